@@ -0,0 +1,289 @@
+// Package archive builds release archives, like mytool_1.2.0_linux_amd64.tar.gz,
+// without shelling out to tar or zip, whose flags and output differ from one
+// machine to the next.  Archives are reproducible: the entries are sorted and
+// have fixed timestamps, owners and permissions, so building the same files
+// twice gives the same bytes.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultDocs are the files included at the top of every archive whose Spec
+// doesn't set Docs.
+var DefaultDocs = []string{"LICENSE*", "README*"}
+
+// File is a file to put in an archive.
+type File struct {
+	// Src is the template for the path of the file on disk.
+	Src string
+
+	// Dst is the template for the slash separated path of the file in the
+	// archive.  It defaults to the name of the file.
+	Dst string
+}
+
+// Spec describes the archives of a release.  Names and paths are templates, in
+// which {version}, {os} and {arch} are replaced with the version, GOOS and
+// GOARCH of the archive, and {ext} with .exe for windows.
+type Spec struct {
+	// Name is the template for the file name of the archives, without the
+	// extension, e.g. "mytool_{version}_{os}_{arch}".
+	Name string
+
+	// Version is the version being released.
+	Version string
+
+	// Format is the kind of archive to make, "tar.gz" or "zip".  It
+	// defaults to "tar.gz".
+	Format string
+
+	// Formats overrides the Format for some OSes, e.g. {"windows": "zip"}.
+	Formats map[string]string
+
+	// Prefix is the template for a directory to put the files in, in the
+	// archive, e.g. "mytool-{version}".  By default they're at the top.
+	Prefix string
+
+	// Files are the files to put in the archive, e.g. the binary.
+	Files []File
+
+	// Docs are glob patterns for files to include at the top of the
+	// archive, if they exist, like the license and readme.  If it's nil,
+	// DefaultDocs are used, so set it to an empty slice to include none.
+	Docs []string
+
+	// ModTime is the time every entry in the archive has.  It defaults to
+	// $SOURCE_DATE_EPOCH, if that's set, or else 2000-01-01.
+	ModTime time.Time
+}
+
+// Create makes the archive for the platform in dir, and returns its path.
+func (s Spec) Create(dir, goos, goarch string) (string, error) {
+	if s.Name == "" {
+		return "", fmt.Errorf("archive needs a name")
+	}
+	format := s.Format
+	if f, ok := s.Formats[goos]; ok {
+		format = f
+	}
+	if format == "" {
+		format = "tar.gz"
+	}
+	if format != "tar.gz" && format != "zip" {
+		return "", fmt.Errorf("unsupported archive format %q, the supported formats are: tar.gz, zip", format)
+	}
+	entries, err := s.entries(goos, goarch)
+	if err != nil {
+		return "", err
+	}
+	modTime, err := s.modTime()
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	out := filepath.Join(dir, s.expand(s.Name, goos, goarch)+"."+format)
+	f, err := os.Create(out)
+	if err != nil {
+		return "", err
+	}
+	if format == "zip" {
+		err = writeZip(f, entries, modTime)
+	} else {
+		err = writeTarGz(f, entries, modTime)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(out)
+		return "", fmt.Errorf("failed to create %s: %v", out, err)
+	}
+	return out, nil
+}
+
+// CreateAll makes the archives for each of the platforms, given as GOOS/GOARCH,
+// in dir, and returns their paths.
+func (s Spec) CreateAll(dir string, platforms ...string) ([]string, error) {
+	var paths []string
+	for _, p := range platforms {
+		parts := strings.Split(p, "/")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid platform %q, expected GOOS/GOARCH", p)
+		}
+		path, err := s.Create(dir, parts[0], parts[1])
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, path)
+	}
+	return paths, nil
+}
+
+// entry is a file on disk and its path in the archive.
+type entry struct {
+	src, dst string
+}
+
+// entries returns the files to put in the archive for the platform, sorted by
+// their path in the archive.
+func (s Spec) entries(goos, goarch string) ([]entry, error) {
+	prefix := s.expand(s.Prefix, goos, goarch)
+	seen := map[string]string{}
+	var entries []entry
+	add := func(src, dst string) error {
+		dst = path.Join(prefix, dst)
+		if other, ok := seen[dst]; ok {
+			return fmt.Errorf("%s and %s would both be %s in the archive", other, src, dst)
+		}
+		seen[dst] = src
+		entries = append(entries, entry{src: src, dst: dst})
+		return nil
+	}
+
+	for _, f := range s.Files {
+		src := s.expand(f.Src, goos, goarch)
+		fi, err := os.Stat(src)
+		if err != nil {
+			return nil, err
+		}
+		if fi.IsDir() {
+			return nil, fmt.Errorf("%s is a directory", src)
+		}
+		dst := s.expand(f.Dst, goos, goarch)
+		if dst == "" {
+			dst = filepath.Base(src)
+		}
+		if err := add(src, dst); err != nil {
+			return nil, err
+		}
+	}
+	docs := s.Docs
+	if docs == nil {
+		docs = DefaultDocs
+	}
+	for _, pattern := range docs {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			if fi, err := os.Stat(m); err != nil || fi.IsDir() {
+				continue
+			}
+			if _, ok := seen[path.Join(prefix, filepath.Base(m))]; ok {
+				continue
+			}
+			if err := add(m, filepath.Base(m)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].dst < entries[j].dst })
+	return entries, nil
+}
+
+// modTime returns the timestamp for the entries of the archive.
+func (s Spec) modTime() (time.Time, error) {
+	if !s.ModTime.IsZero() {
+		return s.ModTime.UTC(), nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		secs, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q", epoch)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	}
+	return time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// expand replaces the placeholders in the template.
+func (s Spec) expand(tmpl, goos, goarch string) string {
+	ext := ""
+	if goos == "windows" {
+		ext = ".exe"
+	}
+	return strings.NewReplacer("{version}", s.Version, "{os}", goos, "{arch}", goarch, "{ext}", ext).Replace(tmpl)
+}
+
+// mode returns the permissions for the file in the archive, which only keep
+// whether it's executable, so they don't depend on the umask of the machine.
+func mode(fi os.FileInfo) os.FileMode {
+	if fi.Mode()&0111 != 0 || strings.HasSuffix(fi.Name(), ".exe") {
+		return 0755
+	}
+	return 0644
+}
+
+func writeTarGz(w io.Writer, entries []entry, modTime time.Time) error {
+	gz := gzip.NewWriter(w)
+	gz.ModTime = modTime
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		f, err := os.Open(e.src)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err == nil {
+			err = tw.WriteHeader(&tar.Header{
+				Name:     e.dst,
+				Mode:     int64(mode(fi)),
+				Size:     fi.Size(),
+				ModTime:  modTime,
+				Typeflag: tar.TypeReg,
+			})
+		}
+		if err == nil {
+			_, err = io.Copy(tw, f)
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+func writeZip(w io.Writer, entries []entry, modTime time.Time) error {
+	zw := zip.NewWriter(w)
+	for _, e := range entries {
+		f, err := os.Open(e.src)
+		if err != nil {
+			return err
+		}
+		fi, err := f.Stat()
+		if err == nil {
+			hdr := &zip.FileHeader{Name: e.dst, Method: zip.Deflate, Modified: modTime}
+			hdr.SetMode(mode(fi))
+			var zf io.Writer
+			zf, err = zw.CreateHeader(hdr)
+			if err == nil {
+				_, err = io.Copy(zf, f)
+			}
+		}
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
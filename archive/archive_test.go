@@ -0,0 +1,257 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// tempdir changes to a new temporary directory with the files, the mytool ones
+// executable, and returns a func that changes back and removes it.
+func tempdir(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range files {
+		mode := os.FileMode(0644)
+		if strings.HasPrefix(filepath.Base(name), "mytool") {
+			mode = 0755
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, []byte(s), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+type item struct {
+	name string
+	mode os.FileMode
+	body string
+}
+
+func readTarGz(t *testing.T, path string) []item {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tr := tar.NewReader(gz)
+	var items []item
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return items
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(tr)
+		items = append(items, item{hdr.Name, os.FileMode(hdr.Mode), string(b)})
+	}
+}
+
+func readZip(t *testing.T, path string) []item {
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var items []item
+	for _, f := range r.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		items = append(items, item{f.Name, f.Mode(), string(b)})
+	}
+	return items
+}
+
+func TestCreateAll(t *testing.T) {
+	defer tempdir(t, map[string]string{
+		"bin/linux_amd64/mytool":       "linux binary",
+		"bin/windows_amd64/mytool.exe": "windows binary",
+		"LICENSE":                      "license",
+		"README.md":                    "readme",
+		"docs/guide.md":                "guide",
+	})()
+	spec := Spec{
+		Name:    "mytool_{version}_{os}_{arch}",
+		Version: "1.2.0",
+		Formats: map[string]string{"windows": "zip"},
+		Prefix:  "mytool-{version}",
+		Files: []File{
+			{Src: "bin/{os}_{arch}/mytool{ext}"},
+			{Src: "docs/guide.md", Dst: "docs/guide.md"},
+		},
+	}
+	paths, err := spec.CreateAll("dist", "linux/amd64", "windows/amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		filepath.Join("dist", "mytool_1.2.0_linux_amd64.tar.gz"),
+		filepath.Join("dist", "mytool_1.2.0_windows_amd64.zip"),
+	}
+	if !reflect.DeepEqual(paths, expected) {
+		t.Fatalf("expected %v but got %v", expected, paths)
+	}
+
+	linux := []item{
+		{"mytool-1.2.0/LICENSE", 0644, "license"},
+		{"mytool-1.2.0/README.md", 0644, "readme"},
+		{"mytool-1.2.0/docs/guide.md", 0644, "guide"},
+		{"mytool-1.2.0/mytool", 0755, "linux binary"},
+	}
+	if items := readTarGz(t, paths[0]); !reflect.DeepEqual(items, linux) {
+		t.Fatalf("expected %v but got %v", linux, items)
+	}
+	windows := []item{
+		{"mytool-1.2.0/LICENSE", 0644, "license"},
+		{"mytool-1.2.0/README.md", 0644, "readme"},
+		{"mytool-1.2.0/docs/guide.md", 0644, "guide"},
+		{"mytool-1.2.0/mytool.exe", 0755, "windows binary"},
+	}
+	if items := readZip(t, paths[1]); !reflect.DeepEqual(items, windows) {
+		t.Fatalf("expected %v but got %v", windows, items)
+	}
+}
+
+func TestCreateReproducible(t *testing.T) {
+	defer tempdir(t, map[string]string{"mytool": "binary", "LICENSE": "license"})()
+	for _, format := range []string{"tar.gz", "zip"} {
+		spec := Spec{Name: "mytool", Format: format, Files: []File{{Src: "mytool"}}}
+		first, err := spec.Create("one", "linux", "amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		// touching and re-permissioning the files doesn't change the archive.
+		later := time.Now().Add(time.Hour)
+		os.Chtimes("mytool", later, later)
+		os.Chtimes("LICENSE", later, later)
+		os.Chmod("LICENSE", 0600)
+		second, err := spec.Create("two", "linux", "amd64")
+		if err != nil {
+			t.Fatal(err)
+		}
+		a, _ := ioutil.ReadFile(first)
+		b, _ := ioutil.ReadFile(second)
+		if !bytes.Equal(a, b) {
+			t.Fatalf("%s: expected the same archive twice", format)
+		}
+		os.Chmod("LICENSE", 0644)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	old, ok := os.LookupEnv("SOURCE_DATE_EPOCH")
+	defer func() {
+		if ok {
+			os.Setenv("SOURCE_DATE_EPOCH", old)
+		} else {
+			os.Unsetenv("SOURCE_DATE_EPOCH")
+		}
+	}()
+	os.Unsetenv("SOURCE_DATE_EPOCH")
+	if m, _ := (Spec{}).modTime(); !m.Equal(time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Errorf("expected the default time but got %v", m)
+	}
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	if m, _ := (Spec{}).modTime(); !m.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("expected the time from SOURCE_DATE_EPOCH but got %v", m)
+	}
+	set := time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)
+	if m, _ := (Spec{ModTime: set}).modTime(); !m.Equal(set) {
+		t.Errorf("expected the time from the spec but got %v", m)
+	}
+	os.Setenv("SOURCE_DATE_EPOCH", "yesterday")
+	if _, err := (Spec{}).modTime(); err == nil || err.Error() != `invalid SOURCE_DATE_EPOCH "yesterday"` {
+		t.Errorf("expected an invalid SOURCE_DATE_EPOCH error but got %v", err)
+	}
+}
+
+func TestCreateNoDocs(t *testing.T) {
+	defer tempdir(t, map[string]string{"mytool": "binary", "LICENSE": "license"})()
+	spec := Spec{Name: "mytool", Files: []File{{Src: "mytool"}}, Docs: []string{}}
+	path, err := spec.Create("dist", "linux", "amd64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []item{{"mytool", 0755, "binary"}}
+	if runtime.GOOS == "windows" {
+		expected[0].mode = 0644
+	}
+	if items := readTarGz(t, path); !reflect.DeepEqual(items, expected) {
+		t.Fatalf("expected %v but got %v", expected, items)
+	}
+}
+
+func TestCreateErrors(t *testing.T) {
+	defer tempdir(t, map[string]string{"a/mytool": "a", "b/mytool": "b"})()
+	tests := []struct {
+		desc     string
+		spec     Spec
+		expected string
+	}{
+		{
+			desc:     "format",
+			spec:     Spec{Name: "x", Format: "rar"},
+			expected: `unsupported archive format "rar", the supported formats are: tar.gz, zip`,
+		},
+		{
+			desc:     "duplicate",
+			spec:     Spec{Name: "x", Files: []File{{Src: "a/mytool"}, {Src: "b/mytool"}}},
+			expected: "a/mytool and b/mytool would both be mytool in the archive",
+		},
+		{
+			desc:     "directory",
+			spec:     Spec{Name: "x", Files: []File{{Src: "a"}}},
+			expected: "a is a directory",
+		},
+		{
+			desc:     "name",
+			spec:     Spec{},
+			expected: "archive needs a name",
+		},
+	}
+	for _, tt := range tests {
+		_, err := tt.spec.Create("dist", "linux", "amd64")
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q but got %v", tt.desc, tt.expected, err)
+		}
+	}
+	if _, err := (Spec{Name: "x"}).CreateAll("dist", "linux"); err == nil || err.Error() != `invalid platform "linux", expected GOOS/GOARCH` {
+		t.Errorf("expected an invalid platform error but got %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Backend is a remote cache, shared between machines.
+type Backend interface {
+	// Get returns the entry with the name, or nil if there isn't one.
+	Get(name string) (io.ReadCloser, error)
+
+	// Put stores the entry with the name.
+	Put(name string, r io.Reader) error
+}
+
+// DirBackend is a remote cache in a directory, e.g. on a network drive or
+// one CI saves and restores between builds.
+type DirBackend string
+
+// Get returns the entry from the directory, or nil if it isn't there.
+func (d DirBackend) Get(name string) (io.ReadCloser, error) {
+	f, err := os.Open(filepath.Join(string(d), name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Put stores the entry in the directory.
+func (d DirBackend) Put(name string, r io.Reader) error {
+	return writeAtomic(filepath.Join(string(d), name), r)
+}
+
+// HTTPBackend is a remote cache on an HTTP server, where entries are
+// downloaded with GET and uploaded with PUT, like a WebDAV server or a
+// bucket that allows it.
+type HTTPBackend struct {
+	// URL is the URL the entries' names are added to.
+	URL string
+
+	// Header is added to every request, e.g. an Authorization header.
+	Header http.Header
+
+	// Client makes the requests.  It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Get downloads the entry, or returns nil if the server responds 404 Not
+// Found.
+func (h HTTPBackend) Get(name string) (io.ReadCloser, error) {
+	resp, err := h.do("GET", name, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return resp.Body, nil
+	case http.StatusNotFound:
+		resp.Body.Close()
+		return nil, nil
+	default:
+		resp.Body.Close()
+		return nil, fmt.Errorf("GET %s: %s", h.url(name), resp.Status)
+	}
+}
+
+// Put uploads the entry.
+func (h HTTPBackend) Put(name string, r io.Reader) error {
+	resp, err := h.do("PUT", name, r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("PUT %s: %s", h.url(name), resp.Status)
+	}
+	return nil
+}
+
+func (h HTTPBackend) do(method, name string, body io.Reader) (*http.Response, error) {
+	req, err := http.NewRequest(method, h.url(name), body)
+	if err != nil {
+		return nil, err
+	}
+	// some servers, like S3, need the length of what's uploaded.
+	if f, ok := body.(*os.File); ok {
+		if info, err := f.Stat(); err == nil {
+			req.ContentLength = info.Size()
+		}
+	}
+	for k, v := range h.Header {
+		req.Header[k] = v
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return client.Do(req)
+}
+
+func (h HTTPBackend) url(name string) string {
+	return strings.TrimSuffix(h.URL, "/") + "/" + name
+}
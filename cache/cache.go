@@ -0,0 +1,163 @@
+// Package cache skips expensive steps, like generating code or building
+// images, when their inputs are the same as a previous run's, and restores the
+// outputs that run made instead, the way Bazel does.  The outputs are stored
+// in mage's cache directory, and can be shared through a remote Backend, e.g.
+// with CI.  Whole directories, like the go module cache, can also be kept in
+// a CI service's own cache between builds, with RestoreDirs and SaveDirs.
+package cache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Cache stores the outputs of steps.
+type Cache struct {
+	// Dir is the directory the outputs are stored in.  It defaults to
+	// outputs in mage's cache directory.
+	Dir string
+
+	// Remote is where outputs are shared from, if they aren't in Dir, and
+	// to, when a step runs.
+	Remote Backend
+}
+
+// Default is the cache Do uses.
+var Default = &Cache{}
+
+// Do runs fn, unless it ran successfully before with the same key and
+// outputs, in which case the outputs it made then are restored instead.  The outputs are files
+// and directories in the working directory.
+//
+//	func Protos() error {
+//		key := cache.Key{Name: "protos", Files: []string{"api/*.proto"}, Tools: []string{"protoc --version"}}
+//		return cache.Do(key, []string{"api/gen"}, func() error {
+//			return sh.Run("protoc", "--go_out=api/gen", "api/service.proto")
+//		})
+//	}
+func Do(key Key, outputs []string, fn func() error) error {
+	return Default.Do(key, outputs, fn)
+}
+
+// Do is like the Do func, but uses this cache.
+func (c *Cache) Do(key Key, outputs []string, fn func() error) error {
+	for _, out := range outputs {
+		if filepath.IsAbs(out) || out == ".." || strings.HasPrefix(filepath.ToSlash(filepath.Clean(out)), "../") {
+			return fmt.Errorf("cache output %s isn't in the working directory", out)
+		}
+	}
+	hash, err := key.hash(outputs)
+	if err != nil {
+		return fmt.Errorf("can't make the cache key for %s: %v", key.Name, err)
+	}
+	name := hash + ".tar.gz"
+	path := filepath.Join(c.dir(), name)
+
+	hit, err := c.fetch(name, path)
+	if err != nil {
+		log.Printf("can't get %s from the remote cache: %v", key.Name, err)
+	}
+	if hit {
+		if err := restore(path, outputs); err != nil {
+			return fmt.Errorf("can't restore the cached outputs of %s: %v", key.Name, err)
+		}
+		log.Printf("restored the outputs of %s from the cache", key.Name)
+		return nil
+	}
+
+	if err := fn(); err != nil {
+		return err
+	}
+	if err := c.store(name, path, outputs); err != nil {
+		return fmt.Errorf("can't cache the outputs of %s: %v", key.Name, err)
+	}
+	return nil
+}
+
+func (c *Cache) dir() string {
+	if c.Dir != "" {
+		return c.Dir
+	}
+	return filepath.Join(mg.CacheDir(), "outputs")
+}
+
+// fetch reports whether the entry is in the cache, getting it from the
+// remote one if it's only there.
+func (c *Cache) fetch(name, path string) (bool, error) {
+	if _, err := os.Stat(path); err == nil {
+		return true, nil
+	}
+	if c.Remote == nil {
+		return false, nil
+	}
+	rc, err := c.Remote.Get(name)
+	if err != nil || rc == nil {
+		return false, err
+	}
+	defer rc.Close()
+	if err := writeAtomic(path, rc); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// store packs the outputs into the cache, and the remote one.  Failing to
+// share them isn't an error, since the step itself worked.
+func (c *Cache) store(name, path string, outputs []string) error {
+	tmp, err := ioutil.TempFile("", "mage-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := pack(tmp, outputs); err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := writeAtomic(path, tmp); err != nil {
+		return err
+	}
+	if c.Remote != nil {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := c.Remote.Put(name, f); err != nil {
+			log.Printf("can't put %s in the remote cache: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// writeAtomic writes what r reads to the file, through a temporary file, so
+// other runs never see it half written.
+func writeAtomic(path string, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
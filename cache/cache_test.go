@@ -0,0 +1,264 @@
+package cache
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// project changes to a new directory with api/service.proto, and returns a
+// cache in it and a func that changes back and removes it.
+func project(t *testing.T) (*Cache, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("api", 0755)
+	ioutil.WriteFile("api/service.proto", []byte("service A {}"), 0644)
+	return &Cache{Dir: filepath.Join(dir, "cache")}, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// generate is a step that writes gen/service.go and gen/sub/run.sh from the
+// proto, and counts how many times it runs.
+func generate(runs *int) func() error {
+	return func() error {
+		*runs++
+		b, err := ioutil.ReadFile("api/service.proto")
+		if err != nil {
+			return err
+		}
+		os.MkdirAll("gen/sub", 0755)
+		ioutil.WriteFile("gen/service.go", b, 0644)
+		return ioutil.WriteFile("gen/sub/run.sh", []byte("#!/bin/sh\n"), 0755)
+	}
+}
+
+func TestDo(t *testing.T) {
+	c, cleanup := project(t)
+	defer cleanup()
+	key := Key{Name: "protos", Files: []string{"api/*.proto"}, Env: []string{"CACHE_TEST_VAR"}, Values: []string{"v1"}}
+	outputs := []string{"gen"}
+	runs := 0
+
+	if err := c.Do(key, outputs, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll("gen")
+	if err := c.Do(key, outputs, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 {
+		t.Fatalf("expected the step to run once but it ran %d times", runs)
+	}
+	if b, err := ioutil.ReadFile("gen/service.go"); err != nil || string(b) != "service A {}" {
+		t.Fatalf("expected gen/service.go to be restored but got %q, %v", b, err)
+	}
+	if info, err := os.Stat("gen/sub/run.sh"); err != nil || runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Fatalf("expected gen/sub/run.sh to be restored with its permissions but got %v, %v", info, err)
+	}
+
+	// a stale output is replaced, not merged with.
+	ioutil.WriteFile("gen/stale.go", nil, 0644)
+	if err := c.Do(key, outputs, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat("gen/stale.go"); !os.IsNotExist(err) {
+		t.Fatalf("expected gen/stale.go to be removed but got %v", err)
+	}
+
+	changes := []func(){
+		func() { ioutil.WriteFile("api/service.proto", []byte("service B {}"), 0644) },
+		func() { ioutil.WriteFile("api/other.proto", nil, 0644) },
+		func() { os.Setenv("CACHE_TEST_VAR", "x") },
+		func() { key.Values = []string{"v2"} },
+		func() { outputs = []string{"gen", "api"} },
+		func() { key.Name = "other" },
+	}
+	defer os.Unsetenv("CACHE_TEST_VAR")
+	for i, change := range changes {
+		change()
+		if err := c.Do(key, outputs, generate(&runs)); err != nil {
+			t.Fatal(err)
+		}
+		if runs != i+2 {
+			t.Fatalf("%d: expected the step to run again after the change but it ran %d times", i, runs)
+		}
+	}
+	if b, _ := ioutil.ReadFile("gen/service.go"); string(b) != "service B {}" {
+		t.Fatalf("expected the new output but got %q", b)
+	}
+}
+
+func TestDoErrors(t *testing.T) {
+	c, cleanup := project(t)
+	defer cleanup()
+	key := Key{Name: "protos", Files: []string{"api/*.proto"}}
+
+	runs := 0
+	failing := func() error {
+		runs++
+		return errors.New("protoc failed")
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.Do(key, []string{"gen"}, failing); err == nil || err.Error() != "protoc failed" {
+			t.Fatalf("expected the step's error but got %v", err)
+		}
+	}
+	if runs != 2 {
+		t.Fatalf("expected a failed step not to be cached but it ran %d times", runs)
+	}
+
+	err := c.Do(key, []string{"gen"}, func() error { return nil })
+	if err == nil || !strings.HasPrefix(err.Error(), "can't cache the outputs of protos: output gen wasn't made: ") {
+		t.Fatalf("expected an error for the missing output but got %v", err)
+	}
+	err = c.Do(Key{Name: "x", Files: []string{"*.missing"}}, nil, failing)
+	if err == nil || err.Error() != "can't make the cache key for x: glob didn't match any files: *.missing" {
+		t.Fatalf("expected an error for the glob but got %v", err)
+	}
+	err = c.Do(key, []string{"../gen"}, failing)
+	if err == nil || err.Error() != "cache output ../gen isn't in the working directory" {
+		t.Fatalf("expected an error for the output but got %v", err)
+	}
+	if err := c.Do(Key{}, nil, failing); err == nil || err.Error() != "can't make the cache key for : the cache key needs a name" {
+		t.Fatalf("expected an error for the name but got %v", err)
+	}
+}
+
+func TestKeyTools(t *testing.T) {
+	k := Key{Name: "tools", Tools: []string{"go version"}}
+	h1, err := k.Hash()
+	if err != nil {
+		t.Fatal(err)
+	}
+	h2, _ := Key{Name: "tools"}.Hash()
+	if h1 == h2 || len(h1) != 64 {
+		t.Fatalf("expected the tool's output to change the hash but got %s and %s", h1, h2)
+	}
+	k.Tools = []string{"no-such-tool --version"}
+	if _, err := k.Hash(); err == nil || !strings.HasPrefix(err.Error(), "can't get the version of no-such-tool: ") {
+		t.Fatalf("expected an error for the tool but got %v", err)
+	}
+}
+
+func TestKeyEnvOrder(t *testing.T) {
+	os.Setenv("CACHE_TEST_A", "a")
+	os.Setenv("CACHE_TEST_B", "b")
+	defer os.Unsetenv("CACHE_TEST_A")
+	defer os.Unsetenv("CACHE_TEST_B")
+	h1, _ := Key{Name: "env", Env: []string{"CACHE_TEST_A", "CACHE_TEST_B"}}.Hash()
+	h2, _ := Key{Name: "env", Env: []string{"CACHE_TEST_B", "CACHE_TEST_A"}}.Hash()
+	if h1 != h2 {
+		t.Fatalf("expected the order of the variables not to change the hash but got %s and %s", h1, h2)
+	}
+}
+
+func TestRemote(t *testing.T) {
+	remote, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(remote)
+	c, cleanup := project(t)
+	defer cleanup()
+	c.Remote = DirBackend(remote)
+	key := Key{Name: "protos", Files: []string{"api"}}
+
+	runs := 0
+	if err := c.Do(key, []string{"gen"}, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(remote); len(files) != 1 || !strings.HasSuffix(files[0].Name(), ".tar.gz") {
+		t.Fatalf("expected the outputs in the remote cache but found %v", files)
+	}
+
+	// another machine, with an empty local cache.
+	os.RemoveAll(c.Dir)
+	os.RemoveAll("gen")
+	if err := c.Do(key, []string{"gen"}, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 {
+		t.Fatalf("expected the outputs to come from the remote cache but the step ran %d times", runs)
+	}
+	if _, err := os.Stat("gen/service.go"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHTTPBackend(t *testing.T) {
+	var mu sync.Mutex
+	entries := map[string][]byte{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case "PUT":
+			if r.ContentLength < 0 {
+				w.WriteHeader(http.StatusLengthRequired)
+				return
+			}
+			b, _ := ioutil.ReadAll(r.Body)
+			entries[r.URL.Path] = b
+		case "GET":
+			b, ok := entries[r.URL.Path]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			w.Write(b)
+		}
+	}))
+	defer srv.Close()
+
+	c, cleanup := project(t)
+	defer cleanup()
+	c.Remote = HTTPBackend{URL: srv.URL + "/mage/", Header: http.Header{"Authorization": {"Bearer token"}}}
+	key := Key{Name: "protos", Files: []string{"api"}}
+	runs := 0
+	if err := c.Do(key, []string{"gen"}, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := key.hash([]string{"gen"})
+	if _, ok := entries["/mage/"+hash+".tar.gz"]; !ok {
+		t.Fatalf("expected the outputs to be uploaded but found %v", entries)
+	}
+	os.RemoveAll(c.Dir)
+	if err := c.Do(key, []string{"gen"}, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 1 {
+		t.Fatalf("expected the outputs to be downloaded but the step ran %d times", runs)
+	}
+
+	// a remote cache that fails doesn't fail the step.
+	os.RemoveAll(c.Dir)
+	c.Remote = HTTPBackend{URL: srv.URL}
+	if err := c.Do(key, []string{"gen"}, generate(&runs)); err != nil {
+		t.Fatal(err)
+	}
+	if runs != 2 {
+		t.Fatalf("expected the step to run but it ran %d times", runs)
+	}
+}
@@ -0,0 +1,145 @@
+package cache
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+// CIBackend is a CI service's cache, which keeps directories between builds,
+// like the go module cache, so each build doesn't start from nothing.  Its
+// entries can't be changed once they're saved, so a change is saved with a new
+// key.
+type CIBackend interface {
+	// Restore returns the entry with the key, or if there isn't one, the
+	// newest one whose key starts with one of the restore keys, tried in
+	// order, and the key of the entry it returns.  It returns a nil reader
+	// if there's none.
+	Restore(key string, restoreKeys []string) (io.ReadCloser, string, error)
+
+	// Save stores the entry with the key, which is size bytes read from r.
+	// It's not an error if there's already an entry with the key.
+	Save(key string, r io.Reader, size int64) error
+}
+
+// CI is the CI cache RestoreDirs and SaveDirs use.  If it's nil, they use
+// GitHub Actions' cache when it's running in GitHub Actions, and otherwise do
+// nothing, so the same targets work locally.
+var CI CIBackend
+
+// ciBackend returns the CI cache to use, or nil if there isn't one.
+func ciBackend() CIBackend {
+	if CI != nil {
+		return CI
+	}
+	if gh := (GitHubActions{}); gh.url() != "" && gh.token() != "" {
+		return gh
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		log.Printf("the GitHub Actions cache needs ACTIONS_RESULTS_URL and ACTIONS_RUNTIME_TOKEN, which the workflow has to pass on to mage")
+	}
+	return nil
+}
+
+// restored are the keys RestoreDirs found an exact match for, which SaveDirs
+// doesn't save again, since the entries can't change.
+var (
+	restoredMu sync.Mutex
+	restored   = map[string]bool{}
+)
+
+// RestoreDirs restores the directories from the CI cache entry with the key,
+// or if there isn't one, the newest one with one of the restore keys as a
+// prefix, and returns the key of the entry it restored, or "" if it didn't
+// find one, or the CI cache can't be reached.  The files in the entry are
+// added to the directories, keeping any that are already there.  Use it with
+// SaveDirs, at the start and end of a target, with a key from Key.ID:
+//
+//	func Deps() error {
+//		modcache, err := sh.Output(mg.GoCmd(), "env", "GOMODCACHE")
+//		if err != nil {
+//			return err
+//		}
+//		key := cache.GoModulesKey()
+//		id, err := key.ID()
+//		if err != nil {
+//			return err
+//		}
+//		if _, err := cache.RestoreDirs(id, []string{key.Prefix()}, modcache); err != nil {
+//			return err
+//		}
+//		if err := sh.Run(mg.GoCmd(), "mod", "download"); err != nil {
+//			return err
+//		}
+//		return cache.SaveDirs(id, modcache)
+//	}
+func RestoreDirs(key string, restoreKeys []string, dirs ...string) (string, error) {
+	ci := ciBackend()
+	if ci == nil {
+		return "", nil
+	}
+	rc, matched, err := ci.Restore(key, restoreKeys)
+	if err != nil {
+		// the build can go on without it, it'll just be slower.
+		log.Printf("can't restore %s from the CI cache: %v", key, err)
+		return "", nil
+	}
+	if rc == nil {
+		log.Printf("%s isn't in the CI cache", key)
+		return "", nil
+	}
+	defer rc.Close()
+	if err := unpackDirs(rc, dirs); err != nil {
+		return "", fmt.Errorf("can't restore %s from the CI cache: %v", matched, err)
+	}
+	log.Printf("restored %s from the CI cache", matched)
+	if matched == key {
+		restoredMu.Lock()
+		restored[key] = true
+		restoredMu.Unlock()
+	}
+	return matched, nil
+}
+
+// SaveDirs saves the directories to the CI cache with the key, unless
+// RestoreDirs restored them from an entry with the same key, which can't be
+// changed.  Directories that don't exist are left out.  Failing to upload
+// them isn't an error, since the build itself worked.
+func SaveDirs(key string, dirs ...string) error {
+	ci := ciBackend()
+	if ci == nil {
+		return nil
+	}
+	restoredMu.Lock()
+	skip := restored[key]
+	restoredMu.Unlock()
+	if skip {
+		log.Printf("%s was restored from the CI cache, so it's not saved again", key)
+		return nil
+	}
+	tmp, err := ioutil.TempFile("", "mage-ci-cache")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	if err := packDirs(tmp, dirs); err != nil {
+		return fmt.Errorf("can't save %s to the CI cache: %v", key, err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := ci.Save(key, tmp, size); err != nil {
+		log.Printf("can't save %s to the CI cache: %v", key, err)
+		return nil
+	}
+	log.Printf("saved %s to the CI cache", key)
+	return nil
+}
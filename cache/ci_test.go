@@ -0,0 +1,251 @@
+package cache
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestKeyID(t *testing.T) {
+	_, cleanup := project(t)
+	defer cleanup()
+	k := Key{Name: "protos", Files: []string{"api/*.proto"}}
+	id, err := k.ID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, _ := k.Hash()
+	prefix := "protos-" + runtime.GOOS + "-" + runtime.GOARCH + "-"
+	if k.Prefix() != prefix || id != prefix+hash {
+		t.Fatalf("unexpected id %s", id)
+	}
+
+	// optional files don't have to be there, but change the key when they
+	// are.
+	k.Optional = []string{"go.sum"}
+	if again, err := k.ID(); err != nil || again != id {
+		t.Fatalf("expected the same id without go.sum, got %s, %v", again, err)
+	}
+	ioutil.WriteFile("go.sum", []byte("example.com/a v1.0.0 h1:abc=\n"), 0644)
+	if again, err := k.ID(); err != nil || again == id {
+		t.Fatalf("expected a new id with go.sum, got %s, %v", again, err)
+	}
+	if _, err := GoModulesKey().ID(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// memoryCI is a CIBackend that keeps its entries in memory.
+type memoryCI struct {
+	mu      sync.Mutex
+	entries map[string][]byte
+	keys    []string // in the order they were saved
+}
+
+func (m *memoryCI) Restore(key string, restoreKeys []string) (io.ReadCloser, string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if b, ok := m.entries[key]; ok {
+		return ioutil.NopCloser(bytes.NewReader(b)), key, nil
+	}
+	for _, prefix := range restoreKeys {
+		for i := len(m.keys) - 1; i >= 0; i-- {
+			if strings.HasPrefix(m.keys[i], prefix) {
+				return ioutil.NopCloser(bytes.NewReader(m.entries[m.keys[i]])), m.keys[i], nil
+			}
+		}
+	}
+	return nil, "", nil
+}
+
+func (m *memoryCI) Save(key string, r io.Reader, size int64) error {
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(b)) != size {
+		return io.ErrShortWrite
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.entries[key]; !ok {
+		m.entries[key] = b
+		m.keys = append(m.keys, key)
+	}
+	return nil
+}
+
+// useCI makes CI the backend, and returns a func that puts it back.
+func useCI(ci CIBackend) func() {
+	old := CI
+	CI = ci
+	return func() {
+		CI = old
+		restoredMu.Lock()
+		restored = map[string]bool{}
+		restoredMu.Unlock()
+	}
+}
+
+func TestDirs(t *testing.T) {
+	_, cleanup := project(t)
+	defer cleanup()
+	ci := &memoryCI{entries: map[string][]byte{}}
+	defer useCI(ci)()
+	wd, _ := os.Getwd()
+	modcache := filepath.Join(wd, "home", "go", "pkg", "mod")
+	os.MkdirAll(filepath.Join(modcache, "example.com", "a@v1.0.0"), 0755)
+	ioutil.WriteFile(filepath.Join(modcache, "example.com", "a@v1.0.0", "a.go"), []byte("package a"), 0444)
+	os.MkdirAll("node_modules/.bin", 0755)
+	ioutil.WriteFile("node_modules/tool.js", []byte("tool"), 0755)
+	if runtime.GOOS != "windows" {
+		os.Symlink("../tool.js", "node_modules/.bin/tool")
+	}
+
+	if err := SaveDirs("deps-1", modcache, "node_modules", "missing"); err != nil {
+		t.Fatal(err)
+	}
+	os.RemoveAll("home")
+	os.RemoveAll("node_modules")
+	os.MkdirAll("node_modules", 0755)
+	ioutil.WriteFile("node_modules/tool.js", []byte("newer"), 0755)
+
+	matched, err := RestoreDirs("deps-2", []string{"deps-"}, modcache, "node_modules", "missing")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if matched != "deps-1" {
+		t.Fatalf("expected the entry with the restore key, got %q", matched)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(modcache, "example.com", "a@v1.0.0", "a.go")); string(b) != "package a" {
+		t.Fatalf("unexpected %q", b)
+	}
+	if b, _ := ioutil.ReadFile("node_modules/tool.js"); string(b) != "newer" {
+		t.Fatalf("expected the file that was there to be kept, got %q", b)
+	}
+	if runtime.GOOS != "windows" {
+		if link, err := os.Readlink("node_modules/.bin/tool"); err != nil || link != "../tool.js" {
+			t.Fatalf("unexpected link %q, %v", link, err)
+		}
+	}
+
+	// an exact match isn't saved again, but a new key is.
+	if _, err := RestoreDirs("deps-1", nil, modcache, "node_modules"); err != nil {
+		t.Fatal(err)
+	}
+	SaveDirs("deps-1", modcache, "node_modules")
+	SaveDirs("deps-2", modcache, "node_modules")
+	if len(ci.keys) != 2 {
+		t.Fatalf("unexpected entries %v", ci.keys)
+	}
+	if matched, err := RestoreDirs("other", nil, modcache); err != nil || matched != "" {
+		t.Fatalf("expected a miss, got %q, %v", matched, err)
+	}
+}
+
+func TestGitHubActions(t *testing.T) {
+	_, cleanup := project(t)
+	defer cleanup()
+	var mu sync.Mutex
+	blobs := map[string][]byte{}
+	finalized := map[string]string{}
+	var srv *httptest.Server
+	srv = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/blob/") {
+			key := strings.TrimPrefix(r.URL.Path, "/blob/")
+			switch r.Method {
+			case "PUT":
+				if r.Header.Get("x-ms-blob-type") != "BlockBlob" || r.ContentLength <= 0 {
+					http.Error(w, "bad upload", http.StatusBadRequest)
+					return
+				}
+				blobs[key], _ = ioutil.ReadAll(r.Body)
+				w.WriteHeader(http.StatusCreated)
+			case "GET":
+				w.Write(blobs[key])
+			}
+			return
+		}
+		if r.Header.Get("Authorization") != "Bearer token" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		var req struct {
+			Key         string   `json:"key"`
+			RestoreKeys []string `json:"restore_keys"`
+			Version     string   `json:"version"`
+			Size        string   `json:"size_bytes"`
+		}
+		json.NewDecoder(r.Body).Decode(&req)
+		resp := map[string]interface{}{"ok": true}
+		switch strings.TrimPrefix(r.URL.Path, "/twirp/github.actions.results.api.v1.CacheService/") {
+		case "CreateCacheEntry":
+			resp["signed_upload_url"] = srv.URL + "/blob/" + req.Key + "?sig=secret"
+		case "FinalizeCacheEntryUpload":
+			finalized[req.Key] = req.Size
+		case "GetCacheEntryDownloadURL":
+			for _, k := range append([]string{req.Key}, req.RestoreKeys...) {
+				for saved := range finalized {
+					if strings.HasPrefix(saved, k) {
+						resp["signedDownloadUrl"] = srv.URL + "/blob/" + saved + "?sig=secret"
+						resp["matchedKey"] = saved
+					}
+				}
+				if resp["matchedKey"] != nil {
+					break
+				}
+			}
+			if resp["matchedKey"] == nil {
+				resp["ok"] = false
+			}
+		default:
+			http.NotFound(w, r)
+			return
+		}
+		json.NewEncoder(w).Encode(resp)
+	}))
+	defer srv.Close()
+	defer useCI(nil)()
+	url, token := os.Getenv("ACTIONS_RESULTS_URL"), os.Getenv("ACTIONS_RUNTIME_TOKEN")
+	defer func() {
+		os.Setenv("ACTIONS_RESULTS_URL", url)
+		os.Setenv("ACTIONS_RUNTIME_TOKEN", token)
+	}()
+	os.Setenv("ACTIONS_RESULTS_URL", srv.URL+"/")
+	os.Setenv("ACTIONS_RUNTIME_TOKEN", "token")
+
+	if err := SaveDirs("tools-abc", "api"); err != nil {
+		t.Fatal(err)
+	}
+	if len(finalized) != 1 || finalized["tools-abc"] != strconv.Itoa(len(blobs["tools-abc"])) {
+		t.Fatalf("unexpected entries %v", finalized)
+	}
+	os.RemoveAll("api")
+	if matched, err := RestoreDirs("tools-def", []string{"tools-"}, "api"); err != nil || matched != "tools-abc" {
+		t.Fatalf("unexpected %q, %v", matched, err)
+	}
+	if b, _ := ioutil.ReadFile("api/service.proto"); string(b) != "service A {}" {
+		t.Fatalf("unexpected %q", b)
+	}
+	if matched, err := RestoreDirs("other", nil, "api"); err != nil || matched != "" {
+		t.Fatalf("expected a miss, got %q, %v", matched, err)
+	}
+
+	// without the cache service, they do nothing.
+	os.Setenv("ACTIONS_RUNTIME_TOKEN", "")
+	if ciBackend() != nil {
+		t.Fatal("expected no CI cache without a token")
+	}
+}
@@ -0,0 +1,165 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/magefile/mage/httpx"
+)
+
+// ghVersion is the version of the GitHub Actions cache entries, which the
+// cache service keeps apart from entries with the same key made by other
+// tools, like actions/cache, that are archived differently.
+var ghVersion = func() string {
+	sum := sha256.Sum256([]byte("mage|dirs|tar.gz|1"))
+	return hex.EncodeToString(sum[:])
+}()
+
+// GitHubActions is the GitHub Actions cache.  GitHub only gives the URL and
+// token it needs to actions, not to run steps, so the workflow has to pass
+// ACTIONS_RESULTS_URL and ACTIONS_RUNTIME_TOKEN on to mage, e.g. with the
+// crazy-max/ghaction-github-runtime action.  Entries are scoped to the branch
+// they're saved on, and can be restored on branches made from it.
+type GitHubActions struct {
+	// URL is the URL of the cache service.  It defaults to
+	// ACTIONS_RESULTS_URL.
+	URL string
+
+	// Token is the token for the cache service.  It defaults to
+	// ACTIONS_RUNTIME_TOKEN.
+	Token string
+
+	// Client transfers the entries, to and from the storage the service
+	// gives URLs for.  It defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+func (g GitHubActions) url() string {
+	if g.URL != "" {
+		return g.URL
+	}
+	return os.Getenv("ACTIONS_RESULTS_URL")
+}
+
+func (g GitHubActions) token() string {
+	if g.Token != "" {
+		return g.Token
+	}
+	return os.Getenv("ACTIONS_RUNTIME_TOKEN")
+}
+
+// service returns a client for the cache service's twirp API.
+func (g GitHubActions) service() *httpx.Client {
+	return &httpx.Client{
+		BaseURL: strings.TrimSuffix(g.url(), "/") + "/twirp/github.actions.results.api.v1.CacheService",
+		Token:   g.token(),
+	}
+}
+
+// ghResponse is what the cache service responds with.  Its fields are named
+// like its protobufs, in either case, depending on how it's encoding them.
+type ghResponse struct {
+	OK                     bool   `json:"ok"`
+	SignedDownloadURL      string `json:"signed_download_url"`
+	SignedDownloadURLCamel string `json:"signedDownloadUrl"`
+	SignedUploadURL        string `json:"signed_upload_url"`
+	SignedUploadURLCamel   string `json:"signedUploadUrl"`
+	MatchedKey             string `json:"matched_key"`
+	MatchedKeyCamel        string `json:"matchedKey"`
+}
+
+func either(s, t string) string {
+	if s != "" {
+		return s
+	}
+	return t
+}
+
+// Restore gets the URL of the entry from the cache service, and downloads it.
+func (g GitHubActions) Restore(key string, restoreKeys []string) (io.ReadCloser, string, error) {
+	var resp ghResponse
+	req := map[string]interface{}{"key": key, "restore_keys": restoreKeys, "version": ghVersion}
+	if err := g.service().Post("/GetCacheEntryDownloadURL", req, &resp); err != nil {
+		return nil, "", err
+	}
+	signed := either(resp.SignedDownloadURL, resp.SignedDownloadURLCamel)
+	if !resp.OK || signed == "" {
+		return nil, "", nil
+	}
+	r, err := g.transfer("GET", signed, nil, 0)
+	if err != nil {
+		return nil, "", err
+	}
+	return r.Body, either(resp.MatchedKey, resp.MatchedKeyCamel), nil
+}
+
+// Save creates the entry in the cache service, uploads it to the URL the
+// service gives, and tells the service it's done.
+func (g GitHubActions) Save(key string, r io.Reader, size int64) error {
+	var resp ghResponse
+	err := g.service().Post("/CreateCacheEntry", map[string]string{"key": key, "version": ghVersion}, &resp)
+	if e, ok := err.(*httpx.StatusError); ok && e.StatusCode == http.StatusConflict {
+		// another job saved it first.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	signed := either(resp.SignedUploadURL, resp.SignedUploadURLCamel)
+	if !resp.OK || signed == "" {
+		// the service says no when the entry's already there.
+		return nil
+	}
+	up, err := g.transfer("PUT", signed, r, size)
+	if err != nil {
+		return err
+	}
+	up.Body.Close()
+	req := map[string]string{"key": key, "version": ghVersion, "size_bytes": fmt.Sprint(size)}
+	if err := g.service().Post("/FinalizeCacheEntryUpload", req, &resp); err != nil {
+		return err
+	}
+	if !resp.OK {
+		return fmt.Errorf("the cache service didn't accept %s", key)
+	}
+	return nil
+}
+
+// transfer sends a request to the storage the cache service gives a URL for.
+// The URL is signed, so it's left out of errors, which end up in the logs.
+func (g GitHubActions) transfer(method, signed string, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequest(method, signed, body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL for the cache entry")
+	}
+	if body != nil {
+		req.ContentLength = size
+		// the storage is Azure Blob Storage, which needs to know what kind of
+		// blob it is.
+		req.Header.Set("x-ms-blob-type", "BlockBlob")
+	}
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if e, ok := err.(*url.Error); ok {
+			err = e.Err
+		}
+		return nil, fmt.Errorf("%s of the cache entry failed: %v", method, err)
+	}
+	if resp.StatusCode/100 != 2 {
+		b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+		resp.Body.Close()
+		return nil, fmt.Errorf("%s of the cache entry failed: %s: %s", method, resp.Status, strings.TrimSpace(string(b)))
+	}
+	return resp, nil
+}
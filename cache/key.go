@@ -0,0 +1,189 @@
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/tools"
+)
+
+// Key is what the outputs of a step depend on.  If all of it is the same as
+// for a previous run, the outputs will be too.  The OS and architecture mage
+// runs on are always part of it.
+type Key struct {
+	// Name is the name of the step, e.g. protobufs, so different steps
+	// with the same inputs don't share outputs.
+	Name string
+
+	// Files are the files the step reads, as globs like api/*.proto.  Each
+	// must match something.  Their contents are part of the key, not their
+	// modtimes, and directories' files are included.
+	Files []string
+
+	// Optional are globs like Files, but they don't have to match anything,
+	// e.g. Dockerfile*, for keys that suit any project.
+	Optional []string
+
+	// Env are the names of the environment variables the step depends on.
+	Env []string
+
+	// Tools are the commands that print the versions of the tools the step
+	// runs, e.g. "protoc --version", so a new version runs the step again.
+	Tools []string
+
+	// Values are anything else the step depends on, e.g. the version being
+	// built.
+	Values []string
+}
+
+// Hash returns the hash of everything in the key.
+func (k Key) Hash() (string, error) {
+	return k.hash(nil)
+}
+
+// hash returns the hash of everything in the key, and the paths of the
+// outputs of the step, so a step whose outputs change doesn't restore the
+// ones an earlier version of it made.
+func (k Key) hash(outputs []string) (string, error) {
+	if k.Name == "" {
+		return "", errors.New("the cache key needs a name")
+	}
+	h := sha256.New()
+	fmt.Fprintf(h, "name %q\nplatform %s/%s\n", k.Name, runtime.GOOS, runtime.GOARCH)
+	files, err := k.files()
+	if err != nil {
+		return "", err
+	}
+	for _, f := range files {
+		if err := hashFile(h, f); err != nil {
+			return "", err
+		}
+	}
+	// the order the variables are listed in doesn't change what the step
+	// does.
+	env := append([]string{}, k.Env...)
+	sort.Strings(env)
+	for _, v := range env {
+		fmt.Fprintf(h, "env %s=%q\n", v, os.Getenv(v))
+	}
+	for _, t := range k.Tools {
+		args := strings.Fields(t)
+		if len(args) == 0 {
+			continue
+		}
+		out, err := sh.Output(args[0], args[1:]...)
+		if err != nil {
+			return "", fmt.Errorf("can't get the version of %s: %v", args[0], err)
+		}
+		fmt.Fprintf(h, "tool %q %q\n", t, out)
+	}
+	for _, v := range k.Values {
+		fmt.Fprintf(h, "value %q\n", v)
+	}
+	paths := make([]string, len(outputs))
+	for i, out := range outputs {
+		paths[i] = filepath.ToSlash(filepath.Clean(out))
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		fmt.Fprintf(h, "output %q\n", p)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// ID returns the key as the key of a CI cache entry: the Prefix and the
+// Hash, e.g. go-modules-linux-amd64-9f86d0..., so it's clear in the CI
+// service's list of caches what each entry is.
+func (k Key) ID() (string, error) {
+	hash, err := k.Hash()
+	if err != nil {
+		return "", err
+	}
+	return k.Prefix() + hash, nil
+}
+
+// Prefix returns the start of the key's ID that doesn't depend on the inputs,
+// e.g. go-modules-linux-amd64-, which is the restore key that finds the most
+// recent entry for the step, when there's none for its exact inputs.
+func (k Key) Prefix() string {
+	return fmt.Sprintf("%s-%s-%s-", k.Name, runtime.GOOS, runtime.GOARCH)
+}
+
+// GoModulesKey returns the key of the go module cache, which depends on the
+// go.sum files of the project, and of modules in directories under it.
+func GoModulesKey() Key {
+	return Key{Name: "go-modules", Optional: []string{"go.sum", "*/go.sum", "*/*/go.sum"}}
+}
+
+// ToolsKey returns the key of the tools the tools package installs, which
+// depend on the tools manifest, the go.mod tools.go takes the versions from,
+// and the version of go that builds them.
+func ToolsKey() Key {
+	return Key{Name: "tools", Optional: append(append([]string{}, tools.ManifestFiles...), "go.mod"), Tools: []string{mg.GoCmd() + " version"}}
+}
+
+// DockerKey returns the key of what's built from the project's Dockerfiles and
+// compose files, e.g. a BuildKit cache directory.
+func DockerKey() Key {
+	return Key{Name: "docker", Optional: []string{"Dockerfile*", "*.Dockerfile", "*/Dockerfile*", ".dockerignore", "compose*.y*ml", "docker-compose*.y*ml"}}
+}
+
+// files returns the files the globs match, and those in the directories they
+// match, sorted.
+func (k Key) files() ([]string, error) {
+	seen := map[string]bool{}
+	for i, pattern := range append(append([]string{}, k.Files...), k.Optional...) {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 && i < len(k.Files) {
+			return nil, errors.New("glob didn't match any files: " + pattern)
+		}
+		for _, m := range matches {
+			err := filepath.Walk(m, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !info.IsDir() {
+					seen[filepath.ToSlash(path)] = true
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	files := make([]string, 0, len(seen))
+	for f := range seen {
+		files = append(files, f)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func hashFile(h hash.Hash, path string) error {
+	f, err := os.Open(filepath.FromSlash(path))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fh := sha256.New()
+	if _, err := io.Copy(fh, f); err != nil {
+		return err
+	}
+	fmt.Fprintf(h, "file %q %x\n", path, fh.Sum(nil))
+	return nil
+}
@@ -0,0 +1,117 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pack writes the outputs, with the files in the directories, to w as a
+// gzipped tarball.
+func pack(w io.Writer, outputs []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for _, out := range outputs {
+		if _, err := os.Stat(out); err != nil {
+			return fmt.Errorf("output %s wasn't made: %v", out, err)
+		}
+		err := filepath.Walk(out, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			hdr := &tar.Header{
+				Name:    filepath.ToSlash(path),
+				Mode:    int64(info.Mode().Perm()),
+				ModTime: info.ModTime(),
+			}
+			switch {
+			case info.IsDir():
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+				return tw.WriteHeader(hdr)
+			case info.Mode().IsRegular():
+				hdr.Typeflag = tar.TypeReg
+				hdr.Size = info.Size()
+			default:
+				return fmt.Errorf("can't cache %s, only files and directories can be", path)
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// restore replaces the outputs with what's in the tarball at path.
+func restore(path string, outputs []string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	for _, out := range outputs {
+		if err := os.RemoveAll(out); err != nil {
+			return err
+		}
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.FromSlash(strings.TrimSuffix(hdr.Name, "/"))
+		if filepath.IsAbs(name) || strings.HasPrefix(filepath.Clean(name), "..") {
+			return fmt.Errorf("cache entry %q is outside the working directory", hdr.Name)
+		}
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, mode|0700); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+				return err
+			}
+			w, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(w, tr)
+			if cerr := w.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+		os.Chtimes(name, hdr.ModTime, hdr.ModTime)
+	}
+}
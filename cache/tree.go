@@ -0,0 +1,160 @@
+package cache
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// packDirs writes the directories to w as a gzipped tarball, with the files in
+// each under its index, e.g. 0/cache/download/..., since they may be anywhere,
+// like the go module cache.  Directories that don't exist are left out.
+func packDirs(w io.Writer, dirs []string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	for i, dir := range dirs {
+		if _, err := os.Stat(dir); os.IsNotExist(err) {
+			continue
+		}
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			name := strconv.Itoa(i) + "/" + filepath.ToSlash(rel)
+			if rel == "." {
+				name = strconv.Itoa(i)
+			}
+			hdr := &tar.Header{
+				Name:    name,
+				Mode:    int64(info.Mode().Perm()),
+				ModTime: info.ModTime(),
+			}
+			switch {
+			case info.IsDir():
+				hdr.Typeflag = tar.TypeDir
+				hdr.Name += "/"
+				return tw.WriteHeader(hdr)
+			case info.Mode()&os.ModeSymlink != 0:
+				hdr.Typeflag = tar.TypeSymlink
+				if hdr.Linkname, err = os.Readlink(path); err != nil {
+					return err
+				}
+				return tw.WriteHeader(hdr)
+			case info.Mode().IsRegular():
+				hdr.Typeflag = tar.TypeReg
+				hdr.Size = info.Size()
+			default:
+				// sockets and the like can't be restored, and aren't worth
+				// caching.
+				return nil
+			}
+			if err := tw.WriteHeader(hdr); err != nil {
+				return err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			_, err = io.Copy(tw, f)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// unpackDirs extracts a tarball written by packDirs into the directories.
+// Files that are already there are kept, so it only fills in what's missing,
+// and symlinks are made last, so nothing is written through one.
+func unpackDirs(r io.Reader, dirs []string) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	var links []*tar.Header
+	var linkPaths []string
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		parts := strings.SplitN(strings.TrimSuffix(hdr.Name, "/"), "/", 2)
+		i, err := strconv.Atoi(parts[0])
+		if err != nil || i < 0 || i >= len(dirs) {
+			return fmt.Errorf("cache entry %q isn't in one of the directories", hdr.Name)
+		}
+		rel := "."
+		if len(parts) == 2 {
+			rel = filepath.FromSlash(parts[1])
+		}
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(filepath.Clean(rel), ".."+string(filepath.Separator)) {
+			return fmt.Errorf("cache entry %q is outside its directory", hdr.Name)
+		}
+		name := filepath.Join(dirs[i], rel)
+		mode := os.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(name, mode|0700); err != nil {
+				return err
+			}
+			continue
+		case tar.TypeSymlink:
+			links = append(links, hdr)
+			linkPaths = append(linkPaths, name)
+			continue
+		case tar.TypeReg:
+		default:
+			continue
+		}
+		if _, err := os.Lstat(name); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			return err
+		}
+		w, err := os.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_EXCL, mode)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, tr)
+		if cerr := w.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+		os.Chtimes(name, hdr.ModTime, hdr.ModTime)
+	}
+	for i, hdr := range links {
+		if _, err := os.Lstat(linkPaths[i]); err == nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(linkPaths[i]), 0755); err != nil {
+			return err
+		}
+		if err := os.Symlink(hdr.Linkname, linkPaths[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// Package clock is the time that mage's libraries wait on and measure with:
+// the delays between retries, the deadlines of waits, and how long targets
+// and tasks took.  It's the real time, but tests can set Default to a Fake,
+// whose time only moves when it's told to, so they run instantly and the same
+// way every time, rather than sleeping:
+//
+//	func TestDeploy(t *testing.T) {
+//		fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+//		clock.Default = fake
+//		defer func() { clock.Default = clock.Real{} }()
+//		if err := Deploy(); err != nil {
+//			t.Fatal(err)
+//		}
+//		if len(fake.Sleeps()) != 2 {
+//			t.Fatalf("expected 2 retries but got %v", fake.Sleeps())
+//		}
+//	}
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells the time, and waits for it to pass.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// Default is the clock the libraries use.
+var Default Clock = Real{}
+
+// Now returns the time on the Default clock.
+func Now() time.Time {
+	return Default.Now()
+}
+
+// Since returns how long it's been since t on the Default clock.
+func Since(t time.Time) time.Duration {
+	return Default.Now().Sub(t)
+}
+
+// Until returns how long it is until t on the Default clock.
+func Until(t time.Time) time.Duration {
+	return t.Sub(Default.Now())
+}
+
+// Sleep waits for d to pass on the Default clock.
+func Sleep(d time.Duration) {
+	Default.Sleep(d)
+}
+
+// Real is the real time, from the time package.
+type Real struct{}
+
+// Now calls time.Now.
+func (Real) Now() time.Time { return time.Now() }
+
+// Sleep calls time.Sleep.
+func (Real) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Fake is a clock for tests, whose time only moves when it sleeps or is
+// advanced, and then moves right away.  It's safe to use from more than one
+// goroutine.
+type Fake struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps []time.Duration
+}
+
+// NewFake returns a Fake whose time is now.
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time.
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// Sleep moves the time forward by d, without waiting, and records it, for
+// Sleeps.
+func (f *Fake) Sleep(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.sleeps = append(f.sleeps, d)
+	if d > 0 {
+		f.now = f.now.Add(d)
+	}
+}
+
+// Advance moves the time forward by d, like something taking that long.
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+}
+
+// Sleeps returns how long each call to Sleep slept, in order, e.g. to check
+// the backoff between retries.
+func (f *Fake) Sleeps() []time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]time.Duration(nil), f.sleeps...)
+}
@@ -0,0 +1,36 @@
+package clock
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFake(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	fake := NewFake(start)
+	Default = fake
+	defer func() { Default = Real{} }()
+
+	deadline := Now().Add(time.Minute)
+	Sleep(time.Second)
+	Sleep(2 * time.Second)
+	fake.Advance(10 * time.Second)
+	if d := Since(start); d != 13*time.Second {
+		t.Fatalf("expected 13s to have passed but got %v", d)
+	}
+	if d := Until(deadline); d != 47*time.Second {
+		t.Fatalf("expected 47s to be left but got %v", d)
+	}
+	if s := fake.Sleeps(); !reflect.DeepEqual(s, []time.Duration{time.Second, 2 * time.Second}) {
+		t.Fatalf("unexpected sleeps %v", s)
+	}
+}
+
+func TestReal(t *testing.T) {
+	start := Now()
+	Sleep(time.Millisecond)
+	if d := Since(start); d < time.Millisecond {
+		t.Fatalf("expected at least 1ms to have passed but got %v", d)
+	}
+}
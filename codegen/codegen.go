@@ -0,0 +1,163 @@
+// Package codegen runs go generate, or other code generators, only when the
+// files the code is generated from have changed, and checks on CI that the
+// generated code that's committed is up to date.
+package codegen
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/gitx"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/target"
+)
+
+// Generator is a code generator, and the files it reads and writes.
+type Generator struct {
+	// Name is what the generator is called in messages.  It defaults to the
+	// command.
+	Name string
+
+	// Command is the generator to run, e.g. protoc --go_out=. api.proto.  By
+	// default, it's go generate.
+	Command []string
+
+	// Packages are the packages go generate is run in, when there's no
+	// Command.  They default to ./...
+	Packages []string
+
+	// Inputs are the files the code is generated from, as globs like
+	// api/*.proto.  Each must match something.  If there are none, the
+	// generator always runs.
+	Inputs []string
+
+	// Outputs are the files and directories the generator writes.  If there
+	// are none, the generator always runs, and Check checks every file.
+	Outputs []string
+}
+
+func (g Generator) command() []string {
+	if len(g.Command) > 0 {
+		return g.Command
+	}
+	pkgs := g.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	return append([]string{mg.GoCmd(), "generate"}, pkgs...)
+}
+
+func (g Generator) name() string {
+	if g.Name != "" {
+		return g.Name
+	}
+	return strings.Join(g.command(), " ")
+}
+
+// Stale reports whether any of the inputs have been modified more recently
+// than the outputs, or any of the outputs don't exist, the way target.Dir
+// does.
+func (g Generator) Stale() (bool, error) {
+	if len(g.Inputs) == 0 || len(g.Outputs) == 0 {
+		return true, nil
+	}
+	var inputs []string
+	for _, pattern := range g.Inputs {
+		files, err := filepath.Glob(pattern)
+		if err != nil {
+			return false, err
+		}
+		if len(files) == 0 {
+			return false, errors.New("glob didn't match any files: " + pattern)
+		}
+		inputs = append(inputs, files...)
+	}
+	for _, out := range g.Outputs {
+		stale, err := target.Dir(out, inputs...)
+		if err != nil || stale {
+			return stale, err
+		}
+	}
+	return false, nil
+}
+
+// Run runs the generator, whether or not it's stale.
+func (g Generator) Run() error {
+	cmd := g.command()
+	if err := sh.RunV(cmd[0], cmd[1:]...); err != nil {
+		return fmt.Errorf("%s failed: %v", g.name(), err)
+	}
+	return nil
+}
+
+// Generate runs the generators that are stale, in order.
+func Generate(gens ...Generator) error {
+	for _, g := range gens {
+		stale, err := g.Stale()
+		if err != nil {
+			return fmt.Errorf("can't tell if %s needs to run: %v", g.name(), err)
+		}
+		if !stale {
+			if mg.Verbose() {
+				log.Printf("%s is up to date", g.name())
+			}
+			continue
+		}
+		if err := g.Run(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Check runs all the generators, and fails with the diff if that changed
+// the generated code, so it's out of date with what's committed.  If the
+// generators declare their outputs, only those are checked, or else any file
+// in the working directory.
+func Check(gens ...Generator) error {
+	var paths []string
+	for _, g := range gens {
+		if len(g.Outputs) == 0 {
+			paths = nil
+			break
+		}
+		paths = append(paths, g.Outputs...)
+	}
+	for _, g := range gens {
+		if err := g.Run(); err != nil {
+			return err
+		}
+	}
+	changed, err := gitx.Changed(paths...)
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+	diff, err := gitx.Diff(changed...)
+	if err != nil {
+		return err
+	}
+	msg := "generated code is out of date, regenerate it and commit the changes to:\n  " + strings.Join(changed, "\n  ")
+	if diff != "" {
+		msg += "\n\n" + diff
+	}
+	return errors.New(msg)
+}
+
+// Run checks the generated code, like Check, when running on CI, i.e. CI is
+// true, as most CI services set it, and otherwise generates it, like
+// Generate.
+func Run(gens ...Generator) error {
+	if ci, _ := strconv.ParseBool(os.Getenv("CI")); ci {
+		return Check(gens...)
+	}
+	return Generate(gens...)
+}
@@ -0,0 +1,182 @@
+package codegen
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// project changes to a new git repository with api/api.txt, which the
+// generator copies to gen/api.go, counting each time it runs.  It returns
+// the generator, a func that runs git, and one that changes back and removes
+// the repository.
+func project(t *testing.T) (Generator, func(args ...string), func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh as the generator")
+	}
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	git := func(args ...string) {
+		args = append([]string{"-c", "user.name=mage", "-c", "user.email=mage@example.com", "-c", "commit.gpgsign=false"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	git("init", "-q")
+	os.Mkdir("api", 0755)
+	ioutil.WriteFile("api/api.txt", []byte("v1\n"), 0644)
+	ioutil.WriteFile(".gitignore", []byte("runs\n"), 0644)
+	g := Generator{
+		Name:    "api",
+		Command: []string{"sh", "-c", "mkdir -p gen && cp api/api.txt gen/api.go && echo >> runs"},
+		Inputs:  []string{"api/*.txt"},
+		Outputs: []string{"gen"},
+	}
+	return g, git, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func runs(t *testing.T) int {
+	b, err := ioutil.ReadFile("runs")
+	if os.IsNotExist(err) {
+		return 0
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(b)
+}
+
+func TestGenerate(t *testing.T) {
+	g, _, cleanup := project(t)
+	defer cleanup()
+
+	if err := Generate(g); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs(t); n != 1 {
+		t.Fatalf("expected the generator to run since gen doesn't exist but it ran %d times", n)
+	}
+	if err := Generate(g); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs(t); n != 1 {
+		t.Fatalf("expected the generator not to run again but it ran %d times", n)
+	}
+
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes("api/api.txt", later, later); err != nil {
+		t.Fatal(err)
+	}
+	if err := Generate(g); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs(t); n != 2 {
+		t.Fatalf("expected the generator to run after api.txt changed but it ran %d times", n)
+	}
+
+	g.Inputs = nil
+	if err := Generate(g); err != nil {
+		t.Fatal(err)
+	}
+	if n := runs(t); n != 3 {
+		t.Fatalf("expected the generator to run with no inputs but it ran %d times", n)
+	}
+
+	g.Inputs = []string{"api/*.proto"}
+	if err := Generate(g); err == nil || err.Error() != "can't tell if api needs to run: glob didn't match any files: api/*.proto" {
+		t.Fatalf("expected an error for the glob but got %v", err)
+	}
+
+	g.Command = []string{"sh", "-c", "exit 3"}
+	g.Inputs = nil
+	if err := Generate(g); err == nil || !strings.HasPrefix(err.Error(), "api failed: ") {
+		t.Fatalf("expected the generator to fail but got %v", err)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	g, git, cleanup := project(t)
+	defer cleanup()
+
+	if err := Generate(g); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "generated")
+	if err := Check(g); err != nil {
+		t.Fatalf("expected the generated code to be up to date but got %v", err)
+	}
+
+	ioutil.WriteFile("api/api.txt", []byte("v2\n"), 0644)
+	err := Check(g)
+	if err == nil {
+		t.Fatal("expected the generated code to be out of date")
+	}
+	msg := err.Error()
+	if !strings.HasPrefix(msg, "generated code is out of date, regenerate it and commit the changes to:\n  gen/api.go\n\ndiff --git") || !strings.HasSuffix(msg, "-v1\n+v2") {
+		t.Fatalf("expected the diff of gen/api.go but got:\n%s", msg)
+	}
+
+	// api.txt changed too, but it isn't generated.
+	git("commit", "-q", "-m", "regenerated", "gen")
+	if err := Check(g); err != nil {
+		t.Fatalf("expected only the outputs to be checked but got %v", err)
+	}
+	g.Outputs = nil
+	if err := Check(g); err == nil || !strings.Contains(err.Error(), "\n  api/api.txt\n") {
+		t.Fatalf("expected every file to be checked with no outputs but got %v", err)
+	}
+	git("commit", "-q", "-a", "-m", "v2")
+
+	g.Outputs = []string{"gen"}
+	g.Command = []string{"sh", "-c", "echo package gen > gen/new.go"}
+	if err := Check(g); err == nil || !strings.HasSuffix(err.Error(), "the changes to:\n  gen/new.go") {
+		t.Fatalf("expected the new file to be out of date but got %v", err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	g, git, cleanup := project(t)
+	defer cleanup()
+	old, ok := os.LookupEnv("CI")
+	defer func() {
+		if ok {
+			os.Setenv("CI", old)
+		} else {
+			os.Unsetenv("CI")
+		}
+	}()
+
+	os.Setenv("CI", "")
+	if err := Run(g); err != nil {
+		t.Fatal(err)
+	}
+	git("add", ".")
+	git("commit", "-q", "-m", "generated")
+	if err := Run(g); err != nil || runs(t) != 1 {
+		t.Fatalf("expected the generator not to run again but got %v and %d runs", err, runs(t))
+	}
+	os.Setenv("CI", "true")
+	if err := Run(g); err != nil || runs(t) != 2 {
+		t.Fatalf("expected the generator to run on CI but got %v and %d runs", err, runs(t))
+	}
+}
@@ -0,0 +1,127 @@
+package codegen
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/tools"
+)
+
+// MockTool is a tool that generates mocks of interfaces.
+type MockTool string
+
+// The mock generators, which are installed from these packages.
+const (
+	Mockgen MockTool = "go.uber.org/mock/mockgen"
+	Moq     MockTool = "github.com/matryer/moq"
+)
+
+// Mock is mocks of interfaces, generated by mockgen or moq.
+type Mock struct {
+	// Tool generates the mocks.  It defaults to Mockgen.
+	Tool MockTool
+
+	// Version is the version of the tool to install.  It defaults to the
+	// version in the tools manifest.
+	Version string
+
+	// Dir is the directory of the package with the interfaces, e.g. ./store.
+	Dir string
+
+	// Interfaces are the names of the interfaces to mock.
+	Interfaces []string
+
+	// Out is the file the mocks are written to, e.g. store/mocks/store.go.
+	Out string
+
+	// Package is the name of the package the mocks are in.  It defaults to
+	// the tool's default.
+	Package string
+}
+
+// Generator returns the generator of the mocks, having installed the tool,
+// which regenerates them when a go file in Dir changes.
+//
+//	g, err := codegen.Mock{Dir: "./store", Interfaces: []string{"Store"}, Out: "store/mocks/store.go"}.Generator()
+func (m Mock) Generator() (Generator, error) {
+	if m.Dir == "" || len(m.Interfaces) == 0 || m.Out == "" {
+		return Generator{}, fmt.Errorf("mocks need a directory, interfaces and a file to write them to")
+	}
+	tool := m.Tool
+	if tool == "" {
+		tool = Mockgen
+	}
+	bin, err := m.binary(tool)
+	if err != nil {
+		return Generator{}, err
+	}
+	var cmd []string
+	switch tool {
+	case Mockgen:
+		// mockgen needs the import path of the package, rather than its
+		// directory.
+		pkg, err := sh.Output(mg.GoCmd(), "list", m.Dir)
+		if err != nil {
+			return Generator{}, err
+		}
+		cmd = []string{bin, "-destination", m.Out}
+		if m.Package != "" {
+			cmd = append(cmd, "-package", m.Package)
+		}
+		cmd = append(cmd, pkg, strings.Join(m.Interfaces, ","))
+	case Moq:
+		cmd = []string{bin, "-out", m.Out}
+		if m.Package != "" {
+			cmd = append(cmd, "-pkg", m.Package)
+		}
+		cmd = append(append(cmd, m.Dir), m.Interfaces...)
+	default:
+		return Generator{}, fmt.Errorf("unknown mock generator %q", tool)
+	}
+	return Generator{
+		Name:    "mocks of " + strings.Join(m.Interfaces, ", "),
+		Command: cmd,
+		Inputs:  []string{filepath.Join(m.Dir, "*.go")},
+		Outputs: []string{m.Out},
+	}, nil
+}
+
+// binary returns the path to the tool, installing it if it's not already.
+func (m Mock) binary(tool MockTool) (string, error) {
+	if m.Version != "" {
+		return tools.Ensure(string(tool), m.Version)
+	}
+	t, ok, err := tools.Lookup(string(tool))
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return t.Ensure()
+	}
+	return "", fmt.Errorf("no version of %s to install, set one or add it to the tools manifest", tool)
+}
+
+// RunMocks regenerates the mocks that are stale, or in CI, checks they're up
+// to date, like Run.
+//
+//	// Mocks regenerates the mocks, or checks they're up to date in CI.
+//	func Mocks() error {
+//		return codegen.RunMocks(
+//			codegen.Mock{Dir: "./store", Interfaces: []string{"Store"}, Out: "store/mocks/store.go"},
+//			codegen.Mock{Tool: codegen.Moq, Dir: "./queue", Interfaces: []string{"Queue"}, Out: "queue/queue_mock.go"},
+//		)
+//	}
+func RunMocks(mocks ...Mock) error {
+	gens := make([]Generator, len(mocks))
+	for i, m := range mocks {
+		g, err := m.Generator()
+		if err != nil {
+			return err
+		}
+		gens[i] = g
+	}
+	return Run(gens...)
+}
@@ -0,0 +1,129 @@
+package codegen
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/mg"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"mockgen": fakeMock("mockgen"), "moq": fakeMock("moq")})
+	os.Exit(m.Run())
+}
+
+// fakeMock stands in for the tool called name, logging how it was run to
+// $FAKE_MOCK_LOG, and writing the file given as its second arg.
+func fakeMock(name string) faketool.Tool {
+	return func(args []string) int {
+		faketool.Append(os.Getenv("FAKE_MOCK_LOG"), name+" "+strings.Join(args, " "))
+		if err := ioutil.WriteFile(args[1], []byte("mock\n"), 0644); err != nil {
+			return 1
+		}
+		return 0
+	}
+}
+
+// mockProject changes to a new module with an interface in store, and puts a
+// fake mockgen and moq where the tools package installs them, which log how
+// they were run and write the file they're asked to.  It returns the log, and
+// a func that changes back and removes the module.
+func mockProject(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir("store", 0755)
+	ioutil.WriteFile("go.mod", []byte("module example.com/app\n\ngo 1.16\n"), 0644)
+	ioutil.WriteFile(filepath.Join("store", "store.go"), []byte("package store\n\ntype Store interface{ Get(string) string }\n"), 0644)
+	logFile := filepath.Join(dir, "log")
+	faketool.Install(t, filepath.Join(dir, ".tools", "mockgen", "v0.4.0"), "mockgen")
+	faketool.Install(t, filepath.Join(dir, ".tools", "moq", "v0.3.3"), "moq")
+	restore := map[string]string{}
+	for k, v := range map[string]string{"FAKE_MOCK_LOG": logFile, "GOFLAGS": "", "GOWORK": "off", mg.ToolsDirEnv: filepath.Join(dir, ".tools"), "CI": ""} {
+		restore[k] = os.Getenv(k)
+		os.Setenv(k, v)
+	}
+	return logFile, func() {
+		for k, v := range restore {
+			os.Setenv(k, v)
+		}
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestMockGenerator(t *testing.T) {
+	_, cleanup := mockProject(t)
+	defer cleanup()
+	g, err := Mock{Version: "v0.4.0", Dir: "./store", Interfaces: []string{"Store", "Cache"}, Out: "store/mocks/store.go", Package: "mocks"}.Generator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin, _ := filepath.Abs(filepath.Join(".tools", "mockgen", "v0.4.0", "mockgen"))
+	if runtime.GOOS == "windows" {
+		bin += ".exe"
+	}
+	expected := bin + " -destination store/mocks/store.go -package mocks example.com/app/store Store,Cache"
+	if got := strings.Join(g.Command, " "); got != expected {
+		t.Fatalf("expected %s, got %s", expected, got)
+	}
+	if g.Inputs[0] != filepath.Join("store", "*.go") || g.Outputs[0] != "store/mocks/store.go" {
+		t.Fatalf("unexpected inputs %v and outputs %v", g.Inputs, g.Outputs)
+	}
+
+	if _, err := (Mock{Tool: Moq, Dir: "./store", Interfaces: []string{"Store"}, Out: "x.go"}).Generator(); err == nil || !strings.HasPrefix(err.Error(), "no version of github.com/matryer/moq") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := (Mock{Dir: "./store"}).Generator(); err == nil {
+		t.Fatal("expected an error without interfaces")
+	}
+	// a broken tools manifest is reported, rather than taken for a missing one.
+	if err := ioutil.WriteFile("tools.yaml", []byte("moq "+string(Moq)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (Mock{Tool: Moq, Dir: "./store", Interfaces: []string{"Store"}, Out: "x.go"}).Generator(); err == nil || !strings.HasPrefix(err.Error(), "error reading tools.yaml") {
+		t.Fatalf("expected an error reading the tools manifest, got %v", err)
+	}
+}
+
+func TestRunMocks(t *testing.T) {
+	logFile, cleanup := mockProject(t)
+	defer cleanup()
+	m := Mock{Tool: Moq, Version: "v0.3.3", Dir: "./store", Interfaces: []string{"Store"}, Out: "store/store_mock.go"}
+	count := func() int {
+		b, _ := ioutil.ReadFile(logFile)
+		return strings.Count(string(b), "\n")
+	}
+	if err := RunMocks(m); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(logFile)
+	if !strings.HasSuffix(strings.TrimSpace(string(b)), "moq -out store/store_mock.go ./store Store") {
+		t.Fatalf("unexpected %q", b)
+	}
+	if err := RunMocks(m); err != nil {
+		t.Fatal(err)
+	}
+	if count() != 1 {
+		t.Fatal("expected the mocks not to be generated again when they're up to date")
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join("store", "store.go"), later, later)
+	if err := RunMocks(m); err != nil {
+		t.Fatal(err)
+	}
+	if count() != 2 {
+		t.Fatal("expected the mocks to be generated when the interface changed")
+	}
+}
@@ -0,0 +1,260 @@
+// Package devcert makes TLS certificates for running HTTPS services locally,
+// without mkcert or openssl: a local certificate authority, and certificates
+// for the hosts the services run on, signed by it.  They're written to a
+// directory that's ignored by git, and made again when they're about to
+// expire, or the hosts change.  Trust the CA, e.g. with SSL_CERT_FILE or the
+// system's keychain, to have clients accept the certificates.
+package devcert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// now is the time the certificates are checked and made at.
+var now = time.Now
+
+// caValidity is how long the CA is valid for.
+const caValidity = 10 * 365 * 24 * time.Hour
+
+// Options are the options for Ensure.
+type Options struct {
+	// Dir is the directory the certificates are written to.  It defaults to
+	// .certs.  It gets a .gitignore that ignores everything in it.
+	Dir string
+
+	// Name is the name of the certificate's files, e.g. localhost for
+	// localhost.pem and localhost-key.pem.  It defaults to localhost.
+	Name string
+
+	// Hosts are the DNS names and IP addresses the certificate is for.
+	// They default to localhost, 127.0.0.1 and ::1.
+	Hosts []string
+
+	// Validity is how long the certificate is valid for.  It defaults to
+	// 90 days.  It's made again when less than a third of that is left.
+	Validity time.Duration
+}
+
+// Cert is the files of a certificate.
+type Cert struct {
+	// CertFile and KeyFile are the certificate and its private key, in PEM
+	// format, as http.ListenAndServeTLS takes them.
+	CertFile string
+	KeyFile  string
+
+	// CAFile is the certificate of the CA that signed it.
+	CAFile string
+}
+
+// Ensure makes the CA and the certificate, unless they're there and valid
+// for long enough, and for the hosts, and returns their files.
+//
+//	func Serve() error {
+//		cert, err := devcert.Ensure(devcert.Options{Hosts: []string{"app.localhost", "127.0.0.1"}})
+//		if err != nil {
+//			return err
+//		}
+//		return sh.RunWithV(map[string]string{"TLS_CERT": cert.CertFile, "TLS_KEY": cert.KeyFile}, "go", "run", "./cmd/app")
+//	}
+func Ensure(opts Options) (Cert, error) {
+	dir := opts.Dir
+	if dir == "" {
+		dir = ".certs"
+	}
+	name := opts.Name
+	if name == "" {
+		name = "localhost"
+	}
+	hosts := opts.Hosts
+	if len(hosts) == 0 {
+		hosts = []string{"localhost", "127.0.0.1", "::1"}
+	}
+	validity := opts.Validity
+	if validity == 0 {
+		validity = 90 * 24 * time.Hour
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return Cert{}, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*\n"), 0644); err != nil {
+		return Cert{}, err
+	}
+	c := Cert{
+		CertFile: filepath.Join(dir, name+".pem"),
+		KeyFile:  filepath.Join(dir, name+"-key.pem"),
+		CAFile:   filepath.Join(dir, "ca.pem"),
+	}
+	caKeyFile := filepath.Join(dir, "ca-key.pem")
+
+	ca, caKey, err := load(c.CAFile, caKeyFile)
+	if err != nil || expiring(ca, caValidity) {
+		log.Printf("making a CA for local development in %s", dir)
+		if ca, caKey, err = makeCA(c.CAFile, caKeyFile); err != nil {
+			return Cert{}, err
+		}
+	}
+	cert, _, err := load(c.CertFile, c.KeyFile)
+	if err == nil && !expiring(cert, validity) && sameHosts(cert, hosts) && cert.CheckSignatureFrom(ca) == nil {
+		return c, nil
+	}
+	log.Printf("making a certificate for %v in %s", hosts, dir)
+	tmpl, err := template(validity)
+	if err != nil {
+		return Cert{}, err
+	}
+	tmpl.Subject = pkix.Name{Organization: []string{"mage development"}, CommonName: hosts[0]}
+	tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+	tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+	if err := write(c.CertFile, c.KeyFile, tmpl, ca, caKey); err != nil {
+		return Cert{}, err
+	}
+	return c, nil
+}
+
+// makeCA makes a CA, and writes it to the files.
+func makeCA(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	tmpl, err := template(caValidity)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl.Subject = pkix.Name{Organization: []string{"mage development"}, CommonName: "mage development CA"}
+	tmpl.IsCA = true
+	tmpl.BasicConstraintsValid = true
+	tmpl.MaxPathLenZero = true
+	tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	if err := write(certFile, keyFile, tmpl, nil, nil); err != nil {
+		return nil, nil, err
+	}
+	return load(certFile, keyFile)
+}
+
+// template returns a certificate template with a random serial number, valid
+// from a little while ago, so clocks that are a bit off accept it, for the
+// validity.
+func template(validity time.Duration) (*x509.Certificate, error) {
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	start := now().Add(-time.Hour)
+	return &x509.Certificate{
+		SerialNumber: serial,
+		NotBefore:    start,
+		NotAfter:     start.Add(validity),
+	}, nil
+}
+
+// write makes a key, and the certificate for it from the template, signed by
+// the parent, or by itself if it's nil, and writes them to the files.
+func write(certFile, keyFile string, tmpl, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) error {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return err
+	}
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		return fmt.Errorf("can't make the certificate %s: %v", certFile, err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644)
+}
+
+// load reads a certificate and its key from the files.
+func load(certFile, keyFile string) (*x509.Certificate, *ecdsa.PrivateKey, error) {
+	cert, err := readPEM(certFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	c, err := x509.ParseCertificate(cert)
+	if err != nil {
+		return nil, nil, err
+	}
+	key, err := readPEM(keyFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	k, err := x509.ParsePKCS8PrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	ecKey, ok := k.(*ecdsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("%s isn't an ECDSA key", keyFile)
+	}
+	return c, ecKey, nil
+}
+
+func readPEM(path string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(b)
+	if block == nil {
+		return nil, errors.New(path + " isn't PEM encoded")
+	}
+	return block.Bytes, nil
+}
+
+// expiring reports whether less than a third of the validity is left on the
+// certificate.
+func expiring(c *x509.Certificate, validity time.Duration) bool {
+	return c.NotAfter.Sub(now()) < validity/3
+}
+
+// sameHosts reports whether the certificate is for exactly the hosts.
+func sameHosts(c *x509.Certificate, hosts []string) bool {
+	var have, want []string
+	have = append(have, c.DNSNames...)
+	for _, ip := range c.IPAddresses {
+		have = append(have, ip.String())
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			h = ip.String()
+		}
+		want = append(want, h)
+	}
+	sort.Strings(have)
+	sort.Strings(want)
+	if len(have) != len(want) {
+		return false
+	}
+	for i := range have {
+		if have[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
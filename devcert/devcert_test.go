@@ -0,0 +1,96 @@
+package devcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestEnsure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	opts := Options{Dir: filepath.Join(dir, "certs")}
+	c, err := Ensure(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(opts.Dir, ".gitignore")); string(b) != "*\n" {
+		t.Fatalf("expected the directory to be ignored, got %q", b)
+	}
+
+	// a server with the certificate is trusted by a client that trusts the CA.
+	pair, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{pair}}
+	srv.StartTLS()
+	defer srv.Close()
+	caPEM, _ := ioutil.ReadFile(c.CAFile)
+	pool := x509.NewCertPool()
+	pool.AppendCertsFromPEM(caPEM)
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+	resp, err := client.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	serial := func(path string) string {
+		b, err := readPEM(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert.SerialNumber.String()
+	}
+	leaf, ca := serial(c.CertFile), serial(c.CAFile)
+	if _, err := Ensure(opts); err != nil {
+		t.Fatal(err)
+	}
+	if serial(c.CertFile) != leaf || serial(c.CAFile) != ca {
+		t.Fatal("expected valid certificates to be kept")
+	}
+
+	opts.Hosts = []string{"app.localhost", "127.0.0.1"}
+	if _, err := Ensure(opts); err != nil {
+		t.Fatal(err)
+	}
+	if serial(c.CertFile) == leaf || serial(c.CAFile) != ca {
+		t.Fatal("expected a new certificate, from the same CA, for new hosts")
+	}
+	leaf = serial(c.CertFile)
+
+	// 80 days on, less than a third of 90 days is left.
+	now = func() time.Time { return time.Now().Add(80 * 24 * time.Hour) }
+	defer func() { now = time.Now }()
+	if _, err := Ensure(opts); err != nil {
+		t.Fatal(err)
+	}
+	if serial(c.CertFile) == leaf || serial(c.CAFile) != ca {
+		t.Fatal("expected a new certificate when it's about to expire")
+	}
+
+	// a new CA means a new certificate signed by it.
+	os.Remove(filepath.Join(opts.Dir, "ca-key.pem"))
+	leaf = serial(c.CertFile)
+	if _, err := Ensure(opts); err != nil {
+		t.Fatal(err)
+	}
+	if serial(c.CertFile) == leaf || serial(c.CAFile) == ca {
+		t.Fatal("expected a new CA and certificate")
+	}
+}
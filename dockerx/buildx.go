@@ -0,0 +1,109 @@
+package dockerx
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// DefaultPlatforms are the platforms BuildMultiArch builds for if none are
+// given.
+var DefaultPlatforms = []string{"linux/amd64", "linux/arm64"}
+
+// BuildxOptions are the options for BuildMultiArch.
+type BuildxOptions struct {
+	// BuildOptions are the context, Dockerfile, tags, build args and target.
+	// The Platform is ignored in favor of Platforms.
+	BuildOptions
+
+	// Platforms are the platforms to build for.  They default to
+	// DefaultPlatforms.
+	Platforms []string
+
+	// Builder is the name of the buildx builder to use.  If it doesn't
+	// exist, it's created for the build and removed afterwards.  It defaults
+	// to mage.
+	Builder string
+
+	// KeepBuilder keeps a builder that was created for the build, so the
+	// next build can use its cache.
+	KeepBuilder bool
+
+	// Push pushes the images, and the manifest list that ties them together,
+	// to the registry.  Images for several platforms can't be loaded into
+	// docker, so without it, they're only built.
+	Push bool
+
+	// CacheFrom and CacheTo are where buildx reads and writes its cache,
+	// e.g. "type=gha".  When pushing, they default to the cache in the first
+	// tag's image.
+	CacheFrom string
+	CacheTo   string
+}
+
+// BuildMultiArch builds an image for several platforms with docker buildx.
+//
+//	func Image() error {
+//		return dockerx.BuildMultiArch(dockerx.BuildxOptions{
+//			BuildOptions: dockerx.BuildOptions{Tags: []string{"example.com/app:" + version}},
+//			Push:         true,
+//		})
+//	}
+func BuildMultiArch(opts BuildxOptions) (err error) {
+	builder := opts.Builder
+	if builder == "" {
+		builder = "mage"
+	}
+	platforms := opts.Platforms
+	if len(platforms) == 0 {
+		platforms = DefaultPlatforms
+	}
+
+	// buildx inspect fails if the builder doesn't exist.
+	if _, err := sh.Exec(nil, nil, nil, "docker", "buildx", "inspect", builder); err != nil {
+		if err := sh.RunV("docker", "buildx", "create", "--name", builder, "--driver", "docker-container"); err != nil {
+			return &Error{Step: "buildx create", Ref: builder, Err: err}
+		}
+		if !opts.KeepBuilder {
+			defer func() {
+				if rmErr := sh.RunV("docker", "buildx", "rm", builder); rmErr != nil {
+					rmErr = &Error{Step: "buildx rm", Ref: builder, Err: rmErr}
+					if err == nil {
+						err = rmErr
+					} else {
+						fmt.Fprintln(os.Stderr, "Error:", rmErr)
+					}
+				}
+			}()
+		}
+	}
+
+	context := opts.context()
+	args := []string{"buildx", "build", "--builder", builder, "--platform", strings.Join(platforms, ",")}
+	args = append(args, opts.flags()...)
+	cacheFrom, cacheTo := opts.CacheFrom, opts.CacheTo
+	if opts.Push && len(opts.Tags) > 0 {
+		if cacheFrom == "" {
+			cacheFrom = "type=registry,ref=" + opts.Tags[0]
+		}
+		if cacheTo == "" {
+			cacheTo = "type=inline"
+		}
+	}
+	if cacheFrom != "" {
+		args = append(args, "--cache-from", cacheFrom)
+	}
+	if cacheTo != "" {
+		args = append(args, "--cache-to", cacheTo)
+	}
+	if opts.Push {
+		args = append(args, "--push")
+	}
+	args = append(args, context)
+	if err := sh.RunV("docker", args...); err != nil {
+		return &Error{Step: "buildx build", Ref: context, Err: err}
+	}
+	return nil
+}
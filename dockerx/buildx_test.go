@@ -0,0 +1,76 @@
+package dockerx
+
+import (
+	"os"
+	"testing"
+)
+
+func TestBuildMultiArch(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("DOCKER_BUILDKIT", "")()
+	os.Unsetenv("DOCKER_BUILDKIT")
+	defer setenv("FAKE_BUILDX_MISSING", "1")()
+
+	err := BuildMultiArch(BuildxOptions{
+		BuildOptions: BuildOptions{
+			Tags:      []string{"example.com/app:v1", "example.com/app:latest"},
+			BuildArgs: map[string]string{"VERSION": "v1"},
+		},
+		Push: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "buildx inspect mage\nbuildkit=\n" +
+		"buildx create --name mage --driver docker-container\nbuildkit=\n" +
+		"buildx build --builder mage --platform linux/amd64,linux/arm64 --tag example.com/app:v1 --tag example.com/app:latest " +
+		"--build-arg VERSION=v1 --cache-from type=registry,ref=example.com/app:v1 --cache-to type=inline --push .\nbuildkit=\n" +
+		"buildx rm mage\nbuildkit=\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	// an existing builder is used and kept.
+	os.Unsetenv("FAKE_BUILDX_MISSING")
+	err = BuildMultiArch(BuildxOptions{
+		BuildOptions: BuildOptions{Context: "app", Tags: []string{"app:dev"}},
+		Platforms:    []string{"linux/arm64"},
+		Builder:      "ci",
+		CacheFrom:    "type=gha",
+		CacheTo:      "type=gha,mode=max",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "buildx inspect ci\nbuildkit=\n" +
+		"buildx build --builder ci --platform linux/arm64 --tag app:dev --cache-from type=gha --cache-to type=gha,mode=max app\nbuildkit=\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	// a builder created for a build can be kept for the next one.
+	os.Setenv("FAKE_BUILDX_MISSING", "1")
+	if err := BuildMultiArch(BuildxOptions{KeepBuilder: true}); err != nil {
+		t.Fatal(err)
+	}
+	expected = "buildx inspect mage\nbuildkit=\n" +
+		"buildx create --name mage --driver docker-container\nbuildkit=\n" +
+		"buildx build --builder mage --platform linux/amd64,linux/arm64 .\nbuildkit=\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestBuildMultiArchError(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("FAKE_DOCKER_EXIT", "2")()
+
+	err := BuildMultiArch(BuildxOptions{})
+	expected := `docker buildx create of mage failed: running "docker buildx create --name mage --driver docker-container" failed with exit code 2`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q but got %v", expected, err)
+	}
+	read()
+}
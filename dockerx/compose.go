@@ -0,0 +1,179 @@
+package dockerx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/sh"
+)
+
+// Compose is a docker compose project, e.g. the dependencies of integration
+// tests.
+type Compose struct {
+	// Files are the compose files.  By default, docker compose looks for
+	// compose.yaml in the working directory.
+	Files []string
+
+	// Project is the name of the project.  By default, docker compose names
+	// it after the directory.
+	Project string
+}
+
+// pollInterval is how often WaitHealthy checks the services.
+var pollInterval = time.Second
+
+// Up starts the services, or all of them, in the background, and waits up to
+// the timeout for them to be healthy.  If they don't start, their logs are
+// printed and they're torn down.  Otherwise it returns a func that tears them
+// down, so it can be returned by a dependency to be run once the targets are
+// done:
+//
+//	var stack = dockerx.Compose{Files: []string{"test/compose.yaml"}}
+//
+//	func Stack() (func(), error) {
+//		return stack.Up(2*time.Minute)
+//	}
+//
+//	func Integration() error {
+//		mg.Deps(Stack)
+//		return sh.RunV("go", "test", "-tags", "integration", "./...")
+//	}
+func (c Compose) Up(timeout time.Duration, services ...string) (down func(), err error) {
+	args := append([]string{"up", "--detach"}, services...)
+	if err = sh.RunV("docker", c.args(args...)...); err != nil {
+		err = &Error{Step: "compose up", Ref: c.ref(), Err: err}
+	} else {
+		err = c.WaitHealthy(timeout, services...)
+	}
+	if err != nil {
+		c.Logs(services...)
+		c.Down()
+		return nil, err
+	}
+	return func() {
+		if err := c.Down(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}, nil
+}
+
+// WaitHealthy waits up to the timeout for the services, or all of them, to be
+// ready: healthy if they have a health check, running if they don't, or
+// exited successfully if they're a one-off task.  It fails straight away if
+// one is unhealthy or exits with an error.
+func (c Compose) WaitHealthy(timeout time.Duration, services ...string) error {
+	deadline := clock.Now().Add(timeout)
+	for {
+		states, err := c.ps(services...)
+		if err != nil {
+			return err
+		}
+		var waiting []string
+		for _, s := range states {
+			switch {
+			case s.Health == "unhealthy":
+				return &Error{Step: "wait", Ref: c.ref(), Err: fmt.Errorf("%s is unhealthy", s.Service)}
+			case s.State == "exited" && s.ExitCode != 0:
+				return &Error{Step: "wait", Ref: c.ref(), Err: fmt.Errorf("%s exited with code %d", s.Service, s.ExitCode)}
+			case s.State == "exited", s.Health == "healthy", s.State == "running" && s.Health == "":
+			default:
+				waiting = append(waiting, s.Service)
+			}
+		}
+		if len(states) == 0 {
+			waiting = []string{"the services to start"}
+		}
+		if len(waiting) == 0 {
+			return nil
+		}
+		if !clock.Now().Before(deadline) {
+			sort.Strings(waiting)
+			return &Error{Step: "wait", Ref: c.ref(), Err: fmt.Errorf("timed out after %v waiting for %s", timeout, strings.Join(waiting, ", "))}
+		}
+		clock.Sleep(pollInterval)
+	}
+}
+
+// Logs prints the logs of the services, or all of them, to stderr.
+func (c Compose) Logs(services ...string) error {
+	args := append([]string{"logs", "--no-color"}, services...)
+	if _, err := sh.Exec(nil, os.Stderr, os.Stderr, "docker", c.args(args...)...); err != nil {
+		return &Error{Step: "compose logs", Ref: c.ref(), Err: err}
+	}
+	return nil
+}
+
+// Down stops the services and removes them, their networks and volumes.
+func (c Compose) Down() error {
+	if err := sh.RunV("docker", c.args("down", "--volumes", "--remove-orphans")...); err != nil {
+		return &Error{Step: "compose down", Ref: c.ref(), Err: err}
+	}
+	return nil
+}
+
+// serviceState is a container in the output of docker compose ps.
+type serviceState struct {
+	Service  string
+	State    string
+	Health   string
+	ExitCode int
+}
+
+// ps returns the state of the services' containers.
+func (c Compose) ps(services ...string) ([]serviceState, error) {
+	args := append([]string{"ps", "--all", "--format", "json"}, services...)
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(nil, out, os.Stderr, "docker", c.args(args...)...); err != nil {
+		return nil, &Error{Step: "compose ps", Ref: c.ref(), Err: err}
+	}
+	// older versions print a JSON array, newer ones an object per line.
+	var states []serviceState
+	s := strings.TrimSpace(out.String())
+	if strings.HasPrefix(s, "[") {
+		if err := json.Unmarshal([]byte(s), &states); err != nil {
+			return nil, &Error{Step: "compose ps", Ref: c.ref(), Err: err}
+		}
+		return states, nil
+	}
+	for _, line := range strings.Split(s, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var state serviceState
+		if err := json.Unmarshal([]byte(line), &state); err != nil {
+			return nil, &Error{Step: "compose ps", Ref: c.ref(), Err: err}
+		}
+		states = append(states, state)
+	}
+	return states, nil
+}
+
+// args returns the args for docker to run the compose command.
+func (c Compose) args(args ...string) []string {
+	a := []string{"compose"}
+	for _, f := range c.Files {
+		a = append(a, "--file", f)
+	}
+	if c.Project != "" {
+		a = append(a, "--project-name", c.Project)
+	}
+	return append(a, args...)
+}
+
+// ref names the project in errors.
+func (c Compose) ref() string {
+	switch {
+	case c.Project != "":
+		return c.Project
+	case len(c.Files) > 0:
+		return c.Files[0]
+	default:
+		return "compose.yaml"
+	}
+}
@@ -0,0 +1,103 @@
+package dockerx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// psDir is where the fake docker looks for the compose ps responses.
+var psDir string
+
+// psResponses sets what the fake docker prints for each compose ps.
+func psResponses(t *testing.T, responses ...string) {
+	for i, r := range responses {
+		if err := ioutil.WriteFile(filepath.Join(psDir, fmt.Sprintf("ps.%d", i)), []byte(r), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func fastPoll() func() {
+	old := pollInterval
+	pollInterval = time.Millisecond
+	return func() { pollInterval = old }
+}
+
+func TestComposeUp(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer fastPoll()()
+	psResponses(t,
+		`{"Service":"db","State":"running","Health":"starting"}`+"\n"+`{"Service":"cache","State":"running","Health":""}`,
+		// older versions print an array.
+		`[{"Service":"db","State":"running","Health":"healthy"},{"Service":"cache","State":"running","Health":""},{"Service":"migrate","State":"exited","ExitCode":0}]`,
+	)
+	c := Compose{Files: []string{"test/compose.yaml"}, Project: "it"}
+	down, err := c.Up(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	base := "compose --file test/compose.yaml --project-name it "
+	expected := base + "up --detach\nbuildkit=\n" +
+		base + "ps --all --format json\nbuildkit=\n" +
+		base + "ps --all --format json\nbuildkit=\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+	down()
+	if out, expected := read(), base+"down --volumes --remove-orphans\nbuildkit=\n"; out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestComposeUpFailure(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer fastPoll()()
+
+	tests := []struct {
+		ps       string
+		timeout  time.Duration
+		expected string
+	}{
+		{
+			`{"Service":"db","State":"running","Health":"unhealthy"}`,
+			time.Minute,
+			"docker wait of compose.yaml failed: db is unhealthy",
+		},
+		{
+			`{"Service":"migrate","State":"exited","ExitCode":2}`,
+			time.Minute,
+			"docker wait of compose.yaml failed: migrate exited with code 2",
+		},
+		{
+			`{"Service":"web","State":"created"}` + "\n" + `{"Service":"db","State":"running","Health":"starting"}`,
+			10 * time.Millisecond,
+			"docker wait of compose.yaml failed: timed out after 10ms waiting for db, web",
+		},
+		{
+			"",
+			10 * time.Millisecond,
+			"docker wait of compose.yaml failed: timed out after 10ms waiting for the services to start",
+		},
+	}
+	for _, tt := range tests {
+		psResponses(t, tt.ps)
+		down, err := Compose{}.Up(tt.timeout, "db")
+		if down != nil {
+			t.Error("expected no down func when up fails")
+		}
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("expected %q but got %v", tt.expected, err)
+		}
+		// the logs are printed and the stack is torn down.
+		out := read()
+		tail := "compose logs --no-color db\nbuildkit=\ncompose down --volumes --remove-orphans\nbuildkit=\n"
+		if len(out) < len(tail) || out[len(out)-len(tail):] != tail {
+			t.Errorf("expected the logs and down after a failure but got %q", out)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+// Package dockerx builds and pushes container images with the docker CLI.
+// Builds use BuildKit unless DOCKER_BUILDKIT is set, and a command that fails
+// returns an *Error that says which step it was.
+package dockerx
+
+import (
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// BuildOptions are the options for Build.
+type BuildOptions struct {
+	// Context is the build context.  It defaults to the working directory.
+	Context string
+
+	// Dockerfile is the path to the Dockerfile, if it isn't the Dockerfile
+	// in the context.
+	Dockerfile string
+
+	// Tags are the names to tag the image with, e.g. example.com/app:v1.
+	Tags []string
+
+	// BuildArgs are the values of the Dockerfile's ARGs.
+	BuildArgs map[string]string
+
+	// Target is the stage of a multi-stage Dockerfile to build.
+	Target string
+
+	// Platform is the platform to build for, e.g. linux/arm64.
+	Platform string
+}
+
+// Error is the error from a docker command that failed.
+type Error struct {
+	// Step is the step that failed: build, tag, push or login.
+	Step string
+
+	// Ref is what the step was for, e.g. the image or the registry.
+	Ref string
+
+	// Err is the error from running docker.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("docker %s of %s failed: %v", e.Step, e.Ref, e.Err)
+}
+
+// ExitStatus returns the exit code docker failed with, so mage exits with it.
+func (e *Error) ExitStatus() int {
+	return mg.ExitStatus(e.Err)
+}
+
+// Build builds an image.
+func Build(opts BuildOptions) error {
+	context := opts.context()
+	args := append([]string{"build"}, opts.flags()...)
+	if opts.Platform != "" {
+		args = append(args, "--platform", opts.Platform)
+	}
+	args = append(args, context)
+
+	var env map[string]string
+	if _, ok := os.LookupEnv("DOCKER_BUILDKIT"); !ok {
+		env = map[string]string{"DOCKER_BUILDKIT": "1"}
+	}
+	if err := sh.RunWithV(env, "docker", args...); err != nil {
+		return &Error{Step: "build", Ref: context, Err: err}
+	}
+	return nil
+}
+
+// context returns the build context.
+func (o BuildOptions) context() string {
+	if o.Context == "" {
+		return "."
+	}
+	return o.Context
+}
+
+// flags returns the flags for the file, tags, build args and target.
+func (o BuildOptions) flags() []string {
+	var args []string
+	if o.Dockerfile != "" {
+		args = append(args, "--file", o.Dockerfile)
+	}
+	for _, tag := range o.Tags {
+		args = append(args, "--tag", tag)
+	}
+	// the build args are sorted so the command is the same every time.
+	names := make([]string, 0, len(o.BuildArgs))
+	for name := range o.BuildArgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		args = append(args, "--build-arg", name+"="+o.BuildArgs[name])
+	}
+	if o.Target != "" {
+		args = append(args, "--target", o.Target)
+	}
+	return args
+}
+
+// Tag gives the image another name.
+func Tag(image, tag string) error {
+	if err := sh.RunV("docker", "tag", image, tag); err != nil {
+		return &Error{Step: "tag", Ref: image, Err: err}
+	}
+	return nil
+}
+
+// Push pushes the images to their registries, in order.
+func Push(images ...string) error {
+	for _, image := range images {
+		if err := sh.RunV("docker", "push", image); err != nil {
+			return &Error{Step: "push", Ref: image, Err: err}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,199 @@
+package dockerx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/mg"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"docker": docker, "aws": aws})
+	os.Exit(m.Run())
+}
+
+// docker stands in for docker.  It appends its args, DOCKER_BUILDKIT and its
+// stdin to the file log next to it, and exits with $FAKE_DOCKER_EXIT, or 1
+// from buildx inspect if $FAKE_BUILDX_MISSING is set.  For compose ps, it
+// prints the responses set with psResponses in turn, repeating the last.
+func docker(args []string) int {
+	dir := faketool.Dir()
+	logfile := filepath.Join(dir, "log")
+	faketool.Append(logfile, strings.Join(args, " "))
+	faketool.Append(logfile, "buildkit="+os.Getenv("DOCKER_BUILDKIT"))
+	code, _ := strconv.Atoi(os.Getenv("FAKE_DOCKER_EXIT"))
+	if len(args) > 0 && args[0] == "login" {
+		b, _ := ioutil.ReadAll(os.Stdin)
+		pw := strings.TrimRight(string(b), "\n")
+		faketool.Append(logfile, "stdin="+pw)
+		fmt.Fprintln(os.Stderr, "using password "+pw)
+		if code == 0 && os.Getenv("FAKE_DOCKER_LOGIN_FAILS") == "" {
+			fmt.Println("Login Succeeded")
+		}
+	}
+	if len(args) > 1 && args[0] == "buildx" && args[1] == "inspect" && os.Getenv("FAKE_BUILDX_MISSING") != "" {
+		return 1
+	}
+	for _, arg := range args {
+		if arg != "ps" {
+			continue
+		}
+		responses, _ := filepath.Glob(filepath.Join(dir, "ps.*"))
+		if len(responses) > 0 {
+			faketool.Cat(responses[0])
+		}
+		if len(responses) > 1 {
+			os.Remove(responses[0])
+		}
+		break
+	}
+	return code
+}
+
+// aws stands in for aws, printing a token for ecr get-login-password in
+// eu-west-1, and failing otherwise.
+func aws(args []string) int {
+	if strings.Join(args, " ") != "ecr get-login-password --region eu-west-1" {
+		return 1
+	}
+	fmt.Println("ecr-token")
+	return 0
+}
+
+// fakeDocker puts a fake docker on the PATH, as docker describes.  It returns
+// a func that reads the log, and one that restores the PATH and removes it.
+func fakeDocker(t *testing.T) (func() string, func()) {
+	dir, cleanup := faketool.OnPath(t, "docker")
+	psDir = dir
+	return func() string { return faketool.Log(dir) }, cleanup
+}
+
+// setenv sets the environment variable, and returns a func that restores it.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+func TestBuild(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("DOCKER_BUILDKIT", "")()
+	os.Unsetenv("DOCKER_BUILDKIT")
+
+	err := Build(BuildOptions{
+		Context:    "app",
+		Dockerfile: "app/Dockerfile.prod",
+		Tags:       []string{"example.com/app:v1", "example.com/app:latest"},
+		BuildArgs:  map[string]string{"VERSION": "v1", "COMMIT": "abc"},
+		Target:     "release",
+		Platform:   "linux/arm64",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "build --file app/Dockerfile.prod --tag example.com/app:v1 --tag example.com/app:latest " +
+		"--build-arg COMMIT=abc --build-arg VERSION=v1 --target release --platform linux/arm64 app\nbuildkit=1\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	// BuildKit can be turned off.
+	os.Setenv("DOCKER_BUILDKIT", "0")
+	if err := Build(BuildOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if out, expected := read(), "build .\nbuildkit=0\n"; out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestTagPushLogin(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("DOCKER_BUILDKIT", "")()
+	os.Unsetenv("DOCKER_BUILDKIT")
+
+	if err := Tag("app:v1", "example.com/app:v1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push("example.com/app:v1", "example.com/app:latest"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Login("example.com", "bot", "s3cret"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "tag app:v1 example.com/app:v1\nbuildkit=\n" +
+		"push example.com/app:v1\nbuildkit=\n" +
+		"push example.com/app:latest\nbuildkit=\n" +
+		"login --username bot --password-stdin example.com\nbuildkit=\nstdin=s3cret\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("FAKE_DOCKER_EXIT", "3")()
+
+	tests := []struct {
+		run      func() error
+		step     string
+		ref      string
+		expected string
+	}{
+		{
+			func() error { return Build(BuildOptions{Context: "app"}) },
+			"build", "app",
+			`docker build of app failed: running "docker build app" failed with exit code 3`,
+		},
+		{
+			func() error { return Tag("app:v1", "app:v2") },
+			"tag", "app:v1",
+			`docker tag of app:v1 failed: running "docker tag app:v1 app:v2" failed with exit code 3`,
+		},
+		{
+			func() error { return Push("app:v1", "app:v2") },
+			"push", "app:v1",
+			`docker push of app:v1 failed: running "docker push app:v1" failed with exit code 3`,
+		},
+		{
+			func() error { return Login("example.com", "bot", "s3cret") },
+			"login", "example.com",
+			`docker login of example.com failed: running "docker login --username bot --password-stdin example.com" failed with exit code 3`,
+		},
+	}
+	for _, tt := range tests {
+		err := tt.run()
+		e, ok := err.(*Error)
+		if !ok {
+			t.Fatalf("expected an *Error but got %T: %v", err, err)
+		}
+		if e.Step != tt.step || e.Ref != tt.ref {
+			t.Errorf("expected the %s of %s to fail but got the %s of %s", tt.step, tt.ref, e.Step, e.Ref)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected %q but got %q", tt.expected, err)
+		}
+		if code := mg.ExitStatus(err); code != 3 {
+			t.Errorf("%s: expected exit status 3 but got %d", tt.step, code)
+		}
+	}
+	// pushing stops at the first image that fails.
+	if out := read(); strings.Contains(out, "push app:v2") {
+		t.Fatalf("expected push to stop at the first failure but got %q", out)
+	}
+}
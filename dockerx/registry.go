@@ -0,0 +1,111 @@
+package dockerx
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Credentials are the username and password, or token, for a registry.
+type Credentials struct {
+	Username string
+	Password string
+}
+
+// CredentialsFunc gets the credentials for a registry, e.g. from the
+// environment or a secrets manager.
+type CredentialsFunc func() (Credentials, error)
+
+// EnvCredentials returns a CredentialsFunc that reads the username and
+// password from the environment variables.
+func EnvCredentials(usernameVar, passwordVar string) CredentialsFunc {
+	return func() (Credentials, error) {
+		for _, v := range []string{usernameVar, passwordVar} {
+			if os.Getenv(v) == "" {
+				return Credentials{}, fmt.Errorf("$%s is not set", v)
+			}
+		}
+		return Credentials{Username: os.Getenv(usernameVar), Password: os.Getenv(passwordVar)}, nil
+	}
+}
+
+// GHCRCredentials returns a CredentialsFunc for the GitHub container registry,
+// ghcr.io, that reads them from $GITHUB_ACTOR and $GITHUB_TOKEN, which GitHub
+// Actions sets.
+func GHCRCredentials() CredentialsFunc {
+	return EnvCredentials("GITHUB_ACTOR", "GITHUB_TOKEN")
+}
+
+// ECRCredentials returns a CredentialsFunc for an Amazon ECR registry in the
+// region, which gets a token with the aws CLI, using its usual credentials.
+func ECRCredentials(region string) CredentialsFunc {
+	return func() (Credentials, error) {
+		out := &bytes.Buffer{}
+		if _, err := sh.Exec(nil, out, os.Stderr, "aws", "ecr", "get-login-password", "--region", region); err != nil {
+			return Credentials{}, err
+		}
+		return Credentials{Username: "AWS", Password: strings.TrimSpace(out.String())}, nil
+	}
+}
+
+// LoginWith logs in to the registry with the credentials the func gets.
+//
+//	func Push() error {
+//		if err := dockerx.LoginWith("ghcr.io", dockerx.GHCRCredentials()); err != nil {
+//			return err
+//		}
+//		return dockerx.Push("ghcr.io/example/app:" + version)
+//	}
+func LoginWith(registry string, creds CredentialsFunc) error {
+	c, err := creds()
+	if err != nil {
+		return &Error{Step: "login", Ref: registry, Err: fmt.Errorf("failed to get credentials: %v", err)}
+	}
+	return Login(registry, c.Username, c.Password)
+}
+
+// Login logs in to the registry.  The password is given to docker on stdin,
+// so it isn't in the command line where other processes can see it, and it's
+// masked in docker's output and errors.  It fails unless docker says the login
+// succeeded.
+func Login(registry, username, password string) error {
+	if username == "" || password == "" {
+		return &Error{Step: "login", Ref: registry, Err: errors.New("the username and password are required")}
+	}
+	args := []string{"login", "--username", username, "--password-stdin", registry}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	c := exec.Command("docker", args...)
+	c.Stdin = strings.NewReader(password)
+	c.Stdout = stdout
+	c.Stderr = stderr
+	log.Println("exec: docker", strings.Join(args, " "))
+	err := c.Run()
+	os.Stdout.WriteString(mask(stdout.String(), password))
+	os.Stderr.WriteString(mask(stderr.String(), password))
+	if err != nil {
+		if sh.CmdRan(err) {
+			code := sh.ExitStatus(err)
+			err = mg.Fatalf(code, `running "docker %s" failed with exit code %d`, strings.Join(args, " "), code)
+		} else {
+			err = errors.New(mask(err.Error(), password))
+		}
+		return &Error{Step: "login", Ref: registry, Err: err}
+	}
+	if !strings.Contains(stdout.String(), "Login Succeeded") {
+		return &Error{Step: "login", Ref: registry, Err: errors.New("docker didn't say the login succeeded")}
+	}
+	return nil
+}
+
+// mask hides the secret in s.
+func mask(s, secret string) string {
+	return strings.Replace(s, secret, "***", -1)
+}
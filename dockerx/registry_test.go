@@ -0,0 +1,87 @@
+package dockerx
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+// captureStderr sends os.Stderr to a file, and returns a func that restores it
+// and returns what was written.
+func captureStderr(t *testing.T) func() string {
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old := os.Stderr
+	os.Stderr = f
+	return func() string {
+		os.Stderr = old
+		f.Close()
+		defer os.Remove(f.Name())
+		b, _ := ioutil.ReadFile(f.Name())
+		return string(b)
+	}
+}
+
+func TestLoginWith(t *testing.T) {
+	read, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("GITHUB_ACTOR", "octocat")()
+	defer setenv("GITHUB_TOKEN", "ghp_s3cret")()
+
+	stderr := captureStderr(t)
+	err := LoginWith("ghcr.io", GHCRCredentials())
+	if out := stderr(); out != "using password ***\n" {
+		t.Errorf("expected the password to be masked but got %q", out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "login --username octocat --password-stdin ghcr.io\nbuildkit=" + os.Getenv("DOCKER_BUILDKIT") + "\nstdin=ghp_s3cret\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	os.Unsetenv("GITHUB_TOKEN")
+	err = LoginWith("ghcr.io", GHCRCredentials())
+	if err == nil || err.Error() != "docker login of ghcr.io failed: failed to get credentials: $GITHUB_TOKEN is not set" {
+		t.Fatalf("expected a missing credentials error but got %v", err)
+	}
+}
+
+func TestLoginFailure(t *testing.T) {
+	_, cleanup := fakeDocker(t)
+	defer cleanup()
+	defer setenv("FAKE_DOCKER_LOGIN_FAILS", "1")()
+
+	stderr := captureStderr(t)
+	err := Login("example.com", "bot", "s3cret")
+	stderr()
+	if err == nil || err.Error() != "docker login of example.com failed: docker didn't say the login succeeded" {
+		t.Fatalf("expected the login not to succeed but got %v", err)
+	}
+	err = Login("example.com", "bot", "")
+	if err == nil || err.Error() != "docker login of example.com failed: the username and password are required" {
+		t.Fatalf("expected a missing password error but got %v", err)
+	}
+}
+
+func TestECRCredentials(t *testing.T) {
+	_, cleanup := faketool.OnPath(t, "aws")
+	defer cleanup()
+
+	c, err := ECRCredentials("eu-west-1")()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c != (Credentials{Username: "AWS", Password: "ecr-token"}) {
+		t.Fatalf("expected the token from aws but got %+v", c)
+	}
+	if _, err := ECRCredentials("us-east-1")(); err == nil || !strings.Contains(err.Error(), "exit code 1") {
+		t.Fatalf("expected aws to fail but got %v", err)
+	}
+}
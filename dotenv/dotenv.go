@@ -0,0 +1,242 @@
+// Package dotenv reads, changes and writes dotenv files, the KEY=VALUE files
+// like the .env that mage loads before running targets.  A File keeps every
+// line it was read from, so a file that's changed and written back keeps its
+// comments and order, and package Export writes variables for other tools,
+// like direnv or a devcontainer.
+package dotenv
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/magefile/mage/fsutil"
+)
+
+// File is a dotenv file.
+//
+// Each line is KEY=VALUE, optionally preceded by "export ".  Blank lines and
+// lines starting with # are comments.  Values may be single quoted, which are
+// taken literally, or double quoted, in which \n, \t, \" and \\ are unescaped.
+// Unquoted values have surrounding whitespace and any " #" comment removed.
+type File struct {
+	lines []line
+}
+
+// line is a line of a file, and the variable it sets, if any.
+type line struct {
+	text  string
+	key   string
+	value string
+}
+
+// Read reads the dotenv file at path.  If it doesn't exist, the error
+// satisfies os.IsNotExist.
+func Read(path string) (*File, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Parse(f, path)
+}
+
+// Parse parses a dotenv file.  The name is only used in errors.
+func Parse(r io.Reader, name string) (*File, error) {
+	f := &File{}
+	scanner := bufio.NewScanner(r)
+	for lineno := 1; scanner.Scan(); lineno++ {
+		text := scanner.Text()
+		l := line{text: text}
+		s := strings.TrimSpace(text)
+		if s == "" || strings.HasPrefix(s, "#") {
+			f.lines = append(f.lines, l)
+			continue
+		}
+		s = strings.TrimSpace(strings.TrimPrefix(s, "export "))
+		i := strings.Index(s, "=")
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: expected KEY=VALUE", name, lineno)
+		}
+		l.key = strings.TrimSpace(s[:i])
+		if strings.ContainsAny(l.key, " \t") {
+			return nil, fmt.Errorf("%s:%d: invalid variable name %q", name, lineno, l.key)
+		}
+		val, err := parseValue(strings.TrimSpace(s[i+1:]))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, lineno, err)
+		}
+		l.value = val
+		f.lines = append(f.lines, l)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// parseValue returns the value of a variable from the text after the =.
+func parseValue(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+	switch quote := s[0]; quote {
+	case '\'', '"':
+		end := -1
+		for i := 1; i < len(s); i++ {
+			if quote == '"' && s[i] == '\\' {
+				i++
+				continue
+			}
+			if s[i] == quote {
+				end = i
+				break
+			}
+		}
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quoted value %s", s)
+		}
+		if rest := strings.TrimSpace(s[end+1:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %q after quoted value", rest)
+		}
+		val := s[1:end]
+		if quote == '"' {
+			val = strings.NewReplacer(`\n`, "\n", `\t`, "\t", `\"`, `"`, `\\`, `\`).Replace(val)
+		}
+		return val, nil
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = s[:i]
+	}
+	return strings.TrimSpace(s), nil
+}
+
+// Vars returns the variables in the file, as key/value pairs in the order
+// they're set.  A variable that's set twice is in it twice.
+func (f *File) Vars() [][2]string {
+	var vars [][2]string
+	for _, l := range f.lines {
+		if l.key != "" {
+			vars = append(vars, [2]string{l.key, l.value})
+		}
+	}
+	return vars
+}
+
+// Map returns the variables in the file.  If a variable is set twice, the
+// last value wins, as when the file is loaded.
+func (f *File) Map() map[string]string {
+	m := map[string]string{}
+	for _, l := range f.lines {
+		if l.key != "" {
+			m[l.key] = l.value
+		}
+	}
+	return m
+}
+
+// Get returns the value of the variable, and whether it's set.
+func (f *File) Get(key string) (string, bool) {
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		if f.lines[i].key == key {
+			return f.lines[i].value, true
+		}
+	}
+	return "", false
+}
+
+// Set sets the variable.  If it's already set, its last line is changed,
+// keeping an "export " before it, otherwise a line is added at the end.
+func (f *File) Set(key, value string) {
+	for i := len(f.lines) - 1; i >= 0; i-- {
+		l := &f.lines[i]
+		if l.key != key {
+			continue
+		}
+		if l.value != value {
+			prefix := ""
+			if strings.HasPrefix(strings.TrimSpace(l.text), "export ") {
+				prefix = "export "
+			}
+			l.text = prefix + key + "=" + Quote(value)
+			l.value = value
+		}
+		return
+	}
+	f.lines = append(f.lines, line{text: key + "=" + Quote(value), key: key, value: value})
+}
+
+// Delete removes the lines that set the variable.
+func (f *File) Delete(key string) {
+	lines := f.lines[:0]
+	for _, l := range f.lines {
+		if l.key != key {
+			lines = append(lines, l)
+		}
+	}
+	f.lines = lines
+}
+
+// Merge sets the variables from other, in the order they're set in it, so a
+// file's values override the defaults in another:
+//
+//	env, _ := dotenv.Read(".env")
+//	local, _ := dotenv.Read(".env.local")
+//	env.Merge(local)
+func (f *File) Merge(other *File) {
+	for _, kv := range other.Vars() {
+		f.Set(kv[0], kv[1])
+	}
+}
+
+// Bytes returns the text of the file.
+func (f *File) Bytes() []byte {
+	var b bytes.Buffer
+	for _, l := range f.lines {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	return b.Bytes()
+}
+
+// Write writes the file to path with fsutil.WriteFile, so it's never half
+// written.
+func (f *File) Write(path string) error {
+	return fsutil.WriteFile(path, f.Bytes(), 0644)
+}
+
+// Load returns the variables set in the dotenv files, where a variable in a
+// later file overrides one from an earlier file, as mage loads .env and
+// .env.local.  Missing files are ignored.
+func Load(paths ...string) (map[string]string, error) {
+	vars := map[string]string{}
+	for _, path := range paths {
+		f, err := Read(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range f.Map() {
+			vars[k] = v
+		}
+	}
+	return vars, nil
+}
+
+// Quote returns the value as it's written in a dotenv file: as it is if it
+// has nothing that needs quoting, in single quotes if it can be, since other
+// tools take those literally too, and in double quotes otherwise.
+func Quote(value string) string {
+	if value != "" && strings.Trim(value, "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789_-.,/:@+=%") == "" {
+		return value
+	}
+	if !strings.ContainsAny(value, "'\n\t") {
+		return "'" + value + "'"
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "\t", `\t`).Replace(value) + `"`
+}
@@ -0,0 +1,127 @@
+package dotenv
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const example = `# shared settings
+REGISTRY=docker.io/example
+export TAG=dev               # "export" is optional
+
+GREETING="hello\nworld"
+PATTERN='*.go #1'
+TAG=v1
+`
+
+func TestParse(t *testing.T) {
+	f, err := Parse(strings.NewReader(example), ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := [][2]string{
+		{"REGISTRY", "docker.io/example"},
+		{"TAG", "dev"},
+		{"GREETING", "hello\nworld"},
+		{"PATTERN", "*.go #1"},
+		{"TAG", "v1"},
+	}
+	if vars := f.Vars(); !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("expected %q, got %q", expected, vars)
+	}
+	if v, ok := f.Get("TAG"); !ok || v != "v1" {
+		t.Fatalf("expected the last TAG, got %q", v)
+	}
+	if _, ok := f.Get("NOPE"); ok {
+		t.Fatal("expected NOPE not to be set")
+	}
+	if string(f.Bytes()) != example {
+		t.Fatalf("expected the file unchanged, got %q", f.Bytes())
+	}
+
+	for _, s := range []string{"NOPE", "A B=1", `A="open`, `A='x' y`} {
+		if _, err := Parse(strings.NewReader("# ok\n"+s+"\n"), ".env"); err == nil || !strings.HasPrefix(err.Error(), ".env:2: ") {
+			t.Errorf("expected an error on line 2 for %q, got %v", s, err)
+		}
+	}
+}
+
+func TestSet(t *testing.T) {
+	f, err := Parse(strings.NewReader(example), ".env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("REGISTRY", "docker.io/example")
+	f.Set("TAG", "it's")
+	f.Set("GREETING", "hi")
+	f.Set("NEW", "")
+	f.Delete("PATTERN")
+	expected := `# shared settings
+REGISTRY=docker.io/example
+export TAG=dev               # "export" is optional
+
+GREETING=hi
+TAG="it's"
+NEW=''
+`
+	if string(f.Bytes()) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, f.Bytes())
+	}
+
+	other, _ := Parse(strings.NewReader("TAG=v2\nEXTRA=$HOME\n"), ".env.local")
+	f.Merge(other)
+	m := f.Map()
+	if m["TAG"] != "v2" || m["EXTRA"] != "$HOME" || m["REGISTRY"] != "docker.io/example" {
+		t.Fatalf("unexpected vars after merge %q", m)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	for _, v := range []string{"", "plain", "a b", "$HOME", "it's", "two\nlines", `back\slash "quoted"`, "tab\there"} {
+		f, err := Parse(strings.NewReader("V="+Quote(v)+"\n"), "quoted")
+		if err != nil {
+			t.Fatalf("can't parse %q: %v", Quote(v), err)
+		}
+		if got, _ := f.Get("V"); got != v {
+			t.Errorf("expected %q back from %s, got %q", v, Quote(v), got)
+		}
+	}
+	if q := Quote("a b"); q != "'a b'" {
+		t.Fatalf("expected single quotes, got %s", q)
+	}
+}
+
+func TestReadWriteLoad(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	env := filepath.Join(dir, ".env")
+	local := filepath.Join(dir, ".env.local")
+	ioutil.WriteFile(env, []byte(example), 0644)
+
+	f, err := Read(env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Set("TAG", "local")
+	if err := f.Write(local); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Read(filepath.Join(dir, "missing")); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error, got %v", err)
+	}
+
+	vars, err := Load(env, filepath.Join(dir, "missing"), local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vars) != 4 || vars["TAG"] != "local" || vars["GREETING"] != "hello\nworld" {
+		t.Fatalf("unexpected vars %q", vars)
+	}
+}
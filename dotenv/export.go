@@ -0,0 +1,72 @@
+package dotenv
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Format is a format Export writes variables in.
+type Format string
+
+const (
+	// Dotenv is a dotenv file, e.g. for docker compose's env_file.
+	Dotenv Format = "dotenv"
+
+	// Shell is a shell script of export statements, e.g. a .envrc for
+	// direnv.
+	Shell Format = "shell"
+
+	// JSON is a JSON object, e.g. for the containerEnv or remoteEnv of a
+	// devcontainer.json.
+	JSON Format = "json"
+)
+
+// Export writes the variables to w in the format, sorted by name, so what's
+// written only changes when they do.  It's meant for generating files other
+// tools read from the build's configuration:
+//
+//	var b bytes.Buffer
+//	if err := dotenv.Export(&b, env, dotenv.Shell); err != nil {
+//		return err
+//	}
+//	return fsutil.WriteFile(".envrc", b.Bytes(), 0644)
+func Export(w io.Writer, vars map[string]string, format Format) error {
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	switch format {
+	case Dotenv:
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(w, "%s=%s\n", k, Quote(vars[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case Shell:
+		for _, k := range keys {
+			if _, err := fmt.Fprintf(w, "export %s=%s\n", k, shellQuote(vars[k])); err != nil {
+				return err
+			}
+		}
+		return nil
+	case JSON:
+		b, err := json.MarshalIndent(vars, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "%s\n", b)
+		return err
+	default:
+		return fmt.Errorf("unknown format %q, the formats are: dotenv, shell, json", format)
+	}
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,30 @@
+package dotenv
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestExport(t *testing.T) {
+	vars := map[string]string{"TAG": "dev", "GREETING": "it's me", "EMPTY": ""}
+	tests := []struct {
+		format   Format
+		expected string
+	}{
+		{Dotenv, "EMPTY=''\nGREETING=\"it's me\"\nTAG=dev\n"},
+		{Shell, "export EMPTY=''\nexport GREETING='it'\\''s me'\nexport TAG='dev'\n"},
+		{JSON, "{\n  \"EMPTY\": \"\",\n  \"GREETING\": \"it's me\",\n  \"TAG\": \"dev\"\n}\n"},
+	}
+	for _, tt := range tests {
+		var b bytes.Buffer
+		if err := Export(&b, vars, tt.format); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.expected {
+			t.Errorf("%s: expected %q, got %q", tt.format, tt.expected, b.String())
+		}
+	}
+	if err := Export(&bytes.Buffer{}, vars, "yaml"); err == nil || err.Error() != `unknown format "yaml", the formats are: dotenv, shell, json` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,192 @@
+// Package downloads installs prebuilt binaries, like protoc or helm, from their
+// release downloads.  The download for the current OS and architecture is
+// found from a URL template, checked against its checksum, extracted, and
+// kept in a cache shared by every project, so it's only downloaded once.
+package downloads
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Binary is a binary that's published as a release download.
+type Binary struct {
+	// Name is the name of the binary.
+	Name string
+
+	// Version is the version to download.
+	Version string
+
+	// URL is the template for the URL of the download, in which {version},
+	// {os} and {arch} are replaced with the version and the release's names
+	// for the OS and architecture, e.g.
+	//
+	//	https://github.com/protocolbuffers/protobuf/releases/download/v{version}/protoc-{version}-{os}-{arch}.zip
+	//
+	// Downloads that end in .zip, .tar.gz or .tgz are extracted, anything else
+	// is the binary itself.
+	URL string
+
+	// Path is the template for the path of the binary in the archive, e.g.
+	// "bin/protoc".  It defaults to the Name, with .exe on windows.
+	Path string
+
+	// OS maps a GOOS to the release's name for it, when they're different,
+	// e.g. {"darwin": "osx"}.
+	OS map[string]string
+
+	// Arch maps a GOARCH to the release's name for it, when they're
+	// different, e.g. {"amd64": "x86_64"}.
+	Arch map[string]string
+
+	// Checksums are the hex encoded sha256 sums of the downloads, by platform,
+	// e.g. {"linux/amd64": "..."}.  If there are any, downloads for a platform
+	// without one are refused.
+	Checksums map[string]string
+}
+
+// downloadMu keeps binaries from being downloaded twice at once by targets
+// running in parallel.
+var downloadMu sync.Mutex
+
+// Ensure downloads the binary for the current OS and architecture, unless it's
+// already in the cache, and returns the path to it.
+func (b Binary) Ensure() (string, error) {
+	if b.Name == "" || b.Version == "" || b.URL == "" {
+		return "", fmt.Errorf("download %q needs a name, a version and a URL", b.Name)
+	}
+	dir := filepath.Join(mg.CacheDir(), "downloads", b.Name, b.Version, runtime.GOOS+"_"+runtime.GOARCH)
+	path := filepath.Join(dir, filepath.FromSlash(b.binPath()))
+	if fileExists(path) {
+		return path, nil
+	}
+
+	downloadMu.Lock()
+	defer downloadMu.Unlock()
+	if fileExists(path) {
+		return path, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	// the download is extracted to a temporary directory and moved into place,
+	// so one that fails part way isn't mistaken for a good one.
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".download-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := b.download(tmp); err != nil {
+		return "", err
+	}
+	bin := filepath.Join(tmp, filepath.FromSlash(b.binPath()))
+	if !fileExists(bin) {
+		return "", fmt.Errorf("%s is not in the download %s", b.binPath(), b.URLFor(runtime.GOOS, runtime.GOARCH))
+	}
+	if err := os.Chmod(bin, 0755); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// URLFor returns the URL of the download for the given OS and architecture.
+func (b Binary) URLFor(goos, goarch string) string {
+	return b.expand(b.URL, goos, goarch)
+}
+
+// download fetches the download for the current platform, checks its
+// checksum, and extracts it into dir.
+func (b Binary) download(dir string) error {
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+	url := b.URLFor(runtime.GOOS, runtime.GOARCH)
+	sum, ok := b.Checksums[platform]
+	if len(b.Checksums) > 0 && !ok {
+		return fmt.Errorf("no checksum for the %s download of %s %s", platform, b.Name, b.Version)
+	}
+	log.Printf("downloading %s", url)
+	resp, err := http.Get(url)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", url, resp.Status)
+	}
+	f, err := ioutil.TempFile(dir, ".archive-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(f, h), resp.Body); err != nil {
+		return fmt.Errorf("failed to download %s: %v", url, err)
+	}
+	if ok && !strings.EqualFold(hex.EncodeToString(h.Sum(nil)), sum) {
+		return fmt.Errorf("checksum of %s is %x, expected %s", url, h.Sum(nil), sum)
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	name := strings.ToLower(strings.SplitN(url, "?", 2)[0])
+	switch {
+	case strings.HasSuffix(name, ".zip"):
+		return extractZip(f, dir)
+	case strings.HasSuffix(name, ".tar.gz"), strings.HasSuffix(name, ".tgz"):
+		return extractTarGz(f, dir)
+	default:
+		out, err := os.Create(filepath.Join(dir, filepath.FromSlash(b.binPath())))
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(out, f); err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+}
+
+// binPath returns the slash separated path of the binary in the download for
+// the current platform.
+func (b Binary) binPath() string {
+	if b.Path != "" {
+		return b.expand(b.Path, runtime.GOOS, runtime.GOARCH)
+	}
+	if runtime.GOOS == "windows" {
+		return b.Name + ".exe"
+	}
+	return b.Name
+}
+
+// expand replaces the placeholders in the template.
+func (b Binary) expand(tmpl, goos, goarch string) string {
+	if s, ok := b.OS[goos]; ok {
+		goos = s
+	}
+	if s, ok := b.Arch[goarch]; ok {
+		goarch = s
+	}
+	return strings.NewReplacer("{version}", b.Version, "{os}", goos, "{arch}", goarch).Replace(tmpl)
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
@@ -0,0 +1,260 @@
+package downloads
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/mg"
+)
+
+// cacheDir points the mage cache at a new temporary directory, and returns a
+// func that restores it.
+func cacheDir(t *testing.T) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, ok := os.LookupEnv(mg.CacheEnv)
+	os.Setenv(mg.CacheEnv, dir)
+	return func() {
+		if ok {
+			os.Setenv(mg.CacheEnv, old)
+		} else {
+			os.Unsetenv(mg.CacheEnv)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+// server serves the files, and counts the requests for each.
+func server(files map[string][]byte) (*httptest.Server, map[string]int) {
+	hits := map[string]int{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits[r.URL.Path]++
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	return srv, hits
+}
+
+func makeZip(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	z := zip.NewWriter(buf)
+	for name, s := range files {
+		w, err := z.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte(s))
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func makeTarGz(t *testing.T, files map[string]string) []byte {
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gz)
+	for name, s := range files {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(s)), Typeflag: tar.TypeReg}); err != nil {
+			t.Fatal(err)
+		}
+		tw.Write([]byte(s))
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func sum(b []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(b))
+}
+
+func exe(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+func TestURLFor(t *testing.T) {
+	b := Binary{
+		Version: "25.1",
+		URL:     "https://example.com/v{version}/protoc-{version}-{os}-{arch}.zip",
+		OS:      map[string]string{"darwin": "osx"},
+		Arch:    map[string]string{"amd64": "x86_64", "arm64": "aarch_64"},
+	}
+	tests := map[[2]string]string{
+		{"darwin", "arm64"}: "https://example.com/v25.1/protoc-25.1-osx-aarch_64.zip",
+		{"linux", "amd64"}:  "https://example.com/v25.1/protoc-25.1-linux-x86_64.zip",
+		{"linux", "386"}:    "https://example.com/v25.1/protoc-25.1-linux-386.zip",
+	}
+	for platform, expected := range tests {
+		if url := b.URLFor(platform[0], platform[1]); url != expected {
+			t.Errorf("%s/%s: expected %q but got %q", platform[0], platform[1], expected, url)
+		}
+	}
+}
+
+func TestEnsureZip(t *testing.T) {
+	defer cacheDir(t)()
+	archive := makeZip(t, map[string]string{
+		"bin/" + exe("protoc"):     "protoc binary",
+		"include/google/any.proto": "syntax",
+	})
+	file := fmt.Sprintf("/protoc-1.0-%s-%s.zip", runtime.GOOS, runtime.GOARCH)
+	srv, hits := server(map[string][]byte{file: archive})
+	defer srv.Close()
+
+	b := Binary{
+		Name:      "protoc",
+		Version:   "1.0",
+		URL:       srv.URL + "/protoc-{version}-{os}-{arch}.zip",
+		Path:      "bin/" + exe("protoc"),
+		Checksums: map[string]string{runtime.GOOS + "/" + runtime.GOARCH: strings.ToUpper(sum(archive))},
+	}
+	path, err := b.Ensure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := filepath.Join(mg.CacheDir(), "downloads", "protoc", "1.0", runtime.GOOS+"_"+runtime.GOARCH, "bin", exe("protoc"))
+	if path != expected {
+		t.Fatalf("expected %q but got %q", expected, path)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "protoc binary" {
+		t.Fatalf("expected the binary from the archive but got %q", b)
+	}
+	if runtime.GOOS != "windows" {
+		if fi, err := os.Stat(path); err != nil || fi.Mode()&0111 == 0 {
+			t.Fatalf("expected %s to be executable", path)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(path), "..", "include", "google", "any.proto")); err != nil {
+		t.Fatal("expected the rest of the archive to be extracted:", err)
+	}
+
+	// the second time it comes from the cache.
+	if _, err := b.Ensure(); err != nil {
+		t.Fatal(err)
+	}
+	if hits[file] != 1 {
+		t.Fatalf("expected 1 download but got %d", hits[file])
+	}
+}
+
+func TestEnsureTarGz(t *testing.T) {
+	defer cacheDir(t)()
+	archive := makeTarGz(t, map[string]string{
+		"linux-amd64/helm":    "helm binary",
+		"linux-amd64/LICENSE": "license",
+	})
+	srv, _ := server(map[string][]byte{"/helm-v3.tgz": archive})
+	defer srv.Close()
+
+	b := Binary{
+		Name:    "helm",
+		Version: "v3",
+		URL:     srv.URL + "/helm-{version}.tgz",
+		Path:    "linux-amd64/helm",
+	}
+	path, err := b.Ensure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "helm binary" {
+		t.Fatalf("expected the binary from the archive but got %q", b)
+	}
+}
+
+func TestEnsureBinary(t *testing.T) {
+	defer cacheDir(t)()
+	srv, _ := server(map[string][]byte{"/jq-1.7": []byte("jq binary")})
+	defer srv.Close()
+
+	path, err := Binary{Name: "jq", Version: "1.7", URL: srv.URL + "/jq-{version}"}.Ensure()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filepath.Base(path) != exe("jq") {
+		t.Fatalf("expected the binary to be named %s but got %s", exe("jq"), path)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "jq binary" {
+		t.Fatalf("expected the download but got %q", b)
+	}
+}
+
+func TestEnsureErrors(t *testing.T) {
+	defer cacheDir(t)()
+	archive := makeZip(t, map[string]string{"tool": "tool binary"})
+	srv, _ := server(map[string][]byte{
+		"/tool.zip": archive,
+		"/evil.zip": makeZip(t, map[string]string{"../evil": "evil"}),
+	})
+	defer srv.Close()
+	platform := runtime.GOOS + "/" + runtime.GOARCH
+
+	tests := []struct {
+		desc     string
+		binary   Binary
+		expected string
+	}{
+		{
+			desc:     "bad checksum",
+			binary:   Binary{Name: "tool", Version: "1", URL: srv.URL + "/tool.zip", Path: "tool", Checksums: map[string]string{platform: "abc"}},
+			expected: fmt.Sprintf("checksum of %s/tool.zip is %s, expected abc", srv.URL, sum(archive)),
+		},
+		{
+			desc:     "no checksum for platform",
+			binary:   Binary{Name: "tool", Version: "1", URL: srv.URL + "/tool.zip", Path: "tool", Checksums: map[string]string{"plan9/mips": "abc"}},
+			expected: fmt.Sprintf("no checksum for the %s download of tool 1", platform),
+		},
+		{
+			desc:     "not found",
+			binary:   Binary{Name: "tool", Version: "1", URL: srv.URL + "/missing.zip"},
+			expected: fmt.Sprintf("failed to download %s/missing.zip: 404 Not Found", srv.URL),
+		},
+		{
+			desc:     "binary not in archive",
+			binary:   Binary{Name: "tool", Version: "1", URL: srv.URL + "/tool.zip", Path: "bin/tool"},
+			expected: fmt.Sprintf("bin/tool is not in the download %s/tool.zip", srv.URL),
+		},
+		{
+			desc:     "entry outside archive",
+			binary:   Binary{Name: "evil", Version: "1", URL: srv.URL + "/evil.zip", Path: "evil"},
+			expected: `archive entry "../evil" is outside the archive`,
+		},
+		{
+			desc:     "missing URL",
+			binary:   Binary{Name: "tool", Version: "1"},
+			expected: `download "tool" needs a name, a version and a URL`,
+		},
+	}
+	for _, tt := range tests {
+		_, err := tt.binary.Ensure()
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q but got %v", tt.desc, tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,224 @@
+// Package frontend builds the web assets of full-stack repos with npm, yarn or
+// pnpm, whichever the project uses, so a magefile can build them without
+// knowing which.  Dependencies are installed exactly as the lockfile says in
+// CI, and neither installing nor building runs again until the files they're
+// made from change.
+package frontend
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/fsutil"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/target"
+)
+
+// PackageManager is a JavaScript package manager.
+type PackageManager string
+
+// The package managers.
+const (
+	NPM  PackageManager = "npm"
+	Yarn PackageManager = "yarn"
+	PNPM PackageManager = "pnpm"
+)
+
+// lockfiles are the package managers' lockfiles, in the order they're looked
+// for.
+var lockfiles = []struct {
+	name string
+	pm   PackageManager
+}{
+	{"pnpm-lock.yaml", PNPM},
+	{"yarn.lock", Yarn},
+	{"package-lock.json", NPM},
+	{"npm-shrinkwrap.json", NPM},
+}
+
+// stamp is the file Install writes in node_modules once it's installed
+// everything, so it knows when it needs to again.
+const stamp = ".mage-installed"
+
+// Detect returns the package manager of the project in dir: the one named by
+// the packageManager field of its package.json, if it has one, as corepack
+// uses, or else the one whose lockfile it has, or else npm.  It's an error if
+// there's no package.json, or there are lockfiles of different package
+// managers.
+func Detect(dir string) (PackageManager, error) {
+	b, err := ioutil.ReadFile(filepath.Join(dir, "package.json"))
+	if err != nil {
+		return "", err
+	}
+	var pkg struct {
+		PackageManager string `json:"packageManager"`
+	}
+	if err := json.Unmarshal(b, &pkg); err != nil {
+		return "", fmt.Errorf("can't read %s: %v", filepath.Join(dir, "package.json"), err)
+	}
+	if pkg.PackageManager != "" {
+		name := strings.SplitN(pkg.PackageManager, "@", 2)[0]
+		switch pm := PackageManager(name); pm {
+		case NPM, Yarn, PNPM:
+			return pm, nil
+		default:
+			return "", fmt.Errorf("unknown package manager %q in %s", name, filepath.Join(dir, "package.json"))
+		}
+	}
+	var found PackageManager
+	var foundFile string
+	for _, lf := range lockfiles {
+		if !fsutil.FileExists(filepath.Join(dir, lf.name)) {
+			continue
+		}
+		if found != "" && found != lf.pm {
+			return "", fmt.Errorf("%s has both %s and %s, delete the one that isn't used", dir, foundFile, lf.name)
+		}
+		found, foundFile = lf.pm, lf.name
+	}
+	if found == "" {
+		return NPM, nil
+	}
+	return found, nil
+}
+
+// Project is a JavaScript project.
+type Project struct {
+	// Dir is the directory with the project's package.json.  It defaults to
+	// the current directory.
+	Dir string
+
+	// PackageManager is the package manager to use.  It defaults to the one
+	// Detect finds.
+	PackageManager PackageManager
+
+	// Frozen installs exactly what the lockfile says, and fails if it
+	// doesn't match package.json, rather than updating it, e.g. with npm ci.
+	// It's always done in CI, i.e. when CI is true, as most CI services set
+	// it.
+	Frozen bool
+}
+
+// Install installs the project's dependencies, unless they've been installed
+// since package.json and the lockfile last changed.
+//
+//	// Web builds the web UI.
+//	func Web() error {
+//		return frontend.Project{Dir: "web"}.Build("build", "web/dist", "web/src")
+//	}
+func (p Project) Install() error {
+	pm, err := p.packageManager()
+	if err != nil {
+		return err
+	}
+	done := filepath.Join(p.dir(), "node_modules", stamp)
+	stale, err := target.Path(done, p.manifests(pm)...)
+	if err != nil {
+		return err
+	}
+	if !stale {
+		log.Printf("the dependencies of %s are up to date", p.dir())
+		return nil
+	}
+	frozen := p.Frozen
+	if ci, _ := strconv.ParseBool(os.Getenv("CI")); ci {
+		frozen = true
+	}
+	args := []string{"install"}
+	switch {
+	case !frozen:
+	case pm == NPM:
+		args = []string{"ci"}
+	case pm == Yarn && fsutil.FileExists(filepath.Join(p.dir(), ".yarnrc.yml")):
+		// yarn 2 and later, which are configured by .yarnrc.yml.
+		args = append(args, "--immutable")
+	default:
+		args = append(args, "--frozen-lockfile")
+	}
+	if err := p.run(pm, args...); err != nil {
+		return err
+	}
+	return fsutil.WriteFile(done, nil, 0644)
+}
+
+// Run runs the script in package.json with the args.
+func (p Project) Run(script string, args ...string) error {
+	pm, err := p.packageManager()
+	if err != nil {
+		return err
+	}
+	a := []string{"run", script}
+	if len(args) > 0 {
+		if pm == NPM {
+			// npm takes the flags before -- as its own.
+			a = append(a, "--")
+		}
+		a = append(a, args...)
+	}
+	return p.run(pm, a...)
+}
+
+// Build installs the dependencies, like Install, and runs the script, unless
+// out is newer than the sources, package.json and the lockfile.  If out or a
+// source is a directory, the files in it are compared.  Relative paths are
+// relative to the working directory, not the project's.
+func (p Project) Build(script, out string, sources ...string) error {
+	if err := p.Install(); err != nil {
+		return err
+	}
+	pm, err := p.packageManager()
+	if err != nil {
+		return err
+	}
+	srcs := append(append([]string{}, sources...), p.manifests(pm)...)
+	stale, err := target.Dir(out, srcs...)
+	if err != nil {
+		return err
+	}
+	if !stale {
+		log.Printf("%s is up to date, not running %s", out, script)
+		return nil
+	}
+	return p.Run(script)
+}
+
+func (p Project) dir() string {
+	if p.Dir == "" {
+		return "."
+	}
+	return p.Dir
+}
+
+func (p Project) packageManager() (PackageManager, error) {
+	if p.PackageManager != "" {
+		return p.PackageManager, nil
+	}
+	return Detect(p.dir())
+}
+
+// manifests returns package.json and the package manager's lockfile, if
+// there is one.
+func (p Project) manifests(pm PackageManager) []string {
+	files := []string{filepath.Join(p.dir(), "package.json")}
+	for _, lf := range lockfiles {
+		if path := filepath.Join(p.dir(), lf.name); lf.pm == pm && fsutil.FileExists(path) {
+			files = append(files, path)
+		}
+	}
+	return files
+}
+
+// run runs the package manager in the project's directory.
+func (p Project) run(pm PackageManager, args ...string) error {
+	if p.dir() != "." {
+		flag := map[PackageManager]string{NPM: "--prefix", Yarn: "--cwd", PNPM: "--dir"}[pm]
+		args = append([]string{flag, p.dir()}, args...)
+	}
+	return sh.RunV(string(pm), args...)
+}
@@ -0,0 +1,203 @@
+package frontend
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{
+		"npm":  packageManager("npm"),
+		"yarn": packageManager("yarn"),
+		"pnpm": packageManager("pnpm"),
+	})
+	os.Exit(m.Run())
+}
+
+// packageManager returns a fake package manager called name, that writes how
+// it was run to the file log next to it, and whose "build" script makes
+// dist/app.js in the project given as its second arg.
+func packageManager(name string) faketool.Tool {
+	return func(args []string) int {
+		all := strings.Join(args, " ")
+		faketool.Append(filepath.Join(faketool.Dir(), "log"), name+" "+all)
+		if strings.Contains(all, "run build") {
+			dist := filepath.Join(args[1], "dist")
+			os.MkdirAll(dist, 0755)
+			ioutil.WriteFile(filepath.Join(dist, "app.js"), nil, 0644)
+		}
+		return 0
+	}
+}
+
+// project makes a project with the files, and puts a fake npm, yarn and pnpm
+// on the PATH.  It returns the project's dir, the log of how the package
+// managers were run, and a func that puts things back.
+func project(t *testing.T, files map[string]string) (dir, logFile string, cleanup func()) {
+	tmp, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(tmp, "bin")
+	for _, name := range []string{"npm", "yarn", "pnpm"} {
+		faketool.Install(t, bin, name)
+	}
+	logFile = filepath.Join(bin, "log")
+	dir = filepath.Join(tmp, "web")
+	os.Mkdir(dir, 0755)
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	ci, hasCI := os.LookupEnv("CI")
+	os.Unsetenv("CI")
+	return dir, logFile, func() {
+		os.Setenv("PATH", path)
+		if hasCI {
+			os.Setenv("CI", ci)
+		}
+		os.RemoveAll(tmp)
+	}
+}
+
+// ran returns how the package managers were run, and clears the log.
+func ran(t *testing.T, logFile string) string {
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	os.Remove(logFile)
+	return strings.TrimSpace(string(b))
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		files    map[string]string
+		expected PackageManager
+		err      string
+	}{
+		{files: map[string]string{"package.json": "{}"}, expected: NPM},
+		{files: map[string]string{"package.json": "{}", "package-lock.json": "{}"}, expected: NPM},
+		{files: map[string]string{"package.json": "{}", "yarn.lock": ""}, expected: Yarn},
+		{files: map[string]string{"package.json": "{}", "pnpm-lock.yaml": ""}, expected: PNPM},
+		{files: map[string]string{"package.json": `{"packageManager": "pnpm@8.6.0"}`, "yarn.lock": ""}, expected: PNPM},
+		{files: map[string]string{"package.json": `{"packageManager": "bun@1.0.0"}`}, err: `unknown package manager "bun"`},
+		{files: map[string]string{"package.json": "{}", "yarn.lock": "", "package-lock.json": "{}"}, err: "has both yarn.lock and package-lock.json"},
+		{files: map[string]string{}, err: "package.json"},
+	}
+	for _, tt := range tests {
+		dir, _, cleanup := project(t, tt.files)
+		pm, err := Detect(dir)
+		cleanup()
+		switch {
+		case tt.err != "":
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%v: expected an error with %q, got %v", tt.files, tt.err, err)
+			}
+		case err != nil:
+			t.Errorf("%v: %v", tt.files, err)
+		case pm != tt.expected:
+			t.Errorf("%v: expected %s, got %s", tt.files, tt.expected, pm)
+		}
+	}
+}
+
+func TestInstall(t *testing.T) {
+	dir, logFile, cleanup := project(t, map[string]string{"package.json": "{}", "package-lock.json": "{}"})
+	defer cleanup()
+	p := Project{Dir: dir}
+	if err := p.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "npm --prefix "+dir+" install" {
+		t.Fatalf("unexpected %q", got)
+	}
+	if err := p.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "" {
+		t.Fatalf("expected nothing to run when the dependencies are up to date, got %q", got)
+	}
+
+	// a change to the lockfile installs again, with it frozen in CI.
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join(dir, "package-lock.json"), later, later)
+	os.Setenv("CI", "true")
+	defer os.Unsetenv("CI")
+	if err := p.Install(); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "npm --prefix "+dir+" ci" {
+		t.Fatalf("unexpected %q", got)
+	}
+}
+
+func TestFrozen(t *testing.T) {
+	tests := []struct {
+		lockfile string
+		yarnrc   bool
+		expected string
+	}{
+		{lockfile: "yarn.lock", expected: "yarn --cwd DIR install --frozen-lockfile"},
+		{lockfile: "yarn.lock", yarnrc: true, expected: "yarn --cwd DIR install --immutable"},
+		{lockfile: "pnpm-lock.yaml", expected: "pnpm --dir DIR install --frozen-lockfile"},
+	}
+	for _, tt := range tests {
+		files := map[string]string{"package.json": "{}", tt.lockfile: ""}
+		if tt.yarnrc {
+			files[".yarnrc.yml"] = ""
+		}
+		dir, logFile, cleanup := project(t, files)
+		if err := (Project{Dir: dir, Frozen: true}).Install(); err != nil {
+			t.Fatal(err)
+		}
+		if got, expected := ran(t, logFile), strings.Replace(tt.expected, "DIR", dir, 1); got != expected {
+			t.Errorf("expected %q, got %q", expected, got)
+		}
+		cleanup()
+	}
+}
+
+func TestRunAndBuild(t *testing.T) {
+	dir, logFile, cleanup := project(t, map[string]string{"package.json": "{}", "pnpm-lock.yaml": "", "index.ts": ""})
+	defer cleanup()
+	p := Project{Dir: dir}
+	if err := (Project{Dir: dir, PackageManager: NPM}).Run("test", "--watch=false"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "npm --prefix "+dir+" run test -- --watch=false" {
+		t.Fatalf("unexpected %q", got)
+	}
+
+	out, src := filepath.Join(dir, "dist"), filepath.Join(dir, "index.ts")
+	if err := p.Build("build", out, src); err != nil {
+		t.Fatal(err)
+	}
+	expected := "pnpm --dir " + dir + " install\npnpm --dir " + dir + " run build"
+	if got := ran(t, logFile); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+	if err := p.Build("build", out, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "" {
+		t.Fatalf("expected nothing to run when the build is up to date, got %q", got)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(src, later, later)
+	if err := p.Build("build", out, src); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "pnpm --dir "+dir+" run build" {
+		t.Fatalf("unexpected %q", got)
+	}
+}
@@ -0,0 +1,240 @@
+package fsutil
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// FS is a file system: the files the helpers in fsutil, sh and target read and
+// write.  Its reads are like io/fs's, which go 1.12 doesn't have, and IOFS and
+// FromIOFS convert between them, and its writes are like the os package's.
+// Paths are the OS's, and errors are *os.PathErrors, so os.IsNotExist and the
+// like work with them.
+type FS interface {
+	// Open opens the file for reading.
+	Open(name string) (File, error)
+
+	// OpenFile opens the file with the flags, like os.OpenFile.
+	OpenFile(name string, flag int, perm os.FileMode) (File, error)
+
+	// Stat returns the file's info, following symlinks.
+	Stat(name string) (os.FileInfo, error)
+
+	// Lstat returns the file's info, without following symlinks.
+	Lstat(name string) (os.FileInfo, error)
+
+	// ReadDir returns the info, like Lstat's, of the files in the
+	// directory, sorted by name.
+	ReadDir(name string) ([]os.FileInfo, error)
+
+	// TempFile creates a new file in the directory, like
+	// ioutil.TempFile, opened for reading and writing.
+	TempFile(dir, pattern string) (File, error)
+
+	MkdirAll(path string, perm os.FileMode) error
+	Remove(name string) error
+	RemoveAll(path string) error
+	Rename(oldpath, newpath string) error
+	Chmod(name string, mode os.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+}
+
+// File is an open file in an FS, which *os.File is.
+type File interface {
+	io.Reader
+	io.Writer
+	io.Closer
+	Name() string
+	Stat() (os.FileInfo, error)
+}
+
+// Default is the FS the helpers in fsutil, sh and target use.  It's the OS's,
+// but tests can set it to a MemFS, to test targets that use them without
+// touching the disk:
+//
+//	func TestGenerate(t *testing.T) {
+//		mem := fsutil.NewMemFS()
+//		fsutil.Default = mem
+//		defer func() { fsutil.Default = fsutil.OSFS{} }()
+//		...
+//	}
+var Default FS = OSFS{}
+
+// OSFS is the OS's file system.
+type OSFS struct{}
+
+// Open calls os.Open.
+func (OSFS) Open(name string) (File, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// OpenFile calls os.OpenFile.
+func (OSFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	f, err := os.OpenFile(name, flag, perm)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Stat calls os.Stat.
+func (OSFS) Stat(name string) (os.FileInfo, error) { return os.Stat(name) }
+
+// Lstat calls os.Lstat.
+func (OSFS) Lstat(name string) (os.FileInfo, error) { return os.Lstat(name) }
+
+// ReadDir calls ioutil.ReadDir.
+func (OSFS) ReadDir(name string) ([]os.FileInfo, error) { return ioutil.ReadDir(name) }
+
+// TempFile calls ioutil.TempFile.
+func (OSFS) TempFile(dir, pattern string) (File, error) {
+	f, err := ioutil.TempFile(dir, pattern)
+	if err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// MkdirAll calls os.MkdirAll.
+func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.MkdirAll(path, perm) }
+
+// Remove calls os.Remove.
+func (OSFS) Remove(name string) error { return os.Remove(name) }
+
+// RemoveAll calls os.RemoveAll.
+func (OSFS) RemoveAll(path string) error { return os.RemoveAll(path) }
+
+// Rename calls os.Rename.
+func (OSFS) Rename(oldpath, newpath string) error { return os.Rename(oldpath, newpath) }
+
+// Chmod calls os.Chmod.
+func (OSFS) Chmod(name string, mode os.FileMode) error { return os.Chmod(name, mode) }
+
+// Chtimes calls os.Chtimes.
+func (OSFS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+// ReadFile returns the contents of the file in the FS.
+func ReadFile(fsys FS, name string) ([]byte, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// Walk walks the tree in the FS, like filepath.Walk.
+func Walk(fsys FS, root string, fn filepath.WalkFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walk(fsys, root, info, fn)
+	}
+	if err == filepath.SkipDir {
+		return nil
+	}
+	return err
+}
+
+func walk(fsys FS, path string, info os.FileInfo, fn filepath.WalkFunc) error {
+	if !info.IsDir() {
+		return fn(path, info, nil)
+	}
+	infos, err := fsys.ReadDir(path)
+	err1 := fn(path, info, err)
+	if err != nil || err1 != nil {
+		return err1
+	}
+	for _, fi := range infos {
+		err := walk(fsys, filepath.Join(path, fi.Name()), fi, fn)
+		if err != nil && (!fi.IsDir() || err != filepath.SkipDir) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Glob returns the files in the FS that match the pattern, like
+// filepath.Glob.
+func Glob(fsys FS, pattern string) ([]string, error) {
+	if _, ok := fsys.(OSFS); ok {
+		return filepath.Glob(pattern)
+	}
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	if !hasMeta(pattern) {
+		if _, err := fsys.Lstat(pattern); err != nil {
+			return nil, nil
+		}
+		return []string{pattern}, nil
+	}
+	dir, file := filepath.Split(pattern)
+	switch dir {
+	case "":
+		dir = "."
+	case string(filepath.Separator):
+	default:
+		dir = dir[:len(dir)-1]
+	}
+	if !hasMeta(dir) {
+		return glob(fsys, dir, file, nil)
+	}
+	if dir == pattern {
+		return nil, filepath.ErrBadPattern
+	}
+	dirs, err := Glob(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	var matches []string
+	for _, d := range dirs {
+		if matches, err = glob(fsys, d, file, matches); err != nil {
+			return nil, err
+		}
+	}
+	return matches, nil
+}
+
+// glob appends the files in the directory that match the pattern, which has
+// no separators, to matches.
+func glob(fsys FS, dir, pattern string, matches []string) ([]string, error) {
+	info, err := fsys.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return matches, nil
+	}
+	infos, err := fsys.ReadDir(dir)
+	if err != nil {
+		return matches, nil
+	}
+	for _, fi := range infos {
+		matched, err := filepath.Match(pattern, fi.Name())
+		if err != nil {
+			return matches, err
+		}
+		if matched {
+			matches = append(matches, filepath.Join(dir, fi.Name()))
+		}
+	}
+	return matches, nil
+}
+
+func hasMeta(path string) bool {
+	magic := `*?[`
+	if runtime.GOOS != "windows" {
+		magic = `*?[\`
+	}
+	return strings.ContainsAny(path, magic)
+}
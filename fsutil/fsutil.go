@@ -0,0 +1,167 @@
+// Package fsutil has the small file operations magefiles do all the time, like
+// writing a file without leaving it half written, or changing a line in one.
+// Each logs what it changes, which mage shows with -v, and its errors say
+// which file they're about.  They work on Default, the OS's file system, which
+// tests can swap for a MemFS.
+package fsutil
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// WriteFile writes the data to the file, like ioutil.WriteFile, but to a
+// temporary file that's then renamed to it, so the file is never half
+// written, even if mage is stopped.  The directory it's in is created if it
+// doesn't exist.
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+	if err := Default.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("can't write %s: %v", path, err)
+	}
+	f, err := Default.TempFile(dir, "."+filepath.Base(path))
+	if err != nil {
+		return fmt.Errorf("can't write %s: %v", path, err)
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = Default.Chmod(f.Name(), perm)
+	}
+	if err == nil {
+		err = Default.Rename(f.Name(), path)
+	}
+	if err != nil {
+		Default.Remove(f.Name())
+		return fmt.Errorf("can't write %s: %v", path, err)
+	}
+	log.Printf("wrote %s", path)
+	return nil
+}
+
+// EnsureDir creates the directory, and any it's in, if it doesn't exist.
+func EnsureDir(path string) error {
+	if DirExists(path) {
+		return nil
+	}
+	if err := Default.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("can't create %s: %v", path, err)
+	}
+	log.Printf("created %s", path)
+	return nil
+}
+
+// FileExists reports whether the path is a file, or a symlink to one.
+func FileExists(path string) bool {
+	info, err := Default.Stat(path)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// DirExists reports whether the path is a directory, or a symlink to one.
+func DirExists(path string) bool {
+	info, err := Default.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// ReplaceInFile replaces the matches of the regular expression in the file
+// with repl, which may refer to submatches like $1, as with
+// regexp.ReplaceAllString, and returns how many matches there were.  The
+// file is only written if that changes it.
+func ReplaceInFile(path, pattern, repl string) (int, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return 0, err
+	}
+	b, info, err := read(path)
+	if err != nil {
+		return 0, err
+	}
+	n := len(re.FindAllIndex(b, -1))
+	out := re.ReplaceAll(b, []byte(repl))
+	if bytes.Equal(out, b) {
+		return n, nil
+	}
+	if err := WriteFile(path, out, info.Mode().Perm()); err != nil {
+		return 0, err
+	}
+	log.Printf("replaced %d matches of %s in %s", n, pattern, path)
+	return n, nil
+}
+
+// LineInFile makes sure the line is in the file, and reports whether it
+// changed the file.  If the pattern isn't empty, the last line that matches
+// the regular expression is replaced with the line, e.g. to change a
+// setting.  Otherwise, or if no line matches, the line is appended, unless
+// it's already there.  The file is created if it doesn't exist.
+func LineInFile(path, pattern, line string) (bool, error) {
+	var re *regexp.Regexp
+	if pattern != "" {
+		var err error
+		if re, err = regexp.Compile(pattern); err != nil {
+			return false, err
+		}
+	}
+	b, info, err := read(path)
+	perm := os.FileMode(0644)
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return false, err
+	default:
+		perm = info.Mode().Perm()
+	}
+
+	text := string(b)
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	match := -1
+	for i, l := range lines {
+		l = strings.TrimRight(l, "\r\n")
+		if re != nil && re.MatchString(l) {
+			match = i
+		}
+		if re == nil && l == line {
+			return false, nil
+		}
+	}
+	if match >= 0 {
+		old := lines[match]
+		eol := old[len(strings.TrimRight(old, "\r\n")):]
+		if strings.TrimRight(old, "\r\n") == line {
+			return false, nil
+		}
+		lines[match] = line + eol
+	} else {
+		if text != "" && !strings.HasSuffix(text, "\n") {
+			lines[len(lines)-1] += "\n"
+		}
+		lines = append(lines, line+"\n")
+	}
+	if err := WriteFile(path, []byte(strings.Join(lines, "")), perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// read returns the contents of the file and its info.  The error is
+// os.IsNotExist if the file doesn't exist.
+func read(path string) ([]byte, os.FileInfo, error) {
+	info, err := Default.Stat(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	b, err := ReadFile(Default, path)
+	if err != nil {
+		return nil, nil, err
+	}
+	return b, info, nil
+}
@@ -0,0 +1,155 @@
+package fsutil
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestWriteFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "bin", "run.sh")
+
+	if err := WriteFile(path, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(path, []byte("#!/bin/sh\necho hi\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "#!/bin/sh\necho hi\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if info, _ := os.Stat(path); runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Fatalf("expected 0755 but got %v", info.Mode())
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Fatalf("expected no temporary files to be left but found %d files", len(files))
+	}
+
+	// the directory is in the way of the file.
+	err := WriteFile(filepath.Join(dir, "bin"), nil, 0644)
+	if err == nil || !strings.HasPrefix(err.Error(), "can't write "+filepath.Join(dir, "bin")+": ") {
+		t.Fatalf("expected an error but got %v", err)
+	}
+}
+
+func TestExists(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	file := filepath.Join(dir, "file")
+	ioutil.WriteFile(file, nil, 0644)
+	sub := filepath.Join(dir, "a", "b")
+
+	if err := EnsureDir(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureDir(sub); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureDir(file); err == nil {
+		t.Fatal("expected an error for a file in the way")
+	}
+
+	tests := []struct {
+		path        string
+		file, dir   bool
+		description string
+	}{
+		{file, true, false, "file"},
+		{sub, false, true, "dir"},
+		{filepath.Join(dir, "missing"), false, false, "missing"},
+	}
+	for _, tt := range tests {
+		if FileExists(tt.path) != tt.file || DirExists(tt.path) != tt.dir {
+			t.Errorf("%s: expected FileExists %v and DirExists %v", tt.description, tt.file, tt.dir)
+		}
+	}
+}
+
+func TestReplaceInFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "version.go")
+	ioutil.WriteFile(path, []byte("const Version = \"1.2.3\"\nconst Min = \"1.0.0\"\n"), 0600)
+
+	n, err := ReplaceInFile(path, `(Version|Min) = "[^"]*"`, `$1 = "2.0.0"`)
+	if err != nil || n != 2 {
+		t.Fatalf("expected 2 matches but got %d, %v", n, err)
+	}
+	b, _ := ioutil.ReadFile(path)
+	if string(b) != "const Version = \"2.0.0\"\nconst Min = \"2.0.0\"\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if info, _ := os.Stat(path); runtime.GOOS != "windows" && info.Mode().Perm() != 0600 {
+		t.Fatalf("expected the permissions to be kept but got %v", info.Mode())
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(path, old, old)
+	if n, err := ReplaceInFile(path, `Version = "[^"]*"`, `Version = "2.0.0"`); err != nil || n != 1 {
+		t.Fatalf("expected 1 match but got %d, %v", n, err)
+	}
+	if info, _ := os.Stat(path); !info.ModTime().Equal(old) {
+		t.Fatal("expected the file not to be written when nothing changed")
+	}
+	if _, err := ReplaceInFile(path, `(`, ""); err == nil {
+		t.Fatal("expected an error for the pattern")
+	}
+	if _, err := ReplaceInFile(filepath.Join(dir, "missing"), `x`, ""); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error but got %v", err)
+	}
+}
+
+func TestLineInFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, ".env")
+
+	tests := []struct {
+		pattern, line string
+		changed       bool
+		expected      string
+	}{
+		{"", "A=1", true, "A=1\n"},
+		{"", "A=1", false, "A=1\n"},
+		{"^B=", "B=2", true, "A=1\nB=2\n"},
+		{"^A=", "A=3", true, "A=3\nB=2\n"},
+		{"^A=", "A=3", false, "A=3\nB=2\n"},
+	}
+	for i, tt := range tests {
+		changed, err := LineInFile(path, tt.pattern, tt.line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(path)
+		if changed != tt.changed || string(b) != tt.expected {
+			t.Fatalf("%d: expected %v and %q but got %v and %q", i, tt.changed, tt.expected, changed, b)
+		}
+	}
+
+	// a file without a newline at the end, or with Windows line endings.
+	ioutil.WriteFile(path, []byte("A=1\r\nB=2"), 0644)
+	if _, err := LineInFile(path, "^A=", "A=4"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LineInFile(path, "", "C=5"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(path); string(b) != "A=4\r\nB=2\nC=5\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+}
@@ -0,0 +1,174 @@
+//go:build go1.16
+// +build go1.16
+
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// IOFS returns the directory in the FS as an io/fs file system, e.g. to use
+// with fs.WalkDir or template.ParseFS.
+func IOFS(fsys FS, dir string) fs.FS {
+	return ioFS{fsys: fsys, dir: dir}
+}
+
+type ioFS struct {
+	fsys FS
+	dir  string
+}
+
+func (f ioFS) path(op, name string) (string, error) {
+	if !fs.ValidPath(name) {
+		return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	return filepath.Join(f.dir, filepath.FromSlash(name)), nil
+}
+
+func (f ioFS) Open(name string) (fs.File, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.fsys.Open(p)
+}
+
+func (f ioFS) Stat(name string) (fs.FileInfo, error) {
+	p, err := f.path("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.fsys.Stat(p)
+}
+
+func (f ioFS) ReadFile(name string) ([]byte, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	return ReadFile(f.fsys, p)
+}
+
+func (f ioFS) ReadDir(name string) ([]fs.DirEntry, error) {
+	p, err := f.path("open", name)
+	if err != nil {
+		return nil, err
+	}
+	infos, err := f.fsys.ReadDir(p)
+	entries := make([]fs.DirEntry, len(infos))
+	for i, info := range infos {
+		entries[i] = dirEntry{info}
+	}
+	return entries, err
+}
+
+type dirEntry struct {
+	fs.FileInfo
+}
+
+func (e dirEntry) Type() fs.FileMode          { return e.Mode().Type() }
+func (e dirEntry) Info() (fs.FileInfo, error) { return e.FileInfo, nil }
+
+// FromIOFS returns the io/fs file system, like an embed.FS, as a read-only FS,
+// whose writes fail with os.ErrPermission.  Its paths are the OS's, relative
+// to its root, which absolute paths are too.
+func FromIOFS(fsys fs.FS) FS {
+	return roFS{fsys}
+}
+
+type roFS struct {
+	fsys fs.FS
+}
+
+// name returns the path as an io/fs path.
+func (roFS) name(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+filepath.ToSlash(p)), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func (f roFS) Open(name string) (File, error) {
+	file, err := f.fsys.Open(f.name(name))
+	if err != nil {
+		return nil, err
+	}
+	return roFile{File: file, name: name}, nil
+}
+
+func (f roFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	if flag&(os.O_WRONLY|os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND) != 0 {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrPermission}
+	}
+	return f.Open(name)
+}
+
+func (f roFS) Stat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, f.name(name))
+}
+
+func (f roFS) Lstat(name string) (os.FileInfo, error) {
+	return fs.Stat(f.fsys, f.name(name))
+}
+
+func (f roFS) ReadDir(name string) ([]os.FileInfo, error) {
+	entries, err := fs.ReadDir(f.fsys, f.name(name))
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		info, err := e.Info()
+		if err != nil {
+			return nil, err
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+func (roFS) TempFile(dir, pattern string) (File, error) {
+	return nil, &os.PathError{Op: "createtemp", Path: filepath.Join(dir, pattern), Err: os.ErrPermission}
+}
+
+func (roFS) MkdirAll(path string, perm os.FileMode) error {
+	return &os.PathError{Op: "mkdir", Path: path, Err: os.ErrPermission}
+}
+
+func (roFS) Remove(name string) error {
+	return &os.PathError{Op: "remove", Path: name, Err: os.ErrPermission}
+}
+
+func (roFS) RemoveAll(path string) error {
+	return &os.PathError{Op: "remove", Path: path, Err: os.ErrPermission}
+}
+
+func (roFS) Rename(oldpath, newpath string) error {
+	return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrPermission}
+}
+
+func (roFS) Chmod(name string, mode os.FileMode) error {
+	return &os.PathError{Op: "chmod", Path: name, Err: os.ErrPermission}
+}
+
+func (roFS) Chtimes(name string, atime, mtime time.Time) error {
+	return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrPermission}
+}
+
+// roFile is a file in a roFS, which can't be written.
+type roFile struct {
+	fs.File
+	name string
+}
+
+func (f roFile) Name() string { return f.name }
+
+func (f roFile) Write(p []byte) (int, error) {
+	return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+}
@@ -0,0 +1,65 @@
+//go:build go1.16
+// +build go1.16
+
+package fsutil
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+)
+
+func TestIOFS(t *testing.T) {
+	m := NewMemFS()
+	m.MkdirAll(filepath.FromSlash("/site/docs"), 0755)
+	for _, name := range []string{"/site/index.md", "/site/docs/intro.md"} {
+		f, _ := m.OpenFile(filepath.FromSlash(name), os.O_CREATE|os.O_WRONLY, 0644)
+		f.Write([]byte(name))
+		f.Close()
+	}
+
+	fsys := IOFS(m, filepath.FromSlash("/site"))
+	var walked []string
+	err := fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		walked = append(walked, path)
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{".", "docs", "docs/intro.md", "index.md"}; !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("expected to walk %q but walked %q", expected, walked)
+	}
+	if b, err := fs.ReadFile(fsys, "docs/intro.md"); err != nil || string(b) != "/site/docs/intro.md" {
+		t.Fatalf("unexpected contents %q, %v", b, err)
+	}
+	if _, err := fsys.Open("../site"); err == nil {
+		t.Fatal("expected an invalid path to fail")
+	}
+}
+
+func TestFromIOFS(t *testing.T) {
+	fsys := FromIOFS(fstest.MapFS{
+		"templates/a.tmpl": {Data: []byte("a")},
+		"templates/b.tmpl": {Data: []byte("b")},
+	})
+	if b, err := ReadFile(fsys, filepath.FromSlash("/templates/a.tmpl")); err != nil || string(b) != "a" {
+		t.Fatalf("unexpected contents %q, %v", b, err)
+	}
+	matches, err := Glob(fsys, filepath.Join("templates", "*.tmpl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{filepath.Join("templates", "a.tmpl"), filepath.Join("templates", "b.tmpl")}; !reflect.DeepEqual(matches, expected) {
+		t.Fatalf("expected %q but got %q", expected, matches)
+	}
+	if _, err := fsys.OpenFile("templates/c.tmpl", os.O_CREATE|os.O_WRONLY, 0644); !os.IsPermission(err) {
+		t.Fatalf("expected writes to fail but got %v", err)
+	}
+	if _, err := fsys.Stat("templates/c.tmpl"); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file to not exist but got %v", err)
+	}
+}
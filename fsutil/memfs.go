@@ -0,0 +1,361 @@
+package fsutil
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/clock"
+)
+
+var (
+	errIsDir    = errors.New("is a directory")
+	errNotDir   = errors.New("not a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+// MemFS is an FS in memory, for tests.  Relative paths are relative to its
+// root, /, and it has no symlinks.  The temporary directory, os.TempDir, is
+// there when it's made.
+type MemFS struct {
+	mu    sync.Mutex
+	nodes map[string]*memNode
+	temps int
+}
+
+// memNode is a file or directory.  Open files point to it, so they see it
+// renamed, like an inode.
+type memNode struct {
+	data    []byte
+	mode    os.FileMode
+	modTime time.Time
+}
+
+// NewMemFS returns an empty MemFS.
+func NewMemFS() *MemFS {
+	m := &MemFS{nodes: map[string]*memNode{"/": {mode: os.ModeDir | 0755, modTime: clock.Now()}}}
+	m.MkdirAll(os.TempDir(), 0755)
+	return m
+}
+
+// memPath returns the key of the path in the map.
+func memPath(name string) string {
+	return path.Clean("/" + filepath.ToSlash(name))
+}
+
+// dir returns the directory the key is in, which has to exist.
+func (m *MemFS) dir(op, name, key string) error {
+	parent, ok := m.nodes[path.Dir(key)]
+	if !ok {
+		return &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	if !parent.mode.IsDir() {
+		return &os.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return nil
+}
+
+// Open opens the file for reading.
+func (m *MemFS) Open(name string) (File, error) {
+	return m.OpenFile(name, os.O_RDONLY, 0)
+}
+
+// OpenFile opens the file with the flags, like os.OpenFile.
+func (m *MemFS) OpenFile(name string, flag int, perm os.FileMode) (File, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	writing := flag&(os.O_WRONLY|os.O_RDWR) != 0
+	n, ok := m.nodes[key]
+	switch {
+	case ok && flag&os.O_CREATE != 0 && flag&os.O_EXCL != 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrExist}
+	case ok && n.mode.IsDir() && writing:
+		return nil, &os.PathError{Op: "open", Path: name, Err: errIsDir}
+	case ok:
+		if writing && flag&os.O_TRUNC != 0 {
+			n.data = nil
+			n.modTime = clock.Now()
+		}
+	case flag&os.O_CREATE == 0:
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	default:
+		if err := m.dir("open", name, key); err != nil {
+			return nil, err
+		}
+		n = &memNode{mode: perm.Perm(), modTime: clock.Now()}
+		m.nodes[key] = n
+	}
+	return &memFile{fs: m, name: name, node: n, flag: flag}, nil
+}
+
+func (m *MemFS) stat(op, name string) (os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	n, ok := m.nodes[key]
+	if !ok {
+		return nil, &os.PathError{Op: op, Path: name, Err: os.ErrNotExist}
+	}
+	return n.info(path.Base(key)), nil
+}
+
+// Stat returns the file's info.
+func (m *MemFS) Stat(name string) (os.FileInfo, error) {
+	return m.stat("stat", name)
+}
+
+// Lstat returns the file's info, the same as Stat, since there are no
+// symlinks.
+func (m *MemFS) Lstat(name string) (os.FileInfo, error) {
+	return m.stat("lstat", name)
+}
+
+// ReadDir returns the info of the files in the directory, sorted by name.
+func (m *MemFS) ReadDir(name string) ([]os.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	n, ok := m.nodes[key]
+	if !ok {
+		return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+	}
+	if !n.mode.IsDir() {
+		return nil, &os.PathError{Op: "readdirent", Path: name, Err: errNotDir}
+	}
+	var infos []os.FileInfo
+	for k, child := range m.nodes {
+		if k != key && path.Dir(k) == key {
+			infos = append(infos, child.info(path.Base(k)))
+		}
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name() < infos[j].Name() })
+	return infos, nil
+}
+
+// TempFile creates a new file in the directory, or os.TempDir if it's empty,
+// named with the pattern, like ioutil.TempFile.
+func (m *MemFS) TempFile(dir, pattern string) (File, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	prefix, suffix := pattern, ""
+	if i := strings.LastIndex(pattern, "*"); i >= 0 {
+		prefix, suffix = pattern[:i], pattern[i+1:]
+	}
+	for {
+		m.mu.Lock()
+		m.temps++
+		name := filepath.Join(dir, prefix+strconv.Itoa(m.temps)+suffix)
+		m.mu.Unlock()
+		f, err := m.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+		if !os.IsExist(err) {
+			return f, err
+		}
+	}
+}
+
+// MkdirAll creates the directory, and any it's in.
+func (m *MemFS) MkdirAll(name string, perm os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	var missing []string
+	for k := key; ; k = path.Dir(k) {
+		n, ok := m.nodes[k]
+		if ok {
+			if !n.mode.IsDir() {
+				return &os.PathError{Op: "mkdir", Path: name, Err: errNotDir}
+			}
+			break
+		}
+		missing = append(missing, k)
+	}
+	for _, k := range missing {
+		m.nodes[k] = &memNode{mode: os.ModeDir | perm.Perm(), modTime: clock.Now()}
+	}
+	return nil
+}
+
+// Remove removes the file, or empty directory.
+func (m *MemFS) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	if _, ok := m.nodes[key]; !ok {
+		return &os.PathError{Op: "remove", Path: name, Err: os.ErrNotExist}
+	}
+	for k := range m.nodes {
+		if k != key && path.Dir(k) == key {
+			return &os.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+		}
+	}
+	delete(m.nodes, key)
+	return nil
+}
+
+// RemoveAll removes the file, or directory and everything in it.  It's not
+// an error if it doesn't exist.
+func (m *MemFS) RemoveAll(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	key := memPath(name)
+	for k := range m.nodes {
+		if k != "/" && within(k, key) {
+			delete(m.nodes, k)
+		}
+	}
+	return nil
+}
+
+// Rename moves the file or directory, replacing the file that's there, if
+// there's one.
+func (m *MemFS) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, to := memPath(oldpath), memPath(newpath)
+	n, ok := m.nodes[from]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrNotExist}
+	}
+	if from == to {
+		return nil
+	}
+	if err := m.dir("rename", newpath, to); err != nil {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: err.(*os.PathError).Err}
+	}
+	if old, ok := m.nodes[to]; ok && (old.mode.IsDir() || n.mode.IsDir()) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrExist}
+	}
+	if n.mode.IsDir() && within(to, from) {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: os.ErrInvalid}
+	}
+	for k, child := range m.nodes {
+		if within(k, from) {
+			delete(m.nodes, k)
+			m.nodes[to+strings.TrimPrefix(k, from)] = child
+		}
+	}
+	return nil
+}
+
+// Chmod changes the file's permissions.
+func (m *MemFS) Chmod(name string, mode os.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[memPath(name)]
+	if !ok {
+		return &os.PathError{Op: "chmod", Path: name, Err: os.ErrNotExist}
+	}
+	n.mode = n.mode&os.ModeType | mode.Perm()
+	return nil
+}
+
+// Chtimes changes the file's modification time.  It has no access times.
+func (m *MemFS) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n, ok := m.nodes[memPath(name)]
+	if !ok {
+		return &os.PathError{Op: "chtimes", Path: name, Err: os.ErrNotExist}
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// within reports whether the key is dir or in it.
+func within(key, dir string) bool {
+	return key == dir || strings.HasPrefix(key, strings.TrimSuffix(dir, "/")+"/")
+}
+
+func (n *memNode) info(name string) os.FileInfo {
+	return memInfo{name: name, size: int64(len(n.data)), mode: n.mode, modTime: n.modTime}
+}
+
+type memInfo struct {
+	name    string
+	size    int64
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func (i memInfo) Name() string       { return i.name }
+func (i memInfo) Size() int64        { return i.size }
+func (i memInfo) Mode() os.FileMode  { return i.mode }
+func (i memInfo) ModTime() time.Time { return i.modTime }
+func (i memInfo) IsDir() bool        { return i.mode.IsDir() }
+func (i memInfo) Sys() interface{}   { return nil }
+
+// memFile is an open file in a MemFS.
+type memFile struct {
+	fs     *MemFS
+	name   string
+	node   *memNode
+	flag   int
+	off    int
+	closed bool
+}
+
+func (f *memFile) Name() string { return f.name }
+
+func (f *memFile) Read(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch {
+	case f.closed:
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrClosed}
+	case f.flag&os.O_WRONLY != 0:
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: os.ErrPermission}
+	case f.node.mode.IsDir():
+		return 0, &os.PathError{Op: "read", Path: f.name, Err: errIsDir}
+	case f.off >= len(f.node.data):
+		return 0, io.EOF
+	}
+	n := copy(p, f.node.data[f.off:])
+	f.off += n
+	return n, nil
+}
+
+func (f *memFile) Write(p []byte) (int, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	switch {
+	case f.closed:
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrClosed}
+	case f.flag&(os.O_WRONLY|os.O_RDWR) == 0:
+		return 0, &os.PathError{Op: "write", Path: f.name, Err: os.ErrPermission}
+	}
+	if f.flag&os.O_APPEND != 0 {
+		f.off = len(f.node.data)
+	}
+	if end := f.off + len(p); end > len(f.node.data) {
+		f.node.data = append(f.node.data, make([]byte, end-len(f.node.data))...)
+	}
+	copy(f.node.data[f.off:], p)
+	f.off += len(p)
+	f.node.modTime = clock.Now()
+	return len(p), nil
+}
+
+func (f *memFile) Stat() (os.FileInfo, error) {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	return f.node.info(filepath.Base(f.name)), nil
+}
+
+func (f *memFile) Close() error {
+	f.fs.mu.Lock()
+	defer f.fs.mu.Unlock()
+	if f.closed {
+		return &os.PathError{Op: "close", Path: f.name, Err: os.ErrClosed}
+	}
+	f.closed = true
+	return nil
+}
@@ -0,0 +1,160 @@
+package fsutil
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// useMemFS makes the helpers use a new MemFS until the returned func is
+// called.
+func useMemFS() (*MemFS, func()) {
+	mem := NewMemFS()
+	Default = mem
+	return mem, func() { Default = OSFS{} }
+}
+
+func TestMemFS(t *testing.T) {
+	m := NewMemFS()
+	if err := m.MkdirAll("/src/a", 0755); err != nil {
+		t.Fatal(err)
+	}
+	f, err := m.OpenFile("/src/a/file.txt", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte("hello"))
+	f.Close()
+	if _, err := f.Write([]byte("!")); err == nil {
+		t.Fatal("expected writing a closed file to fail")
+	}
+	f, _ = m.OpenFile("/src/a/file.txt", os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte(", world"))
+	f.Close()
+	if b, err := ReadFile(m, "/src/a/file.txt"); err != nil || string(b) != "hello, world" {
+		t.Fatalf("unexpected contents %q, %v", b, err)
+	}
+
+	if _, err := m.Open("/src/missing"); !os.IsNotExist(err) {
+		t.Fatalf("expected a missing file to not exist but got %v", err)
+	}
+	if _, err := m.OpenFile("/nowhere/file", os.O_CREATE|os.O_WRONLY, 0644); !os.IsNotExist(err) {
+		t.Fatalf("expected a file in a missing directory to fail but got %v", err)
+	}
+	if _, err := m.OpenFile("/src/a/file.txt", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644); !os.IsExist(err) {
+		t.Fatalf("expected an exclusive create to fail but got %v", err)
+	}
+	if err := m.MkdirAll("/src/a/file.txt/b", 0755); err == nil {
+		t.Fatal("expected making a directory in a file to fail")
+	}
+	if err := m.Remove("/src/a"); err == nil {
+		t.Fatal("expected removing a directory with files to fail")
+	}
+
+	if err := m.Chmod("/src/a/file.txt", 0600); err != nil {
+		t.Fatal(err)
+	}
+	mtime := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	if err := m.Chtimes("/src/a/file.txt", mtime, mtime); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Rename("/src/a", "/dst"); err != nil {
+		t.Fatal(err)
+	}
+	info, err := m.Stat("/dst/file.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Name() != "file.txt" || info.Size() != 12 || info.Mode() != 0600 || !info.ModTime().Equal(mtime) {
+		t.Fatalf("unexpected info %v %v %v %v", info.Name(), info.Size(), info.Mode(), info.ModTime())
+	}
+	if infos, err := m.ReadDir("/src"); err != nil || len(infos) != 0 {
+		t.Fatalf("expected /src to be empty but got %v, %v", infos, err)
+	}
+	if infos, err := m.ReadDir("/dst"); err != nil || len(infos) != 1 || infos[0].Name() != "file.txt" {
+		t.Fatalf("expected /dst to have the file but got %v, %v", infos, err)
+	}
+
+	if err := m.RemoveAll("/dst"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/dst/file.txt"); !os.IsNotExist(err) {
+		t.Fatalf("expected the file to be removed but got %v", err)
+	}
+	if err := m.RemoveAll("/dst"); err != nil {
+		t.Fatalf("expected removing a missing directory to work but got %v", err)
+	}
+}
+
+func TestWalkGlob(t *testing.T) {
+	m := NewMemFS()
+	for _, name := range []string{"/w/b.go", "/w/a.go", "/w/sub/c.go", "/w/sub/d.txt", "/w/skip/e.go"} {
+		m.MkdirAll(filepath.Dir(name), 0755)
+		f, _ := m.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		f.Close()
+	}
+
+	var walked []string
+	err := Walk(m, filepath.FromSlash("/w"), func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Name() == "skip" {
+			return filepath.SkipDir
+		}
+		walked = append(walked, filepath.ToSlash(path))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/w", "/w/a.go", "/w/b.go", "/w/sub", "/w/sub/c.go", "/w/sub/d.txt"}
+	if !reflect.DeepEqual(walked, expected) {
+		t.Fatalf("expected to walk %q but walked %q", expected, walked)
+	}
+
+	matches, err := Glob(m, filepath.FromSlash("/w/*/*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(matches, ","); filepath.ToSlash(got) != "/w/skip/e.go,/w/sub/c.go" {
+		t.Fatalf("unexpected matches %q", matches)
+	}
+	if matches, _ := Glob(m, filepath.FromSlash("/w/*.txt")); len(matches) != 0 {
+		t.Fatalf("expected no matches but got %q", matches)
+	}
+	if _, err := Glob(m, "["); err != filepath.ErrBadPattern {
+		t.Fatalf("expected a bad pattern but got %v", err)
+	}
+}
+
+func TestHelpersMemFS(t *testing.T) {
+	mem, restore := useMemFS()
+	defer restore()
+	path := filepath.FromSlash("/project/.env")
+
+	if err := WriteFile(path, []byte("A=1\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if changed, err := LineInFile(path, "^B=", "B=2"); err != nil || !changed {
+		t.Fatalf("expected the line to be added but got %v, %v", changed, err)
+	}
+	if b, _ := ReadFile(mem, path); string(b) != "A=1\nB=2\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if info, _ := mem.Stat(path); info.Mode() != 0600 {
+		t.Fatalf("expected 0600 but got %v", info.Mode())
+	}
+	if files, _ := mem.ReadDir(filepath.Dir(path)); len(files) != 1 {
+		t.Fatalf("expected no temporary files to be left but found %d files", len(files))
+	}
+	if !FileExists(path) || !DirExists(filepath.Dir(path)) {
+		t.Fatal("expected the file and its directory to exist")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected nothing to be written to disk but got %v", err)
+	}
+}
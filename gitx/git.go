@@ -0,0 +1,196 @@
+// Package gitx answers the questions about the git repository that versioning
+// and release targets ask, like what version it is and whether there are
+// uncommitted changes.  Each runs git in the working directory, and git's
+// error message is returned if it fails.
+package gitx
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// DescribeVersion returns a version for the commit that's checked out, from
+// git describe --tags --always --dirty: the latest tag, then the number of
+// commits since it and the commit, if it isn't tagged, e.g.
+// v1.2.0-3-g1a2b3c4, and -dirty if there are uncommitted changes.  If there
+// are no tags, it's the short commit SHA.
+func DescribeVersion() (string, error) {
+	return git("describe", "--tags", "--always", "--dirty")
+}
+
+// IsDirty reports whether there are changes that aren't committed, including
+// untracked files that aren't ignored.
+func IsDirty() (bool, error) {
+	out, err := git("status", "--porcelain")
+	if err != nil {
+		return false, err
+	}
+	return out != "", nil
+}
+
+// Changed returns the files under the paths, or in the working directory if
+// there are none, with changes that aren't committed, including untracked
+// files that aren't ignored.  They're relative to the working directory and
+// sorted.
+func Changed(paths ...string) ([]string, error) {
+	out, err := git(append([]string{"diff", "HEAD", "--relative", "--name-only", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	untracked, err := git(append([]string{"ls-files", "--others", "--exclude-standard", "--"}, paths...)...)
+	if err != nil {
+		return nil, err
+	}
+	var files []string
+	for _, f := range strings.Split(out+"\n"+untracked, "\n") {
+		if f != "" {
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// Diff returns the diff of the changes to the paths, or to everything if
+// there are none, that aren't committed.  Untracked files aren't in it.
+func Diff(paths ...string) (string, error) {
+	return git(append([]string{"diff", "HEAD", "--"}, paths...)...)
+}
+
+// CurrentBranch returns the name of the branch that's checked out, or "" if
+// the HEAD is detached, as it often is on CI.
+func CurrentBranch() (string, error) {
+	out, err := git("rev-parse", "--abbrev-ref", "HEAD")
+	if err != nil {
+		return "", err
+	}
+	if out == "HEAD" {
+		return "", nil
+	}
+	return out, nil
+}
+
+// CommitSHA returns the SHA of the commit that's checked out, abbreviated if
+// short is true.
+func CommitSHA(short bool) (string, error) {
+	if short {
+		return git("rev-parse", "--short", "HEAD")
+	}
+	return git("rev-parse", "HEAD")
+}
+
+// TagExists reports whether the tag exists in the repository.
+func TagExists(tag string) (bool, error) {
+	_, err := git("rev-parse", "--quiet", "--verify", "refs/tags/"+tag)
+	if err == nil {
+		return true, nil
+	}
+	// --verify --quiet exits 1 with no message when the ref doesn't exist.
+	if e, ok := err.(gitError); ok && e.code == 1 && e.msg == "" {
+		return false, nil
+	}
+	return false, err
+}
+
+// Tags returns the tags in the repository, sorted by name.
+func Tags() ([]string, error) {
+	out, err := git("tag", "--list")
+	if err != nil || out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), nil
+}
+
+// CreateTag makes an annotated tag of the commit that's checked out.
+func CreateTag(tag, message string) error {
+	_, err := git("tag", "--annotate", tag, "--message", message)
+	return err
+}
+
+// PushTag pushes the tag to the remote.
+func PushTag(remote, tag string) error {
+	_, err := git("push", "--quiet", remote, "refs/tags/"+tag)
+	return err
+}
+
+// AddWorktree checks out the ref, detached, in a new worktree at dir, so it
+// can be built or tested alongside the working tree.
+func AddWorktree(dir, ref string) error {
+	_, err := git("worktree", "add", "--quiet", "--detach", dir, ref)
+	return err
+}
+
+// RemoveWorktree removes the worktree at dir, and any changes made in it.
+func RemoveWorktree(dir string) error {
+	_, err := git("worktree", "remove", "--force", dir)
+	return err
+}
+
+// Commit is a commit in the log.
+type Commit struct {
+	SHA     string
+	Subject string
+	Body    string
+}
+
+// Log returns the commits, newest first, that are reachable from the ref to
+// but not from the ref from, leaving out merges.  If from is empty, it's the
+// whole history of to, and if to is empty, it's HEAD.
+func Log(from, to string) ([]Commit, error) {
+	if to == "" {
+		to = "HEAD"
+	}
+	rev := to
+	if from != "" {
+		rev = from + ".." + to
+	}
+	// the fields are separated by the unit separator, and commits by the
+	// record separator, which don't turn up in commit messages.
+	out, err := git("log", "--no-merges", "--format=%H%x1f%s%x1f%b%x1e", rev, "--")
+	if err != nil {
+		return nil, err
+	}
+	var commits []Commit
+	for _, rec := range strings.Split(out, "\x1e") {
+		fields := strings.Split(strings.TrimSpace(rec), "\x1f")
+		if len(fields) != 3 {
+			continue
+		}
+		commits = append(commits, Commit{SHA: fields[0], Subject: fields[1], Body: strings.TrimSpace(fields[2])})
+	}
+	return commits, nil
+}
+
+// gitError is the error from a git command that failed, with what it printed
+// to stderr.
+type gitError struct {
+	args []string
+	code int
+	msg  string
+}
+
+func (e gitError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("git %s failed with exit code %d", strings.Join(e.args, " "), e.code)
+	}
+	return fmt.Sprintf("git %s: %s", strings.Join(e.args, " "), e.msg)
+}
+
+// git runs git with the args, and returns what it prints with the whitespace
+// trimmed.
+func git(args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	ran, err := sh.Exec(nil, stdout, stderr, "git", args...)
+	if !ran {
+		return "", fmt.Errorf("failed to run git: %v", err)
+	}
+	if err != nil {
+		return "", gitError{args: args, code: sh.ExitStatus(err), msg: strings.TrimSpace(stderr.String())}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,187 @@
+package gitx
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// repo changes to a new git repository, and returns a func that runs git in
+// it, and one that changes back and removes it.
+func repo(t *testing.T) (func(args ...string), func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) {
+		args = append([]string{"-c", "user.name=mage", "-c", "user.email=mage@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	run("init", "-q")
+	run("checkout", "-q", "-b", "main")
+	return run, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestGit(t *testing.T) {
+	run, cleanup := repo(t)
+	defer cleanup()
+
+	if _, err := CommitSHA(false); err == nil || !strings.HasPrefix(err.Error(), "git rev-parse HEAD: ") {
+		t.Fatalf("expected the error from git with no commits but got %v", err)
+	}
+
+	ioutil.WriteFile("README.md", []byte("readme"), 0644)
+	if dirty, err := IsDirty(); err != nil || !dirty {
+		t.Fatalf("expected an untracked file to be dirty but got %v, %v", dirty, err)
+	}
+	run("add", "README.md")
+	run("commit", "-q", "-m", "first")
+	if dirty, err := IsDirty(); err != nil || dirty {
+		t.Fatalf("expected a clean tree but got %v, %v", dirty, err)
+	}
+
+	sha, err := CommitSHA(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile("^[0-9a-f]{40}$").MatchString(sha) {
+		t.Fatalf("expected a full SHA but got %q", sha)
+	}
+	short, err := CommitSHA(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(short) >= len(sha) || !strings.HasPrefix(sha, short) {
+		t.Fatalf("expected a short SHA of %s but got %q", sha, short)
+	}
+	if v, err := DescribeVersion(); err != nil || v != short {
+		t.Fatalf("expected %q with no tags but got %q, %v", short, v, err)
+	}
+
+	if ok, err := TagExists("v1.0.0"); err != nil || ok {
+		t.Fatalf("expected no tag but got %v, %v", ok, err)
+	}
+	if tags, err := Tags(); err != nil || tags != nil {
+		t.Fatalf("expected no tags but got %v, %v", tags, err)
+	}
+	run("tag", "-a", "v1.0.0", "-m", "v1.0.0")
+	run("tag", "other")
+	if tags, err := Tags(); err != nil || strings.Join(tags, " ") != "other v1.0.0" {
+		t.Fatalf("expected other and v1.0.0 but got %v, %v", tags, err)
+	}
+	if ok, err := TagExists("v1.0.0"); err != nil || !ok {
+		t.Fatalf("expected the tag but got %v, %v", ok, err)
+	}
+	if v, err := DescribeVersion(); err != nil || v != "v1.0.0" {
+		t.Fatalf("expected v1.0.0 but got %q, %v", v, err)
+	}
+	ioutil.WriteFile("README.md", []byte("changed"), 0644)
+	if v, err := DescribeVersion(); err != nil || v != "v1.0.0-dirty" {
+		t.Fatalf("expected v1.0.0-dirty but got %q, %v", v, err)
+	}
+	if files, err := Changed(); err != nil || strings.Join(files, " ") != "README.md" {
+		t.Fatalf("expected README.md to have changed but got %v, %v", files, err)
+	}
+	if diff, err := Diff("README.md"); err != nil || !strings.Contains(diff, "-readme\n\\ No newline at end of file\n+changed") {
+		t.Fatalf("expected the diff of README.md but got %q, %v", diff, err)
+	}
+	os.Mkdir("gen", 0755)
+	ioutil.WriteFile("gen/new.go", []byte("package gen"), 0644)
+	if files, err := Changed("gen"); err != nil || strings.Join(files, " ") != "gen/new.go" {
+		t.Fatalf("expected the untracked gen/new.go but got %v, %v", files, err)
+	}
+	os.RemoveAll("gen")
+	run("commit", "-q", "-a", "-m", "second")
+	if files, err := Changed(); err != nil || files != nil {
+		t.Fatalf("expected no changes but got %v, %v", files, err)
+	}
+	v, err := DescribeVersion()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^v1\.0\.0-1-g[0-9a-f]+$`).MatchString(v) {
+		t.Fatalf("expected a version one commit after v1.0.0 but got %q", v)
+	}
+
+	if b, err := CurrentBranch(); err != nil || b != "main" {
+		t.Fatalf("expected main but got %q, %v", b, err)
+	}
+	run("checkout", "-q", "--detach")
+	if b, err := CurrentBranch(); err != nil || b != "" {
+		t.Fatalf("expected no branch when detached but got %q, %v", b, err)
+	}
+}
+
+func TestGitNotRepo(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	// keep git from finding a repository above the temp dir.
+	old, ok := os.LookupEnv("GIT_CEILING_DIRECTORIES")
+	os.Setenv("GIT_CEILING_DIRECTORIES", dir)
+	defer func() {
+		if ok {
+			os.Setenv("GIT_CEILING_DIRECTORIES", old)
+		} else {
+			os.Unsetenv("GIT_CEILING_DIRECTORIES")
+		}
+	}()
+	_, err = TagExists("v1.0.0")
+	if err == nil || !strings.Contains(err.Error(), "not a git repository") {
+		t.Fatalf("expected a not a git repository error but got %v", err)
+	}
+}
+
+func TestWorktree(t *testing.T) {
+	run, cleanup := repo(t)
+	defer cleanup()
+	ioutil.WriteFile("README.md", []byte("first"), 0644)
+	run("add", "README.md")
+	run("commit", "-q", "-m", "first")
+	run("tag", "v1")
+	ioutil.WriteFile("README.md", []byte("second"), 0644)
+	run("commit", "-q", "-am", "second")
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wt := dir + "/v1"
+	if err := AddWorktree(wt, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(wt + "/README.md"); string(b) != "first" {
+		t.Fatalf("expected v1 to be checked out, got %q", b)
+	}
+	ioutil.WriteFile(wt+"/README.md", []byte("changed"), 0644)
+	if err := RemoveWorktree(wt); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(wt); !os.IsNotExist(err) {
+		t.Fatalf("expected the worktree to be removed, got %v", err)
+	}
+}
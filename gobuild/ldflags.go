@@ -0,0 +1,188 @@
+// Package gobuild has helpers for building go binaries: stamping them with
+// their version, building them for several platforms at once, and building
+// them to WebAssembly.
+package gobuild
+
+import (
+	"bufio"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// LDFlags are the values to stamp into string variables of a binary with the
+// linker's -X flag, e.g. its version.
+type LDFlags struct {
+	// Package is the import path of the package the variables are in.  It
+	// defaults to main.
+	Package string
+
+	// Version, Commit and Date set the version, commit and date variables.
+	// The date is in RFC 3339 format, in UTC.
+	Version string
+	Commit  string
+	Date    time.Time
+
+	// Vars are the values of other variables, by name.  A name with a dot
+	// in it, like example.com/app/internal/build.Edition, is the full path
+	// of a variable in another package.
+	Vars map[string]string
+
+	// Strip leaves out the symbol table and debug information, with -s -w,
+	// to make the binary smaller.
+	Strip bool
+}
+
+// Flags returns the value for go build's -ldflags.  Values with spaces or
+// quotes are quoted the way the go command splits the flags, which is the
+// same on every platform, since they're passed to go as a single argument
+// rather than through a shell:
+//
+//	ldflags, err := gobuild.LDFlags{Version: version, Date: time.Now()}.Flags()
+//	if err != nil {
+//		return err
+//	}
+//	return sh.RunV(mg.GoCmd(), "build", "-ldflags", ldflags, "./cmd/app")
+func (l LDFlags) Flags() (string, error) {
+	pkg := l.Package
+	if pkg == "" {
+		pkg = "main"
+	}
+	vars := map[string]string{}
+	for name, value := range l.Vars {
+		if !strings.Contains(name, ".") {
+			name = pkg + "." + name
+		}
+		vars[name] = value
+	}
+	if l.Version != "" {
+		vars[pkg+".version"] = l.Version
+	}
+	if l.Commit != "" {
+		vars[pkg+".commit"] = l.Commit
+	}
+	if !l.Date.IsZero() {
+		vars[pkg+".date"] = l.Date.UTC().Format(time.RFC3339)
+	}
+	names := make([]string, 0, len(vars))
+	for name := range vars {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var flags []string
+	if l.Strip {
+		flags = append(flags, "-s", "-w")
+	}
+	for _, name := range names {
+		x, err := quote(name + "=" + vars[name])
+		if err != nil {
+			return "", err
+		}
+		flags = append(flags, "-X", x)
+	}
+	return strings.Join(flags, " "), nil
+}
+
+// quote quotes s, if it needs to be, so the go command reads it as one field.
+// Its quoting has no escapes, so s can't have both kinds of quote in it.
+func quote(s string) (string, error) {
+	if !strings.ContainsAny(s, " \t\n\r'\"") {
+		return s, nil
+	}
+	if !strings.Contains(s, "'") {
+		return "'" + s + "'", nil
+	}
+	if !strings.Contains(s, `"`) {
+		return `"` + s + `"`, nil
+	}
+	return "", fmt.Errorf("can't quote %q for -ldflags, it has both single and double quotes", s)
+}
+
+// ReadLDFlags returns the values a binary was stamped with by -X, by the full
+// name of the variable, e.g. main.version, to check the build stamped what it
+// was meant to.  They're read from the flags recorded in the binary, by go
+// version -m, so it has to be built with go 1.18 or later, and without
+// -trimpath, which leaves them out.
+func ReadLDFlags(binary string) (map[string]string, error) {
+	out, err := sh.Output(mg.GoCmd(), "version", "-m", binary)
+	if err != nil {
+		return nil, err
+	}
+	vars := map[string]string{}
+	trimpath := false
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.SplitN(strings.TrimSpace(scanner.Text()), "\t", 2)
+		if len(fields) == 2 && fields[1] == "-trimpath=true" {
+			trimpath = true
+		}
+		if len(fields) != 2 || fields[0] != "build" || !strings.HasPrefix(fields[1], "-ldflags=") {
+			continue
+		}
+		value := strings.TrimPrefix(fields[1], "-ldflags=")
+		if strings.HasPrefix(value, `"`) {
+			if value, err = strconv.Unquote(value); err != nil {
+				return nil, fmt.Errorf("can't read the -ldflags of %s: %v", binary, err)
+			}
+		}
+		args, err := splitQuoted(value)
+		if err != nil {
+			return nil, fmt.Errorf("can't read the -ldflags of %s: %v", binary, err)
+		}
+		for i := 0; i < len(args); i++ {
+			x := ""
+			switch {
+			case (args[i] == "-X" || args[i] == "--X") && i+1 < len(args):
+				i++
+				x = args[i]
+			case strings.HasPrefix(args[i], "-X="):
+				x = strings.TrimPrefix(args[i], "-X=")
+			default:
+				continue
+			}
+			if kv := strings.SplitN(x, "=", 2); len(kv) == 2 {
+				vars[kv[0]] = kv[1]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(vars) == 0 && trimpath {
+		return nil, fmt.Errorf("can't read the -ldflags of %s, it was built with -trimpath, which leaves them out", binary)
+	}
+	return vars, nil
+}
+
+// splitQuoted splits s into fields at spaces, where a field can be quoted with
+// single or double quotes, like the go command splits -ldflags.
+func splitQuoted(s string) ([]string, error) {
+	var fields []string
+	for {
+		s = strings.TrimLeft(s, " \t\n\r")
+		if s == "" {
+			return fields, nil
+		}
+		if q := s[0]; q == '\'' || q == '"' {
+			end := strings.IndexByte(s[1:], q)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated %c string", q)
+			}
+			fields = append(fields, s[1:end+1])
+			s = s[end+2:]
+			continue
+		}
+		end := strings.IndexAny(s, " \t\n\r")
+		if end < 0 {
+			end = len(s)
+		}
+		fields = append(fields, s[:end])
+		s = s[end:]
+	}
+}
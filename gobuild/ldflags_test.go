@@ -0,0 +1,127 @@
+package gobuild
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+func TestLDFlags(t *testing.T) {
+	tests := []struct {
+		flags    LDFlags
+		expected string
+	}{
+		{LDFlags{}, ""},
+		{LDFlags{Strip: true}, "-s -w"},
+		{
+			LDFlags{Version: "v1.2.0", Commit: "abc123", Date: time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("x", 3600))},
+			"-X main.commit=abc123 -X main.date=2024-05-01T11:00:00Z -X main.version=v1.2.0",
+		},
+		{
+			LDFlags{Package: "example.com/app/version", Version: "1.0 beta", Vars: map[string]string{
+				"by":                      "it's me",
+				"main.Edition":            `"pro"`,
+				"example.com/app/x.plain": "yes",
+			}},
+			`-X "example.com/app/version.by=it's me" -X 'example.com/app/version.version=1.0 beta' -X example.com/app/x.plain=yes -X 'main.Edition="pro"'`,
+		},
+	}
+	for _, tt := range tests {
+		s, err := tt.flags.Flags()
+		if err != nil {
+			t.Errorf("%+v: unexpected error: %v", tt.flags, err)
+			continue
+		}
+		if s != tt.expected {
+			t.Errorf("expected %q but got %q", tt.expected, s)
+		}
+	}
+	_, err := LDFlags{Version: `it's "quoted"`}.Flags()
+	if err == nil || err.Error() != `can't quote "main.version=it's \"quoted\"" for -ldflags, it has both single and double quotes` {
+		t.Errorf("expected a quoting error but got %v", err)
+	}
+}
+
+func TestSplitQuoted(t *testing.T) {
+	fields, err := splitQuoted(` -s  -X 'a.b=1 2' -X "c.d=it's" -X=e.f=3 `)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"-s", "-X", "a.b=1 2", "-X", "c.d=it's", "-X=e.f=3"}
+	if !reflect.DeepEqual(fields, expected) {
+		t.Fatalf("expected %q but got %q", expected, fields)
+	}
+	if _, err := splitQuoted(`-X 'a=b`); err == nil {
+		t.Fatal("expected an error for an unterminated quote")
+	}
+}
+
+func TestReadLDFlags(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"go.mod":  "module example.com/app\n\ngo 1.16\n",
+		"main.go": "package main\n\nvar version, date, note string\n\nfunc main() { println(version, date, note) }\n",
+	}
+	for name, s := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	flags, err := LDFlags{
+		Version: "v1.2.0",
+		Date:    time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC),
+		Vars:    map[string]string{"note": "it's stamped"},
+		Strip:   true,
+	}.Flags()
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "app")
+	cmd := exec.Command(mg.GoCmd(), "build", "-ldflags", flags, "-o", bin, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	out, err := exec.Command(bin).CombinedOutput()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "v1.2.0 2024-05-01T00:00:00Z it's stamped\n" {
+		t.Fatalf("expected the binary to print its stamped values but got %q", out)
+	}
+	vars, err := ReadLDFlags(bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]string{
+		"main.version": "v1.2.0",
+		"main.date":    "2024-05-01T00:00:00Z",
+		"main.note":    "it's stamped",
+	}
+	if !reflect.DeepEqual(vars, expected) {
+		t.Fatalf("expected %v but got %v", expected, vars)
+	}
+
+	cmd = exec.Command(mg.GoCmd(), "build", "-trimpath", "-ldflags", flags, "-o", bin, ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOFLAGS=", "GOWORK=off")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+	_, err = ReadLDFlags(bin)
+	if err == nil || err.Error() != "can't read the -ldflags of "+bin+", it was built with -trimpath, which leaves them out" {
+		t.Fatalf("expected a -trimpath error but got %v", err)
+	}
+}
@@ -0,0 +1,124 @@
+package gobuild
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// WASM builds a package to WebAssembly, to run in a browser with the
+// wasm_exec.js that comes with the toolchain.
+type WASM struct {
+	// Package is the package to build, e.g. ./cmd/web.
+	Package string
+
+	// Output is the path of the .wasm file, e.g. web/dist/app.wasm.
+	// wasm_exec.js is copied next to it.
+	Output string
+
+	// Flags are other flags for the build, e.g. -trimpath.
+	Flags []string
+
+	// LDFlags is the value of -ldflags, e.g. from LDFlags.Flags.
+	LDFlags string
+
+	// TinyGo is the tinygo to build with, e.g. tinygo, instead of go, for
+	// much smaller binaries.
+	TinyGo string
+
+	// Gzip and Brotli write compressed copies of the output next to it, with
+	// .gz and .br added, for servers that serve them precompressed.  Brotli
+	// needs the brotli command.
+	Gzip   bool
+	Brotli bool
+}
+
+// Build builds the package, copies the wasm_exec.js that matches the
+// toolchain next to it, and compresses it, if asked to.
+//
+//	func Web() error {
+//		return gobuild.WASM{Package: "./cmd/web", Output: "web/dist/app.wasm", Gzip: true}.Build()
+//	}
+func (w WASM) Build() error {
+	if err := os.MkdirAll(filepath.Dir(w.Output), 0755); err != nil {
+		return err
+	}
+	var execJS string
+	if w.TinyGo != "" {
+		args := append([]string{"build", "-o", w.Output, "-target", "wasm"}, w.Flags...)
+		if w.LDFlags != "" {
+			args = append(args, "-ldflags", w.LDFlags)
+		}
+		if err := sh.RunV(w.TinyGo, append(args, w.Package)...); err != nil {
+			return err
+		}
+		root, err := sh.Output(w.TinyGo, "env", "TINYGOROOT")
+		if err != nil {
+			return err
+		}
+		execJS = filepath.Join(root, "targets", "wasm_exec.js")
+	} else {
+		args := append([]string{"build", "-o", w.Output}, w.Flags...)
+		if w.LDFlags != "" {
+			args = append(args, "-ldflags", w.LDFlags)
+		}
+		env := map[string]string{"GOOS": "js", "GOARCH": "wasm"}
+		if err := sh.RunWithV(env, mg.GoCmd(), append(args, w.Package)...); err != nil {
+			return err
+		}
+		root, err := sh.Output(mg.GoCmd(), "env", "GOROOT")
+		if err != nil {
+			return err
+		}
+		// it moved from misc/wasm to lib/wasm in go 1.24.
+		execJS = filepath.Join(root, "lib", "wasm", "wasm_exec.js")
+		if _, err := os.Stat(execJS); os.IsNotExist(err) {
+			execJS = filepath.Join(root, "misc", "wasm", "wasm_exec.js")
+		}
+	}
+	if err := sh.Copy(filepath.Join(filepath.Dir(w.Output), "wasm_exec.js"), execJS); err != nil {
+		return fmt.Errorf("can't copy wasm_exec.js: %v", err)
+	}
+	if w.Gzip {
+		if err := gzipFile(w.Output); err != nil {
+			return err
+		}
+	}
+	if w.Brotli {
+		if err := sh.Run("brotli", "--force", "--best", "--output="+w.Output+".br", w.Output); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gzipFile writes the file, compressed as much as it can be, to its path
+// plus .gz.
+func gzipFile(path string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ".gz")
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	gz, err := gzip.NewWriterLevel(out, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(gz, in); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return out.Close()
+}
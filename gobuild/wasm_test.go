@@ -0,0 +1,97 @@
+package gobuild
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"tinygo": tinygo, "brotli": brotli})
+	os.Exit(m.Run())
+}
+
+// tinygo stands in for tinygo.  tinygo env prints the directory root next to
+// it, and tinygo build logs its args to the file log next to it and writes
+// the output.
+func tinygo(args []string) int {
+	dir := faketool.Dir()
+	if len(args) > 0 && args[0] == "env" {
+		fmt.Println(filepath.Join(dir, "root"))
+		return 0
+	}
+	faketool.Append(filepath.Join(dir, "log"), "tinygo "+strings.Join(args, " "))
+	ioutil.WriteFile(args[2], []byte("wasm\n"), 0644)
+	return 0
+}
+
+// brotli stands in for brotli, logging its args to the file log next to it.
+func brotli(args []string) int {
+	faketool.Append(filepath.Join(faketool.Dir(), "log"), "brotli "+strings.Join(args, " "))
+	return 0
+}
+
+func TestWASM(t *testing.T) {
+	defer project(t, "package main\n\nfunc main() { println(\"hi\") }\n")()
+	err := WASM{
+		Package: "./cmd/app",
+		Output:  "web/dist/app.wasm",
+		Flags:   []string{"-buildvcs=false"},
+		Gzip:    true,
+	}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	wasm, err := ioutil.ReadFile("web/dist/app.wasm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(wasm, []byte("\x00asm")) {
+		t.Fatal("expected a wasm module")
+	}
+	if _, err := os.Stat("web/dist/wasm_exec.js"); err != nil {
+		t.Fatalf("expected wasm_exec.js to be copied: %v", err)
+	}
+	f, err := os.Open("web/dist/app.wasm.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(gz); err != nil || !bytes.Equal(b, wasm) {
+		t.Fatalf("expected the gzipped wasm, got %d bytes: %v", len(b), err)
+	}
+}
+
+func TestWASMTinyGo(t *testing.T) {
+	dir, cleanup := faketool.OnPath(t, "tinygo", "brotli")
+	defer cleanup()
+	os.MkdirAll(filepath.Join(dir, "root", "targets"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "root", "targets", "wasm_exec.js"), []byte("// tinygo"), 0644)
+	logFile := filepath.Join(dir, "log")
+
+	out := filepath.Join(dir, "dist", "app.wasm")
+	err := WASM{Package: "./cmd/web", Output: out, TinyGo: "tinygo", LDFlags: "-X main.version=v1", Brotli: true}.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(logFile)
+	expected := "tinygo build -o " + out + " -target wasm -ldflags -X main.version=v1 ./cmd/web\n" +
+		"brotli --force --best --output=" + out + ".br " + out + "\n"
+	if string(b) != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, b)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "dist", "wasm_exec.js")); string(b) != "// tinygo" {
+		t.Fatalf("expected tinygo's wasm_exec.js, got %q", b)
+	}
+}
@@ -0,0 +1,211 @@
+package gobuild
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"text/template"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/osinfo"
+	"github.com/magefile/mage/sh"
+)
+
+// Platform is a platform to build for.
+type Platform struct {
+	OS   string
+	Arch string
+
+	// ARM is the GOARM variant for arm, e.g. 7.
+	ARM string
+
+	// CGO enables cgo, which is disabled by default, so the binaries don't
+	// need the C toolchain for each platform.
+	CGO bool
+
+	// Env is any other environment for the build, e.g. CC.
+	Env map[string]string
+}
+
+// ParsePlatform parses a platform like linux/amd64, or linux/arm/v7 for an arm
+// variant.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected os/arch or os/arch/variant", s)
+	}
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		if p.Arch != "arm" || !strings.HasPrefix(parts[2], "v") {
+			return Platform{}, fmt.Errorf("invalid platform %q, only arm has variants, like linux/arm/v7", s)
+		}
+		p.ARM = strings.TrimPrefix(parts[2], "v")
+	}
+	return p, nil
+}
+
+// String returns the platform as os/arch, or os/arch/variant.
+func (p Platform) String() string {
+	if p.ARM != "" {
+		return p.OS + "/" + p.Arch + "/v" + p.ARM
+	}
+	return p.OS + "/" + p.Arch
+}
+
+// Ext returns the extension of binaries for the platform, .exe for windows.
+func (p Platform) Ext() string {
+	if p.OS == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// CrossBuild builds a package for several platforms.
+type CrossBuild struct {
+	// Package is the package to build, e.g. ./cmd/app.
+	Package string
+
+	// Output is the template for the path of each binary, in text/template
+	// syntax, with the Platform's fields and Ext, e.g.
+	// "bin/{{.OS}}-{{.Arch}}/app{{.Ext}}".
+	Output string
+
+	// Platforms are the platforms to build for.
+	Platforms []Platform
+
+	// Flags are other flags for go build, e.g. -trimpath.
+	Flags []string
+
+	// LDFlags is the value of -ldflags, e.g. from LDFlags.Flags.
+	LDFlags string
+
+	// Parallel is how many builds run at once.  It defaults to the number of
+	// CPUs.
+	Parallel int
+}
+
+// Result is the result of building for one platform.
+type Result struct {
+	Platform Platform
+
+	// Path is the path of the binary.
+	Path string
+
+	// Duration is how long the build took.
+	Duration time.Duration
+
+	// Err is why the build failed: the compiler's output, if it printed
+	// any.
+	Err error
+}
+
+// Results are the results of a CrossBuild, in the order of its Platforms.
+type Results []Result
+
+// Run builds the package for each platform, several at once, and returns the
+// results.  It returns an error if any of the builds failed, after they've all
+// finished.
+//
+//	func Build() error {
+//		results, err := gobuild.CrossBuild{
+//			Package:   "./cmd/app",
+//			Output:    "bin/{{.OS}}-{{.Arch}}/app{{.Ext}}",
+//			Platforms: platforms,
+//		}.Run()
+//		results.Report(os.Stdout)
+//		return err
+//	}
+func (c CrossBuild) Run() (Results, error) {
+	tmpl, err := template.New("output").Option("missingkey=error").Parse(c.Output)
+	if err != nil {
+		return nil, fmt.Errorf("invalid output template: %v", err)
+	}
+	parallel := c.Parallel
+	if parallel <= 0 {
+		parallel = osinfo.CPUs()
+	}
+
+	results := make(Results, len(c.Platforms))
+	sem := make(chan struct{}, parallel)
+	var wg sync.WaitGroup
+	for i, p := range c.Platforms {
+		var path bytes.Buffer
+		if err := tmpl.Execute(&path, struct {
+			Platform
+			Ext string
+		}{p, p.Ext()}); err != nil {
+			return nil, fmt.Errorf("invalid output template: %v", err)
+		}
+		results[i] = Result{Platform: p, Path: path.String()}
+		wg.Add(1)
+		go func(r *Result) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			start := clock.Now()
+			r.Err = c.build(r.Platform, r.Path)
+			r.Duration = clock.Since(start)
+		}(&results[i])
+	}
+	wg.Wait()
+
+	var failed []string
+	for _, r := range results {
+		if r.Err != nil {
+			failed = append(failed, r.Platform.String())
+		}
+	}
+	if len(failed) > 0 {
+		return results, fmt.Errorf("%d of %d builds failed: %s", len(failed), len(results), strings.Join(failed, ", "))
+	}
+	return results, nil
+}
+
+// build builds the package for the platform.
+func (c CrossBuild) build(p Platform, path string) error {
+	env := map[string]string{"GOOS": p.OS, "GOARCH": p.Arch, "CGO_ENABLED": "0"}
+	if p.CGO {
+		env["CGO_ENABLED"] = "1"
+	}
+	if p.ARM != "" {
+		env["GOARM"] = p.ARM
+	}
+	for k, v := range p.Env {
+		env[k] = v
+	}
+	args := append([]string{"build", "-o", path}, c.Flags...)
+	if c.LDFlags != "" {
+		args = append(args, "-ldflags", c.LDFlags)
+	}
+	args = append(args, c.Package)
+	// the compiler's output is kept with the result, rather than printed,
+	// so the output of builds running at once isn't mixed up.
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(env, out, out, mg.GoCmd(), args...); err != nil {
+		if msg := strings.TrimSpace(out.String()); msg != "" {
+			return errors.New(msg)
+		}
+		return err
+	}
+	return nil
+}
+
+// Report writes a table of the results, with how long each build took, and
+// the binary, or the error on one line.
+func (r Results) Report(w io.Writer) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	for _, res := range r {
+		status, detail := "ok", res.Path
+		if res.Err != nil {
+			status, detail = "FAILED", strings.Replace(res.Err.Error(), "\n", "; ", -1)
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%v\n", res.Platform, status, res.Duration.Round(time.Millisecond), detail)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,136 @@
+package gobuild
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestParsePlatform(t *testing.T) {
+	valid := map[string]Platform{
+		"linux/amd64":  {OS: "linux", Arch: "amd64"},
+		"linux/arm/v7": {OS: "linux", Arch: "arm", ARM: "7"},
+	}
+	for s, expected := range valid {
+		p, err := ParsePlatform(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		if p.String() != s || p.OS != expected.OS || p.Arch != expected.Arch || p.ARM != expected.ARM {
+			t.Errorf("%s: expected %+v but got %+v", s, expected, p)
+		}
+	}
+	invalid := map[string]string{
+		"linux":          `invalid platform "linux", expected os/arch or os/arch/variant`,
+		"linux/":         `invalid platform "linux/", expected os/arch or os/arch/variant`,
+		"linux/amd64/v3": `invalid platform "linux/amd64/v3", only arm has variants, like linux/arm/v7`,
+		"a/b/c/d":        `invalid platform "a/b/c/d", expected os/arch or os/arch/variant`,
+	}
+	for s, expected := range invalid {
+		if _, err := ParsePlatform(s); err == nil || err.Error() != expected {
+			t.Errorf("%s: expected %q but got %v", s, expected, err)
+		}
+	}
+}
+
+// project changes to a new module with a main package in cmd/app, and returns
+// a func that changes back and removes it.
+func project(t *testing.T, main string) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join("cmd", "app"), 0755)
+	ioutil.WriteFile("go.mod", []byte("module example.com/app\n\ngo 1.16\n"), 0644)
+	ioutil.WriteFile(filepath.Join("cmd", "app", "main.go"), []byte(main), 0644)
+	oldFlags, oldWork := os.Getenv("GOFLAGS"), os.Getenv("GOWORK")
+	os.Setenv("GOFLAGS", "")
+	os.Setenv("GOWORK", "off")
+	return func() {
+		os.Setenv("GOFLAGS", oldFlags)
+		os.Setenv("GOWORK", oldWork)
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestCrossBuild(t *testing.T) {
+	defer project(t, "package main\n\nvar version string\n\nfunc main() { println(version) }\n")()
+	host := Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	windows := Platform{OS: "windows", Arch: "amd64"}
+	results, err := CrossBuild{
+		Package:   "./cmd/app",
+		Output:    "bin/{{.OS}}-{{.Arch}}/app{{.Ext}}",
+		Platforms: []Platform{host, windows},
+		Flags:     []string{"-buildvcs=false"},
+		LDFlags:   "-X main.version=v1",
+		Parallel:  1,
+	}.Run()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"bin/" + runtime.GOOS + "-" + runtime.GOARCH + "/app" + host.Ext(),
+		"bin/windows-amd64/app.exe",
+	}
+	for i, r := range results {
+		if r.Path != expected[i] {
+			t.Errorf("expected %s but got %s", expected[i], r.Path)
+		}
+		if _, err := os.Stat(r.Path); err != nil {
+			t.Errorf("expected %s to be built: %v", r.Path, err)
+		}
+		if r.Duration <= 0 {
+			t.Errorf("expected the duration of %s", r.Platform)
+		}
+	}
+	vars, err := ReadLDFlags(results[0].Path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if vars["main.version"] != "v1" {
+		t.Fatalf("expected the ldflags to be used but got %v", vars)
+	}
+	out := &bytes.Buffer{}
+	results.Report(out)
+	re := regexp.MustCompile(`(?m)^windows/amd64 +ok +\d+ms +bin/windows-amd64/app.exe$`)
+	if !re.MatchString(out.String()) {
+		t.Fatalf("expected the report to have the windows build but got:\n%s", out)
+	}
+}
+
+func TestCrossBuildFailure(t *testing.T) {
+	defer project(t, "package main\n\nfunc main() { undefined() }\n")()
+	results, err := CrossBuild{
+		Package:   "./cmd/app",
+		Output:    "bin/app-{{.OS}}{{.Ext}}",
+		Platforms: []Platform{{OS: runtime.GOOS, Arch: runtime.GOARCH}, {OS: "plan9", Arch: "nope"}},
+	}.Run()
+	expected := "2 of 2 builds failed: " + runtime.GOOS + "/" + runtime.GOARCH + ", plan9/nope"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q but got %v", expected, err)
+	}
+	if !strings.Contains(results[0].Err.Error(), "undefined: undefined") {
+		t.Fatalf("expected the compiler's error but got %v", results[0].Err)
+	}
+	out := &bytes.Buffer{}
+	results.Report(out)
+	if lines := strings.Split(strings.TrimSpace(out.String()), "\n"); len(lines) != 2 || !strings.Contains(lines[0], "FAILED") {
+		t.Fatalf("expected a line for each failure but got:\n%s", out)
+	}
+
+	_, err = CrossBuild{Output: "bin/{{.Nope}}", Platforms: []Platform{{OS: "linux", Arch: "amd64"}}}.Run()
+	if err == nil || !strings.HasPrefix(err.Error(), "invalid output template: ") {
+		t.Fatalf("expected an invalid template error but got %v", err)
+	}
+}
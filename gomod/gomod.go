@@ -0,0 +1,234 @@
+// Package gomod maintains the go.mod of the module in the working directory:
+// checking that it and the vendor directory are up to date, bumping a
+// dependency, and listing the dependencies that have newer versions.  Each runs mg.GoCmd(), and the go command's error
+// message is returned if it fails.
+package gomod
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Module is a module from go list -m -json.
+type Module struct {
+	Path       string     // module path
+	Version    string     // module version
+	Time       *time.Time // time version was created
+	Update     *Module    // available update, if any
+	Replace    *Module    // replaced by this module
+	Main       bool       // is this the main module?
+	Indirect   bool       // is this module only an indirect dependency of the main module?
+	Dir        string     // directory holding files for this module, if any
+	GoVersion  string     // go version used in module
+	Deprecated string     // deprecation message, if any
+}
+
+// Outdated returns the direct dependencies of the module that have newer
+// versions, from go list -u -m -json all, with the newest version in their
+// Update.  It needs to reach the module proxy.
+func Outdated() ([]Module, error) {
+	out, err := goCmd("list", "-u", "-m", "-json", "all")
+	if err != nil {
+		return nil, err
+	}
+	var mods []Module
+	dec := json.NewDecoder(strings.NewReader(out))
+	for {
+		var m Module
+		if err := dec.Decode(&m); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("can't parse the output of go list: %v", err)
+		}
+		if !m.Main && !m.Indirect && m.Update != nil {
+			mods = append(mods, m)
+		}
+	}
+	return mods, nil
+}
+
+// TidyCheck returns an error if go mod tidy would change go.mod or go.sum,
+// which says what it would change.  The files are put back as they were
+// afterwards, so it's safe to run on a developer's checkout, as well as in CI.
+func TidyCheck() error {
+	files, err := modFiles()
+	if err != nil {
+		return err
+	}
+	restore, err := save(files)
+	if err != nil {
+		return err
+	}
+	defer restore()
+	if _, err := goCmd("mod", "tidy"); err != nil {
+		return err
+	}
+	var diffs []string
+	for _, f := range files {
+		b, _ := ioutil.ReadFile(f.path)
+		if d := diff(f.data, b); d != "" {
+			diffs = append(diffs, filepath.Base(f.path)+":\n"+d)
+		}
+	}
+	if len(diffs) > 0 {
+		return fmt.Errorf("go.mod isn't tidy, run go mod tidy and commit the changes:\n%s", strings.Join(diffs, "\n"))
+	}
+	return nil
+}
+
+// Bump updates the dependency to the version, e.g. "latest" or "v1.2.3", with
+// go get and go mod tidy, then runs go test with the args, or ./... if there
+// are none.  If any of them fail, go.mod and go.sum are put back as they were.
+func Bump(module, version string, testArgs ...string) (err error) {
+	files, err := modFiles()
+	if err != nil {
+		return err
+	}
+	restore, err := save(files)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			restore()
+		}
+	}()
+	if _, err := goCmd("get", module+"@"+version); err != nil {
+		return err
+	}
+	if _, err := goCmd("mod", "tidy"); err != nil {
+		return err
+	}
+	if len(testArgs) == 0 {
+		testArgs = []string{"./..."}
+	}
+	if err := sh.RunV(mg.GoCmd(), append([]string{"test"}, testArgs...)...); err != nil {
+		return fmt.Errorf("tests failed after bumping %s to %s, so it's not bumped: %v", module, version, err)
+	}
+	return nil
+}
+
+// file is the path and contents of a file, or nil contents if it doesn't
+// exist.
+type file struct {
+	path string
+	data []byte
+}
+
+// modFiles returns the go.mod and go.sum of the module in the working
+// directory.
+func modFiles() ([]file, error) {
+	gomod, err := goCmd("env", "GOMOD")
+	if err != nil {
+		return nil, err
+	}
+	if gomod == "" || gomod == os.DevNull {
+		return nil, fmt.Errorf("there's no go.mod in the working directory or above it")
+	}
+	gosum := strings.TrimSuffix(gomod, ".mod") + ".sum"
+	return []file{{path: gomod}, {path: gosum}}, nil
+}
+
+// save reads the files, and returns a func that puts them back as they were.
+func save(files []file) (restore func(), err error) {
+	for i := range files {
+		b, err := ioutil.ReadFile(files[i].path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		files[i].data = b
+	}
+	return func() {
+		for _, f := range files {
+			if f.data == nil {
+				os.Remove(f.path)
+				continue
+			}
+			ioutil.WriteFile(f.path, f.data, 0644)
+		}
+	}, nil
+}
+
+// diff returns the lines removed from a, with -, and added to b, with +, or
+// "" if they're the same.  go.mod and go.sum lines are unique, so it doesn't
+// need to care about their order.
+func diff(a, b []byte) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	lines := func(data []byte) []string {
+		var ls []string
+		for _, l := range strings.Split(string(data), "\n") {
+			if l = strings.TrimSpace(l); l != "" {
+				ls = append(ls, l)
+			}
+		}
+		return ls
+	}
+	// removed counts the lines of a that aren't in b.
+	removed := map[string]int{}
+	for _, l := range lines(a) {
+		removed[l]++
+	}
+	var added []string
+	for _, l := range lines(b) {
+		if removed[l] > 0 {
+			removed[l]--
+		} else {
+			added = append(added, "  + "+l)
+		}
+	}
+	var out []string
+	for _, l := range lines(a) {
+		if removed[l] > 0 {
+			out = append(out, "  - "+l)
+			removed[l]--
+		}
+	}
+	out = append(out, added...)
+	if len(out) == 0 {
+		// only the whitespace changed.
+		return "  (formatting)"
+	}
+	return strings.Join(out, "\n")
+}
+
+// goError is the error from a go command that failed, with what it printed
+// to stderr.
+type goError struct {
+	args []string
+	code int
+	msg  string
+}
+
+func (e goError) Error() string {
+	if e.msg == "" {
+		return fmt.Sprintf("go %s failed with exit code %d", strings.Join(e.args, " "), e.code)
+	}
+	return fmt.Sprintf("go %s: %s", strings.Join(e.args, " "), e.msg)
+}
+
+// goCmd runs the go command with the args, and returns what it prints with
+// the whitespace trimmed.
+func goCmd(args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	ran, err := sh.Exec(nil, stdout, stderr, mg.GoCmd(), append([]string(nil), args...)...)
+	if !ran {
+		return "", fmt.Errorf("failed to run go: %v", err)
+	}
+	if err != nil {
+		return "", goError{args: args, code: sh.ExitStatus(err), msg: strings.TrimSpace(stderr.String())}
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
@@ -0,0 +1,190 @@
+package gomod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/mg"
+)
+
+const goMod = "module example.com/app\n\ngo 1.21\n\nrequire example.com/lib v1.0.0\n"
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"go": fakeGoCmd})
+	os.Exit(m.Run())
+}
+
+// fakeGoCmd stands in for go, logging its args to the file log next to it,
+// where the go.mod it reports is too.  "mod tidy" and "get" write FAKE_TIDY
+// and FAKE_GET to the go.mod, "mod vendor" copies the files in FAKE_VENDOR,
+// "list -u" prints the file list.json next to it, and "test" exits with
+// FAKE_TEST_EXIT.
+func fakeGoCmd(args []string) int {
+	dir := faketool.Dir()
+	gomod := filepath.Join(dir, "go.mod")
+	faketool.Append(filepath.Join(dir, "log"), strings.Join(args, " "))
+	if len(args) < 2 {
+		return 0
+	}
+	switch {
+	case args[0] == "env" && args[1] == "GOMOD":
+		fmt.Println(gomod)
+	case args[0] == "mod" && args[1] == "tidy":
+		if tidy := os.Getenv("FAKE_TIDY"); tidy != "" {
+			ioutil.WriteFile(gomod, []byte(tidy), 0644)
+		}
+	case args[0] == "mod" && args[1] == "vendor":
+		if err := copyDir(os.Getenv("FAKE_VENDOR"), args[3]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	case args[0] == "list" && args[1] == "-u":
+		faketool.Cat(filepath.Join(dir, "list.json"))
+	case args[0] == "get":
+		ioutil.WriteFile(gomod, []byte(os.Getenv("FAKE_GET")), 0644)
+	case args[0] == "test":
+		code, _ := strconv.Atoi(os.Getenv("FAKE_TEST_EXIT"))
+		return code
+	}
+	return 0
+}
+
+// copyDir copies the files in src to dst.
+func copyDir(src, dst string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return os.MkdirAll(filepath.Join(dst, rel), 0755)
+		}
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(dst, rel), b, fi.Mode())
+	})
+}
+
+// fakeGo makes MAGEFILE_GOCMD a fake go, as fakeGoCmd describes.  It returns
+// the directory with the go.mod, a func that reads the log, and one that puts
+// things back.
+func fakeGo(t *testing.T) (string, func() string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	gomod := filepath.Join(dir, "go.mod")
+	bin := faketool.Install(t, dir, "go")
+	if err := ioutil.WriteFile(gomod, []byte(goMod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(mg.GoCmdEnv, bin)
+	return dir, func() string { return faketool.Log(dir) }, func() {
+		os.Unsetenv(mg.GoCmdEnv)
+		for _, k := range []string{"FAKE_TIDY", "FAKE_GET", "FAKE_VENDOR", "FAKE_TEST_EXIT"} {
+			os.Unsetenv(k)
+		}
+		os.RemoveAll(dir)
+	}
+}
+
+func TestTidyCheck(t *testing.T) {
+	dir, read, cleanup := fakeGo(t)
+	defer cleanup()
+	if err := TidyCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	os.Setenv("FAKE_TIDY", "module example.com/app\n\ngo 1.21\n\nrequire example.com/lib v1.1.0\n")
+	ioutil.WriteFile(filepath.Join(dir, "go.sum"), []byte("example.com/lib v1.0.0 h1:abc=\n"), 0644)
+	err := TidyCheck()
+	expected := "go.mod isn't tidy, run go mod tidy and commit the changes:\ngo.mod:\n" +
+		"  - require example.com/lib v1.0.0\n  + require example.com/lib v1.1.0"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "go.mod")); string(b) != goMod {
+		t.Fatalf("expected go.mod to be put back, got %q", b)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "go.sum")); string(b) != "example.com/lib v1.0.0 h1:abc=\n" {
+		t.Fatalf("expected go.sum to be put back, got %q", b)
+	}
+	if log := read(); log != "env GOMOD\nmod tidy\nenv GOMOD\nmod tidy\n" {
+		t.Fatalf("unexpected commands %q", log)
+	}
+
+	os.Setenv("FAKE_TIDY", "module example.com/app\ngo 1.21\nrequire example.com/lib v1.0.0\n")
+	if err := TidyCheck(); err == nil || !strings.HasSuffix(err.Error(), "go.mod:\n  (formatting)") {
+		t.Fatalf("expected a formatting change, got %v", err)
+	}
+}
+
+func TestBump(t *testing.T) {
+	dir, read, cleanup := fakeGo(t)
+	defer cleanup()
+	bumped := "module example.com/app\n\ngo 1.21\n\nrequire example.com/lib v1.2.0\n"
+	os.Setenv("FAKE_GET", bumped)
+
+	os.Setenv("FAKE_TEST_EXIT", "1")
+	err := Bump("example.com/lib", "v1.2.0", "-short", "./lib/...")
+	if err == nil || !strings.HasPrefix(err.Error(), "tests failed after bumping example.com/lib to v1.2.0, so it's not bumped") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "go.mod")); string(b) != goMod {
+		t.Fatalf("expected go.mod to be put back, got %q", b)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "go.sum")); !os.IsNotExist(err) {
+		t.Fatalf("expected no go.sum, since there wasn't one, got %v", err)
+	}
+	if log := read(); log != "env GOMOD\nget example.com/lib@v1.2.0\nmod tidy\ntest -short ./lib/...\n" {
+		t.Fatalf("unexpected commands %q", log)
+	}
+
+	os.Setenv("FAKE_TEST_EXIT", "0")
+	if err := Bump("example.com/lib", "v1.2.0"); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "go.mod")); string(b) != bumped {
+		t.Fatalf("expected go.mod to be bumped, got %q", b)
+	}
+	if log := read(); !strings.HasSuffix(log, "test ./...\n") {
+		t.Fatalf("expected go test ./..., got %q", log)
+	}
+}
+
+func TestOutdated(t *testing.T) {
+	dir, read, cleanup := fakeGo(t)
+	defer cleanup()
+	list := `{"Path": "example.com/app", "Main": true}
+{"Path": "example.com/lib", "Version": "v1.0.0", "Time": "2024-01-02T03:04:05Z", "Update": {"Path": "example.com/lib", "Version": "v1.3.0"}}
+{"Path": "example.com/dep", "Version": "v0.1.0", "Indirect": true, "Update": {"Path": "example.com/dep", "Version": "v0.2.0"}}
+{"Path": "example.com/current", "Version": "v2.0.0"}
+`
+	ioutil.WriteFile(filepath.Join(dir, "list.json"), []byte(list), 0644)
+	mods, err := Outdated()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(mods) != 1 || mods[0].Path != "example.com/lib" || mods[0].Update.Version != "v1.3.0" || mods[0].Time.Year() != 2024 {
+		t.Fatalf("unexpected modules %+v", mods)
+	}
+	if log := read(); log != "list -u -m -json all\n" {
+		t.Fatalf("unexpected commands %q", log)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "list.json"), []byte("{oops"), 0644)
+	if _, err := Outdated(); err == nil || !strings.HasPrefix(err.Error(), "can't parse the output of go list") {
+		t.Fatalf("expected a parse error, got %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package gomod
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// maxVendorReport is how many files of each kind VendorCheck lists.
+const maxVendorReport = 10
+
+// VendorCheck returns an error if the vendor directory isn't what go mod
+// vendor makes, which lists the files that are missing, shouldn't be there,
+// or are different.  go mod vendor is run into a temporary directory, so the
+// vendor directory isn't changed.  It needs go 1.18 or later.
+func VendorCheck() error {
+	files, err := modFiles()
+	if err != nil {
+		return err
+	}
+	vendor := filepath.Join(filepath.Dir(files[0].path), "vendor")
+	tmp, err := ioutil.TempDir("", "mage-vendor-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	want := filepath.Join(tmp, "vendor")
+	if _, err := goCmd("mod", "vendor", "-o", want); err != nil {
+		return err
+	}
+
+	wantFiles, err := listFiles(want)
+	if err != nil {
+		return err
+	}
+	gotFiles, err := listFiles(vendor)
+	if err != nil {
+		return err
+	}
+	var missing, extra, changed []string
+	for name := range wantFiles {
+		if !gotFiles[name] {
+			missing = append(missing, name)
+			continue
+		}
+		a, err := ioutil.ReadFile(filepath.Join(want, name))
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadFile(filepath.Join(vendor, name))
+		if err != nil {
+			return err
+		}
+		if !bytes.Equal(a, b) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range gotFiles {
+		if !wantFiles[name] {
+			extra = append(extra, name)
+		}
+	}
+	if len(missing)+len(extra)+len(changed) == 0 {
+		return nil
+	}
+	var report []string
+	report = appendReport(report, "missing", missing)
+	report = appendReport(report, "not needed", extra)
+	report = appendReport(report, "different", changed)
+	return fmt.Errorf("vendor isn't up to date, run go mod vendor and commit the changes:\n%s", strings.Join(report, "\n"))
+}
+
+// appendReport adds the kind of file and the first few of them to the
+// report.
+func appendReport(report []string, kind string, names []string) []string {
+	if len(names) == 0 {
+		return report
+	}
+	sort.Strings(names)
+	report = append(report, fmt.Sprintf("  %d %s:", len(names), kind))
+	for i, name := range names {
+		if i == maxVendorReport {
+			report = append(report, fmt.Sprintf("    and %d more", len(names)-i))
+			break
+		}
+		report = append(report, "    vendor/"+name)
+	}
+	return report
+}
+
+// listFiles returns the slash separated paths of the files in dir, or none
+// if it doesn't exist.
+func listFiles(dir string) (map[string]bool, error) {
+	files := map[string]bool{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files[filepath.ToSlash(rel)] = true
+		}
+		return nil
+	})
+	return files, err
+}
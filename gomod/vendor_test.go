@@ -0,0 +1,79 @@
+package gomod
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeTree writes the files, by slash separated path, under dir.
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestVendorCheck(t *testing.T) {
+	dir, read, cleanup := fakeGo(t)
+	defer cleanup()
+	fresh := filepath.Join(dir, "fresh")
+	vendored := map[string]string{
+		"modules.txt":                 "# example.com/lib v1.0.0\n",
+		"example.com/lib/lib.go":      "package lib\n",
+		"example.com/lib/sub/sub.go":  "package sub\n",
+		"example.com/other/other.go":  "package other\n",
+		"example.com/other/LICENSE":   "MIT\n",
+		"example.com/other/README.md": "hi\n",
+	}
+	writeTree(t, fresh, vendored)
+	os.Setenv("FAKE_VENDOR", fresh)
+
+	err := VendorCheck()
+	if err == nil || !strings.Contains(err.Error(), "  6 missing:\n    vendor/example.com/lib/lib.go\n") {
+		t.Fatalf("expected every file to be missing, got %v", err)
+	}
+	if log := read(); !strings.Contains(log, "mod vendor -o ") {
+		t.Fatalf("expected go mod vendor -o, got %q", log)
+	}
+
+	writeTree(t, filepath.Join(dir, "vendor"), vendored)
+	if err := VendorCheck(); err != nil {
+		t.Fatal(err)
+	}
+
+	writeTree(t, filepath.Join(dir, "vendor"), map[string]string{
+		"modules.txt":            "# example.com/lib v0.9.0\n",
+		"example.com/old/old.go": "package old\n",
+	})
+	os.Remove(filepath.Join(dir, "vendor", "example.com", "other", "LICENSE"))
+	expected := `vendor isn't up to date, run go mod vendor and commit the changes:
+  1 missing:
+    vendor/example.com/other/LICENSE
+  1 not needed:
+    vendor/example.com/old/old.go
+  1 different:
+    vendor/modules.txt`
+	if err := VendorCheck(); err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+}
+
+func TestAppendReport(t *testing.T) {
+	var names []string
+	for i := 0; i < 12; i++ {
+		names = append(names, fmt.Sprintf("f%02d.go", i))
+	}
+	report := appendReport(nil, "missing", names)
+	if len(report) != 12 || report[0] != "  12 missing:" || report[11] != "    and 2 more" {
+		t.Fatalf("unexpected report %q", report)
+	}
+}
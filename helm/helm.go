@@ -0,0 +1,156 @@
+// Package helm lints, packages and pushes Helm charts, and installs them, with
+// the helm CLI.  A command that fails returns an *Error that says which step
+// it was.
+package helm
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/magefile/mage/dockerx"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Error is the error from a helm command that failed.
+type Error struct {
+	// Step is the step that failed, e.g. lint, package or install.
+	Step string
+
+	// Ref is what the step was for, e.g. the chart or the release.
+	Ref string
+
+	// Err is the error from running helm.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("helm %s of %s failed: %v", e.Step, e.Ref, e.Err)
+}
+
+// ExitStatus returns the exit code helm failed with, so mage exits with it.
+func (e *Error) ExitStatus() int {
+	return mg.ExitStatus(e.Err)
+}
+
+// Lint checks that the chart is well-formed, with warnings treated as
+// failures if strict is true.  The values files are used to render the
+// templates, like for an install.
+func Lint(chart string, strict bool, valuesFiles ...string) error {
+	args := []string{"lint", chart}
+	if strict {
+		args = append(args, "--strict")
+	}
+	for _, f := range valuesFiles {
+		args = append(args, "--values", f)
+	}
+	if err := sh.RunV("helm", args...); err != nil {
+		return &Error{Step: "lint", Ref: chart, Err: err}
+	}
+	return nil
+}
+
+// PackageOptions are the options for Package.
+type PackageOptions struct {
+	// Destination is the directory the package is written to.  It defaults
+	// to the working directory.
+	Destination string
+
+	// Version replaces the chart's version, e.g. with the version of the
+	// release.  A leading v, as in a git tag, is dropped.
+	Version string
+
+	// AppVersion replaces the chart's appVersion, e.g. with the tag of the
+	// image the chart deploys.
+	AppVersion string
+
+	// DependencyUpdate updates the chart's dependencies before packaging
+	// it.
+	DependencyUpdate bool
+}
+
+// Package packages the chart in a directory, and returns the path of the
+// package, e.g. app-1.2.3.tgz.
+func Package(chart string, opts PackageOptions) (string, error) {
+	args := []string{"package", chart}
+	if opts.Destination != "" {
+		args = append(args, "--destination", opts.Destination)
+	}
+	if opts.Version != "" {
+		args = append(args, "--version", strings.TrimPrefix(opts.Version, "v"))
+	}
+	if opts.AppVersion != "" {
+		args = append(args, "--app-version", opts.AppVersion)
+	}
+	if opts.DependencyUpdate {
+		args = append(args, "--dependency-update")
+	}
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(nil, out, os.Stderr, "helm", args...); err != nil {
+		return "", &Error{Step: "package", Ref: chart, Err: err}
+	}
+	os.Stdout.Write(out.Bytes())
+	const saved = "saved it to: "
+	s := out.String()
+	i := strings.LastIndex(s, saved)
+	if i < 0 {
+		return "", &Error{Step: "package", Ref: chart, Err: errors.New("helm didn't say where it saved the package")}
+	}
+	return strings.TrimSpace(s[i+len(saved):]), nil
+}
+
+// Push pushes the package to an OCI registry, e.g. oci://ghcr.io/example/charts.
+// Helm needs to be logged in to the registry, with RegistryLogin.
+func Push(pkg, registry string) error {
+	if !strings.HasPrefix(registry, "oci://") {
+		registry = "oci://" + registry
+	}
+	if err := sh.RunV("helm", "push", pkg, registry); err != nil {
+		return &Error{Step: "push", Ref: pkg, Err: err}
+	}
+	return nil
+}
+
+// RegistryLogin logs helm in to the OCI registry, e.g. ghcr.io, with the
+// credentials the func gets.  As with dockerx.Login, the password is given to
+// helm on stdin and masked in its output.
+func RegistryLogin(registry string, creds dockerx.CredentialsFunc) error {
+	c, err := creds()
+	if err != nil {
+		return &Error{Step: "registry login", Ref: registry, Err: fmt.Errorf("failed to get credentials: %v", err)}
+	}
+	if c.Username == "" || c.Password == "" {
+		return &Error{Step: "registry login", Ref: registry, Err: errors.New("the username and password are required")}
+	}
+	args := []string{"registry", "login", registry, "--username", c.Username, "--password-stdin"}
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command("helm", args...)
+	cmd.Stdin = strings.NewReader(c.Password)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	log.Println("exec: helm", strings.Join(args, " "))
+	err = cmd.Run()
+	os.Stdout.WriteString(mask(stdout.String(), c.Password))
+	os.Stderr.WriteString(mask(stderr.String(), c.Password))
+	if err != nil {
+		if sh.CmdRan(err) {
+			code := sh.ExitStatus(err)
+			err = mg.Fatalf(code, `running "helm %s" failed with exit code %d`, strings.Join(args, " "), code)
+		} else {
+			err = errors.New(mask(err.Error(), c.Password))
+		}
+		return &Error{Step: "registry login", Ref: registry, Err: err}
+	}
+	return nil
+}
+
+// mask hides the secret in s.
+func mask(s, secret string) string {
+	return strings.Replace(s, secret, "***", -1)
+}
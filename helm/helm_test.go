@@ -0,0 +1,145 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/dockerx"
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"helm": helm})
+	os.Exit(m.Run())
+}
+
+// helm logs its args, and what it reads on stdin for registry login, to the
+// file log next to it.  It exits with FAKE_HELM_EXIT.
+func helm(args []string) int {
+	logfile := filepath.Join(faketool.Dir(), "log")
+	faketool.Append(logfile, strings.Join(args, " "))
+	switch args[0] {
+	case "package":
+		fmt.Println("Successfully packaged chart and saved it to: /charts/app-1.2.3.tgz")
+	case "template":
+		fmt.Println("kind: Deployment")
+	case "registry":
+		b, _ := ioutil.ReadAll(os.Stdin)
+		pw := strings.TrimRight(string(b), "\n")
+		faketool.Append(logfile, "stdin="+pw)
+		fmt.Println("logging in with " + pw)
+		fmt.Println("Login Succeeded")
+	}
+	code, _ := strconv.Atoi(os.Getenv("FAKE_HELM_EXIT"))
+	return code
+}
+
+// fakeHelm puts the fake helm on the PATH, and returns a func that reads the
+// log, and one that puts the PATH back.
+func fakeHelm(t *testing.T) (func() string, func()) {
+	dir, cleanup := faketool.OnPath(t, "helm")
+	return func() string { return faketool.Log(dir) }, func() {
+		os.Unsetenv("FAKE_HELM_EXIT")
+		cleanup()
+	}
+}
+
+func TestChart(t *testing.T) {
+	read, cleanup := fakeHelm(t)
+	defer cleanup()
+
+	if err := Lint("charts/app", true, "ci.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := Package("charts/app", PackageOptions{Destination: "dist", Version: "v1.2.3", AppVersion: "v1.2.3", DependencyUpdate: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pkg != "/charts/app-1.2.3.tgz" {
+		t.Fatalf("unexpected package %s", pkg)
+	}
+	creds := func() (dockerx.Credentials, error) {
+		return dockerx.Credentials{Username: "bot", Password: "s3cret"}, nil
+	}
+	if err := RegistryLogin("ghcr.io", creds); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push(pkg, "ghcr.io/example/charts"); err != nil {
+		t.Fatal(err)
+	}
+	expected := "lint charts/app --strict --values ci.yaml\n" +
+		"package charts/app --destination dist --version 1.2.3 --app-version v1.2.3 --dependency-update\n" +
+		"registry login ghcr.io --username bot --password-stdin\nstdin=s3cret\n" +
+		"push /charts/app-1.2.3.tgz oci://ghcr.io/example/charts\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestRelease(t *testing.T) {
+	read, cleanup := fakeHelm(t)
+	defer cleanup()
+
+	r := Release{
+		Name:            "api",
+		Chart:           "oci://ghcr.io/example/charts/app",
+		Version:         "1.2.3",
+		Namespace:       "it",
+		CreateNamespace: true,
+		ValuesFiles:     []string{"test/values.yaml"},
+		Values:          map[string]string{"image.tag": "dev", "replicas": "1"},
+		KubeContext:     "kind-it",
+	}
+	if err := r.Install(5 * time.Minute); err != nil {
+		t.Fatal(err)
+	}
+	out, err := r.Template()
+	if err != nil || out != "kind: Deployment\n" {
+		t.Fatalf("unexpected template %q, %v", out, err)
+	}
+	if err := r.Uninstall(); err != nil {
+		t.Fatal(err)
+	}
+	args := "api oci://ghcr.io/example/charts/app --version 1.2.3 --kube-context kind-it --namespace it --values test/values.yaml --set image.tag=dev --set replicas=1"
+	expected := "upgrade --install " + args + " --create-namespace --wait --timeout=5m0s\n" +
+		"template " + args + "\n" +
+		"uninstall api --kube-context kind-it --namespace it\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	if err := (Release{Name: "web", Chart: "charts/web"}).Install(0); err != nil {
+		t.Fatal(err)
+	}
+	if out, expected := read(), "upgrade --install web charts/web\n"; out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, cleanup := fakeHelm(t)
+	defer cleanup()
+
+	os.Setenv("FAKE_HELM_EXIT", "2")
+	err := Release{Name: "api", Chart: "charts/app"}.Install(0)
+	if e, ok := err.(*Error); !ok || e.ExitStatus() != 2 || e.Error() != `helm install of api failed: running "helm upgrade --install api charts/app" failed with exit code 2` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	creds := func() (dockerx.Credentials, error) {
+		return dockerx.Credentials{Username: "bot", Password: "s3cret"}, nil
+	}
+	err = RegistryLogin("ghcr.io", creds)
+	if err == nil || err.Error() != `helm registry login of ghcr.io failed: running "helm registry login ghcr.io --username bot --password-stdin" failed with exit code 2` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	err = RegistryLogin("ghcr.io", dockerx.EnvCredentials("NO_SUCH_USER", "NO_SUCH_TOKEN"))
+	if err == nil || err.Error() != "helm registry login of ghcr.io failed: failed to get credentials: $NO_SUCH_USER is not set" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,113 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/magefile/mage/sh"
+)
+
+// Release is a chart installed in a cluster.
+type Release struct {
+	// Name is the name of the release.
+	Name string
+
+	// Chart is the chart to install: a directory, a package, or a reference
+	// like oci://ghcr.io/example/charts/app.
+	Chart string
+
+	// Version is the version of the chart to install, when it's a
+	// reference.  It defaults to the latest.
+	Version string
+
+	// Namespace is the namespace to install the release in.  It defaults to
+	// the one in the context.
+	Namespace string
+
+	// CreateNamespace creates the namespace if it doesn't exist.
+	CreateNamespace bool
+
+	// ValuesFiles are the files of values that override the chart's, in
+	// order.
+	ValuesFiles []string
+
+	// Values override the values in the files, e.g. "image.tag": "dev".
+	// They're given to helm with --set, so commas in them need escaping.
+	Values map[string]string
+
+	// KubeContext is the kubectl context of the cluster, e.g. from
+	// kube.Cluster's Context.  It defaults to the current one.
+	KubeContext string
+}
+
+// Install installs the release, or upgrades it if it's already installed.
+// If the timeout isn't 0, it waits up to then for the rollout to finish, so
+// the release's pods are ready.
+func (r Release) Install(timeout time.Duration) error {
+	args := r.args("upgrade", "--install")
+	if r.CreateNamespace {
+		args = append(args, "--create-namespace")
+	}
+	if timeout > 0 {
+		args = append(args, "--wait", fmt.Sprintf("--timeout=%v", timeout))
+	}
+	if err := sh.RunV("helm", args...); err != nil {
+		return &Error{Step: "install", Ref: r.Name, Err: err}
+	}
+	return nil
+}
+
+// Template renders the release's manifests, without installing them.
+func (r Release) Template() (string, error) {
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(nil, out, os.Stderr, "helm", r.args("template")...); err != nil {
+		return "", &Error{Step: "template", Ref: r.Name, Err: err}
+	}
+	return out.String(), nil
+}
+
+// Uninstall removes the release.
+func (r Release) Uninstall() error {
+	args := []string{"uninstall", r.Name}
+	args = append(args, r.flags()...)
+	if err := sh.RunV("helm", args...); err != nil {
+		return &Error{Step: "uninstall", Ref: r.Name, Err: err}
+	}
+	return nil
+}
+
+// args returns the args for the command to render or install the chart.
+func (r Release) args(cmd ...string) []string {
+	args := append(cmd, r.Name, r.Chart)
+	if r.Version != "" {
+		args = append(args, "--version", r.Version)
+	}
+	args = append(args, r.flags()...)
+	for _, f := range r.ValuesFiles {
+		args = append(args, "--values", f)
+	}
+	keys := make([]string, 0, len(r.Values))
+	for k := range r.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		args = append(args, "--set", k+"="+r.Values[k])
+	}
+	return args
+}
+
+// flags returns the flags for the release's cluster and namespace.
+func (r Release) flags() []string {
+	var args []string
+	if r.KubeContext != "" {
+		args = append(args, "--kube-context", r.KubeContext)
+	}
+	if r.Namespace != "" {
+		args = append(args, "--namespace", r.Namespace)
+	}
+	return args
+}
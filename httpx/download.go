@@ -0,0 +1,123 @@
+package httpx
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// progressInterval is how often the progress of a download is logged.
+var progressInterval = 5 * time.Second
+
+// Download downloads the url to the file with the Default client.
+func Download(url, dst string) error {
+	return Default.Download(url, dst)
+}
+
+// Download downloads the url to the file, creating the directory it's in if
+// it doesn't exist.  It's downloaded to a temporary file that's then renamed
+// to dst, so dst is never half downloaded.  How much has been downloaded is
+// logged every few seconds, which mage shows with -v.
+func (c *Client) Download(url, dst string) error {
+	dir := filepath.Dir(dst)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("can't download to %s: %v", dst, err)
+	}
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(dst))
+	if err != nil {
+		return fmt.Errorf("can't download to %s: %v", dst, err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	log.Printf("downloading %s to %s", c.shown(url), dst)
+	err = c.retry("GET", url, func() error {
+		if err := f.Truncate(0); err != nil {
+			return permanent{err}
+		}
+		if _, err := f.Seek(0, io.SeekStart); err != nil {
+			return permanent{err}
+		}
+		resp, err := c.send("GET", url, nil, "", "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		p := &progress{url: c.shown(url), total: resp.ContentLength}
+		stop := p.start()
+		defer stop()
+		_, err = io.Copy(f, io.TeeReader(resp.Body, p))
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dst)
+}
+
+// progress counts the bytes written to it, and logs how many there have
+// been while it's started.
+type progress struct {
+	url   string
+	total int64
+	n     int64
+}
+
+func (p *progress) Write(b []byte) (int, error) {
+	atomic.AddInt64(&p.n, int64(len(b)))
+	return len(b), nil
+}
+
+// start logs the progress every progressInterval until the returned func is
+// called.
+func (p *progress) start() (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(progressInterval)
+		defer t.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-t.C:
+				log.Printf("downloading %s: %s", p.url, p)
+			}
+		}
+	}()
+	return func() { close(done) }
+}
+
+// String returns how much has been downloaded, and of how much if it's known,
+// e.g. "12.5 MB of 50.0 MB (25%)".
+func (p *progress) String() string {
+	n := atomic.LoadInt64(&p.n)
+	if p.total <= 0 {
+		return size(n)
+	}
+	return fmt.Sprintf("%s of %s (%d%%)", size(n), size(p.total), n*100/p.total)
+}
+
+// size formats a number of bytes for people to read.
+func size(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.1f GB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.1f KB", float64(n)/(1<<10))
+	default:
+		return fmt.Sprintf("%d B", n)
+	}
+}
@@ -0,0 +1,76 @@
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDownload(t *testing.T) {
+	data := strings.Repeat("x", 100<<10)
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Header.Get("Accept") == "application/json" {
+			t.Errorf("a download asked for json")
+		}
+		if requests == 1 {
+			http.Error(w, "oops", http.StatusBadGateway)
+			return
+		}
+		w.Write([]byte(data))
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dst := filepath.Join(dir, "sub", "file.bin")
+	if err := Download(srv.URL, dst); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(b) != data || requests != 2 {
+		t.Fatalf("got %d bytes after %d requests", len(b), requests)
+	}
+	entries, _ := ioutil.ReadDir(filepath.Dir(dst))
+	if len(entries) != 1 {
+		t.Fatalf("expected only the download, got %d files", len(entries))
+	}
+
+	c := &Client{Retries: -1}
+	requests = 0
+	if err := c.Download(srv.URL, filepath.Join(dir, "failed")); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "failed")); !os.IsNotExist(err) {
+		t.Fatalf("expected no file for a failed download, got %v", err)
+	}
+}
+
+func TestProgress(t *testing.T) {
+	tests := []struct {
+		n, total int64
+		want     string
+	}{
+		{n: 10, want: "10 B"},
+		{n: 1536, total: 3072, want: "1.5 KB of 3.0 KB (50%)"},
+		{n: 5 << 20, total: 20 << 20, want: "5.0 MB of 20.0 MB (25%)"},
+		{n: 3 << 30, total: -1, want: "3.0 GB"},
+	}
+	for _, tt := range tests {
+		p := &progress{n: tt.n, total: tt.total}
+		if got := p.String(); got != tt.want {
+			t.Errorf("expected %q, got %q", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,316 @@
+// Package httpx makes the HTTP requests build targets make, like calling a
+// release API or downloading from an artifact store, without configuring an
+// http.Client each time.  Requests that are safe to send again are retried
+// with backoff when the server is unreachable or responds 429 Too Many
+// Requests or 5xx, values are sent and received as JSON, and an error
+// response is returned as a *StatusError with what the server said.
+package httpx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/sh"
+)
+
+// retryDelay is how long to wait before the first retry, which doubles after
+// each one up to maxRetryDelay.
+var (
+	retryDelay    = time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// Client makes requests.  The zero value is ready to use.
+type Client struct {
+	// BaseURL is prepended to URLs that don't start with http:// or
+	// https://, e.g. https://api.example.com/v1.
+	BaseURL string
+
+	// Header is added to every request.
+	Header http.Header
+
+	// Token is sent as a bearer token, if it's set.
+	Token string
+
+	// Username and Password are sent with basic auth, if Username is set.
+	Username string
+	Password string
+
+	// Retries is how many times a request is retried.  It defaults to 3, and
+	// is no retries if it's negative.  Only requests that are safe to send
+	// twice are retried: those whose method is idempotent, like GET and PUT,
+	// and POST and PATCH requests with an Idempotency-Key header, or from a
+	// client that's Idempotent.
+	Retries int
+
+	// Idempotent says the server handles a POST or PATCH sent twice the same
+	// as one sent once, so they're retried like other requests.
+	Idempotent bool
+
+	// Timeout is how long each attempt may take, from sending the request
+	// to reading all of the response.  It defaults to no timeout.
+	Timeout time.Duration
+
+	// HTTPClient sends the requests.  It defaults to http.DefaultClient.
+	HTTPClient *http.Client
+}
+
+// Default is the client used by the package's functions.
+var Default = &Client{}
+
+// StatusError is the error when the server responds with a status that isn't
+// 2xx.
+type StatusError struct {
+	// Method and URL are the request's.  The secrets registered with sh.Mask
+	// are replaced by *** in the URL.
+	Method string
+	URL    string
+	// StatusCode is the status of the response, e.g. 404.
+	StatusCode int
+	// Status is the status line, e.g. "404 Not Found".
+	Status string
+	// Body is the start of the response's body, which usually says what
+	// was wrong.
+	Body string
+
+	// after is how long the response's Retry-After header asked to wait.
+	after time.Duration
+}
+
+func (e *StatusError) Error() string {
+	if e.Body == "" {
+		return fmt.Sprintf("%s %s: %s", e.Method, e.URL, e.Status)
+	}
+	// the server could echo a secret back, like a token it refused.
+	return sh.Masked(fmt.Sprintf("%s %s: %s: %s", e.Method, e.URL, e.Status, e.Body))
+}
+
+// maxErrorBody is how much of an error response's body is kept in a
+// StatusError.
+const maxErrorBody = 4 << 10
+
+// Get gets the url with the Default client and decodes the JSON response
+// into out, unless it's nil.
+func Get(url string, out interface{}) error {
+	return Default.Get(url, out)
+}
+
+// Post posts in to the url with the Default client and decodes the JSON
+// response into out, unless it's nil.
+func Post(url string, in, out interface{}) error {
+	return Default.Post(url, in, out)
+}
+
+// Get gets the url and decodes the JSON response into out, unless it's nil.
+func (c *Client) Get(url string, out interface{}) error {
+	return c.Do("GET", url, nil, out)
+}
+
+// Post posts in to the url and decodes the JSON response into out, unless
+// it's nil.
+func (c *Client) Post(url string, in, out interface{}) error {
+	return c.Do("POST", url, in, out)
+}
+
+// Do sends a request and reads the response.
+//
+// The request's body is in, encoded as JSON, or nothing if it's nil, or the
+// bytes themselves if it's a []byte.  The response's body is decoded as JSON
+// into out, or stored in it if it's a *[]byte, or discarded if it's nil.
+func (c *Client) Do(method, url string, in, out interface{}) error {
+	var body []byte
+	contentType := ""
+	switch v := in.(type) {
+	case nil:
+	case []byte:
+		body, contentType = v, "application/octet-stream"
+	default:
+		b, err := json.Marshal(in)
+		if err != nil {
+			return fmt.Errorf("can't encode the body of %s %s: %v", method, c.shown(url), err)
+		}
+		body, contentType = b, "application/json"
+	}
+	return c.retry(method, url, func() error {
+		var r io.Reader
+		if body != nil {
+			r = bytes.NewReader(body)
+		}
+		resp, err := c.send(method, url, r, contentType, "application/json")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return err
+		}
+		switch v := out.(type) {
+		case nil:
+		case *[]byte:
+			*v = b
+		default:
+			if err := json.Unmarshal(b, out); err != nil {
+				return permanent{fmt.Errorf("can't decode the response of %s %s: %v", method, c.shown(url), err)}
+			}
+		}
+		return nil
+	})
+}
+
+// permanent is an error that retrying won't fix.
+type permanent struct{ error }
+
+// retry calls try until it succeeds, returns an error that isn't worth
+// retrying, or runs out of retries.  It only tries once if the request isn't
+// safe to send again.
+func (c *Client) retry(method, url string, try func() error) error {
+	retries := c.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	if !c.idempotent(method) {
+		retries = 0
+	}
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		err := try()
+		if err == nil {
+			return nil
+		}
+		if p, ok := err.(permanent); ok {
+			return p.error
+		}
+		wait := delay
+		if e, ok := err.(*StatusError); ok {
+			if e.StatusCode != http.StatusTooManyRequests && e.StatusCode < 500 {
+				return err
+			}
+			wait = e.retryAfter(delay)
+		}
+		if attempt >= retries {
+			return err
+		}
+		// the error could have a secret in it, like a token in the query.
+		log.Printf("%s", sh.Masked(fmt.Sprintf("%s %s failed, retrying in %v: %v", method, c.shown(url), wait, err)))
+		clock.Sleep(wait)
+		if delay *= 2; delay > maxRetryDelay {
+			delay = maxRetryDelay
+		}
+	}
+}
+
+// idempotent returns whether a request with the method is safe to send
+// again: its method is idempotent, or it has an Idempotency-Key, or the
+// client says its server handles every request sent twice the same as one.
+func (c *Client) idempotent(method string) bool {
+	switch strings.ToUpper(method) {
+	case "POST", "PATCH":
+		return c.Idempotent || c.Header.Get("Idempotency-Key") != ""
+	}
+	return true
+}
+
+// retryAfter returns how long the server asked to wait before retrying, or
+// delay if it didn't say, up to maxRetryDelay.
+func (e *StatusError) retryAfter(delay time.Duration) time.Duration {
+	if e.after > 0 {
+		delay = e.after
+	}
+	if delay > maxRetryDelay {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// send sends the request once, and returns the response if its status is
+// 2xx, which the caller must close.  The accept header is sent unless the
+// client's Header has one.
+func (c *Client) send(method, url string, body io.Reader, contentType, accept string) (*http.Response, error) {
+	req, err := http.NewRequest(method, c.url(url), body)
+	if err != nil {
+		return nil, permanent{err}
+	}
+	if f, ok := body.(*os.File); ok {
+		// send the file's length, rather than chunks, which object stores
+		// refuse.
+		fi, err := f.Stat()
+		if err != nil {
+			return nil, permanent{err}
+		}
+		req.ContentLength = fi.Size()
+	}
+	for k, v := range c.Header {
+		req.Header[k] = v
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	if accept != "" && req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", accept)
+	}
+	switch {
+	case c.Token != "":
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	case c.Username != "":
+		req.SetBasicAuth(c.Username, c.Password)
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if c.Timeout > 0 {
+		cl := *client
+		cl.Timeout = c.Timeout
+		client = &cl
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if e, ok := err.(*neturl.Error); ok {
+			e.URL = sh.Masked(e.URL)
+		}
+		return nil, err
+	}
+	if resp.StatusCode/100 == 2 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	e := &StatusError{
+		Method:     method,
+		URL:        sh.Masked(req.URL.String()),
+		StatusCode: resp.StatusCode,
+		Status:     resp.Status,
+		Body:       strings.TrimSpace(string(b)),
+	}
+	if s, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && s > 0 {
+		e.after = time.Duration(s) * time.Second
+	}
+	return nil, e
+}
+
+// shown returns the url as it's shown in logs and errors, with the BaseURL
+// prepended and the secrets registered with sh.Mask replaced by ***, like a
+// token in the query.
+func (c *Client) shown(url string) string {
+	return sh.Masked(c.url(url))
+}
+
+// url returns the url with the BaseURL prepended, unless it's absolute.
+func (c *Client) url(url string) string {
+	if c.BaseURL == "" || strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") {
+		return url
+	}
+	return strings.TrimSuffix(c.BaseURL, "/") + "/" + strings.TrimPrefix(url, "/")
+}
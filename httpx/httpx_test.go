@@ -0,0 +1,193 @@
+package httpx
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/sh"
+)
+
+func init() {
+	retryDelay = time.Millisecond
+}
+
+func TestGetPost(t *testing.T) {
+	var gotAuth, gotType, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		gotType = r.Header.Get("Content-Type")
+		b, _ := ioutil.ReadAll(r.Body)
+		gotBody = string(b)
+		json.NewEncoder(w).Encode(map[string]string{"method": r.Method, "path": r.URL.Path})
+	}))
+	defer srv.Close()
+
+	c := &Client{BaseURL: srv.URL + "/api/", Token: "t0ken"}
+	var out struct{ Method, Path string }
+	if err := c.Get("/releases", &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Method != "GET" || out.Path != "/api/releases" {
+		t.Fatalf("got %+v", out)
+	}
+	if gotAuth != "Bearer t0ken" {
+		t.Fatalf("got Authorization %q", gotAuth)
+	}
+
+	if err := c.Post("releases", map[string]string{"tag": "v1.0.0"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out.Method != "POST" || gotType != "application/json" || gotBody != `{"tag":"v1.0.0"}` {
+		t.Fatalf("got %+v, Content-Type %q and body %q", out, gotType, gotBody)
+	}
+
+	c = &Client{Username: "me", Password: "pw"}
+	var raw []byte
+	if err := c.Do("PUT", srv.URL+"/file", []byte("data"), &raw); err != nil {
+		t.Fatal(err)
+	}
+	if gotAuth != "Basic bWU6cHc=" || gotType != "application/octet-stream" || gotBody != "data" {
+		t.Fatalf("got Authorization %q, Content-Type %q and body %q", gotAuth, gotType, gotBody)
+	}
+	if !strings.Contains(string(raw), `"method":"PUT"`) {
+		t.Fatalf("got response %q", raw)
+	}
+}
+
+func TestRetry(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		b, _ := ioutil.ReadAll(r.Body)
+		if requests < 3 || r.URL.Path == "/down" {
+			http.Error(w, "try later", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	var out []byte
+	if err := Post(srv.URL, []byte("again"), &out); err == nil || requests != 1 {
+		t.Fatalf("expected a POST not to be retried, got %d requests and %v", requests, err)
+	}
+	requests = 0
+	keyed := &Client{Header: http.Header{"Idempotency-Key": {"abc"}}}
+	if err := keyed.Post(srv.URL, []byte("again"), &out); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 3 || string(out) != "again" {
+		t.Fatalf("got %d requests and response %q", requests, out)
+	}
+
+	requests = 0
+	c := &Client{Retries: 1}
+	err := c.Get(srv.URL+"/down", nil)
+	e, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("expected a *StatusError, got %#v", err)
+	}
+	if requests != 2 || e.StatusCode != 503 || e.Body != "try later" {
+		t.Fatalf("got %d requests and %+v", requests, e)
+	}
+	want := "GET " + srv.URL + "/down: 503 Service Unavailable: try later"
+	if e.Error() != want {
+		t.Fatalf("expected %q, got %q", want, e.Error())
+	}
+}
+
+func TestNoRetry(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path == "/missing" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte("not json"))
+	}))
+	defer srv.Close()
+
+	err := Get(srv.URL+"/missing", nil)
+	if e, ok := err.(*StatusError); !ok || e.StatusCode != 404 {
+		t.Fatalf("expected a 404 *StatusError, got %#v", err)
+	}
+	if requests != 1 {
+		t.Fatalf("expected 1 request, got %d", requests)
+	}
+
+	var out map[string]string
+	err = Get(srv.URL, &out)
+	if err == nil || !strings.Contains(err.Error(), "can't decode the response of GET "+srv.URL) {
+		t.Fatalf("expected a decode error, got %v", err)
+	}
+	if requests != 2 {
+		t.Fatalf("expected 2 requests, got %d", requests)
+	}
+}
+
+func TestMaskedURL(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad token "+r.URL.Query().Get("token"), http.StatusForbidden)
+	}))
+	defer srv.Close()
+
+	sh.Mask("t0ps3cret")
+	err := Get(srv.URL+"/x?token=t0ps3cret", nil)
+	e, ok := err.(*StatusError)
+	if !ok {
+		t.Fatalf("expected a *StatusError, got %#v", err)
+	}
+	want := "GET " + srv.URL + "/x?token=***: 403 Forbidden: bad token ***"
+	if e.URL != srv.URL+"/x?token=***" || e.Error() != want {
+		t.Fatalf("expected %q, got %q", want, e.Error())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	e := &StatusError{after: 2 * time.Second}
+	if d := e.retryAfter(time.Second); d != 2*time.Second {
+		t.Fatalf("expected 2s, got %v", d)
+	}
+	e = &StatusError{after: time.Hour}
+	if d := e.retryAfter(time.Second); d != maxRetryDelay {
+		t.Fatalf("expected %v, got %v", maxRetryDelay, d)
+	}
+	if d := (&StatusError{}).retryAfter(time.Second); d != time.Second {
+		t.Fatalf("expected 1s, got %v", d)
+	}
+}
+
+func TestRetryDelays(t *testing.T) {
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 3 {
+			w.Header().Set("Retry-After", "7")
+		}
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	old := retryDelay
+	retryDelay = time.Second
+	defer func() { retryDelay = old }()
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Default = fake
+	defer func() { clock.Default = clock.Real{} }()
+
+	if err := Get(srv.URL, nil); err == nil {
+		t.Fatal("expected the request to fail")
+	}
+	// the delay doubles, unless the server says how long to wait.
+	expected := []time.Duration{time.Second, 2 * time.Second, 7 * time.Second}
+	if s := fake.Sleeps(); requests != 4 || !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected 4 requests and to wait %v but got %d and %v", expected, requests, s)
+	}
+}
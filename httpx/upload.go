@@ -0,0 +1,42 @@
+package httpx
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Upload uploads the file to the url with a PUT, with the Default client.
+func Upload(src, url string) error {
+	return Default.Upload(src, url)
+}
+
+// Upload uploads the file to the url with a PUT, the way object stores and
+// their presigned URLs take them.  It's sent as application/octet-stream,
+// unless the client's Header has a Content-Type, and read from the file as
+// it's sent, so it can be bigger than memory.
+func (c *Client) Upload(src, url string) error {
+	if _, err := os.Stat(src); err != nil {
+		return fmt.Errorf("can't upload %s: %v", src, err)
+	}
+	contentType := c.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	log.Printf("uploading %s to %s", src, c.shown(url))
+	return c.retry("PUT", url, func() error {
+		f, err := os.Open(src)
+		if err != nil {
+			return permanent{err}
+		}
+		defer f.Close()
+		resp, err := c.send("PUT", url, f, contentType, "")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		return err
+	})
+}
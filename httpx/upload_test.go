@@ -0,0 +1,49 @@
+package httpx
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestUpload(t *testing.T) {
+	var got []byte
+	requests := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.Method != "PUT" || r.ContentLength != 5 || len(r.TransferEncoding) != 0 {
+			t.Errorf("unexpected %s with length %d and %v", r.Method, r.ContentLength, r.TransferEncoding)
+		}
+		if ct := r.Header.Get("Content-Type"); ct != "application/gzip" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+		if requests == 1 {
+			http.Error(w, "oops", http.StatusServiceUnavailable)
+			return
+		}
+		got, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	src := filepath.Join(dir, "app.tar.gz")
+	ioutil.WriteFile(src, []byte("hello"), 0644)
+
+	c := &Client{Header: http.Header{"Content-Type": {"application/gzip"}}}
+	if err := c.Upload(src, srv.URL+"/app.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello" || requests != 2 {
+		t.Fatalf("got %q after %d requests", got, requests)
+	}
+	if err := c.Upload(filepath.Join(dir, "missing"), srv.URL); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/magefile/mage/dotenv"
+)
+
+// DotenvFiles are the files LoadDotenv reads from the magefile directory.
+// A variable set in a later file overrides one from an earlier file, so
+// .env.local, which usually isn't committed, can override the shared .env.
+var DotenvFiles = []string{".env", ".env.local"}
+
+// LoadDotenv returns the variables set in the dotenv files in dir that aren't
+// already set in environ, as KEY=VALUE pairs that can be appended to it.
+// Variables that are already set are left alone, so the environment always
+// takes precedence over the files.  Missing files are ignored.
+func LoadDotenv(dir string, environ []string) ([]string, error) {
+	vars := map[string]string{}
+	var keys []string
+	for _, name := range DotenvFiles {
+		f, err := dotenv.Read(filepath.Join(dir, name))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, v := range f.Vars() {
+			if _, ok := vars[v[0]]; !ok {
+				keys = append(keys, v[0])
+			}
+			vars[v[0]] = v[1]
+		}
+	}
+	env := make([]string, 0, len(keys))
+	for _, k := range keys {
+		env = append(env, k+"="+vars[k])
+	}
+	return UnsetVars(environ, env), nil
+}
+
+// UnsetVars returns the KEY=VALUE pairs in vars whose keys aren't set in
+// environ.
+func UnsetVars(environ, vars []string) []string {
+	set := map[string]bool{}
+	for _, kv := range environ {
+		if i := strings.Index(kv, "="); i > 0 {
+			set[kv[:i]] = true
+		}
+	}
+	var unset []string
+	for _, kv := range vars {
+		if i := strings.Index(kv, "="); i > 0 && !set[kv[:i]] {
+			unset = append(unset, kv)
+		}
+	}
+	return unset
+}
@@ -0,0 +1,141 @@
+// Package faketool stands in for the command line tools mage's packages run,
+// in their tests.  The test binary is installed in place of a tool, and runs
+// the fake for it written in Go, the way the sh package's tests run
+// themselves as helper processes, so the fakes work the same on every OS.
+package faketool
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// Tool is a fake tool.  It's given the args the tool was run with, and
+// returns the code to exit with.  It runs in a process of its own, with the
+// environment and working directory the tool was run with, so it gets what
+// the test sets up for it from there, or from files in Dir.
+type Tool func(args []string) int
+
+// Main runs the fake tool the process was started as, if it's one of tools,
+// and exits.  The package's TestMain calls it before running the tests.
+func Main(tools map[string]Tool) {
+	exe, err := os.Executable()
+	if err != nil {
+		return
+	}
+	name := strings.TrimSuffix(filepath.Base(exe), ".exe")
+	if tool, ok := tools[name]; ok {
+		os.Exit(tool(os.Args[1:]))
+	}
+}
+
+// Dir returns the directory of the running fake tool.
+func Dir() string {
+	exe, err := os.Executable()
+	if err != nil {
+		panic(err)
+	}
+	return filepath.Dir(exe)
+}
+
+// Install puts the fake tool called name in dir, and returns its path.
+func Install(t testing.TB, dir, name string) string {
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if runtime.GOOS == "windows" {
+		path += ".exe"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	// a tool installed before may be a link to the test binary, which mustn't
+	// be written to.
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	// a link is quicker than a copy of the test binary, where it's allowed.
+	if err := os.Link(exe, path); err == nil {
+		return path
+	}
+	if err := copyFile(exe, path); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// OnPath installs the fake tools with the names in a temporary directory,
+// which it puts first on the PATH, and returns it, and the func that
+// restores the PATH and removes it.
+func OnPath(t testing.TB, names ...string) (dir string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range names {
+		Install(t, dir, name)
+	}
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+path)
+	return dir, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+// Append appends the line to the file, e.g. to log the args a fake tool was
+// run with, creating the file if need be.
+func Append(path, line string) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		panic(err)
+	}
+}
+
+// Log returns what the fake tools in dir have logged to the file log there,
+// with Append(filepath.Join(Dir(), "log"), ...), and removes it, so the next
+// call returns only what's logged after this one.
+func Log(dir string) string {
+	path := filepath.Join(dir, "log")
+	b, _ := ioutil.ReadFile(path)
+	os.Remove(path)
+	return string(b)
+}
+
+// Cat copies the file to stdout, if it exists.
+func Cat(path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+	io.Copy(os.Stdout, f)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,47 @@
+package faketool
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	Main(map[string]Tool{"greet": greet})
+	os.Exit(m.Run())
+}
+
+func greet(args []string) int {
+	Append(filepath.Join(Dir(), "log"), strings.Join(args, " "))
+	Cat(os.Getenv("FAKE_GREETING"))
+	return len(args)
+}
+
+func TestInstall(t *testing.T) {
+	dir, cleanup := OnPath(t, "greet")
+	defer cleanup()
+	greeting := filepath.Join(dir, "greeting")
+	ioutil.WriteFile(greeting, []byte("hello\n"), 0644)
+	os.Setenv("FAKE_GREETING", greeting)
+	defer os.Unsetenv("FAKE_GREETING")
+
+	out, err := exec.Command("greet", "a", "b").Output()
+	if e, ok := err.(*exec.ExitError); !ok || e.ExitCode() != 2 {
+		t.Fatalf("expected exit code 2, got %v", err)
+	}
+	if string(out) != "hello\n" {
+		t.Fatalf("unexpected output %q", out)
+	}
+	// installing again mustn't write to the test binary through the link.
+	Install(t, dir, "greet")
+	exec.Command("greet").Run()
+	if log := Log(dir); log != "a b\n\n" {
+		t.Fatalf("unexpected log %q", log)
+	}
+	if log := Log(dir); log != "" {
+		t.Fatalf("expected the log to be emptied, got %q", log)
+	}
+}
@@ -17,6 +17,16 @@ func SetDebug(l *log.Logger) {
 	debug = l
 }
 
+// goEnv are the variables set for the go commands mage runs, over the ones in
+// the environment.
+var goEnv map[string]string
+
+// SetGoEnv sets variables for the go commands mage runs, e.g. GOFLAGS and
+// GOWORK from the project config, which take precedence over the environment.
+func SetGoEnv(env map[string]string) {
+	goEnv = env
+}
+
 func RunDebug(cmd string, args ...string) error {
 	env, err := EnvWithCurrentGOOS()
 	if err != nil {
@@ -38,6 +48,12 @@ func RunDebug(cmd string, args ...string) error {
 }
 
 func OutputDebug(cmd string, args ...string) (string, error) {
+	return OutputDebugDir("", cmd, args...)
+}
+
+// OutputDebugDir is like OutputDebug, but runs the command in the given
+// directory.
+func OutputDebugDir(dir, cmd string, args ...string) (string, error) {
 	env, err := EnvWithCurrentGOOS()
 	if err != nil {
 		return "", err
@@ -46,6 +62,7 @@ func OutputDebug(cmd string, args ...string) (string, error) {
 	errbuf := &bytes.Buffer{}
 	debug.Println("running", cmd, strings.Join(args, " "))
 	c := exec.Command(cmd, args...)
+	c.Dir = dir
 	c.Env = env
 	c.Stderr = errbuf
 	c.Stdout = buf
@@ -89,6 +106,9 @@ func EnvWithCurrentGOOS() ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range goEnv {
+		vals[k] = v
+	}
 	vals["GOOS"] = runtime.GOOS
 	vals["GOARCH"] = runtime.GOARCH
 	return joinEnv(vals), nil
@@ -101,6 +121,9 @@ func EnvWithGOOS(goos, goarch string) ([]string, error) {
 	if err != nil {
 		return nil, err
 	}
+	for k, v := range goEnv {
+		env[k] = v
+	}
 	if goos == "" {
 		env["GOOS"] = runtime.GOOS
 	} else {
@@ -0,0 +1,216 @@
+// Package kube runs local Kubernetes clusters with kind or k3d for
+// integration tests: it creates them with a pinned node image, loads images
+// built locally into them, applies manifests, and waits for rollouts.  A
+// command that fails returns an *Error that says which step it was.
+package kube
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Provider is the tool that runs the cluster.
+type Provider string
+
+// The providers.
+const (
+	// Kind runs the cluster's nodes as docker containers, see
+	// https://kind.sigs.k8s.io.
+	Kind Provider = "kind"
+
+	// K3d runs k3s in docker containers, see https://k3d.io.
+	K3d Provider = "k3d"
+)
+
+// Cluster is a local Kubernetes cluster.
+type Cluster struct {
+	// Name is the name of the cluster.  It defaults to the provider's
+	// default, kind or k3s-default.
+	Name string
+
+	// Provider runs the cluster.  It defaults to Kind.
+	Provider Provider
+
+	// Image is the node image, pinned so the tests run against the same
+	// version of Kubernetes everywhere, e.g. kindest/node:v1.29.2 or
+	// rancher/k3s:v1.29.2-k3s1.  By default, it's the provider's default
+	// for its version.
+	Image string
+
+	// Config is the path of the provider's config file for the cluster.
+	Config string
+
+	// Namespace is the namespace kubectl uses.  It defaults to the one in
+	// the context, usually default.
+	Namespace string
+
+	// Keep leaves the cluster running when the func Create returns is
+	// called, so the next run reuses it instead of waiting for a new one.
+	Keep bool
+}
+
+// Error is the error from a command that failed.
+type Error struct {
+	// Step is the step that failed, e.g. create, load or apply.
+	Step string
+
+	// Cluster is the name of the cluster.
+	Cluster string
+
+	// Err is the error from running the command.
+	Err error
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%s of cluster %s failed: %v", e.Step, e.Cluster, e.Err)
+}
+
+// ExitStatus returns the exit code the command failed with, so mage exits
+// with it.
+func (e *Error) ExitStatus() int {
+	return mg.ExitStatus(e.Err)
+}
+
+func (c Cluster) provider() Provider {
+	if c.Provider == "" {
+		return Kind
+	}
+	return c.Provider
+}
+
+func (c Cluster) name() string {
+	switch {
+	case c.Name != "":
+		return c.Name
+	case c.provider() == K3d:
+		return "k3s-default"
+	default:
+		return "kind"
+	}
+}
+
+// Context is the name of the cluster's kubectl context, e.g. kind-test.
+func (c Cluster) Context() string {
+	return string(c.provider()) + "-" + c.name()
+}
+
+// Exists reports whether the cluster has been created.
+func (c Cluster) Exists() (bool, error) {
+	var args []string
+	switch c.provider() {
+	case Kind:
+		args = []string{"get", "clusters"}
+	case K3d:
+		args = []string{"cluster", "list", "--no-headers"}
+	default:
+		return false, c.unsupported("list")
+	}
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(nil, out, os.Stderr, string(c.provider()), args...); err != nil {
+		return false, &Error{Step: "list", Cluster: c.name(), Err: err}
+	}
+	for _, line := range strings.Split(out.String(), "\n") {
+		if f := strings.Fields(line); len(f) > 0 && f[0] == c.name() {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Create creates the cluster, or reuses it if it already exists, and returns
+// a func that deletes it, unless Keep is set.  The func can be returned by a
+// dependency to be run once the targets are done:
+//
+//	var cluster = kube.Cluster{Name: "it", Image: "kindest/node:v1.29.2"}
+//
+//	func Cluster() (func(), error) {
+//		return cluster.Create()
+//	}
+//
+//	func Integration() error {
+//		mg.Deps(Cluster)
+//		...
+//	}
+func (c Cluster) Create() (del func(), err error) {
+	exists, err := c.Exists()
+	if err != nil {
+		return nil, err
+	}
+	if exists {
+		if mg.Verbose() {
+			log.Printf("reusing %s cluster %s", c.provider(), c.name())
+		}
+	} else {
+		var args []string
+		switch c.provider() {
+		case Kind:
+			args = []string{"create", "cluster", "--name", c.name()}
+		case K3d:
+			args = []string{"cluster", "create", c.name()}
+		}
+		if c.Image != "" {
+			args = append(args, "--image", c.Image)
+		}
+		if c.Config != "" {
+			args = append(args, "--config", c.Config)
+		}
+		if err := sh.RunV(string(c.provider()), args...); err != nil {
+			return nil, &Error{Step: "create", Cluster: c.name(), Err: err}
+		}
+	}
+	return func() {
+		if c.Keep {
+			return
+		}
+		if err := c.Delete(); err != nil {
+			fmt.Fprintln(os.Stderr, "Error:", err)
+		}
+	}, nil
+}
+
+// Delete deletes the cluster.
+func (c Cluster) Delete() error {
+	var args []string
+	switch c.provider() {
+	case Kind:
+		args = []string{"delete", "cluster", "--name", c.name()}
+	case K3d:
+		args = []string{"cluster", "delete", c.name()}
+	default:
+		return c.unsupported("delete")
+	}
+	if err := sh.RunV(string(c.provider()), args...); err != nil {
+		return &Error{Step: "delete", Cluster: c.name(), Err: err}
+	}
+	return nil
+}
+
+// LoadImages copies images from the local docker daemon into the cluster's
+// nodes, so pods can run images that were built locally without pushing
+// them to a registry.  The pods need an imagePullPolicy of IfNotPresent or
+// Never, and a tag other than latest, for the nodes to use them.
+func (c Cluster) LoadImages(images ...string) error {
+	var args []string
+	switch c.provider() {
+	case Kind:
+		args = []string{"load", "docker-image", "--name", c.name()}
+	case K3d:
+		args = []string{"image", "import", "--cluster", c.name()}
+	default:
+		return c.unsupported("load")
+	}
+	if err := sh.RunV(string(c.provider()), append(args, images...)...); err != nil {
+		return &Error{Step: "load", Cluster: c.name(), Err: err}
+	}
+	return nil
+}
+
+func (c Cluster) unsupported(step string) error {
+	return &Error{Step: step, Cluster: c.name(), Err: fmt.Errorf("unsupported provider %q, the supported providers are: %s, %s", c.Provider, Kind, K3d)}
+}
@@ -0,0 +1,126 @@
+package kube
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"kind": fakeTool("kind"), "k3d": fakeTool("k3d"), "kubectl": fakeTool("kubectl")})
+	os.Exit(m.Run())
+}
+
+// fakeTool stands in for the tool called name, logging its args to the file
+// log next to it.  The clusters it lists are in FAKE_CLUSTERS, and it exits
+// with FAKE_EXIT.
+func fakeTool(name string) faketool.Tool {
+	return func(args []string) int {
+		faketool.Append(filepath.Join(faketool.Dir(), "log"), name+" "+strings.Join(args, " "))
+		clusters := os.Getenv("FAKE_CLUSTERS")
+		switch strings.Join(args, " ") {
+		case "get clusters":
+			fmt.Print(clusters)
+		case "cluster list --no-headers":
+			for _, c := range strings.SplitAfter(clusters, "\n") {
+				if c != "" {
+					fmt.Print(strings.TrimSuffix(c, "\n") + "   1/1   0/0   true\n")
+				}
+			}
+		}
+		code, _ := strconv.Atoi(os.Getenv("FAKE_EXIT"))
+		return code
+	}
+}
+
+// fakeTools puts kind, k3d and kubectl on the PATH, and returns a func that
+// reads the log of how they were run, and one that puts the PATH back.
+func fakeTools(t *testing.T) (func() string, func()) {
+	dir, cleanup := faketool.OnPath(t, "kind", "k3d", "kubectl")
+	return func() string { return faketool.Log(dir) }, func() {
+		os.Unsetenv("FAKE_CLUSTERS")
+		os.Unsetenv("FAKE_EXIT")
+		cleanup()
+	}
+}
+
+func TestKind(t *testing.T) {
+	read, cleanup := fakeTools(t)
+	defer cleanup()
+
+	c := Cluster{Name: "it", Image: "kindest/node:v1.29.2", Config: "test/kind.yaml", Namespace: "app"}
+	os.Setenv("FAKE_CLUSTERS", "other\n")
+	del, err := c.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "kind get clusters\nkind create cluster --name it --image kindest/node:v1.29.2 --config test/kind.yaml\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+	if err := c.LoadImages("example.com/api:dev", "example.com/worker:dev"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Apply("deploy/", "https://example.com/crds.yaml"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Rollout(2*time.Minute, "deployment/api"); err != nil {
+		t.Fatal(err)
+	}
+	del()
+	expected = "kind load docker-image --name it example.com/api:dev example.com/worker:dev\n" +
+		"kubectl --context kind-it --namespace app apply --filename deploy/ --filename https://example.com/crds.yaml\n" +
+		"kubectl --context kind-it --namespace app rollout status deployment/api --timeout=2m0s\n" +
+		"kind delete cluster --name it\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestK3dReuse(t *testing.T) {
+	read, cleanup := fakeTools(t)
+	defer cleanup()
+
+	c := Cluster{Provider: K3d, Keep: true}
+	os.Setenv("FAKE_CLUSTERS", "k3s-default\n")
+	del, err := c.Create()
+	if err != nil {
+		t.Fatal(err)
+	}
+	del()
+	if out, expected := read(), "k3d cluster list --no-headers\n"; out != expected {
+		t.Fatalf("expected the cluster to be reused and kept but got %q", out)
+	}
+	if c.Context() != "k3d-k3s-default" {
+		t.Fatalf("unexpected context %s", c.Context())
+	}
+	c.LoadImages("api:dev")
+	c.Delete()
+	expected := "k3d image import --cluster k3s-default api:dev\nk3d cluster delete k3s-default\n"
+	if out := read(); out != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	_, cleanup := fakeTools(t)
+	defer cleanup()
+
+	os.Setenv("FAKE_EXIT", "3")
+	err := Cluster{Name: "it"}.Rollout(time.Minute, "deployment/api")
+	if e, ok := err.(*Error); !ok || e.ExitStatus() != 3 || e.Error() != `rollout of deployment/api of cluster it failed: running "kubectl --context kind-it rollout status deployment/api --timeout=1m0s" failed with exit code 3` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if _, err := (Cluster{}).Create(); err == nil || err.Error() != `list of cluster kind failed: running "kind get clusters" failed with exit code 3` {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := (Cluster{Provider: "minikube"}).Delete(); err == nil || err.Error() != `delete of cluster kind failed: unsupported provider "minikube", the supported providers are: kind, k3d` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/sh"
+)
+
+// Apply applies the manifests, which may be files, directories or URLs, to
+// the cluster.
+func (c Cluster) Apply(manifests ...string) error {
+	args := []string{"apply"}
+	for _, m := range manifests {
+		args = append(args, "--filename", m)
+	}
+	if err := sh.RunV("kubectl", c.kubectl(args...)...); err != nil {
+		return &Error{Step: "apply", Cluster: c.name(), Err: err}
+	}
+	return nil
+}
+
+// Rollout waits up to the timeout for the rollouts of the resources, e.g.
+// deployment/api or statefulset/db, to finish, so their pods are ready.
+func (c Cluster) Rollout(timeout time.Duration, resources ...string) error {
+	deadline := clock.Now().Add(timeout)
+	for _, r := range resources {
+		// the timeout is for all of them, so each gets what's left.
+		left := clock.Until(deadline)
+		if left < time.Second {
+			left = time.Second
+		}
+		args := c.kubectl("rollout", "status", r, fmt.Sprintf("--timeout=%v", left.Round(time.Second)))
+		if err := sh.RunV("kubectl", args...); err != nil {
+			return &Error{Step: "rollout of " + r, Cluster: c.name(), Err: err}
+		}
+	}
+	return nil
+}
+
+// kubectl returns the args for kubectl to run the command in the cluster.
+func (c Cluster) kubectl(args ...string) []string {
+	a := []string{"--context", c.Context()}
+	if c.Namespace != "" {
+		a = append(a, "--namespace", c.Namespace)
+	}
+	return append(a, args...)
+}
@@ -0,0 +1,54 @@
+package licenses
+
+import (
+	"regexp"
+	"strings"
+)
+
+// licenseTexts are the phrases that identify the common licenses, which are
+// checked in order, so a license is checked before the ones whose phrases
+// are part of it.  All of a license's phrases must be in the text, after its
+// whitespace is collapsed and it's lower cased.
+var licenseTexts = []struct {
+	id      string
+	phrases []string
+}{
+	{"AGPL-3.0", []string{"gnu affero general public license", "version 3"}},
+	{"LGPL-3.0", []string{"gnu lesser general public license", "version 3"}},
+	{"LGPL-2.1", []string{"gnu lesser general public license", "version 2.1"}},
+	{"GPL-3.0", []string{"gnu general public license", "version 3"}},
+	{"GPL-2.0", []string{"gnu general public license", "version 2"}},
+	{"MPL-2.0", []string{"mozilla public license", "2.0"}},
+	{"EPL-2.0", []string{"eclipse public license", "2.0"}},
+	{"Apache-2.0", []string{"apache license", "version 2.0"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "neither the name"}},
+	{"BSD-3-Clause", []string{"redistribution and use in source and binary forms", "names of its contributors may"}},
+	{"BSD-2-Clause", []string{"redistribution and use in source and binary forms"}},
+	{"MIT", []string{"permission is hereby granted, free of charge"}},
+	{"ISC", []string{"permission to use, copy, modify, and/or distribute this software for any purpose with or without fee is hereby granted"}},
+	{"ISC", []string{"permission to use, copy, modify, and distribute this software for any purpose with or without fee is hereby granted"}},
+	{"Unlicense", []string{"this is free and unencumbered software released into the public domain"}},
+	{"CC0-1.0", []string{"creative commons", "cc0 1.0 universal"}},
+	{"Zlib", []string{"this software is provided 'as-is', without any express or implied warranty", "altered source versions must be plainly marked"}},
+}
+
+var space = regexp.MustCompile(`\s+`)
+
+// Identify returns the SPDX identifier of the license text, e.g. MIT, if it's
+// one of the common licenses, or Unknown.
+func Identify(text []byte) string {
+	s := strings.ToLower(space.ReplaceAllString(string(text), " "))
+	for _, l := range licenseTexts {
+		matched := true
+		for _, p := range l.phrases {
+			if !strings.Contains(s, p) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return l.id
+		}
+	}
+	return Unknown
+}
@@ -0,0 +1,26 @@
+package licenses
+
+import "testing"
+
+func TestIdentify(t *testing.T) {
+	tests := []struct {
+		text, expected string
+	}{
+		{mitText, "MIT"},
+		{"                                 Apache License\n                           Version 2.0, January 2004", "Apache-2.0"},
+		{"Redistribution and use in source and binary forms, with or without\nmodification, are permitted... * Neither the name of Google Inc. nor", "BSD-3-Clause"},
+		{"Redistribution and use in source and binary forms, with or without modification", "BSD-2-Clause"},
+		{"GNU GENERAL PUBLIC LICENSE\n   Version 3, 29 June 2007", "GPL-3.0"},
+		{"GNU LESSER GENERAL PUBLIC LICENSE\n   Version 3, 29 June 2007", "LGPL-3.0"},
+		{"GNU AFFERO GENERAL PUBLIC LICENSE\n   Version 3, 19 November 2007", "AGPL-3.0"},
+		{"Mozilla Public License Version 2.0\n==================================", "MPL-2.0"},
+		{"ISC License\n\nPermission to use, copy, modify, and/or distribute this software for any\npurpose with or without fee is hereby granted", "ISC"},
+		{"This is free and unencumbered software released into the public domain.", "Unlicense"},
+		{"All rights reserved.", Unknown},
+	}
+	for _, tt := range tests {
+		if got := Identify([]byte(tt.text)); got != tt.expected {
+			t.Errorf("expected %s, got %s for %q", tt.expected, got, tt.text)
+		}
+	}
+}
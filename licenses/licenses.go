@@ -0,0 +1,196 @@
+// Package licenses checks the licenses of the modules a build depends on, for
+// compliance targets in release pipelines.  Find lists the modules the
+// packages import and detects their licenses from their license files, or
+// asks go-licenses, a Policy says which licenses are allowed, and
+// WriteNotice writes the third-party notices to ship with a release.
+package licenses
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/fsutil"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Unknown is the License of a module whose license isn't recognized, or
+// which has no license file.
+const Unknown = "unknown"
+
+// Dependency is a module the packages depend on.
+type Dependency struct {
+	Module  string // the module path, e.g. github.com/pkg/errors
+	Version string // the module version, e.g. v0.9.1
+	License string // the SPDX identifier of the license, e.g. MIT, or Unknown
+	File    string // the path to the license file, or "" if there isn't one
+}
+
+// Options are the options for Find.
+type Options struct {
+	// Packages are the packages whose dependencies are found.  They default
+	// to ./...
+	Packages []string
+
+	// GoLicenses is the path to go-licenses, e.g. from tools.Path, to
+	// identify the licenses with instead of the built in detection, which
+	// only knows the common licenses.
+	GoLicenses string
+}
+
+// Find returns the modules the packages import, other than the main module
+// and the standard library, sorted by path, with their licenses.
+func Find(opts Options) ([]Dependency, error) {
+	pkgs := opts.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	args := append([]string{"list", "-deps", "-f", "{{with .Module}}{{if not .Main}}{{.Path}}\t{{.Version}}\t{{.Dir}}{{end}}{{end}}"}, pkgs...)
+	out, err := output(mg.GoCmd(), args...)
+	if err != nil {
+		return nil, err
+	}
+	var deps []Dependency
+	seen := map[string]bool{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 || seen[fields[0]] {
+			continue
+		}
+		seen[fields[0]] = true
+		d := Dependency{Module: fields[0], Version: fields[1], License: Unknown}
+		if fields[2] != "" {
+			if d.File, err = licenseFile(fields[2]); err != nil {
+				return nil, err
+			}
+		}
+		if d.File != "" {
+			b, err := ioutil.ReadFile(d.File)
+			if err != nil {
+				return nil, err
+			}
+			d.License = Identify(b)
+		}
+		deps = append(deps, d)
+	}
+	sort.Slice(deps, func(i, j int) bool { return deps[i].Module < deps[j].Module })
+
+	if opts.GoLicenses != "" {
+		found, err := goLicenses(opts.GoLicenses, pkgs)
+		if err != nil {
+			return nil, err
+		}
+		// go-licenses reports the packages with the license files, which are
+		// usually the modules' roots.
+		for i := range deps {
+			for pkg, l := range found {
+				if pkg == deps[i].Module || strings.HasPrefix(pkg, deps[i].Module+"/") {
+					deps[i].License = l
+				}
+			}
+		}
+	}
+	return deps, nil
+}
+
+// licenseFile returns the license file in the module's directory, or "" if
+// there isn't one.
+func licenseFile(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var files []string
+	for _, fi := range infos {
+		name := strings.ToUpper(fi.Name())
+		if fi.IsDir() {
+			continue
+		}
+		for _, prefix := range []string{"LICENSE", "LICENCE", "COPYING", "UNLICENSE"} {
+			if strings.HasPrefix(name, prefix) {
+				files = append(files, fi.Name())
+				break
+			}
+		}
+	}
+	if len(files) == 0 {
+		return "", nil
+	}
+	// prefer LICENSE to the likes of LICENSE.docs, if there are several.
+	sort.Slice(files, func(i, j int) bool {
+		if len(files[i]) != len(files[j]) {
+			return len(files[i]) < len(files[j])
+		}
+		return files[i] < files[j]
+	})
+	return filepath.Join(dir, files[0]), nil
+}
+
+// goLicenses returns the licenses go-licenses reports for the packages, by
+// module.
+func goLicenses(bin string, pkgs []string) (map[string]string, error) {
+	out, err := output(bin, append([]string{"csv"}, pkgs...)...)
+	if err != nil {
+		return nil, err
+	}
+	records, err := csv.NewReader(strings.NewReader(out)).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("can't parse the output of go-licenses: %v", err)
+	}
+	found := map[string]string{}
+	for _, r := range records {
+		if len(r) == 3 && r[2] != "" && r[2] != "Unknown" {
+			found[r[0]] = r[2]
+		}
+	}
+	return found, nil
+}
+
+// output runs the command, and returns what it prints, or an error with what
+// it printed to stderr.
+func output(cmd string, args ...string) (string, error) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	ran, err := sh.Exec(nil, stdout, stderr, cmd, args...)
+	if !ran {
+		return "", fmt.Errorf("failed to run %s: %v", filepath.Base(cmd), err)
+	}
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s %s: %s", filepath.Base(cmd), args[0], msg)
+		}
+		return "", err
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// WriteNotice writes the third-party notices file, e.g. NOTICE or
+// THIRD_PARTY_LICENSES, with the license of each dependency.
+func WriteNotice(path string, deps []Dependency) error {
+	var b bytes.Buffer
+	b.WriteString("This software includes the following third-party modules.\n")
+	for _, d := range deps {
+		fmt.Fprintf(&b, "\n================================================================================\n")
+		fmt.Fprintf(&b, "%s", d.Module)
+		if d.Version != "" {
+			fmt.Fprintf(&b, " %s", d.Version)
+		}
+		fmt.Fprintf(&b, " (%s)\n\n", d.License)
+		if d.File == "" {
+			b.WriteString("No license file was found.\n")
+			continue
+		}
+		text, err := ioutil.ReadFile(d.File)
+		if err != nil {
+			return err
+		}
+		b.Write(bytes.TrimRight(text, "\r\n"))
+		b.WriteString("\n")
+	}
+	return fsutil.WriteFile(path, b.Bytes(), 0644)
+}
@@ -0,0 +1,127 @@
+package licenses
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+const mitText = `MIT License
+
+Copyright (c) 2020 Example
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software")...
+`
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"go-licenses": fakeGoLicenses})
+	os.Exit(m.Run())
+}
+
+// fakeGoLicenses stands in for go-licenses, writing its args to the file args
+// next to it, and printing the licenses of the module made by module.
+func fakeGoLicenses(args []string) int {
+	ioutil.WriteFile(filepath.Join(faketool.Dir(), "args"), []byte(strings.Join(args, " ")+"\n"), 0644)
+	fmt.Println("example.com/mit/sub,https://example.com/LICENSE,Apache-2.0")
+	fmt.Println("example.com/none,Unknown,Unknown")
+	return 0
+}
+
+// module makes a main module in dir/app that imports example.com/mit and
+// example.com/none, which are replaced with directories next to it, and
+// changes to it.  It returns a func that changes back.
+func module(t *testing.T, dir string) func() {
+	files := map[string]string{
+		"app/go.mod": "module example.com/app\n\ngo 1.12\n\n" +
+			"require (\n\texample.com/mit v1.0.0\n\texample.com/none v0.1.0\n)\n\n" +
+			"replace example.com/mit => ../mit\n\nreplace example.com/none => ../none\n",
+		"app/main.go":       "package main\n\nimport (\n\t_ \"example.com/mit/sub\"\n\t_ \"example.com/none\"\n)\n\nfunc main() {}\n",
+		"mit/go.mod":        "module example.com/mit\n",
+		"mit/LICENSE":       mitText,
+		"mit/LICENSE.docs":  "CC-BY-4.0\n",
+		"mit/sub/sub.go":    "package sub\n",
+		"none/go.mod":       "module example.com/none\n",
+		"none/none.go":      "package none\n",
+		"none/README.md":    "no license\n",
+		"app/internal/a.go": "package internal\n",
+	}
+	for name, data := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(filepath.Join(dir, "app")); err != nil {
+		t.Fatal(err)
+	}
+	proxy := os.Getenv("GOPROXY")
+	os.Setenv("GOPROXY", "off")
+	return func() {
+		os.Setenv("GOPROXY", proxy)
+		os.Chdir(wd)
+	}
+}
+
+func TestFind(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer module(t, dir)()
+
+	deps, err := Find(Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 {
+		t.Fatalf("expected 2 dependencies, got %+v", deps)
+	}
+	mit, none := deps[0], deps[1]
+	if mit.Module != "example.com/mit" || mit.Version != "v1.0.0" || mit.License != "MIT" || filepath.Base(mit.File) != "LICENSE" {
+		t.Fatalf("unexpected %+v", mit)
+	}
+	if none.Module != "example.com/none" || none.License != Unknown || none.File != "" {
+		t.Fatalf("unexpected %+v", none)
+	}
+
+	notice := filepath.Join(dir, "dist", "NOTICE")
+	if err := WriteNotice(notice, deps); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(notice)
+	for _, s := range []string{"example.com/mit v1.0.0 (MIT)\n\nMIT License\n", "example.com/none v0.1.0 (unknown)\n\nNo license file was found.\n"} {
+		if !strings.Contains(string(b), s) {
+			t.Errorf("expected the notice to have %q, got\n%s", s, b)
+		}
+	}
+}
+
+func TestGoLicenses(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer module(t, dir)()
+	bin := faketool.Install(t, dir, "go-licenses")
+
+	deps, err := Find(Options{Packages: []string{"."}, GoLicenses: bin})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deps) != 2 || deps[0].License != "Apache-2.0" || deps[1].License != Unknown {
+		t.Fatalf("unexpected %+v", deps)
+	}
+	if b, _ := ioutil.ReadFile(filepath.Join(dir, "args")); string(b) != "csv .\n" {
+		t.Fatalf("unexpected args %q", b)
+	}
+}
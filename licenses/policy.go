@@ -0,0 +1,82 @@
+package licenses
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Policy says which licenses the dependencies may have.
+type Policy struct {
+	// Allow are the licenses dependencies may have, by SPDX identifier, e.g.
+	// MIT.  If there are none, any license that isn't denied is allowed,
+	// including Unknown.
+	Allow []string
+
+	// Deny are the licenses dependencies may not have, e.g. AGPL-3.0.
+	Deny []string
+
+	// Ignore are modules that aren't checked, like your organization's own,
+	// by module path or a prefix of one ending in /, e.g.
+	// github.com/example/.
+	Ignore []string
+}
+
+// Violation is a dependency whose license isn't allowed.
+type Violation struct {
+	Dependency
+	// Reason is why the license isn't allowed.
+	Reason string
+}
+
+// ViolationError is the error when dependencies' licenses aren't allowed.
+type ViolationError []Violation
+
+func (e ViolationError) Error() string {
+	lines := []string{fmt.Sprintf("%d dependencies have licenses that aren't allowed:", len(e))}
+	for _, v := range e {
+		lines = append(lines, fmt.Sprintf("  %s %s: %s", v.Module, v.Version, v.Reason))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Check returns a ViolationError listing the dependencies whose licenses the
+// policy doesn't allow, or nil if they're all allowed.
+func (p Policy) Check(deps []Dependency) error {
+	var violations ViolationError
+	for _, d := range deps {
+		if p.ignored(d.Module) {
+			continue
+		}
+		switch {
+		case has(p.Deny, d.License):
+			violations = append(violations, Violation{d, d.License + " is denied"})
+		case len(p.Allow) > 0 && d.License == Unknown:
+			violations = append(violations, Violation{d, "the license isn't known"})
+		case len(p.Allow) > 0 && !has(p.Allow, d.License):
+			violations = append(violations, Violation{d, d.License + " isn't allowed"})
+		}
+	}
+	if len(violations) > 0 {
+		return violations
+	}
+	return nil
+}
+
+func (p Policy) ignored(module string) bool {
+	for _, ig := range p.Ignore {
+		if module == ig || strings.HasSuffix(ig, "/") && strings.HasPrefix(module, ig) {
+			return true
+		}
+	}
+	return false
+}
+
+// has reports whether the licenses have the license, ignoring case.
+func has(licenses []string, license string) bool {
+	for _, l := range licenses {
+		if strings.EqualFold(l, license) {
+			return true
+		}
+	}
+	return false
+}
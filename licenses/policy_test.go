@@ -0,0 +1,33 @@
+package licenses
+
+import "testing"
+
+func TestCheck(t *testing.T) {
+	deps := []Dependency{
+		{Module: "example.com/a", Version: "v1.0.0", License: "MIT"},
+		{Module: "example.com/b", Version: "v1.0.0", License: "AGPL-3.0"},
+		{Module: "example.com/c", Version: "v0.1.0", License: Unknown},
+		{Module: "example.com/d", Version: "v2.0.0", License: "GPL-2.0"},
+		{Module: "github.com/example/tool", Version: "v0.0.1", License: Unknown},
+	}
+	p := Policy{
+		Allow:  []string{"mit", "Apache-2.0"},
+		Deny:   []string{"AGPL-3.0"},
+		Ignore: []string{"github.com/example/"},
+	}
+	err := p.Check(deps)
+	expected := `3 dependencies have licenses that aren't allowed:
+  example.com/b v1.0.0: AGPL-3.0 is denied
+  example.com/c v0.1.0: the license isn't known
+  example.com/d v2.0.0: GPL-2.0 isn't allowed`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected %q, got %v", expected, err)
+	}
+	if v := err.(ViolationError); len(v) != 3 || v[0].Module != "example.com/b" {
+		t.Fatalf("unexpected violations %+v", v)
+	}
+
+	if err := (Policy{Deny: []string{"AGPL-3.0"}}).Check(deps[2:]); err != nil {
+		t.Fatalf("expected only denied licenses to fail without an allow list, got %v", err)
+	}
+}
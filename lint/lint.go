@@ -0,0 +1,189 @@
+// Package lint runs golangci-lint, pinned to a version with the tools package,
+// and reports the issues it finds as text, as GitHub Actions annotations, and
+// as SARIF for code scanning.
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/tools"
+)
+
+// Package is the package golangci-lint is installed from.
+const Package = "github.com/golangci/golangci-lint/cmd/golangci-lint"
+
+// DefaultVersion is the version of golangci-lint that's installed if the tools
+// manifest doesn't pin one.
+const DefaultVersion = "v1.55.2"
+
+// Options are the options for Run.
+type Options struct {
+	// Version is the version of golangci-lint to install.  By default, it's
+	// the version in the tools manifest, if it lists golangci-lint, or else
+	// DefaultVersion.
+	Version string
+
+	// Binary is the golangci-lint to run, instead of installing one.
+	Binary string
+
+	// Config is the path of the config file.  By default, golangci-lint
+	// looks for .golangci.yml and the like in the project.
+	Config string
+
+	// Packages are the packages to lint.  They default to ./...
+	Packages []string
+
+	// Fix fixes the issues golangci-lint knows how to.
+	Fix bool
+
+	// Flags are other flags for golangci-lint, e.g. --new-from-rev=main.
+	Flags []string
+
+	// SARIF is the path to write the issues to in SARIF format, e.g. for
+	// GitHub code scanning.
+	SARIF string
+
+	// Format is how the issues are printed: text, like compiler errors, or
+	// github, as GitHub Actions annotations, so they show up on the lines of
+	// the pull request.  It defaults to github when running on GitHub
+	// Actions, and text otherwise.
+	Format string
+
+	// Stdout is where the issues are printed.  It defaults to os.Stdout.
+	Stdout io.Writer
+}
+
+// Issue is a problem golangci-lint found.
+type Issue struct {
+	Linter   string
+	Text     string
+	Severity string
+	File     string
+	Line     int
+	Column   int
+}
+
+// String returns the issue the way compilers print errors, e.g.
+// "main.go:12:2: Error return value is not checked (errcheck)".
+func (i Issue) String() string {
+	pos := fmt.Sprintf("%s:%d", i.File, i.Line)
+	if i.Column > 0 {
+		pos += fmt.Sprintf(":%d", i.Column)
+	}
+	return fmt.Sprintf("%s: %s (%s)", pos, i.Text, i.Linter)
+}
+
+// Run lints the project with golangci-lint, prints the issues, and returns
+// them.  It returns an error if there are any.
+//
+//	func Lint() error {
+//		_, err := lint.Run(lint.Options{SARIF: "lint.sarif"})
+//		return err
+//	}
+func Run(opts Options) ([]Issue, error) {
+	if opts.Format != "" && opts.Format != "text" && opts.Format != "github" {
+		return nil, fmt.Errorf("unsupported lint format %q, the supported formats are: github, text", opts.Format)
+	}
+	bin, err := binary(opts)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"run", "--out-format", "json"}
+	if opts.Config != "" {
+		args = append(args, "--config", opts.Config)
+	}
+	if opts.Fix {
+		args = append(args, "--fix")
+	}
+	args = append(args, opts.Flags...)
+	pkgs := opts.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	args = append(args, pkgs...)
+
+	out := &bytes.Buffer{}
+	_, runErr := sh.Exec(nil, out, os.Stderr, bin, args...)
+	// golangci-lint exits with 1 when it finds issues, and anything else
+	// when it couldn't lint.
+	if runErr != nil && sh.ExitStatus(runErr) != 1 {
+		return nil, runErr
+	}
+	var report struct {
+		Issues []struct {
+			FromLinter string
+			Text       string
+			Severity   string
+			Pos        struct {
+				Filename     string
+				Line, Column int
+			}
+		}
+	}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		return nil, fmt.Errorf("can't read the output of golangci-lint: %v", err)
+	}
+	issues := make([]Issue, 0, len(report.Issues))
+	for _, i := range report.Issues {
+		issues = append(issues, Issue{
+			Linter:   i.FromLinter,
+			Text:     i.Text,
+			Severity: i.Severity,
+			File:     i.Pos.Filename,
+			Line:     i.Pos.Line,
+			Column:   i.Pos.Column,
+		})
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	format := opts.Format
+	if format == "" {
+		format = "text"
+		if os.Getenv("GITHUB_ACTIONS") == "true" {
+			format = "github"
+		}
+	}
+	for _, i := range issues {
+		if format == "github" {
+			fmt.Fprintln(stdout, annotation(i))
+		} else {
+			fmt.Fprintln(stdout, i)
+		}
+	}
+	if opts.SARIF != "" {
+		if err := writeSARIF(opts.SARIF, issues); err != nil {
+			return issues, err
+		}
+	}
+	if runErr != nil || len(issues) > 0 {
+		return issues, mg.Fatalf(1, "golangci-lint found %d issues", len(issues))
+	}
+	return issues, nil
+}
+
+// binary returns the golangci-lint to run, installing it if it needs to.
+func binary(opts Options) (string, error) {
+	if opts.Binary != "" {
+		return opts.Binary, nil
+	}
+	if opts.Version != "" {
+		return tools.Ensure(Package, opts.Version)
+	}
+	t, ok, err := tools.Lookup(Package)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return t.Ensure()
+	}
+	return tools.Ensure(Package, DefaultVersion)
+}
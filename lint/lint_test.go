@@ -0,0 +1,203 @@
+package lint
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/mg"
+)
+
+const report = `{"Issues":[
+{"FromLinter":"errcheck","Text":"Error return value of ` + "`f.Close`" + ` is not checked","Severity":"","Pos":{"Filename":"main.go","Line":12,"Column":2}},
+{"FromLinter":"gofmt","Text":"File is not gofmt-ed, 50% off","Severity":"warning","Pos":{"Filename":"pkg/a,b.go","Line":3,"Column":0}}
+],"Report":{}}`
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"golangci-lint": golangciLint})
+	os.Exit(m.Run())
+}
+
+// golangciLint stands in for golangci-lint.  It writes its args to the file
+// args, prints the file output, and exits with the code in the file code,
+// all next to it.
+func golangciLint(args []string) int {
+	dir := faketool.Dir()
+	ioutil.WriteFile(filepath.Join(dir, "args"), []byte(strings.Join(args, " ")+"\n"), 0644)
+	faketool.Cat(filepath.Join(dir, "output"))
+	b, _ := ioutil.ReadFile(filepath.Join(dir, "code"))
+	code, _ := strconv.Atoi(string(b))
+	return code
+}
+
+// fakeLint installs a golangci-lint that prints the output, and exits with
+// the code.  It returns its path, the file with the args it was run with, and
+// a func that removes them.
+func fakeLint(t *testing.T, output string, code int) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(filepath.Join(dir, "output"), []byte(output), 0644)
+	ioutil.WriteFile(filepath.Join(dir, "code"), []byte(strconv.Itoa(code)), 0644)
+	bin := faketool.Install(t, dir, "golangci-lint")
+	return bin, filepath.Join(dir, "args"), func() { os.RemoveAll(dir) }
+}
+
+func TestRun(t *testing.T) {
+	bin, args, cleanup := fakeLint(t, report, 1)
+	defer cleanup()
+	out := &bytes.Buffer{}
+	sarif := filepath.Join(filepath.Dir(bin), "lint.sarif")
+	issues, err := Run(Options{Binary: bin, Config: "ci.yml", Fix: true, Flags: []string{"--new-from-rev=main"}, Format: "text", SARIF: sarif, Stdout: out})
+	if err == nil || err.Error() != "golangci-lint found 2 issues" || mg.ExitStatus(err) != 1 {
+		t.Fatalf("expected 2 issues but got %v", err)
+	}
+	if len(issues) != 2 || issues[0].Linter != "errcheck" || issues[1].File != "pkg/a,b.go" {
+		t.Fatalf("unexpected issues %+v", issues)
+	}
+	b, _ := ioutil.ReadFile(args)
+	if string(b) != "run --out-format json --config ci.yml --fix --new-from-rev=main ./...\n" {
+		t.Fatalf("unexpected args %q", b)
+	}
+	expected := "main.go:12:2: Error return value of `f.Close` is not checked (errcheck)\n" +
+		"pkg/a,b.go:3: File is not gofmt-ed, 50% off (gofmt)\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+
+	var log struct {
+		Version string
+		Runs    []struct {
+			Tool struct {
+				Driver struct {
+					Name  string
+					Rules []struct{ ID string }
+				}
+			}
+			Results []struct {
+				RuleID    string
+				Level     string
+				Locations []struct {
+					PhysicalLocation struct {
+						ArtifactLocation struct{ URI string }
+						Region           struct{ StartLine, StartColumn int }
+					}
+				}
+			}
+		}
+	}
+	b, err = ioutil.ReadFile(sarif)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &log); err != nil {
+		t.Fatal(err)
+	}
+	run := log.Runs[0]
+	if log.Version != "2.1.0" || run.Tool.Driver.Name != "golangci-lint" || len(run.Tool.Driver.Rules) != 2 || run.Tool.Driver.Rules[0].ID != "errcheck" {
+		t.Fatalf("unexpected SARIF:\n%s", b)
+	}
+	if r := run.Results[1]; r.RuleID != "gofmt" || r.Level != "warning" || r.Locations[0].PhysicalLocation.ArtifactLocation.URI != "pkg/a,b.go" || r.Locations[0].PhysicalLocation.Region.StartLine != 3 {
+		t.Fatalf("unexpected SARIF result %+v", r)
+	}
+}
+
+func TestRunGitHub(t *testing.T) {
+	bin, _, cleanup := fakeLint(t, report, 1)
+	defer cleanup()
+	old, ok := os.LookupEnv("GITHUB_ACTIONS")
+	os.Setenv("GITHUB_ACTIONS", "true")
+	defer func() {
+		if ok {
+			os.Setenv("GITHUB_ACTIONS", old)
+		} else {
+			os.Unsetenv("GITHUB_ACTIONS")
+		}
+	}()
+	out := &bytes.Buffer{}
+	Run(Options{Binary: bin, Stdout: out})
+	expected := "::error file=main.go,line=12,col=2,title=errcheck::Error return value of `f.Close` is not checked\n" +
+		"::warning file=pkg/a%2Cb.go,line=3,title=gofmt::File is not gofmt-ed, 50%25 off\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+}
+
+func TestRunClean(t *testing.T) {
+	bin, _, cleanup := fakeLint(t, `{"Issues":[],"Report":{}}`, 0)
+	defer cleanup()
+	out := &bytes.Buffer{}
+	issues, err := Run(Options{Binary: bin, Stdout: out})
+	if err != nil || len(issues) != 0 || out.Len() != 0 {
+		t.Fatalf("expected no issues but got %v, %v, %q", issues, err, out)
+	}
+}
+
+func TestRunFailure(t *testing.T) {
+	bin, _, cleanup := fakeLint(t, "", 3)
+	defer cleanup()
+	_, err := Run(Options{Binary: bin, Stdout: ioutil.Discard})
+	if err == nil || mg.ExitStatus(err) != 3 {
+		t.Fatalf("expected golangci-lint's failure but got %v", err)
+	}
+	if _, err := Run(Options{Binary: bin, Format: "xml"}); err == nil || err.Error() != `unsupported lint format "xml", the supported formats are: github, text` {
+		t.Fatalf("expected an unsupported format error but got %v", err)
+	}
+}
+
+func TestBinaryFromManifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	wd, _ := os.Getwd()
+	defer os.Chdir(wd)
+	os.Chdir(dir)
+	old, ok := os.LookupEnv(mg.ToolsDirEnv)
+	os.Unsetenv(mg.ToolsDirEnv)
+	defer func() {
+		if ok {
+			os.Setenv(mg.ToolsDirEnv, old)
+		}
+	}()
+
+	// the tools are already installed, so nothing is downloaded.
+	exe := "golangci-lint"
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	for _, v := range []string{"v1.0.0", "v2.0.0", DefaultVersion} {
+		path := filepath.Join(dir, ".tools", "golangci-lint", v, exe)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		ioutil.WriteFile(path, nil, 0755)
+	}
+	check := func(opts Options, version string) {
+		t.Helper()
+		bin, err := binary(opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := filepath.Join(dir, ".tools", "golangci-lint", version, exe); bin != expected {
+			t.Fatalf("expected %s but got %s", expected, bin)
+		}
+	}
+	check(Options{}, DefaultVersion)
+	ioutil.WriteFile("tools.yaml", []byte("lint: "+Package+"@v1.0.0\n"), 0644)
+	check(Options{}, "v1.0.0")
+	check(Options{Version: "v2.0.0"}, "v2.0.0")
+
+	// a broken manifest isn't taken for a missing one.
+	ioutil.WriteFile("tools.yaml", []byte("lint "+Package+"\n"), 0644)
+	if _, err := binary(Options{}); err == nil {
+		t.Fatal("expected an error reading the tools manifest, but got none")
+	}
+}
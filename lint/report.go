@@ -0,0 +1,116 @@
+package lint
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// annotation returns the GitHub Actions workflow command that annotates the
+// line with the issue.
+func annotation(i Issue) string {
+	level := "error"
+	if i.Severity == "warning" || i.Severity == "info" {
+		level = "warning"
+	}
+	props := fmt.Sprintf("file=%s,line=%d", escapeProperty(filepath.ToSlash(i.File)), i.Line)
+	if i.Column > 0 {
+		props += fmt.Sprintf(",col=%d", i.Column)
+	}
+	props += ",title=" + escapeProperty(i.Linter)
+	return fmt.Sprintf("::%s %s::%s", level, props, escapeData(i.Text))
+}
+
+// escapeData escapes the message of a workflow command.
+func escapeData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeProperty escapes a property of a workflow command.
+func escapeProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// writeSARIF writes the issues to the file as a SARIF 2.1.0 log.
+func writeSARIF(path string, issues []Issue) error {
+	type (
+		message struct {
+			Text string `json:"text"`
+		}
+		artifact struct {
+			URI string `json:"uri"`
+		}
+		region struct {
+			StartLine   int `json:"startLine"`
+			StartColumn int `json:"startColumn,omitempty"`
+		}
+		physical struct {
+			ArtifactLocation artifact `json:"artifactLocation"`
+			Region           region   `json:"region"`
+		}
+		location struct {
+			PhysicalLocation physical `json:"physicalLocation"`
+		}
+		result struct {
+			RuleID    string     `json:"ruleId"`
+			Level     string     `json:"level"`
+			Message   message    `json:"message"`
+			Locations []location `json:"locations"`
+		}
+		rule struct {
+			ID string `json:"id"`
+		}
+		driver struct {
+			Name           string `json:"name"`
+			InformationURI string `json:"informationUri"`
+			Rules          []rule `json:"rules"`
+		}
+		tool struct {
+			Driver driver `json:"driver"`
+		}
+		run struct {
+			Tool    tool     `json:"tool"`
+			Results []result `json:"results"`
+		}
+	)
+	linters := map[string]bool{}
+	results := []result{}
+	for _, i := range issues {
+		linters[i.Linter] = true
+		level := "error"
+		if i.Severity == "warning" || i.Severity == "info" {
+			level = "warning"
+		}
+		results = append(results, result{
+			RuleID:  i.Linter,
+			Level:   level,
+			Message: message{i.Text},
+			Locations: []location{{PhysicalLocation: physical{
+				ArtifactLocation: artifact{URI: filepath.ToSlash(i.File)},
+				Region:           region{StartLine: i.Line, StartColumn: i.Column},
+			}}},
+		})
+	}
+	rules := []rule{}
+	for l := range linters {
+		rules = append(rules, rule{ID: l})
+	}
+	sort.Slice(rules, func(i, j int) bool { return rules[i].ID < rules[j].ID })
+
+	log := map[string]interface{}{
+		"version": "2.1.0",
+		"$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+		"runs": []run{{
+			Tool:    tool{Driver: driver{Name: "golangci-lint", InformationURI: "https://golangci-lint.run", Rules: rules}},
+			Results: results,
+		}},
+	}
+	b, err := json.MarshalIndent(log, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, append(b, '\n'), 0644)
+}
@@ -0,0 +1,210 @@
+// Package lock takes advisory file locks, so that two runs of a target that
+// mustn't overlap, like two deploys from the same checkout on a shared
+// server, either wait for each other or fail straight away, saying who has
+// the lock.  A lock is released when it's released, or when the process that
+// holds it exits, however it exits.
+package lock
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/mg"
+)
+
+// pollInterval is how often a lock that's waited for is tried.
+var pollInterval = 250 * time.Millisecond
+
+// Holder is who holds a lock.
+type Holder struct {
+	PID     int
+	User    string
+	Host    string
+	Command string
+	Since   time.Time
+}
+
+// String describes the holder, e.g. "mage deploy (pid 1234) run by alice on
+// devbox since 14:03:05".
+func (h Holder) String() string {
+	s := fmt.Sprintf("%s (pid %d)", h.Command, h.PID)
+	if h.User != "" {
+		s += " run by " + h.User
+	}
+	if h.Host != "" {
+		s += " on " + h.Host
+	}
+	if !h.Since.IsZero() {
+		s += " since " + h.Since.Format("2006-01-02 15:04:05")
+	}
+	return s
+}
+
+// HeldError is the error when the lock is held by someone else.
+type HeldError struct {
+	// Path is the path of the lock file.
+	Path string
+
+	// Holder is who holds it, if they could be found out.
+	Holder *Holder
+}
+
+func (e *HeldError) Error() string {
+	if e.Holder == nil {
+		return fmt.Sprintf("%s is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("%s is locked by %s", e.Path, e.Holder)
+}
+
+// Lock is a lock that's held.
+type Lock struct {
+	f *os.File
+}
+
+// Release releases the lock.
+func (l *Lock) Release() error {
+	// the holder is cleared before the lock is let go, so no one else's is
+	// ever cleared.
+	l.f.Truncate(0)
+	return l.f.Close()
+}
+
+// File takes the lock on the file at path, creating it if it doesn't exist.
+// If someone else holds it, File returns a *HeldError, or if wait is true,
+// waits for them to release it.
+func File(path string, wait bool) (*Lock, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0666)
+	if err != nil {
+		return nil, err
+	}
+	logged := false
+	for {
+		ok, err := tryLock(f)
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can't lock %s: %v", path, err)
+		}
+		if ok {
+			break
+		}
+		held := &HeldError{Path: path, Holder: readHolder(path)}
+		if !wait {
+			f.Close()
+			return nil, held
+		}
+		if !logged {
+			log.Printf("waiting for the lock: %v", held)
+			logged = true
+		}
+		clock.Sleep(pollInterval)
+	}
+	writeHolder(f)
+	return &Lock{f: f}, nil
+}
+
+// Named takes the lock with the name, e.g. staging, which is shared by every
+// checkout the user has on the machine, like File.  The locks are kept in
+// mage's cache dir, where other users can't read who holds them.
+func Named(name string, wait bool) (*Lock, error) {
+	dir := filepath.Join(mg.CacheDir(), "locks")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return File(filepath.Join(dir, name+".lock"), wait)
+}
+
+// Repo takes the lock for the checkout that the directory is in, like File.
+// The lock file is mage.lock in the .git directory, so it isn't committed,
+// or .mage.lock in the directory if it isn't in a git repository.
+func Repo(dir string, wait bool) (*Lock, error) {
+	path, err := RepoPath(dir)
+	if err != nil {
+		return nil, err
+	}
+	return File(path, wait)
+}
+
+// RepoPath returns the path of the lock file Repo uses for the directory.
+func RepoPath(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for d := dir; ; {
+		info, err := os.Stat(filepath.Join(d, ".git"))
+		if err == nil {
+			if info.IsDir() {
+				return filepath.Join(d, ".git", "mage.lock"), nil
+			}
+			// a worktree or submodule, where .git is a file.
+			return filepath.Join(d, ".mage.lock"), nil
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return filepath.Join(dir, ".mage.lock"), nil
+		}
+		d = parent
+	}
+}
+
+// writeHolder records who holds the lock in the file.
+func writeHolder(f *os.File) {
+	h := Holder{
+		PID:     os.Getpid(),
+		Command: command(os.Args),
+		Since:   time.Now(),
+	}
+	if u, err := user.Current(); err == nil {
+		h.User = u.Username
+	}
+	h.Host, _ = os.Hostname()
+	b, _ := json.Marshal(h)
+	f.Truncate(0)
+	f.WriteAt(b, 0)
+}
+
+// command returns the name of the binary in args and the targets it's running,
+// for the holder.  Flags are left out along with the word after them, unless
+// they're -name=value, since it may be a value like a target's --token, which
+// mustn't be written to disk.
+func command(args []string) string {
+	words := []string{filepath.Base(args[0])}
+	for i := 1; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if strings.HasPrefix(a, "-") {
+			if !strings.Contains(a, "=") {
+				i++
+			}
+			continue
+		}
+		words = append(words, a)
+	}
+	return strings.Join(words, " ")
+}
+
+// readHolder returns who holds the lock, or nil if it can't tell.
+func readHolder(path string) *Holder {
+	b, err := ioutil.ReadFile(path)
+	if err != nil || len(b) == 0 {
+		return nil
+	}
+	var h Holder
+	if err := json.Unmarshal(b, &h); err != nil {
+		return nil
+	}
+	return &h
+}
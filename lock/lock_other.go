@@ -0,0 +1,15 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !windows
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd,!windows
+
+package lock
+
+import (
+	"errors"
+	"os"
+	"runtime"
+)
+
+// tryLock fails, since there's no file locking here.
+func tryLock(f *os.File) (bool, error) {
+	return false, errors.New("file locks aren't supported on " + runtime.GOOS)
+}
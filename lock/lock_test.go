@@ -0,0 +1,138 @@
+package lock
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	path := filepath.Join(dir, "locks", "deploy.lock")
+
+	l, err := File(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = File(path, false)
+	held, ok := err.(*HeldError)
+	if !ok {
+		t.Fatalf("expected the lock to be held but got %v", err)
+	}
+	if held.Holder == nil || held.Holder.PID != os.Getpid() || time.Since(held.Holder.Since) > time.Minute {
+		t.Fatalf("expected this process to hold the lock but got %+v", held.Holder)
+	}
+	if !strings.HasPrefix(err.Error(), path+" is locked by "+held.Holder.Command+" (pid ") {
+		t.Fatalf("unexpected message %q", err)
+	}
+
+	old := pollInterval
+	pollInterval = time.Millisecond
+	defer func() { pollInterval = old }()
+	released := make(chan struct{})
+	time.AfterFunc(50*time.Millisecond, func() {
+		l.Release()
+		close(released)
+	})
+	l2, err := File(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-released:
+	default:
+		t.Fatal("expected to wait for the lock to be released")
+	}
+	l2.Release()
+	if b, _ := ioutil.ReadFile(path); len(b) != 0 {
+		t.Fatalf("expected the holder to be cleared on release but got %q", b)
+	}
+}
+
+func TestRepoPath(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	dir, _ = filepath.EvalSymlinks(dir)
+	sub := filepath.Join(dir, "repo", "magefiles")
+	os.MkdirAll(sub, 0755)
+
+	tests := []struct {
+		setup    func()
+		expected string
+	}{
+		{func() {}, filepath.Join(sub, ".mage.lock")},
+		{func() { os.Mkdir(filepath.Join(dir, "repo", ".git"), 0755) }, filepath.Join(dir, "repo", ".git", "mage.lock")},
+		{func() { ioutil.WriteFile(filepath.Join(sub, ".git"), []byte("gitdir: ../.git/worktrees/x"), 0644) }, filepath.Join(sub, ".mage.lock")},
+	}
+	for i, tt := range tests {
+		tt.setup()
+		path, err := RepoPath(sub)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// the temp dir may itself be in a git repository.
+		if i == 0 && !strings.HasPrefix(path, dir) {
+			continue
+		}
+		if path != tt.expected {
+			t.Errorf("%d: expected %s but got %s", i, tt.expected, path)
+		}
+	}
+}
+
+func TestNamed(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	os.Setenv(mg.CacheEnv, dir)
+	defer os.Unsetenv(mg.CacheEnv)
+
+	l, err := Named("mage-lock-test", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	// other users can't read who holds it.
+	info, err := os.Stat(filepath.Join(dir, "locks"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm() != 0700 {
+		t.Fatalf("expected the locks to be private to the user, but their dir is %v", info.Mode())
+	}
+	if _, err := Named("mage-lock-test", false); err == nil {
+		t.Fatal("expected the named lock to be held")
+	}
+	if l2, err := Named("mage-lock-test-other", false); err != nil {
+		t.Fatalf("expected another name to be a different lock but got %v", err)
+	} else {
+		l2.Release()
+	}
+}
+
+func TestCommand(t *testing.T) {
+	tests := map[string][]string{
+		"mage deploy":         {"/usr/local/bin/mage", "-lock", "wait", "deploy"},
+		"mage deploy staging": {"mage", "deploy", "--token", "s3cret", "staging"},
+		"mage build test":     {"mage", "build", "--token=s3cret", "test", "--", "s3cret"},
+	}
+	for expected, args := range tests {
+		if actual := command(args); actual != expected {
+			t.Errorf("%q: expected %q but got %q", args, expected, actual)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package mage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestArgs(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/args",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"greet", "bob", "2", "hello", "build:tag", "--push", "--tag=v1", "release", "--version", "1.2.3", "--draft"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	actual := stdout.String()
+	expected := "hi bob\nhi bob\nhello\ntag v1 push=true\nrelease 1.2.3 draft=true tries=0\n"
+	if actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestArgTypes(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/args",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args: []string{
+			"deploy", "--env=prod", "--wait", "1m30s", "--ratio", "0.5", "--tags", "a,b", "--tags", "c",
+			"deploy", "dev", "2s", "1", "x, y",
+		},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	actual := stdout.String()
+	expected := "deploy prod wait=1m30s ratio=0.5 tags=[\"a\" \"b\" \"c\"]\ndeploy dev wait=2s ratio=1 tags=[\"x\" \"y\"]\n"
+	if actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestArgsErrors(t *testing.T) {
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"greet", "bob"}, "not enough arguments for target \"greet\", expected 2, got 1\n"},
+		{[]string{"greet", "--count", "x"}, "invalid value \"x\" for --count of target \"greet\": expected an integer\n"},
+		{[]string{"greet", "bob", "x"}, "invalid value \"x\" for count argument of target \"greet\": expected an integer\n"},
+		{[]string{"deploy", "--env", "qa"}, "invalid value \"qa\" for --env of target \"deploy\": expected one of: dev, prod\n"},
+		{[]string{"deploy", "--wait", "5"}, "invalid value \"5\" for --wait of target \"deploy\": expected a duration like 1m30s\n"},
+		{[]string{"deploy", "--ratio", "half"}, "invalid value \"half\" for --ratio of target \"deploy\": expected a number\n"},
+		{[]string{"greet", "--nope"}, "unknown flag --nope for target \"greet\"\n"},
+		{[]string{"greet", "--name"}, "flag --name for target \"greet\" needs a value\n"},
+	}
+	for _, tt := range tests {
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/args",
+			Stdout: ioutil.Discard,
+			Stderr: stderr,
+			Args:   tt.args,
+		}
+		code := Invoke(inv)
+		if code != 2 {
+			t.Errorf("%q: expected to exit with code 2, but got %v", tt.args, code)
+		}
+		if actual := stderr.String(); actual != tt.expected {
+			t.Errorf("%q: expected %q, but got %q", tt.args, tt.expected, actual)
+		}
+	}
+}
+
+func TestArgsHelp(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/args",
+		Stdout: stdout,
+		Stderr: ioutil.Discard,
+		Args:   []string{"release"},
+		Help:   true,
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	actual := stdout.String()
+	expected := `
+mage release:
+
+Cuts a release.
+
+Usage:
+
+	mage release [--version=<string>] [--draft] [--max-tries=<int>]
+
+Flags:
+
+    --version string    the version to release
+    --draft bool        
+    --max-tries int     MaxTries is how many times to try.
+
+`[1:]
+	if actual != expected {
+		t.Fatalf("expected:\n%s\n\ngot:\n%s", expected, actual)
+	}
+}
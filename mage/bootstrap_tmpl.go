@@ -0,0 +1,62 @@
+package mage
+
+var bootstrapTpl = `// +build ignore
+
+// This file was generated by mage -bootstrap.  It lets you run this project's
+// magefiles without installing mage first:
+//
+//   go run bootstrap.go [options] [target]
+//
+// The first run installs the pinned version of mage into .mage/bin, later runs
+// reuse it.  Requires go 1.16 or later.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+)
+
+// mageVersion is the version of mage used to run the magefiles.  Change it to
+// upgrade mage for everyone working on the project.
+const mageVersion = "{{.Version}}"
+
+func main() {
+	dir, err := filepath.Abs(filepath.Join(".mage", "bin", mageVersion))
+	if err != nil {
+		fail(err)
+	}
+	exe := filepath.Join(dir, "mage")
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	if _, err := os.Stat(exe); err != nil {
+		fmt.Fprintln(os.Stderr, "Installing mage", mageVersion, "into", dir)
+		cmd := exec.Command("go", "install", "github.com/magefile/mage@"+mageVersion)
+		cmd.Env = append(os.Environ(), "GOBIN="+dir)
+		cmd.Stdout = os.Stderr
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fail(err)
+		}
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if exit, ok := err.(*exec.ExitError); ok {
+			os.Exit(exit.ExitCode())
+		}
+		fail(err)
+	}
+}
+
+func fail(err error) {
+	fmt.Fprintln(os.Stderr, "Error:", err)
+	os.Exit(1)
+}
+`
@@ -0,0 +1,53 @@
+package mage
+
+import (
+	"fmt"
+	"io"
+)
+
+// completionShells are the shells mage -completion supports.
+var completionShells = map[string]string{
+	"powershell": powershellCompletion,
+}
+
+// writeCompletion writes the completion script for shell.
+func writeCompletion(w io.Writer, shell string) error {
+	script, ok := completionShells[shell]
+	if !ok {
+		return fmt.Errorf("unsupported shell %q, the supported shells are: powershell", shell)
+	}
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+// powershellCompletion registers completion of mage's flags and the targets
+// in the current directory, for mage and the shims.  The flags are read from
+// mage -h and the targets from mage -l, so they're always current.
+var powershellCompletion = `# PowerShell completion for mage, generated by mage -completion powershell.
+# Load it in your profile with:
+#
+#   mage -completion powershell | Out-String | Invoke-Expression
+Register-ArgumentCompleter -Native -CommandName mage, mage.exe, mage.cmd, mage.ps1 -ScriptBlock {
+    param($wordToComplete, $commandAst, $cursorPosition)
+    $mage = $commandAst.CommandElements[0].Extent.Text
+    if ($wordToComplete -like '-*') {
+        $names = & $mage -h 2>$null | ForEach-Object {
+            if ($_ -match '^\s+(-[\w-]+)') { $Matches[1] }
+        }
+    } else {
+        $inTargets = $false
+        $names = & $mage -l 2>$null | ForEach-Object {
+            if ($_ -match '^Targets') {
+                $inTargets = $true
+            } elseif ($inTargets -and $_ -match '^\s+([^\s*]+)') {
+                $Matches[1]
+            } elseif ($_ -notmatch '^\s') {
+                $inTargets = $false
+            }
+        }
+    }
+    $names | Where-Object { $_ -like "$wordToComplete*" } | Sort-Object -Unique | ForEach-Object {
+        [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+    }
+}
+`
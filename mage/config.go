@@ -0,0 +1,325 @@
+package mage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+// configFiles are the names of the project configuration file mage looks for
+// in the magefile directory, in order.
+var configFiles = []string{".mage.yaml", ".mage.yml"}
+
+// Config holds the project defaults set in .mage.yaml in the magefile
+// directory.  Command line flags take precedence over environment variables,
+// which take precedence over the config file.
+type Config struct {
+	Verbose      bool              // run with -v
+	Parallel     bool              // run with -p
+	Summary      bool              // run with -summary
+	CacheDir     string            // the directory to store compiled binaries in, relative to the magefile directory
+	Default      []string          // the targets to run when none are given
+	Env          map[string]string // variables to set, unless they're set in the environment or a .env file
+	Subdirs      []string          // glob patterns for directories whose magefiles are run as namespaces
+	Plugins      []string          // glob patterns for plugin executables whose targets are run as namespaces
+	RemoteFiles  []string          // glob patterns for the files to copy to the host when running with -host
+	ContainerEnv []string          // the variables to pass on from the environment when running with -in-container
+	Mage         string            // the version of mage required, either exact (v1.15.0) or a minimum (>=v1.15.0)
+	ExitCodes    ExitCodes         // the codes to exit with for each kind of failure
+	Telemetry    string            // the URL to post a summary of each run to, or the file to append it to
+	GoFlags      string            // flags for the go commands that compile the magefiles, e.g. -mod=vendor
+	GoWork       string            // the go.work file to compile the magefiles with, relative to the magefile directory, or off
+}
+
+// ReadConfig reads the project configuration file in dir.  If there isn't
+// one, it returns an empty Config.
+//
+// The file is a small subset of YAML: top level keys with a scalar value, a
+// list (either [a, b] or one "- item" per line), or for env, a map of
+// indented "KEY: value" lines.
+func ReadConfig(dir string) (Config, error) {
+	var cfg Config
+	for _, name := range configFiles {
+		path := filepath.Join(dir, name)
+		f, err := os.Open(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return cfg, err
+		}
+		defer f.Close()
+		return parseConfig(bufio.NewScanner(f), path)
+	}
+	return cfg, nil
+}
+
+// configLine is a line of a config file, without comments.
+type configLine struct {
+	num    int
+	indent int
+	text   string
+}
+
+func parseConfig(scanner *bufio.Scanner, path string) (Config, error) {
+	var cfg Config
+	var lines []configLine
+	for num := 1; scanner.Scan(); num++ {
+		raw := scanner.Text()
+		text := strings.TrimSpace(stripComment(raw))
+		if text == "" || text == "---" {
+			continue
+		}
+		indent := len(raw) - len(strings.TrimLeft(raw, " \t"))
+		lines = append(lines, configLine{num, indent, text})
+	}
+	if err := scanner.Err(); err != nil {
+		return cfg, err
+	}
+	errorf := func(l configLine, format string, args ...interface{}) error {
+		return fmt.Errorf("%s:%d: %s", path, l.num, fmt.Sprintf(format, args...))
+	}
+
+	for i := 0; i < len(lines); i++ {
+		l := lines[i]
+		if l.indent > 0 {
+			return cfg, errorf(l, "unexpected indentation")
+		}
+		key, val, ok := splitKey(l.text)
+		if !ok {
+			return cfg, errorf(l, "expected key: value")
+		}
+		// the lines indented under this key, for lists and maps.
+		var nested []configLine
+		for i+1 < len(lines) && lines[i+1].indent > 0 {
+			i++
+			nested = append(nested, lines[i])
+		}
+		if val != "" && len(nested) > 0 {
+			return cfg, errorf(nested[0], "unexpected indentation")
+		}
+
+		list := func() ([]string, error) {
+			if val != "" {
+				if !strings.HasPrefix(val, "[") || !strings.HasSuffix(val, "]") {
+					return []string{unquote(val)}, nil
+				}
+				var items []string
+				for _, s := range strings.Split(val[1:len(val)-1], ",") {
+					if s = unquote(strings.TrimSpace(s)); s != "" {
+						items = append(items, s)
+					}
+				}
+				return items, nil
+			}
+			var items []string
+			for _, n := range nested {
+				if !strings.HasPrefix(n.text, "- ") && n.text != "-" {
+					return nil, errorf(n, "expected a list item for %s", key)
+				}
+				items = append(items, unquote(strings.TrimSpace(strings.TrimPrefix(n.text, "-"))))
+			}
+			return items, nil
+		}
+		boolean := func() (bool, error) {
+			b, err := strconv.ParseBool(unquote(val))
+			if err != nil {
+				return false, errorf(l, "%s must be true or false, not %q", key, val)
+			}
+			return b, nil
+		}
+
+		var err error
+		switch key {
+		case "verbose":
+			cfg.Verbose, err = boolean()
+		case "parallel":
+			cfg.Parallel, err = boolean()
+		case "summary":
+			cfg.Summary, err = boolean()
+		case "cacheDir":
+			cfg.CacheDir = unquote(val)
+		case "telemetry":
+			cfg.Telemetry = unquote(val)
+		case "goflags":
+			cfg.GoFlags = unquote(val)
+		case "gowork":
+			cfg.GoWork = unquote(val)
+		case "default":
+			cfg.Default, err = list()
+		case "subdirs":
+			cfg.Subdirs, err = list()
+		case "plugins":
+			cfg.Plugins, err = list()
+		case "remoteFiles":
+			cfg.RemoteFiles, err = list()
+		case "containerEnv":
+			cfg.ContainerEnv, err = list()
+		case "mage":
+			cfg.Mage = unquote(val)
+			if _, verr := parseVersionReq(cfg.Mage); verr != nil {
+				err = errorf(l, "%v", verr)
+			}
+		case "exitCodes":
+			if val != "" {
+				return cfg, errorf(l, "exitCodes must be a map of codes, one per line")
+			}
+			for _, n := range nested {
+				k, v, ok := splitKey(n.text)
+				if !ok {
+					return cfg, errorf(n, "expected name: code")
+				}
+				p := cfg.ExitCodes.code(k)
+				if p == nil {
+					return cfg, errorf(n, "unknown exit code %q, expected one of: %s", k, strings.Join(exitCodeNames, ", "))
+				}
+				code, err := strconv.Atoi(unquote(v))
+				if err != nil || code < 1 || code > 255 {
+					return cfg, errorf(n, "exit code %s must be a number from 1 to 255, not %q", k, v)
+				}
+				*p = code
+			}
+		case "env":
+			if val != "" {
+				return cfg, errorf(l, "env must be a map of variables, one per line")
+			}
+			cfg.Env = map[string]string{}
+			for _, n := range nested {
+				k, v, ok := splitKey(n.text)
+				if !ok {
+					return cfg, errorf(n, "expected KEY: value")
+				}
+				cfg.Env[k] = unquote(v)
+			}
+		default:
+			return cfg, errorf(l, "unknown setting %q", key)
+		}
+		if err != nil {
+			return cfg, err
+		}
+	}
+	return cfg, nil
+}
+
+// splitKey splits "key: value" into its key and value.
+func splitKey(s string) (key, val string, ok bool) {
+	i := strings.Index(s, ":")
+	if i <= 0 {
+		return "", "", false
+	}
+	if i+1 < len(s) && s[i+1] != ' ' && s[i+1] != '\t' {
+		return "", "", false
+	}
+	return strings.TrimSpace(s[:i]), strings.TrimSpace(s[i+1:]), true
+}
+
+// stripComment removes a # comment from the end of a line, unless it's in
+// quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' ' || s[i-1] == '\t'):
+			return s[:i]
+		}
+	}
+	return s
+}
+
+// unquote removes the quotes around a scalar value, if any.
+func unquote(s string) string {
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') && s[len(s)-1] == s[0] {
+		if s[0] == '"' {
+			if u, err := strconv.Unquote(s); err == nil {
+				return u
+			}
+		}
+		return s[1 : len(s)-1]
+	}
+	return s
+}
+
+// applyConfig sets the defaults from the project configuration file on inv,
+// for any settings that weren't given on the command line or in the
+// environment.
+func applyConfig(inv *Invocation) error {
+	cfg, err := ReadConfig(inv.Dir)
+	if err != nil {
+		return err
+	}
+	if cfg.Verbose && !inv.flagSet("v") {
+		inv.Verbose = true
+	}
+	if cfg.Parallel && !inv.flagSet("p") {
+		inv.Parallel = true
+	}
+	if cfg.Summary && !inv.flagSet("summary") {
+		inv.Summary = true
+	}
+	// an Invocation that wasn't made by Parse only gets the cache dir if it
+	// didn't set its own.
+	if cfg.CacheDir != "" && !inv.flagSet("cache-dir") && os.Getenv(mg.CacheEnv) == "" &&
+		(inv.setFlags != nil || inv.CacheDir == "") {
+		inv.CacheDir = cfg.CacheDir
+		if !filepath.IsAbs(inv.CacheDir) {
+			inv.CacheDir = filepath.Join(inv.Dir, inv.CacheDir)
+		}
+	}
+	if len(inv.Args) == 0 && !inv.List && !inv.Help && inv.CompileOut == "" {
+		inv.Args = cfg.Default
+	}
+	inv.subdirs = cfg.Subdirs
+	// plugins given with -plugin or MAGEFILE_PLUGINS replace the config's,
+	// which are relative to the magefile directory.
+	if len(inv.Plugins) == 0 {
+		for _, pattern := range cfg.Plugins {
+			if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(inv.Dir, filepath.FromSlash(pattern))
+			}
+			inv.Plugins = append(inv.Plugins, pattern)
+		}
+	}
+	inv.remoteFiles = cfg.RemoteFiles
+	inv.containerEnv = cfg.ContainerEnv
+	inv.mageVersion = cfg.Mage
+	// set on the Invocation, including from the environment by Parse, they
+	// take precedence.
+	if inv.GoFlags == "" {
+		inv.GoFlags = cfg.GoFlags
+	}
+	if inv.GoWork == "" {
+		inv.GoWork = cfg.GoWork
+	}
+	// the environment takes precedence, e.g. to send it somewhere else in CI,
+	// or to turn it off with MAGEFILE_TELEMETRY=off.
+	if cfg.Telemetry != "" && os.Getenv(mg.TelemetryEnv) == "" {
+		inv.telemetry = cfg.Telemetry
+		if !isURL(inv.telemetry) && !filepath.IsAbs(inv.telemetry) {
+			inv.telemetry = filepath.Join(inv.Dir, inv.telemetry)
+		}
+	}
+	// codes set on the Invocation take precedence.
+	inv.ExitCodes.merge(cfg.ExitCodes)
+	keys := make([]string, 0, len(cfg.Env))
+	for k := range cfg.Env {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	inv.configEnv = nil
+	for _, k := range keys {
+		inv.configEnv = append(inv.configEnv, k+"="+cfg.Env[k])
+	}
+	return nil
+}
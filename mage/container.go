@@ -0,0 +1,115 @@
+package mage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// containerCacheDir is where the directory of the compiled magefile is
+// mounted in the container.
+const containerCacheDir = "/mage-cache"
+
+// containerCmd returns the command mage runs containers with, from
+// MAGEFILE_CONTAINER_CMD if it's set, e.g. podman.
+func containerCmd() []string {
+	if cmd := strings.Fields(os.Getenv(mg.ContainerCmdEnv)); len(cmd) > 0 {
+		return cmd
+	}
+	return []string{"docker"}
+}
+
+// repoRoot returns the root of the git repository dir is in, or dir itself if
+// it isn't in one.
+func repoRoot(dir string) string {
+	for d := dir; ; {
+		if fileExists(filepath.Join(d, ".git")) {
+			return d
+		}
+		parent := filepath.Dir(d)
+		if parent == d {
+			return dir
+		}
+		d = parent
+	}
+}
+
+// containerArgs returns the args to run the compiled magefile at exePath in
+// a container from inv.Container, with the repository mounted at the same path
+// and the given variables set.  Only their names are given, since the values
+// may be secrets from .env files, so the command must be run with them in its
+// environment.
+func containerArgs(inv Invocation, exePath string, vars []string) ([]string, error) {
+	dir, err := filepath.Abs(inv.Dir)
+	if err != nil {
+		return nil, err
+	}
+	workDir, err := filepath.Abs(inv.WorkDir)
+	if err != nil {
+		return nil, err
+	}
+	root := repoRoot(dir)
+	if rel, err := filepath.Rel(root, workDir); err != nil || strings.HasPrefix(rel, "..") {
+		return nil, fmt.Errorf("the working directory %s isn't in %s, which is mounted in the container", workDir, root)
+	}
+	args := []string{"run", "--rm", "-i",
+		"-v", root + ":" + root,
+		// the compiled binary is reused from the cache across runs.
+		"-v", filepath.Dir(exePath) + ":" + containerCacheDir + ":ro",
+		"-w", workDir,
+	}
+	for _, v := range vars {
+		args = append(args, "-e", strings.SplitN(v, "=", 2)[0])
+	}
+	// variables named without a value are passed on from the environment.
+	for _, name := range inv.containerEnv {
+		args = append(args, "-e", name)
+	}
+	args = append(args, inv.Container, containerCacheDir+"/"+filepath.Base(exePath))
+	return append(args, inv.Args...), nil
+}
+
+// invokeContainer compiles the magefiles for linux, and runs the targets in
+// a container from inv.Container.
+func invokeContainer(inv Invocation, errlog *log.Logger) int {
+	if runtime.GOOS == "windows" {
+		errlog.Println("Error: -in-container isn't supported on windows")
+		return 1
+	}
+	buildInv := inv
+	buildInv.GOOS = "linux"
+	buildInv.GOARCH = runtime.GOARCH
+	exePath, code := buildExe(buildInv, errlog)
+	if exePath == "" {
+		return code
+	}
+	vars, err := magefileEnv(inv, nil)
+	if err != nil {
+		errlog.Println("Error loading .env:", err)
+		return 1
+	}
+	args, err := containerArgs(inv, exePath, vars)
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	cmd := containerCmd()
+	debug.Println("running", strings.Join(append(cmd, args...), " "))
+	c := exec.Command(cmd[0], append(cmd[1:len(cmd):len(cmd)], args...)...)
+	c.Env = append(os.Environ(), vars...)
+	c.Stdin = inv.Stdin
+	c.Stdout = inv.Stdout
+	c.Stderr = inv.Stderr
+	err = c.Run()
+	if !sh.CmdRan(err) {
+		errlog.Printf("failed to run %s: %v", cmd[0], err)
+	}
+	return sh.ExitStatus(err)
+}
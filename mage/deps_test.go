@@ -0,0 +1,162 @@
+package mage
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDepsTree(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/deps",
+		Stdout: stdout,
+		Stderr: stderr,
+		Deps:   true,
+		Args:   []string{"b"},
+	}
+
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+build
+  generate
+    installTools()
+  test
+    generate (see above)
+    docker:image
+    other:ns:deploy2
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/deps",
+		Stdout: stdout,
+		Stderr: stderr,
+		DryRun: true,
+		Args:   []string{"docker:image", "b"},
+	}
+
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+docker:image
+installTools()
+generate
+    go generate ./...
+other:ns:deploy2
+test
+build
+    go build -o <out> .
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestGraph(t *testing.T) {
+	tests := []struct {
+		format   string
+		args     []string
+		expected string
+	}{
+		{
+			format: "tree",
+			expected: `
+build
+  generate
+    installTools()
+  test
+    generate (see above)
+    docker:image
+    other:ns:deploy2
+other:buildSubdir2
+`[1:],
+		},
+		{
+			format: "dot",
+			args:   []string{"test"},
+			expected: `
+digraph mage {
+	"test";
+	"generate";
+	"installTools()";
+	subgraph "cluster_docker" {
+		label = "docker";
+		"docker:image";
+	}
+	subgraph "cluster_other:ns" {
+		label = "other:ns";
+		"other:ns:deploy2";
+	}
+	"test" -> "generate";
+	"test" -> "docker:image";
+	"test" -> "other:ns:deploy2";
+	"generate" -> "installTools()";
+}
+`[1:],
+		},
+		{
+			format: "mermaid",
+			args:   []string{"b"},
+			expected: `
+graph TD
+    n0["build"]
+    n1["generate"]
+    n2["installTools()"]
+    n3["test"]
+    subgraph ns0 ["docker"]
+        n4["docker:image"]
+    end
+    subgraph ns1 ["other:ns"]
+        n5["other:ns:deploy2"]
+    end
+    n0 --> n1
+    n0 --> n3
+    n1 --> n2
+    n3 --> n1
+    n3 --> n4
+    n3 --> n5
+`[1:],
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			stdout := &bytes.Buffer{}
+			stderr := &bytes.Buffer{}
+			inv := Invocation{
+				Dir:         "./testdata/deps",
+				Stdout:      stdout,
+				Stderr:      stderr,
+				Graph:       true,
+				GraphFormat: tt.format,
+				Args:        tt.args,
+			}
+			if code := Invoke(inv); code != 0 {
+				t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+			}
+			if actual := stdout.String(); actual != tt.expected {
+				t.Fatalf("expected:\n%v\n\ngot:\n%v", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseGraphFormat(t *testing.T) {
+	_, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-graph", "-graph-format", "svg"})
+	expected := `unsupported graph format "svg", the supported formats are: dot, mermaid, tree`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+}
@@ -0,0 +1,68 @@
+package mage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ExitCodes are the codes mage exits with for each kind of failure, so CI
+// pipelines and wrapper scripts can tell a broken build from failing tests.
+// A zero code means the default.
+type ExitCodes struct {
+	UnknownTarget int // a target on the command line doesn't exist or has invalid args (default 2)
+	CompileError  int // the magefiles couldn't be parsed or compiled (default 1)
+	TargetFailure int // a target failed, unless its error has an exit status of its own, like a failed command (default 1)
+	Timeout       int // the run or a target timed out (default 1)
+	Interrupted   int // the run was interrupted with Ctrl+C or SIGTERM (default 130)
+}
+
+// exitCodeNames are the names of the exit codes in the config file and in
+// MAGEFILE_EXIT_CODES.
+var exitCodeNames = []string{"unknownTarget", "compileError", "targetFailure", "timeout", "interrupted"}
+
+// code returns a pointer to the named code.
+func (c *ExitCodes) code(name string) *int {
+	switch name {
+	case "unknownTarget":
+		return &c.UnknownTarget
+	case "compileError":
+		return &c.CompileError
+	case "targetFailure":
+		return &c.TargetFailure
+	case "timeout":
+		return &c.Timeout
+	case "interrupted":
+		return &c.Interrupted
+	}
+	return nil
+}
+
+// compileError returns the code to exit with when the magefiles don't
+// compile.
+func (c ExitCodes) compileError() int {
+	if c.CompileError != 0 {
+		return c.CompileError
+	}
+	return 1
+}
+
+// merge sets the codes that aren't set in c from other.
+func (c *ExitCodes) merge(other ExitCodes) {
+	for _, name := range exitCodeNames {
+		if p := c.code(name); *p == 0 {
+			*p = *other.code(name)
+		}
+	}
+}
+
+// env returns the codes the compiled binary exits with, as name=code pairs
+// for MAGEFILE_EXIT_CODES.
+func (c ExitCodes) env() string {
+	var pairs []string
+	for _, name := range exitCodeNames {
+		if p := c.code(name); *p != 0 && name != "compileError" {
+			pairs = append(pairs, fmt.Sprintf("%s=%d", name, *p))
+		}
+	}
+	return strings.Join(pairs, ",")
+}
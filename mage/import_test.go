@@ -214,3 +214,111 @@ Error parsing magefiles: error running "go list -f {{.Dir}}||{{.Name}} github.co
 		t.Fatalf("expected:\n%s\n\ngot:\n%s", expected, actualShortened)
 	}
 }
+
+func TestMageImportsOtherModule(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/extimport",
+		Stdout: stdout,
+		Stderr: stderr,
+		List:   true,
+	}
+
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	actual := stdout.String()
+	expected := `
+Targets:
+  build      
+  ci:lint    Runs the linters.
+
+Imported from other modules:
+  example.com/targets/ci@v1.2.0 (as ci)
+`[1:]
+
+	if actual != expected {
+		t.Logf("expected: %q", expected)
+		t.Logf("  actual: %q", actual)
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = false
+	inv.Args = []string{"ci:lint"}
+	code = Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual := stdout.String(); actual != "linting\n" {
+		t.Fatalf("expected %q but got %q", "linting\n", actual)
+	}
+}
+
+func TestMageImportsPinMismatch(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/extimport/badpin",
+		Stdout: &bytes.Buffer{},
+		Stderr: stderr,
+		List:   true,
+	}
+
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `Error parsing magefiles: mage:import for example.com/targets/ci is pinned to version v1.0.0, but go.mod requires example.com/targets@v1.2.0.  Run "go get example.com/targets@v1.0.0" to update it` + "\n"
+	if actual := stderr.String(); actual != expected {
+		t.Fatalf("expected %q but got %q", expected, actual)
+	}
+}
+
+func TestMageImportsSelective(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/mageimport/selective",
+		Stdout: stdout,
+		Stderr: stderr,
+		List:   true,
+	}
+
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	actual := stdout.String()
+	expected := `
+Targets:
+  ns:deploy            deploys stuff.
+  sub2:buildSubdir2    Builds stuff.
+`[1:]
+
+	if actual != expected {
+		t.Logf("expected: %q", expected)
+		t.Logf("  actual: %q", actual)
+		t.Fatalf("expected:\n%v\n\ngot:\n%v", expected, actual)
+	}
+}
+
+func TestMageImportsSelectUnknown(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/mageimport/badselect",
+		Stdout: &bytes.Buffer{},
+		Stderr: stderr,
+		List:   true,
+	}
+
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := "Error parsing magefiles: mage:import for github.com/magefile/mage/mage/testdata/mageimport/subdir1 selects unknown target(s): Missing\n"
+	if actual := stderr.String(); actual != expected {
+		t.Fatalf("expected %q but got %q", expected, actual)
+	}
+}
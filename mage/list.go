@@ -0,0 +1,153 @@
+package mage
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/parse"
+)
+
+// canListParsed reports whether mage can list the targets from the parsed
+// magefiles, rather than compiling them and running the binary with -l.
+func canListParsed(inv Invocation) bool {
+	// the kept mainfile or compiled binary is the point of these flags.
+	return !inv.Keep && !inv.KeepDebug && inv.CompileOut == ""
+}
+
+// noColorTerms are the terminals the compiled binary doesn't list targets in
+// color for.
+var noColorTerms = map[string]bool{
+	"vt100":      true,
+	"cygwin":     true,
+	"xterm-mono": true,
+}
+
+// listParsed writes the list of targets in info to w, the same way the
+// compiled binary does for -l.  Listing doesn't need the magefiles to compile, which
+// makes it fast when there's no cached binary, e.g. for shell completion.
+func listParsed(w io.Writer, info *parse.PkgInfo) error {
+	if info.Description != "" {
+		fmt.Fprintln(w, info.Description+"\n")
+	}
+	defaults := defaultTargets(info)
+	targets := map[string]string{}
+	// aliases are only listed for the targets in the magefiles themselves.
+	addTargets := func(funcs []*parse.Function, withAliases bool) {
+		for _, f := range funcs {
+			if f.IsHidden {
+				continue
+			}
+			name := listName(f.TargetName())
+			if isDefaultTarget(f, defaults) {
+				name += "*"
+			}
+			targets[name] = synopsis(f)
+			if withAliases {
+				for _, alias := range f.Aliases {
+					targets[alias] = synopsis(f)
+				}
+			}
+		}
+	}
+	addTargets(info.Funcs, true)
+	for _, imp := range info.Imports {
+		addTargets(imp.Info.Funcs, false)
+	}
+
+	keys := make([]string, 0, len(targets))
+	for name := range targets {
+		keys = append(keys, name)
+	}
+	sort.Strings(keys)
+
+	color := mg.EnableColor() && !noColorTerms[os.Getenv("TERM")] && os.Getenv("NO_COLOR") == ""
+	targetColor := mg.TargetColor()
+
+	fmt.Fprintln(w, "Targets:")
+	tw := tabwriter.NewWriter(w, 0, 4, 4, ' ', 0)
+	for _, name := range keys {
+		printed := name
+		if color {
+			printed = targetColor + name + mg.AnsiColorReset
+		}
+		fmt.Fprintf(tw, "  %v\t%v\n", printed, targets[name])
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if len(defaults) > 0 {
+		s := ""
+		if len(defaults) > 1 {
+			s = "s"
+		}
+		fmt.Fprintf(w, "\n* default target%s\n", s)
+	}
+	if imports := externalImports(info); len(imports) > 0 {
+		fmt.Fprintln(w, "\nImported from other modules:")
+		for _, imp := range imports {
+			line := "  " + imp.Origin()
+			if imp.Alias != "" {
+				line += " (as " + imp.Alias + ")"
+			}
+			fmt.Fprintln(w, line)
+		}
+	}
+	return nil
+}
+
+// listName is the name of a target as it's listed, with the first word of
+// each part lowercased.
+func listName(name string) string {
+	parts := strings.Split(name, ":")
+	for i, t := range parts {
+		parts[i] = lowerFirstWord(t)
+	}
+	return strings.Join(parts, ":")
+}
+
+// synopsis is the description of a target shown by -l.
+func synopsis(f *parse.Function) string {
+	if f.IsDeprecated {
+		return strings.TrimSpace("[deprecated] " + f.Synopsis)
+	}
+	return f.Synopsis
+}
+
+// defaultTargets returns the targets that run when none are given, if the
+// magefile declares them statically.
+func defaultTargets(info *parse.PkgInfo) []*parse.Function {
+	if info.DefaultFunc != nil {
+		return []*parse.Function{info.DefaultFunc}
+	}
+	return info.Defaults
+}
+
+// isDefaultTarget reports whether f is one of the defaults.
+func isDefaultTarget(f *parse.Function, defaults []*parse.Function) bool {
+	for _, d := range defaults {
+		if d == f {
+			return true
+		}
+	}
+	return false
+}
+
+// externalImports returns the mage:imports from other modules, sorted by
+// path.
+func externalImports(info *parse.PkgInfo) []*parse.Import {
+	var imports []*parse.Import
+	for _, imp := range info.Imports {
+		if imp.Module != "" {
+			imports = append(imports, imp)
+		}
+	}
+	sort.Slice(imports, func(i, j int) bool {
+		return imports[i].Path < imports[j].Path
+	})
+	return imports
+}
@@ -6,20 +6,25 @@ import (
 	"errors"
 	"flag"
 	"fmt"
+	"go/format"
 	"io"
 	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
 
 	"github.com/magefile/mage/internal"
+	"github.com/magefile/mage/lock"
 	"github.com/magefile/mage/mg"
 	"github.com/magefile/mage/parse"
 	"github.com/magefile/mage/sh"
@@ -29,7 +34,7 @@ import (
 // a new binary even if nothing in the input files or generated mainfile has
 // changed. This can be used when we change how we parse files, or otherwise
 // change the inputs to the compiling process.
-const magicRebuildKey = "v0.3"
+const magicRebuildKey = "v0.4"
 
 // (Aaaa)(Bbbb) -> aaaaBbbb
 var firstWordRx = regexp.MustCompile(`^([[:upper:]][^[:upper:]]+)([[:upper:]].*)$`)
@@ -48,19 +53,21 @@ func lowerFirstWord(s string) string {
 }
 
 var mainfileTemplate = template.Must(template.New("").Funcs(map[string]interface{}{
-	"lower": strings.ToLower,
-	"lowerFirst": func(s string) string {
-		parts := strings.Split(s, ":")
-		for i, t := range parts {
-			parts[i] = lowerFirstWord(t)
-		}
-		return strings.Join(parts, ":")
-	},
+	"lower":      strings.ToLower,
+	"lowerFirst": listName,
+	"isDefault":  isDefaultTarget,
+	"synopsis":   synopsis,
 }).Parse(mageMainfileTplString))
 var initOutput = template.Must(template.New("").Parse(mageTpl))
+var bootstrapOutput = template.Must(template.New("").Parse(bootstrapTpl))
+var shimCmdOutput = template.Must(template.New("").Parse(shimCmdTpl))
+var shimPs1Output = template.Must(template.New("").Parse(shimPs1Tpl))
 
 const mainfile = "mage_output_file.go"
 const initFile = "magefile.go"
+const bootstrapFile = "bootstrap.go"
+const shimCmdFile = "mage.cmd"
+const shimPs1File = "mage.ps1"
 
 var debug = log.New(ioutil.Discard, "DEBUG: ", log.Ltime|log.Lmicroseconds)
 
@@ -83,6 +90,11 @@ const (
 	Init                  // create a starting template for mage
 	Clean                 // clean out old compiled mage binaries from the cache
 	CompileStatic         // compile a static binary of the current directory
+	Bootstrap             // create a go run file that installs and runs mage
+	Ensure                // install the version of mage the project requires
+	Serve                 // keep the compiled magefile up to date for other invocations
+	Shim                  // create mage.cmd and mage.ps1 shims that install and run mage
+	Completion            // print a shell completion script
 )
 
 // Main is the entrypoint for running mage.  It exists external to mage's main
@@ -94,25 +106,83 @@ func Main() int {
 
 // Invocation contains the args for invoking a run of Mage.
 type Invocation struct {
-	Debug      bool          // turn on debug messages
-	Dir        string        // directory to read magefiles from
-	WorkDir    string        // directory where magefiles will run
-	Force      bool          // forces recreation of the compiled binary
-	Verbose    bool          // tells the magefile to print out log statements
-	List       bool          // tells the magefile to print out a list of targets
-	Help       bool          // tells the magefile to print out help for a specific target
-	Keep       bool          // tells mage to keep the generated main file after compiling
-	Timeout    time.Duration // tells mage to set a timeout to running the targets
-	CompileOut string        // tells mage to compile a static binary to this path, but not execute
-	GOOS       string        // sets the GOOS when producing a binary with -compileout
-	GOARCH     string        // sets the GOARCH when producing a binary with -compileout
-	Stdout     io.Writer     // writer to write stdout messages to
-	Stderr     io.Writer     // writer to write stderr messages to
-	Stdin      io.Reader     // reader to read stdin from
-	Args       []string      // args to pass to the compiled binary
-	GoCmd      string        // the go binary command to run
-	CacheDir   string        // the directory where we should store compiled binaries
-	HashFast   bool          // don't rely on GOCACHE, just hash the magefiles
+	Debug         bool          // turn on debug messages
+	Dir           string        // directory to read magefiles from
+	WorkDir       string        // directory where magefiles will run
+	Force         bool          // forces recreation of the compiled binary
+	Verbose       bool          // tells the magefile to print out log statements
+	List          bool          // tells the magefile to print out a list of targets
+	Help          bool          // tells the magefile to print out help for a specific target
+	Deps          bool          // tells the magefile to print out the dependency tree of a specific target
+	Graph         bool          // tells the magefile to print out the dependency graph of a target, or of all of them
+	GraphFormat   string        // the format of the graph: dot, mermaid or tree
+	DryRun        bool          // tells the magefile to print out what would run, without running anything
+	CPUProfile    string        // tells the magefile to write a cpu profile of the run to this file
+	MemProfile    string        // tells the magefile to write a memory profile of the run to this file
+	Trace         string        // tells the magefile to write an execution trace of the run to this file
+	Report        string        // tells the magefile to write a report of the targets it ran, as format=file, e.g. junit=report.xml
+	Events        string        // tells the magefile to write events for the run to this file, as lines of JSON
+	Timestamps    bool          // tells the magefile to prefix output with a timestamp and the running targets
+	Status        string        // "true" or "false" tells the magefile whether to print the status of each target, "" leaves it up to the magefile
+	Summary       bool          // tells the magefile to print a summary of the targets run when it's done
+	OnlyTags      []string      // tells the magefile to only run the targets with one of these tags
+	SkipTags      []string      // tells the magefile not to run the targets with any of these tags
+	Yes           bool          // tells the magefile to run the targets matched by a pattern without asking
+	Lock          string        // tells mage to lock the checkout while the targets run, and whether to "wait" for or "fail" on another run's lock
+	Keep          bool          // tells mage to keep the generated main file after compiling
+	KeepDebug     bool          // tells mage to keep a debuggable main file, and compile without optimizations
+	Parallel      bool          // tells the magefile to run the targets concurrently
+	Jobs          int           // tells the magefile how many targets, dependencies and commands may run at once, 0 for no limit
+	Timeout       time.Duration // tells mage to set a timeout to running the targets
+	TargetTimeout time.Duration // tells mage to set a timeout to running each target
+	CompileOut    string        // tells mage to compile a static binary to this path, but not execute
+	GOOS          string        // sets the GOOS when producing a binary with -compileout
+	GOARCH        string        // sets the GOARCH when producing a binary with -compileout
+	Platforms     []string      // GOOS/GOARCH pairs to produce suffixed binaries for with -compileout
+	Stdout        io.Writer     // writer to write stdout messages to
+	Stderr        io.Writer     // writer to write stderr messages to
+	Stdin         io.Reader     // reader to read stdin from
+	Args          []string      // args to pass to the compiled binary
+	GoCmd         string        // the go binary command to run
+	GoFlags       string        // flags for the go commands that compile the magefiles, added after GOFLAGS
+	GoWork        string        // the go.work file to compile the magefiles with, relative to Dir, or "off"
+	CacheDir      string        // the directory where we should store compiled binaries
+	HashFast      bool          // only hash the magefiles, not their dependencies, to decide whether to rebuild
+	NoDotenv      bool          // don't load variables from .env and .env.local in the magefile directory
+	CleanEnv      bool          // run the magefile with only the variables in KeepEnv from the environment
+	KeepEnv       []string      // the variables to keep from the environment, with CleanEnv
+	Env           []string      // KEY=VALUE variables to add to the magefile's environment, over those it would get
+	ExitCodes     ExitCodes     // the codes to exit with for each kind of failure, in place of the defaults
+	Host          string        // run the targets on this host over ssh, e.g. user@buildbox
+	Container     string        // run the targets in a container from this image
+	Plugins       []string      // glob patterns for plugin executables whose targets are run as namespaces
+
+	setFlags     map[string]bool // the flags given on the command line, if this was made by Parse
+	configEnv    []string        // KEY=VALUE variables from the project config file
+	subdirs      []string        // patterns for subdirectories whose magefiles are run as namespaces
+	mageVersion  string          // the version of mage the project config requires
+	serve        bool            // run mage -serve instead of the targets
+	remoteFiles  []string        // patterns for the files to copy to the host, with Host
+	containerEnv []string        // the variables to pass on to the container, with Container
+	telemetry    string          // the URL or file to send a summary of the run to, from the project config
+	shell        string          // the shell to print the completion script for, with -completion
+}
+
+// stringsFlag is a flag that may be given more than once.
+type stringsFlag []string
+
+func (s *stringsFlag) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringsFlag) Set(val string) error {
+	*s = append(*s, val)
+	return nil
+}
+
+// flagSet reports whether the named flag was given on the command line.
+func (inv Invocation) flagSet(name string) bool {
+	return inv.setFlags[name]
 }
 
 // ParseAndRun parses the command line, and then compiles and runs the mage
@@ -134,7 +204,11 @@ func ParseAndRun(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 
 	switch cmd {
 	case Version:
-		out.Println("Mage Build Tool", gitTag)
+		version := currentVersion()
+		if version == "" {
+			version = gitTag
+		}
+		out.Println("Mage Build Tool", version)
 		out.Println("Build Date:", timestamp)
 		out.Println("Commit:", commitHash)
 		out.Println("built with:", runtime.Version())
@@ -146,7 +220,34 @@ func ParseAndRun(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 		}
 		out.Println(initFile, "created")
 		return 0
+	case Bootstrap:
+		if err := generateBootstrap(inv.Dir); err != nil {
+			errlog.Println("Error:", err)
+			return 1
+		}
+		out.Println(bootstrapFile, "created, add .mage/ to your .gitignore")
+		return 0
+	case Ensure:
+		inv.Stdout = stdout
+		return ensureVersion(inv)
+	case Shim:
+		if err := generateShim(inv.Dir); err != nil {
+			errlog.Println("Error:", err)
+			return 1
+		}
+		out.Println(shimCmdFile, "and", shimPs1File, "created, add .mage/ to your .gitignore")
+		return 0
+	case Completion:
+		if err := writeCompletion(stdout, inv.shell); err != nil {
+			errlog.Println("Error:", err)
+			return 1
+		}
+		return 0
 	case Clean:
+		if err := applyConfig(&inv); err != nil {
+			errlog.Println("Error reading config:", err)
+			return 1
+		}
 		if err := removeContents(inv.CacheDir); err != nil {
 			out.Println("Error:", err)
 			return 1
@@ -155,6 +256,9 @@ func ParseAndRun(stdout, stderr io.Writer, stdin io.Reader, args []string) int {
 		return 0
 	case CompileStatic:
 		return Invoke(inv)
+	case Serve:
+		inv.serve = true
+		return Invoke(inv)
 	case None:
 		return Invoke(inv)
 	default:
@@ -175,13 +279,45 @@ func Parse(stderr, stdout io.Writer, args []string) (inv Invocation, cmd Command
 	fs.BoolVar(&inv.Debug, "debug", mg.Debug(), "turn on debug messages")
 	fs.BoolVar(&inv.Verbose, "v", mg.Verbose(), "show verbose output when running mage targets")
 	fs.BoolVar(&inv.Help, "h", false, "show this help")
+	fs.BoolVar(&inv.Deps, "deps", false, "show the dependency tree of a target")
+	fs.BoolVar(&inv.Graph, "graph", false, "show the dependency graph of a target, or of all targets")
+	fs.StringVar(&inv.GraphFormat, "graph-format", "tree", "the format of the graph shown with -graph: dot, mermaid or tree")
+	fs.StringVar(&inv.CPUProfile, "cpuprofile", "", "write a cpu profile of the magefile run to this file")
+	fs.StringVar(&inv.MemProfile, "memprofile", "", "write a memory profile of the magefile run to this file")
+	fs.StringVar(&inv.Trace, "trace", "", "write an execution trace of the magefile run to this file")
+	fs.StringVar(&inv.Report, "report", "", "write a report of the targets run, as format=file, e.g. junit=report.xml")
+	fs.StringVar(&inv.Events, "events", "", "write events for the run to this file, as lines of JSON")
+	fs.BoolVar(&inv.Timestamps, "timestamps", false, "prefix each line of output with a timestamp and the running targets")
+	var status bool
+	fs.BoolVar(&status, "status", false, "print the status of each target as it starts and finishes")
+	fs.BoolVar(&inv.Summary, "summary", false, "print a summary of the targets run when they're done")
+	var onlyTags, skipTags string
+	fs.StringVar(&onlyTags, "only-tags", "", "comma separated tags, only run the targets with one of them")
+	fs.StringVar(&skipTags, "skip-tags", "", "comma separated tags, don't run the targets with any of them")
+	fs.BoolVar(&inv.Yes, "yes", false, "run the targets matched by a pattern without asking")
+	fs.StringVar(&inv.Lock, "lock", os.Getenv(mg.LockEnv), "lock the checkout while the targets run, and wait for or fail on another run's lock: wait or fail")
+	fs.BoolVar(&inv.DryRun, "n", false, "print the targets and commands that would run, without running them")
 	fs.DurationVar(&inv.Timeout, "t", 0, "timeout in duration parsable format (e.g. 5m30s)")
+	fs.DurationVar(&inv.TargetTimeout, "timeout-per-target", 0, "timeout for each target in duration parsable format (e.g. 5m30s)")
 	fs.BoolVar(&inv.Keep, "keep", false, "keep intermediate mage files around after running")
+	fs.BoolVar(&inv.KeepDebug, "keep-debug", false, "keep a debuggable mainfile and compile without optimizations")
+	fs.BoolVar(&inv.NoDotenv, "no-dotenv", mg.NoDotenv(), "don't load variables from .env and .env.local in the magefile directory")
+	var cleanEnv string
+	fs.StringVar(&cleanEnv, "clean-env", "", "run the targets with only the given variables from the environment, e.g. KEEP=PATH,HOME")
+	fs.BoolVar(&inv.Parallel, "p", false, "run the given targets in parallel")
+	fs.IntVar(&inv.Jobs, "j", 0, "how many targets, dependencies and commands may run at once")
 	fs.StringVar(&inv.Dir, "d", ".", "directory to read magefiles from")
+	fs.StringVar(&inv.Host, "host", "", "run the targets on this host over ssh, e.g. user@buildbox")
+	fs.StringVar(&inv.Container, "in-container", "", "run the targets in a container from this image")
+	var plugins stringsFlag
+	fs.Var(&plugins, "plugin", "load targets from the plugin executables matching this glob pattern, may be repeated")
 	fs.StringVar(&inv.WorkDir, "w", "", "working directory where magefiles will run")
 	fs.StringVar(&inv.GoCmd, "gocmd", mg.GoCmd(), "use the given go binary to compile the output")
 	fs.StringVar(&inv.GOOS, "goos", "", "set GOOS for binary produced with -compile")
 	fs.StringVar(&inv.GOARCH, "goarch", "", "set GOARCH for binary produced with -compile")
+	var platforms string
+	fs.StringVar(&platforms, "compile-targets", "", "comma separated GOOS/GOARCH pairs to build binaries for with -compile")
+	fs.StringVar(&inv.CacheDir, "cache-dir", mg.CacheDir(), "directory to store compiled magefile binaries in")
 
 	// commands below
 
@@ -190,6 +326,16 @@ func Parse(stderr, stdout io.Writer, args []string) (inv Invocation, cmd Command
 	fs.BoolVar(&showVersion, "version", false, "show version info for the mage binary")
 	var mageInit bool
 	fs.BoolVar(&mageInit, "init", false, "create a starting template if no mage files exist")
+	var bootstrap bool
+	fs.BoolVar(&bootstrap, "bootstrap", false, "create a bootstrap.go that runs this version of mage with go run")
+	var ensure bool
+	fs.BoolVar(&ensure, "ensure", false, "install the version of mage the project requires")
+	var shim bool
+	fs.BoolVar(&shim, "shim", false, "create mage.cmd and mage.ps1 shims that install and run this version of mage")
+	var completion string
+	fs.StringVar(&completion, "completion", "", "print the completion script for the given shell")
+	var serve bool
+	fs.BoolVar(&serve, "serve", false, "keep the compiled magefile up to date, so mage runs it without checking")
 	var clean bool
 	fs.BoolVar(&clean, "clean", false, "clean out old generated binaries from CACHE_DIR")
 	var compileOutPath string
@@ -202,33 +348,121 @@ mage [options] [target]
 Mage is a make-like command runner.  See https://magefile.org for full docs.
 
 Commands:
+  -bootstrap
+            create a bootstrap.go that runs this version of mage with go run
   -clean    clean out old generated binaries from CACHE_DIR
+  -completion <shell>
+            print the completion script for the shell, the supported shells
+            are: powershell
   -compile <string>
             output a static binary to the given path
+  -ensure   install the version of mage the project config requires, in
+            place of this one
   -h        show this help
   -init     create a starting template if no mage files exist
   -l        list mage targets in this directory
+  -shim     create mage.cmd and mage.ps1, which install and run this version
+            of mage on windows, like bootstrap.go
+  -serve    keep running, and keep the binary compiled from the magefiles up
+            to date, so other runs of mage in this directory start instantly
   -version  show version info for the mage binary
 
 Options:
+  -cache-dir <string>
+            directory to store compiled magefile binaries in (default $MAGEFILE_CACHE or ~/.magefile)
+  -clean-env KEEP=<vars>
+            run the targets without the variables in the environment, except
+            for the comma separated list of variables to keep and mage's own
+            MAGEFILE variables (e.g. -clean-env KEEP=PATH,HOME,GOPATH)
+  -compile-targets <string>
+            comma separated list of GOOS/GOARCH pairs to build binaries for
+            with -compile (e.g. linux/amd64,darwin/arm64)
+  -cpuprofile <string>
+            write a cpu profile of the magefile run to this file
   -d <string> 
             directory to read magefiles from (default ".")
   -debug    turn on debug messages
+  -deps     show the dependency tree of a target
+  -events <string>
+            write events for the run to this file, as lines of JSON, e.g. for
+            the start and end of each target and each command run
   -f        force recreation of compiled magefile
   -goarch   sets the GOARCH for the binary created by -compile (default: current arch)
   -gocmd <string>
 		    use the given go binary to compile the output (default: "go")
   -goos     sets the GOOS for the binary created by -compile (default: current OS)
+  -graph    show the dependency graph of a target, or of all targets if
+            none is given, including namespaces and imported targets
+  -graph-format <format>
+            the format of the graph shown with -graph, one of: dot, mermaid,
+            tree (default tree)
   -h        show description of a target
+  -host <user@host>
+            compile the magefiles for the host, copy the binary and the
+            remoteFiles from the project config there over ssh, and run the
+            targets on it
+  -in-container <image>
+            compile the magefiles for linux, and run the targets in a
+            container from the image, with the repository mounted
+  -j <int>  run at most this many targets, dependencies and commands with
+            the sh package at once (default no limit)
   -keep     keep intermediate mage files around after running
+  -keep-debug
+            keep the generated mainfile, formatted and with //line
+            directives to the magefiles, and compile without optimizations
+            so debuggers can set breakpoints in targets
+  -lock <wait|fail>
+            lock the checkout while the targets run, so another run in it
+            waits for them to finish, or fails saying who has the lock
+  -memprofile <string>
+            write a memory profile of the magefile run to this file
+  -n        print the targets and commands that would run, without running them
+  -no-dotenv
+            don't load variables from .env and .env.local in the magefile
+            directory
+  -only-tags <string>
+            comma separated list of tags, only run the targets with one of
+            them and list only those targets (e.g. release,docs)
+  -p        run the given targets in parallel
+  -plugin <pattern>
+            run the targets of the plugin executables matching the glob
+            pattern as namespaces named after them, may be repeated (quote
+            the pattern so the shell doesn't expand it)
+  -report <format=file>
+            write a report of the targets run to a file, the supported
+            formats are: junit (e.g. -report junit=report.xml)
+  -skip-tags <string>
+            comma separated list of tags, don't run or list the targets with
+            any of them (e.g. slow)
+  -status   print the status of each target as it starts and finishes, and
+            prefix the output of targets run with -p (default when stderr is a
+            terminal, use -status=false to turn it off)
+  -summary  print a table of the targets run, with the status and duration of
+            each, when they're done
   -t <string>
             timeout in duration parsable format (e.g. 5m30s)
+  -timeout-per-target <string>
+            timeout for each target in duration parsable format (e.g. 5m30s)
+  -timestamps
+            prefix each line of output with a timestamp and the running targets
+  -trace <string>
+            write an execution trace of the magefile run to this file
   -v        show verbose output when running mage targets
   -w <string>
             working directory where magefiles will run (default -d value)
+  -yes      run the targets matched by a pattern like docker:* or test...
+            without asking first
 `[1:])
 	}
 	err = fs.Parse(args)
+	// the magefile decides whether to show the status when it isn't set.
+	inv.setFlags = map[string]bool{}
+	fs.Visit(func(f *flag.Flag) {
+		inv.setFlags[f.Name] = true
+		if f.Name == "status" {
+			inv.Status = strconv.FormatBool(status)
+		}
+	})
 	if err == flag.ErrHelp {
 		// parse will have already called fs.Usage()
 		return inv, cmd, err
@@ -252,12 +486,31 @@ Options:
 	case showVersion:
 		numCommands++
 		cmd = Version
+	case bootstrap:
+		numCommands++
+		cmd = Bootstrap
+	case ensure:
+		numCommands++
+		cmd = Ensure
+	case serve:
+		numCommands++
+		cmd = Serve
+	case shim:
+		numCommands++
+		cmd = Shim
+	case completion != "":
+		numCommands++
+		cmd = Completion
+		if _, ok := completionShells[completion]; !ok {
+			return inv, cmd, fmt.Errorf("unsupported shell %q, the supported shells are: powershell", completion)
+		}
+		inv.shell = completion
 	case clean:
 		numCommands++
 		cmd = Clean
 		if fs.NArg() > 0 {
 			// Temporary dupe of below check until we refactor the other commands to use this check
-			return inv, cmd, errors.New("-h, -init, -clean, -compile and -version cannot be used simultaneously")
+			return inv, cmd, errors.New("-h, -init, -bootstrap, -shim, -completion, -clean, -compile, -ensure, -serve and -version cannot be used simultaneously")
 
 		}
 	}
@@ -269,26 +522,110 @@ Options:
 		debug.SetOutput(stderr)
 	}
 
-	inv.CacheDir = mg.CacheDir()
-
 	if numCommands > 1 {
 		debug.Printf("%d commands defined", numCommands)
-		return inv, cmd, errors.New("-h, -init, -clean, -compile and -version cannot be used simultaneously")
+		return inv, cmd, errors.New("-h, -init, -bootstrap, -shim, -completion, -clean, -compile, -ensure, -serve and -version cannot be used simultaneously")
 	}
 
 	if cmd != CompileStatic && (inv.GOARCH != "" || inv.GOOS != "") {
 		return inv, cmd, errors.New("-goos and -goarch only apply when running with -compile")
 	}
 
+	if platforms != "" {
+		if cmd != CompileStatic {
+			return inv, cmd, errors.New("-compile-targets only applies when running with -compile")
+		}
+		if inv.GOARCH != "" || inv.GOOS != "" {
+			return inv, cmd, errors.New("-compile-targets cannot be used with -goos or -goarch")
+		}
+		for _, p := range strings.Split(platforms, ",") {
+			p = strings.TrimSpace(p)
+			if parts := strings.Split(p, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+				return inv, cmd, fmt.Errorf("invalid compile target %q, expected GOOS/GOARCH", p)
+			}
+			inv.Platforms = append(inv.Platforms, p)
+		}
+	}
+
+	if inv.setFlags["clean-env"] {
+		// the variables are required, so a target isn't mistaken for them.
+		if !strings.HasPrefix(cleanEnv, "KEEP=") {
+			return inv, cmd, fmt.Errorf("invalid -clean-env %q, expected KEEP=<vars>, e.g. KEEP=PATH,HOME", cleanEnv)
+		}
+		inv.CleanEnv = true
+		inv.KeepEnv = splitTags(strings.TrimPrefix(cleanEnv, "KEEP="))
+	}
+
+	if inv.Jobs < 0 {
+		return inv, cmd, errors.New("-j can't be negative")
+	}
+
+	inv.OnlyTags = splitTags(onlyTags)
+	inv.SkipTags = splitTags(skipTags)
+
+	switch inv.GraphFormat {
+	case "dot", "mermaid", "tree":
+	default:
+		return inv, cmd, fmt.Errorf("unsupported graph format %q, the supported formats are: dot, mermaid, tree", inv.GraphFormat)
+	}
+
+	switch inv.Lock {
+	case "", "fail", "wait":
+	default:
+		return inv, cmd, fmt.Errorf("unsupported lock %q, the supported values are: fail, wait", inv.Lock)
+	}
+
+	if inv.Report != "" {
+		parts := strings.SplitN(inv.Report, "=", 2)
+		if len(parts) != 2 || parts[1] == "" {
+			return inv, cmd, fmt.Errorf("invalid report %q, expected format=file, e.g. junit=report.xml", inv.Report)
+		}
+		if parts[0] != "junit" {
+			return inv, cmd, fmt.Errorf("unsupported report format %q, the supported formats are: junit", parts[0])
+		}
+	}
+
+	for flag, val := range map[string]string{"host": inv.Host, "in-container": inv.Container} {
+		if val == "" {
+			continue
+		}
+		if cmd != None {
+			return inv, cmd, fmt.Errorf("-%s only applies when running targets", flag)
+		}
+		if inv.CPUProfile != "" || inv.MemProfile != "" || inv.Trace != "" || inv.Report != "" || inv.Events != "" {
+			return inv, cmd, fmt.Errorf("-%s cannot be used with -cpuprofile, -memprofile, -trace, -report or -events", flag)
+		}
+		// the targets run in the remote environment, which mage doesn't
+		// control.
+		if inv.CleanEnv {
+			return inv, cmd, fmt.Errorf("-%s cannot be used with -clean-env", flag)
+		}
+	}
+	if inv.Host != "" && inv.Container != "" {
+		return inv, cmd, errors.New("-host and -in-container cannot be used together")
+	}
+
 	inv.Args = fs.Args()
 	if inv.Help && len(inv.Args) > 1 {
 		return inv, cmd, errors.New("-h can only show help for a single target")
 	}
+	if inv.Deps && len(inv.Args) != 1 {
+		return inv, cmd, errors.New("-deps requires a single target")
+	}
+	if inv.Graph && len(inv.Args) > 1 {
+		return inv, cmd, errors.New("-graph takes at most one target")
+	}
 
 	if len(inv.Args) > 0 && cmd != None {
 		return inv, cmd, fmt.Errorf("unexpected arguments to command: %q", inv.Args)
 	}
 	inv.HashFast = mg.HashFast()
+	inv.Plugins = plugins
+	if len(inv.Plugins) == 0 {
+		inv.Plugins = filepath.SplitList(os.Getenv(mg.PluginsEnv))
+	}
+	inv.GoFlags = os.Getenv(mg.GoFlagsEnv)
+	inv.GoWork = os.Getenv(mg.GoWorkEnv)
 	return inv, cmd, err
 }
 
@@ -304,58 +641,136 @@ func Invoke(inv Invocation) int {
 	if inv.WorkDir == "" {
 		inv.WorkDir = inv.Dir
 	}
+	if inv.CleanEnv && (inv.Host != "" || inv.Container != "") && inv.CompileOut == "" {
+		errlog.Println("Error: CleanEnv cannot be used with Host or Container")
+		return 1
+	}
+	if err := applyConfig(&inv); err != nil {
+		errlog.Println("Error reading config:", err)
+		return 1
+	}
+	internal.SetGoEnv(goEnv(inv))
+	if err := checkVersion(inv.mageVersion); err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
 	if inv.CacheDir == "" {
 		inv.CacheDir = mg.CacheDir()
 	}
+	// the cache dir may be relative, e.g. to keep it in the workspace for CI
+	// caches, but we compile from inside the magefile directory.
+	cacheDir, err := filepath.Abs(inv.CacheDir)
+	if err != nil {
+		errlog.Println("Error determining cache directory:", err)
+		return 1
+	}
+	inv.CacheDir = cacheDir
+
+	if inv.CompileOut != "" && len(inv.Platforms) > 0 {
+		return compilePlatforms(inv)
+	}
+	if inv.serve {
+		return serve(inv, errlog)
+	}
+	if inv.Lock != "" && inv.CompileOut == "" && !inv.List && !inv.Help && !inv.Deps && !inv.Graph && !inv.DryRun {
+		// if another run has the lock, lock.File says it's waiting for it.
+		l, err := lock.Repo(inv.Dir, inv.Lock == "wait")
+		if err != nil {
+			errlog.Println("Error:", err)
+			return 1
+		}
+		defer l.Release()
+	}
+	if inv.Host != "" && inv.CompileOut == "" {
+		return invokeRemote(inv, errlog)
+	}
+	if inv.Container != "" && inv.CompileOut == "" {
+		return invokeContainer(inv, errlog)
+	}
+	if (len(inv.subdirs) > 0 || len(inv.Plugins) > 0) && inv.CompileOut == "" {
+		return invokeSubdirs(inv, errlog)
+	}
+	return invoke(inv, errlog)
+}
+
+// invoke compiles and runs the magefiles in inv.Dir, once the defaults and
+// project config have been applied to inv.
+func invoke(inv Invocation, errlog *log.Logger) int {
+	if exePath, ok := askServer(inv); ok {
+		debug.Println("running the exe from mage -serve")
+		return RunCompiled(inv, exePath, errlog)
+	}
+	exePath, code := buildExe(inv, errlog)
+	if exePath == "" {
+		return code
+	}
+	return RunCompiled(inv, exePath, errlog)
+}
 
+// buildExe compiles the magefiles in inv.Dir, if they aren't already, and returns
+// the path to the binary.  If there's nothing to run, e.g. because the targets
+// were listed from the parsed magefiles, the binary was compiled with
+// -compile, or there was an error, it returns "" and the code to exit with.
+func buildExe(inv Invocation, errlog *log.Logger) (string, int) {
 	files, err := Magefiles(inv.Dir, inv.GOOS, inv.GOARCH, inv.GoCmd, inv.Stderr, inv.Debug)
 	if err != nil {
 		errlog.Println("Error determining list of magefiles:", err)
-		return 1
+		return "", 1
 	}
 
 	if len(files) == 0 {
 		errlog.Println("No .go files marked with the mage build tag in this directory.")
-		return 1
+		return "", 1
 	}
 	debug.Printf("found magefiles: %s", strings.Join(files, ", "))
 	exePath := inv.CompileOut
+	depsHash := ""
 	if inv.CompileOut == "" {
-		exePath, err = ExeName(inv.GoCmd, inv.CacheDir, files)
+		settings, err := buildSettings(inv)
 		if err != nil {
 			errlog.Println("Error getting exe name:", err)
-			return 1
+			return "", 1
 		}
-	}
-	debug.Println("output exe is ", exePath)
-
-	useCache := false
-	if inv.HashFast {
-		debug.Println("user has set MAGEFILE_HASHFAST, so we'll ignore GOCACHE")
-	} else {
-		s, err := internal.OutputDebug(inv.GoCmd, "env", "GOCACHE")
+		exePath, err = exeName(inv.GoCmd, inv.CacheDir, files, settings)
 		if err != nil {
-			errlog.Printf("failed to run %s env GOCACHE: %s", inv.GoCmd, err)
-			return 1
+			errlog.Println("Error getting exe name:", err)
+			return "", 1
 		}
-
-		// if GOCACHE exists, always rebuild, so we catch transitive
-		// dependencies that have changed.
-		if s != "" {
-			debug.Println("go build cache exists, will ignore any compiled binary")
-			useCache = true
+		if inv.HashFast {
+			debug.Println("user has set MAGEFILE_HASHFAST, so we'll only hash the magefiles")
+		} else {
+			depsHash, err = hashDeps(inv, files)
+			if err != nil {
+				errlog.Println("Error hashing the magefiles' dependencies:", err)
+				return "", 1
+			}
 		}
 	}
+	if inv.KeepDebug {
+		inv.Keep = true
+		inv.Force = true
+		if inv.CompileOut == "" {
+			// don't share the binary with optimized builds.
+			ext := filepath.Ext(exePath)
+			exePath = strings.TrimSuffix(exePath, ext) + "-debug" + ext
+		}
+	}
+	debug.Println("output exe is ", exePath)
 
-	if !useCache {
+	// the binary is named after the hash of the magefiles, and the hash of
+	// their dependencies is stored next to it, so it's rebuilt when either
+	// changes, no matter what happened to the files' modtimes.
+	if inv.CompileOut == "" {
 		_, err = os.Stat(exePath)
 		switch {
 		case err == nil:
 			if inv.Force {
 				debug.Println("ignoring existing executable")
+			} else if b, _ := ioutil.ReadFile(exePath + depsHashExt); !inv.HashFast && string(b) != depsHash {
+				debug.Println("dependencies have changed, rebuilding")
 			} else {
 				debug.Println("Running existing exe")
-				return RunCompiled(inv, exePath, errlog)
+				return exePath, 0
 			}
 		case os.IsNotExist(err):
 			debug.Println("no existing exe, creating new")
@@ -377,7 +792,16 @@ func Invoke(inv Invocation) int {
 	info, err := parse.PrimaryPackage(inv.GoCmd, inv.Dir, fnames)
 	if err != nil {
 		errlog.Println("Error parsing magefiles:", err)
-		return 1
+		return "", inv.ExitCodes.compileError()
+	}
+
+	if inv.List && canListParsed(inv) {
+		debug.Println("listing targets from the parsed magefiles")
+		if err := listParsed(inv.Stdout, info); err != nil {
+			errlog.Println("Error:", err)
+			return "", 1
+		}
+		return "", 0
 	}
 
 	main := filepath.Join(inv.Dir, mainfile)
@@ -386,18 +810,32 @@ func Invoke(inv Invocation) int {
 		binaryName = filepath.Base(inv.CompileOut)
 	}
 
-	err = GenerateMainfile(binaryName, main, info)
+	err = generateMainfile(binaryName, main, info, inv.KeepDebug)
 	if err != nil {
 		errlog.Println("Error:", err)
-		return 1
+		return "", 1
 	}
 	if !inv.Keep {
 		defer os.RemoveAll(main)
 	}
 	files = append(files, main)
-	if err := Compile(inv.GOOS, inv.GOARCH, inv.Dir, inv.GoCmd, exePath, files, inv.Debug, inv.Stderr, inv.Stdout); err != nil {
+	var buildArgs []string
+	if inv.KeepDebug {
+		// turn off optimizations and inlining, so breakpoints work.
+		buildArgs = []string{"-gcflags=all=-N -l"}
+	}
+	if inv.CompileOut == "" {
+		// a binary built with HashFast has no record of its dependencies.
+		os.Remove(exePath + depsHashExt)
+	}
+	if err := compile(inv.GOOS, inv.GOARCH, inv.Dir, inv.GoCmd, exePath, files, buildArgs, inv.Debug, inv.Stderr, inv.Stdout); err != nil {
 		errlog.Println("Error:", err)
-		return 1
+		return "", inv.ExitCodes.compileError()
+	}
+	if depsHash != "" {
+		if err := ioutil.WriteFile(exePath+depsHashExt, []byte(depsHash), 0644); err != nil {
+			debug.Printf("error saving the hash of the dependencies: %v", err)
+		}
 	}
 	if !inv.Keep {
 		// move aside this file before we run the compiled version, in case the
@@ -409,21 +847,66 @@ func Invoke(inv Invocation) int {
 	}
 
 	if inv.CompileOut != "" {
-		return 0
+		return "", 0
 	}
+	return exePath, 0
+}
 
-	return RunCompiled(inv, exePath, errlog)
+// compilePlatforms compiles a binary for each of the invocation's platforms.
+// The binaries are named after CompileOut with the GOOS and GOARCH appended,
+// e.g. mage-linux-amd64 and mage-windows-amd64.exe.
+func compilePlatforms(inv Invocation) int {
+	platforms := inv.Platforms
+	inv.Platforms = nil
+	for _, p := range platforms {
+		parts := strings.SplitN(p, "/", 2)
+		pinv := inv
+		pinv.GOOS, pinv.GOARCH = parts[0], parts[1]
+		pinv.CompileOut = PlatformBinaryName(inv.CompileOut, pinv.GOOS, pinv.GOARCH)
+		debug.Printf("compiling %s for %s", pinv.CompileOut, p)
+		if code := Invoke(pinv); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// PlatformBinaryName returns the name of the binary compiled for the given
+// GOOS and GOARCH when compiling for multiple platforms to path.
+func PlatformBinaryName(path, goos, goarch string) string {
+	path = strings.TrimSuffix(path, ".exe")
+	path += "-" + goos + "-" + goarch
+	if goos == "windows" {
+		path += ".exe"
+	}
+	return path
 }
 
 type mainfileTemplateData struct {
-	Description string
-	Funcs       []*parse.Function
-	DefaultFunc parse.Function
-	Aliases     map[string]*parse.Function
-	Imports     []*parse.Import
-	BinaryName  string
+	Description    string
+	Funcs          []*parse.Function
+	DefaultFunc    parse.Function
+	Defaults       []*parse.Function // the default targets, whether there's one or a list
+	DynamicDefault bool
+	Aliases        map[string]*parse.Function
+	Imports        []*parse.Import
+	BinaryName     string
+	Setup          *parse.Function
+	Teardown       *parse.Function
+
+	// ExternalImports are the imports from other modules, sorted by path.
+	ExternalImports []*parse.Import
+
+	// Mg are the funcs in mg the mainfile calls, of those the version of mg
+	// the magefiles are compiled with has.  The mainfile imports mg, as
+	// magemg, only if there are any.
+	Mg map[string]bool
 }
 
+// mgHooks are the funcs in mg the mainfile calls, when it can, so the targets
+// it runs share mg's state with their dependencies.
+var mgHooks = []string{"CurrentLogger", "RunCleanups", "RunTarget"}
+
 // Magefiles returns the list of magefiles in dir.
 func Magefiles(magePath, goos, goarch, goCmd string, stderr io.Writer, isDebug bool) ([]string, error) {
 	start := time.Now()
@@ -492,6 +975,11 @@ func Magefiles(magePath, goos, goarch, goCmd string, stderr io.Writer, isDebug b
 
 // Compile uses the go tool to compile the files into an executable at path.
 func Compile(goos, goarch, magePath, goCmd, compileTo string, gofiles []string, isDebug bool, stderr, stdout io.Writer) error {
+	return compile(goos, goarch, magePath, goCmd, compileTo, gofiles, nil, isDebug, stderr, stdout)
+}
+
+// compile is Compile with extra args for go build.
+func compile(goos, goarch, magePath, goCmd, compileTo string, gofiles, buildArgs []string, isDebug bool, stderr, stdout io.Writer) error {
 	debug.Println("compiling to", compileTo)
 	debug.Println("compiling using gocmd:", goCmd)
 	if isDebug {
@@ -506,7 +994,8 @@ func Compile(goos, goarch, magePath, goCmd, compileTo string, gofiles []string,
 	for i := range gofiles {
 		gofiles[i] = filepath.Base(gofiles[i])
 	}
-	args := append([]string{"build", "-o", compileTo}, gofiles...)
+	args := append([]string{"build", "-o", compileTo}, buildArgs...)
+	args = append(args, gofiles...)
 	debug.Printf("running %s %s", goCmd, strings.Join(args, " "))
 	c := exec.Command(goCmd, args...)
 	c.Env = environ
@@ -524,44 +1013,101 @@ func Compile(goos, goarch, magePath, goCmd, compileTo string, gofiles []string,
 
 // GenerateMainfile generates the mage mainfile at path.
 func GenerateMainfile(binaryName, path string, info *parse.PkgInfo) error {
-	debug.Println("Creating mainfile at", path)
+	return generateMainfile(binaryName, path, info, false)
+}
 
-	f, err := os.Create(path)
-	if err != nil {
-		return fmt.Errorf("error creating generated mainfile: %v", err)
-	}
-	defer f.Close()
+// generateMainfile generates the mage mainfile at path.  For debugging, the
+// mainfile is formatted, and the lines that call each target have //line
+// directives pointing at the target in its magefile.
+func generateMainfile(binaryName, path string, info *parse.PkgInfo, forDebug bool) error {
+	debug.Println("Creating mainfile at", path)
 	data := mainfileTemplateData{
 		Description: info.Description,
 		Funcs:       info.Funcs,
 		Aliases:     info.Aliases,
 		Imports:     info.Imports,
 		BinaryName:  binaryName,
+		Setup:       info.Setup,
+		Teardown:    info.Teardown,
 	}
 
 	if info.DefaultFunc != nil {
 		data.DefaultFunc = *info.DefaultFunc
 	}
+	data.Defaults = defaultTargets(info)
+	data.DynamicDefault = info.DynamicDefault
+	data.ExternalImports = externalImports(info)
+	for _, name := range mgHooks {
+		if info.MgFuncs[name] {
+			if data.Mg == nil {
+				data.Mg = map[string]bool{}
+			}
+			data.Mg[name] = true
+		}
+	}
 
-	debug.Println("writing new file at", path)
-	if err := mainfileTemplate.Execute(f, data); err != nil {
+	buf := &bytes.Buffer{}
+	if err := mainfileTemplate.Execute(buf, data); err != nil {
 		return fmt.Errorf("can't execute mainfile template: %v", err)
 	}
-	if err := f.Close(); err != nil {
-		return fmt.Errorf("error closing generated mainfile: %v", err)
+	src := buf.Bytes()
+	if forDebug {
+		formatted, err := format.Source(src)
+		if err != nil {
+			return fmt.Errorf("error formatting generated mainfile: %v", err)
+		}
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return err
+		}
+		src = lineDirectives(formatted, abs)
+	} else {
+		src = lineDirectives(src, "")
 	}
-	// we set an old modtime on the generated mainfile so that the go tool
-	// won't think it has changed more recently than the compiled binary.
-	longAgo := time.Now().Add(-time.Hour * 24 * 365 * 10)
-	if err := os.Chtimes(path, longAgo, longAgo); err != nil {
-		return fmt.Errorf("error setting old modtime on generated mainfile: %v", err)
+
+	debug.Println("writing new file at", path)
+	if err := ioutil.WriteFile(path, src, 0644); err != nil {
+		return fmt.Errorf("error writing generated mainfile: %v", err)
 	}
 	return nil
 }
 
+// lineDirectives replaces the //mage:line and //mage:endline markers that
+// parse.Function.ExecCode puts around the line that calls a target with
+// //line directives, so the call is attributed to the target in its magefile,
+// and the lines after it to the mainfile at path.  If path is empty, the
+// markers are removed instead.
+func lineDirectives(src []byte, path string) []byte {
+	lines := bytes.Split(src, []byte("\n"))
+	out := make([][]byte, 0, len(lines))
+	for _, line := range lines {
+		text := bytes.TrimSpace(line)
+		switch {
+		case !bytes.HasPrefix(text, []byte("//mage:")):
+		case path == "":
+			continue
+		case bytes.HasPrefix(text, []byte("//mage:line ")):
+			line = append([]byte("//line "), text[len("//mage:line "):]...)
+		case bytes.Equal(text, []byte("//mage:endline")):
+			// the directive sets the position of the line after it.
+			line = []byte(fmt.Sprintf("//line %s:%d", path, len(out)+2))
+		}
+		out = append(out, line)
+	}
+	return bytes.Join(out, []byte("\n"))
+}
+
 // ExeName reports the executable filename that this version of Mage would
-// create for the given magefiles.
+// create for the given magefiles.  The name is a hash of the contents of the
+// magefiles, the go version, and the platform the binary is built for, so the
+// cache directory may be shared between machines and restored by CI caches.
 func ExeName(goCmd, cacheDir string, files []string) (string, error) {
+	return exeName(goCmd, cacheDir, files, []string{"GOOS=" + runtime.GOOS, "GOARCH=" + runtime.GOARCH})
+}
+
+// exeName is ExeName for a binary built from the given files, with the given
+// settings from the environment as KEY=VALUE.
+func exeName(goCmd, cacheDir string, files, settings []string) (string, error) {
 	var hashes []string
 	for _, s := range files {
 		h, err := hashFile(s)
@@ -578,7 +1124,7 @@ func ExeName(goCmd, cacheDir string, files []string) (string, error) {
 	if err != nil {
 		return "", err
 	}
-	hash := sha1.Sum([]byte(strings.Join(hashes, "") + magicRebuildKey + ver))
+	hash := sha1.Sum([]byte(strings.Join(hashes, "") + magicRebuildKey + ver + strings.Join(settings, "\n")))
 	filename := fmt.Sprintf("%x", hash)
 
 	out := filepath.Join(cacheDir, filename)
@@ -588,6 +1134,155 @@ func ExeName(goCmd, cacheDir string, files []string) (string, error) {
 	return out, nil
 }
 
+// buildEnv are the environment variables that change the binary the go tool
+// builds from the same files.
+var buildEnv = []string{"GOOS", "GOARCH", "GOFLAGS", "GOWORK", "CGO_ENABLED"}
+
+// goEnv returns the variables to set for the go commands that compile the
+// magefiles, from inv.GoFlags and inv.GoWork.
+func goEnv(inv Invocation) map[string]string {
+	env := map[string]string{}
+	if inv.GoFlags != "" {
+		env["GOFLAGS"] = strings.TrimSpace(os.Getenv("GOFLAGS") + " " + inv.GoFlags)
+	}
+	if inv.GoWork != "" {
+		env["GOWORK"] = inv.GoWork
+		// the go command requires an absolute path.
+		if inv.GoWork != "off" && !filepath.IsAbs(inv.GoWork) {
+			if abs, err := filepath.Abs(filepath.Join(inv.Dir, inv.GoWork)); err == nil {
+				env["GOWORK"] = abs
+			}
+		}
+	}
+	return env
+}
+
+// depsHashExt is the extension of the file next to a compiled binary in the
+// cache that holds the hash of its dependencies.
+const depsHashExt = ".deps"
+
+// buildSettings returns the variables from the environment that affect the
+// compiled binary, as KEY=VALUE.
+func buildSettings(inv Invocation) ([]string, error) {
+	env, err := internal.EnvWithGOOS(inv.GOOS, inv.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	var settings []string
+	for _, kv := range env {
+		for _, name := range buildEnv {
+			if strings.HasPrefix(kv, name+"=") {
+				settings = append(settings, kv)
+			}
+		}
+	}
+	sort.Strings(settings)
+	return settings, nil
+}
+
+// hashDeps returns a hash of what the magefiles depend on: the source files
+// of the non-standard packages they import, directly or not, and the go.mod
+// and go.sum files of those packages' modules.
+func hashDeps(inv Invocation, files []string) (string, error) {
+	deps, err := listDeps(inv, files)
+	if err != nil {
+		return "", err
+	}
+	debug.Printf("hashing %d dependencies of the magefiles", len(deps))
+	// the hash doesn't include the directories, so it's the same wherever
+	// the code is checked out.
+	hashes := make([]string, 0, len(deps))
+	for _, dep := range deps {
+		h, err := hashFile(dep)
+		if err != nil {
+			return "", err
+		}
+		hashes = append(hashes, filepath.Base(dep)+" "+h)
+	}
+	sort.Strings(hashes)
+	return fmt.Sprintf("%x", sha1.Sum([]byte(strings.Join(hashes, "\n")))), nil
+}
+
+// listDeps returns the source files of the packages the magefiles import,
+// outside the standard library, the go.mod and go.sum of their modules, and
+// the go.work and go.work.sum of the workspace they're compiled in, if any.
+func listDeps(inv Invocation, files []string) ([]string, error) {
+	env, err := internal.EnvWithGOOS(inv.GOOS, inv.GOARCH)
+	if err != nil {
+		return nil, err
+	}
+	args := []string{"list", "-tags=mage", "-e", "-deps", "-f",
+		`{{if not .Standard}}{{.Dir}}||{{join .GoFiles ","}},{{join .CgoFiles ","}}||{{with .Module}}{{.GoMod}}{{end}}{{end}}`}
+	for _, f := range files {
+		args = append(args, filepath.Base(f))
+	}
+	cmd := exec.Command(inv.GoCmd, args...)
+	cmd.Env = env
+	cmd.Dir = inv.Dir
+	stderr := &bytes.Buffer{}
+	cmd.Stderr = stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the magefiles' dependencies: %v: %s", err, stderr)
+	}
+
+	seen := map[string]bool{}
+	var deps []string
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			deps = append(deps, path)
+		}
+	}
+	for _, line := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		parts := strings.Split(line, "||")
+		if len(parts) != 3 {
+			continue
+		}
+		for _, name := range strings.Split(parts[1], ",") {
+			if name != "" {
+				add(filepath.Join(parts[0], name))
+			}
+		}
+		if gomod := parts[2]; gomod != "" {
+			add(gomod)
+			if gosum := filepath.Join(filepath.Dir(gomod), "go.sum"); fileExists(gosum) {
+				add(gosum)
+			}
+		}
+	}
+	cmd = exec.Command(inv.GoCmd, "env", "GOWORK")
+	cmd.Env = env
+	cmd.Dir = inv.Dir
+	if out, err := cmd.Output(); err == nil {
+		// it's empty when there's no workspace, or "off".
+		if gowork := strings.TrimSpace(string(out)); filepath.IsAbs(gowork) && fileExists(gowork) {
+			add(gowork)
+			if sum := gowork + ".sum"; fileExists(sum) {
+				add(sum)
+			}
+		}
+	}
+	return deps, nil
+}
+
+// splitTags splits a comma separated list of tags, like the value of
+// -only-tags, ignoring empty ones.
+func splitTags(s string) []string {
+	var tags []string
+	for _, tag := range strings.Split(s, ",") {
+		if tag = strings.TrimSpace(tag); tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+	return tags
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 func hashFile(fn string) (string, error) {
 	f, err := os.Open(fn)
 	if err != nil {
@@ -617,6 +1312,61 @@ func generateInit(dir string) error {
 	return nil
 }
 
+func generateBootstrap(dir string) error {
+	debug.Println("generating bootstrap file in", dir)
+	version := gitTag
+	if version == "<not set>" {
+		// a development build of mage, there's no release to pin to.
+		version = "latest"
+	}
+	f, err := os.Create(filepath.Join(dir, bootstrapFile))
+	if err != nil {
+		return fmt.Errorf("could not create bootstrap file: %v", err)
+	}
+	defer f.Close()
+
+	data := struct{ Version string }{version}
+	if err := bootstrapOutput.Execute(f, data); err != nil {
+		return fmt.Errorf("can't execute bootstrap template: %v", err)
+	}
+	return nil
+}
+
+// generateShim writes mage.cmd and mage.ps1 to dir.  They pin the version of
+// mage the project config requires, if it requires an exact version, or else
+// this version.
+func generateShim(dir string) error {
+	debug.Println("generating shims in", dir)
+	cfg, err := ReadConfig(dir)
+	if err != nil {
+		return err
+	}
+	version := currentVersion()
+	if r, err := parseVersionReq(cfg.Mage); err == nil && !r.min {
+		version = r.version
+	}
+	if version == "" {
+		// a development build of mage, there's no release to pin to.
+		version = "latest"
+	}
+	data := struct{ Version string }{version}
+	for name, tpl := range map[string]*template.Template{shimCmdFile: shimCmdOutput, shimPs1File: shimPs1Output} {
+		buf := &bytes.Buffer{}
+		if err := tpl.Execute(buf, data); err != nil {
+			return fmt.Errorf("can't execute shim template: %v", err)
+		}
+		b := buf.Bytes()
+		if name == shimCmdFile {
+			// cmd.exe expects windows line endings.
+			b = bytes.Replace(b, []byte("\n"), []byte("\r\n"), -1)
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, name), b, 0755); err != nil {
+			return fmt.Errorf("could not create %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
 // RunCompiled runs an already-compiled mage command with the given args,
 func RunCompiled(inv Invocation, exePath string, errlog *log.Logger) int {
 	debug.Println("running binary", exePath)
@@ -629,32 +1379,190 @@ func RunCompiled(inv Invocation, exePath string, errlog *log.Logger) int {
 		c.Dir = inv.WorkDir
 	}
 	// intentionally pass through unaltered os.Environ here.. your magefile has
-	// to deal with it.
+	// to deal with it, unless it's run with -clean-env.
 	c.Env = os.Environ()
+	if inv.CleanEnv {
+		c.Env = cleanEnviron(c.Env, inv.KeepEnv)
+		debug.Print("running magefile with only these variables from the environment:\n", strings.Join(c.Env, "\n"))
+	}
+	c.Env = append(c.Env, inv.Env...)
+	vars, err := magefileEnv(inv, c.Env)
+	if err != nil {
+		errlog.Println("Error loading .env:", err)
+		return 1
+	}
+	c.Env = append(c.Env, vars...)
+	debug.Print("running magefile with mage vars:\n", strings.Join(filter(c.Env, "MAGEFILE"), "\n"))
+	// the magefile handles interrupts itself, so mage waits for it to stop
+	// rather than exiting and leaving it running, and passes on the signals it
+	// gets.  Unless it's run from a terminal, which it may read from, it gets
+	// a process group of its own, so they reach the commands it's running too.
+	group := !isTerminal(inv.Stdin) && ownProcessGroup(c)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+	err = c.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case sig := <-sigs:
+					if group {
+						signalProcessGroup(c.Process, sig)
+					} else {
+						c.Process.Signal(sig)
+					}
+				case <-done:
+					return
+				}
+			}
+		}()
+		err = c.Wait()
+		close(done)
+	}
+	if !sh.CmdRan(err) {
+		errlog.Printf("failed to run compiled magefile: %v", err)
+	}
+	return sh.ExitStatus(err)
+}
+
+// magefileEnv returns the variables to add to environ for the compiled
+// magefile: those from .env files and the project config that aren't already
+// set, and the MAGEFILE variables that pass on the options mage was run with.
+func magefileEnv(inv Invocation, environ []string) ([]string, error) {
+	var vars []string
+	// .env files are often machine specific, like .env.local, so they're
+	// left out of a clean environment, to keep it the same everywhere.
+	if !inv.NoDotenv && !inv.CleanEnv {
+		// variables from .env files never override the environment, so
+		// they can always be overridden when running mage.
+		dotenv, err := internal.LoadDotenv(inv.Dir, environ)
+		if err != nil {
+			return nil, err
+		}
+		vars = append(vars, dotenv...)
+	}
+	// the config file has the lowest precedence of all.
+	set := append(append([]string{}, environ...), vars...)
+	vars = append(vars, internal.UnsetVars(set, inv.configEnv)...)
 	if inv.Verbose {
-		c.Env = append(c.Env, "MAGEFILE_VERBOSE=1")
+		vars = append(vars, "MAGEFILE_VERBOSE=1")
 	}
 	if inv.List {
-		c.Env = append(c.Env, "MAGEFILE_LIST=1")
+		vars = append(vars, "MAGEFILE_LIST=1")
+	}
+	if inv.Deps {
+		vars = append(vars, mg.DepsEnv+"=1")
+	}
+	if inv.Graph {
+		vars = append(vars, mg.GraphEnv+"=1", mg.GraphFormatEnv+"="+inv.GraphFormat)
+	}
+	if inv.DryRun {
+		vars = append(vars, mg.DryRunEnv+"=1")
+	}
+	if inv.Timestamps {
+		vars = append(vars, mg.TimestampsEnv+"=1")
+	}
+	if inv.Status != "" {
+		vars = append(vars, mg.StatusEnv+"="+inv.Status)
+	}
+	if inv.Summary {
+		vars = append(vars, mg.SummaryEnv+"=1")
+	}
+	if inv.Yes {
+		vars = append(vars, mg.YesEnv+"=1")
+	}
+	if inv.Jobs > 0 {
+		vars = append(vars, mg.JobsEnv+"="+strconv.Itoa(inv.Jobs))
+	}
+	if len(inv.OnlyTags) > 0 {
+		vars = append(vars, mg.OnlyTagsEnv+"="+strings.Join(inv.OnlyTags, ","))
+	}
+	if len(inv.SkipTags) > 0 {
+		vars = append(vars, mg.SkipTagsEnv+"="+strings.Join(inv.SkipTags, ","))
+	}
+	// the binary runs in the working directory, so profile paths are made
+	// absolute to keep them relative to where mage was run.
+	for env, path := range map[string]string{
+		mg.CPUProfileEnv: inv.CPUProfile,
+		mg.MemProfileEnv: inv.MemProfile,
+		mg.TraceEnv:      inv.Trace,
+		mg.EventsEnv:     inv.Events,
+	} {
+		if path == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		vars = append(vars, env+"="+path)
+	}
+	if inv.Report != "" {
+		parts := strings.SplitN(inv.Report, "=", 2)
+		if abs, err := filepath.Abs(parts[1]); err == nil {
+			parts[1] = abs
+		}
+		vars = append(vars, mg.ReportEnv+"="+strings.Join(parts, "="))
 	}
 	if inv.Help {
-		c.Env = append(c.Env, "MAGEFILE_HELP=1")
+		vars = append(vars, "MAGEFILE_HELP=1")
 	}
 	if inv.Debug {
-		c.Env = append(c.Env, "MAGEFILE_DEBUG=1")
+		vars = append(vars, "MAGEFILE_DEBUG=1")
 	}
 	if inv.GoCmd != "" {
-		c.Env = append(c.Env, fmt.Sprintf("MAGEFILE_GOCMD=%s", inv.GoCmd))
+		vars = append(vars, fmt.Sprintf("MAGEFILE_GOCMD=%s", inv.GoCmd))
 	}
 	if inv.Timeout > 0 {
-		c.Env = append(c.Env, fmt.Sprintf("MAGEFILE_TIMEOUT=%s", inv.Timeout.String()))
+		vars = append(vars, fmt.Sprintf("MAGEFILE_TIMEOUT=%s", inv.Timeout.String()))
 	}
-	debug.Print("running magefile with mage vars:\n", strings.Join(filter(c.Env, "MAGEFILE"), "\n"))
-	err := c.Run()
-	if !sh.CmdRan(err) {
-		errlog.Printf("failed to run compiled magefile: %v", err)
+	if inv.TargetTimeout > 0 {
+		vars = append(vars, fmt.Sprintf("%s=%s", mg.TargetTimeoutEnv, inv.TargetTimeout.String()))
 	}
-	return sh.ExitStatus(err)
+	if inv.Parallel {
+		vars = append(vars, mg.ParallelEnv+"=1")
+	}
+	if codes := inv.ExitCodes.env(); codes != "" {
+		vars = append(vars, mg.ExitCodesEnv+"="+codes)
+	}
+	if inv.telemetry != "" {
+		vars = append(vars, mg.TelemetryEnv+"="+inv.telemetry)
+	}
+	return vars, nil
+}
+
+// isTerminal reports whether r is a terminal rather than a file or pipe.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// isURL reports whether s is an http or https URL, rather than a file.
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// cleanEnviron returns the KEY=VALUE variables in environ that are named in
+// keep, or are mage's own MAGEFILE variables.
+func cleanEnviron(environ, keep []string) []string {
+	var out []string
+	for _, kv := range environ {
+		name := strings.SplitN(kv, "=", 2)[0]
+		keepIt := strings.HasPrefix(name, "MAGEFILE_")
+		for _, k := range keep {
+			// variable names aren't case sensitive on windows.
+			keepIt = keepIt || k == name || (runtime.GOOS == "windows" && strings.EqualFold(k, name))
+		}
+		if keepIt {
+			out = append(out, kv)
+		}
+	}
+	return out
 }
 
 func filter(list []string, prefix string) []string {
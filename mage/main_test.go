@@ -1,9 +1,11 @@
 package mage
 
 import (
+	"bufio"
 	"bytes"
 	"debug/macho"
 	"debug/pe"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/build"
@@ -12,6 +14,9 @@ import (
 	"io"
 	"io/ioutil"
 	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"os/exec"
 	"path/filepath"
@@ -24,7 +29,10 @@ import (
 	"time"
 
 	"github.com/magefile/mage/internal"
+	"github.com/magefile/mage/lock"
 	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/parse"
+	"github.com/magefile/mage/sh"
 )
 
 const testExeEnv = "MAGE_TEST_STRING"
@@ -66,6 +74,12 @@ func testmain(m *testing.M) int {
 	if err := os.Unsetenv(mg.TargetColorEnv); err != nil {
 		log.Fatal(err)
 	}
+	// don't let the environment the tests run in change their output.
+	for _, env := range []string{mg.StatusEnv, mg.GitHubActionsEnv, mg.NoDotenvEnv, "GITHUB_ACTIONS", "GITHUB_STEP_SUMMARY"} {
+		if err := os.Unsetenv(env); err != nil {
+			log.Fatal(err)
+		}
+	}
 	resetTerm()
 	return m.Run()
 }
@@ -130,6 +144,40 @@ func TestTransitiveDepCache(t *testing.T) {
 	}
 }
 
+func TestUnchangedDepsReuseBinary(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "mage-cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	logs := &bytes.Buffer{}
+	debug.SetOutput(logs)
+	defer debug.SetOutput(ioutil.Discard)
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Stderr:   stderr,
+		Stdout:   ioutil.Discard,
+		Dir:      "testdata/transitiveDeps",
+		CacheDir: cacheDir,
+		Args:     []string{"Run"},
+	}
+	for i := 0; i < 2; i++ {
+		if code := Invoke(inv); code != 0 {
+			t.Fatalf("got code %v, err: %s", code, stderr)
+		}
+	}
+	if n := strings.Count(logs.String(), "Running existing exe"); n != 1 {
+		t.Fatalf("expected the second run to reuse the binary, but it was reused %d times, logs:\n%s", n, logs)
+	}
+	matches, err := filepath.Glob(filepath.Join(cacheDir, "*"+depsHashExt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(matches) != 1 {
+		t.Fatalf("expected the hash of the dependencies to be saved in the cache, but found %q", matches)
+	}
+}
+
 func TestTransitiveHashFast(t *testing.T) {
 	cache, err := internal.OutputDebug("go", "env", "GOCACHE")
 	if err != nil {
@@ -709,6 +757,52 @@ func TestKeepFlag(t *testing.T) {
 	}
 }
 
+func TestKeepDebug(t *testing.T) {
+	buildFile := filepath.Join("testdata", "keep_debug", mainfile)
+	defer os.Remove(buildFile)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:       "./testdata/keep_debug",
+		Stdout:    stdout,
+		Stderr:    stderr,
+		KeepDebug: true,
+		Args:      []string{"caller"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// the call to the target is attributed to its declaration.
+	expected := "called from magefile.go:12\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+	b, err := ioutil.ReadFile(buildFile)
+	if err != nil {
+		t.Fatalf("expected the mainfile to be kept, but got %v", err)
+	}
+	file, err := filepath.Abs(filepath.Join("testdata", "keep_debug", "magefile.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if directive := "\n//line " + file + ":12\n"; !bytes.Contains(b, []byte(directive)) {
+		t.Errorf("expected the mainfile to contain %q", directive)
+	}
+}
+
+func TestLineDirectives(t *testing.T) {
+	src := "a\n\t//mage:line magefile.go:10\n\tcall()\n\t//mage:endline\nb"
+	expected := "a\n//line magefile.go:10\n\tcall()\n//line /out.go:5\nb"
+	if actual := string(lineDirectives([]byte(src), "/out.go")); actual != expected {
+		t.Errorf("expected %q, but got %q", expected, actual)
+	}
+	expected = "a\n\tcall()\nb"
+	if actual := string(lineDirectives([]byte(src), "")); actual != expected {
+		t.Errorf("expected %q, but got %q", expected, actual)
+	}
+}
+
 type tLogWriter struct {
 	*testing.T
 }
@@ -756,6 +850,10 @@ func TestOnlyStdLib(t *testing.T) {
 	for _, s := range f.Imports {
 		// the path value comes in as a quoted string, i.e. literally \"context\"
 		path := strings.Trim(s.Path.Value, "\"")
+		if path == "github.com/magefile/mage/mg" {
+			// the magefiles import mg, so the mainfile can hook into it.
+			continue
+		}
 		pkg, err := build.Default.Import(path, "./testdata/keep_flag", build.FindOnly)
 		if err != nil {
 			t.Fatal(err)
@@ -766,6 +864,31 @@ func TestOnlyStdLib(t *testing.T) {
 	}
 }
 
+func TestMainfileWithoutMg(t *testing.T) {
+	// the cleanenv magefile doesn't use mage's libraries, which its module
+	// might not have, so the mainfile can't import mg.
+	info, err := parse.PrimaryPackage("go", "./testdata/cleanenv", []string{"magefile.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, mainfile)
+	if err := GenerateMainfile("mage", path, info); err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "github.com/magefile/mage/mg") {
+		t.Error("expected the mainfile not to import mg")
+	}
+}
+
 func TestMultipleTargets(t *testing.T) {
 	var stderr, stdout bytes.Buffer
 	inv := Invocation{
@@ -842,7 +965,7 @@ func TestBadSecondTargets(t *testing.T) {
 
 func TestParse(t *testing.T) {
 	buf := &bytes.Buffer{}
-	inv, cmd, err := Parse(ioutil.Discard, buf, []string{"-v", "-debug", "-gocmd=foo", "-d", "dir", "build", "deploy"})
+	inv, cmd, err := Parse(ioutil.Discard, buf, []string{"-v", "-debug", "-gocmd=foo", "-d", "dir", "-p", "-cache-dir", "cache", "build", "deploy"})
 	if err != nil {
 		t.Fatal("unexpected error", err)
 	}
@@ -861,6 +984,12 @@ func TestParse(t *testing.T) {
 	if inv.GoCmd != "foo" {
 		t.Errorf("Expected gocmd to be \"foo\" but was %q", inv.GoCmd)
 	}
+	if !inv.Parallel {
+		t.Error("parallel should be true")
+	}
+	if inv.CacheDir != "cache" {
+		t.Errorf("Expected cache dir to be \"cache\" but was %q", inv.CacheDir)
+	}
 	expected := []string{"build", "deploy"}
 	if !reflect.DeepEqual(inv.Args, expected) {
 		t.Fatalf("expected args to be %q but got %q", expected, inv.Args)
@@ -913,6 +1042,46 @@ func TestSetWorkingDir(t *testing.T) {
 	}
 }
 
+func TestParallel(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:      "./testdata/parallel",
+		Stdout:   stdout,
+		Stderr:   stderr,
+		Parallel: true,
+		Args:     []string{"a", "b", "a"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// the shared dependency of both targets must only run once.
+	expected := "shared\n"
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+}
+
+func TestParallelError(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:      "./testdata/parallel",
+		Stdout:   ioutil.Discard,
+		Stderr:   stderr,
+		Parallel: true,
+		Args:     []string{"fail", "a", "b"},
+	}
+	code := Invoke(inv)
+	if code != 3 {
+		t.Fatalf("expected to exit with code 3, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := "Error: failed\n"
+	if actual := stderr.String(); actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+}
+
 // Test the timeout option
 func TestTimeout(t *testing.T) {
 	stderr := &bytes.Buffer{}
@@ -935,6 +1104,128 @@ func TestTimeout(t *testing.T) {
 		t.Fatalf("expected %q, but got %q", expected, actual)
 	}
 }
+
+// Test the per-target timeout option
+func TestTargetTimeout(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	stdout := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:           "testdata/context",
+		Stdout:        stdout,
+		Stderr:        stderr,
+		Args:          []string{"takescontextnoerror", "timeout"},
+		TargetTimeout: time.Duration(100 * time.Millisecond),
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected 1, but got %v, stderr: %q, stdout: %q", code, stderr, stdout)
+	}
+	actual := stderr.String()
+	expected := "Error: target \"Timeout\" timed out after 100ms\n"
+	if actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+	// the first target must have finished within its own deadline, and the
+	// timeout is only reported on stderr.
+	if !strings.HasPrefix(stdout.String(), "Context timeout: ") || strings.Contains(stdout.String(), "ctx err") {
+		t.Fatalf("expected first target to run, but got stdout %q", stdout)
+	}
+}
+
+func TestCompilePlatforms(t *testing.T) {
+	target, err := ioutil.TempDir("./testdata", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(target)
+
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Stderr: stderr,
+		Stdout: ioutil.Discard,
+		Dir:    "testdata",
+		// this is relative to the Dir above
+		CompileOut: filepath.Join(".", filepath.Base(target), "output"),
+		Platforms:  []string{"windows/386", "darwin/arm64"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("got code %v, err: %s", code, stderr)
+	}
+	os, arch, err := fileData(filepath.Join(target, "output-windows-386.exe"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os != winExe || arch != arch32 {
+		t.Error("expected output-windows-386.exe to be a 32 bit windows exe")
+	}
+	os, arch, err = fileData(filepath.Join(target, "output-darwin-arm64"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if os != macExe || arch != arch64 {
+		t.Error("expected output-darwin-arm64 to be a 64 bit mac exe")
+	}
+}
+
+func TestParseCompileTargets(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-compile", "out", "-compile-targets", "linux/amd64, windows/arm64"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	expected := []string{"linux/amd64", "windows/arm64"}
+	if !reflect.DeepEqual(inv.Platforms, expected) {
+		t.Fatalf("expected platforms %q but got %q", expected, inv.Platforms)
+	}
+	tests := []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-compile-targets", "linux/amd64"}, "-compile-targets only applies when running with -compile"},
+		{[]string{"-compile", "out", "-goos", "linux", "-compile-targets", "linux/amd64"}, "-compile-targets cannot be used with -goos or -goarch"},
+		{[]string{"-compile", "out", "-compile-targets", "linux"}, `invalid compile target "linux", expected GOOS/GOARCH`},
+	}
+	for _, tt := range tests {
+		_, _, err := Parse(ioutil.Discard, ioutil.Discard, tt.args)
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("args %q: expected error %q but got %v", tt.args, tt.err, err)
+		}
+	}
+}
+
+func TestParseStatus(t *testing.T) {
+	tests := map[string][]string{
+		"":      {"build"},
+		"true":  {"-status", "build"},
+		"false": {"-status=false", "build"},
+	}
+	for expected, args := range tests {
+		inv, _, err := Parse(ioutil.Discard, ioutil.Discard, args)
+		if err != nil {
+			t.Fatal("unexpected error", err)
+		}
+		if inv.Status != expected {
+			t.Errorf("args %q: expected status %q but got %q", args, expected, inv.Status)
+		}
+	}
+}
+
+func TestPlatformBinaryName(t *testing.T) {
+	tests := []struct {
+		path, goos, goarch, expected string
+	}{
+		{"bin/mage", "linux", "amd64", "bin/mage-linux-amd64"},
+		{"bin/mage", "windows", "amd64", "bin/mage-windows-amd64.exe"},
+		{"bin/mage.exe", "windows", "386", "bin/mage-windows-386.exe"},
+	}
+	for _, tt := range tests {
+		actual := PlatformBinaryName(tt.path, tt.goos, tt.goarch)
+		if actual != tt.expected {
+			t.Errorf("expected %q but got %q", tt.expected, actual)
+		}
+	}
+}
+
 func TestParseHelp(t *testing.T) {
 	buf := &bytes.Buffer{}
 	_, _, err := Parse(ioutil.Discard, buf, []string{"-h"})
@@ -1369,6 +1660,34 @@ func TestClean(t *testing.T) {
 	}
 }
 
+func TestRelativeCacheDir(t *testing.T) {
+	dir, err := ioutil.TempDir(".", "cache")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:      "./testdata/alias",
+		Stdout:   ioutil.Discard,
+		Stderr:   stderr,
+		CacheDir: filepath.Base(dir),
+		HashFast: true,
+		Args:     []string{"status"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected the compiled binary in the cache dir, but found %v files", len(files))
+	}
+}
+
 func TestGoCmd(t *testing.T) {
 	textOutput := "TestGoCmd"
 	defer os.Unsetenv(testExeEnv)
@@ -1588,3 +1907,2065 @@ func fileData(file string) (exeType, archSize, error) {
 	}
 	return -1, -1, fmt.Errorf("unrecognized executable format")
 }
+
+func TestBootstrap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stdout := &bytes.Buffer{}
+	code := ParseAndRun(stdout, ioutil.Discard, nil, []string{"-bootstrap", "-d", dir})
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	expected := "bootstrap.go created, add .mage/ to your .gitignore\n"
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+	b, err := ioutil.ReadFile(filepath.Join(dir, "bootstrap.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `const mageVersion = "latest"`) {
+		t.Fatalf("expected a dev build of mage to pin the latest version, got:\n%s", b)
+	}
+	// make sure the generated file is valid go.
+	cmd := exec.Command("go", "vet", "bootstrap.go")
+	cmd.Dir = dir
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("generated bootstrap.go doesn't vet: %v\n%s", err, out)
+	}
+}
+
+func TestProfiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:        "./testdata/parallel",
+		Stdout:     ioutil.Discard,
+		Stderr:     stderr,
+		Args:       []string{"fail"},
+		CPUProfile: filepath.Join(dir, "cpu.pprof"),
+		MemProfile: filepath.Join(dir, "mem.pprof"),
+		Trace:      filepath.Join(dir, "trace.out"),
+	}
+	// profiles must be written even when a target fails.
+	code := Invoke(inv)
+	if code != 3 {
+		t.Fatalf("expected to exit with code 3, but got %v, stderr:\n%s", code, stderr)
+	}
+	for _, name := range []string{"cpu.pprof", "mem.pprof", "trace.out"} {
+		info, err := os.Stat(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() == 0 {
+			t.Errorf("expected %s to have been written, but it is empty", name)
+		}
+	}
+}
+
+func TestTimestamps(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:        "./testdata/timestamps",
+		Stdout:     stdout,
+		Stderr:     stderr,
+		Verbose:    true,
+		Timestamps: true,
+		Args:       []string{"build"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	stamp := `\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{3}Z `
+	expectedOut := regexp.MustCompile("^" + stamp + `\[Build\] dep\n` + stamp + `\[Build\] build\n$`)
+	if !expectedOut.MatchString(stdout.String()) {
+		t.Errorf("expected stdout to match %s, but got:\n%s", expectedOut, stdout)
+	}
+	expectedErr := regexp.MustCompile("^" + stamp + "Running target: Build\n" + stamp + `\[Build\] Running dependency: Dep\n` + stamp + `\[Build\] warning\n$`)
+	if !expectedErr.MatchString(stderr.String()) {
+		t.Errorf("expected stderr to match %s, but got:\n%s", expectedErr, stderr)
+	}
+}
+
+func TestCustomLogger(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:     "./testdata/logger",
+		Stdout:  stdout,
+		Stderr:  stderr,
+		Verbose: true,
+		Args:    []string{"build", "fail"},
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+verbose Running target: Build
+start Build
+verbose Running dependency: Dep
+start Dep
+end Dep <nil>
+end Build <nil>
+verbose Running target: Fail
+start Fail
+end Fail oops
+error oops
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected:\n%s\n\ngot:\n%s", expected, actual)
+	}
+	if stderr.Len() != 0 {
+		t.Fatalf("expected no output on stderr, but got:\n%s", stderr)
+	}
+}
+
+func TestStatus(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/status",
+		Stdout: stdout,
+		Stderr: stderr,
+		Status: "true",
+		Args:   []string{"build", "fail"},
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	expectedErr := regexp.MustCompile(`^▶ Build\n✓ Build \(\d+(\.\d+)?[µm]?s\)\n▶ Fail\n✗ Fail \(\d+(\.\d+)?[µm]?s\)\nError: oops\n$`)
+	if !expectedErr.MatchString(stderr.String()) {
+		t.Errorf("expected stderr to match %s, but got:\n%s", expectedErr, stderr)
+	}
+	if actual, expected := stdout.String(), "building\n"; actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestStatusParallel(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:      "./testdata/status",
+		Stdout:   stdout,
+		Stderr:   stderr,
+		Status:   "true",
+		Parallel: true,
+		Args:     []string{"slow", "fast"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// the targets start at the same time, so either may be listed first.
+	expected := regexp.MustCompile(`^\[[A-Za-z,]*Slow[A-Za-z,]*\] slow started\n\[[A-Za-z,]*Fast[A-Za-z,]*\] fast done\n\[Slow\] slow done\n$`)
+	if !expected.MatchString(stdout.String()) {
+		t.Errorf("expected stdout to match %s, but got:\n%s", expected, stdout)
+	}
+}
+
+func TestStatusOff(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/status",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"build"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if stderr.Len() != 0 {
+		t.Errorf("expected no status when stderr isn't a terminal, but got:\n%s", stderr)
+	}
+}
+
+func TestGitHubActions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	summary := filepath.Join(dir, "summary.md")
+	os.Setenv("GITHUB_ACTIONS", "true")
+	os.Setenv("GITHUB_STEP_SUMMARY", summary)
+	defer os.Unsetenv("GITHUB_ACTIONS")
+	defer os.Unsetenv("GITHUB_STEP_SUMMARY")
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/github",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"build", "fail"},
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+::group::Build
+building
+::endgroup::
+::group::Fail
+::endgroup::
+::error file=magefile.go,line=16,title=Fail failed::magefile.go:16: 100%25 broken
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout:\n%s\ngot:\n%s", expected, actual)
+	}
+	b, err := ioutil.ReadFile(summary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedSummary := regexp.MustCompile(`^### mage\n\n\| Target \| Result \| Duration \|\n\| --- \| --- \| --- \|\n\| Build \| ✅ passed \| .+ \|\n\| Fail \| ❌ magefile.go:16: 100% broken \| .+ \|\n\n$`)
+	if !expectedSummary.Match(b) {
+		t.Errorf("expected summary to match %s, but got:\n%s", expectedSummary, b)
+	}
+}
+
+func TestJUnitReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "report.xml")
+
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/report",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+		Report: "junit=" + report,
+		Args:   []string{"build", "fail"},
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	b, err := ioutil.ReadFile(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := regexp.MustCompile(`^<\?xml version="1.0" encoding="UTF-8"\?>
+<testsuites>
+  <testsuite name="[^"]+" tests="2" failures="1" time="\d+\.\d{3}" timestamp="\d{4}-\d\d-\d\dT\d\d:\d\d:\d\d">
+    <testcase name="Build" classname="[^"]+" time="\d+\.\d{3}"></testcase>
+    <testcase name="Fail" classname="[^"]+" time="\d+\.\d{3}">
+      <failure message="&lt;oops&gt;" type="error">&lt;oops&gt;</failure>
+    </testcase>
+  </testsuite>
+</testsuites>
+$`)
+	if !expected.Match(b) {
+		t.Errorf("expected report to match %s, but got:\n%s", expected, b)
+	}
+}
+
+func TestParseReport(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-report", "junit=out.xml", "build"})
+	if err != nil {
+		t.Fatal("unexpected error", err)
+	}
+	if inv.Report != "junit=out.xml" {
+		t.Fatalf("expected report junit=out.xml but got %q", inv.Report)
+	}
+	tests := map[string]string{
+		"junit":       `invalid report "junit", expected format=file, e.g. junit=report.xml`,
+		"junit=":      `invalid report "junit=", expected format=file, e.g. junit=report.xml`,
+		"tap=out.tap": `unsupported report format "tap", the supported formats are: junit`,
+	}
+	for report, expected := range tests {
+		_, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-report", report})
+		if err == nil || err.Error() != expected {
+			t.Errorf("report %q: expected error %q but got %v", report, expected, err)
+		}
+	}
+}
+
+func TestDotenv(t *testing.T) {
+	os.Setenv("FROM_ENV", "environment")
+	defer os.Unsetenv("FROM_ENV")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/dotenv",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"env"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+NAME="world"
+GREETING="hello"
+QUOTED="two\nlines"
+LOCAL="only # here"
+FROM_ENV="environment"
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected:\n%s\n\ngot:\n%s", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.NoDotenv = true
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if !strings.Contains(stdout.String(), `NAME=""`) {
+		t.Fatalf("expected .env not to be loaded with NoDotenv, but got:\n%s", stdout)
+	}
+}
+
+func TestConfig(t *testing.T) {
+	os.Setenv("GREETING", "hi")
+	defer os.Unsetenv("GREETING")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/config",
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// the environment takes precedence over the config file.
+	expected := "building\nhi world # 1\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+	if !strings.Contains(stderr.String(), "verbose output") {
+		t.Errorf("expected verbose output from the config, but got:\n%s", stderr)
+	}
+}
+
+func TestConfigFlagsTakePrecedence(t *testing.T) {
+	cacheEnv := os.Getenv(mg.CacheEnv)
+	os.Unsetenv(mg.CacheEnv)
+	defer os.Setenv(mg.CacheEnv, cacheEnv)
+
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-d", "./testdata/config", "-v=false", "greet"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := applyConfig(&inv); err != nil {
+		t.Fatal(err)
+	}
+	if inv.Verbose {
+		t.Error("expected -v=false to override verbose in the config")
+	}
+	if !reflect.DeepEqual(inv.Args, []string{"greet"}) {
+		t.Errorf("expected the args to override the default targets in the config, but got %q", inv.Args)
+	}
+	if expected := filepath.Join("testdata", "config", "cache"); inv.CacheDir != expected {
+		t.Errorf("expected cache dir %q from the config, but got %q", expected, inv.CacheDir)
+	}
+	expectedEnv := []string{"GREETING=hello", "NAME=world # 1"}
+	if !reflect.DeepEqual(inv.configEnv, expectedEnv) {
+		t.Errorf("expected env %q, but got %q", expectedEnv, inv.configEnv)
+	}
+}
+
+func TestConfigInvalid(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/config/bad",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v", code)
+	}
+	expected := "Error reading config: " + filepath.Join("testdata", "config", "bad", ".mage.yaml") + `:1: verbose must be true or false, not "yes please"` + "\n"
+	if actual := stderr.String(); actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	cfg, err := parseConfig(bufio.NewScanner(strings.NewReader(`
+---
+parallel: true
+default: [build, "test"]
+telemetry: https://metrics.example.com/mage # opt in
+`)), ".mage.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := Config{Parallel: true, Default: []string{"build", "test"}, Telemetry: "https://metrics.example.com/mage"}
+	if !reflect.DeepEqual(cfg, expected) {
+		t.Errorf("expected %#v, but got %#v", expected, cfg)
+	}
+	tests := map[string]string{
+		"  verbose: true":          ".mage.yaml:1: unexpected indentation",
+		"verbose":                  ".mage.yaml:1: expected key: value",
+		"tags: slow":               `.mage.yaml:1: unknown setting "tags"`,
+		"env: FOO=bar":             ".mage.yaml:1: env must be a map of variables, one per line",
+		"default:\n  build":        ".mage.yaml:2: expected a list item for default",
+		"default: build\n  - test": ".mage.yaml:2: unexpected indentation",
+	}
+	for text, expected := range tests {
+		_, err := parseConfig(bufio.NewScanner(strings.NewReader(text)), ".mage.yaml")
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q, but got %v", text, expected, err)
+		}
+	}
+}
+
+func TestHooks(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"build", "build"}, 0, "setup\nbuilding\nbuilding\nteardown: <nil>\n", ""},
+		{[]string{"fail", "build"}, 1, "setup\nteardown: oops\n", "Error: oops\n"},
+		{[]string{"panic"}, 1, "setup\nteardown: boom\n", "Error: boom\n"},
+		{[]string{"build", "fail"}, 1, "setup\nbuilding\nteardown: oops\n", "Error: oops\n"},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/hooks",
+			Stdout: stdout,
+			Stderr: stderr,
+			Args:   tt.args,
+		}
+		if code := Invoke(inv); code != tt.code {
+			t.Errorf("%q: expected to exit with code %d, but got %v, stderr:\n%s", tt.args, tt.code, code, stderr)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("%q: expected stdout %q, but got %q", tt.args, tt.stdout, actual)
+		}
+		if actual := stderr.String(); actual != tt.stderr {
+			t.Errorf("%q: expected stderr %q, but got %q", tt.args, tt.stderr, actual)
+		}
+	}
+}
+
+func TestHooksNotListed(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/hooks",
+		Stdout: stdout,
+		Stderr: ioutil.Discard,
+		List:   true,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	if strings.Contains(strings.ToLower(stdout.String()), "magesetup") {
+		t.Errorf("expected MageSetup not to be listed as a target, but got:\n%s", stdout)
+	}
+	if strings.Contains(stdout.String(), "setup") {
+		t.Errorf("expected setup not to run when listing targets, but got:\n%s", stdout)
+	}
+}
+
+func TestHooksBadSignature(t *testing.T) {
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/hooks/bad",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+	}
+	if code := Invoke(inv); code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v", code)
+	}
+	expected := "MageTeardown must take the error from the run, e.g. func MageTeardown(ctx context.Context, err error)"
+	if !strings.Contains(stderr.String(), expected) {
+		t.Errorf("expected stderr to contain %q, but got:\n%s", expected, stderr)
+	}
+}
+
+func TestDeprecated(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/deprecated",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"build", "old"},
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stdout.String(), "building\n"; actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+	expected := "Warning: Build is deprecated, use buildAll\nWarning: Old is deprecated\n"
+	if actual := stderr.String(); actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.Args = nil
+	inv.List = true
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected = `
+Targets:
+  build       [deprecated] Builds everything, the old way.
+  buildAll    Builds everything.
+  old         [deprecated]
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = false
+	inv.Help = true
+	inv.Args = []string{"build"}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected = "Deprecated: use buildAll\n"
+	if !strings.Contains(stdout.String(), expected) {
+		t.Errorf("expected help to contain %q, but got:\n%s", expected, stdout)
+	}
+	if strings.Contains(stdout.String(), "mage:") {
+		t.Errorf("expected the directive not to be in the help, but got:\n%s", stdout)
+	}
+}
+
+func TestHidden(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/hidden",
+		Stdout: stdout,
+		Stderr: stderr,
+		List:   true,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := "Targets:\n  build    Builds everything.\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = false
+	inv.Args = []string{"installTools"}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stdout.String(), "installing tools\n"; actual != expected {
+		t.Errorf("expected hidden target to run, with stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestDefaultList(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/defaults/list",
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stdout.String(), "generating\nbuilding\n"; actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = true
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+Targets:
+  build*       
+  generate*    
+  test         
+
+* default targets
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%q\ngot:\n%q", expected, actual)
+	}
+}
+
+func TestDefaultDynamic(t *testing.T) {
+	os.Setenv("DEFAULT_TARGETS", "build:windows build:linux")
+	defer os.Unsetenv("DEFAULT_TARGETS")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/defaults/dynamic",
+		Stdout: stdout,
+		Stderr: stderr,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stdout.String(), "building for windows\nbuilding for linux\n"; actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+
+	os.Setenv("DEFAULT_TARGETS", "build:mac")
+	stdout.Reset()
+	stderr.Reset()
+	if code := Invoke(inv); code != 2 {
+		t.Fatalf("expected to exit with code 2, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stderr.String(), "Error choosing the default targets: Unknown target specified: build:mac\n"; actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+}
+
+func TestAliasDirective(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/alias_directive",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"bld"},
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual, expected := stdout.String(), "building\n"; actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.Args = nil
+	inv.List = true
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+Targets:
+  b        Builds the binaries.
+  bld      Builds the binaries.
+  build    Builds the binaries.
+  test     Runs the tests.
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = false
+	inv.Help = true
+	inv.Args = []string{"build"}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if expected := "Aliases: b, bld\n"; !strings.Contains(stdout.String(), expected) {
+		t.Errorf("expected help to contain %q, but got:\n%s", expected, stdout)
+	}
+}
+
+func TestAliasCollisions(t *testing.T) {
+	tests := map[string]string{
+		"collide": `alias "test" for <current>.Build duplicates existing target(s): <current>.Test`,
+		"twice":   `alias "B" is declared for both <current>.Build and <current>.Bench`,
+	}
+	for dir, expected := range tests {
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    filepath.Join("./testdata/alias_directive", dir),
+			Stdout: ioutil.Discard,
+			Stderr: stderr,
+			List:   true,
+		}
+		if code := Invoke(inv); code != 1 {
+			t.Errorf("%s: expected to exit with code 1, but got %v", dir, code)
+		}
+		if !strings.Contains(stderr.String(), expected) {
+			t.Errorf("%s: expected stderr to contain %q, but got:\n%s", dir, expected, stderr)
+		}
+	}
+}
+
+func TestSubdirs(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/subdirs",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"root", "api:build", "api:greet", "-name", "bob", "web"},
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// each magefile runs from its own directory.
+	expected := "root in subdirs\napi build in api\napi says hello to bob\nweb serve in web\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestSubdirsList(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/subdirs",
+		Stdout: stdout,
+		Stderr: stderr,
+		List:   true,
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+Targets:
+  root    Prints the directory the root magefile runs in.
+
+Targets from services/api:
+  api:build    Builds the api.
+  api:greet    Says hello to someone.
+
+Targets from services/web:
+  web:serve     Serves the website.
+`[1:]
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout:\n%s\nbut got:\n%s", expected, actual)
+	}
+}
+
+func TestPlugins(t *testing.T) {
+	resetTerm()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	exe := filepath.Join(dir, "pack")
+	if runtime.GOOS == "windows" {
+		exe += ".exe"
+	}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:        "./testdata/plugins/pack",
+		Stdout:     ioutil.Discard,
+		Stderr:     stderr,
+		CompileOut: exe,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	// files that aren't executable aren't plugins.
+	if err := ioutil.WriteFile(filepath.Join(dir, "README"), []byte("plugins"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	inv = Invocation{
+		Dir:     "./testdata/plugins/root",
+		Stdout:  stdout,
+		Stderr:  stderr,
+		Plugins: []string{filepath.Join(dir, "*")},
+		List:    true,
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `
+Targets:
+  build    Builds the project.
+
+Targets from `[1:] + filepath.ToSlash(exe) + `:
+  pack:deploy      Deploys to the given environment.
+  pack:rollback    Rolls back the last deploy.
+`
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout:\n%s\nbut got:\n%s", expected, actual)
+	}
+
+	stdout.Reset()
+	inv.List = false
+	inv.Args = []string{"build", "pack:deploy", "prod", "pack:rollback"}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected = "build\ndeploying to prod\nrolling back\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestSplitSubdirArgs(t *testing.T) {
+	api := subdir{namespace: "api", dir: "services/api"}
+	web := subdir{namespace: "web", dir: "services/web"}
+	subs := []subdir{api, web}
+	runs := splitSubdirArgs([]string{"lint", "API:test", "-v", "Web", "api:build"}, subs)
+	expected := []struct {
+		ns   string
+		args []string
+	}{
+		{"", []string{"lint"}},
+		{"api", []string{"test", "-v"}},
+		{"web", nil},
+		{"api", []string{"build"}},
+	}
+	if len(runs) != len(expected) {
+		t.Fatalf("expected %d runs, but got %d: %#v", len(expected), len(runs), runs)
+	}
+	for i, run := range runs {
+		ns := ""
+		if run.sub != nil {
+			ns = run.sub.namespace
+		}
+		if ns != expected[i].ns || !reflect.DeepEqual(run.args, expected[i].args) {
+			t.Errorf("expected run %d to be %q %q, but got %q %q", i, expected[i].ns, expected[i].args, ns, run.args)
+		}
+	}
+}
+
+func TestListParsed(t *testing.T) {
+	resetTerm()
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/list_parsed",
+		Stdout: stdout,
+		Stderr: stderr,
+		List:   true,
+		Force:  true,
+	}
+	code := Invoke(inv)
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := "Targets:\n  build    Builds the binaries.\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestListCompiled(t *testing.T) {
+	// -keep needs the binary, so the targets are listed by it.
+	buildFile := filepath.Join("testdata", "list_parsed", mainfile)
+	defer os.Remove(buildFile)
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/list_parsed",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+		List:   true,
+		Keep:   true,
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v", code)
+	}
+	if !strings.Contains(stderr.String(), "error compiling magefiles") {
+		t.Errorf("expected a compile error, but got:\n%s", stderr)
+	}
+}
+
+func TestVersionRequired(t *testing.T) {
+	defer func(tag string) { gitTag = tag }(gitTag)
+	gitTag = "v1.0.2"
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/version",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+		Args:   []string{"hello"},
+	}
+	code := Invoke(inv)
+	if code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v", code)
+	}
+	expected := "Error: this project requires mage >=v1.1.0, but this is mage v1.0.2, run mage -ensure to install it\n"
+	if actual := stderr.String(); actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+
+	gitTag = "v1.10.0"
+	stdout := &bytes.Buffer{}
+	inv.Stdout = stdout
+	stderr.Reset()
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if actual := stdout.String(); actual != "hello\n" {
+		t.Errorf("expected stdout %q, but got %q", "hello\n", actual)
+	}
+}
+
+func TestEnsureInstalled(t *testing.T) {
+	defer func(tag string) { gitTag = tag }(gitTag)
+	gitTag = "v1.2.0"
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	code := ParseAndRun(stdout, stderr, nil, []string{"-ensure", "-d", "./testdata/version"})
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := "mage v1.2.0 is already installed\n"
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, actual)
+	}
+}
+
+func TestVersionReq(t *testing.T) {
+	tests := []struct {
+		req     string
+		version string
+		allowed bool
+	}{
+		{"v1.15.0", "v1.15.0", true},
+		{"v1.15.0", "v1.15.1", false},
+		{">=v1.15.0", "v1.15.1", true},
+		{">= v1.15.0", "v1.16.0", true},
+		{">=v1.15.0", "v1.9.9", false},
+		{">=v1.15.0", "v2.0.0", true},
+	}
+	for _, tt := range tests {
+		r, err := parseVersionReq(tt.req)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.req, err)
+			continue
+		}
+		if allowed := r.allows(tt.version); allowed != tt.allowed {
+			t.Errorf("expected %s allowing %s to be %v, but got %v", tt.req, tt.version, tt.allowed, allowed)
+		}
+	}
+	if _, err := parseVersionReq("1.15"); err == nil {
+		t.Error("expected an error for an invalid version")
+	}
+}
+
+func TestExitCodes(t *testing.T) {
+	tests := []struct {
+		args []string
+		code int
+	}{
+		{[]string{"nope"}, 64},
+		{[]string{"fail"}, 3},
+		// errors with an exit status keep it.
+		{[]string{"fatal"}, 5},
+		{[]string{"slow"}, 124},
+	}
+	for _, tt := range tests {
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:           "./testdata/exitcodes",
+			Stdout:        ioutil.Discard,
+			Stderr:        stderr,
+			Args:          tt.args,
+			TargetTimeout: 10 * time.Millisecond,
+		}
+		if code := Invoke(inv); code != tt.code {
+			t.Errorf("%s: expected to exit with code %d, but got %d, stderr:\n%s", tt.args, tt.code, code, stderr)
+		}
+	}
+}
+
+func TestExitCodeCompileError(t *testing.T) {
+	inv := Invocation{
+		Dir:       "./testdata/list_parsed",
+		Stdout:    ioutil.Discard,
+		Stderr:    ioutil.Discard,
+		Args:      []string{"build"},
+		ExitCodes: ExitCodes{CompileError: 9},
+	}
+	if code := Invoke(inv); code != 9 {
+		t.Errorf("expected to exit with code 9, but got %d", code)
+	}
+}
+
+func TestParseConfigExitCodes(t *testing.T) {
+	_, err := parseConfig(bufio.NewScanner(strings.NewReader("exitCodes:\n  testFailure: 3\n")), ".mage.yaml")
+	expected := `.mage.yaml:2: unknown exit code "testFailure", expected one of: unknownTarget, compileError, targetFailure, timeout, interrupted`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+}
+
+func TestSummary(t *testing.T) {
+	tests := []struct {
+		args     []string
+		parallel bool
+		rows     []string
+		totals   string
+	}{
+		{
+			args:   []string{"build", "test", "lint"},
+			rows:   []string{"Build ok", "Test failed", "Lint skipped"},
+			totals: "3 targets: 1 ok, 1 failed, 1 skipped in ",
+		},
+		{
+			args:     []string{"build", "lint", "build"},
+			parallel: true,
+			rows:     []string{"Build ok", "Lint ok", "Build cached"},
+			totals:   "3 targets: 2 ok, 1 cached in ",
+		},
+	}
+	for _, tt := range tests {
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:      "./testdata/summary",
+			Stdout:   ioutil.Discard,
+			Stderr:   stderr,
+			Args:     tt.args,
+			Parallel: tt.parallel,
+			Summary:  true,
+		}
+		Invoke(inv)
+		out := stderr.String()
+		i := strings.Index(out, "Summary:\n")
+		if i < 0 {
+			t.Fatalf("%s: expected a summary, got:\n%s", tt.args, out)
+		}
+		lines := strings.Split(strings.TrimSpace(out[i:]), "\n")
+		if len(lines) != len(tt.rows)+2 {
+			t.Fatalf("%s: expected %d rows, got:\n%s", tt.args, len(tt.rows), out[i:])
+		}
+		for j, row := range lines[1 : len(lines)-1] {
+			// ignore the durations, and the order of targets run in parallel.
+			fields := strings.Fields(row)
+			found := false
+			for _, want := range tt.rows {
+				if strings.Join(fields[:2], " ") == want {
+					found = true
+				}
+			}
+			if !found || (!tt.parallel && strings.Join(fields[:2], " ") != tt.rows[j]) {
+				t.Errorf("%s: unexpected row %q, expected rows %q", tt.args, row, tt.rows)
+			}
+		}
+		if totals := lines[len(lines)-1]; !strings.HasPrefix(totals, tt.totals) {
+			t.Errorf("%s: expected totals to start with %q, got %q", tt.args, tt.totals, totals)
+		}
+	}
+
+	// the table is written before the pipe that timestamps stderr is closed.
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:        "./testdata/summary",
+		Stdout:     ioutil.Discard,
+		Stderr:     stderr,
+		Args:       []string{"build"},
+		Summary:    true,
+		Timestamps: true,
+	}
+	Invoke(inv)
+	if out := stderr.String(); !strings.Contains(out, "Summary:\n") || !strings.Contains(out, "1 target: 1 ok in ") {
+		t.Errorf("expected a summary with -timestamps, got:\n%s", out)
+	}
+}
+
+func TestTelemetry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "metrics.jsonl")
+	posted := make(chan []byte, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		posted <- b
+	}))
+	defer srv.Close()
+
+	type run struct {
+		Success   bool `json:"success"`
+		CacheHits int  `json:"cacheHits"`
+		Targets   []struct {
+			Name   string `json:"name"`
+			Status string `json:"status"`
+			Error  string `json:"error"`
+		} `json:"targets"`
+	}
+	invoke := func(telemetry string, parallel bool, args ...string) {
+		os.Setenv(mg.TelemetryEnv, telemetry)
+		defer os.Unsetenv(mg.TelemetryEnv)
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:      "./testdata/summary",
+			Stdout:   ioutil.Discard,
+			Stderr:   stderr,
+			Args:     args,
+			Parallel: parallel,
+		}
+		Invoke(inv)
+		if strings.Contains(stderr.String(), "telemetry") {
+			t.Errorf("%q: unexpected error sending telemetry:\n%s", args, stderr)
+		}
+	}
+
+	// runs are appended to the file.
+	invoke(path, false, "build", "test", "lint")
+	invoke(path, true, "build", "build")
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 runs, got:\n%s", b)
+	}
+	var r run
+	if err := json.Unmarshal([]byte(lines[0]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if r.Success || len(r.Targets) != 3 || r.Targets[1].Status != "failed" || r.Targets[1].Error != "tests failed" || r.Targets[2].Status != "skipped" {
+		t.Errorf("unexpected summary of a failed run: %s", lines[0])
+	}
+	r = run{}
+	if err := json.Unmarshal([]byte(lines[1]), &r); err != nil {
+		t.Fatal(err)
+	}
+	if !r.Success || r.CacheHits != 1 || len(r.Targets) != 2 || r.Targets[1].Status != "cached" {
+		t.Errorf("unexpected summary of a run with a cache hit: %s", lines[1])
+	}
+
+	// or posted to a URL.
+	invoke(srv.URL, false, "build")
+	select {
+	case b := <-posted:
+		r = run{}
+		if err := json.Unmarshal(b, &r); err != nil {
+			t.Fatal(err)
+		}
+		if !r.Success || len(r.Targets) != 1 || r.Targets[0].Name != "Build" || r.Targets[0].Status != "ok" {
+			t.Errorf("unexpected summary posted: %s", b)
+		}
+	default:
+		t.Error("expected the summary to be posted")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/events",
+		Stdout: ioutil.Discard,
+		Stderr: stderr,
+		Args:   []string{"build", "test"},
+		Events: path,
+	}
+	if code := Invoke(inv); code != 1 {
+		t.Fatalf("expected to exit with code 1, but got %v, stderr:\n%s", code, stderr)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(strings.TrimSpace(string(b)), "\n") {
+		var ev map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if _, ok := ev["time"]; !ok {
+			t.Errorf("event %q has no time", line)
+		}
+		desc := fmt.Sprint(ev["event"])
+		for _, field := range []string{"target", "command", "error"} {
+			if v, ok := ev[field]; ok {
+				desc += " " + fmt.Sprint(v)
+			}
+		}
+		got = append(got, desc)
+	}
+	expected := []string{
+		"run_start",
+		"target_start Build",
+		"target_start Generate",
+		"target_end Generate",
+		"exec " + mg.GoCmd(),
+		"target_end Build",
+		"target_start Test",
+		"target_end Test tests failed",
+		"error tests failed",
+		"run_end",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected events\n%q\nbut got\n%q", expected, got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	cacheDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(cacheDir)
+	inv := Invocation{
+		Dir:      "./testdata/serve",
+		WorkDir:  "./testdata/serve",
+		Stdout:   ioutil.Discard,
+		Stderr:   ioutil.Discard,
+		GoCmd:    "go",
+		CacheDir: cacheDir,
+	}
+	s := &server{inv: inv, errlog: log.New(ioutil.Discard, "", 0)}
+	if s.dir, err = filepath.Abs(inv.Dir); err != nil {
+		t.Fatal(err)
+	}
+	if s.settings, err = buildSettings(inv); err != nil {
+		t.Fatal(err)
+	}
+	sock, err := serveSocket(cacheDir, inv.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go s.serve(l)
+
+	exe, ok := askServer(inv)
+	if !ok || !fileExists(exe) {
+		t.Fatalf("expected the server to provide the exe, but got %q", exe)
+	}
+
+	// the binary is rebuilt when a magefile changes.
+	magefile := filepath.Join(inv.Dir, "magefile.go")
+	now := time.Now()
+	if err := os.Chtimes(magefile, now, now); err != nil {
+		t.Fatal(err)
+	}
+	if !s.stale() {
+		t.Fatal("expected the binary to be stale once the magefile changed")
+	}
+	if _, ok := askServer(inv); !ok {
+		t.Fatal("expected the server to provide the exe after the magefile changed")
+	}
+	if s.stale() {
+		t.Fatal("expected the binary to be up to date after asking the server")
+	}
+
+	stdout := &bytes.Buffer{}
+	inv.Stdout = stdout
+	inv.Args = []string{"hello"}
+	buf := &bytes.Buffer{}
+	debug.SetOutput(buf)
+	defer debug.SetOutput(ioutil.Discard)
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	if !strings.Contains(buf.String(), "running the exe from mage -serve") {
+		t.Errorf("expected mage to run the exe from the server, debug output:\n%s", buf)
+	}
+	if expected := "hello from the served binary\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, stdout)
+	}
+
+	// -f doesn't ask the server.
+	inv.Force = true
+	if _, ok := askServer(inv); ok {
+		t.Error("expected -f not to ask the server")
+	}
+}
+
+func TestRemote(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake ssh is a shell script")
+	}
+	fakessh, err := filepath.Abs("testdata/remote/fakessh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(mg.SSHEnv, fakessh)
+	defer os.Unsetenv(mg.SSHEnv)
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sshLog := filepath.Join(dir, "ssh.log")
+	os.Setenv("FAKESSH_LOG", sshLog)
+	defer os.Unsetenv("FAKESSH_LOG")
+	debugLog := &bytes.Buffer{}
+	debug.SetOutput(debugLog)
+	defer debug.SetOutput(ioutil.Discard)
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/remote",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"show", "it's me"},
+		Host:   "user@buildbox",
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	if expected := "copied, hi it's me, the secret is hunter2\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, stdout)
+	}
+	// the variables go over ssh's stdin, not on its command line.
+	args, err := ioutil.ReadFile(sshLog)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(args), "hunter2") || strings.Contains(string(args), "GREETING") {
+		t.Errorf("expected no variables in the ssh commands, but got:\n%s", args)
+	}
+	if strings.Contains(debugLog.String(), "hunter2") {
+		t.Errorf("expected no variables in the debug output, but got:\n%s", debugLog)
+	}
+}
+
+func TestRemotePlatform(t *testing.T) {
+	tests := []struct {
+		uname, goos, goarch string
+	}{
+		{"Linux x86_64", "linux", "amd64"},
+		{"Linux aarch64", "linux", "arm64"},
+		{"Darwin arm64", "darwin", "arm64"},
+		{"Linux armv7l", "linux", "arm"},
+	}
+	for _, tt := range tests {
+		goos, goarch, err := remotePlatform(tt.uname)
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.uname, err)
+			continue
+		}
+		if goos != tt.goos || goarch != tt.goarch {
+			t.Errorf("%q: expected %s/%s, but got %s/%s", tt.uname, tt.goos, tt.goarch, goos, goarch)
+		}
+	}
+	if _, _, err := remotePlatform("MINGW64_NT-10.0 x86_64"); err == nil {
+		t.Error("expected an error for an unsupported OS")
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	tests := map[string]string{
+		"build":      "build",
+		"FOO=bar":    "FOO=bar",
+		"it's me":    `'it'\''s me'`,
+		"":           "''",
+		"$HOME/*.go": "'$HOME/*.go'",
+	}
+	for s, expected := range tests {
+		if actual := shellQuote(s); actual != expected {
+			t.Errorf("shellQuote(%q): expected %s, but got %s", s, expected, actual)
+		}
+	}
+}
+
+func TestInContainer(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the fake docker runs the linux binary on this machine")
+	}
+	fakedocker, err := filepath.Abs("testdata/container/fakedocker")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv(mg.ContainerCmdEnv, fakedocker)
+	defer os.Unsetenv(mg.ContainerCmdEnv)
+	os.Setenv("FORWARDED", "there")
+	defer os.Unsetenv("FORWARDED")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:       "./testdata/container",
+		Stdout:    stdout,
+		Stderr:    stderr,
+		Args:      []string{"show"},
+		Container: "golang:latest",
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	wd, err := filepath.Abs(inv.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := wd + " hi there\n"; stdout.String() != expected {
+		t.Errorf("expected stdout %q, but got %q", expected, stdout)
+	}
+}
+
+func TestContainerArgs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("-in-container isn't supported on windows")
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := repoRoot(wd)
+	inv := Invocation{
+		Dir:          ".",
+		WorkDir:      ".",
+		Container:    "golang:1.21",
+		Args:         []string{"build"},
+		containerEnv: []string{"TOKEN"},
+	}
+	args, err := containerArgs(inv, "/cache/mage-abc", []string{"MAGEFILE_VERBOSE=1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"run", "--rm", "-i",
+		"-v", root + ":" + root,
+		"-v", "/cache:/mage-cache:ro",
+		"-w", wd,
+		"-e", "MAGEFILE_VERBOSE",
+		"-e", "TOKEN",
+		"golang:1.21", "/mage-cache/mage-abc", "build",
+	}
+	if !reflect.DeepEqual(args, expected) {
+		t.Errorf("expected args\n%q\nbut got\n%q", expected, args)
+	}
+
+	inv.WorkDir = filepath.Dir(root)
+	if _, err := containerArgs(inv, "/cache/mage-abc", nil); err == nil {
+		t.Error("expected an error for a working directory outside the repository")
+	}
+}
+
+func TestShim(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, ".mage.yaml"), []byte("mage: v1.15.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout := &bytes.Buffer{}
+	code := ParseAndRun(stdout, ioutil.Discard, nil, []string{"-shim", "-d", dir})
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	expected := "mage.cmd and mage.ps1 created, add .mage/ to your .gitignore\n"
+	if actual := stdout.String(); actual != expected {
+		t.Fatalf("expected %q, but got %q", expected, actual)
+	}
+	cmd, err := ioutil.ReadFile(filepath.Join(dir, "mage.cmd"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(cmd, []byte("set MAGE_VERSION=v1.15.0\r\n")) {
+		t.Errorf("expected mage.cmd to pin the version from the config with windows line endings, got:\n%q", cmd)
+	}
+	ps1, err := ioutil.ReadFile(filepath.Join(dir, "mage.ps1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(ps1, []byte("$mageVersion = 'v1.15.0'\n")) {
+		t.Errorf("expected mage.ps1 to pin the version from the config, got:\n%s", ps1)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	code := ParseAndRun(stdout, ioutil.Discard, nil, []string{"-completion", "powershell"})
+	if code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	if !strings.Contains(stdout.String(), "Register-ArgumentCompleter -Native -CommandName mage") {
+		t.Errorf("expected a powershell completion script, got:\n%s", stdout)
+	}
+
+	stderr := &bytes.Buffer{}
+	code = ParseAndRun(ioutil.Discard, stderr, nil, []string{"-completion", "fish"})
+	if code != 2 {
+		t.Fatalf("expected to exit with code 2, but got %v", code)
+	}
+	expected := "Error: unsupported shell \"fish\", the supported shells are: powershell\n"
+	if stderr.String() != expected {
+		t.Errorf("expected %q, but got %q", expected, stderr)
+	}
+}
+
+func TestCleanupsAndVariadicArgs(t *testing.T) {
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			// the server is stopped once the target that needs it is done.
+			args:   []string{"test", "echo", "x:", "a", "-b", "c"},
+			stdout: "starting server\ntesting\nstopping server\nx:a,-b,c\ncleaning up test\nteardown\n",
+		},
+		{
+			args:   []string{"fail", "test"},
+			code:   1,
+			stdout: "cleaning up fail\nteardown\n",
+			stderr: "Error: oops\n",
+		},
+		{
+			args:   []string{"echo", "--prefix", "p", "--args", "a", "--args", "b"},
+			stdout: "pa,b\nteardown\n",
+		},
+		{
+			args:   []string{"echo"},
+			code:   2,
+			stderr: "not enough arguments for target \"echo\", expected 1, got 0\n",
+		},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/cleanup",
+			Stdout: stdout,
+			Stderr: stderr,
+			Args:   tt.args,
+		}
+		if code := Invoke(inv); code != tt.code {
+			t.Errorf("%q: expected to exit with code %d, but got %v, stderr:\n%s", tt.args, tt.code, code, stderr)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("%q: expected stdout %q, but got %q", tt.args, tt.stdout, actual)
+		}
+		if actual := stderr.String(); actual != tt.stderr {
+			t.Errorf("%q: expected stderr %q, but got %q", tt.args, tt.stderr, actual)
+		}
+	}
+}
+
+func TestVariadicArgHelp(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/cleanup",
+		Stdout: stdout,
+		Stderr: ioutil.Discard,
+		Help:   true,
+		Args:   []string{"echo"},
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v", code)
+	}
+	expected := "echo [--prefix=<string>] [<args>...]"
+	if !strings.Contains(stdout.String(), expected) {
+		t.Errorf("expected help to contain %q, but got:\n%s", expected, stdout)
+	}
+}
+
+func TestTags(t *testing.T) {
+	tests := []struct {
+		only, skip []string
+		stdout     string
+	}{
+		{stdout: "build\ntest\npublish\nlint\n"},
+		{only: []string{"release"}, stdout: "build\npublish\n"},
+		{skip: []string{"slow"}, stdout: "build\nlint\n"},
+		{only: []string{"release"}, skip: []string{"slow"}, stdout: "build\n"},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:      "./testdata/tags",
+			Stdout:   stdout,
+			Stderr:   stderr,
+			Args:     []string{"build", "test", "publish", "lint"},
+			OnlyTags: tt.only,
+			SkipTags: tt.skip,
+		}
+		if code := Invoke(inv); code != 0 {
+			t.Fatalf("only %q, skip %q: expected to exit with code 0, but got %v, stderr:\n%s", tt.only, tt.skip, code, stderr)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("only %q, skip %q: expected stdout %q, but got %q", tt.only, tt.skip, tt.stdout, actual)
+		}
+	}
+}
+
+func TestListTags(t *testing.T) {
+	resetTerm()
+	tests := []struct {
+		only   []string
+		stdout string
+	}{
+		{
+			stdout: `
+Targets:
+  build      Builds the binaries. [release]
+  lint       
+  publish    Publishes the release. [release, slow]
+  test       Runs the integration tests. [ci, slow]
+`[1:],
+		},
+		{
+			only: []string{"ci"},
+			stdout: `
+Targets:
+  test    Runs the integration tests. [ci, slow]
+`[1:],
+		},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:      "./testdata/tags",
+			Stdout:   stdout,
+			Stderr:   ioutil.Discard,
+			List:     true,
+			OnlyTags: tt.only,
+		}
+		if code := Invoke(inv); code != 0 {
+			t.Fatalf("only %q: expected to exit with code 0, but got %v", tt.only, code)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("only %q: expected:\n%q\ngot:\n%q", tt.only, tt.stdout, actual)
+		}
+	}
+}
+
+func TestParseTags(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-only-tags", "release, docs", "-skip-tags", "slow,", "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"release", "docs"}; !reflect.DeepEqual(inv.OnlyTags, expected) {
+		t.Errorf("expected only tags %q, but got %q", expected, inv.OnlyTags)
+	}
+	if expected := []string{"slow"}; !reflect.DeepEqual(inv.SkipTags, expected) {
+		t.Errorf("expected skip tags %q, but got %q", expected, inv.SkipTags)
+	}
+}
+
+func TestPatterns(t *testing.T) {
+	tests := []struct {
+		args   []string
+		yes    bool
+		code   int
+		stdout string
+		stderr string
+	}{
+		{
+			args:   []string{"test..."},
+			yes:    true,
+			stdout: "test\ntestRace\n",
+		},
+		{
+			args:   []string{"docker:[bp]*", "test"},
+			yes:    true,
+			stdout: "docker:build\ndocker:push\ntest\n",
+		},
+		{
+			args:   []string{"docker:*"},
+			yes:    true,
+			code:   2,
+			stderr: "target \"docker:tag\" matched by \"docker:*\" needs arguments, so it must be run by name\n",
+		},
+		{
+			args:   []string{"nothing*"},
+			yes:    true,
+			code:   2,
+			stderr: "Unknown target specified: nothing*\n",
+		},
+		{
+			args:   []string{"test..."},
+			code:   1,
+			stderr: "The patterns match these targets:\n  test...: test, testRace\nError: use -yes to run the targets matched by a pattern when stdin isn't a terminal\n",
+		},
+	}
+	for _, tt := range tests {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/patterns",
+			Stdout: stdout,
+			Stderr: stderr,
+			Stdin:  strings.NewReader("y\n"),
+			Args:   tt.args,
+			Yes:    tt.yes,
+		}
+		if code := Invoke(inv); code != tt.code {
+			t.Errorf("%q: expected to exit with code %d, but got %v, stderr:\n%s", tt.args, tt.code, code, stderr)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("%q: expected stdout %q, but got %q", tt.args, tt.stdout, actual)
+		}
+		if actual := stderr.String(); actual != tt.stderr {
+			t.Errorf("%q: expected stderr %q, but got %q", tt.args, tt.stderr, actual)
+		}
+	}
+}
+
+func TestJobs(t *testing.T) {
+	for jobs, expected := range map[int]string{0: "max: 3\n", 1: "max: 1\n", 2: "max: 2\n"} {
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/jobs",
+			Stdout: stdout,
+			Stderr: stderr,
+			Args:   []string{"all"},
+			Jobs:   jobs,
+		}
+		if code := Invoke(inv); code != 0 {
+			t.Fatalf("-j %d: expected to exit with code 0, but got %v, stderr:\n%s", jobs, code, stderr)
+		}
+		if actual := stdout.String(); actual != expected {
+			t.Errorf("-j %d: expected %q, but got %q", jobs, expected, actual)
+		}
+	}
+}
+
+func TestParseJobs(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-j", "4", "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Jobs != 4 {
+		t.Errorf("expected 4 jobs, but got %d", inv.Jobs)
+	}
+	_, _, err = Parse(ioutil.Discard, ioutil.Discard, []string{"-j", "-1", "build"})
+	if err == nil || err.Error() != "-j can't be negative" {
+		t.Errorf("expected an error for -j -1, but got %v", err)
+	}
+}
+
+func TestCleanEnv(t *testing.T) {
+	os.Setenv("MAGE_TEST_AMBIENT", "ambient")
+	os.Setenv("MAGE_TEST_KEPT", "kept")
+	defer os.Unsetenv("MAGE_TEST_AMBIENT")
+	defer os.Unsetenv("MAGE_TEST_KEPT")
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:      "./testdata/cleanenv",
+		Stdout:   stdout,
+		Stderr:   stderr,
+		Args:     []string{"show"},
+		CleanEnv: true,
+		KeepEnv:  []string{"MAGE_TEST_KEPT"},
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `MAGE_TEST_AMBIENT=""
+MAGE_TEST_KEPT="kept"
+MAGE_TEST_DOTENV=""
+MAGEFILE_VERBOSE="0"
+`
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestInvokeEnv(t *testing.T) {
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/cleanenv",
+		Stdout: stdout,
+		Stderr: stderr,
+		Args:   []string{"show"},
+		Env:    []string{"MAGE_TEST_AMBIENT=given", "MAGE_TEST_DOTENV=over .env"},
+	}
+	if code := Invoke(inv); code != 0 {
+		t.Fatalf("expected to exit with code 0, but got %v, stderr:\n%s", code, stderr)
+	}
+	expected := `MAGE_TEST_AMBIENT="given"
+MAGE_TEST_KEPT=""
+MAGE_TEST_DOTENV="over .env"
+MAGEFILE_VERBOSE="0"
+`
+	if actual := stdout.String(); actual != expected {
+		t.Errorf("expected:\n%s\ngot:\n%s", expected, actual)
+	}
+}
+
+func TestParseCleanEnv(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-clean-env", "KEEP=PATH,HOME", "build"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !inv.CleanEnv || !reflect.DeepEqual(inv.KeepEnv, []string{"PATH", "HOME"}) {
+		t.Errorf("expected to keep PATH and HOME, but got %v %q", inv.CleanEnv, inv.KeepEnv)
+	}
+	for _, flag := range []string{"-host", "-in-container"} {
+		_, _, err = Parse(ioutil.Discard, ioutil.Discard, []string{"-clean-env", "KEEP=PATH", flag, "remote", "build"})
+		if expected := flag + " cannot be used with -clean-env"; err == nil || err.Error() != expected {
+			t.Errorf("expected %q, but got %v", expected, err)
+		}
+	}
+	_, _, err = Parse(ioutil.Discard, ioutil.Discard, []string{"-clean-env", "build"})
+	expected := `invalid -clean-env "build", expected KEEP=<vars>, e.g. KEEP=PATH,HOME`
+	if err == nil || err.Error() != expected {
+		t.Errorf("expected error %q, but got %v", expected, err)
+	}
+}
+
+// compileInterrupt compiles the magefile in testdata/interrupt, and returns
+// the binary and the func to remove it.  The binary goes in a temporary
+// directory outside testdata, so a run that's stopped can't leave it in the
+// tree.
+func compileInterrupt(t *testing.T) (name string, remove func()) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts can't be sent to processes on windows")
+	}
+	compileDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	name = filepath.Join(compileDir, "mage_out")
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:        "./testdata/interrupt",
+		Stdout:     ioutil.Discard,
+		Stderr:     stderr,
+		CompileOut: name,
+	}
+	if code := Invoke(inv); code != 0 {
+		os.RemoveAll(compileDir)
+		t.Fatalf("expected to exit with code 0, but got %v, stderr: %s", code, stderr)
+	}
+	return name, func() { os.RemoveAll(compileDir) }
+}
+
+func TestInterrupt(t *testing.T) {
+	name, remove := compileInterrupt(t)
+	defer remove()
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(name, "wait")
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r := bufio.NewReader(out)
+	if line, err := r.ReadString('\n'); line != "waiting\n" {
+		cmd.Process.Kill()
+		t.Fatalf("expected the target to start, but got %q, %v, stderr: %s", line, err, stderr)
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(r)
+	err = cmd.Wait()
+	if string(rest) != "stopping\n" {
+		t.Errorf("expected the target to stop cleanly, but got %q", rest)
+	}
+	if code := sh.ExitStatus(err); code != 130 {
+		t.Errorf("expected to exit with code 130, but got %v", code)
+	}
+	expected := "Interrupted, waiting for Wait to stop (interrupt again to exit now)\nError: interrupted\n"
+	if actual := stderr.String(); actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+}
+
+func TestInterruptGrace(t *testing.T) {
+	name, remove := compileInterrupt(t)
+	defer remove()
+	stderr := &bytes.Buffer{}
+	cmd := exec.Command(name, "ignore")
+	cmd.Env = append(os.Environ(), "MAGEFILE_INTERRUPT_GRACE=100ms")
+	cmd.Stderr = stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if line, err := bufio.NewReader(out).ReadString('\n'); line != "waiting\n" {
+		cmd.Process.Kill()
+		t.Fatalf("expected the target to start, but got %q, %v, stderr: %s", line, err, stderr)
+	}
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	err = cmd.Wait()
+	if code := sh.ExitStatus(err); code != 130 {
+		t.Errorf("expected to exit with code 130, but got %v", code)
+	}
+	expected := "Interrupted, waiting for Ignore to stop (interrupt again to exit now)\nIgnore didn't stop within 100ms, exiting anyway\nError: interrupted\n"
+	if actual := stderr.String(); actual != expected {
+		t.Errorf("expected stderr %q, but got %q", expected, actual)
+	}
+}
+
+// TestInterruptForwarded checks that mage passes an interrupt sent to it
+// alone, rather than to its process group, on to the binary.
+func TestInterruptForwarded(t *testing.T) {
+	name, remove := compileInterrupt(t)
+	defer remove()
+	r, w := io.Pipe()
+	stderr := &bytes.Buffer{}
+	inv := Invocation{
+		Dir:    "./testdata/interrupt",
+		Stdout: w,
+		Stderr: stderr,
+		Args:   []string{"wait"},
+	}
+	code := make(chan int)
+	go func() {
+		c := RunCompiled(inv, name, log.New(stderr, "", 0))
+		w.Close()
+		code <- c
+	}()
+	out := bufio.NewReader(r)
+	if line, err := out.ReadString('\n'); line != "waiting\n" {
+		t.Fatalf("expected the target to start, but got %q, %v, stderr: %s", line, err, stderr)
+	}
+	self, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := self.Signal(os.Interrupt); err != nil {
+		t.Fatal(err)
+	}
+	rest, _ := ioutil.ReadAll(out)
+	if c := <-code; c != 130 {
+		t.Errorf("expected to exit with code 130, but got %v, stderr: %s", c, stderr)
+	}
+	if string(rest) != "stopping\n" {
+		t.Errorf("expected the target to stop cleanly, but got %q", rest)
+	}
+}
+
+func TestGoWorkspace(t *testing.T) {
+	for _, env := range []string{"GOFLAGS", "GOWORK", "GOPROXY"} {
+		defer os.Setenv(env, os.Getenv(env))
+	}
+	os.Unsetenv("GOWORK")
+	os.Setenv("GOPROXY", "off")
+	dir := "./testdata/gowork/magefiles"
+	tests := []struct {
+		desc    string
+		goflags string // GOFLAGS in the environment
+		inv     Invocation
+		code    int
+		stdout  string
+	}{
+		{desc: "the enclosing workspace is used", stdout: "hello from the workspace\n"},
+		{
+			desc:    "GoFlags takes precedence over GOFLAGS",
+			goflags: "-mod=mod", // not allowed in workspace mode
+			inv:     Invocation{GoFlags: "-mod=readonly"},
+			stdout:  "hello from the workspace\n",
+		},
+		{desc: "GoWork turns the workspace off", inv: Invocation{GoWork: "off"}, code: 1},
+	}
+	for _, tt := range tests {
+		os.Setenv("GOFLAGS", tt.goflags)
+		stdout := &bytes.Buffer{}
+		stderr := &bytes.Buffer{}
+		inv := tt.inv
+		inv.Dir = dir
+		inv.Stdout = stdout
+		inv.Stderr = stderr
+		inv.Args = []string{"build"}
+		if code := Invoke(inv); code != tt.code {
+			t.Errorf("%s: expected to exit with code %d, but got %v, stderr:\n%s", tt.desc, tt.code, code, stderr)
+		}
+		if actual := stdout.String(); actual != tt.stdout {
+			t.Errorf("%s: expected stdout %q, but got %q", tt.desc, tt.stdout, actual)
+		}
+	}
+
+	// the binary is rebuilt when the workspace changes.
+	os.Setenv("GOFLAGS", "")
+	internal.SetGoEnv(nil)
+	deps, err := listDeps(Invocation{Dir: dir, GoCmd: "go"}, []string{"magefile.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	gowork, err := filepath.Abs("./testdata/gowork/go.work")
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := false
+	for _, dep := range deps {
+		found = found || dep == gowork
+	}
+	if !found {
+		t.Errorf("expected the dependencies to include %s, got %q", gowork, deps)
+	}
+}
+
+func TestLock(t *testing.T) {
+	held, err := lock.Repo("./testdata/jobs", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(mode string) (int, string) {
+		stderr := &bytes.Buffer{}
+		inv := Invocation{
+			Dir:    "./testdata/jobs",
+			Stdout: ioutil.Discard,
+			Stderr: stderr,
+			Args:   []string{"all"},
+			Lock:   mode,
+		}
+		return Invoke(inv), stderr.String()
+	}
+	code, stderr := run("fail")
+	if code != 1 || !strings.HasPrefix(stderr, "Error: ") || !strings.Contains(stderr, "mage.lock is locked by ") {
+		t.Fatalf("expected to fail on the lock, but got %d, stderr:\n%s", code, stderr)
+	}
+
+	time.AfterFunc(200*time.Millisecond, func() { held.Release() })
+	// lock.File says it's waiting, once.
+	logged := &bytes.Buffer{}
+	log.SetOutput(logged)
+	code, stderr = run("wait")
+	log.SetOutput(os.Stderr)
+	if code != 0 || strings.Count(logged.String()+stderr, "aiting for") != 1 || !strings.Contains(logged.String(), "waiting for the lock: ") {
+		t.Fatalf("expected to wait for the lock, but got %d, log:\n%s\nstderr:\n%s", code, logged, stderr)
+	}
+	// the run let the lock go.
+	l, err := lock.Repo("./testdata/jobs", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Release()
+}
+
+func TestParseLock(t *testing.T) {
+	inv, _, err := Parse(ioutil.Discard, ioutil.Discard, []string{"-lock", "wait", "deploy"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if inv.Lock != "wait" {
+		t.Errorf("expected to wait for the lock, but got %q", inv.Lock)
+	}
+	_, _, err = Parse(ioutil.Discard, ioutil.Discard, []string{"-lock", "queue", "deploy"})
+	if err == nil || err.Error() != `unsupported lock "queue", the supported values are: fail, wait` {
+		t.Errorf("expected an error for -lock queue, but got %v", err)
+	}
+}
@@ -0,0 +1,303 @@
+package mage
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// remoteExe is the name of the compiled magefile on the remote host.
+const remoteExe = "mage-remote"
+
+// remoteEnv is the name of the file on the remote host the variables for the
+// magefile are sourced from, so their values, which may be secrets from .env
+// files, never show up on a command line there or here.
+const remoteEnv = ".mage-env"
+
+// sshCmd returns the command mage runs ssh with, from MAGEFILE_SSH if it's
+// set, e.g. to give options like the port or identity file.
+func sshCmd() []string {
+	if cmd := strings.Fields(os.Getenv(mg.SSHEnv)); len(cmd) > 0 {
+		return cmd
+	}
+	return []string{"ssh"}
+}
+
+// sshCommand returns the command that runs the shell command on host.
+func sshCommand(host, command string) *exec.Cmd {
+	ssh := sshCmd()
+	args := append(ssh[1:len(ssh):len(ssh)], host, command)
+	return exec.Command(ssh[0], args...)
+}
+
+// sshOutput runs the shell command on host and returns its output.
+func sshOutput(host, command string) (string, error) {
+	c := sshCommand(host, command)
+	stderr := &bytes.Buffer{}
+	c.Stderr = stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("running %q on %s failed: %v: %s", command, host, err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// remotePlatform returns the GOOS and GOARCH for the output of uname -sm.
+func remotePlatform(uname string) (goos, goarch string, err error) {
+	fields := strings.Fields(uname)
+	if len(fields) != 2 {
+		return "", "", fmt.Errorf("unexpected output from uname -sm: %q", uname)
+	}
+	switch strings.ToLower(fields[0]) {
+	case "linux", "darwin", "freebsd", "openbsd", "netbsd":
+		goos = strings.ToLower(fields[0])
+	default:
+		return "", "", fmt.Errorf("unsupported remote OS %q", fields[0])
+	}
+	switch m := fields[1]; {
+	case m == "x86_64" || m == "amd64":
+		goarch = "amd64"
+	case m == "aarch64" || m == "arm64":
+		goarch = "arm64"
+	case m == "i386" || m == "i686":
+		goarch = "386"
+	case strings.HasPrefix(m, "armv"):
+		goarch = "arm"
+	case m == "ppc64le" || m == "s390x" || m == "riscv64":
+		goarch = m
+	default:
+		return "", "", fmt.Errorf("unsupported remote architecture %q", m)
+	}
+	return goos, goarch, nil
+}
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_./=:,@%+") == "" {
+		return s
+	}
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// remoteFiles returns the files matching the remoteFiles patterns from the
+// project config, relative to the magefile directory.  Directories are
+// included with everything in them.
+func remoteFiles(inv Invocation) ([]string, error) {
+	seen := map[string]bool{}
+	var files []string
+	for _, pattern := range inv.remoteFiles {
+		matches, err := filepath.Glob(filepath.Join(inv.Dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid remoteFiles pattern %q: %v", pattern, err)
+		}
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, fi os.FileInfo, err error) error {
+				if err != nil {
+					return err
+				}
+				if !fi.Mode().IsRegular() {
+					return nil
+				}
+				rel, err := filepath.Rel(inv.Dir, path)
+				if err != nil {
+					return err
+				}
+				if !seen[rel] {
+					seen[rel] = true
+					files = append(files, rel)
+				}
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// remoteEnvFile returns the script that exports the variables to the
+// magefile on the remote host.
+func remoteEnvFile(vars []string) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	for _, v := range vars {
+		parts := strings.SplitN(v, "=", 2)
+		if len(parts) != 2 || !isShellName(parts[0]) {
+			return nil, fmt.Errorf("variable %q can't be set on the remote host", parts[0])
+		}
+		fmt.Fprintf(buf, "export %s=%s\n", parts[0], shellQuote(parts[1]))
+	}
+	return buf.Bytes(), nil
+}
+
+// isShellName reports whether s can be the name of a variable in a POSIX
+// shell.
+func isShellName(s string) bool {
+	for i, r := range s {
+		switch {
+		case r == '_', 'a' <= r && r <= 'z', 'A' <= r && r <= 'Z':
+		case i > 0 && '0' <= r && r <= '9':
+		default:
+			return false
+		}
+	}
+	return s != ""
+}
+
+// writeRemoteArchive writes a tar archive of the compiled magefile, the
+// script with its variables, which only the user can read, and the files to
+// copy to the remote host.
+func writeRemoteArchive(w io.Writer, exePath string, env []byte, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+	add := func(path, name string, mode int64) error {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{Name: name, Mode: mode, Size: fi.Size(), ModTime: fi.ModTime()}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, f)
+		return err
+	}
+	if err := add(exePath, remoteExe, 0755); err != nil {
+		return err
+	}
+	hdr := &tar.Header{Name: remoteEnv, Mode: 0600, Size: int64(len(env)), ModTime: time.Now()}
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err := tw.Write(env); err != nil {
+		return err
+	}
+	for _, file := range files {
+		fi, err := os.Stat(filepath.Join(dir, file))
+		if err != nil {
+			return err
+		}
+		if err := add(filepath.Join(dir, file), filepath.ToSlash(file), int64(fi.Mode().Perm())); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
+
+// invokeRemote compiles the magefiles for the platform of inv.Host, copies
+// the binary and the files listed under remoteFiles in the project config to
+// a temporary directory there over ssh, and runs the targets in it.
+func invokeRemote(inv Invocation, errlog *log.Logger) int {
+	uname, err := sshOutput(inv.Host, "uname -sm")
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	goos, goarch, err := remotePlatform(uname)
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	debug.Printf("compiling for %s/%s to run on %s", goos, goarch, inv.Host)
+	files, err := remoteFiles(inv)
+	if err != nil {
+		errlog.Println("Error finding the files to copy:", err)
+		return 1
+	}
+
+	tmp, err := ioutil.TempDir("", "mage-remote")
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	defer os.RemoveAll(tmp)
+	compileInv := inv
+	compileInv.CompileOut = filepath.Join(tmp, remoteExe)
+	compileInv.GOOS = goos
+	compileInv.GOARCH = goarch
+	compileInv.List = false
+	compileInv.Keep = false
+	compileInv.KeepDebug = false
+	if exe, code := buildExe(compileInv, errlog); exe != "" || code != 0 {
+		return code
+	}
+
+	dir, err := sshOutput(inv.Host, "mktemp -d")
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	defer func() {
+		if _, err := sshOutput(inv.Host, "rm -rf "+shellQuote(dir)); err != nil {
+			errlog.Println("Error cleaning up:", err)
+		}
+	}()
+
+	vars, err := magefileEnv(inv, nil)
+	if err != nil {
+		errlog.Println("Error loading .env:", err)
+		return 1
+	}
+	env, err := remoteEnvFile(vars)
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+
+	debug.Printf("copying the binary and %d files to %s:%s", len(files), inv.Host, dir)
+	c := sshCommand(inv.Host, "tar -xf - -C "+shellQuote(dir))
+	stderr := &bytes.Buffer{}
+	c.Stderr = stderr
+	w, err := c.StdinPipe()
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	if err := c.Start(); err != nil {
+		errlog.Println("Error running ssh:", err)
+		return 1
+	}
+	archiveErr := writeRemoteArchive(w, compileInv.CompileOut, env, inv.Dir, files)
+	w.Close()
+	if err := c.Wait(); err != nil || archiveErr != nil {
+		if archiveErr != nil {
+			err = archiveErr
+		}
+		errlog.Printf("Error copying files to %s: %v: %s", inv.Host, err, strings.TrimSpace(stderr.String()))
+		return 1
+	}
+
+	// the variables are removed as soon as they're set, and the directory
+	// once the targets have run.
+	command := "cd " + shellQuote(dir) + " && . ./" + remoteEnv + " && rm " + remoteEnv + " && exec ./" + remoteExe
+	for _, arg := range inv.Args {
+		command += " " + shellQuote(arg)
+	}
+	debug.Println("running on", inv.Host+":", command)
+	c = sshCommand(inv.Host, command)
+	c.Stdin = inv.Stdin
+	c.Stdout = inv.Stdout
+	c.Stderr = inv.Stderr
+	err = c.Run()
+	if !sh.CmdRan(err) {
+		errlog.Printf("failed to run ssh: %v", err)
+	}
+	return sh.ExitStatus(err)
+}
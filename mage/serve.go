@@ -0,0 +1,271 @@
+package mage
+
+import (
+	"crypto/sha1"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// serveTimeout is how long mage waits for mage -serve to answer, which
+// includes compiling the magefiles if they've changed.
+const serveTimeout = 5 * time.Minute
+
+// serveRequest is what mage sends to mage -serve to ask for the binary to run.
+type serveRequest struct {
+	Dir      string   `json:"dir"`
+	Settings []string `json:"settings"`
+}
+
+// serveResponse is the answer from mage -serve, with either the path to the
+// up to date binary or why it couldn't provide one.
+type serveResponse struct {
+	Exe   string `json:"exe,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// serveSocket returns the path of the socket mage -serve listens on for the
+// magefiles in dir.
+func serveSocket(cacheDir, dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	// sockets have a short maximum path length, so the name is kept short.
+	name := fmt.Sprintf("serve-%x", sha1.Sum([]byte(abs)))[:len("serve-")+16] + ".sock"
+	return filepath.Join(cacheDir, name), nil
+}
+
+// askServer asks mage -serve, if it's running for inv.Dir, for the binary to
+// run, so mage doesn't need to check whether the magefiles have changed.  It
+// reports false if there's no server, or it couldn't provide the binary, in
+// which case mage compiles the magefiles as usual.
+func askServer(inv Invocation) (string, bool) {
+	if inv.CompileOut != "" || inv.Force || inv.Keep || inv.KeepDebug {
+		return "", false
+	}
+	sock, err := serveSocket(inv.CacheDir, inv.Dir)
+	if err != nil || !fileExists(sock) {
+		return "", false
+	}
+	req := serveRequest{}
+	if req.Dir, err = filepath.Abs(inv.Dir); err != nil {
+		return "", false
+	}
+	if req.Settings, err = buildSettings(inv); err != nil {
+		return "", false
+	}
+	conn, err := net.DialTimeout("unix", sock, time.Second)
+	if err != nil {
+		debug.Printf("error connecting to mage -serve: %v", err)
+		return "", false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(serveTimeout))
+	var resp serveResponse
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		debug.Printf("error asking mage -serve for the exe: %v", err)
+		return "", false
+	}
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		debug.Printf("error reading the answer from mage -serve: %v", err)
+		return "", false
+	}
+	if resp.Error != "" {
+		debug.Printf("mage -serve couldn't provide the exe: %s", resp.Error)
+		return "", false
+	}
+	return resp.Exe, true
+}
+
+// fileStamp is what the server remembers about a file to tell if it changed.
+type fileStamp struct {
+	size    int64
+	modTime time.Time
+}
+
+// server keeps the binary for a magefile directory up to date, and tells mage
+// where it is.
+type server struct {
+	inv      Invocation
+	errlog   *log.Logger
+	dir      string
+	settings []string
+
+	mu     sync.Mutex
+	exe    string
+	stamps map[string]fileStamp // the files the binary was compiled from
+}
+
+// serve runs mage -serve for inv.Dir until it's interrupted.
+func serve(inv Invocation, errlog *log.Logger) int {
+	out := log.New(inv.Stdout, "", 0)
+	inv.List = false
+	inv.Keep = false
+	inv.KeepDebug = false
+	inv.Force = false
+	s := &server{inv: inv, errlog: errlog}
+	var err error
+	if s.dir, err = filepath.Abs(inv.Dir); err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	if s.settings, err = buildSettings(inv); err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	sock, err := serveSocket(inv.CacheDir, inv.Dir)
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	if err := os.MkdirAll(inv.CacheDir, 0700); err != nil {
+		errlog.Println("Error creating the cache directory:", err)
+		return 1
+	}
+	if fileExists(sock) {
+		if conn, err := net.DialTimeout("unix", sock, time.Second); err == nil {
+			conn.Close()
+			errlog.Printf("Error: mage -serve is already running for %s", s.dir)
+			return 1
+		}
+		// left behind by a server that didn't exit cleanly.
+		os.Remove(sock)
+	}
+	l, err := net.Listen("unix", sock)
+	if err != nil {
+		errlog.Println("Error listening for invocations:", err)
+		return 1
+	}
+	defer os.Remove(sock)
+
+	if _, err := s.binary(); err != nil {
+		errlog.Println("Error:", err)
+	}
+	out.Printf("Serving the magefiles in %s, press Ctrl+C to stop", s.dir)
+
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(c)
+	go func() {
+		<-c
+		l.Close()
+	}()
+	s.serve(l)
+	return 0
+}
+
+// serve answers the requests on l until it's closed.
+func (s *server) serve(l net.Listener) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		go s.handle(conn)
+	}
+}
+
+// handle answers a request for the binary.
+func (s *server) handle(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(serveTimeout))
+	var req serveRequest
+	if err := json.NewDecoder(conn).Decode(&req); err != nil {
+		debug.Printf("error reading a request: %v", err)
+		return
+	}
+	var resp serveResponse
+	switch {
+	case req.Dir != s.dir:
+		resp.Error = fmt.Sprintf("serving the magefiles in %s, not %s", s.dir, req.Dir)
+	case !reflect.DeepEqual(req.Settings, s.settings):
+		resp.Error = fmt.Sprintf("serving a binary built with %q, not %q", s.settings, req.Settings)
+	default:
+		exe, err := s.binary()
+		if err != nil {
+			resp.Error = err.Error()
+		}
+		resp.Exe = exe
+	}
+	if err := json.NewEncoder(conn).Encode(resp); err != nil {
+		debug.Printf("error answering a request: %v", err)
+	}
+}
+
+// binary returns the path to the binary, compiling it first if the files it
+// was compiled from have changed.
+func (s *server) binary() (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.exe != "" && fileExists(s.exe) && !s.stale() {
+		return s.exe, nil
+	}
+	s.exe = ""
+	// the files are stamped before compiling, so changes made while it's
+	// compiling cause another rebuild.
+	stamps, err := s.stampFiles()
+	if err != nil {
+		return "", err
+	}
+	exe, _ := buildExe(s.inv, s.errlog)
+	if exe == "" {
+		return "", errors.New("error compiling the magefiles")
+	}
+	// compiling writes the mainfile to the directory, which changes it.
+	if fi, err := os.Stat(s.inv.Dir); err == nil {
+		stamps[s.inv.Dir] = fileStamp{fi.Size(), fi.ModTime()}
+	}
+	s.exe = exe
+	s.stamps = stamps
+	return exe, nil
+}
+
+// stampFiles returns the stamps of the magefiles, their directory, which
+// changes when magefiles are added or removed, and unless HashFast is set,
+// their dependencies.
+func (s *server) stampFiles() (map[string]fileStamp, error) {
+	files, err := Magefiles(s.inv.Dir, s.inv.GOOS, s.inv.GOARCH, s.inv.GoCmd, s.inv.Stderr, s.inv.Debug)
+	if err != nil {
+		return nil, err
+	}
+	paths := append([]string{s.inv.Dir}, files...)
+	if !s.inv.HashFast && len(files) > 0 {
+		deps, err := listDeps(s.inv, files)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, deps...)
+	}
+	stamps := map[string]fileStamp{}
+	for _, path := range paths {
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stamps[path] = fileStamp{fi.Size(), fi.ModTime()}
+	}
+	return stamps, nil
+}
+
+// stale reports whether any of the files the binary was compiled from have
+// changed.
+func (s *server) stale() bool {
+	for path, stamp := range s.stamps {
+		fi, err := os.Stat(path)
+		if err != nil || fi.Size() != stamp.size || !fi.ModTime().Equal(stamp.modTime) {
+			debug.Printf("%s has changed, rebuilding", path)
+			return true
+		}
+	}
+	return false
+}
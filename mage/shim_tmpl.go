@@ -0,0 +1,48 @@
+package mage
+
+// shimCmdTpl and shimPs1Tpl are the shims mage -shim writes, for cmd.exe and
+// PowerShell.  Like bootstrap.go, they install the pinned version of mage into
+// .mage/bin the first time they're run, and then run it with the arguments
+// they were given.
+var shimCmdTpl = `@echo off
+rem This file was generated by mage -shim.  It runs this project's magefiles
+rem with the pinned version of mage, installing it into .mage\bin the first
+rem time it's run.  Requires go 1.16 or later.
+setlocal
+rem Change this to upgrade mage for everyone working on the project.
+set MAGE_VERSION={{.Version}}
+set MAGE_DIR=%~dp0.mage\bin\%MAGE_VERSION%
+if not exist "%MAGE_DIR%\mage.exe" (
+  echo Installing mage %MAGE_VERSION% into %MAGE_DIR% 1>&2
+  set "GOBIN=%MAGE_DIR%"
+  go install github.com/magefile/mage@%MAGE_VERSION% || exit /b 1
+)
+"%MAGE_DIR%\mage.exe" %*
+exit /b %ERRORLEVEL%
+`
+
+var shimPs1Tpl = `# This file was generated by mage -shim.  It runs this project's magefiles
+# with the pinned version of mage, installing it into .mage/bin the first time
+# it's run.  Requires go 1.16 or later.
+
+# Change this to upgrade mage for everyone working on the project.
+$mageVersion = '{{.Version}}'
+
+$dir = Join-Path $PSScriptRoot ".mage/bin/$mageVersion"
+$exe = Join-Path $dir 'mage'
+if ($env:OS -eq 'Windows_NT') {
+    $exe += '.exe'
+}
+if (-not (Test-Path $exe)) {
+    [Console]::Error.WriteLine("Installing mage $mageVersion into $dir")
+    $gobin = $env:GOBIN
+    $env:GOBIN = $dir
+    & go install "github.com/magefile/mage@$mageVersion"
+    $env:GOBIN = $gobin
+    if ($LASTEXITCODE -ne 0) {
+        exit $LASTEXITCODE
+    }
+}
+& $exe @args
+exit $LASTEXITCODE
+`
@@ -0,0 +1,20 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package mage
+
+import (
+	"os"
+	"os/exec"
+)
+
+// ownProcessGroup reports false, since there are no process groups to
+// signal here.
+func ownProcessGroup(c *exec.Cmd) bool {
+	return false
+}
+
+// signalProcessGroup sends sig to p alone.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return p.Signal(sig)
+}
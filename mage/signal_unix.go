@@ -0,0 +1,22 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package mage
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// ownProcessGroup has c start a process group of its own, and reports whether
+// it can.
+func ownProcessGroup(c *exec.Cmd) bool {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	return true
+}
+
+// signalProcessGroup sends sig to the process group p leads.
+func signalProcessGroup(p *os.Process, sig os.Signal) error {
+	return syscall.Kill(-p.Pid, sig.(syscall.Signal))
+}
@@ -0,0 +1,280 @@
+package mage
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+// subdir is a directory listed in the subdirs setting of the project config,
+// whose targets are run from the root magefile directory as a namespace named
+// after the directory, or a plugin executable, whose targets are run as a
+// namespace named after the file.
+type subdir struct {
+	namespace string
+	dir       string
+	plugin    string // the plugin executable, for a plugin rather than a directory
+}
+
+// findSubdirs returns the directories matching the subdirs patterns from the
+// project config that contain magefiles.
+func findSubdirs(inv Invocation) ([]subdir, error) {
+	var subs []subdir
+	seen := map[string]string{}
+	for _, pattern := range inv.subdirs {
+		matches, err := filepath.Glob(filepath.Join(inv.Dir, filepath.FromSlash(pattern)))
+		if err != nil {
+			return nil, fmt.Errorf("invalid subdirs pattern %q: %v", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, dir := range matches {
+			if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+				continue
+			}
+			files, err := Magefiles(dir, inv.GOOS, inv.GOARCH, inv.GoCmd, inv.Stderr, inv.Debug)
+			if err != nil {
+				return nil, err
+			}
+			if len(files) == 0 {
+				continue
+			}
+			ns := strings.ToLower(filepath.Base(dir))
+			if prev, ok := seen[ns]; ok {
+				if prev == dir {
+					continue
+				}
+				return nil, fmt.Errorf("subdirectories %s and %s would both be the %s namespace", prev, dir, ns)
+			}
+			seen[ns] = dir
+			subs = append(subs, subdir{namespace: ns, dir: dir})
+		}
+	}
+	plugins, err := findPlugins(inv.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range plugins {
+		if prev, ok := seen[p.namespace]; ok {
+			return nil, fmt.Errorf("%s and the plugin %s would both be the %s namespace", prev, p.plugin, p.namespace)
+		}
+		seen[p.namespace] = p.plugin
+		subs = append(subs, p)
+	}
+	return subs, nil
+}
+
+// findPlugins returns the executables matching the plugin patterns.  A plugin
+// is an executable that lists its targets when run with -l, in the format of
+// mage -l, and runs the targets it's given along with their args, like a
+// binary compiled with mage -compile.
+func findPlugins(patterns []string) ([]subdir, error) {
+	var plugins []subdir
+	seen := map[string]bool{}
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid plugin pattern %q: %v", pattern, err)
+		}
+		sort.Strings(matches)
+		for _, path := range matches {
+			fi, err := os.Stat(path)
+			if err != nil || !fi.Mode().IsRegular() || seen[path] {
+				continue
+			}
+			if runtime.GOOS == "windows" {
+				if !strings.EqualFold(filepath.Ext(path), ".exe") {
+					continue
+				}
+			} else if fi.Mode()&0111 == 0 {
+				continue
+			}
+			seen[path] = true
+			name := filepath.Base(path)
+			ns := strings.ToLower(strings.TrimSuffix(name, filepath.Ext(name)))
+			plugins = append(plugins, subdir{namespace: ns, plugin: path})
+		}
+	}
+	return plugins, nil
+}
+
+// subdirRun is a run of consecutive args for the same magefile directory.
+// A nil sub is the root directory.
+type subdirRun struct {
+	sub  *subdir
+	args []string
+}
+
+// splitSubdirArgs groups args by the directory whose magefile runs them.  A
+// target named ns:target, or just ns for the default target, runs in that
+// subdirectory without the namespace, along with the args after it, up to the
+// next target in a subdirectory.
+func splitSubdirArgs(args []string, subs []subdir) []subdirRun {
+	var runs []subdirRun
+	for _, arg := range args {
+		sub, target := findSubdir(arg, subs)
+		if sub == nil && len(runs) > 0 {
+			// args after a target in a subdirectory are for that magefile.
+			sub = runs[len(runs)-1].sub
+		}
+		if len(runs) == 0 || runs[len(runs)-1].sub != sub {
+			runs = append(runs, subdirRun{sub: sub})
+		}
+		if target != "" {
+			runs[len(runs)-1].args = append(runs[len(runs)-1].args, target)
+		}
+	}
+	return runs
+}
+
+// findSubdir returns the subdirectory for a target named ns:target or just ns,
+// and the target without the namespace.  If the target isn't in a
+// subdirectory, it returns nil and the target unchanged.
+func findSubdir(target string, subs []subdir) (*subdir, string) {
+	for i := range subs {
+		ns := subs[i].namespace
+		if strings.EqualFold(target, ns) {
+			return &subs[i], ""
+		}
+		if len(target) > len(ns) && strings.EqualFold(target[:len(ns)+1], ns+":") {
+			return &subs[i], target[len(ns)+1:]
+		}
+	}
+	return nil, target
+}
+
+// invokeSubdirs runs inv when the project config lists subdirectories, or
+// there are plugins, with each subdirectory's or plugin's targets exposed as a
+// namespace.  Each run of targets is compiled and run by the magefile that
+// declares it, from its own directory, or run by the plugin, from the working
+// directory, one after the other.
+func invokeSubdirs(inv Invocation, errlog *log.Logger) int {
+	subs, err := findSubdirs(inv)
+	if err != nil {
+		errlog.Println("Error finding magefiles in subdirectories:", err)
+		return 1
+	}
+	files, err := Magefiles(inv.Dir, inv.GOOS, inv.GOARCH, inv.GoCmd, inv.Stderr, inv.Debug)
+	if err != nil {
+		errlog.Println("Error determining list of magefiles:", err)
+		return 1
+	}
+	hasRoot := len(files) > 0
+
+	if inv.List || (len(inv.Args) == 0 && !inv.Help && !hasRoot) {
+		return listSubdirs(inv, subs, hasRoot, errlog)
+	}
+
+	runs := splitSubdirArgs(inv.Args, subs)
+	if len(runs) == 0 {
+		runs = []subdirRun{{}}
+	}
+	if inv.Help || inv.Deps || inv.Graph {
+		// these only take a single target.
+		runs = runs[:1]
+	}
+	for _, run := range runs {
+		if run.sub == nil {
+			root := inv
+			root.Args = run.args
+			if code := invoke(root, errlog); code != 0 {
+				return code
+			}
+			continue
+		}
+		if run.sub.plugin != "" {
+			if code := runPlugin(inv, *run.sub, run.args, errlog); code != 0 {
+				return code
+			}
+			continue
+		}
+		if code := Invoke(subdirInvocation(inv, *run.sub, run.args)); code != 0 {
+			return code
+		}
+	}
+	return 0
+}
+
+// runPlugin runs args with the plugin, from the working directory, with the
+// same variables a compiled magefile gets.
+func runPlugin(inv Invocation, plugin subdir, args []string, errlog *log.Logger) int {
+	inv.Args = args
+	debug.Printf("running %s with the plugin %s", strings.Join(args, " "), plugin.plugin)
+	return RunCompiled(inv, plugin.plugin, errlog)
+}
+
+// subdirInvocation returns the invocation that runs args with the magefile in
+// sub, from that directory.
+func subdirInvocation(inv Invocation, sub subdir, args []string) Invocation {
+	inv.Dir = sub.dir
+	inv.WorkDir = sub.dir
+	inv.Args = args
+	inv.subdirs = nil
+	inv.Plugins = nil
+	inv.configEnv = nil
+	return inv
+}
+
+// listSubdirs lists the targets of the root magefile, if there is one,
+// followed by the targets of each subdirectory under its namespace.
+func listSubdirs(inv Invocation, subs []subdir, hasRoot bool, errlog *log.Logger) int {
+	inv.List = true
+	if hasRoot {
+		if code := invoke(inv, errlog); code != 0 {
+			return code
+		}
+	}
+	for _, sub := range subs {
+		out := &bytes.Buffer{}
+		path := sub.dir
+		if sub.plugin != "" {
+			pluginInv := inv
+			pluginInv.List = false
+			pluginInv.Stdout = out
+			if code := runPlugin(pluginInv, sub, []string{"-l"}, errlog); code != 0 {
+				return code
+			}
+			path = sub.plugin
+		} else {
+			subInv := subdirInvocation(inv, sub, nil)
+			subInv.Stdout = out
+			if code := Invoke(subInv); code != 0 {
+				return code
+			}
+		}
+		rel, err := filepath.Rel(inv.Dir, path)
+		if err != nil || strings.HasPrefix(rel, "..") {
+			rel = path
+		}
+		fmt.Fprint(inv.Stdout, namespaceList(out.String(), sub.namespace, filepath.ToSlash(rel)))
+	}
+	return 0
+}
+
+// namespaceList rewrites the output of mage -l for a subdirectory so each
+// target is shown under its namespace.  The default target isn't marked, since
+// it's run by the namespace alone.
+func namespaceList(list, ns, dir string) string {
+	b := &bytes.Buffer{}
+	inTargets := false
+	for _, line := range strings.Split(list, "\n") {
+		switch {
+		case line == "Targets:":
+			inTargets = true
+			fmt.Fprintf(b, "\nTargets from %s:\n", dir)
+		case inTargets && strings.HasPrefix(line, "  "):
+			line = line[2:]
+			if i := strings.IndexAny(line, " *"); i >= 0 && line[i] == '*' {
+				line = line[:i] + " " + line[i+1:]
+			}
+			fmt.Fprintf(b, "  %s:%s\n", ns, strings.TrimRight(line, " "))
+		case inTargets:
+			return b.String()
+		}
+	}
+	return b.String()
+}
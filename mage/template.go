@@ -8,29 +8,62 @@ var mageMainfileTplString = `// +build ignore
 package main
 
 import (
+	"bufio"
 	"context"
+	"encoding/json"
+	"encoding/xml"
 	"flag"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"path"
 	"path/filepath"
+	"runtime"
+	"runtime/pprof"
+	"runtime/trace"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"text/tabwriter"
 	"time"
 	{{range .Imports}}{{.UniqueName}} "{{.Path}}"
 	{{end}}
+	{{- if .Mg}}
+	magemg "github.com/magefile/mage/mg"
+	{{- end}}
 )
 
+{{define "depnode"}}{{printf "%q" (lowerFirst .TargetName)}}, []string{ {{range .Deps}}{{if .Target}}{{printf "%q" (lowerFirst .Target.TargetName)}}{{else}}{{printf "%q" (printf "%s()" .Name)}}{{end}}, {{end}}}, []string{ {{range .Commands}}{{printf "%q" .}}, {{end}}}{{end}}
 func main() {
 	// Use local types and functions in order to avoid name conflicts with additional magefiles.
 	type arguments struct {
 		Verbose       bool          // print out log statements
 		List          bool          // print out a list of targets
 		Help          bool          // print out help for a specific target
+		Deps          bool          // print out the dependency tree of a specific target
+		Graph         bool          // print out the dependency graph of a target, or of all of them
+		GraphFormat   string        // the format of the graph: dot, mermaid or tree
+		DryRun        bool          // print out what would run, without running it
+		CPUProfile    string        // write a cpu profile of the run to this file
+		MemProfile    string        // write a memory profile at the end of the run to this file
+		Trace         string        // write an execution trace of the run to this file
+		Report        string        // write a report of the targets run, as format=file
+		Events        string        // write events for the run to this file, as lines of JSON
+		Timestamps    bool          // prefix output with a timestamp and the running targets
+		Status        bool          // print the status of each target as it starts and finishes
+		Summary       bool          // print a summary of the targets run when they're done
+		Yes           bool          // run the targets matched by a pattern without asking
+		OnlyTags      string        // only run the targets with one of these comma separated tags
+		SkipTags      string        // don't run the targets with any of these comma separated tags
+		Parallel      bool          // run the targets concurrently
+		Jobs          int           // how many targets and commands may run at once, 0 for no limit
 		Timeout       time.Duration // set a timeout to running the targets
+		TargetTimeout time.Duration // set a timeout to running each target
 		Args          []string      // args contain the non-flag command-line arguments
 	}
 
@@ -59,6 +92,16 @@ func main() {
 		}
 		return d
 	}
+	// isTerminal reports whether f is a terminal rather than a file or pipe.
+	isTerminal := func(f *os.File) bool {
+		fi, err := f.Stat()
+		return err == nil && fi.Mode()&os.ModeCharDevice != 0
+	}
+	// target status is shown by default when someone is watching.
+	defaultStatus := isTerminal(os.Stderr)
+	if os.Getenv("MAGEFILE_STATUS") != "" {
+		defaultStatus = parseBool("MAGEFILE_STATUS")
+	}
 	args := arguments{}
 	fs := flag.FlagSet{}
 	fs.SetOutput(os.Stdout)
@@ -67,7 +110,30 @@ func main() {
 	fs.BoolVar(&args.Verbose, "v", parseBool("MAGEFILE_VERBOSE"), "show verbose output when running targets")
 	fs.BoolVar(&args.List, "l", parseBool("MAGEFILE_LIST"), "list targets for this binary")
 	fs.BoolVar(&args.Help, "h", parseBool("MAGEFILE_HELP"), "print out help for a specific target")
+	fs.BoolVar(&args.Deps, "deps", parseBool("MAGEFILE_DEPS"), "print out the dependency tree of a specific target")
+	fs.BoolVar(&args.Graph, "graph", parseBool("MAGEFILE_GRAPH"), "print out the dependency graph of a target, or of all targets")
+	graphFormat := os.Getenv("MAGEFILE_GRAPH_FORMAT")
+	if graphFormat == "" {
+		graphFormat = "tree"
+	}
+	fs.StringVar(&args.GraphFormat, "graph-format", graphFormat, "the format of the graph: dot, mermaid or tree")
+	fs.StringVar(&args.CPUProfile, "cpuprofile", os.Getenv("MAGEFILE_CPUPROFILE"), "write a cpu profile of the run to this file")
+	fs.StringVar(&args.MemProfile, "memprofile", os.Getenv("MAGEFILE_MEMPROFILE"), "write a memory profile at the end of the run to this file")
+	fs.StringVar(&args.Trace, "trace", os.Getenv("MAGEFILE_TRACE"), "write an execution trace of the run to this file")
+	fs.StringVar(&args.Report, "report", os.Getenv("MAGEFILE_REPORT"), "write a report of the targets run, as format=file, e.g. junit=report.xml")
+	fs.StringVar(&args.Events, "events", os.Getenv("MAGEFILE_EVENTS"), "write events for the run to this file, as lines of JSON")
+	fs.BoolVar(&args.Timestamps, "timestamps", parseBool("MAGEFILE_TIMESTAMPS"), "prefix each line of output with a timestamp and the running targets")
+	fs.BoolVar(&args.Status, "status", defaultStatus, "print the status of each target as it starts and finishes")
+	fs.BoolVar(&args.Summary, "summary", parseBool("MAGEFILE_SUMMARY"), "print a summary of the targets run when they're done")
+	fs.StringVar(&args.OnlyTags, "only-tags", os.Getenv("MAGEFILE_ONLY_TAGS"), "comma separated tags, only run the targets with one of them")
+	fs.StringVar(&args.SkipTags, "skip-tags", os.Getenv("MAGEFILE_SKIP_TAGS"), "comma separated tags, don't run the targets with any of them")
+	fs.BoolVar(&args.Yes, "yes", parseBool("MAGEFILE_YES"), "run the targets matched by a pattern without asking")
+	fs.BoolVar(&args.DryRun, "n", parseBool("MAGEFILE_DRYRUN"), "print out the targets and commands that would run, without running them")
+	fs.BoolVar(&args.Parallel, "p", parseBool("MAGEFILE_PARALLEL"), "run the given targets in parallel")
+	jobs, _ := strconv.Atoi(os.Getenv("MAGEFILE_JOBS"))
+	fs.IntVar(&args.Jobs, "j", jobs, "how many targets, dependencies and commands may run at once")
 	fs.DurationVar(&args.Timeout, "t", parseDuration("MAGEFILE_TIMEOUT"), "timeout in duration parsable format (e.g. 5m30s)")
+	fs.DurationVar(&args.TargetTimeout, "timeout-per-target", parseDuration("MAGEFILE_TARGET_TIMEOUT"), "timeout for each target in duration parsable format (e.g. 5m30s)")
 	fs.Usage = func() {
 		fmt.Fprintf(os.Stdout, ` + "`" + `
 %s [options] [target]
@@ -77,10 +143,54 @@ Commands:
   -h    show this help
 
 Options:
+  -cpuprofile <string>
+        write a cpu profile of the run to this file
+  -deps show the dependency tree of a target
+  -events <string>
+        write events for the run to this file, as lines of JSON, e.g. for
+        the start and end of each target
+  -graph
+        show the dependency graph of a target, or of all targets if none is
+        given
+  -graph-format <format>
+        the format of the graph shown with -graph, one of: dot, mermaid,
+        tree (default tree)
   -h    show description of a target
+  -j <int>
+        run at most this many targets, dependencies and commands with the
+        sh package at once
+  -memprofile <string>
+        write a memory profile at the end of the run to this file
+  -n    print the targets and commands that would run, without running them
+  -only-tags <string>
+        comma separated list of tags, only run the targets with one of them
+        and list only those targets
+  -p    run the given targets in parallel
+  -report <format=file>
+        write a report of the targets run to a file, the supported formats
+        are: junit (e.g. -report junit=report.xml)
+  -skip-tags <string>
+        comma separated list of tags, don't run or list the targets with any
+        of them
+  -status
+        print the status of each target as it starts and finishes, and
+        prefix the output of targets run with -p (default when stderr is a
+        terminal, use -status=false to turn it off)
+  -summary
+        print a table of the targets run, with the status and duration of
+        each, when they're done
   -t <string>
         timeout in duration parsable format (e.g. 5m30s)
+  -timeout-per-target <string>
+        timeout for each target in duration parsable format (e.g. 5m30s)
+  -timestamps
+        prefix each line of output with a timestamp and the running targets
+  -trace <string>
+        write an execution trace of the run to this file, with a region for
+        each target
   -v    show verbose output when running targets
+  -yes  run the targets matched by a pattern like docker:* or test...
+        without asking first
  ` + "`" + `[1:], filepath.Base(os.Args[0]))
 	}
 	if err := fs.Parse(os.Args[1:]); err != nil {
@@ -209,7 +319,9 @@ Options:
 	}
 
 	// store the color terminal variables, so that the detection isn't repeated for each target
-	var enableColorValue = enableColor() && terminalSupportsColor()
+	var enableColorValue = enableColor() && terminalSupportsColor() && os.Getenv("NO_COLOR") == ""
+	// stderr is colored whenever it's a terminal.
+	var stderrColorValue = isTerminal(os.Stderr) && terminalSupportsColor() && os.Getenv("NO_COLOR") == ""
 	var targetColorValue = targetColor()
 
 	printName := func(str string) string {
@@ -220,44 +332,129 @@ Options:
 		}
 	}
 
+	// canonical returns the name of the target the given name or alias refers
+	// to.
+	canonical := func(target string) string {
+		switch strings.ToLower(target) {
+		{{range $alias, $func := .Aliases}}
+			case "{{lower $alias}}":
+				return "{{$func.TargetName}}"
+		{{- end}}
+		}
+		return target
+	}
+
+	// targetTags are the tags of the targets with //mage:tags directives.
+	targetTags := map[string][]string{
+	{{- range .Funcs}}{{if .Tags}}
+		"{{lower .TargetName}}": {{printf "%#v" .Tags}},
+	{{- end}}{{end}}
+	{{- range .Imports}}
+		{{- range .Info.Funcs}}{{if .Tags}}
+		"{{lower .TargetName}}": {{printf "%#v" .Tags}},
+		{{- end}}{{end}}
+	{{- end}}
+	}
+	splitTags := func(s string) map[string]bool {
+		tags := map[string]bool{}
+		for _, tag := range strings.Split(s, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags[tag] = true
+			}
+		}
+		return tags
+	}
+	onlyTags, skipTags := splitTags(args.OnlyTags), splitTags(args.SkipTags)
+	// tagsMatch reports whether the target or alias is selected by -only-tags
+	// and -skip-tags: it must have one of the tags given with -only-tags, if
+	// any, and none of those given with -skip-tags.
+	tagsMatch := func(name string) bool {
+		tags := targetTags[strings.ToLower(canonical(name))]
+		only := len(onlyTags) == 0
+		for _, tag := range tags {
+			if skipTags[tag] {
+				return false
+			}
+			only = only || onlyTags[tag]
+		}
+		return only
+	}
+
 	list := func() error {
 		{{with .Description}}fmt.Println(` + "`{{.}}\n`" + `)
 		{{- end}}
-		{{- $default := .DefaultFunc}}
+		{{- $defaults := .Defaults}}
+		// targets with a //mage:hidden directive aren't listed, but can still
+		// be run.
 		targets := map[string]string{
-		{{- range .Funcs}}
-			"{{lowerFirst .TargetName}}{{if and (eq .Name $default.Name) (eq .Receiver $default.Receiver)}}*{{end}}": {{printf "%q" .Synopsis}},
-		{{- end}}
-		{{- range .Imports}}{{$imp := .}}
-			{{- range .Info.Funcs}}
-			"{{lowerFirst .TargetName}}{{if and (eq .Name $default.Name) (eq .Receiver $default.Receiver)}}*{{end}}": {{printf "%q" .Synopsis}},
+		{{- range .Funcs}}{{if not .IsHidden}}
+			"{{lowerFirst .TargetName}}{{if isDefault . $defaults}}*{{end}}": {{printf "%q" (synopsis .)}},
+		{{- end}}{{end}}
+		{{- range .Funcs}}{{if not .IsHidden}}{{$synopsis := synopsis .}}
+			{{- range .Aliases}}
+			"{{.}}": {{printf "%q" $synopsis}},
 			{{- end}}
+		{{- end}}{{end}}
+		{{- range .Imports}}{{$imp := .}}
+			{{- range .Info.Funcs}}{{if not .IsHidden}}
+			"{{lowerFirst .TargetName}}{{if isDefault . $defaults}}*{{end}}": {{printf "%q" (synopsis .)}},
+			{{- end}}{{end}}
 		{{- end}}
 		}
 
 		keys := make([]string, 0, len(targets))
 		for name := range targets {
-			keys = append(keys, name)
+			if tagsMatch(strings.TrimSuffix(name, "*")) {
+				keys = append(keys, name)
+			}
 		}
 		sort.Strings(keys)
 
 		fmt.Println("Targets:")
 		w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
 		for _, name := range keys {
-			fmt.Fprintf(w, "  %v\t%v\n", printName(name), targets[name])
+			synopsis := targets[name]
+			if tags := targetTags[strings.ToLower(canonical(strings.TrimSuffix(name, "*")))]; len(tags) > 0 {
+				synopsis = strings.TrimSpace(synopsis + " [" + strings.Join(tags, ", ") + "]")
+			}
+			fmt.Fprintf(w, "  %v\t%v\n", printName(name), synopsis)
 		}
 		err := w.Flush()
-		{{- if .DefaultFunc.Name}}
+		{{- if .Defaults}}
 			if err == nil {
-				fmt.Println("\n* default target")
+				fmt.Println("\n* default target{{if gt (len .Defaults) 1}}s{{end}}")
 			}
 		{{- end}}
+		{{- if .ExternalImports}}
+		if err == nil {
+			fmt.Println("\nImported from other modules:")
+			{{- range .ExternalImports}}
+			fmt.Println({{printf "%q" (printf "  %s" .Origin)}}{{if .Alias}} + {{printf "%q" (printf " (as %s)" .Alias)}}{{end}})
+			{{- end}}
+		}
+		{{- end}}
 		return err
 	}
 
 	var ctx context.Context
 	var ctxCancel func()
 
+	// exitCodes are the codes to exit with for each kind of failure, which
+	// may be changed with MAGEFILE_EXIT_CODES, e.g. "timeout=124".
+	exitCodes := map[string]int{"unknownTarget": 2, "targetFailure": 1, "timeout": 1, "interrupted": 130}
+	for _, kv := range strings.Split(os.Getenv("MAGEFILE_EXIT_CODES"), ",") {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		if code, err := strconv.Atoi(parts[1]); err == nil {
+			exitCodes[parts[0]] = code
+		}
+	}
+	// timeouts are the errors of targets that ran past -timeout-per-target.
+	var timeoutsMu sync.Mutex
+	var timeouts []interface{}
+
 	getContext := func() (context.Context, func()) {
 		if ctx != nil {
 			return ctx, ctxCancel
@@ -266,32 +463,321 @@ Options:
 		if args.Timeout != 0 {
 			ctx, ctxCancel = context.WithTimeout(context.Background(), args.Timeout)
 		} else {
-			ctx = context.Background()
-			ctxCancel = func() {}
+			// it's cancelled when the run is interrupted.
+			ctx, ctxCancel = context.WithCancel(context.Background())
 		}
 		return ctx, ctxCancel
 	}
 
-	runTarget := func(fn func(context.Context) error) interface{} {
-		var err interface{}
-		ctx, cancel := getContext()
+	// running holds the names of the targets being run, to prefix their output.
+	var runningMu sync.Mutex
+	var running []string
+	runningTargets := func() string {
+		runningMu.Lock()
+		defer runningMu.Unlock()
+		return strings.Join(running, ",")
+	}
+	_ = runningTargets
+	// outputSyncs wait for the output written so far to be prefixed, so that
+	// it's attributed to the target that wrote it.
+	var outputSyncs []func()
+
+	syncOutput := func() {
+		for _, sync := range outputSyncs {
+			sync()
+		}
+	}
+
+	logger := log.New(os.Stderr, "", 0)
+
+	// these log the progress of the run.  They're replaced by the logger the
+	// magefile registered with mg.SetLogger, if any.
+	logVerbose := func(msg string) {
+		if args.Verbose {
+			logger.Println(msg)
+		}
+	}
+	logError := func(err interface{}) {
+		logger.Printf("Error: %+v\n", err)
+	}
+	// by default, the start and finish of each target is shown with -status.
+	statusLine := func(c color, symbol, msg string) {
+		if stderrColorValue {
+			symbol = ansiColor[c] + symbol + ansiColorReset
+		}
+		logger.Println(symbol + " " + msg)
+	}
+
+	// when running in GitHub Actions, each target's output is put in a
+	// collapsible group, failures are annotated, and a summary of the run is
+	// added to the job.  Workflow commands must start at the beginning of a
+	// line, so they're written to stdout directly, even with -timestamps.
+	githubActions := os.Getenv("GITHUB_ACTIONS") == "true"
+	if os.Getenv("MAGEFILE_GITHUB_ACTIONS") != "" {
+		githubActions = parseBool("MAGEFILE_GITHUB_ACTIONS")
+	}
+	githubOut := os.Stdout
+	// groups can't be nested or interleaved, so targets run in parallel
+	// aren't grouped.
+	githubGroups := githubActions
+	// githubEscape escapes the data of a workflow command, or with props set,
+	// the value of one of its properties.
+	githubEscape := func(s string, props bool) string {
+		s = strings.Replace(s, "%", "%25", -1)
+		s = strings.Replace(s, "\r", "%0D", -1)
+		s = strings.Replace(s, "\n", "%0A", -1)
+		if props {
+			s = strings.Replace(s, ":", "%3A", -1)
+			s = strings.Replace(s, ",", "%2C", -1)
+		}
+		return s
+	}
+	// githubLocation returns the file and line an error message starts with,
+	// if any, as it does for errors from the go tools, e.g. "main.go:12: ...".
+	githubLocation := func(msg string) (file, line string) {
+		i := strings.Index(msg, ".go:")
+		if i < 0 || strings.ContainsAny(msg[:i], " \t\n") {
+			return "", ""
+		}
+		rest := msg[i+len(".go:"):]
+		j := 0
+		for j < len(rest) && rest[j] >= '0' && rest[j] <= '9' {
+			j++
+		}
+		if j == 0 || j == len(rest) || rest[j] != ':' {
+			return "", ""
+		}
+		return msg[:i+len(".go")], rest[:j]
+	}
+	// emitEvent writes an event to the file given with -events, as a line of
+	// JSON.  mg.EmitEvent appends the events for dependencies and commands
+	// to the same file.
+	var eventsMu sync.Mutex
+	var events *os.File
+	if args.Events != "" {
+		f, err := os.OpenFile(args.Events, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+		if err != nil {
+			logger.Printf("Error opening the events file: %v\n", err)
+			os.Exit(2)
+		}
+		events = f
+	}
+	emitEvent := func(event string, fields map[string]interface{}) {
+		if events == nil {
+			return
+		}
+		if fields == nil {
+			fields = map[string]interface{}{}
+		}
+		fields["time"] = time.Now().UTC().Format(time.RFC3339Nano)
+		fields["event"] = event
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return
+		}
+		eventsMu.Lock()
+		defer eventsMu.Unlock()
+		events.Write(append(b, '\n'))
+	}
+
+	// targetResult is how a target run by the mainfile finished, for the
+	// GitHub Actions job summary and -report.
+	type targetResult struct {
+		name     string
+		err      interface{}
+		duration time.Duration
+	}
+	var resultsMu sync.Mutex
+	var results []targetResult
+
+	recordResult := func(name string, err interface{}, d time.Duration) {
+		resultsMu.Lock()
+		defer resultsMu.Unlock()
+		results = append(results, targetResult{name, err, d})
+	}
+
+	logTargetStart := func(name string) {
+		if githubGroups {
+			fmt.Fprintf(githubOut, "::group::%s\n", githubEscape(name, false))
+		}
+		if args.Status {
+			statusLine(blue, "▶", name)
+		}
+	}
+	logTargetEnd := func(name string, err interface{}, d time.Duration) {
+		if githubActions {
+			if githubGroups {
+				fmt.Fprintln(githubOut, "::endgroup::")
+			}
+			if err != nil {
+				msg := fmt.Sprint(err)
+				props := "title=" + githubEscape(name+" failed", true)
+				if file, line := githubLocation(msg); file != "" {
+					props = "file=" + githubEscape(file, true) + ",line=" + line + "," + props
+				}
+				fmt.Fprintf(githubOut, "::error %s::%s\n", props, githubEscape(msg, false))
+			}
+		}
+		if !args.Status {
+			return
+		}
+		msg := fmt.Sprintf("%s (%v)", name, d.Round(time.Millisecond))
+		if err != nil {
+			statusLine(red, "✗", msg)
+		} else {
+			statusLine(green, "✓", msg)
+		}
+	}
+	// toError returns a target's error or the value it panicked with as an
+	// error.
+	toError := func(err interface{}) error {
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(error); ok {
+			return e
+		}
+		return fmt.Errorf("%v", err)
+	}
+	{{- if .Mg.CurrentLogger}}
+	if l := magemg.CurrentLogger(); l != nil {
+		logVerbose = func(msg string) {
+			if args.Verbose {
+				l.Verbose(msg)
+			}
+		}
+		logError = func(err interface{}) {
+			l.Error(toError(err))
+		}
+		logTargetStart = l.TargetStart
+		logTargetEnd = func(name string, err interface{}, d time.Duration) {
+			l.TargetEnd(name, toError(err), d)
+		}
+	}
+	{{- end}}
+	_, _ = logVerbose, toError
+	if events != nil {
+		logErr := logError
+		logError = func(err interface{}) {
+			emitEvent("error", map[string]interface{}{"error": fmt.Sprint(err)})
+			logErr(err)
+		}
+	}
+
+	// runJob runs a target once it's allowed to with -j.  mg provides it when
+	// the magefile uses it, so targets, their dependencies and the commands
+	// they run count against the same limit, and the dependencies a target
+	// runs with its context are cleaned up once it has finished.
+	runJob := func(ctx context.Context, fn func(context.Context)) { fn(ctx) }
+	{{- if .Mg.RunTarget}}
+	runJob = magemg.RunTarget
+	{{- end}}
+	if args.Jobs > 0 {
+		// mg and sh read the limit from the environment.
+		os.Setenv("MAGEFILE_JOBS", strconv.Itoa(args.Jobs))
+		{{- if not .Mg.RunTarget}}
+		slots := make(chan struct{}, args.Jobs)
+		runJob = func(ctx context.Context, fn func(context.Context)) {
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			fn(ctx)
+		}
+		{{- end}}
+	}
+
+	// interrupted is closed when the run is interrupted with Ctrl+C or SIGTERM.
+	interrupted := make(chan struct{})
+	isInterrupted := func() bool {
+		select {
+		case <-interrupted:
+			return true
+		default:
+			return false
+		}
+	}
+	errInterrupted := fmt.Errorf("interrupted")
+	// interruptGrace is how long a target has to stop once the run is
+	// interrupted, in case it doesn't take its context.
+	interruptGrace := parseDuration("MAGEFILE_INTERRUPT_GRACE")
+	if interruptGrace <= 0 {
+		interruptGrace = 10 * time.Second
+	}
+
+	runTarget := func(name string, fn func(context.Context) error) (err interface{}) {
+		syncOutput()
+		runningMu.Lock()
+		running = append(running, name)
+		runningMu.Unlock()
+		logTargetStart(name)
+		emitEvent("target_start", map[string]interface{}{"target": name})
+		start := time.Now()
+		defer func() {
+			d := time.Since(start)
+			recordResult(name, err, d)
+			logTargetEnd(name, err, d)
+			end := map[string]interface{}{"target": name, "duration": d.Seconds()}
+			if err != nil {
+				end["error"] = fmt.Sprint(err)
+			}
+			emitEvent("target_end", end)
+		}()
+		defer func() {
+			syncOutput()
+			runningMu.Lock()
+			defer runningMu.Unlock()
+			for i, n := range running {
+				if n == name {
+					running = append(running[:i], running[i+1:]...)
+					break
+				}
+			}
+		}()
+		// the context is shared by all targets in the run, so it must not be
+		// cancelled when a single target finishes.
+		runCtx, _ := getContext()
+		ctx := runCtx
+		if args.TargetTimeout > 0 {
+			var cancel func()
+			ctx, cancel = context.WithTimeout(runCtx, args.TargetTimeout)
+			defer cancel()
+		}
 		d := make(chan interface{})
 		go func() {
 			defer func() {
 				err := recover()
 				d <- err
 			}()
-			err := fn(ctx)
+			var err error
+			trace.WithRegion(ctx, name, func() {
+				runJob(ctx, func(ctx context.Context) { err = fn(ctx) })
+			})
 			d <- err
 		}()
 		select {
 		case <-ctx.Done():
-			cancel()
-			e := ctx.Err()
-			fmt.Printf("ctx err: %v\n", e)
-			return e
+			if isInterrupted() {
+				// the target is given the chance to stop cleanly, but not
+				// forever.
+				select {
+				case <-d:
+				case <-time.After(interruptGrace):
+					logger.Printf("%s didn't stop within %s, exiting anyway", name, interruptGrace)
+				}
+				return errInterrupted
+			}
+			if runCtx.Err() == nil {
+				// only this target's deadline has passed, not the whole run's.
+				// The error names the target, so it's only printed once it's
+				// returned.
+				e := fmt.Errorf("target %q timed out after %s", name, args.TargetTimeout)
+				timeoutsMu.Lock()
+				timeouts = append(timeouts, e)
+				timeoutsMu.Unlock()
+				return e
+			}
+			fmt.Printf("ctx err: %v\n", ctx.Err())
+			return ctx.Err()
 		case err = <-d:
-			cancel()
 			return err
 		}
 	}
@@ -299,19 +785,153 @@ Options:
 	// variable error.
 	_ = runTarget
 
-	handleError := func(logger *log.Logger, err interface{}) {
+	// exitCode returns the code to exit with when a target fails with err.
+	// Errors with an exit status of their own, like a command that failed,
+	// keep it.
+	exitCode := func(err interface{}) int {
+		if isInterrupted() {
+			return exitCodes["interrupted"]
+		}
+		if c, ok := err.(interface{ ExitStatus() int }); ok {
+			return c.ExitStatus()
+		}
+		timedOut := err == context.DeadlineExceeded || (ctx != nil && ctx.Err() == context.DeadlineExceeded)
+		timeoutsMu.Lock()
+		for _, e := range timeouts {
+			// errors may not be comparable, so they aren't map keys.
+			timedOut = timedOut || err == e
+		}
+		timeoutsMu.Unlock()
+		if timedOut {
+			return exitCodes["timeout"]
+		}
+		return exitCodes["targetFailure"]
+	}
+
+	// beforeExit finishes writing any profiles and output requested on the
+	// command line.  It must be called before exiting, since os.Exit skips
+	// defers.
+	var exitFuncs []func()
+	beforeExit := func() {
+		for i := len(exitFuncs) - 1; i >= 0; i-- {
+			exitFuncs[i]()
+		}
+		exitFuncs = nil
+	}
+
+	handleError := func(err interface{}) {
 		if err != nil {
-			logger.Printf("Error: %+v\n", err)
-			type code interface {
-				ExitStatus() int
+			logError(err)
+			beforeExit()
+			os.Exit(exitCode(err))
+		}
+	}
+	_ = handleError
+
+	// warnDeprecated warns that a target with a //mage:deprecated directive
+	// is being run.
+	warnDeprecated := func(name, msg string) {
+		warning := "Warning: " + name + " is deprecated"
+		if msg != "" {
+			warning += ", " + msg
+		}
+		if stderrColorValue {
+			warning = ansiColor[yellow] + warning + ansiColorReset
+		}
+		logger.Println(warning)
+	}
+	_ = warnDeprecated
+
+	// callHook calls MageSetup or MageTeardown, returning the error it
+	// returned or the value it panicked with.
+	callHook := func(name string, fn func(context.Context) error) (err interface{}) {
+		logVerbose("Running " + name)
+		ctx, _ := getContext()
+		defer func() {
+			if r := recover(); r != nil {
+				err = r
 			}
-			if c, ok := err.(code); ok {
-				os.Exit(c.ExitStatus())
+		}()
+		if e := fn(ctx); e != nil {
+			return e
+		}
+		return nil
+	}
+	_ = callHook
+
+	// setup runs MageSetup, if the magefile has one, before the first target.
+	// If it fails, no targets are run.
+	setup := func() {
+		{{- with .Setup}}
+		handleError(callHook("MageSetup", func(ctx context.Context) error {
+			{{if .IsError}}return {{end}}MageSetup({{if .IsContext}}ctx{{end}})
+			{{- if not .IsError}}
+			return nil
+			{{- end}}
+		}))
+		{{- end}}
+	}
+
+	// cleanups are the funcs targets returned along with their error, which
+	// are run once all the targets have finished.
+	var cleanupsMu sync.Mutex
+	var cleanups []func()
+	addCleanup := func(fn func()) {
+		if fn == nil {
+			return
+		}
+		cleanupsMu.Lock()
+		defer cleanupsMu.Unlock()
+		cleanups = append(cleanups, fn)
+	}
+	_ = addCleanup
+
+	// runCleanups runs the cleanups of the targets in reverse order, then
+	// those of the dependencies that weren't run with the context of a
+	// target, like those run with mg.Deps.  A cleanup that panics doesn't stop the others
+	// from running.
+	runCleanups := func() {
+		cleanupsMu.Lock()
+		fns := cleanups
+		cleanups = nil
+		cleanupsMu.Unlock()
+		{{- if .Mg.RunCleanups}}
+		fns = append([]func(){magemg.RunCleanups}, fns...)
+		{{- end}}
+		for i := len(fns) - 1; i >= 0; i-- {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						logError(fmt.Errorf("cleanup panicked: %v", r))
+					}
+				}()
+				fns[i]()
+			}()
+		}
+	}
+
+	// teardown runs the cleanups and MageTeardown, if the magefile has one,
+	// once the targets have finished or one has failed, and returns the error
+	// the run should end with.
+	teardown := func(err interface{}) interface{} {
+		runCleanups()
+		{{- with .Teardown}}
+		tdErr := callHook("MageTeardown", func(ctx context.Context) error {
+			{{if .IsError}}return {{end}}MageTeardown({{if .IsContext}}ctx, {{end}}toError(err))
+			{{- if not .IsError}}
+			return nil
+			{{- end}}
+		})
+		if tdErr != nil {
+			if err == nil {
+				return tdErr
 			}
-			os.Exit(1)
+			// the run already failed, which is the error to exit with.
+			logError(tdErr)
 		}
+		{{- end}}
+		return err
 	}
-	_ = handleError
 
 	// Set MAGEFILE_VERBOSE so mg.Verbose() reflects the flag value.
 	if args.Verbose {
@@ -324,7 +944,6 @@ Options:
 	if !args.Verbose {
 		log.SetOutput(ioutil.Discard)
 	}
-	logger := log.New(os.Stderr, "", 0)
 	if args.List {
 		if err := list(); err != nil {
 			log.Println(err)
@@ -333,33 +952,536 @@ Options:
 		return
 	}
 
-	targets := map[string]bool {
-		{{range $alias, $funci := .Aliases}}"{{lower $alias}}": true,
+	// targetArg describes a parameter of a target, which may be set from the
+	// command line as a flag (--name value) or positionally.
+	type targetArg struct {
+		name string
+		kind string
+		enum []string // the allowed values, if any
+	}
+
+	targetArgs := map[string][]targetArg {
+		{{range $alias, $funci := .Aliases}}"{{lower $alias}}": {{$funci.ArgDefs}},
 		{{end}}
-		{{range .Funcs}}"{{lower .TargetName}}": true,
+		{{range .Funcs}}"{{lower .TargetName}}": {{.ArgDefs}},
 		{{end}}
 		{{range .Imports}}
 			{{$imp := .}}
-			{{range $alias, $funci := .Info.Aliases}}"{{if ne $imp.Alias "."}}{{lower $imp.Alias}}:{{end}}{{lower $alias}}": true,
+			{{range $alias, $funci := .Info.Aliases}}"{{if ne $imp.Alias "."}}{{lower $imp.Alias}}:{{end}}{{lower $alias}}": {{$funci.ArgDefs}},
 			{{end}}
-			{{range .Info.Funcs}}"{{lower .TargetName}}": true,
+			{{range .Info.Funcs}}"{{lower .TargetName}}": {{.ArgDefs}},
 			{{end}}
 		{{end}}
 	}
 
-	var unknown []string
-	for _, arg := range args.Args {
-		if !targets[strings.ToLower(arg)] {
-			unknown = append(unknown, arg)
+	// checkArg returns an error describing what was expected if val can't be
+	// converted to the arg's type.
+	checkArg := func(param targetArg, val string) error {
+		var err error
+		var expected string
+		switch param.kind {
+		case "int":
+			_, err = strconv.Atoi(val)
+			expected = "an integer"
+		case "float64":
+			_, err = strconv.ParseFloat(val, 64)
+			expected = "a number"
+		case "bool":
+			_, err = strconv.ParseBool(val)
+			expected = "true or false"
+		case "time.Duration":
+			_, err = time.ParseDuration(val)
+			expected = "a duration like 1m30s"
+		}
+		if err != nil {
+			return fmt.Errorf("expected %s", expected)
+		}
+		if len(param.enum) > 0 {
+			for _, v := range param.enum {
+				if v == val {
+					return nil
+				}
+			}
+			return fmt.Errorf("expected one of: %s", strings.Join(param.enum, ", "))
+		}
+		return nil
+	}
+
+	// targetCall is a target to run and the values of its args, keyed by name.
+	// An arg may be given more than once, which is only useful for lists.
+	type targetCall struct {
+		name   string
+		values map[string][]string
+	}
+
+	// argValue returns the value of an arg, the last one wins if it was given
+	// more than once.
+	argValue := func(call targetCall, name string) string {
+		vals := call.values[name]
+		if len(vals) == 0 {
+			return ""
+		}
+		return vals[len(vals)-1]
+	}
+	_ = argValue
+
+	// argList returns the values of a list arg, which may be given more than
+	// once and each value may be a comma-separated list.
+	argList := func(call targetCall, name string) []string {
+		var list []string
+		for _, v := range call.values[name] {
+			for _, s := range strings.Split(v, ",") {
+				if s = strings.TrimSpace(s); s != "" {
+					list = append(list, s)
+				}
+			}
+		}
+		return list
+	}
+	_ = argList
+
+	// isFlag reports whether s looks like --name or -name rather than a value.
+	isFlag := func(s string) bool {
+		if !strings.HasPrefix(s, "-") {
+			return false
 		}
+		s = strings.TrimPrefix(strings.TrimPrefix(s, "-"), "-")
+		return s != "" && ((s[0] >= 'a' && s[0] <= 'z') || (s[0] >= 'A' && s[0] <= 'Z'))
 	}
-	if len(unknown) == 1 {
-		logger.Println("Unknown target specified:", unknown[0])
-		os.Exit(2)
+
+	// patternTargets are the targets a pattern may match, which leaves out
+	// hidden targets and aliases.
+	patternTargets := []string{
+	{{- range .Funcs}}{{if not .IsHidden}}
+		"{{lowerFirst .TargetName}}",
+	{{- end}}{{end}}
+	{{- range .Imports}}
+		{{- range .Info.Funcs}}{{if not .IsHidden}}
+		"{{lowerFirst .TargetName}}",
+		{{- end}}{{end}}
+	{{- end}}
 	}
-	if len(unknown) > 1 {
-		logger.Println("Unknown targets specified:", strings.Join(unknown, ", "))
-		os.Exit(2)
+	// matchTargets returns the targets matched by a glob like docker:* or a
+	// prefix like test..., in order.  Any other name matches nothing.
+	matchTargets := func(pattern string) []string {
+		pattern = strings.ToLower(pattern)
+		prefix := strings.HasSuffix(pattern, "...")
+		if !prefix && !strings.ContainsAny(pattern, "*?[") {
+			return nil
+		}
+		var matches []string
+		for _, name := range patternTargets {
+			var ok bool
+			if prefix {
+				ok = strings.HasPrefix(strings.ToLower(name), strings.TrimSuffix(pattern, "..."))
+			} else {
+				ok, _ = path.Match(pattern, strings.ToLower(name))
+			}
+			if ok {
+				matches = append(matches, name)
+			}
+		}
+		sort.Strings(matches)
+		return matches
+	}
+	// matched are the patterns given on the command line and the targets they
+	// matched, which are confirmed before they're run.
+	var matched []string
+
+	// parseCalls splits the command line into the targets to run and their
+	// args.  Args may be given as flags, in which case any not given get their
+	// zero value, or positionally, in which case all of them are required
+	// except a final ...string param, which takes the rest of the command line.
+	parseCalls := func(cliArgs []string) ([]targetCall, error) {
+		var calls []targetCall
+		var unknown []string
+		for x := 0; x < len(cliArgs); x++ {
+			call := targetCall{name: cliArgs[x], values: map[string][]string{}}
+			params, ok := targetArgs[strings.ToLower(call.name)]
+			if !ok {
+				matches := matchTargets(call.name)
+				if len(matches) == 0 {
+					unknown = append(unknown, call.name)
+					continue
+				}
+				// the matched targets are run without args, so they can't
+				// have any that are required.
+				for _, name := range matches {
+					for _, param := range targetArgs[strings.ToLower(name)] {
+						if param.kind != "...string" && !args.Help && !args.Deps && !args.Graph && !args.DryRun {
+							return nil, fmt.Errorf("target %q matched by %q needs arguments, so it must be run by name", name, call.name)
+						}
+					}
+					calls = append(calls, targetCall{name: name, values: map[string][]string{}})
+				}
+				matched = append(matched, call.name+": "+strings.Join(matches, ", "))
+				continue
+			}
+			usedFlags := false
+			positional := 0
+			// a final ...string param isn't required.
+			required := len(params)
+			if required > 0 && params[required-1].kind == "...string" {
+				required--
+			}
+			for x+1 < len(cliArgs) {
+				next := cliArgs[x+1]
+				if !usedFlags && positional < len(params) && params[positional].kind == "...string" {
+					// it takes the rest of the command line as is, so it can be
+					// passed on to other tools.
+					call.values[params[positional].name] = cliArgs[x+1:]
+					x = len(cliArgs)
+					break
+				}
+				if isFlag(next) {
+					name := strings.TrimPrefix(strings.TrimPrefix(next, "-"), "-")
+					val, hasVal := "", false
+					if i := strings.Index(name, "="); i >= 0 {
+						name, val, hasVal = name[:i], name[i+1:], true
+					}
+					var param *targetArg
+					for i := range params {
+						if strings.EqualFold(params[i].name, name) {
+							param = &params[i]
+						}
+					}
+					if param == nil {
+						return nil, fmt.Errorf("unknown flag --%s for target %q", name, call.name)
+					}
+					x++
+					if !hasVal {
+						if param.kind == "bool" {
+							val = "true"
+						} else {
+							if x+1 >= len(cliArgs) {
+								return nil, fmt.Errorf("flag --%s for target %q needs a value", name, call.name)
+							}
+							x++
+							val = cliArgs[x]
+						}
+					}
+					if err := checkArg(*param, val); err != nil {
+						return nil, fmt.Errorf("invalid value %q for --%s of target %q: %v", val, param.name, call.name, err)
+					}
+					call.values[param.name] = append(call.values[param.name], val)
+					usedFlags = true
+					continue
+				}
+				if usedFlags || positional >= len(params) {
+					break
+				}
+				param := params[positional]
+				if err := checkArg(param, next); err != nil {
+					return nil, fmt.Errorf("invalid value %q for %s argument of target %q: %v", next, param.name, call.name, err)
+				}
+				call.values[param.name] = []string{next}
+				positional++
+				x++
+			}
+			if !usedFlags && positional < required && !args.Help && !args.Deps && !args.Graph && !args.DryRun {
+				return nil, fmt.Errorf("not enough arguments for target %q, expected %d, got %d", call.name, required, positional)
+			}
+			calls = append(calls, call)
+		}
+		if len(unknown) == 1 {
+			return nil, fmt.Errorf("Unknown target specified: %s", unknown[0])
+		}
+		if len(unknown) > 1 {
+			return nil, fmt.Errorf("Unknown targets specified: %s", strings.Join(unknown, ", "))
+		}
+		return calls, nil
+	}
+
+	calls, err := parseCalls(args.Args)
+	if err != nil {
+		logger.Println(err)
+		os.Exit(exitCodes["unknownTarget"])
+	}
+	// running every target a pattern matches is confirmed first, since it may
+	// match more than was meant.
+	if len(matched) > 0 && !args.Yes && !args.Help && !args.Deps && !args.Graph && !args.DryRun {
+		fmt.Fprintln(os.Stderr, "The patterns match these targets:")
+		for _, m := range matched {
+			fmt.Fprintln(os.Stderr, "  "+m)
+		}
+		if !isTerminal(os.Stdin) {
+			logger.Println("Error: use -yes to run the targets matched by a pattern when stdin isn't a terminal")
+			os.Exit(1)
+		}
+		fmt.Fprint(os.Stderr, "Run them? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			os.Exit(1)
+		}
+	}
+	{{- if or .DynamicDefault (and .Defaults (not .DefaultFunc.Name))}}
+	// a list of default targets, or the ones chosen by mg.DefaultFn, are run
+	// just like they were given on the command line.
+	if len(args.Args) == 0 && !args.Deps && !args.Graph && !parseBool("MAGEFILE_IGNOREDEFAULT") {
+		{{- if .DynamicDefault}}
+		args.Args = Default()
+		{{- else}}
+		args.Args = []string{ {{range .Defaults}}{{printf "%q" (lowerFirst .TargetName)}}, {{end}}}
+		{{- end}}
+		calls, err = parseCalls(args.Args)
+		if err != nil {
+			logger.Println("Error choosing the default targets:", err)
+			os.Exit(exitCodes["unknownTarget"])
+		}
+	}
+	{{- end}}
+
+	// the targets that aren't selected by -only-tags and -skip-tags are
+	// skipped, as if they hadn't been given.
+	var tagSkipped []string
+	if !args.Deps && !args.Graph && !args.Help {
+		selected := calls[:0]
+		for _, call := range calls {
+			if tagsMatch(call.name) {
+				selected = append(selected, call)
+				continue
+			}
+			logVerbose("Skipping target " + call.name + ", its tags don't match")
+			tagSkipped = append(tagSkipped, call.name)
+		}
+		calls = selected
+	}
+
+	if args.Deps || args.Graph || args.DryRun {
+		// depNode is a target, the functions it passes to mg.Deps, and the
+		// commands it runs with sh, found by reading the magefiles.  Functions
+		// that aren't targets are shown as name() and have no dependencies of
+		// their own.
+		type depNode struct {
+			name string
+			deps []string
+			cmds []string
+		}
+		nodes := map[string]depNode{
+		{{- range .Funcs}}
+			"{{lower .TargetName}}": { {{template "depnode" .}} },
+		{{- end}}
+		{{- range .Imports}}
+			{{- range .Info.Funcs}}
+			"{{lower .TargetName}}": { {{template "depnode" .}} },
+			{{- end}}
+		{{- end}}
+		}
+		lookup := func(name string) depNode {
+			if node, ok := nodes[strings.ToLower(name)]; ok {
+				return node
+			}
+			return depNode{name: name}
+		}
+
+		seen := map[string]bool{}
+		var printDeps func(name, indent string)
+		printDeps = func(name, indent string) {
+			node := lookup(name)
+			if seen[node.name] && len(node.deps) > 0 {
+				// mage only runs each dependency once, so there's no need to
+				// show the same tree twice.
+				fmt.Println(indent + node.name + " (see above)")
+				return
+			}
+			seen[node.name] = true
+			fmt.Println(indent + node.name)
+			for _, dep := range node.deps {
+				printDeps(dep, indent+"  ")
+			}
+		}
+
+		if args.Deps {
+			if len(args.Args) != 1 {
+				logger.Println("-deps requires a single target")
+				os.Exit(1)
+			}
+			printDeps(canonical(args.Args[0]), "")
+			return
+		}
+
+		if args.Graph {
+			if args.GraphFormat != "dot" && args.GraphFormat != "mermaid" && args.GraphFormat != "tree" {
+				logger.Printf("unsupported graph format %q, the supported formats are: dot, mermaid, tree", args.GraphFormat)
+				os.Exit(1)
+			}
+			// the graph starts from the given targets, or from every target
+			// that no other target depends on.
+			var roots []string
+			for _, call := range calls {
+				roots = append(roots, lookup(canonical(call.name)).name)
+			}
+			all := len(roots) == 0
+			if all {
+				needed := map[string]bool{}
+				for _, name := range patternTargets {
+					for _, dep := range lookup(name).deps {
+						needed[lookup(dep).name] = true
+					}
+				}
+				for _, name := range patternTargets {
+					if !needed[name] {
+						roots = append(roots, name)
+					}
+				}
+				sort.Strings(roots)
+			}
+			// order is every function in the graph, in the order it's reached.
+			var order []string
+			reached := map[string]bool{}
+			var reach func(name string)
+			reach = func(name string) {
+				node := lookup(name)
+				if reached[node.name] {
+					return
+				}
+				reached[node.name] = true
+				order = append(order, node.name)
+				for _, dep := range node.deps {
+					reach(dep)
+				}
+			}
+			for _, name := range roots {
+				reach(name)
+			}
+			if all {
+				// targets that only depend on each other have no root.
+				var rest []string
+				for _, name := range patternTargets {
+					if !reached[name] {
+						rest = append(rest, name)
+					}
+				}
+				sort.Strings(rest)
+				for _, name := range rest {
+					if !reached[name] {
+						roots = append(roots, name)
+						reach(name)
+					}
+				}
+			}
+
+			if args.GraphFormat == "tree" {
+				for _, name := range roots {
+					printDeps(name, "")
+				}
+				return
+			}
+			// targets are grouped by their namespace, which for imported
+			// targets includes the alias they're imported with.
+			namespace := func(name string) string {
+				if _, ok := nodes[strings.ToLower(name)]; !ok {
+					return ""
+				}
+				if i := strings.LastIndex(name, ":"); i >= 0 {
+					return name[:i]
+				}
+				return ""
+			}
+			var namespaces []string
+			members := map[string][]string{}
+			for _, name := range order {
+				ns := namespace(name)
+				if _, ok := members[ns]; !ok && ns != "" {
+					namespaces = append(namespaces, ns)
+				}
+				members[ns] = append(members[ns], name)
+			}
+			sort.Strings(namespaces)
+			type edge struct{ from, to string }
+			var edges []edge
+			for _, name := range order {
+				done := map[string]bool{}
+				for _, dep := range lookup(name).deps {
+					to := lookup(dep).name
+					if !done[to] {
+						done[to] = true
+						edges = append(edges, edge{name, to})
+					}
+				}
+			}
+
+			if args.GraphFormat == "dot" {
+				fmt.Println("digraph mage {")
+				for _, name := range members[""] {
+					fmt.Printf("\t%q;\n", name)
+				}
+				for _, ns := range namespaces {
+					fmt.Printf("\tsubgraph %q {\n", "cluster_"+ns)
+					fmt.Printf("\t\tlabel = %q;\n", ns)
+					for _, name := range members[ns] {
+						fmt.Printf("\t\t%q;\n", name)
+					}
+					fmt.Println("\t}")
+				}
+				for _, e := range edges {
+					fmt.Printf("\t%q -> %q;\n", e.from, e.to)
+				}
+				fmt.Println("}")
+				return
+			}
+			// mermaid ids can't have colons or parentheses, so the nodes are
+			// numbered, and labeled with their names.
+			ids := map[string]string{}
+			for i, name := range order {
+				ids[name] = "n" + strconv.Itoa(i)
+			}
+			label := func(s string) string {
+				return ` + "`" + `["` + "`" + ` + strings.Replace(s, ` + "`" + `"` + "`" + `, "#quot;", -1) + ` + "`" + `"]` + "`" + `
+			}
+			fmt.Println("graph TD")
+			for _, name := range members[""] {
+				fmt.Println("    " + ids[name] + label(name))
+			}
+			for i, ns := range namespaces {
+				fmt.Println("    subgraph ns" + strconv.Itoa(i) + " " + label(ns))
+				for _, name := range members[ns] {
+					fmt.Println("        " + ids[name] + label(name))
+				}
+				fmt.Println("    end")
+			}
+			for _, e := range edges {
+				fmt.Println("    " + ids[e.from] + " --> " + ids[e.to])
+			}
+			return
+		}
+
+		// print each target after its dependencies, the order they run in
+		// when dependencies are run serially, along with the commands each
+		// target runs.
+		var names []string
+		for _, call := range calls {
+			names = append(names, canonical(call.name))
+		}
+		{{- if .DefaultFunc.Name}}
+		if len(args.Args) == 0 && tagsMatch("{{.DefaultFunc.TargetName}}") {
+			names = append(names, "{{.DefaultFunc.TargetName}}")
+		}
+		{{- end}}
+		if len(names) == 0 && len(onlyTags) == 0 && len(skipTags) == 0 {
+			logger.Println("no target specified")
+			os.Exit(1)
+		}
+		planned := map[string]bool{}
+		var plan func(name string)
+		plan = func(name string) {
+			node := lookup(name)
+			if planned[node.name] {
+				return
+			}
+			planned[node.name] = true
+			for _, dep := range node.deps {
+				plan(dep)
+			}
+			fmt.Println(node.name)
+			for _, cmd := range node.cmds {
+				fmt.Println("    " + cmd)
+			}
+		}
+		for _, name := range names {
+			plan(name)
+		}
+		return
 	}
 
 	if args.Help {
@@ -374,6 +1496,21 @@ Options:
 				fmt.Println({{printf "%q" .Comment}})
 				fmt.Println()
 				{{end}}
+				{{- if .IsDeprecated -}}
+				fmt.Println({{if .Deprecation}}{{printf "%q" (printf "Deprecated: %s" .Deprecation)}}{{else}}"Deprecated."{{end}})
+				fmt.Println()
+				{{end}}
+				{{- if .Args -}}
+				fmt.Print("Usage:\n\n\t{{$.BinaryName}} {{lower .TargetName}}{{range .Args}} {{.Usage}}{{end}}\n\n")
+				fmt.Println("Flags:")
+				fmt.Println()
+				w := tabwriter.NewWriter(os.Stdout, 0, 4, 4, ' ', 0)
+				{{- range .Args}}
+				fmt.Fprintf(w, "\t--%s %s\t%s\n", {{printf "%q" .Name}}, {{printf "%q" .Type}}, {{printf "%q" .Doc}})
+				{{- end}}
+				w.Flush()
+				fmt.Println()
+				{{end}}
 				var aliases []string
 				{{- $name := .Name -}}
 				{{- $recv := .Receiver -}}
@@ -390,6 +1527,413 @@ Options:
 				os.Exit(1)
 		}
 	}
+	defer beforeExit()
+	// the output of targets run in parallel is prefixed with their names,
+	// so it's clear which target wrote what.
+	prefixTargets := args.Status && args.Parallel && len(calls) > 1
+	if args.Parallel && len(calls) > 1 {
+		githubGroups = false
+	}
+	if events != nil {
+		targets := make([]string, len(calls))
+		for i, call := range calls {
+			targets[i] = call.name
+		}
+		emitEvent("run_start", map[string]interface{}{"targets": targets})
+		started := time.Now()
+		// run_end is the last event, so it's written by the first exit func,
+		// other than the ones closing the output pipes.
+		exitFuncs = append(exitFuncs, func() {
+			end := map[string]interface{}{"duration": time.Since(started).Seconds()}
+			resultsMu.Lock()
+			failed := 0
+			for _, r := range results {
+				if r.err != nil {
+					failed++
+				}
+			}
+			resultsMu.Unlock()
+			end["failed"] = failed
+			emitEvent("run_end", end)
+			events.Close()
+		})
+	}
+	if args.Report != "" {
+		parts := strings.SplitN(args.Report, "=", 2)
+		if len(parts) != 2 || parts[0] != "junit" || parts[1] == "" {
+			logger.Printf("invalid report %q, expected junit=file\n", args.Report)
+			os.Exit(2)
+		}
+		path := parts[1]
+		started := time.Now()
+		exitFuncs = append(exitFuncs, func() {
+			// this is the format understood by Jenkins, GitLab, Azure DevOps
+			// and others, with each target as a test case.
+			type failure struct {
+				Message string ` + "`" + `xml:"message,attr"` + "`" + `
+				Type    string ` + "`" + `xml:"type,attr"` + "`" + `
+				Text    string ` + "`" + `xml:",chardata"` + "`" + `
+			}
+			type testCase struct {
+				Name      string   ` + "`" + `xml:"name,attr"` + "`" + `
+				ClassName string   ` + "`" + `xml:"classname,attr"` + "`" + `
+				Time      string   ` + "`" + `xml:"time,attr"` + "`" + `
+				Failure   *failure ` + "`" + `xml:"failure,omitempty"` + "`" + `
+			}
+			type testSuite struct {
+				XMLName   xml.Name   ` + "`" + `xml:"testsuite"` + "`" + `
+				Name      string     ` + "`" + `xml:"name,attr"` + "`" + `
+				Tests     int        ` + "`" + `xml:"tests,attr"` + "`" + `
+				Failures  int        ` + "`" + `xml:"failures,attr"` + "`" + `
+				Time      string     ` + "`" + `xml:"time,attr"` + "`" + `
+				Timestamp string     ` + "`" + `xml:"timestamp,attr"` + "`" + `
+				Cases     []testCase ` + "`" + `xml:"testcase"` + "`" + `
+			}
+			type testSuites struct {
+				XMLName xml.Name    ` + "`" + `xml:"testsuites"` + "`" + `
+				Suites  []testSuite ` + "`" + `xml:"testsuite"` + "`" + `
+			}
+			seconds := func(d time.Duration) string {
+				return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+			}
+			suite := testSuite{
+				Name:      filepath.Base(os.Args[0]),
+				Time:      seconds(time.Since(started)),
+				Timestamp: started.UTC().Format("2006-01-02T15:04:05"),
+			}
+			resultsMu.Lock()
+			for _, r := range results {
+				tc := testCase{Name: r.name, ClassName: suite.Name, Time: seconds(r.duration)}
+				if r.err != nil {
+					msg := fmt.Sprint(r.err)
+					tc.Failure = &failure{Message: msg, Type: "error", Text: fmt.Sprintf("%+v", r.err)}
+					suite.Failures++
+				}
+				suite.Cases = append(suite.Cases, tc)
+			}
+			resultsMu.Unlock()
+			suite.Tests = len(suite.Cases)
+			b, err := xml.MarshalIndent(testSuites{Suites: []testSuite{suite}}, "", "  ")
+			if err == nil {
+				err = ioutil.WriteFile(path, append([]byte(xml.Header), append(b, '\n')...), 0644)
+			}
+			if err != nil {
+				logger.Println("Error writing report:", err)
+			}
+		})
+	}
+	// targetName returns the name of the target the given name or alias
+	// refers to, as it's reported.
+	targetName := func(name string) string {
+		switch strings.ToLower(canonical(name)) {
+		{{- range .Funcs}}
+		case "{{lower .TargetName}}":
+			return "{{.TargetName}}"
+		{{- end}}
+		{{- range .Imports}}
+			{{- range .Info.Funcs}}
+		case "{{lower .TargetName}}":
+			return "{{.TargetName}}"
+			{{- end}}
+		{{- end}}
+		}
+		return name
+	}
+	// skippedTargets didn't run because an earlier target failed, and
+	// cachedTargets were given more than once with -p, so they only ran once.
+	var skippedTargets, cachedTargets []string
+	for _, name := range tagSkipped {
+		skippedTargets = append(skippedTargets, targetName(name))
+	}
+	if args.Summary {
+		started := time.Now()
+		exitFuncs = append(exitFuncs, func() {
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			if len(results)+len(skippedTargets)+len(cachedTargets) == 0 {
+				return
+			}
+			counts := map[string]int{}
+			fmt.Fprintln(os.Stderr, "\nSummary:")
+			w := tabwriter.NewWriter(os.Stderr, 0, 4, 4, ' ', 0)
+			for _, r := range results {
+				status := "ok"
+				if r.err != nil {
+					status = "failed"
+				}
+				counts[status]++
+				fmt.Fprintf(w, "  %s\t%s\t%v\n", r.name, status, r.duration.Round(time.Millisecond))
+			}
+			for _, name := range cachedTargets {
+				counts["cached"]++
+				fmt.Fprintf(w, "  %s\tcached\n", name)
+			}
+			for _, name := range skippedTargets {
+				counts["skipped"]++
+				fmt.Fprintf(w, "  %s\tskipped\n", name)
+			}
+			w.Flush()
+			total := len(results) + len(cachedTargets) + len(skippedTargets)
+			var parts []string
+			for _, status := range []string{"ok", "failed", "cached", "skipped"} {
+				if counts[status] > 0 {
+					parts = append(parts, fmt.Sprintf("%d %s", counts[status], status))
+				}
+			}
+			plural := "s"
+			if total == 1 {
+				plural = ""
+			}
+			fmt.Fprintf(os.Stderr, "%d target%s: %s in %v\n", total, plural, strings.Join(parts, ", "), time.Since(started).Round(time.Millisecond))
+		})
+	}
+	if summary := os.Getenv("GITHUB_STEP_SUMMARY"); githubActions && summary != "" {
+		exitFuncs = append(exitFuncs, func() {
+			if len(results) == 0 {
+				return
+			}
+			f, err := os.OpenFile(summary, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err != nil {
+				logger.Println("Error writing job summary:", err)
+				return
+			}
+			defer f.Close()
+			fmt.Fprint(f, "### mage\n\n| Target | Result | Duration |\n| --- | --- | --- |\n")
+			for _, r := range results {
+				result := "✅ passed"
+				if r.err != nil {
+					msg := strings.Replace(fmt.Sprint(r.err), "\n", " ", -1)
+					result = "❌ " + strings.Replace(msg, "|", "\\|", -1)
+				}
+				fmt.Fprintf(f, "| %s | %s | %v |\n", r.name, result, r.duration.Round(time.Millisecond))
+			}
+			fmt.Fprintln(f)
+		})
+	}
+	// projects opt in to telemetry to track the health of their builds: a
+	// summary of each run is posted as JSON to an http(s) URL, or appended as
+	// a line of JSON to a file.  Failing to send it doesn't fail the run.
+	if telemetry := os.Getenv("MAGEFILE_TELEMETRY"); telemetry != "" && telemetry != "off" {
+		started := time.Now()
+		exitFuncs = append(exitFuncs, func() {
+			resultsMu.Lock()
+			defer resultsMu.Unlock()
+			if len(results)+len(skippedTargets)+len(cachedTargets) == 0 {
+				return
+			}
+			type targetSummary struct {
+				Name     string  ` + "`" + `json:"name"` + "`" + `
+				Status   string  ` + "`" + `json:"status"` + "`" + `
+				Duration float64 ` + "`" + `json:"duration,omitempty"` + "`" + `
+				Error    string  ` + "`" + `json:"error,omitempty"` + "`" + `
+			}
+			type runSummary struct {
+				Time        string          ` + "`" + `json:"time"` + "`" + `
+				Duration    float64         ` + "`" + `json:"duration"` + "`" + `
+				Success     bool            ` + "`" + `json:"success"` + "`" + `
+				Interrupted bool            ` + "`" + `json:"interrupted,omitempty"` + "`" + `
+				Binary      string          ` + "`" + `json:"binary"` + "`" + `
+				Dir         string          ` + "`" + `json:"dir"` + "`" + `
+				GOOS        string          ` + "`" + `json:"goos"` + "`" + `
+				GOARCH      string          ` + "`" + `json:"goarch"` + "`" + `
+				CacheHits   int             ` + "`" + `json:"cacheHits"` + "`" + `
+				Targets     []targetSummary ` + "`" + `json:"targets"` + "`" + `
+			}
+			run := runSummary{
+				Time:        started.UTC().Format(time.RFC3339),
+				Duration:    time.Since(started).Seconds(),
+				Success:     true,
+				Interrupted: isInterrupted(),
+				Binary:      filepath.Base(os.Args[0]),
+				GOOS:        runtime.GOOS,
+				GOARCH:      runtime.GOARCH,
+				CacheHits:   len(cachedTargets),
+			}
+			run.Dir, _ = os.Getwd()
+			for _, r := range results {
+				t := targetSummary{Name: r.name, Status: "ok", Duration: r.duration.Seconds()}
+				if r.err != nil {
+					t.Status = "failed"
+					t.Error = fmt.Sprint(r.err)
+					run.Success = false
+				}
+				run.Targets = append(run.Targets, t)
+			}
+			for _, name := range cachedTargets {
+				run.Targets = append(run.Targets, targetSummary{Name: name, Status: "cached"})
+			}
+			for _, name := range skippedTargets {
+				run.Targets = append(run.Targets, targetSummary{Name: name, Status: "skipped"})
+			}
+			if run.Interrupted {
+				run.Success = false
+			}
+			b, err := json.Marshal(run)
+			if err != nil {
+				logger.Println("Error sending telemetry:", err)
+				return
+			}
+			if strings.HasPrefix(telemetry, "http://") || strings.HasPrefix(telemetry, "https://") {
+				client := &http.Client{Timeout: 10 * time.Second}
+				resp, err := client.Post(telemetry, "application/json", strings.NewReader(string(b)))
+				if err != nil {
+					logger.Println("Error sending telemetry:", err)
+					return
+				}
+				resp.Body.Close()
+				if resp.StatusCode >= 300 {
+					logger.Printf("Error sending telemetry: %s returned %s", telemetry, resp.Status)
+				}
+				return
+			}
+			f, err := os.OpenFile(telemetry, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+			if err == nil {
+				// each run is a single write, so runs can share the file.
+				_, err = f.Write(append(b, '\n'))
+				if cerr := f.Close(); err == nil {
+					err = cerr
+				}
+			}
+			if err != nil {
+				logger.Println("Error sending telemetry:", err)
+			}
+		})
+	}
+	if args.Timestamps || prefixTargets {
+		prefixLines := func(dst *os.File) *os.File {
+			r, w, err := os.Pipe()
+			if err != nil {
+				logger.Println("Error prefixing output:", err)
+				os.Exit(1)
+			}
+			// syncMarker is written to the pipe to find out when everything
+			// before it has been copied.
+			const syncMarker = "\x00mage-sync\x00\n"
+			synced := make(chan struct{})
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				br := bufio.NewReader(r)
+				for {
+					line, err := br.ReadString('\n')
+					isSync := strings.HasSuffix(line, syncMarker)
+					if isSync {
+						line = strings.TrimSuffix(line, syncMarker)
+						if line != "" {
+							// the target didn't end its last line.
+							line += "\n"
+						}
+					}
+					if line != "" {
+						prefix := ""
+						if args.Timestamps {
+							prefix = time.Now().UTC().Format("2006-01-02T15:04:05.000Z") + " "
+						}
+						if names := runningTargets(); names != "" {
+							prefix += "[" + names + "] "
+						}
+						dst.WriteString(prefix + line)
+					}
+					if isSync {
+						synced <- struct{}{}
+					}
+					if err != nil {
+						return
+					}
+				}
+			}()
+			outputSyncs = append(outputSyncs, func() {
+				w.WriteString(syncMarker)
+				<-synced
+			})
+			// the pipe is closed after the other exit funcs have run, since
+			// they print to it, like the -summary table, so it's first of
+			// the funcs beforeExit runs in reverse.
+			exitFuncs = append([]func(){func() {
+				w.Close()
+				<-done
+			}}, exitFuncs...)
+			return w
+		}
+		os.Stdout = prefixLines(os.Stdout)
+		os.Stderr = prefixLines(os.Stderr)
+		logger = log.New(os.Stderr, "", 0)
+		if args.Verbose {
+			log.SetOutput(os.Stderr)
+		}
+	}
+	createProfile := func(path string) *os.File {
+		f, err := os.Create(path)
+		if err != nil {
+			logger.Println("Error creating profile:", err)
+			beforeExit()
+			os.Exit(1)
+		}
+		return f
+	}
+	if args.CPUProfile != "" {
+		f := createProfile(args.CPUProfile)
+		if err := pprof.StartCPUProfile(f); err != nil {
+			logger.Println("Error starting cpu profile:", err)
+			beforeExit()
+			os.Exit(1)
+		}
+		exitFuncs = append(exitFuncs, func() {
+			pprof.StopCPUProfile()
+			f.Close()
+		})
+	}
+	if args.Trace != "" {
+		f := createProfile(args.Trace)
+		if err := trace.Start(f); err != nil {
+			logger.Println("Error starting trace:", err)
+			beforeExit()
+			os.Exit(1)
+		}
+		exitFuncs = append(exitFuncs, func() {
+			trace.Stop()
+			f.Close()
+		})
+	}
+	if args.MemProfile != "" {
+		f := createProfile(args.MemProfile)
+		exitFuncs = append(exitFuncs, func() {
+			// get up-to-date statistics, as the pprof docs recommend.
+			runtime.GC()
+			if err := pprof.WriteHeapProfile(f); err != nil {
+				logger.Println("Error writing memory profile:", err)
+			}
+			f.Close()
+		})
+	}
+
+	// Ctrl+C or SIGTERM cancels the run's context, so targets and dependencies
+	// that take it can stop cleanly, and the run ends as if the running
+	// targets failed, with cleanups and MageTeardown.  Doing it again exits
+	// right away.
+	_, cancelRun := getContext()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(interrupted)
+		msg := "Interrupted"
+		if names := runningTargets(); names != "" {
+			msg += ", waiting for " + strings.Replace(names, ",", ", ", -1) + " to stop"
+		}
+		logger.Println(msg + " (interrupt again to exit now)")
+		cancelRun()
+		// mage passes on interrupts, so the Ctrl+C a terminal sends to both
+		// it and the binary arrives twice, close together.
+		interruptedAt := time.Now()
+		for {
+			<-signals
+			if time.Since(interruptedAt) > time.Second {
+				os.Exit(exitCodes["interrupted"])
+			}
+		}
+	}()
+
 	if len(args.Args) < 1 {
 	{{- if .DefaultFunc.Name}}
 		ignoreDefault, _ := strconv.ParseBool(os.Getenv("MAGEFILE_IGNOREDEFAULT"))
@@ -400,8 +1944,23 @@ Options:
 			}
 			return
 		}
+		if !tagsMatch("{{.DefaultFunc.TargetName}}") {
+			logVerbose("Skipping target {{.DefaultFunc.TargetName}}, its tags don't match")
+			skippedTargets = append(skippedTargets, "{{.DefaultFunc.TargetName}}")
+			return
+		}
+		{{- if .DefaultFunc.Args}}
+		call := targetCall{}
+		{{- end}}
+		setup()
+		{{- if .DefaultFunc.IsDeprecated}}
+		warnDeprecated("{{.DefaultFunc.TargetName}}", {{printf "%q" .DefaultFunc.Deprecation}})
+		{{- end}}
 		{{.DefaultFunc.ExecCode}}
-		handleError(logger, err)
+		if err == nil && isInterrupted() {
+			err = errInterrupted
+		}
+		handleError(teardown(err))
 		return
 	{{- else}}
 		if err := list(); err != nil {
@@ -411,38 +1970,109 @@ Options:
 		return
 	{{- end}}
 	}
-	for _, target := range args.Args {
-		switch strings.ToLower(target) {
-		{{range $alias, $func := .Aliases}}
-			case "{{lower $alias}}":
-				target = "{{$func.TargetName}}"
-		{{- end}}
-		}
-		switch strings.ToLower(target) {
+	runCall := func(call targetCall) interface{} {
+		switch strings.ToLower(canonical(call.name)) {
 		{{range .Funcs }}
 			case "{{lower .TargetName}}":
-				if args.Verbose {
-					logger.Println("Running target:", "{{.TargetName}}")
-				}
+				logVerbose("Running target: {{.TargetName}}")
+				{{- if .IsDeprecated}}
+				warnDeprecated("{{.TargetName}}", {{printf "%q" .Deprecation}})
+				{{- end}}
 				{{.ExecCode}}
-				handleError(logger, err)
+				return err
 		{{- end}}
 		{{range .Imports}}
 		{{$imp := .}}
 			{{range .Info.Funcs }}
 				case "{{lower .TargetName}}":
-					if args.Verbose {
-						logger.Println("Running target:", "{{.TargetName}}")
-					}
+					logVerbose("Running target: {{.TargetName}}")
+					{{- if .IsDeprecated}}
+					warnDeprecated("{{.TargetName}}", {{printf "%q" .Deprecation}})
+					{{- end}}
 					{{.ExecCode}}
-					handleError(logger, err)
+					return err
 			{{- end}}
 		{{- end}}
-		default:
-			// should be impossible since we check this above.
-			logger.Printf("Unknown target: %q\n", args.Args[0])
-			os.Exit(1)
 		}
+		// should be impossible since we check this above.
+		logger.Printf("Unknown target: %q\n", args.Args[0])
+		beforeExit()
+		os.Exit(1)
+		return nil
+	}
+
+	setup()
+	if !args.Parallel || len(calls) < 2 {
+		for i, call := range calls {
+			err := runCall(call)
+			if err == nil && isInterrupted() {
+				// the target finished anyway, but no more are run.
+				err = errInterrupted
+			}
+			if err != nil {
+				for _, c := range calls[i+1:] {
+					skippedTargets = append(skippedTargets, targetName(c.name))
+				}
+				handleError(teardown(err))
+			}
+		}
+		handleError(teardown(nil))
+		return
+	}
+
+	// Run the targets concurrently.  A target given more than once with the
+	// same args only runs once, and dependencies shared between the targets
+	// only run once, as usual.
+	seen := map[string]bool{}
+	var unique []targetCall
+	for _, call := range calls {
+		key := strings.ToLower(canonical(call.name)) + fmt.Sprint(call.values)
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, call)
+		} else {
+			cachedTargets = append(cachedTargets, targetName(call.name))
+		}
+	}
+	errs := make([]interface{}, len(unique))
+	var wg sync.WaitGroup
+	for i, call := range unique {
+		wg.Add(1)
+		go func(i int, call targetCall) {
+			defer wg.Done()
+			errs[i] = runCall(call)
+		}(i, call)
+	}
+	wg.Wait()
+	var first interface{}
+	for _, err := range errs {
+		if err != nil {
+			first = err
+			break
+		}
+	}
+	if err := teardown(first); first == nil {
+		handleError(err)
+	}
+	exit := 0
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+		logError(err)
+		code := exitCode(err)
+		switch {
+		case exit == 0:
+			exit = code
+		case exit != code:
+			// the targets failed with different codes, so there's no single
+			// code to exit with.
+			exit = 1
+		}
+	}
+	if exit != 0 {
+		beforeExit()
+		os.Exit(exit)
 	}
 }
 
@@ -0,0 +1,8 @@
+// +build mage
+
+package main
+
+//mage:alias test
+func Build() {}
+
+func Test() {}
@@ -0,0 +1,19 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+// Builds the binaries.
+//
+//mage:alias b, bld
+func Build() {
+	fmt.Println("building")
+}
+
+// Runs the tests.
+func Test() {}
+
+var Aliases = map[string]interface{}{
+	"t": Test,
+}
@@ -0,0 +1,12 @@
+// +build mage
+
+package main
+
+//mage:alias b
+func Build() {}
+
+var Aliases = map[string]interface{}{
+	"B": Bench,
+}
+
+func Bench() {}
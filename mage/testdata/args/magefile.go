@@ -0,0 +1,56 @@
+//+build mage
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+type Build mg.Namespace
+
+// Prints a greeting.
+func Greet(name string, count int) {
+	for i := 0; i < count; i++ {
+		fmt.Println("hi", name)
+	}
+}
+
+// ReleaseOpts configures a release.
+type ReleaseOpts struct {
+	// the version to release
+	Version string
+	Draft   bool
+	// MaxTries is how many times to try.
+	MaxTries int
+}
+
+// Cuts a release.
+func Release(ctx context.Context, opts ReleaseOpts) error {
+	fmt.Printf("release %s draft=%v tries=%d\n", opts.Version, opts.Draft, opts.MaxTries)
+	return nil
+}
+
+func (Build) Tag(tag string, push bool) {
+	fmt.Printf("tag %s push=%v\n", tag, push)
+}
+
+func Hello() {
+	fmt.Println("hello")
+}
+
+// Env is an environment to deploy to.
+type Env string
+
+const (
+	Dev  Env = "dev"
+	Prod Env = "prod"
+)
+
+// Deploys the app.
+func Deploy(env Env, wait time.Duration, ratio float64, tags []string) {
+	fmt.Printf("deploy %s wait=%v ratio=%v tags=%q\n", env, wait, ratio, tags)
+}
@@ -0,0 +1,14 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func Show() {
+	for _, name := range []string{"MAGE_TEST_AMBIENT", "MAGE_TEST_KEPT", "MAGE_TEST_DOTENV", "MAGEFILE_VERBOSE"} {
+		fmt.Printf("%s=%q\n", name, os.Getenv(name))
+	}
+}
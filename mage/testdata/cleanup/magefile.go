@@ -0,0 +1,37 @@
+// +build mage
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+func MageTeardown(err error) {
+	fmt.Println("teardown")
+}
+
+func Server() (func(), error) {
+	fmt.Println("starting server")
+	return func() { fmt.Println("stopping server") }, nil
+}
+
+// Runs the tests against the server.
+func Test(ctx context.Context) (func(), error) {
+	mg.CtxDeps(ctx, Server)
+	fmt.Println("testing")
+	return func() { fmt.Println("cleaning up test") }, nil
+}
+
+func Fail() (func(), error) {
+	return func() { fmt.Println("cleaning up fail") }, errors.New("oops")
+}
+
+// Echoes its args.
+func Echo(prefix string, args ...string) {
+	fmt.Println(prefix + strings.Join(args, ","))
+}
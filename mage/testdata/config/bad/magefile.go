@@ -0,0 +1,5 @@
+// +build mage
+
+package main
+
+func Build() {}
@@ -0,0 +1,20 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+)
+
+func Build() {
+	log.Println("verbose output")
+	fmt.Println("building")
+}
+
+func Greet() {
+	fmt.Println(os.Getenv("GREETING"), os.Getenv("NAME"))
+}
+
+var Default = Build
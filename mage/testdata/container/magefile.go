@@ -0,0 +1,14 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// Show prints where it runs and the variables passed to the container.
+func Show() {
+	wd, _ := os.Getwd()
+	fmt.Printf("%s %s %s\n", wd, os.Getenv("GREETING"), os.Getenv("FORWARDED"))
+}
@@ -0,0 +1,26 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+)
+
+// Default runs the targets in $DEFAULT_TARGETS.
+var Default = mg.DefaultFn(func() []string {
+	return strings.Fields(os.Getenv("DEFAULT_TARGETS"))
+})
+
+type Build mg.Namespace
+
+func (Build) Linux() {
+	fmt.Println("building for linux")
+}
+
+func (Build) Windows() {
+	fmt.Println("building for windows")
+}
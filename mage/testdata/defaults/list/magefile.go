@@ -0,0 +1,19 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+var Default = []interface{}{Generate, Build}
+
+func Generate() {
+	fmt.Println("generating")
+}
+
+func Build() {
+	fmt.Println("building")
+}
+
+func Test() {
+	fmt.Println("testing")
+}
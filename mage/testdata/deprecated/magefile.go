@@ -0,0 +1,20 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+// Builds everything.
+func BuildAll() {
+	fmt.Println("building")
+}
+
+// Builds everything, the old way.
+//
+//mage:deprecated "use buildAll"
+func Build() {
+	BuildAll()
+}
+
+//mage:deprecated
+func Old() {}
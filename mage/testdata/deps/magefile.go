@@ -0,0 +1,42 @@
+// +build mage
+
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+
+	// mage:import other
+	"github.com/magefile/mage/mage/testdata/mageimport/subdir2"
+)
+
+var Aliases = map[string]interface{}{
+	"b": Build,
+}
+
+func Build(ctx context.Context) error {
+	mg.CtxDeps(ctx, Generate, Test)
+	out := "bin/app"
+	return sh.RunWith(map[string]string{"CGO_ENABLED": "0"}, "go", "build", "-o", out, ".")
+}
+
+func Generate() error {
+	mg.Deps(installTools)
+	return sh.Run("go", "generate", "./...")
+}
+
+func Test() {
+	mg.SerialDeps(Generate, Docker.Image, mage.NS.Deploy2)
+	fmt.Println("test")
+}
+
+type Docker mg.Namespace
+
+func (Docker) Image() {
+	fmt.Println("image")
+}
+
+func installTools() {}
@@ -0,0 +1,14 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func Env() {
+	for _, k := range []string{"NAME", "GREETING", "QUOTED", "LOCAL", "FROM_ENV"} {
+		fmt.Printf("%s=%q\n", k, os.Getenv(k))
+	}
+}
@@ -0,0 +1,21 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+func Build() error {
+	mg.Deps(Generate)
+	return sh.Run(mg.GoCmd(), "version")
+}
+
+func Generate() {}
+
+func Test() error {
+	return errors.New("tests failed")
+}
@@ -0,0 +1,28 @@
+// +build mage
+
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+func Fail() error {
+	return errors.New("failed")
+}
+
+func Fatal() error {
+	return mg.Fatal(5, "failed with a code")
+}
+
+func Slow(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(10 * time.Second):
+		return nil
+	}
+}
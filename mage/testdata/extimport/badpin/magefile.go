@@ -0,0 +1,8 @@
+// +build mage
+
+package main
+
+import (
+	// mage:import ci version=v1.0.0
+	_ "example.com/targets/ci"
+)
@@ -0,0 +1,7 @@
+module example.com/magefiles
+
+go 1.12
+
+require example.com/targets v1.2.0
+
+replace example.com/targets => ./targets
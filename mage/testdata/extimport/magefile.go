@@ -0,0 +1,14 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+
+	// mage:import ci version=v1.2.0
+	_ "example.com/targets/ci"
+)
+
+func Build() {
+	fmt.Println("building")
+}
@@ -0,0 +1,8 @@
+package ci
+
+import "fmt"
+
+// Runs the linters.
+func Lint() {
+	fmt.Println("linting")
+}
@@ -0,0 +1,17 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func Build() {
+	fmt.Println("building")
+}
+
+// Fail fails the way go vet or go test would, with the location of the problem.
+func Fail() error {
+	return errors.New("magefile.go:16: 100% broken")
+}
@@ -0,0 +1,5 @@
+// Package lib is a module in the same workspace as the magefiles.
+package lib
+
+// Greeting is printed by the magefile.
+const Greeting = "hello from the workspace"
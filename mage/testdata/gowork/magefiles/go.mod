@@ -0,0 +1,3 @@
+module example.com/magefiles
+
+go 1.18
@@ -0,0 +1,14 @@
+//+build mage
+
+package main
+
+import (
+	"fmt"
+
+	"example.com/lib"
+)
+
+// Prints a greeting from a sibling module in the workspace.
+func Build() {
+	fmt.Println(lib.Greeting)
+}
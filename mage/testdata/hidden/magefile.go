@@ -0,0 +1,17 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+// Builds everything.
+func Build() {
+	fmt.Println("building")
+}
+
+// Installs the tools the other targets need.
+//
+//mage:hidden
+func InstallTools() {
+	fmt.Println("installing tools")
+}
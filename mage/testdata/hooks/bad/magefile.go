@@ -0,0 +1,7 @@
+// +build mage
+
+package main
+
+func MageTeardown() {}
+
+func Build() {}
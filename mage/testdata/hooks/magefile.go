@@ -0,0 +1,31 @@
+// +build mage
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+func MageSetup(ctx context.Context) error {
+	fmt.Println("setup")
+	return nil
+}
+
+func MageTeardown(ctx context.Context, err error) {
+	fmt.Println("teardown:", err)
+}
+
+// Builds the thing.
+func Build() {
+	fmt.Println("building")
+}
+
+func Fail() error {
+	return errors.New("oops")
+}
+
+func Panic() {
+	panic("boom")
+}
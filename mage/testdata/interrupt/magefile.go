@@ -0,0 +1,23 @@
+//+build mage
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Waits until the run is interrupted.
+func Wait(ctx context.Context) error {
+	fmt.Println("waiting")
+	<-ctx.Done()
+	fmt.Println("stopping")
+	return ctx.Err()
+}
+
+// Waits, ignoring the interrupt.
+func Ignore(ctx context.Context) {
+	fmt.Println("waiting")
+	time.Sleep(time.Hour)
+}
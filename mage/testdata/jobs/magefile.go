@@ -0,0 +1,39 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+var (
+	mu           sync.Mutex
+	running, max int
+)
+
+func work() {
+	mu.Lock()
+	running++
+	if running > max {
+		max = running
+	}
+	mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	mu.Lock()
+	running--
+	mu.Unlock()
+}
+
+func A() { work() }
+func B() { work() }
+func C() { work() }
+
+// Runs A, B and C, and prints how many ran at once.
+func All() {
+	mg.Deps(A, B, C)
+	fmt.Println("max:", max)
+}
@@ -0,0 +1,15 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+)
+
+// Prints where it was called from.
+func Caller() {
+	_, file, line, _ := runtime.Caller(1)
+	fmt.Printf("called from %s:%d\n", filepath.Base(file), line)
+}
@@ -0,0 +1,10 @@
+// +build mage
+
+package main
+
+// Builds the binaries.
+func Build() {
+	// this doesn't compile, but listing only needs to parse the magefile.
+	var n int = "one"
+	_ = n
+}
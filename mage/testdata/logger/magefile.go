@@ -0,0 +1,34 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+type logger struct{}
+
+func (logger) TargetStart(name string) { fmt.Println("start", name) }
+func (logger) TargetEnd(name string, err error, d time.Duration) {
+	fmt.Println("end", name, err)
+}
+func (logger) Verbose(msg string) { fmt.Println("verbose", msg) }
+func (logger) Error(err error)    { fmt.Println("error", err) }
+
+func init() {
+	mg.SetLogger(logger{})
+}
+
+func Build() {
+	mg.Deps(Dep)
+}
+
+func Dep() {}
+
+func Fail() error {
+	return errors.New("oops")
+}
@@ -0,0 +1,8 @@
+// +build mage
+
+package main
+
+import (
+	// mage:import only=Missing,BuildSubdir
+	_ "github.com/magefile/mage/mage/testdata/mageimport/subdir1"
+)
@@ -0,0 +1,10 @@
+// +build mage
+
+package main
+
+import (
+	// mage:import only=NS:Deploy
+	_ "github.com/magefile/mage/mage/testdata/mageimport/subdir1"
+	// mage:import sub2=github.com/magefile/mage/mage/testdata/mageimport/subdir2 only=buildSubdir2
+	_ "github.com/magefile/mage/mage/testdata/mageimport/subdir2"
+)
@@ -0,0 +1,47 @@
+//+build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+// ready is closed when both A and B have started, so that neither can finish
+// unless they run at the same time.
+var (
+	startedA = make(chan struct{})
+	startedB = make(chan struct{})
+)
+
+func A() error {
+	mg.Deps(Shared)
+	close(startedA)
+	return wait(startedB)
+}
+
+func B() error {
+	mg.Deps(Shared)
+	close(startedB)
+	return wait(startedA)
+}
+
+func Shared() {
+	fmt.Println("shared")
+}
+
+func Fail() error {
+	return mg.Fatal(3, "failed")
+}
+
+func wait(ch chan struct{}) error {
+	select {
+	case <-ch:
+		return nil
+	case <-time.After(5 * time.Second):
+		return errors.New("targets did not run in parallel")
+	}
+}
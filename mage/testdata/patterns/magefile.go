@@ -0,0 +1,37 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/magefile/mage/mg"
+)
+
+type Docker mg.Namespace
+
+func (Docker) Build() {
+	fmt.Println("docker:build")
+}
+
+func (Docker) Push() {
+	fmt.Println("docker:push")
+}
+
+// Tags the image.
+func (Docker) Tag(version string) {
+	fmt.Println("docker:tag", version)
+}
+
+func Test() {
+	fmt.Println("test")
+}
+
+func TestRace() {
+	fmt.Println("testRace")
+}
+
+//mage:hidden
+func TestHelper() {
+	fmt.Println("testHelper")
+}
@@ -0,0 +1,15 @@
+//+build mage
+
+package main
+
+import "fmt"
+
+// Deploys to the given environment.
+func Deploy(env string) {
+	fmt.Println("deploying to " + env)
+}
+
+// Rolls back the last deploy.
+func Rollback() {
+	fmt.Println("rolling back")
+}
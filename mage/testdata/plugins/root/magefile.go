@@ -0,0 +1,10 @@
+//+build mage
+
+package main
+
+import "fmt"
+
+// Builds the project.
+func Build() {
+	fmt.Println("build")
+}
@@ -0,0 +1,20 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Show prints the copied file, a variable from the project config and one
+// from .env.
+func Show(name string) error {
+	b, err := ioutil.ReadFile("data.txt")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s, %s %s, the secret is %s\n", b, os.Getenv("GREETING"), name, os.Getenv("SECRET"))
+	return nil
+}
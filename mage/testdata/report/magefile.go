@@ -0,0 +1,16 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+func Build() {
+	fmt.Println("building")
+}
+
+func Fail() error {
+	return errors.New("<oops>")
+}
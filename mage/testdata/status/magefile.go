@@ -0,0 +1,29 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func Build() {
+	fmt.Println("building")
+}
+
+func Fail() error {
+	return errors.New("oops")
+}
+
+// Slow and Fast write their output while the other is running.
+func Slow() {
+	fmt.Println("slow started")
+	time.Sleep(200 * time.Millisecond)
+	fmt.Println("slow done")
+}
+
+func Fast() {
+	time.Sleep(100 * time.Millisecond)
+	fmt.Println("fast done")
+}
@@ -0,0 +1,15 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Prints the directory the root magefile runs in.
+func Root() {
+	wd, _ := os.Getwd()
+	fmt.Println("root in", filepath.Base(wd))
+}
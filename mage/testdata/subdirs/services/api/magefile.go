@@ -0,0 +1,20 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Builds the api.
+func Build() {
+	wd, _ := os.Getwd()
+	fmt.Println("api build in", filepath.Base(wd))
+}
+
+// Says hello to someone.
+func Greet(name string) {
+	fmt.Println("api says hello to", name)
+}
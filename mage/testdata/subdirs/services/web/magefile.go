@@ -0,0 +1,17 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var Default = Serve
+
+// Serves the website.
+func Serve() {
+	wd, _ := os.Getwd()
+	fmt.Println("web serve in", filepath.Base(wd))
+}
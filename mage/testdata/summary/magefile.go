@@ -0,0 +1,13 @@
+// +build mage
+
+package main
+
+import "errors"
+
+func Build() {}
+
+func Test() error {
+	return errors.New("tests failed")
+}
+
+func Lint() {}
@@ -0,0 +1,30 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+// Builds the binaries.
+//
+//mage:tags release
+func Build() {
+	fmt.Println("build")
+}
+
+// Runs the integration tests.
+//
+//mage:tags ci slow
+func Test() {
+	fmt.Println("test")
+}
+
+// Publishes the release.
+//
+//mage:tags release, slow
+func Publish() {
+	fmt.Println("publish")
+}
+
+func Lint() {
+	fmt.Println("lint")
+}
@@ -0,0 +1,20 @@
+// +build mage
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/magefile/mage/mg"
+)
+
+func Build() {
+	mg.Deps(Dep)
+	fmt.Println("build")
+	fmt.Fprintln(os.Stderr, "warning")
+}
+
+func Dep() {
+	fmt.Println("dep")
+}
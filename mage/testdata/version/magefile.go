@@ -0,0 +1,9 @@
+// +build mage
+
+package main
+
+import "fmt"
+
+func Hello() {
+	fmt.Println("hello")
+}
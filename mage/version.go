@@ -0,0 +1,172 @@
+package mage
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	rtdebug "runtime/debug"
+	"strconv"
+	"strings"
+)
+
+// versionReq is the version of mage a project requires, from the mage setting
+// of its config file: either an exact version, e.g. v1.15.0, or a minimum
+// version, e.g. >=v1.14.0.
+type versionReq struct {
+	min     bool
+	version string
+}
+
+func (r versionReq) String() string {
+	if r.min {
+		return ">=" + r.version
+	}
+	return r.version
+}
+
+// parseVersionReq parses the mage setting of the config file.
+func parseVersionReq(s string) (versionReq, error) {
+	var r versionReq
+	if strings.HasPrefix(s, ">=") {
+		r.min = true
+		s = strings.TrimSpace(s[2:])
+	}
+	if _, err := parseVersion(s); err != nil {
+		return r, err
+	}
+	r.version = s
+	return r, nil
+}
+
+// parseVersion parses a release version of mage, e.g. v1.15.0, into its
+// numbers.
+func parseVersion(s string) ([3]int, error) {
+	var nums [3]int
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if !strings.HasPrefix(s, "v") || len(parts) != 3 {
+		return nums, fmt.Errorf("invalid mage version %q, expected e.g. v1.15.0 or >=v1.15.0", s)
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nums, fmt.Errorf("invalid mage version %q, expected e.g. v1.15.0 or >=v1.15.0", s)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// allows reports whether version satisfies the requirement.
+func (r versionReq) allows(version string) bool {
+	v, err := parseVersion(version)
+	if err != nil {
+		return false
+	}
+	req, _ := parseVersion(r.version)
+	if !r.min {
+		return v == req
+	}
+	for i := range v {
+		if v[i] != req[i] {
+			return v[i] > req[i]
+		}
+	}
+	return true
+}
+
+// currentVersion returns the version of this mage binary, or "" for a
+// development build.
+func currentVersion() string {
+	if gitTag != "<not set>" {
+		return gitTag
+	}
+	// mage installed with go install module@version doesn't have its
+	// version set by the linker.
+	if info, ok := rtdebug.ReadBuildInfo(); ok && info.Main.Version != "(devel)" {
+		return info.Main.Version
+	}
+	return ""
+}
+
+// checkVersion returns an error if this isn't a version of mage the project
+// config allows.  Development builds of mage, which have no version, are
+// always allowed.
+func checkVersion(req string) error {
+	if req == "" {
+		return nil
+	}
+	r, err := parseVersionReq(req)
+	if err != nil {
+		return err
+	}
+	version := currentVersion()
+	if version == "" {
+		debug.Printf("this is a development build of mage, ignoring the required version %s", r)
+		return nil
+	}
+	if !r.allows(version) {
+		return fmt.Errorf("this project requires mage %s, but this is mage %s, run mage -ensure to install it", r, version)
+	}
+	return nil
+}
+
+// ensureVersion installs the version of mage the project config requires in
+// place of the running mage, if it isn't already allowed.
+func ensureVersion(inv Invocation) int {
+	errlog := log.New(inv.Stderr, "", 0)
+	out := log.New(inv.Stdout, "", 0)
+	cfg, err := ReadConfig(inv.Dir)
+	if err != nil {
+		errlog.Println("Error reading config:", err)
+		return 1
+	}
+	if cfg.Mage == "" {
+		errlog.Println("Error: the project config doesn't set the mage version to use")
+		return 1
+	}
+	r, err := parseVersionReq(cfg.Mage)
+	if err != nil {
+		errlog.Println("Error:", err)
+		return 1
+	}
+	if version := currentVersion(); version != "" && r.allows(version) {
+		out.Println("mage", version, "is already installed")
+		return 0
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		errlog.Println("Error finding the mage binary:", err)
+		return 1
+	}
+	if exe, err = filepath.EvalSymlinks(exe); err != nil {
+		errlog.Println("Error finding the mage binary:", err)
+		return 1
+	}
+	if runtime.GOOS == "windows" {
+		// windows won't replace a running binary, but it can be renamed.
+		old := exe + ".old"
+		os.Remove(old)
+		if err := os.Rename(exe, old); err != nil {
+			errlog.Println("Error moving the mage binary aside:", err)
+			return 1
+		}
+	}
+	version := r.version
+	if r.min {
+		version = "latest"
+	}
+	out.Printf("Installing mage %s into %s", version, filepath.Dir(exe))
+	cmd := exec.Command(inv.GoCmd, "install", "github.com/magefile/mage@"+version)
+	cmd.Env = append(os.Environ(), "GOBIN="+filepath.Dir(exe))
+	cmd.Stdout = inv.Stdout
+	cmd.Stderr = inv.Stderr
+	if err := cmd.Run(); err != nil {
+		errlog.Println("Error installing mage:", err)
+		return 1
+	}
+	return 0
+}
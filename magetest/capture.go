@@ -0,0 +1,119 @@
+package magetest
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"testing"
+)
+
+var (
+	// captureMu keeps captures from happening at once, since they swap
+	// os.Stdout and the rest for the whole process.
+	captureMu sync.Mutex
+
+	writersMu sync.Mutex
+	writers   = map[string]*io.Writer{}
+)
+
+// Output is what Capture captured.
+type Output struct {
+	// Stdout and Stderr are what was written to os.Stdout and os.Stderr,
+	// including by commands run with sh, which inherit them.
+	Stdout string
+	Stderr string
+
+	// Log is what was logged with the log package, which is where sh logs
+	// the commands it runs when mage is run with -v.
+	Log string
+
+	// Writers are what was written to the registered writers, by the names
+	// they were registered with.
+	Writers map[string]string
+}
+
+// RegisterWriter has Capture capture what's written to the writer that w
+// points to, like a package's var for where its output goes, under the name.
+// Registering another writer with the name replaces it.
+func RegisterWriter(name string, w *io.Writer) {
+	writersMu.Lock()
+	defer writersMu.Unlock()
+	writers[name] = w
+}
+
+// Capture runs fn, for tests of code that prints, like targets called
+// directly, and returns what it printed.  It sends os.Stdout and os.Stderr
+// to pipes, the log package to a buffer, and the registered writers to
+// buffers, until fn returns.  The log package is then set to write to
+// os.Stderr, which is where it writes by default.
+func Capture(t testing.TB, fn func()) (out Output) {
+	t.Helper()
+	captureMu.Lock()
+	defer captureMu.Unlock()
+
+	stdout, err := capturePipe(&os.Stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stderr, err := capturePipe(&os.Stderr)
+	if err != nil {
+		stdout()
+		t.Fatal(err)
+	}
+	var logs bytes.Buffer
+	log.SetOutput(&logs)
+
+	writersMu.Lock()
+	bufs := make(map[string]*bytes.Buffer, len(writers))
+	olds := make(map[*io.Writer]io.Writer, len(writers))
+	for name, w := range writers {
+		bufs[name] = &bytes.Buffer{}
+		olds[w] = *w
+		*w = bufs[name]
+	}
+	writersMu.Unlock()
+
+	// fn may fail the test, which exits its goroutine, so everything's put back
+	// when it does too.
+	defer func() {
+		for w, old := range olds {
+			*w = old
+		}
+		log.SetOutput(os.Stderr)
+		out.Stderr = stderr()
+		out.Stdout = stdout()
+		out.Log = logs.String()
+		out.Writers = make(map[string]string, len(bufs))
+		for name, b := range bufs {
+			out.Writers[name] = b.String()
+		}
+	}()
+	fn()
+	return out
+}
+
+// capturePipe sends *f to a pipe, and returns a func that puts it back and
+// returns what was written to it.
+func capturePipe(f **os.File) (func() string, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	old := *f
+	*f = w
+	var b bytes.Buffer
+	done := make(chan struct{})
+	go func() {
+		io.Copy(&b, r)
+		close(done)
+	}()
+	return func() string {
+		*f = old
+		w.Close()
+		<-done
+		r.Close()
+		return b.String()
+	}, nil
+}
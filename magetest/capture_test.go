@@ -0,0 +1,50 @@
+package magetest
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+func TestCapture(t *testing.T) {
+	old := os.Getenv(mg.VerboseEnv)
+	os.Setenv(mg.VerboseEnv, "1")
+	defer os.Setenv(mg.VerboseEnv, old)
+	var progress io.Writer = os.Stderr
+	RegisterWriter("progress", &progress)
+	defer func() {
+		writersMu.Lock()
+		delete(writers, "progress")
+		writersMu.Unlock()
+	}()
+
+	out := Capture(t, func() {
+		if err := sh.RunV("echo", "hi"); err != nil {
+			t.Error(err)
+		}
+		fmt.Fprintln(os.Stderr, "warning")
+		log.Println("logged")
+		fmt.Fprint(progress, "50%")
+	})
+	if out.Stdout != "hi\n" {
+		t.Errorf("expected stdout %q but got %q", "hi\n", out.Stdout)
+	}
+	if out.Stderr != "warning\n" {
+		t.Errorf("expected stderr %q but got %q", "warning\n", out.Stderr)
+	}
+	if !strings.Contains(out.Log, "exec: echo hi") || !strings.Contains(out.Log, "logged") {
+		t.Errorf("expected the log to have the command and message but got %q", out.Log)
+	}
+	if out.Writers["progress"] != "50%" {
+		t.Errorf("expected the registered writer's output but got %q", out.Writers)
+	}
+	if progress != os.Stderr {
+		t.Error("expected the registered writer to be put back")
+	}
+}
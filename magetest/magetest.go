@@ -0,0 +1,234 @@
+// Package magetest runs a project's mage targets from its go tests, the same
+// way the mage command does: the magefiles are compiled into the same binary,
+// which is cached between runs, and run with the targets and their args, so
+// what's tested is what users run.  Each run gets its own working directory,
+// environment variables and input, and its output and exit code are captured
+// for the test to check, along with the targets that ran and the commands they
+// ran.  Capture does the same for code the test calls directly, like a
+// target's func.
+//
+// Since it's the in-repo mage code that compiles the magefiles, a library of
+// shared targets can be tested end to end by importing it into a magefile in
+// testdata, with // mage:import, and running it with Dir set to testdata.
+//
+//	func TestBuild(t *testing.T) {
+//		r := magetest.Mage{Dir: "..", Env: map[string]string{"VERSION": "v1.2.3"}}.MustRun(t, "build", "linux")
+//		if !strings.Contains(r.Stdout, "built v1.2.3") {
+//			t.Fatalf("unexpected output:\n%s", r.Stdout)
+//		}
+//	}
+package magetest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/mage"
+)
+
+// runMu keeps runs from happening at once, since mage sets up some of how it
+// compiles the magefiles for the whole process.
+var runMu sync.Mutex
+
+// Mage is how to run the targets.
+type Mage struct {
+	// Dir is the directory of the magefiles.  It defaults to the working
+	// directory, which for go test is the directory of the test's package.
+	Dir string
+
+	// WorkDir is the directory the targets run in.  It defaults to a new
+	// temporary directory, which is removed when the test ends, on versions
+	// of go where tests have Cleanup.
+	WorkDir string
+
+	// Env are variables to set for the targets, over those from the
+	// environment the tests run in.
+	Env map[string]string
+
+	// Stdin is what the targets read from standard input.  It defaults to
+	// nothing.
+	Stdin io.Reader
+}
+
+// Result is the result of a run.
+type Result struct {
+	// Code is the exit code, which is 0 if the targets succeeded.
+	Code int
+
+	// Stdout and Stderr are what the run printed.  Mage's own errors, like a
+	// target that doesn't exist, are on Stderr.
+	Stdout string
+	Stderr string
+
+	// WorkDir is the directory the targets ran in.
+	WorkDir string
+
+	// Targets are the targets that ran, and the dependencies they ran with
+	// mg.Deps, in the order they finished.
+	Targets []TargetResult
+
+	// Commands are the commands the targets ran with the sh package, in
+	// order, each with its args, separated by spaces.
+	Commands []string
+}
+
+// TargetResult is how a target, or a dependency, finished.
+type TargetResult struct {
+	// Name is the target's name, as mage reports it.
+	Name string
+
+	// Dependency reports whether it was run with mg.Deps, rather than
+	// from the command line.
+	Dependency bool
+
+	// Duration is how long it took.
+	Duration time.Duration
+
+	// Err is the error it failed with, or empty if it didn't.
+	Err string
+}
+
+// Target returns the result of the target or dependency with the name, which
+// is matched ignoring case, and whether it ran.
+func (r Result) Target(name string) (TargetResult, bool) {
+	for _, t := range r.Targets {
+		if strings.EqualFold(t.Name, name) {
+			return t, true
+		}
+	}
+	return TargetResult{}, false
+}
+
+// Run runs the targets with the Mage's defaults, as Mage.Run does.
+func Run(t testing.TB, args ...string) Result {
+	return Mage{}.Run(t, args...)
+}
+
+// Run runs mage with the args, which are the targets and their args, after
+// any of mage's flags, like -v, as they'd be given to the mage command.
+// It fails the test if mage can't be run at all, but not if the targets
+// fail, which the Result's Code says.
+func (m Mage) Run(t testing.TB, args ...string) Result {
+	t.Helper()
+	dir := m.Dir
+	if dir == "" {
+		dir = "."
+	}
+	work := m.WorkDir
+	if work == "" {
+		tmp, err := ioutil.TempDir("", "magetest")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if c, ok := t.(interface{ Cleanup(func()) }); ok {
+			c.Cleanup(func() { os.RemoveAll(tmp) })
+		}
+		work = tmp
+	}
+
+	var stdout, stderr bytes.Buffer
+	inv, cmd, err := mage.Parse(&stderr, &stdout, append([]string{"-d", dir, "-w", work}, args...))
+	if err == flag.ErrHelp {
+		return Result{Stdout: stdout.String(), Stderr: stderr.String(), WorkDir: work}
+	}
+	if err != nil {
+		t.Fatalf("invalid mage args %q: %v", args, err)
+	}
+	if cmd != mage.None {
+		t.Fatalf("magetest only runs targets, not mage commands like %q", args)
+	}
+	inv.Stderr = &stderr
+	inv.Stdin = m.Stdin
+	if inv.Stdin == nil {
+		inv.Stdin = strings.NewReader("")
+	}
+	for k, v := range m.Env {
+		inv.Env = append(inv.Env, k+"="+v)
+	}
+	sort.Strings(inv.Env)
+	// the results come from the run's events, unless it's run remotely,
+	// where they aren't written.
+	if inv.Events == "" && inv.Host == "" && inv.Container == "" {
+		f, err := ioutil.TempFile("", "magetest-events")
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		defer os.Remove(f.Name())
+		inv.Events = f.Name()
+	}
+
+	runMu.Lock()
+	code := mage.Invoke(inv)
+	runMu.Unlock()
+	r := Result{Code: code, Stdout: stdout.String(), Stderr: stderr.String(), WorkDir: work}
+	if inv.Events != "" {
+		if err := r.readEvents(inv.Events); err != nil {
+			t.Fatalf("can't read the events of the run: %v", err)
+		}
+	}
+	return r
+}
+
+// readEvents fills in the targets and commands from the events written by
+// the run, as described for MAGEFILE_EVENTS.
+func (r *Result) readEvents(path string) error {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// the magefile didn't run.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var ev struct {
+			Event      string
+			Target     string
+			Dependency bool
+			Duration   float64
+			Error      string
+			Command    string
+			Args       []string
+		}
+		if err := json.Unmarshal(scanner.Bytes(), &ev); err != nil {
+			return err
+		}
+		switch ev.Event {
+		case "target_end":
+			r.Targets = append(r.Targets, TargetResult{
+				Name:       ev.Target,
+				Dependency: ev.Dependency,
+				Duration:   time.Duration(ev.Duration * float64(time.Second)),
+				Err:        ev.Error,
+			})
+		case "exec":
+			r.Commands = append(r.Commands, strings.Join(append([]string{ev.Command}, ev.Args...), " "))
+		}
+	}
+	return scanner.Err()
+}
+
+// MustRun is like Run, but fails the test, with the run's output, if the
+// targets fail.
+func (m Mage) MustRun(t testing.TB, args ...string) Result {
+	t.Helper()
+	r := m.Run(t, args...)
+	if r.Code != 0 {
+		t.Fatalf("mage %s failed with exit code %d:\n%s%s", strings.Join(args, " "), r.Code, r.Stdout, r.Stderr)
+	}
+	return r
+}
@@ -0,0 +1,100 @@
+package magetest
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	m := Mage{Dir: "testdata", Env: map[string]string{"GREETING": "Hello"}}
+	r := m.MustRun(t, "greet", "mage")
+	if r.Stdout != "Hello, mage\n" {
+		t.Fatalf("unexpected output %q, stderr %q", r.Stdout, r.Stderr)
+	}
+
+	r = m.MustRun(t, "write")
+	if b, err := ioutil.ReadFile(filepath.Join(r.WorkDir, "out.txt")); err != nil || string(b) != "written" {
+		t.Fatalf("expected out.txt in the working directory, got %q, %v", b, err)
+	}
+	if _, err := ioutil.ReadFile(filepath.Join("testdata", "out.txt")); err == nil {
+		t.Fatal("expected nothing to be written next to the magefiles")
+	}
+
+	m.Stdin = strings.NewReader("from stdin")
+	if r := m.MustRun(t, "echo"); r.Stdout != "from stdin" {
+		t.Fatalf("unexpected output %q", r.Stdout)
+	}
+
+	r = m.Run(t, "fail")
+	if r.Code != 1 || !strings.Contains(r.Stderr, "it failed") {
+		t.Fatalf("expected the target to fail, got %+v", r)
+	}
+	r = m.Run(t, "nope")
+	if r.Code == 0 || !strings.Contains(r.Stderr, "Unknown target specified: nope") {
+		t.Fatalf("expected an unknown target, got %+v", r)
+	}
+
+	r = m.MustRun(t, "-l")
+	if !strings.Contains(r.Stdout, "greet") || !strings.Contains(r.Stdout, "greets the name") {
+		t.Fatalf("expected the targets to be listed, got %q", r.Stdout)
+	}
+}
+
+func TestResults(t *testing.T) {
+	m := Mage{Dir: "testdata"}
+	r := m.MustRun(t, "build")
+	if len(r.Targets) != 2 || !r.Targets[0].Dependency || r.Targets[1].Name != "Build" || r.Targets[1].Dependency {
+		t.Fatalf("expected Build and its dependency, got %+v", r.Targets)
+	}
+	if _, ok := r.Target("prepare"); !ok {
+		t.Fatalf("expected to find Prepare in %+v", r.Targets)
+	}
+	if len(r.Commands) != 1 || r.Commands[0] != "go version" {
+		t.Fatalf("expected go version to run, got %q", r.Commands)
+	}
+
+	r = m.Run(t, "fail")
+	if f, ok := r.Target("fail"); !ok || f.Err != "it failed" {
+		t.Fatalf("expected Fail's error, got %+v", r.Targets)
+	}
+	if r = m.Run(t, "nope"); len(r.Targets) != 0 {
+		t.Fatalf("expected no targets to run, got %+v", r.Targets)
+	}
+}
+
+// TestMageFeatures runs magefiles from mage's own tests, end to end.
+func TestMageFeatures(t *testing.T) {
+	testdata := filepath.Join("..", "mage", "testdata")
+
+	// targets imported with mage:import, with and without a namespace.
+	imports := Mage{Dir: filepath.Join(testdata, "mageimport")}
+	if r := imports.MustRun(t, "zz:ns:deploy2"); r.Stdout != "deploy2\n" {
+		t.Fatalf("unexpected output %q", r.Stdout)
+	}
+	if r := imports.MustRun(t, "buildSubdir", "root"); r.Stdout != "buildsubdir\nroot\n" || len(r.Targets) != 2 {
+		t.Fatalf("unexpected output %q, targets %+v", r.Stdout, r.Targets)
+	}
+
+	// args, which are parsed for the target's parameters.
+	args := Mage{Dir: filepath.Join(testdata, "args")}
+	if r := args.MustRun(t, "greet", "bob", "2"); r.Stdout != "hi bob\nhi bob\n" {
+		t.Fatalf("unexpected output %q", r.Stdout)
+	}
+	if r := args.Run(t, "greet", "bob", "two"); r.Code != 2 || len(r.Targets) != 0 {
+		t.Fatalf("expected a bad arg to fail before the target runs, got %+v", r)
+	}
+
+	// namespaces, with their methods as dependencies.
+	r := Mage{Dir: filepath.Join(testdata, "namespaces")}.MustRun(t, "testNamespaceDep")
+	deps := 0
+	for _, target := range r.Targets {
+		if target.Dependency {
+			deps++
+		}
+	}
+	if deps != 4 || r.Stdout != "hi!\n" {
+		t.Fatalf("expected the namespace's 4 methods to run as dependencies, got %q, %+v", r.Stdout, r.Targets)
+	}
+}
@@ -0,0 +1,183 @@
+package magetest
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magefile/mage/sh"
+)
+
+// CommandRecorder fakes the commands run with the sh package, and records
+// them, so a test can check what a target ran, and in what order, without
+// running it.  Commands succeed without output, unless Respond says
+// otherwise.
+//
+// The assertions take patterns, which are commands and their args, separated
+// by spaces.  A * matches any one arg, and a trailing ... matches any args
+// that are left, including none, so "docker build ..." matches any docker
+// build.
+type CommandRecorder struct {
+	mu        sync.Mutex
+	cmds      []sh.Cmd
+	responses []response
+	old       sh.Executor
+}
+
+// response is what the commands that match a pattern do.
+type response struct {
+	pattern string
+	stdout  string
+	code    int
+}
+
+// RecordCommands starts recording the commands run with the sh package, in
+// place of running them, until the test ends, on versions of go where tests
+// have Cleanup, or Stop is called.
+func RecordCommands(t testing.TB) *CommandRecorder {
+	r := &CommandRecorder{old: sh.Fake}
+	sh.Fake = r
+	if c, ok := t.(interface{ Cleanup(func()) }); ok {
+		c.Cleanup(r.Stop)
+	}
+	return r
+}
+
+// Stop stops recording, and runs commands the way they were before.
+func (r *CommandRecorder) Stop() {
+	if sh.Fake == r {
+		sh.Fake = r.old
+	}
+}
+
+// Respond has the commands that match the pattern print stdout and exit with
+// the code.  The first pattern that matches a command is used.  A code of -1
+// is for a command that isn't found.
+func (r *CommandRecorder) Respond(pattern, stdout string, code int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.responses = append(r.responses, response{pattern: pattern, stdout: stdout, code: code})
+}
+
+// Execute records the command, and does what Respond said for it.
+func (r *CommandRecorder) Execute(c sh.Cmd) (ran bool, code int) {
+	r.mu.Lock()
+	c.Args = append([]string(nil), c.Args...)
+	r.cmds = append(r.cmds, c)
+	var resp *response
+	for i := range r.responses {
+		if match(r.responses[i].pattern, c) {
+			resp = &r.responses[i]
+			break
+		}
+	}
+	r.mu.Unlock()
+	if resp == nil {
+		return true, 0
+	}
+	if resp.code == -1 {
+		return false, -1
+	}
+	if c.Stdout != nil {
+		io.WriteString(c.Stdout, resp.stdout)
+	}
+	return true, resp.code
+}
+
+// Commands returns the commands that were run, in order.
+func (r *CommandRecorder) Commands() []sh.Cmd {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]sh.Cmd(nil), r.cmds...)
+}
+
+// AssertRan fails the test unless a command that matches the pattern was
+// run.
+func (r *CommandRecorder) AssertRan(t testing.TB, pattern string) {
+	t.Helper()
+	cmds := r.Commands()
+	for _, c := range cmds {
+		if match(pattern, c) {
+			return
+		}
+	}
+	t.Errorf("expected %q to run, but it didn't.\n%s", pattern, listing(cmds, nil))
+}
+
+// AssertNotRun fails the test if a command that matches the pattern was run.
+func (r *CommandRecorder) AssertNotRun(t testing.TB, pattern string) {
+	t.Helper()
+	cmds := r.Commands()
+	marks := map[int]string{}
+	for i, c := range cmds {
+		if match(pattern, c) {
+			marks[i] = "!"
+		}
+	}
+	if len(marks) > 0 {
+		t.Errorf("expected %q not to run, but it did, marked with !.\n%s", pattern, listing(cmds, marks))
+	}
+}
+
+// AssertOrder fails the test unless commands that match the patterns were
+// run in the order they're given, not necessarily one after another.
+func (r *CommandRecorder) AssertOrder(t testing.TB, patterns ...string) {
+	t.Helper()
+	cmds := r.Commands()
+	marks := map[int]string{}
+	next := 0
+	for i, c := range cmds {
+		if next < len(patterns) && match(patterns[next], c) {
+			next++
+			marks[i] = fmt.Sprint(next)
+		}
+	}
+	if next == len(patterns) {
+		return
+	}
+	var want strings.Builder
+	for i, p := range patterns {
+		mark := " "
+		if i == next {
+			mark = "-"
+		}
+		fmt.Fprintf(&want, "%s %d. %s\n", mark, i+1, p)
+	}
+	t.Errorf("expected the commands to run in this order, but %q didn't run after the ones before it:\n%s%s",
+		patterns[next], want.String(), listing(cmds, marks))
+}
+
+// listing lists the commands that were run, with the marks in front of them,
+// for a failure message.
+func listing(cmds []sh.Cmd, marks map[int]string) string {
+	if len(cmds) == 0 {
+		return "No commands were run.\n"
+	}
+	var b strings.Builder
+	b.WriteString("Commands run:\n")
+	for i, c := range cmds {
+		mark := marks[i]
+		if mark == "" {
+			mark = " "
+		}
+		fmt.Fprintf(&b, "%s %s\n", mark, c)
+	}
+	return b.String()
+}
+
+// match reports whether the command matches the pattern.
+func match(pattern string, c sh.Cmd) bool {
+	words := strings.Fields(pattern)
+	args := append([]string{c.Name}, c.Args...)
+	for i, w := range words {
+		if w == "..." && i == len(words)-1 {
+			return true
+		}
+		if i >= len(args) || (w != "*" && w != args[i]) {
+			return false
+		}
+	}
+	return len(words) == len(args)
+}
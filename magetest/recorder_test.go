@@ -0,0 +1,101 @@
+package magetest
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/sh"
+)
+
+// fakeT records the errors an assertion reports, rather than failing the
+// test.
+type fakeT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestCommandRecorder(t *testing.T) {
+	r := RecordCommands(t)
+	defer r.Stop()
+	r.Respond("git describe ...", "v1.2.3\n", 0)
+	r.Respond("docker push *", "", 1)
+	r.Respond("helm ...", "", -1)
+
+	version, err := sh.Output("git", "describe", "--tags")
+	if err != nil || version != "v1.2.3" {
+		t.Fatalf("expected the faked version but got %q, %v", version, err)
+	}
+	if err := sh.Run("docker", "build", "-t", "app:"+version, "."); err != nil {
+		t.Fatal(err)
+	}
+	err = sh.Run("docker", "push", "app:"+version)
+	if code := sh.ExitStatus(err); code != 1 {
+		t.Fatalf("expected the push to fail with exit code 1 but got %v", err)
+	}
+	if ran, err := sh.Exec(nil, nil, nil, "helm", "upgrade"); ran || err == nil {
+		t.Fatalf("expected helm not to be found but got %v, %v", ran, err)
+	}
+	if n := len(r.Commands()); n != 4 {
+		t.Fatalf("expected 4 commands but got %d", n)
+	}
+
+	r.AssertRan(t, "docker build -t app:v1.2.3 .")
+	r.AssertRan(t, "docker build ...")
+	r.AssertNotRun(t, "docker build")
+	r.AssertNotRun(t, "kubectl ...")
+	r.AssertOrder(t, "git describe ...", "docker build ...", "docker push *")
+
+	ft := &fakeT{TB: t}
+	r.AssertRan(ft, "docker tag ...")
+	r.AssertNotRun(ft, "docker * app:v1.2.3")
+	r.AssertOrder(ft, "docker build ...", "git describe --tags", "docker push *")
+	if len(ft.errors) != 3 {
+		t.Fatalf("expected 3 failures but got %q", ft.errors)
+	}
+	expected := []string{
+		`expected "docker tag ..." to run, but it didn't.
+Commands run:
+  git describe --tags
+  docker build -t app:v1.2.3 .
+  docker push app:v1.2.3
+  helm upgrade
+`,
+		`expected "docker * app:v1.2.3" not to run, but it did, marked with !.
+Commands run:
+  git describe --tags
+  docker build -t app:v1.2.3 .
+! docker push app:v1.2.3
+  helm upgrade
+`,
+		`expected the commands to run in this order, but "git describe --tags" didn't run after the ones before it:
+  1. docker build ...
+- 2. git describe --tags
+  3. docker push *
+Commands run:
+  git describe --tags
+1 docker build -t app:v1.2.3 .
+  docker push app:v1.2.3
+  helm upgrade
+`,
+	}
+	for i, e := range expected {
+		if ft.errors[i] != e {
+			t.Errorf("expected failure:\n%s\ngot:\n%s", e, ft.errors[i])
+		}
+	}
+
+	r.Stop()
+	if sh.Fake != nil {
+		t.Fatal("expected the recorder to stop faking commands")
+	}
+	if out, err := sh.Output("go", "env", "GOOS"); err != nil || strings.TrimSpace(out) == "" {
+		t.Fatalf("expected go to run for real but got %q, %v", out, err)
+	}
+}
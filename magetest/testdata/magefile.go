@@ -0,0 +1,44 @@
+// +build mage
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Greet greets the name, the way GREETING says to.
+func Greet(name string) {
+	fmt.Printf("%s, %s\n", os.Getenv("GREETING"), name)
+}
+
+// Write writes out.txt in the working directory.
+func Write() error {
+	return ioutil.WriteFile("out.txt", []byte("written"), 0644)
+}
+
+// Echo prints what it reads from stdin.
+func Echo() error {
+	_, err := io.Copy(os.Stdout, os.Stdin)
+	return err
+}
+
+// Build prepares, then runs go version.
+func Build() error {
+	mg.Deps(Prepare)
+	return sh.Run(mg.GoCmd(), "version")
+}
+
+// Prepare does nothing.
+func Prepare() {}
+
+// Fail fails.
+func Fail() error {
+	return errors.New("it failed")
+}
@@ -0,0 +1,102 @@
+package mg
+
+import (
+	"context"
+)
+
+// scope is a target or dependency that's running, and the dependencies it has
+// run with the context it was given.  A dependency's cleanup runs once every
+// scope holding it has ended.
+type scope struct {
+	deps []*onceFun
+}
+
+// root holds the dependencies run with a context that isn't a target's or a
+// dependency's, like the one Deps uses, until RunCleanups.
+var root = &scope{}
+
+// scopeKey is the key of the scope in the contexts given to targets and
+// dependencies.
+type scopeKey struct{}
+
+// withScope returns ctx with s, for the target or dependency it's given to.
+func withScope(ctx context.Context, s *scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, s)
+}
+
+// scopeOf returns the scope of the target or dependency ctx was given to, or
+// nil.
+func scopeOf(ctx context.Context) *scope {
+	s, _ := ctx.Value(scopeKey{}).(*scope)
+	return s
+}
+
+// hold records that s depends on o, unless it already does.  It's called with
+// onces.mu held.
+func (s *scope) hold(o *onceFun) {
+	for _, d := range s.deps {
+		if d == o {
+			return
+		}
+	}
+	s.deps = append(s.deps, o)
+	o.holders++
+}
+
+// end releases the dependencies s holds, and runs the cleanups of those no
+// other scope holds, in reverse order, so a dependency is cleaned up after
+// the ones that ran after it.
+func (s *scope) end() {
+	onces.mu.Lock()
+	var done []*onceFun
+	for i := len(s.deps) - 1; i >= 0; i-- {
+		o := s.deps[i]
+		o.holders--
+		if o.holders == 0 && o.cleanup != nil {
+			done = append(done, o)
+		}
+	}
+	s.deps = nil
+	onces.mu.Unlock()
+	for _, o := range done {
+		o.runCleanup()
+	}
+}
+
+// runCleanup runs the cleanup o's func returned, once.  A cleanup that
+// panics doesn't stop the others from running.
+func (o *onceFun) runCleanup() {
+	onces.mu.Lock()
+	cleanup := o.cleanup
+	o.cleanup = nil
+	onces.mu.Unlock()
+	if cleanup == nil {
+		return
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			logger.Printf("cleanup of %s panicked: %v", o.displayName, r)
+		}
+	}()
+	cleanup()
+}
+
+// call calls o's func in s, a scope of its own, so the dependencies it runs
+// with its context are cleaned up once it has finished, unless something
+// else needs them.
+func (o *onceFun) call(s *scope) {
+	defer s.end()
+	cleanup, err := o.fn(withScope(o.ctx, s))
+	onces.mu.Lock()
+	o.cleanup = cleanup
+	onces.mu.Unlock()
+	o.err = err
+}
+
+// RunCleanups runs the cleanups of the dependencies that weren't run with the
+// context of a target or dependency, like those run with Deps.  It's called
+// by the mainfile mage generates once the targets have finished; magefiles
+// don't need it.
+func RunCleanups() {
+	root.end()
+}
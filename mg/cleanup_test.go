@@ -0,0 +1,126 @@
+package mg
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"reflect"
+	"testing"
+)
+
+type cleanupNS Namespace
+
+var cleanedUp []string
+
+func (cleanupNS) Start(context.Context) (func(), error) {
+	return func() { cleanedUp = append(cleanedUp, "ns") }, nil
+}
+
+func TestDepCleanups(t *testing.T) {
+	cleanedUp = nil
+	first := func() (func(), error) {
+		return func() { cleanedUp = append(cleanedUp, "first") }, nil
+	}
+	second := func(context.Context) (func(), error) {
+		return func() { cleanedUp = append(cleanedUp, "second") }, nil
+	}
+	SerialDeps(first, second, cleanupNS.Start)
+	if len(cleanedUp) != 0 {
+		t.Fatalf("expected no cleanups to run yet, but got %q", cleanedUp)
+	}
+	// the test isn't a target or dependency, so they run with RunCleanups.
+	RunCleanups()
+	expected := []string{"ns", "second", "first"}
+	if !reflect.DeepEqual(cleanedUp, expected) {
+		t.Fatalf("expected cleanups to run in reverse order %q, but got %q", expected, cleanedUp)
+	}
+	if flag.Lookup("mage.cleanups") != nil {
+		t.Error("unexpected flag -mage.cleanups")
+	}
+}
+
+func TestDepCleanupError(t *testing.T) {
+	fn := func() (func(), error) {
+		return nil, errors.New("oops")
+	}
+	defer func() {
+		v := recover()
+		if v == nil {
+			t.Fatal("expected panic, but didn't get one")
+		}
+		if actual := v.(error).Error(); actual != "oops" {
+			t.Fatalf("expected error to be oops, but was %q", actual)
+		}
+	}()
+	Deps(fn)
+}
+
+func TestDepCleanupsWhenDependentsFinish(t *testing.T) {
+	var cleaned []string
+	server := func() (func(), error) {
+		cleaned = append(cleaned, "started")
+		return func() { cleaned = append(cleaned, "stopped") }, nil
+	}
+	// other finishes first, while client still needs the server.
+	clientStarted, otherDone := make(chan struct{}), make(chan struct{})
+	client := func(ctx context.Context) {
+		CtxDeps(ctx, server)
+		close(clientStarted)
+		<-otherDone
+		if len(cleaned) != 1 {
+			t.Errorf("expected the server to be running, but got %q", cleaned)
+		}
+	}
+	other := func(ctx context.Context) {
+		<-clientStarted
+		CtxDeps(ctx, server)
+		close(otherDone)
+	}
+	parent := func(ctx context.Context) {
+		CtxDeps(ctx, client, other)
+	}
+	RunTarget(context.Background(), func(ctx context.Context) {
+		CtxDeps(ctx, parent)
+	})
+	expected := []string{"started", "stopped"}
+	if !reflect.DeepEqual(cleaned, expected) {
+		t.Fatalf("expected the server to be stopped once its dependents finished, but got %q", cleaned)
+	}
+	// dependencies still run exactly once, even after their cleanup ran.
+	Deps(server)
+	RunCleanups()
+	if !reflect.DeepEqual(cleaned, expected) {
+		t.Fatalf("expected the server not to run again, but got %q", cleaned)
+	}
+}
+
+func TestRunTargetCleanups(t *testing.T) {
+	var cleaned []string
+	dep := func() (func(), error) {
+		return func() { cleaned = append(cleaned, "dep") }, nil
+	}
+	// the other target runs at the same time, and finishes first.
+	started, otherDone := make(chan struct{}), make(chan struct{})
+	go RunTarget(context.Background(), func(context.Context) {
+		<-started
+		close(otherDone)
+	})
+	RunTarget(context.Background(), func(ctx context.Context) {
+		// the goroutine is given the target's context, so its dependency is
+		// the target's, not the other one's.
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			CtxDeps(ctx, dep)
+		}()
+		<-done
+		close(started)
+		<-otherDone
+		if len(cleaned) != 0 {
+			t.Errorf("expected no cleanups to run before the target finished, but got %q", cleaned)
+		}
+	})
+	if !reflect.DeepEqual(cleaned, []string{"dep"}) {
+		t.Fatalf("expected the dependency to be cleaned up once the target finished, but got %q", cleaned)
+	}
+}
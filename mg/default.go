@@ -0,0 +1,18 @@
+package mg
+
+// DefaultFunc chooses the targets mage runs when none are given on the
+// command line, by the names they're run with, e.g. "build:all".
+type DefaultFunc func() []string
+
+// DefaultFn lets a magefile choose its default targets when mage runs,
+// rather than when it's written, e.g. to build differently on each platform:
+//
+//	var Default = mg.DefaultFn(func() []string {
+//		if runtime.GOOS == "windows" {
+//			return []string{"build:windows"}
+//		}
+//		return []string{"build:unix"}
+//	})
+func DefaultFn(fn func() []string) DefaultFunc {
+	return fn
+}
@@ -7,8 +7,11 @@ import (
 	"os"
 	"reflect"
 	"runtime"
+	"runtime/trace"
 	"strings"
 	"sync"
+
+	"github.com/magefile/mage/clock"
 )
 
 // funcType indicates a prototype of build job function
@@ -25,25 +28,40 @@ const (
 	namespaceErrorType
 	namespaceContextVoidType
 	namespaceContextErrorType
+	cleanupType
+	contextCleanupType
+	namespaceCleanupType
+	namespaceContextCleanupType
 )
 
-var logger = log.New(os.Stderr, "", 0)
+var logger = log.New(stderr{}, "", 0)
+
+// stderr writes to whatever os.Stderr is at the time of writing, so that
+// output can be redirected after mg is initialized, e.g. by mage -timestamps.
+type stderr struct{}
+
+func (stderr) Write(b []byte) (int, error) {
+	return os.Stderr.Write(b)
+}
 
 type onceMap struct {
 	mu *sync.Mutex
 	m  map[string]*onceFun
 }
 
-func (o *onceMap) LoadOrStore(s string, one *onceFun) *onceFun {
+// LoadOrStore returns the onceFun stored under s, storing one if there isn't
+// one, and records that holder depends on it.
+func (o *onceMap) LoadOrStore(s string, one *onceFun, holder *scope) *onceFun {
 	defer o.mu.Unlock()
 	o.mu.Lock()
 
 	existing, ok := o.m[s]
-	if ok {
-		return existing
+	if !ok {
+		o.m[s] = one
+		existing = one
 	}
-	o.m[s] = one
-	return one
+	holder.hold(existing)
+	return existing
 }
 
 var onces = &onceMap{
@@ -78,7 +96,11 @@ func SerialCtxDeps(ctx context.Context, fns ...interface{}) {
 //     func() error
 //     func(context.Context)
 //     func(context.Context) error
-// Or a similar method on a mg.Namespace type.
+//     func() (func(), error)
+//     func(context.Context) (func(), error)
+// Or a similar method on a mg.Namespace type.  A cleanup func returned along
+// with the error is run once the targets and dependencies that ran it with
+// their context have finished, or else once all the targets have finished.
 //
 // The function calling Deps is guaranteed that all dependent functions will be
 // run exactly once when Deps returns.  Dependent functions may in turn declare
@@ -92,12 +114,16 @@ func CtxDeps(ctx context.Context, fns ...interface{}) {
 
 // runDeps assumes you've already called checkFns.
 func runDeps(ctx context.Context, types []funcType, fns []interface{}) {
+	holder := scopeOf(ctx)
+	if holder == nil {
+		holder = root
+	}
 	mu := &sync.Mutex{}
 	var errs []string
 	var exit int
 	wg := &sync.WaitGroup{}
 	for i, f := range fns {
-		fn := addDep(ctx, types[i], f)
+		fn := addDep(ctx, holder, types[i], f)
 		wg.Add(1)
 		go func() {
 			defer func() {
@@ -122,7 +148,10 @@ func runDeps(ctx context.Context, types []funcType, fns []interface{}) {
 		}()
 	}
 
+	// with -j, the caller doesn't count against the limit while it waits.
+	resume := yieldJob()
 	wg.Wait()
+	resume()
 	if len(errs) > 0 {
 		panic(Fatal(exit, strings.Join(errs, "\n")))
 	}
@@ -146,7 +175,11 @@ func checkFns(fns []interface{}) []funcType {
 //     func() error
 //     func(context.Context)
 //     func(context.Context) error
-// Or a similar method on a mg.Namespace type.
+//     func() (func(), error)
+//     func(context.Context) (func(), error)
+// Or a similar method on a mg.Namespace type.  A cleanup func returned along
+// with the error is run once all the targets have finished; use CtxDeps with
+// the context of the target to run it once the target has finished.
 //
 // This is a way to build up a tree of dependencies with each dependency
 // defining its own dependencies.  Functions must have the same signature as a
@@ -170,7 +203,7 @@ func changeExit(old, new int) int {
 	return 1
 }
 
-func addDep(ctx context.Context, t funcType, f interface{}) *onceFun {
+func addDep(ctx context.Context, holder *scope, t funcType, f interface{}) *onceFun {
 	fn := funcTypeWrap(t, f)
 
 	n := name(f)
@@ -179,7 +212,7 @@ func addDep(ctx context.Context, t funcType, f interface{}) *onceFun {
 		ctx: ctx,
 
 		displayName: displayName(n),
-	})
+	}, holder)
 	return of
 }
 
@@ -197,19 +230,52 @@ func displayName(name string) string {
 
 type onceFun struct {
 	once sync.Once
-	fn   func(context.Context) error
+	fn   func(context.Context) (func(), error)
 	ctx  context.Context
 	err  error
 
+	// cleanup is the cleanup func fn returned, if any, and holders is how
+	// many targets and dependencies that depend on it haven't finished.
+	// Both are guarded by onces.mu.
+	cleanup func()
+	holders int
+
 	displayName string
 }
 
 func (o *onceFun) run() error {
 	o.once.Do(func() {
-		if Verbose() {
+		l := CurrentLogger()
+		switch {
+		case l == nil && Verbose():
 			logger.Println("Running dependency:", o.displayName)
+		case l != nil:
+			if Verbose() {
+				l.Verbose("Running dependency: " + o.displayName)
+			}
+			l.TargetStart(o.displayName)
+			start := clock.Now()
+			defer func() {
+				l.TargetEnd(o.displayName, o.err, clock.Since(start))
+			}()
 		}
-		o.err = o.fn(o.ctx)
+		EmitEvent("target_start", map[string]interface{}{"target": o.displayName, "dependency": true})
+		start := clock.Now()
+		// shows up in execution traces, e.g. from mage -trace.
+		trace.WithRegion(o.ctx, o.displayName, func() {
+			release := acquireJob()
+			defer release()
+			holdJob(func() { o.call(&scope{}) })
+		})
+		end := map[string]interface{}{
+			"target":     o.displayName,
+			"dependency": true,
+			"duration":   clock.Since(start).Seconds(),
+		}
+		if o.err != nil {
+			end["error"] = o.err.Error()
+		}
+		EmitEvent("target_end", end)
 	})
 	return o.err
 }
@@ -240,9 +306,13 @@ func funcCheck(fn interface{}) (funcType, error) {
 		return contextVoidType, nil
 	case func(context.Context) error:
 		return contextErrorType, nil
+	case func() (func(), error):
+		return cleanupType, nil
+	case func(context.Context) (func(), error):
+		return contextCleanupType, nil
 	}
 
-	err := fmt.Errorf("Invalid type for dependent function: %T. Dependencies must be func(), func() error, func(context.Context), func(context.Context) error, or the same method on an mg.Namespace @ %s", fn, causeLocation())
+	err := fmt.Errorf("Invalid type for dependent function: %T. Dependencies must be func(), func() error, func(context.Context), func(context.Context) error, either of the last two returning (func(), error), or the same method on an mg.Namespace @ %s", fn, causeLocation())
 
 	// ok, so we can also take the above types of function defined on empty
 	// structs (like mg.Namespace). When you pass a method of a type, it gets
@@ -255,7 +325,12 @@ func funcCheck(fn interface{}) (funcType, error) {
 		return invalidType, err
 	}
 
-	if t.NumOut() > 1 {
+	if t.NumOut() > 2 {
+		return invalidType, err
+	}
+	// a cleanup func and an error.
+	cleanup := t.NumOut() == 2
+	if cleanup && (t.Out(0) != reflect.TypeOf(func() {}) || t.Out(1) != reflect.TypeOf((*error)(nil)).Elem()) {
 		return invalidType, err
 	}
 	if t.NumOut() == 1 && t.Out(0) == reflect.TypeOf(err) {
@@ -276,6 +351,9 @@ func funcCheck(fn interface{}) (funcType, error) {
 		return invalidType, err
 	}
 	if t.NumIn() == 1 {
+		if cleanup {
+			return namespaceCleanupType, nil
+		}
 		if t.NumOut() == 0 {
 			return namespaceVoidType, nil
 		}
@@ -286,67 +364,97 @@ func funcCheck(fn interface{}) (funcType, error) {
 		return invalidType, err
 	}
 
+	if cleanup {
+		return namespaceContextCleanupType, nil
+	}
 	if t.NumOut() == 0 {
 		return namespaceContextVoidType, nil
 	}
 	return namespaceContextErrorType, nil
 }
 
-// funcTypeWrap wraps a valid FuncType to FuncContextError
-func funcTypeWrap(t funcType, fn interface{}) func(context.Context) error {
+// funcTypeWrap wraps a valid FuncType to a func taking a context and
+// returning a cleanup func, which may be nil, and an error.
+func funcTypeWrap(t funcType, fn interface{}) func(context.Context) (func(), error) {
 	switch f := fn.(type) {
 	case func():
-		return func(context.Context) error {
+		return func(context.Context) (func(), error) {
 			f()
-			return nil
+			return nil, nil
 		}
 	case func() error:
-		return func(context.Context) error {
-			return f()
+		return func(context.Context) (func(), error) {
+			return nil, f()
 		}
 	case func(context.Context):
-		return func(ctx context.Context) error {
+		return func(ctx context.Context) (func(), error) {
 			f(ctx)
-			return nil
+			return nil, nil
 		}
 	case func(context.Context) error:
+		return func(ctx context.Context) (func(), error) {
+			return nil, f(ctx)
+		}
+	case func() (func(), error):
+		return func(context.Context) (func(), error) {
+			return f()
+		}
+	case func(context.Context) (func(), error):
 		return f
 	}
 	args := []reflect.Value{reflect.ValueOf(struct{}{})}
 	switch t {
 	case namespaceVoidType:
-		return func(context.Context) error {
+		return func(context.Context) (func(), error) {
 			v := reflect.ValueOf(fn)
 			v.Call(args)
-			return nil
+			return nil, nil
 		}
 	case namespaceErrorType:
-		return func(context.Context) error {
+		return func(context.Context) (func(), error) {
 			v := reflect.ValueOf(fn)
 			ret := v.Call(args)
 			val := ret[0].Interface()
 			if val == nil {
-				return nil
+				return nil, nil
 			}
-			return val.(error)
+			return nil, val.(error)
 		}
 	case namespaceContextVoidType:
-		return func(ctx context.Context) error {
+		return func(ctx context.Context) (func(), error) {
 			v := reflect.ValueOf(fn)
 			v.Call(append(args, reflect.ValueOf(ctx)))
-			return nil
+			return nil, nil
 		}
 	case namespaceContextErrorType:
-		return func(ctx context.Context) error {
+		return func(ctx context.Context) (func(), error) {
 			v := reflect.ValueOf(fn)
 			ret := v.Call(append(args, reflect.ValueOf(ctx)))
 			val := ret[0].Interface()
 			if val == nil {
-				return nil
+				return nil, nil
 			}
-			return val.(error)
+			return nil, val.(error)
+		}
+	case namespaceCleanupType:
+		return func(context.Context) (func(), error) {
+			v := reflect.ValueOf(fn)
+			return callCleanup(v.Call(args))
+		}
+	case namespaceContextCleanupType:
+		return func(ctx context.Context) (func(), error) {
+			v := reflect.ValueOf(fn)
+			return callCleanup(v.Call(append(args, reflect.ValueOf(ctx))))
 		}
 	default:
 		panic(fmt.Errorf("Don't know how to deal with dep of type %T", fn))
 	}
 }
+
+// callCleanup returns the cleanup func and error returned by a namespace
+// method called with reflection.
+func callCleanup(ret []reflect.Value) (func(), error) {
+	cleanup, _ := ret[0].Interface().(func())
+	err, _ := ret[1].Interface().(error)
+	return cleanup, err
+}
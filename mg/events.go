@@ -0,0 +1,60 @@
+package mg
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/magefile/mage/clock"
+)
+
+var (
+	eventsMu   sync.Mutex
+	eventsPath string
+	eventsFile *os.File
+)
+
+// EmitEvent writes an event to the stream requested with mage -events, if
+// any, as a line of JSON with the time, the event name, and the given fields.
+// Mage emits events for the run, each target and dependency, and each command
+// run with the sh package; magefiles can emit their own, e.g. to report
+// progress to a dashboard watching the stream.  It does nothing if no stream
+// was requested.
+func EmitEvent(event string, fields map[string]interface{}) {
+	path := os.Getenv(EventsEnv)
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if path != eventsPath {
+		if eventsFile != nil {
+			eventsFile.Close()
+			eventsFile = nil
+		}
+		eventsPath = path
+		if path != "" {
+			// the mainfile writes to the same file, so each event is appended
+			// with a single write.
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+			if err != nil {
+				logger.Printf("Error opening the events file: %v", err)
+				return
+			}
+			eventsFile = f
+		}
+	}
+	if eventsFile == nil {
+		return
+	}
+	ev := map[string]interface{}{}
+	for k, v := range fields {
+		ev[k] = v
+	}
+	ev["time"] = clock.Now().UTC().Format(time.RFC3339Nano)
+	ev["event"] = event
+	b, err := json.Marshal(ev)
+	if err != nil {
+		logger.Printf("Error writing event %s: %v", event, err)
+		return
+	}
+	eventsFile.Write(append(b, '\n'))
+}
@@ -0,0 +1,43 @@
+package mg
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEmitEvent(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "events.jsonl")
+
+	// nothing is written without a stream.
+	os.Unsetenv(EventsEnv)
+	EmitEvent("ignored", nil)
+
+	os.Setenv(EventsEnv, path)
+	defer os.Unsetenv(EventsEnv)
+	EmitEvent("deployed", map[string]interface{}{"env": "prod"})
+	// close the file before it's removed.
+	defer func() {
+		os.Unsetenv(EventsEnv)
+		EmitEvent("ignored", nil)
+	}()
+
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ev map[string]interface{}
+	if err := json.Unmarshal(b, &ev); err != nil {
+		t.Fatalf("invalid event %q: %v", b, err)
+	}
+	if ev["event"] != "deployed" || ev["env"] != "prod" || ev["time"] == nil {
+		t.Errorf("unexpected event %q", b)
+	}
+}
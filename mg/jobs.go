@@ -0,0 +1,149 @@
+package mg
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+var (
+	jobsMu sync.Mutex
+	jobs   chan struct{}
+
+	// holding are the ids of the goroutines running targets and
+	// dependencies in a slot.  It's guarded by jobsMu.
+	holding = map[uint64]bool{}
+)
+
+// jobSlots returns the channel that holds a value for each target,
+// dependency or command running, when their number is limited with -j, or
+// nil.
+func jobSlots() chan struct{} {
+	n := Jobs()
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	if n == 0 {
+		return nil
+	}
+	if cap(jobs) != n {
+		jobs = make(chan struct{}, n)
+	}
+	return jobs
+}
+
+// acquireJob waits for a target or dependency to be allowed to run, and
+// returns the func to call when it's done.
+func acquireJob() (release func()) {
+	slots := jobSlots()
+	if slots == nil {
+		return func() {}
+	}
+	slots <- struct{}{}
+	return func() {
+		// the slot may have been taken by a caller that yielded one it
+		// wasn't holding, so this mustn't block.
+		select {
+		case <-slots:
+		default:
+		}
+	}
+}
+
+// yieldJob frees the slot of a target or dependency while it waits for its
+// dependencies, or a job, so they can run in its place, and returns the func
+// that waits to take a slot back.  It does nothing if the caller isn't
+// running in a slot.
+func yieldJob() (resume func()) {
+	slots := jobSlots()
+	if slots == nil || !holdingJob() {
+		return func() {}
+	}
+	select {
+	case <-slots:
+		return func() { slots <- struct{}{} }
+	default:
+		// the limit changed since the caller took its slot.
+		return func() {}
+	}
+}
+
+// holdJob runs fn, a target or dependency, in the slot the calling goroutine
+// has taken, so the commands it runs, which have no context to tell where
+// they're run from, can take its place.
+func holdJob(fn func()) {
+	id := goid()
+	jobsMu.Lock()
+	holding[id] = true
+	jobsMu.Unlock()
+	defer func() {
+		jobsMu.Lock()
+		delete(holding, id)
+		jobsMu.Unlock()
+	}()
+	fn()
+}
+
+// holdingJob reports whether the caller is running in the slot of a target or
+// dependency, rather than in a goroutine one started.
+func holdingJob() bool {
+	id := goid()
+	jobsMu.Lock()
+	defer jobsMu.Unlock()
+	return holding[id]
+}
+
+// goid returns the id of the calling goroutine, from the first line of its
+// stack trace, e.g. "goroutine 7 [running]:".
+func goid() uint64 {
+	b := make([]byte, 64)
+	b = bytes.TrimPrefix(b[:runtime.Stack(b, false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i >= 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseUint(string(b), 10, 64)
+	return id
+}
+
+// StartJob waits until fewer targets, dependencies and commands are running
+// than the limit set with mage -j, if there is one, and returns the func to
+// call when the job is done.  It's for work like the commands the sh package
+// runs.  Called from a target or dependency, the job takes its place until
+// it's done, rather than waiting behind it; called from a goroutine one
+// started, the job waits for a slot of its own, so the target should wait on
+// the goroutine with Yield.
+func StartJob() (done func()) {
+	resume := yieldJob()
+	release := acquireJob()
+	return func() {
+		release()
+		resume()
+	}
+}
+
+// Yield calls wait, which waits on work the calling target or dependency
+// started in other goroutines, like the tasks of the pool package, and lets
+// that work run in the caller's place with mage -j meanwhile.  Without it, a
+// target that waits on commands run from its goroutines deadlocks with -j 1.
+func Yield(wait func()) {
+	resume := yieldJob()
+	defer resume()
+	wait()
+}
+
+// RunTarget runs fn, a target the mainfile mage generates runs, with ctx once
+// it's allowed to with mage -j.  It's called by the mainfile, so the targets
+// count against the same limit as their dependencies and commands; magefiles
+// don't need it.
+//
+// The dependencies the target runs with CtxDeps and the context it's given
+// are cleaned up once it has finished, unless another target running at the
+// same time still needs them.
+func RunTarget(ctx context.Context, fn func(context.Context)) {
+	s := &scope{}
+	defer s.end()
+	release := acquireJob()
+	defer release()
+	holdJob(func() { fn(withScope(ctx, s)) })
+}
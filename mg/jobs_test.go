@@ -0,0 +1,110 @@
+package mg
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDepsJobs(t *testing.T) {
+	os.Setenv(JobsEnv, "2")
+	defer os.Unsetenv(JobsEnv)
+
+	var mu sync.Mutex
+	running, max := 0, 0
+	work := func() {
+		mu.Lock()
+		running++
+		if running > max {
+			max = running
+		}
+		mu.Unlock()
+		time.Sleep(20 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+	}
+	a := func() { work() }
+	b := func() { work() }
+	c := func() { work() }
+	d := func() { work() }
+	// a dependency waiting for its own dependencies doesn't hold a slot, so
+	// this doesn't deadlock.
+	parent := func() { Deps(c, d) }
+	Deps(a, b, parent)
+	if max != 2 {
+		t.Fatalf("expected at most 2 dependencies to run at once, but %d did", max)
+	}
+}
+
+func TestJobs(t *testing.T) {
+	defer os.Unsetenv(JobsEnv)
+	for env, expected := range map[string]int{"": 0, "4": 4, "-1": 0, "x": 0} {
+		os.Setenv(JobsEnv, env)
+		if actual := Jobs(); actual != expected {
+			t.Errorf("%q: expected %d jobs, but got %d", env, expected, actual)
+		}
+	}
+}
+
+func TestRunTargetJobs(t *testing.T) {
+	os.Setenv(JobsEnv, "1")
+	defer os.Unsetenv(JobsEnv)
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		RunTarget(context.Background(), func(ctx context.Context) {
+			// the command and the dependency take the target's place while
+			// it waits for them.
+			done := StartJob()
+			done()
+			CtxDeps(ctx, func() {
+				done := StartJob()
+				done()
+			})
+			// so does a goroutine's, once the target waits with Yield.
+			Yield(func() {
+				ran := make(chan struct{})
+				go func() {
+					defer close(ran)
+					done := StartJob()
+					done()
+				}()
+				<-ran
+			})
+		})
+	}()
+	select {
+	case <-finished:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the target to finish with -j 1, but it deadlocked")
+	}
+	if n := len(jobSlots()); n != 0 {
+		t.Fatalf("expected every slot to be freed, but %d are taken", n)
+	}
+}
+
+func TestHoldingJob(t *testing.T) {
+	if holdingJob() {
+		t.Fatal("expected the test not to hold a slot")
+	}
+	held, fromGoroutine := false, true
+	holdJob(func() {
+		held = holdingJob()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			fromGoroutine = holdingJob()
+		}()
+		<-done
+	})
+	if !held {
+		t.Error("expected the func holdJob runs to hold a slot")
+	}
+	if fromGoroutine {
+		t.Error("expected a goroutine it starts not to hold one")
+	}
+}
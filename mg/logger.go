@@ -0,0 +1,44 @@
+package mg
+
+import (
+	"sync"
+	"time"
+)
+
+// Logger receives the messages mage logs while running targets.  Register one
+// with SetLogger, e.g. in an init function in your magefile, to emit JSON logs
+// or send mage's messages to your own logging stack.
+type Logger interface {
+	// TargetStart is called when a target or dependency starts running.
+	TargetStart(name string)
+	// TargetEnd is called when a target or dependency finishes, with the
+	// error it returned, if any, and how long it ran.
+	TargetEnd(name string, err error, duration time.Duration)
+	// Verbose is called with messages that are only shown in verbose mode,
+	// e.g. "Running target: Build".
+	Verbose(msg string)
+	// Error is called with the error that causes mage to exit unsuccessfully.
+	Error(err error)
+}
+
+var (
+	loggerMu     sync.Mutex
+	customLogger Logger
+)
+
+// SetLogger sets the logger mage uses for the messages it logs while running
+// targets, in place of its default output.  Passing nil restores the default.
+func SetLogger(l Logger) {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	customLogger = l
+}
+
+// CurrentLogger returns the logger set with SetLogger, or nil if mage is using
+// its default output.  The mainfile mage generates calls it to find the
+// logger.
+func CurrentLogger() Logger {
+	loggerMu.Lock()
+	defer loggerMu.Unlock()
+	return customLogger
+}
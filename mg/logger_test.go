@@ -0,0 +1,29 @@
+package mg
+
+import (
+	"flag"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testLogger struct{}
+
+func (testLogger) TargetStart(string)                     {}
+func (testLogger) TargetEnd(string, error, time.Duration) {}
+func (testLogger) Verbose(string)                         {}
+func (testLogger) Error(error)                            {}
+
+func TestSetLogger(t *testing.T) {
+	SetLogger(testLogger{})
+	defer SetLogger(nil)
+	if CurrentLogger() != (testLogger{}) {
+		t.Fatalf("expected the logger that was set, but got %v", CurrentLogger())
+	}
+	// programs that import mg shouldn't get flags they didn't ask for.
+	flag.VisitAll(func(f *flag.Flag) {
+		if strings.HasPrefix(f.Name, "mage.") {
+			t.Errorf("unexpected flag -%s", f.Name)
+		}
+	})
+}
@@ -19,10 +19,134 @@ const VerboseEnv = "MAGEFILE_VERBOSE"
 // debug mode when running mage.
 const DebugEnv = "MAGEFILE_DEBUG"
 
+// ParallelEnv is the environment variable that indicates the user requested
+// the targets given on the command line be run concurrently.
+const ParallelEnv = "MAGEFILE_PARALLEL"
+
+// JobsEnv is the environment variable that sets how many targets,
+// dependencies and commands from the sh package may run at once, like -j.
+const JobsEnv = "MAGEFILE_JOBS"
+
+// TargetTimeoutEnv is the environment variable that indicates the user
+// requested a timeout for each target run, as opposed to the whole run.
+const TargetTimeoutEnv = "MAGEFILE_TARGET_TIMEOUT"
+
+// DepsEnv is the environment variable that indicates the user requested the
+// dependency tree of a target instead of running it.
+const DepsEnv = "MAGEFILE_DEPS"
+
+// GraphEnv is the environment variable that indicates the user requested the
+// dependency graph of a target, or of all targets, instead of running them.
+const GraphEnv = "MAGEFILE_GRAPH"
+
+// GraphFormatEnv is the environment variable that sets the format of the
+// graph requested with GraphEnv: dot, mermaid or tree.
+const GraphFormatEnv = "MAGEFILE_GRAPH_FORMAT"
+
+// DryRunEnv is the environment variable that indicates the user requested to
+// see the targets and commands that would run, without running them.
+const DryRunEnv = "MAGEFILE_DRYRUN"
+
+// CPUProfileEnv, MemProfileEnv and TraceEnv are the environment variables
+// that indicate the user requested a cpu profile, memory profile or execution
+// trace of a magefile run be written to the given file.
+const (
+	CPUProfileEnv = "MAGEFILE_CPUPROFILE"
+	MemProfileEnv = "MAGEFILE_MEMPROFILE"
+	TraceEnv      = "MAGEFILE_TRACE"
+)
+
+// ReportEnv is the environment variable that indicates the user requested a
+// report of the targets that ran, as format=file, e.g. junit=report.xml.
+const ReportEnv = "MAGEFILE_REPORT"
+
+// EventsEnv is the environment variable that indicates the user requested
+// events for the run be written to the given file, as lines of JSON.
+const EventsEnv = "MAGEFILE_EVENTS"
+
+// TimestampsEnv is the environment variable that indicates the user requested
+// each line of output be prefixed with a timestamp and the running targets.
+const TimestampsEnv = "MAGEFILE_TIMESTAMPS"
+
+// StatusEnv is the environment variable that indicates whether the user
+// requested the status of each target be printed as it starts and finishes.
+// When it isn't set, the status is printed if stderr is a terminal.
+const StatusEnv = "MAGEFILE_STATUS"
+
+// SummaryEnv is the environment variable that indicates the user requested a
+// summary of the targets run, with the status and duration of each, be printed
+// when they're done.
+const SummaryEnv = "MAGEFILE_SUMMARY"
+
+// OnlyTagsEnv is the environment variable that lists the tags, separated by
+// commas, that targets must have one of to be run, like -only-tags.
+const OnlyTagsEnv = "MAGEFILE_ONLY_TAGS"
+
+// SkipTagsEnv is the environment variable that lists the tags, separated by
+// commas, of the targets not to run, like -skip-tags.
+const SkipTagsEnv = "MAGEFILE_SKIP_TAGS"
+
+// YesEnv is the environment variable that indicates the user requested the
+// targets matched by a pattern, like docker:*, be run without asking first.
+const YesEnv = "MAGEFILE_YES"
+
+// GitHubActionsEnv is the environment variable that indicates whether to
+// group output and annotate failures for GitHub Actions.  When it isn't set,
+// this is done whenever GITHUB_ACTIONS is "true".
+const GitHubActionsEnv = "MAGEFILE_GITHUB_ACTIONS"
+
+// LockEnv is the environment variable that tells mage to lock the checkout
+// while the targets run, like -lock: "wait" to wait for another run's lock,
+// or "fail" to fail saying who has it.
+const LockEnv = "MAGEFILE_LOCK"
+
+// SSHEnv is the environment variable that sets the command mage runs ssh with
+// for -host, e.g. "ssh -p 2222".  The default is "ssh".
+const SSHEnv = "MAGEFILE_SSH"
+
+// ContainerCmdEnv is the environment variable that sets the command mage runs
+// containers with for -in-container, e.g. "podman".  The default is "docker".
+const ContainerCmdEnv = "MAGEFILE_CONTAINER_CMD"
+
+// NoDotenvEnv is the environment variable that indicates the user requested
+// mage not load variables from .env and .env.local in the magefile directory.
+const NoDotenvEnv = "MAGEFILE_NODOTENV"
+
+// ExitCodesEnv is the environment variable that sets the codes the compiled
+// magefile exits with for each kind of failure, as name=code pairs separated
+// by commas, e.g. "unknownTarget=64,timeout=124".
+const ExitCodesEnv = "MAGEFILE_EXIT_CODES"
+
+// TelemetryEnv is the environment variable that opts in to sending a summary
+// of each run, with the targets run, how long they took and whether they
+// succeeded, to an http(s) URL, which it's posted to as JSON, or to a file,
+// which it's appended to as a line of JSON.
+const TelemetryEnv = "MAGEFILE_TELEMETRY"
+
 // GoCmdEnv is the environment variable that indicates the go binary the user
 // desires to utilize for Magefile compilation.
 const GoCmdEnv = "MAGEFILE_GOCMD"
 
+// PluginsEnv is the environment variable that lists the plugins to load
+// targets from, like -plugin, as glob patterns separated by the OS's path
+// list separator, e.g. "build-plugins/*:/opt/mage/plugins/*".
+const PluginsEnv = "MAGEFILE_PLUGINS"
+
+// ToolsDirEnv is the environment variable that sets the directory the tools
+// package installs tools in, instead of .tools in the working directory.
+const ToolsDirEnv = "MAGEFILE_TOOLS_DIR"
+
+// GoFlagsEnv is the environment variable that sets flags for the go commands
+// mage runs to compile the magefiles, e.g. "-mod=vendor", which are added
+// after the ones in GOFLAGS, so they take precedence.
+const GoFlagsEnv = "MAGEFILE_GOFLAGS"
+
+// GoWorkEnv is the environment variable that sets the go.work file mage
+// compiles the magefiles with, relative to the magefile directory, or "off"
+// to compile them without a workspace.  By default, the go command finds the
+// workspace the magefiles are in, like it does when building the project.
+const GoWorkEnv = "MAGEFILE_GOWORK"
+
 // IgnoreDefaultEnv is the environment variable that indicates the user requested
 // to ignore the default target specified in the magefile.
 const IgnoreDefaultEnv = "MAGEFILE_IGNOREDEFAULT"
@@ -76,6 +200,16 @@ func Debug() bool {
 	return b
 }
 
+// Jobs returns how many targets, dependencies and commands from the sh package
+// may run at once, as set with -j.  It returns 0 if there's no limit.
+func Jobs() int {
+	n, _ := strconv.Atoi(os.Getenv(JobsEnv))
+	if n < 0 {
+		return 0
+	}
+	return n
+}
+
 // GoCmd reports the command that Mage will use to build go code.  By default mage runs
 // the "go" binary in the PATH.
 func GoCmd() string {
@@ -85,13 +219,20 @@ func GoCmd() string {
 	return "go"
 }
 
-// HashFast reports whether the user has requested to use the fast hashing
-// mechanism rather than rely on go's rebuilding mechanism.
+// HashFast reports whether the user has requested to only hash the magefiles,
+// not their dependencies, to decide whether to rebuild the binary.
 func HashFast() bool {
 	b, _ := strconv.ParseBool(os.Getenv(HashFastEnv))
 	return b
 }
 
+// NoDotenv reports whether the user has requested mage not load variables from
+// .env files.
+func NoDotenv() bool {
+	b, _ := strconv.ParseBool(os.Getenv(NoDotenvEnv))
+	return b
+}
+
 // IgnoreDefault reports whether the user has requested to ignore the default target
 // in the magefile.
 func IgnoreDefault() bool {
@@ -115,6 +256,15 @@ func CacheDir() string {
 	}
 }
 
+// ToolsDir returns the directory the tools package installs tools in, from
+// MAGEFILE_TOOLS_DIR, or .tools in the working directory by default.
+func ToolsDir() string {
+	if d := os.Getenv(ToolsDirEnv); d != "" {
+		return d
+	}
+	return ".tools"
+}
+
 // EnableColor reports whether the user has requested to enable a color output.
 func EnableColor() bool {
 	b, _ := strconv.ParseBool(os.Getenv(EnableColorEnv))
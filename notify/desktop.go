@@ -0,0 +1,74 @@
+package notify
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+type desktop struct{}
+
+// Desktop shows a desktop notification: with osascript on macOS, notify-send
+// on Linux and the BSDs, and PowerShell on Windows.  Nothing is shown in CI,
+// i.e. when CI=true.
+func Desktop() Notifier {
+	return desktop{}
+}
+
+func (desktop) Notify(m Message) error {
+	if os.Getenv("CI") == "true" {
+		return nil
+	}
+	var cmd []string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = []string{"osascript", "-e", fmt.Sprintf("display notification %s with title %s", appleScript(m.Text()), appleScript(m.Title))}
+	case "linux", "freebsd", "openbsd", "netbsd", "dragonfly":
+		urgency := "normal"
+		if m.Err != nil {
+			urgency = "critical"
+		}
+		cmd = []string{"notify-send", "--app-name=mage", "--urgency=" + urgency, m.Title, m.Text()}
+	case "windows":
+		cmd = []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", balloon(m.Title, m.Text())}
+	default:
+		return fmt.Errorf("desktop notifications aren't supported on %s", runtime.GOOS)
+	}
+	// the command is run with os/exec rather than sh, which would expand
+	// anything like $VAR in the message.
+	c := exec.Command(cmd[0], cmd[1:]...)
+	stderr := &bytes.Buffer{}
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s failed: %v: %s", cmd[0], err, msg)
+		}
+		return fmt.Errorf("%s failed: %v", cmd[0], err)
+	}
+	return nil
+}
+
+// appleScript quotes s as an AppleScript string.
+func appleScript(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// balloon returns a PowerShell script that shows a notification in the
+// Windows notification area for a few seconds.
+func balloon(title, text string) string {
+	quote := func(s string) string {
+		return "'" + strings.Replace(s, "'", "''", -1) + "'"
+	}
+	return strings.Join([]string{
+		"Add-Type -AssemblyName System.Windows.Forms",
+		"$n = New-Object System.Windows.Forms.NotifyIcon",
+		"$n.Icon = [System.Drawing.SystemIcons]::Information",
+		"$n.Visible = $true",
+		"$n.ShowBalloonTip(10000, " + quote(title) + ", " + quote(text) + ", 'None')",
+		"Start-Sleep -Seconds 10",
+		"$n.Dispose()",
+	}, "; ")
+}
@@ -0,0 +1,66 @@
+package notify
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"notify-send": notifySend})
+	os.Exit(m.Run())
+}
+
+// notifySend stands in for notify-send, writing its args to the file args
+// next to it, one per line.
+func notifySend(args []string) int {
+	b := []byte(strings.Join(args, "\n") + "\n")
+	ioutil.WriteFile(filepath.Join(faketool.Dir(), "args"), b, 0644)
+	return 0
+}
+
+func TestDesktop(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("notify-send is only used on linux")
+	}
+	dir, cleanup := faketool.OnPath(t, "notify-send")
+	defer cleanup()
+	args := filepath.Join(dir, "args")
+	ci, hasCI := os.LookupEnv("CI")
+	os.Unsetenv("CI")
+	if hasCI {
+		defer os.Setenv("CI", ci)
+	}
+
+	m := Message{Title: "mage build", Err: errors.New("costs $HOME"), Duration: 0}
+	if err := Desktop().Notify(m); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile(args)
+	expected := "--app-name=mage\n--urgency=critical\nmage build\nfailed after 0s: costs $HOME\n"
+	if string(b) != expected {
+		t.Fatalf("expected %q, got %q", expected, b)
+	}
+
+	os.Setenv("CI", "true")
+	os.Remove(args)
+	if err := Desktop().Notify(m); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(args); !os.IsNotExist(err) {
+		t.Fatal("expected no notification in CI")
+	}
+	os.Unsetenv("CI")
+}
+
+func TestQuote(t *testing.T) {
+	if s := appleScript(`say "hi" \o/`); s != `"say \"hi\" \\o/"` {
+		t.Fatalf("got %s", s)
+	}
+}
@@ -0,0 +1,148 @@
+// Package notify tells you when a long run finishes, whether it succeeded,
+// and how long it took, by posting to a Slack or Microsoft Teams webhook or
+// showing a desktop notification.  Calling Done from MageTeardown notifies
+// about every run, whichever targets it ran:
+//
+//	func MageTeardown(err error) {
+//		notify.Done(err, time.Minute, notify.Desktop(), notify.Slack(os.Getenv("SLACK_WEBHOOK_URL")))
+//	}
+package notify
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/httpx"
+	"github.com/magefile/mage/sh"
+)
+
+// started is when the run started, near enough: when the magefile's binary
+// started.
+var started = time.Now()
+
+// Message is a notification about a run.
+type Message struct {
+	// Title is what ran, e.g. "mage build test".
+	Title string
+	// Err is the error the run failed with, or nil if it succeeded.
+	Err error
+	// Duration is how long the run took.
+	Duration time.Duration
+}
+
+// Text returns what happened, e.g. "succeeded in 3m12s".
+func (m Message) Text() string {
+	d := m.Duration.Round(time.Second)
+	if m.Err != nil {
+		return fmt.Sprintf("failed after %v: %v", d, m.Err)
+	}
+	return fmt.Sprintf("succeeded in %v", d)
+}
+
+// Notifier sends notifications somewhere.
+type Notifier interface {
+	Notify(m Message) error
+}
+
+// Send sends the message with each notifier, and returns the first error.
+// Every notifier is tried, even if one fails.
+func Send(m Message, notifiers ...Notifier) error {
+	var first error
+	for _, n := range notifiers {
+		if err := n.Notify(m); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// Done notifies that the run finished with err, if it took at least min.  It's
+// meant to be called from MageTeardown.  A notifier that fails is logged,
+// which mage shows with -v, rather than failing the run.
+func Done(err error, min time.Duration, notifiers ...Notifier) {
+	m := Message{Title: title(os.Args[1:]), Err: err, Duration: time.Since(started)}
+	if m.Duration < min {
+		return
+	}
+	if err := Send(m, notifiers...); err != nil {
+		log.Printf("failed to send the notification that %s %s: %v", m.Title, m.Text(), err)
+	}
+}
+
+// title returns "mage" and the targets in the args the magefile's binary was
+// run with.
+func title(args []string) string {
+	words := []string{"mage"}
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") {
+			words = append(words, a)
+		}
+	}
+	return strings.Join(words, " ")
+}
+
+type slack string
+
+// Slack posts to the Slack incoming webhook URL.  The URL is masked with
+// sh.Mask, since anyone who has it can post to the channel.  If it's empty,
+// nothing is posted, so a webhook that's only configured in CI can be used
+// unconditionally.
+func Slack(url string) Notifier {
+	sh.Mask(url)
+	return slack(url)
+}
+
+func (s slack) Notify(m Message) error {
+	if s == "" {
+		return nil
+	}
+	icon := ":white_check_mark:"
+	if m.Err != nil {
+		icon = ":x:"
+	}
+	return post(string(s), map[string]string{
+		"text": fmt.Sprintf("%s *%s* %s", icon, m.Title, m.Text()),
+	})
+}
+
+type teams string
+
+// Teams posts to the Microsoft Teams incoming webhook URL.  Like with Slack,
+// the URL is masked, and nothing is posted if it's empty.
+func Teams(url string) Notifier {
+	sh.Mask(url)
+	return teams(url)
+}
+
+func (t teams) Notify(m Message) error {
+	if t == "" {
+		return nil
+	}
+	color := "2EB886"
+	if m.Err != nil {
+		color = "D00000"
+	}
+	return post(string(t), map[string]string{
+		"@type":      "MessageCard",
+		"@context":   "https://schema.org/extensions",
+		"themeColor": color,
+		"summary":    m.Title + " " + m.Text(),
+		"title":      m.Title,
+		"text":       m.Text(),
+	})
+}
+
+// post posts the body to the webhook.  Its errors have the webhook's URL
+// masked.
+func post(url string, body interface{}) error {
+	// webhooks respond with text like "ok" or "1", not JSON.
+	var resp []byte
+	if err := httpx.Post(url, body, &resp); err != nil {
+		return errors.New(sh.Masked(err.Error()))
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package notify
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestText(t *testing.T) {
+	m := Message{Title: "mage build", Duration: 192*time.Second + 300*time.Millisecond}
+	if s := m.Text(); s != "succeeded in 3m12s" {
+		t.Fatalf("got %q", s)
+	}
+	m.Err = errors.New("oops")
+	if s := m.Text(); s != "failed after 3m12s: oops" {
+		t.Fatalf("got %q", s)
+	}
+	if s := title([]string{"-v", "build", "test:unit"}); s != "mage build test:unit" {
+		t.Fatalf("got %q", s)
+	}
+}
+
+// webhook returns a server that records the JSON posted to it.
+func webhook(got *[]map[string]string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var v map[string]string
+		json.NewDecoder(r.Body).Decode(&v)
+		*got = append(*got, v)
+		w.Write([]byte("ok"))
+	}))
+}
+
+func TestWebhooks(t *testing.T) {
+	var got []map[string]string
+	srv := webhook(&got)
+	defer srv.Close()
+
+	m := Message{Title: "mage deploy", Err: errors.New("no access"), Duration: time.Minute}
+	if err := Send(m, Slack(srv.URL), Teams(srv.URL), Slack(""), Teams("")); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 posts, got %v", got)
+	}
+	if s := got[0]["text"]; s != ":x: *mage deploy* failed after 1m0s: no access" {
+		t.Fatalf("unexpected Slack text %q", s)
+	}
+	if got[1]["@type"] != "MessageCard" || got[1]["title"] != "mage deploy" || got[1]["themeColor"] != "D00000" {
+		t.Fatalf("unexpected Teams card %v", got[1])
+	}
+}
+
+func TestDone(t *testing.T) {
+	var got []map[string]string
+	srv := webhook(&got)
+	defer srv.Close()
+
+	Done(nil, time.Hour, Slack(srv.URL))
+	if len(got) != 0 {
+		t.Fatalf("expected no post for a short run, got %v", got)
+	}
+	Done(nil, 0, Slack(srv.URL))
+	if len(got) != 1 {
+		t.Fatalf("expected a post, got %v", got)
+	}
+
+	// a notifier that fails doesn't stop the others.
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "invalid_token", http.StatusForbidden)
+	}))
+	defer bad.Close()
+	err := Send(Message{}, Slack(bad.URL), Slack(srv.URL))
+	if err == nil || err.Error() != "POST ***: 403 Forbidden: invalid_token" {
+		t.Fatalf("expected a 403 error, got %v", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected another post, got %v", got)
+	}
+}
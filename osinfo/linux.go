@@ -0,0 +1,142 @@
+package osinfo
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// Distro is a Linux distribution, as /etc/os-release describes it.
+type Distro struct {
+	// ID is the distribution's id, e.g. ubuntu, debian, fedora or alpine.
+	ID string
+
+	// Like are the ids of the distributions it's derived from, e.g. debian
+	// for ubuntu.
+	Like []string
+
+	// Version is its version, e.g. 22.04, which may be empty for rolling
+	// releases, like arch.
+	Version string
+
+	// Codename is the codename of the version, e.g. jammy, if it has one.
+	Codename string
+
+	// Name is its name for people, e.g. Ubuntu 22.04.3 LTS.
+	Name string
+}
+
+// Is reports whether the distribution is the one with the id, or derived from
+// it, e.g. whether it's like debian, and so uses apt.
+func (d Distro) Is(id string) bool {
+	if d.ID == id {
+		return true
+	}
+	for _, like := range d.Like {
+		if like == id {
+			return true
+		}
+	}
+	return false
+}
+
+// LinuxDistro returns the Linux distribution that's running.  It's an error if
+// it's not running on Linux.
+func LinuxDistro() (Distro, error) {
+	if runtime.GOOS != "linux" {
+		return Distro{}, fmt.Errorf("there's no Linux distribution on %s", runtime.GOOS)
+	}
+	// /etc/os-release is usually a link to /usr/lib/os-release, which is
+	// read instead if it's not there.
+	b, err := ioutil.ReadFile(root + "etc/os-release")
+	if os.IsNotExist(err) {
+		b, err = ioutil.ReadFile(root + "usr/lib/os-release")
+	}
+	if err != nil {
+		return Distro{}, fmt.Errorf("can't tell the Linux distribution: %v", err)
+	}
+	return parseOSRelease(b), nil
+}
+
+// parseOSRelease parses the shell-like assignments in os-release.
+func parseOSRelease(b []byte) Distro {
+	vars := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		i := strings.Index(line, "=")
+		if i < 0 || strings.HasPrefix(line, "#") {
+			continue
+		}
+		v := line[i+1:]
+		if s, err := strconv.Unquote(v); err == nil {
+			v = s
+		} else if len(v) >= 2 && v[0] == '\'' && v[len(v)-1] == '\'' {
+			v = v[1 : len(v)-1]
+		}
+		vars[line[:i]] = v
+	}
+	d := Distro{
+		ID:       vars["ID"],
+		Like:     strings.Fields(vars["ID_LIKE"]),
+		Version:  vars["VERSION_ID"],
+		Codename: vars["VERSION_CODENAME"],
+		Name:     vars["PRETTY_NAME"],
+	}
+	if d.ID == "" {
+		d.ID = "linux"
+	}
+	if d.Name == "" {
+		d.Name = vars["NAME"]
+	}
+	return d
+}
+
+// Libc is a C library, which on Linux decides which downloads of a binary
+// that's linked to it will run.
+type Libc string
+
+const (
+	// Glibc is the GNU C library, used by most distributions.
+	Glibc Libc = "glibc"
+	// Musl is the musl C library, used by Alpine.
+	Musl Libc = "musl"
+)
+
+// DetectLibc returns the C library the system's programs are linked to, from
+// the dynamic linker that's installed.  It's empty if it's not running on
+// Linux, or there's no dynamic linker, as in a distroless container, where
+// only static binaries run.
+func DetectLibc() Libc {
+	if runtime.GOOS != "linux" {
+		return ""
+	}
+	if matches, _ := filepath.Glob(root + "lib/ld-musl-*.so.1"); len(matches) > 0 {
+		return Musl
+	}
+	for _, pattern := range []string{"lib*/ld-linux*.so.*", "lib/*/ld-linux*.so.*", "usr/lib*/ld-linux*.so.*", "usr/lib/*/ld-linux*.so.*"} {
+		if matches, _ := filepath.Glob(root + pattern); len(matches) > 0 {
+			return Glibc
+		}
+	}
+	return ""
+}
+
+// WSL reports whether it's running in the Windows Subsystem for Linux, where
+// it's Linux, but e.g. browsers and the clipboard are Windows'.
+func WSL() bool {
+	if runtime.GOOS != "linux" {
+		return false
+	}
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		return true
+	}
+	b, err := ioutil.ReadFile(root + "proc/sys/kernel/osrelease")
+	return err == nil && strings.Contains(strings.ToLower(string(b)), "microsoft")
+}
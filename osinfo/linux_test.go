@@ -0,0 +1,67 @@
+package osinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestLinuxDistro(t *testing.T) {
+	cleanup := fakeRoot(t, map[string]string{"usr/lib/os-release": `PRETTY_NAME="Ubuntu 22.04.3 LTS"
+NAME="Ubuntu"
+VERSION_ID="22.04"
+# a comment
+VERSION_CODENAME=jammy
+ID=ubuntu
+ID_LIKE=debian
+`})
+	defer cleanup()
+	d, err := LinuxDistro()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.ID != "ubuntu" || d.Version != "22.04" || d.Codename != "jammy" || d.Name != "Ubuntu 22.04.3 LTS" {
+		t.Fatalf("unexpected %+v", d)
+	}
+	if !d.Is("ubuntu") || !d.Is("debian") || d.Is("fedora") {
+		t.Fatalf("unexpected likeness of %+v", d)
+	}
+
+	if d := parseOSRelease([]byte("NAME='Arch Linux'\n")); d.ID != "linux" || d.Name != "Arch Linux" || d.Version != "" {
+		t.Fatalf("unexpected %+v", d)
+	}
+}
+
+func TestDetectLibc(t *testing.T) {
+	cleanup := fakeRoot(t, map[string]string{"lib/ld-musl-x86_64.so.1": ""})
+	defer cleanup()
+	if libc := DetectLibc(); libc != Musl {
+		t.Fatalf("expected musl, got %q", libc)
+	}
+	os.Remove(root + "lib/ld-musl-x86_64.so.1")
+	if libc := DetectLibc(); libc != "" {
+		t.Fatalf("expected no libc without a dynamic linker, got %q", libc)
+	}
+	os.MkdirAll(root+"lib64", 0755)
+	ioutil.WriteFile(root+"lib64/ld-linux-x86-64.so.2", nil, 0755)
+	if libc := DetectLibc(); libc != Glibc {
+		t.Fatalf("expected glibc, got %q", libc)
+	}
+}
+
+func TestWSL(t *testing.T) {
+	cleanup := fakeRoot(t, map[string]string{"proc/sys/kernel/osrelease": "5.15.133.1-microsoft-standard-WSL2\n"})
+	defer cleanup()
+	wsl, ok := os.LookupEnv("WSL_DISTRO_NAME")
+	os.Unsetenv("WSL_DISTRO_NAME")
+	if ok {
+		defer os.Setenv("WSL_DISTRO_NAME", wsl)
+	}
+	if !WSL() {
+		t.Fatal("expected WSL")
+	}
+	os.Remove(root + "proc/sys/kernel/osrelease")
+	if WSL() {
+		t.Fatal("expected no WSL without a microsoft kernel")
+	}
+}
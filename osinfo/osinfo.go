@@ -0,0 +1,232 @@
+// Package osinfo describes the machine mage is running on.  It names the OS
+// and architecture the ways release downloads do, detects the Linux
+// distribution and C library and whether it's running in WSL, and counts the
+// CPUs and memory that are available, including within a container's limits,
+// so targets can pick downloads and tune how much they run at once without
+// parsing uname.
+package osinfo
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// root is where the files describing the system are read from, so tests can
+// make their own.
+var root = "/"
+
+// Platform is an OS and architecture, named as Go names them, e.g. linux and
+// amd64.
+type Platform struct {
+	OS   string
+	Arch string
+}
+
+// Current returns the platform mage is running on.
+func Current() Platform {
+	return Platform{OS: runtime.GOOS, Arch: runtime.GOARCH}
+}
+
+// ParsePlatform parses a platform written as OS/arch, e.g. linux/amd64.
+func ParsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("invalid platform %q, expected OS/arch, e.g. linux/amd64", s)
+	}
+	return Platform{OS: parts[0], Arch: parts[1]}, nil
+}
+
+// String returns the platform as OS/arch, e.g. linux/amd64.
+func (p Platform) String() string {
+	return p.OS + "/" + p.Arch
+}
+
+// UnameOS returns the OS as uname -s names it, which is how many releases
+// name their downloads, e.g. Linux, Darwin and Windows.
+func (p Platform) UnameOS() string {
+	switch p.OS {
+	case "freebsd":
+		return "FreeBSD"
+	case "openbsd":
+		return "OpenBSD"
+	case "netbsd":
+		return "NetBSD"
+	case "dragonfly":
+		return "DragonFly"
+	case "illumos":
+		return "illumos"
+	case "aix":
+		return "AIX"
+	case "":
+		return ""
+	}
+	return strings.ToUpper(p.OS[:1]) + p.OS[1:]
+}
+
+// UnameArch returns the architecture as uname -m names it, which is how many
+// releases name their downloads, e.g. x86_64, and aarch64, except on macOS,
+// where it's arm64.  32 bit x86 is i386 and 32 bit arm is armv7.
+func (p Platform) UnameArch() string {
+	switch p.Arch {
+	case "amd64":
+		return "x86_64"
+	case "arm64":
+		if p.OS == "darwin" || p.OS == "ios" {
+			return "arm64"
+		}
+		return "aarch64"
+	case "386":
+		return "i386"
+	case "arm":
+		return "armv7"
+	}
+	return p.Arch
+}
+
+// Triple returns the target triple of the platform, as Rust names it, which
+// is how releases of tools written in Rust name their downloads, e.g.
+// x86_64-unknown-linux-gnu, or x86_64-unknown-linux-musl with the musl C
+// library.  The C library only matters on Linux.
+func (p Platform) Triple(libc Libc) string {
+	arch := p.UnameArch()
+	switch p.Arch {
+	case "arm64":
+		arch = "aarch64"
+	case "386":
+		arch = "i686"
+	}
+	switch p.OS {
+	case "darwin":
+		return arch + "-apple-darwin"
+	case "windows":
+		return arch + "-pc-windows-msvc"
+	case "linux":
+		env := "gnu"
+		if libc == Musl {
+			env = "musl"
+		}
+		if p.Arch == "arm" {
+			return "armv7-unknown-linux-" + env + "eabihf"
+		}
+		return arch + "-unknown-linux-" + env
+	}
+	return arch + "-unknown-" + p.OS
+}
+
+// CPUs returns the number of CPUs that can be used, which on Linux is limited
+// by the CPU quota of the container it's running in, if there is one.  It's
+// always at least one.
+func CPUs() int {
+	n := runtime.NumCPU()
+	if runtime.GOOS != "linux" {
+		return n
+	}
+	if quota := cgroupCPUs(); quota > 0 && quota < n {
+		return quota
+	}
+	return n
+}
+
+// cgroupCPUs returns the number of CPUs the cgroup's quota allows, rounded
+// up, or 0 if there's no quota.
+func cgroupCPUs() int {
+	var quota, period float64
+	if b, err := ioutil.ReadFile(root + "sys/fs/cgroup/cpu.max"); err == nil {
+		// cgroup v2: "max 100000" or "200000 100000".
+		fields := strings.Fields(string(b))
+		if len(fields) != 2 {
+			return 0
+		}
+		quota, _ = strconv.ParseFloat(fields[0], 64)
+		period, _ = strconv.ParseFloat(fields[1], 64)
+	} else {
+		// cgroup v1, where a quota of -1 means there's none.
+		q, err1 := ioutil.ReadFile(root + "sys/fs/cgroup/cpu/cpu.cfs_quota_us")
+		p, err2 := ioutil.ReadFile(root + "sys/fs/cgroup/cpu/cpu.cfs_period_us")
+		if err1 != nil || err2 != nil {
+			return 0
+		}
+		quota, _ = strconv.ParseFloat(strings.TrimSpace(string(q)), 64)
+		period, _ = strconv.ParseFloat(strings.TrimSpace(string(p)), 64)
+	}
+	if quota <= 0 || period <= 0 {
+		return 0
+	}
+	return int(math.Ceil(quota / period))
+}
+
+// Memory returns the total memory in bytes, which on Linux is limited by the
+// memory limit of the container it's running in, if there is one.
+func Memory() (uint64, error) {
+	switch runtime.GOOS {
+	case "linux":
+		total, err := memInfo()
+		if err != nil {
+			return 0, err
+		}
+		if limit := cgroupMemory(); limit > 0 && limit < total {
+			return limit, nil
+		}
+		return total, nil
+	case "darwin":
+		return sysctl("hw.memsize")
+	case "freebsd", "openbsd", "netbsd", "dragonfly":
+		return sysctl("hw.physmem")
+	case "windows":
+		out, err := sh.Output("powershell", "-NoProfile", "-NonInteractive", "-Command", "(Get-CimInstance Win32_ComputerSystem).TotalPhysicalMemory")
+		if err != nil {
+			return 0, fmt.Errorf("can't get the total memory: %v", err)
+		}
+		return strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+	}
+	return 0, fmt.Errorf("can't get the total memory on %s", runtime.GOOS)
+}
+
+// memInfo returns the MemTotal in /proc/meminfo, in bytes.
+func memInfo() (uint64, error) {
+	b, err := ioutil.ReadFile(root + "proc/meminfo")
+	if err != nil {
+		return 0, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			kb, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return 0, fmt.Errorf("invalid MemTotal in /proc/meminfo: %v", err)
+			}
+			return kb * 1024, nil
+		}
+	}
+	return 0, fmt.Errorf("there's no MemTotal in /proc/meminfo")
+}
+
+// cgroupMemory returns the cgroup's memory limit in bytes, or 0 if there's
+// none.  cgroup v1 has no way of saying there's no limit, so it says
+// something huge instead, which Memory ignores as more than there is.
+func cgroupMemory() uint64 {
+	b, err := ioutil.ReadFile(root + "sys/fs/cgroup/memory.max")
+	if err != nil {
+		if b, err = ioutil.ReadFile(root + "sys/fs/cgroup/memory/memory.limit_in_bytes"); err != nil {
+			return 0
+		}
+	}
+	// v2 says "max" when there's no limit, which doesn't parse.
+	n, _ := strconv.ParseUint(strings.TrimSpace(string(b)), 10, 64)
+	return n
+}
+
+// sysctl returns the number a sysctl is set to.
+func sysctl(name string) (uint64, error) {
+	out, err := sh.Output("sysctl", "-n", name)
+	if err != nil {
+		return 0, fmt.Errorf("can't get the total memory: %v", err)
+	}
+	return strconv.ParseUint(strings.TrimSpace(out), 10, 64)
+}
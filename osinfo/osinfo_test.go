@@ -0,0 +1,96 @@
+package osinfo
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// fakeRoot makes root a temp dir with the files in it, and returns a func
+// that puts it back.  The files are only read on Linux, so it skips the test
+// anywhere else.
+func fakeRoot(t *testing.T, files map[string]string) func() {
+	if runtime.GOOS != "linux" {
+		t.Skip("the system's files are only read on Linux")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := root
+	root = dir + "/"
+	return func() {
+		root = old
+		os.RemoveAll(dir)
+	}
+}
+
+func TestPlatform(t *testing.T) {
+	tests := []struct {
+		platform, unameOS, unameArch, triple string
+	}{
+		{"linux/amd64", "Linux", "x86_64", "x86_64-unknown-linux-gnu"},
+		{"linux/arm64", "Linux", "aarch64", "aarch64-unknown-linux-gnu"},
+		{"linux/arm", "Linux", "armv7", "armv7-unknown-linux-gnueabihf"},
+		{"darwin/arm64", "Darwin", "arm64", "aarch64-apple-darwin"},
+		{"windows/386", "Windows", "i386", "i686-pc-windows-msvc"},
+		{"freebsd/amd64", "FreeBSD", "x86_64", "x86_64-unknown-freebsd"},
+	}
+	for _, tt := range tests {
+		p, err := ParsePlatform(tt.platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.String() != tt.platform || p.UnameOS() != tt.unameOS || p.UnameArch() != tt.unameArch || p.Triple(Glibc) != tt.triple {
+			t.Errorf("%s: unexpected %s %s %s", tt.platform, p.UnameOS(), p.UnameArch(), p.Triple(Glibc))
+		}
+	}
+	if s := (Platform{OS: "linux", Arch: "amd64"}).Triple(Musl); s != "x86_64-unknown-linux-musl" {
+		t.Errorf("unexpected %s", s)
+	}
+	if _, err := ParsePlatform("linux"); err == nil {
+		t.Error("expected an error for a platform without an arch")
+	}
+}
+
+func TestCPUs(t *testing.T) {
+	cleanup := fakeRoot(t, map[string]string{"sys/fs/cgroup/cpu.max": "150000 100000\n"})
+	defer cleanup()
+	expected := 2
+	if runtime.NumCPU() < 2 {
+		expected = runtime.NumCPU()
+	}
+	if n := CPUs(); n != expected {
+		t.Fatalf("expected %d CPUs with a quota of 1.5, got %d", expected, n)
+	}
+
+	ioutil.WriteFile(root+"sys/fs/cgroup/cpu.max", []byte("max 100000\n"), 0644)
+	if n := CPUs(); n != runtime.NumCPU() {
+		t.Fatalf("expected every CPU without a quota, got %d", n)
+	}
+}
+
+func TestMemory(t *testing.T) {
+	cleanup := fakeRoot(t, map[string]string{
+		"proc/meminfo": "MemTotal:       16315560 kB\nMemFree:         1024 kB\n",
+		"sys/fs/cgroup/memory/memory.limit_in_bytes": "9223372036854771712\n",
+	})
+	defer cleanup()
+	if n, err := Memory(); err != nil || n != 16315560*1024 {
+		t.Fatalf("expected MemTotal without a limit, got %d, %v", n, err)
+	}
+
+	ioutil.WriteFile(root+"sys/fs/cgroup/memory.max", []byte("536870912\n"), 0644)
+	if n, err := Memory(); err != nil || n != 512<<20 {
+		t.Fatalf("expected the limit, got %d, %v", n, err)
+	}
+}
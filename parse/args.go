@@ -0,0 +1,217 @@
+package parse
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// argConverters maps the go types that targets may take as parameters to the
+// code that converts a command line value into that type.  Values are
+// validated by the mainfile before any target is run, so conversion errors
+// can safely be ignored here.  Named string types are also supported, see
+// convertCode.
+var argConverters = map[string]string{
+	"string":        "%s = argValue(call, %q)",
+	"int":           "%s, _ = strconv.Atoi(argValue(call, %q))",
+	"bool":          "%s, _ = strconv.ParseBool(argValue(call, %q))",
+	"float64":       "%s, _ = strconv.ParseFloat(argValue(call, %q), 64)",
+	"time.Duration": "%s, _ = time.ParseDuration(argValue(call, %q))",
+	"[]string":      "%s = argList(call, %q)",
+	"...string":     "%s = call.values[%q]",
+}
+
+// convertCode returns code for the template that sets lhs to the value given
+// for the arg on the command line.  pkg is the package the target is defined
+// in, which qualifies named string types.
+func (a Arg) convertCode(lhs, pkg string) string {
+	if conv, ok := argConverters[a.Type]; ok {
+		return fmt.Sprintf(conv, lhs, a.Name)
+	}
+	return fmt.Sprintf("%s = %s(argValue(call, %q))", lhs, a.goType(pkg), a.Name)
+}
+
+// goType returns the type of the arg as it should be written in the mainfile.
+func (a Arg) goType(pkg string) string {
+	if a.IsVariadic() {
+		return "[]string"
+	}
+	if _, ok := argConverters[a.Type]; ok || pkg == "" {
+		return a.Type
+	}
+	return pkg + "." + a.Type
+}
+
+// IsVariadic reports whether the arg is a final ...string parameter, which
+// takes the rest of the command line.
+func (a Arg) IsVariadic() bool {
+	return a.Type == "...string"
+}
+
+// Usage returns how the arg is written on the command line, for target help.
+func (a Arg) Usage() string {
+	switch {
+	case a.IsVariadic():
+		return "[<" + a.Name + ">...]"
+	case a.Type == "bool":
+		return "[--" + a.Name + "]"
+	case len(a.Enum) > 0:
+		return "[--" + a.Name + "=<" + strings.Join(a.Enum, "|") + ">]"
+	default:
+		return "[--" + a.Name + "=<" + a.Type + ">]"
+	}
+}
+
+// setArgs sets the args of fn from the given parameter list, which must not
+// include a leading context parameter.  A target may either take any number
+// of parameters of the supported types, or a single options struct whose
+// exported fields are of the supported types.
+func setArgs(pi *PkgInfo, fn *Function, params []*ast.Field) error {
+	for _, p := range params {
+		if len(p.Names) == 0 {
+			return fmt.Errorf("parameters of type %s must be named to be used as flags", types.ExprString(p.Type))
+		}
+		if id, ok := p.Type.(*ast.Ident); ok {
+			if st := findStruct(pi, id.Name); st != nil {
+				if len(params) != 1 || len(p.Names) != 1 {
+					return fmt.Errorf("an options struct of type %s must be the only parameter", id.Name)
+				}
+				fn.ArgStruct = id.Name
+				return setStructArgs(pi, fn, st)
+			}
+		}
+		for _, n := range p.Names {
+			a, err := newArg(pi, n.Name, p.Type)
+			if err != nil {
+				return err
+			}
+			fn.Args = append(fn.Args, a)
+		}
+	}
+	return nil
+}
+
+// setStructArgs adds an arg to fn for each exported field of an options
+// struct.
+func setStructArgs(pi *PkgInfo, fn *Function, st *ast.StructType) error {
+	for _, f := range st.Fields.List {
+		for _, n := range f.Names {
+			if !ast.IsExported(n.Name) {
+				continue
+			}
+			a, err := newArg(pi, n.Name, f.Type)
+			if err != nil {
+				return fmt.Errorf("field %s of options struct %s: %v", n.Name, fn.ArgStruct, err)
+			}
+			doc := f.Doc
+			if doc == nil {
+				doc = f.Comment
+			}
+			a.Field = n.Name
+			a.Doc = toOneLine(doc.Text())
+			fn.Args = append(fn.Args, a)
+		}
+	}
+	return nil
+}
+
+// newArg returns the arg for a parameter or field with the given name and
+// type, or an error if the type is not supported.
+func newArg(pi *PkgInfo, name string, typ ast.Expr) (Arg, error) {
+	a := Arg{Name: flagName(name), Type: types.ExprString(typ)}
+	if _, ok := argConverters[a.Type]; ok {
+		return a, nil
+	}
+	if id, ok := typ.(*ast.Ident); ok {
+		if enum, ok := findStringType(pi, id.Name); ok {
+			a.Enum = enum
+			return a, nil
+		}
+	}
+	return a, fmt.Errorf("unsupported parameter type %s", a.Type)
+}
+
+// findStruct returns the struct type with the given name declared in the
+// package, or nil if there is no such struct.
+func findStruct(pi *PkgInfo, name string) *ast.StructType {
+	if ts := findType(pi, name); ts != nil {
+		st, _ := ts.Type.(*ast.StructType)
+		return st
+	}
+	return nil
+}
+
+// findStringType reports whether the package declares a named type with the
+// given name whose underlying type is string.  If so, it also returns the
+// values of the string constants of that type, which are the only values
+// allowed for args of the type.
+func findStringType(pi *PkgInfo, name string) (enum []string, ok bool) {
+	ts := findType(pi, name)
+	if ts == nil {
+		return nil, false
+	}
+	if id, ok := ts.Type.(*ast.Ident); !ok || id.Name != "string" {
+		return nil, false
+	}
+	for _, t := range pi.DocPkg.Types {
+		if t.Name != name {
+			continue
+		}
+		for _, c := range t.Consts {
+			for _, spec := range c.Decl.Specs {
+				vs, ok := spec.(*ast.ValueSpec)
+				if !ok {
+					continue
+				}
+				for _, v := range vs.Values {
+					lit, ok := v.(*ast.BasicLit)
+					if !ok || lit.Kind != token.STRING {
+						continue
+					}
+					if s, err := strconv.Unquote(lit.Value); err == nil {
+						enum = append(enum, s)
+					}
+				}
+			}
+		}
+	}
+	return enum, true
+}
+
+// findType returns the spec of the exported type with the given name declared
+// in the package, or nil if there is no such type.
+func findType(pi *PkgInfo, name string) *ast.TypeSpec {
+	for _, t := range pi.DocPkg.Types {
+		if t.Name != name {
+			continue
+		}
+		for _, spec := range t.Decl.Specs {
+			if ts, ok := spec.(*ast.TypeSpec); ok && ts.Name.Name == name {
+				return ts
+			}
+		}
+	}
+	return nil
+}
+
+// flagName converts a go identifier into the name of a command line flag,
+// e.g. buildTags -> build-tags and BaseURL -> base-url.
+func flagName(s string) string {
+	runes := []rune(s)
+	var out []rune
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextLower) {
+				out = append(out, '-')
+			}
+		}
+		out = append(out, unicode.ToLower(r))
+	}
+	return strings.TrimLeft(string(out), "-")
+}
@@ -0,0 +1,168 @@
+package parse
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"strconv"
+	"strings"
+)
+
+const (
+	mgPath = "github.com/magefile/mage/mg"
+	shPath = "github.com/magefile/mage/sh"
+)
+
+// Dep is a function passed to one of the mg.Deps functions in the body of a
+// target.
+type Dep struct {
+	Name   string    // the function as written in the call to mg.Deps
+	Target *Function // the target the function refers to, if it is one
+}
+
+// depFuncs are the functions in mg that run dependencies, mapped to the index
+// of their first dependency argument.
+var depFuncs = map[string]int{
+	"Deps":          0,
+	"SerialDeps":    0,
+	"CtxDeps":       1,
+	"SerialCtxDeps": 1,
+}
+
+// cmdFuncs are the functions in sh that run commands, mapped to the index of
+// their command argument.
+var cmdFuncs = map[string]int{
+	"Run":        0,
+	"RunV":       0,
+	"Output":     0,
+	"RunWith":    1,
+	"RunWithV":   1,
+	"OutputWith": 1,
+	"Exec":       3,
+}
+
+// funcCalls are the calls of interest in the body of a function.
+type funcCalls struct {
+	deps []ast.Expr // the args to mg.Deps and friends
+	cmds []string   // the commands run with sh
+}
+
+// findCalls returns the calls to mg.Deps and friends, and the commands run
+// with sh, in the bodies of the package's functions, keyed by the function's
+// receiver and name.  It must be called before the package is passed to
+// go/doc, which drops function bodies.  Only direct calls are found, so calls
+// made by helper functions aren't included.
+func findCalls(pkg *ast.Package) map[string]*funcCalls {
+	calls := map[string]*funcCalls{}
+	for _, file := range pkg.Files {
+		mg := importName(file, mgPath)
+		sh := importName(file, shPath)
+		if mg == "" && sh == "" {
+			continue
+		}
+		for _, d := range file.Decls {
+			decl, ok := d.(*ast.FuncDecl)
+			if !ok || decl.Body == nil {
+				continue
+			}
+			fc := &funcCalls{}
+			ast.Inspect(decl.Body, func(n ast.Node) bool {
+				call, ok := n.(*ast.CallExpr)
+				if !ok {
+					return true
+				}
+				sel, ok := call.Fun.(*ast.SelectorExpr)
+				if !ok {
+					return true
+				}
+				id, ok := sel.X.(*ast.Ident)
+				if !ok {
+					return true
+				}
+				switch id.Name {
+				case mg:
+					if first, ok := depFuncs[sel.Sel.Name]; ok && len(call.Args) >= first {
+						fc.deps = append(fc.deps, call.Args[first:]...)
+					}
+				case sh:
+					if first, ok := cmdFuncs[sel.Sel.Name]; ok && len(call.Args) > first {
+						fc.cmds = append(fc.cmds, cmdString(call.Args[first:]))
+					}
+				}
+				return true
+			})
+			if len(fc.deps) > 0 || len(fc.cmds) > 0 {
+				calls[funcKey(decl)] = fc
+			}
+		}
+	}
+	return calls
+}
+
+// cmdString returns the command line for the args of a call to sh.  Args
+// that aren't string literals are shown as the go expression in angle
+// brackets, e.g. go build -o <out>.
+func cmdString(args []ast.Expr) string {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		if lit, ok := arg.(*ast.BasicLit); ok && lit.Kind == token.STRING {
+			if s, err := strconv.Unquote(lit.Value); err == nil {
+				parts = append(parts, s)
+				continue
+			}
+		}
+		parts = append(parts, "<"+types.ExprString(arg)+">")
+	}
+	return strings.Join(parts, " ")
+}
+
+// setDeps sets the dependencies and commands of the package's targets from
+// the calls found by findCalls.  This must be called after imports are set, so
+// dependencies on imported targets can be resolved.
+func setDeps(pi *PkgInfo) {
+	for _, f := range pi.Funcs {
+		fc := pi.calls[f.Receiver+"."+f.Name]
+		if fc == nil {
+			continue
+		}
+		f.Commands = fc.cmds
+		for _, arg := range fc.deps {
+			dep := Dep{Name: types.ExprString(arg)}
+			if target, err := getFunction(arg, pi); err == nil {
+				dep.Target = target
+			}
+			f.Deps = append(f.Deps, dep)
+		}
+	}
+}
+
+// importName returns the name the file imports the package with the given
+// path as, or an empty string if it doesn't import the package.
+func importName(file *ast.File, path string) string {
+	for _, imp := range file.Imports {
+		if strings.Trim(imp.Path.Value, `"`) != path {
+			continue
+		}
+		if imp.Name != nil {
+			return imp.Name.Name
+		}
+		return path[strings.LastIndex(path, "/")+1:]
+	}
+	return ""
+}
+
+// funcKey returns the receiver type and name of the function, separated by a
+// dot, e.g. "Build.Docker" or ".Install" for a function without a receiver.
+func funcKey(decl *ast.FuncDecl) string {
+	receiver := ""
+	if decl.Recv != nil && len(decl.Recv.List) == 1 {
+		typ := decl.Recv.List[0].Type
+		if star, ok := typ.(*ast.StarExpr); ok {
+			typ = star.X
+		}
+		if id, ok := typ.(*ast.Ident); ok {
+			receiver = id.Name
+		}
+	}
+	return receiver + "." + decl.Name.Name
+}
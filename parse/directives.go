@@ -0,0 +1,71 @@
+package parse
+
+import (
+	"go/ast"
+	"strconv"
+	"strings"
+)
+
+// directivePrefix starts a comment on a target that tells mage how to treat
+// it, e.g. //mage:deprecated "use build:all".  Like //go: directives, there's
+// no space after the slashes, so go/doc leaves them out of the target's docs.
+const directivePrefix = "//mage:"
+
+// directive is a //mage:name value comment on a target.
+type directive struct {
+	name  string
+	value string // unquoted, if it was quoted
+}
+
+// findDirectives returns the mage directives in the doc comments of the
+// functions in the package, keyed by funcKey.  It must be called before
+// doc.New, which removes doc comments from the AST.
+func findDirectives(pkg *ast.Package) map[string][]directive {
+	found := map[string][]directive{}
+	for _, file := range pkg.Files {
+		for _, d := range file.Decls {
+			decl, ok := d.(*ast.FuncDecl)
+			if !ok || decl.Doc == nil {
+				continue
+			}
+			for _, c := range decl.Doc.List {
+				if !strings.HasPrefix(c.Text, directivePrefix) {
+					continue
+				}
+				text := strings.TrimPrefix(c.Text, directivePrefix)
+				dir := directive{name: text}
+				if i := strings.IndexAny(text, " \t"); i >= 0 {
+					dir.name = text[:i]
+					dir.value = strings.TrimSpace(text[i:])
+					if s, err := strconv.Unquote(dir.value); err == nil {
+						dir.value = s
+					}
+				}
+				key := funcKey(decl)
+				found[key] = append(found[key], dir)
+			}
+		}
+	}
+	return found
+}
+
+// setDirectives applies the mage directives in the doc comment of a target.
+func setDirectives(pi *PkgInfo, fn *Function) {
+	for _, d := range pi.directives[fn.Receiver+"."+fn.Name] {
+		switch d.name {
+		case "deprecated":
+			fn.IsDeprecated = true
+			fn.Deprecation = d.value
+		case "hidden":
+			fn.IsHidden = true
+		case "alias":
+			// //mage:alias b bld
+			fn.Aliases = append(fn.Aliases, strings.Fields(strings.Replace(d.value, ",", " ", -1))...)
+		case "tags":
+			// //mage:tags release slow
+			fn.Tags = append(fn.Tags, strings.Fields(strings.Replace(d.value, ",", " ", -1))...)
+		default:
+			debug.Printf("ignoring unknown directive //mage:%s on %s", d.name, fn.Name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package parse
+
+import (
+	"errors"
+	"fmt"
+	"go/ast"
+)
+
+// The names of the functions in a magefile that are called before the first
+// target runs and after the last one finishes.  They're never targets.
+const (
+	setupFunc    = "MageSetup"
+	teardownFunc = "MageTeardown"
+)
+
+// isHook reports whether a function with the given name is a run hook rather
+// than a target.
+func isHook(name string) bool {
+	return name == setupFunc || name == teardownFunc
+}
+
+// setHooks sets the MageSetup and MageTeardown functions of the package, if
+// it has them.  Only the functions in the main magefile package are used.
+func setHooks(pi *PkgInfo) error {
+	for _, f := range pi.DocPkg.Funcs {
+		if f.Recv != "" || !isHook(f.Name) {
+			continue
+		}
+		ft := f.Decl.Type
+		fn := &Function{Name: f.Name}
+		switch {
+		case hasVoidReturn(ft):
+		case hasErrorReturn(ft):
+			fn.IsError = true
+		default:
+			return fmt.Errorf("%s may only return nothing or an error", f.Name)
+		}
+		var params []*ast.Field
+		if ft.Params != nil {
+			params = ft.Params.List
+		}
+		if len(params) > 0 && isContextType(params[0].Type) && len(params[0].Names) < 2 {
+			fn.IsContext = true
+			params = params[1:]
+		}
+		if f.Name == setupFunc {
+			if len(params) > 0 {
+				return errors.New("MageSetup may only take a context.Context, e.g. func MageSetup(ctx context.Context) error")
+			}
+			debug.Printf("found setup func %s", f.Name)
+			pi.Setup = fn
+			continue
+		}
+		// the teardown is passed the error that ended the run, if any.
+		if len(params) != 1 || len(params[0].Names) > 1 || fmt.Sprint(params[0].Type) != "error" {
+			return errors.New("MageTeardown must take the error from the run, e.g. func MageTeardown(ctx context.Context, err error)")
+		}
+		debug.Printf("found teardown func %s", f.Name)
+		pi.Teardown = fn
+	}
+	return nil
+}
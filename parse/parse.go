@@ -10,6 +10,8 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -33,8 +35,23 @@ type PkgInfo struct {
 	Description string
 	Funcs       []*Function
 	DefaultFunc *Function
-	Aliases     map[string]*Function
-	Imports     []*Import
+	// Defaults are the targets to run when Default is a list of them.
+	Defaults []*Function
+	// DynamicDefault is true when Default is an mg.DefaultFn, which chooses
+	// the targets to run when the magefile runs.
+	DynamicDefault bool
+	Aliases        map[string]*Function
+	Imports        []*Import
+	Setup          *Function // MageSetup, run before the first target
+	Teardown       *Function // MageTeardown, run after the last target, even if one failed
+	// MgFuncs are the exported funcs of the mg package the magefiles are
+	// compiled with, when they use mage's libraries, so the mainfile only
+	// calls those that version of mg has.
+	MgFuncs map[string]bool
+
+	calls      map[string]*funcCalls  // see findCalls
+	directives map[string][]directive // see findDirectives
+	fset       *token.FileSet
 }
 
 // Function represented a job function from a mage file
@@ -46,8 +63,32 @@ type Function struct {
 	Receiver   string
 	IsError    bool
 	IsContext  bool
+	IsCleanup  bool // the target returns a cleanup func along with its error
 	Synopsis   string
 	Comment    string
+	Args       []Arg
+	ArgStruct  string   // the options struct type, if the target takes one
+	Deps       []Dep    // the functions the target passes to mg.Deps
+	Commands   []string // the commands the target runs with sh, where known
+
+	IsDeprecated bool     // the target has a //mage:deprecated directive
+	Deprecation  string   // what to use instead of a deprecated target, if given
+	IsHidden     bool     // the target has a //mage:hidden directive, so it isn't listed
+	Aliases      []string // the aliases declared with //mage:alias directives
+	Tags         []string // the tags declared with //mage:tags directives, to select targets by
+
+	File string // the absolute path of the file that declares the target
+	Line int    // the line the target is declared on
+}
+
+// Arg is a parameter to a target.  Args are set from the command line either
+// as flags (--name value) or positionally, in the order they are declared.
+type Arg struct {
+	Name  string   // the name of the flag on the command line
+	Type  string   // the go type of the parameter
+	Field string   // the field of the options struct this arg sets, if any
+	Doc   string   // the description of the arg, shown in target help
+	Enum  []string // the allowed values for args of a named string type
 }
 
 // ID returns user-readable information about where this function is defined.
@@ -88,41 +129,84 @@ func (f Function) ExecCode() (string, error) {
 		name = f.Package + "." + name
 	}
 
-	if f.IsContext && f.IsError {
-		out := `
-			wrapFn := func(ctx context.Context) error {
-				return %s(ctx)
+	var decls []string
+	var params []string
+	if f.IsContext {
+		params = append(params, "ctx")
+	}
+	if f.ArgStruct != "" {
+		typ := f.ArgStruct
+		if f.Package != "" {
+			typ = f.Package + "." + typ
+		}
+		decls = append(decls, "var arg0 "+typ)
+		for _, a := range f.Args {
+			decls = append(decls, a.convertCode("arg0."+a.Field, f.Package))
+		}
+		params = append(params, "arg0")
+	} else {
+		for i, a := range f.Args {
+			v := fmt.Sprintf("arg%d", i)
+			decls = append(decls, "var "+v+" "+a.goType(f.Package), a.convertCode(v, f.Package))
+			if a.IsVariadic() {
+				v += "..."
 			}
-			err := runTarget(wrapFn)`[1:]
-		return fmt.Sprintf(out, name), nil
+			params = append(params, v)
+		}
 	}
-	if f.IsContext && !f.IsError {
-		out := `
+	call := fmt.Sprintf("%s(%s)", name, strings.Join(params, ", "))
+
+	switch {
+	case f.IsCleanup:
+		call = "cleanup, err := " + call
+	case f.IsError:
+		call = "return " + call
+	}
+	if f.File != "" {
+		// mage turns these into //line directives when the mainfile is
+		// built for debugging.
+		call = fmt.Sprintf("//mage:line %s:%d\n\t\t\t\t%s\n\t\t\t\t//mage:endline", f.File, f.Line, call)
+	}
+
+	var out string
+	for _, d := range decls {
+		out += "\t\t\t" + d + "\n"
+	}
+	switch {
+	case f.IsCleanup:
+		// the cleanup runs once all the targets have finished.
+		out += `
 			wrapFn := func(ctx context.Context) error {
-				%s(ctx)
-				return nil
+				%s
+				addCleanup(cleanup)
+				return err
 			}
-			err := runTarget(wrapFn)`[1:]
-		return fmt.Sprintf(out, name), nil
-	}
-	if !f.IsContext && f.IsError {
-		out := `
+			err := runTarget(%q, wrapFn)`[1:]
+	case f.IsError:
+		out += `
 			wrapFn := func(ctx context.Context) error {
-				return %s()
+				%s
 			}
-			err := runTarget(wrapFn)`[1:]
-		return fmt.Sprintf(out, name), nil
-	}
-	if !f.IsContext && !f.IsError {
-		out := `
+			err := runTarget(%q, wrapFn)`[1:]
+	default:
+		out += `
 			wrapFn := func(ctx context.Context) error {
-				%s()
+				%s
 				return nil
 			}
-			err := runTarget(wrapFn)`[1:]
-		return fmt.Sprintf(out, name), nil
+			err := runTarget(%q, wrapFn)`[1:]
 	}
-	return "", fmt.Errorf("Error formatting ExecCode code for %#v", f)
+	return fmt.Sprintf(out, call, f.TargetName()), nil
+}
+
+// ArgDefs returns code for the template's table of the target's args, which
+// is used to parse and validate the command line before any target is run.
+func (f Function) ArgDefs() string {
+	defs := make([]string, 0, len(f.Args))
+	for _, a := range f.Args {
+		defs = append(defs, fmt.Sprintf("{%q, %q, %#v}", a.Name, a.Type, a.Enum))
+	}
+	return "{" + strings.Join(defs, ", ") + "}"
 }
 
 // PrimaryPackage parses a package.  If files is non-empty, it will only parse the files given.
@@ -132,12 +216,22 @@ func PrimaryPackage(gocmd, path string, files []string) (*PkgInfo, error) {
 		return nil, err
 	}
 
-	if err := setImports(gocmd, info); err != nil {
+	if err := setImports(gocmd, path, info); err != nil {
 		return nil, err
 	}
 
+	if err := setHooks(info); err != nil {
+		return nil, err
+	}
+	setMgFuncs(gocmd, path, info)
+	setDeps(info)
 	setDefault(info)
-	setAliases(info)
+	if err := setAliases(info); err != nil {
+		return nil, err
+	}
+	if err := checkAliases(info); err != nil {
+		return nil, err
+	}
 	return info, nil
 }
 
@@ -193,11 +287,16 @@ func Package(path string, files []string) (*PkgInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	calls := findCalls(pkg)
+	directives := findDirectives(pkg)
 	p := doc.New(pkg, "./", 0)
 	pi := &PkgInfo{
 		AstPkg:      pkg,
 		DocPkg:      p,
 		Description: toOneLine(p.Doc),
+		calls:       calls,
+		directives:  directives,
+		fset:        fset,
 	}
 
 	setNamespaces(pi)
@@ -217,11 +316,11 @@ func Package(path string, files []string) (*PkgInfo, error) {
 	return pi, nil
 }
 
-func getNamedImports(gocmd string, pkgs map[string]string) ([]*Import, error) {
+func getNamedImports(gocmd, dir string, pkgs map[string]string) ([]*Import, error) {
 	var imports []*Import
 	for alias, pkg := range pkgs {
 		debug.Printf("getting import package %q, alias %q", pkg, alias)
-		imp, err := getImport(gocmd, pkg, alias)
+		imp, err := getImport(gocmd, dir, pkg, alias)
 		if err != nil {
 			return nil, err
 		}
@@ -231,8 +330,10 @@ func getNamedImports(gocmd string, pkgs map[string]string) ([]*Import, error) {
 }
 
 // getImport returns the metadata about a package that has been mage:import'ed.
-func getImport(gocmd, importpath, alias string) (*Import, error) {
-	out, err := internal.OutputDebug(gocmd, "list", "-f", "{{.Dir}}||{{.Name}}", importpath)
+// The package is resolved from dir, so that packages in other modules are
+// found using the magefiles' go.mod.
+func getImport(gocmd, dir, importpath, alias string) (*Import, error) {
+	out, err := internal.OutputDebugDir(dir, gocmd, "list", "-f", "{{.Dir}}||{{.Name}}", importpath)
 	if err != nil {
 		return nil, err
 	}
@@ -240,19 +341,19 @@ func getImport(gocmd, importpath, alias string) (*Import, error) {
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("incorrect data from go list: %s", out)
 	}
-	dir, name := parts[0], parts[1]
-	debug.Printf("parsing imported package %q from dir %q", importpath, dir)
+	pkgDir, name := parts[0], parts[1]
+	debug.Printf("parsing imported package %q from dir %q", importpath, pkgDir)
 
 	// we use go list to get the list of files, since go/parser doesn't differentiate between
 	// go files with build tags etc, and go list does. This prevents weird problems if you
 	// have more than one package in a folder because of build tags.
-	out, err = internal.OutputDebug(gocmd, "list", "-f", `{{join .GoFiles "||"}}`, importpath)
+	out, err = internal.OutputDebugDir(dir, gocmd, "list", "-f", `{{join .GoFiles "||"}}`, importpath)
 	if err != nil {
 		return nil, err
 	}
 	files := strings.Split(out, "||")
 
-	info, err := Package(dir, files)
+	info, err := Package(pkgDir, files)
 	if err != nil {
 		return nil, err
 	}
@@ -261,7 +362,83 @@ func getImport(gocmd, importpath, alias string) (*Import, error) {
 		info.Funcs[i].PkgAlias = alias
 		info.Funcs[i].ImportPath = importpath
 	}
-	return &Import{Alias: alias, Name: name, Path: importpath, Info: *info}, nil
+	setDeps(info)
+	imp := &Import{Alias: alias, Name: name, Path: importpath, Info: *info}
+	imp.Module, imp.Version = getModule(gocmd, dir, importpath)
+	return imp, nil
+}
+
+// setMgFuncs sets info.MgFuncs, if the magefiles, or the packages they
+// mage:import, import any of mage's libraries.  Otherwise mg may not be
+// available to the mainfile, and it has no need of it.
+func setMgFuncs(gocmd, dir string, info *PkgInfo) {
+	pkgs := []*ast.Package{info.AstPkg}
+	for _, imp := range info.Imports {
+		pkgs = append(pkgs, imp.Info.AstPkg)
+	}
+	uses := false
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			for _, spec := range f.Imports {
+				uses = uses || strings.HasPrefix(strings.Trim(spec.Path.Value, `"`), "github.com/magefile/mage/")
+			}
+		}
+	}
+	if !uses {
+		return
+	}
+	// mg is found the same way the compiler will find it, so if it's
+	// vendored without it, or isn't there at all, the mainfile goes without.
+	out, err := internal.OutputDebugDir(dir, gocmd, "list", "-f", `{{.Dir}}||{{join .GoFiles "||"}}`, mgPath)
+	if err != nil {
+		debug.Printf("not using mg from the mainfile: %v", err)
+		return
+	}
+	parts := strings.Split(out, "||")
+	funcs := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, name := range parts[1:] {
+		f, err := parser.ParseFile(fset, filepath.Join(parts[0], name), nil, 0)
+		if err != nil {
+			debug.Printf("not using mg from the mainfile: %v", err)
+			return
+		}
+		for _, decl := range f.Decls {
+			if fn, ok := decl.(*ast.FuncDecl); ok && fn.Recv == nil && ast.IsExported(fn.Name.Name) {
+				funcs[fn.Name.Name] = true
+			}
+		}
+	}
+	info.MgFuncs = funcs
+}
+
+// getModule returns the path and version of the module that provides the
+// given package, if that isn't the magefiles' own module.  Both are empty if
+// the package is in the main module, or go modules aren't in use.
+func getModule(gocmd, dir, importpath string) (path, version string) {
+	// older versions of go don't know about modules and will fail to execute
+	// the template, which is fine, it just means the package isn't in one.
+	out, err := internal.OutputDebugDir(dir, gocmd, "list", "-f", "{{with .Module}}{{if not .Main}}{{.Path}}||{{.Version}}{{end}}{{end}}", importpath)
+	if err != nil || out == "" {
+		return "", ""
+	}
+	parts := strings.Split(out, "||")
+	if len(parts) != 2 {
+		return "", ""
+	}
+	return parts[0], parts[1]
+}
+
+// checkPin returns an error if the module that provides an import doesn't
+// have the version the import is pinned to.
+func checkPin(imp *Import, version string) error {
+	if imp.Module == "" {
+		return fmt.Errorf("%s for %s is pinned to version %s, but the package is not from another go module", importTag, imp.Path, version)
+	}
+	if imp.Version != version {
+		return fmt.Errorf("%s for %s is pinned to version %s, but go.mod requires %s@%s.  Run \"go get %s@%s\" to update it", importTag, imp.Path, version, imp.Module, imp.Version, imp.Module, version)
+	}
+	return nil
 }
 
 // Import represents the data about a mage:import package
@@ -270,9 +447,20 @@ type Import struct {
 	Name       string
 	UniqueName string // a name unique across all imports
 	Path       string
+	Module     string // the module the package is from, if not the magefiles' module
+	Version    string // the version of Module
 	Info       PkgInfo
 }
 
+// Origin returns the package path and version the import was resolved to, if
+// it comes from another module, or an empty string otherwise.
+func (i Import) Origin() string {
+	if i.Module == "" {
+		return ""
+	}
+	return i.Path + "@" + i.Version
+}
+
 func setFuncs(pi *PkgInfo) {
 	for _, f := range pi.DocPkg.Funcs {
 		if f.Recv != "" {
@@ -285,18 +473,17 @@ func setFuncs(pi *PkgInfo) {
 			// skip non-exported functions
 			continue
 		}
-		if typ := funcType(f.Decl.Type); typ != invalidType {
-			debug.Printf("found target %v", f.Name)
-			pi.Funcs = append(pi.Funcs, &Function{
-				Name:      f.Name,
-				Comment:   toOneLine(f.Doc),
-				Synopsis:  sanitizeSynopsis(f),
-				IsError:   typ == errorType || typ == contextErrorType,
-				IsContext: typ == contextVoidType || typ == contextErrorType,
-			})
-		} else {
-			debug.Printf("skipping function with invalid signature func %s(%v)(%v)", f.Name, fieldNames(f.Decl.Type.Params), fieldNames(f.Decl.Type.Results))
+		if isHook(f.Name) {
+			debug.Printf("skipping run hook %s", f.Name)
+			continue
 		}
+		fn, err := newFunction(pi, f, "")
+		if err != nil {
+			debug.Printf("skipping function with invalid signature func %s(%v)(%v): %v", f.Name, fieldNames(f.Decl.Type.Params), fieldNames(f.Decl.Type.Results), err)
+			continue
+		}
+		debug.Printf("found target %v", f.Name)
+		pi.Funcs = append(pi.Funcs, fn)
 	}
 }
 
@@ -310,25 +497,59 @@ func setNamespaces(pi *PkgInfo) {
 			if !ast.IsExported(f.Name) {
 				continue
 			}
-			typ := funcType(f.Decl.Type)
-			if typ == invalidType {
+			fn, err := newFunction(pi, f, t.Name)
+			if err != nil {
+				debug.Printf("skipping namespace method %s.%s: %v", t.Name, f.Name, err)
 				continue
 			}
 			debug.Printf("found namespace method %s %s.%s", pi.DocPkg.ImportPath, t.Name, f.Name)
-			pi.Funcs = append(pi.Funcs, &Function{
-				Name:      f.Name,
-				Receiver:  t.Name,
-				Comment:   toOneLine(f.Doc),
-				Synopsis:  sanitizeSynopsis(f),
-				IsError:   typ == errorType || typ == contextErrorType,
-				IsContext: typ == contextVoidType || typ == contextErrorType,
-			})
+			pi.Funcs = append(pi.Funcs, fn)
 		}
 	}
 }
 
-func setImports(gocmd string, pi *PkgInfo) error {
+// newFunction returns the target for the given function, or an error if the
+// function's signature is not valid for a target.
+func newFunction(pi *PkgInfo, f *doc.Func, receiver string) (*Function, error) {
+	ft := f.Decl.Type
+	fn := &Function{
+		Name:     f.Name,
+		Receiver: receiver,
+		Comment:  toOneLine(f.Doc),
+		Synopsis: sanitizeSynopsis(f),
+	}
+	if pi.fset != nil {
+		pos := pi.fset.Position(f.Decl.Pos())
+		if abs, err := filepath.Abs(pos.Filename); err == nil {
+			fn.File, fn.Line = abs, pos.Line
+		}
+	}
+	switch {
+	case hasVoidReturn(ft):
+	case hasErrorReturn(ft):
+		fn.IsError = true
+	case hasCleanupReturn(ft):
+		fn.IsError = true
+		fn.IsCleanup = true
+	default:
+		return nil, errors.New("targets may only return nothing, an error, or a cleanup func and an error")
+	}
+	params := ft.Params.List
+	if len(params) > 0 && isContextType(params[0].Type) && len(params[0].Names) < 2 {
+		fn.IsContext = true
+		params = params[1:]
+	}
+	if err := setArgs(pi, fn, params); err != nil {
+		return nil, err
+	}
+	setDirectives(pi, fn)
+	return fn, nil
+}
+
+func setImports(gocmd, dir string, pi *PkgInfo) error {
 	importNames := map[string]string{}
+	pins := map[string]string{}
+	only := map[string][]string{}
 	rootImports := []string{}
 	for _, f := range pi.AstPkg.Files {
 		for _, d := range f.Decls {
@@ -342,34 +563,52 @@ func setImports(gocmd string, pi *PkgInfo) error {
 				if len(gen.Specs) == 1 && gen.Lparen == token.NoPos && impspec.Doc == nil {
 					impspec.Doc = gen.Doc
 				}
-				name, alias, ok := getImportPath(impspec)
+				mi, ok := getImportPath(impspec)
 				if !ok {
 					continue
 				}
-				if alias != "" {
-					debug.Printf("found %s: %s (%s)", importTag, name, alias)
-					if importNames[alias] != "" {
-						return fmt.Errorf("duplicate import alias: %q", alias)
+				if mi.version != "" {
+					pins[mi.path] = mi.version
+				}
+				if len(mi.only) > 0 {
+					only[mi.path] = mi.only
+				}
+				if mi.alias != "" {
+					debug.Printf("found %s: %s (%s)", importTag, mi.path, mi.alias)
+					if importNames[mi.alias] != "" {
+						return fmt.Errorf("duplicate import alias: %q", mi.alias)
 					}
-					importNames[alias] = name
+					importNames[mi.alias] = mi.path
 				} else {
-					debug.Printf("found %s: %s", importTag, name)
-					rootImports = append(rootImports, name)
+					debug.Printf("found %s: %s", importTag, mi.path)
+					rootImports = append(rootImports, mi.path)
 				}
 			}
 		}
 	}
-	imports, err := getNamedImports(gocmd, importNames)
+	imports, err := getNamedImports(gocmd, dir, importNames)
 	if err != nil {
 		return err
 	}
 	for _, s := range rootImports {
-		imp, err := getImport(gocmd, s, "")
+		imp, err := getImport(gocmd, dir, s, "")
 		if err != nil {
 			return err
 		}
 		imports = append(imports, imp)
 	}
+	for _, imp := range imports {
+		if v, ok := pins[imp.Path]; ok {
+			if err := checkPin(imp, v); err != nil {
+				return err
+			}
+		}
+		if names, ok := only[imp.Path]; ok {
+			if err := selectFuncs(imp, names); err != nil {
+				return err
+			}
+		}
+	}
 	if err := checkDupes(pi, imports); err != nil {
 		return err
 	}
@@ -393,38 +632,91 @@ func setImports(gocmd string, pi *PkgInfo) error {
 	return nil
 }
 
-func getImportPath(imp *ast.ImportSpec) (path, alias string, ok bool) {
+// selectFuncs removes all targets from the import except those with the
+// given names.  Names are matched without the import's alias, case
+// insensitively, e.g. "Build" or "Docker:Push" for a method on a namespace.
+func selectFuncs(imp *Import, names []string) error {
+	want := map[string]bool{}
+	for _, n := range names {
+		want[strings.ToLower(n)] = true
+	}
+	var funcs []*Function
+	for _, f := range imp.Info.Funcs {
+		name := f.Name
+		if f.Receiver != "" {
+			name = f.Receiver + ":" + f.Name
+		}
+		name = strings.ToLower(name)
+		if want[name] {
+			funcs = append(funcs, f)
+			delete(want, name)
+		}
+	}
+	if len(want) > 0 {
+		var missing []string
+		for _, n := range names {
+			if want[strings.ToLower(n)] {
+				missing = append(missing, n)
+			}
+		}
+		return fmt.Errorf("%s for %s selects unknown target(s): %s", importTag, imp.Path, strings.Join(missing, ", "))
+	}
+	imp.Info.Funcs = funcs
+	return nil
+}
+
+// mageImport is the data from a mage:import comment.
+type mageImport struct {
+	path    string
+	alias   string
+	version string   // the module version the import is pinned to, if any
+	only    []string // the targets to import, if not all of them
+}
+
+// getImportPath parses the mage:import comment on an import, if any.  The
+// comment takes the form:
+//
+//	// mage:import [alias] [version=v1.2.3] [only=Target1,Target2]
+//
+// The alias may also be given as alias=path, where path is the path of the
+// import.
+func getImportPath(imp *ast.ImportSpec) (mi mageImport, ok bool) {
 	if imp.Doc == nil || len(imp.Doc.List) == 9 {
-		return "", "", false
+		return mi, false
 	}
 	// import is always the last comment
 	s := imp.Doc.List[len(imp.Doc.List)-1].Text
 
 	// trim comment start and normalize for anyone who has spaces or not between
 	// "//"" and the text
-	vals := strings.Fields(strings.ToLower(s[2:]))
+	vals := strings.Fields(s[2:])
 	if len(vals) == 0 {
-		return "", "", false
+		return mi, false
 	}
-	if vals[0] != importTag {
-		return "", "", false
+	if strings.ToLower(vals[0]) != importTag {
+		return mi, false
 	}
-	path, ok = lit2string(imp.Path)
+	mi.path, ok = lit2string(imp.Path)
 	if !ok {
-		return "", "", false
+		return mi, false
 	}
 
-	switch len(vals) {
-	case 1:
-		// just the import tag, this is a root import
-		return path, "", true
-	case 2:
-		// also has an alias
-		return path, vals[1], true
-	default:
-		log.Println("warning: ignoring malformed", importTag, "for import", path)
-		return "", "", false
+	for _, v := range vals[1:] {
+		switch {
+		case strings.HasPrefix(v, "version="):
+			mi.version = strings.TrimPrefix(v, "version=")
+		case strings.HasPrefix(v, "only="):
+			mi.only = strings.Split(strings.TrimPrefix(v, "only="), ",")
+		case mi.alias == "" && !strings.Contains(v, "="):
+			mi.alias = strings.ToLower(v)
+		case mi.alias == "" && strings.HasSuffix(v, "="+mi.path):
+			mi.alias = strings.ToLower(strings.TrimSuffix(v, "="+mi.path))
+		default:
+			log.Println("warning: ignoring malformed", importTag, "for import", mi.path)
+			return mageImport{}, false
+		}
 	}
+	return mi, true
 }
 
 func isNamespace(t *doc.Type) bool {
@@ -517,12 +809,35 @@ func setDefault(pi *PkgInfo) {
 				log.Println("warning: default declaration has multiple values")
 			}
 
-			f, err := getFunction(spec.Values[0], pi)
-			if err != nil {
-				log.Println("warning, default declaration malformed:", err)
-				return
+			switch val := spec.Values[0].(type) {
+			case *ast.CompositeLit:
+				// var Default = []interface{}{Generate, Build}
+				var defaults []*Function
+				for _, elt := range val.Elts {
+					f, err := getFunction(elt, pi)
+					if err != nil {
+						log.Println("warning, default declaration malformed:", err)
+						return
+					}
+					defaults = append(defaults, f)
+				}
+				pi.Defaults = defaults
+			case *ast.CallExpr:
+				// var Default = mg.DefaultFn(chooseDefault)
+				sel, ok := val.Fun.(*ast.SelectorExpr)
+				if !ok || sel.Sel.Name != "DefaultFn" {
+					log.Printf("warning, default declaration malformed: %s is not a target or mg.DefaultFn", spec.Values[0])
+					return
+				}
+				pi.DynamicDefault = true
+			default:
+				f, err := getFunction(val, pi)
+				if err != nil {
+					log.Println("warning, default declaration malformed:", err)
+					return
+				}
+				pi.DefaultFunc = f
 			}
-			pi.DefaultFunc = f
 			return
 		}
 	}
@@ -535,7 +850,32 @@ func lit2string(l *ast.BasicLit) (string, bool) {
 	return strings.Trim(l.Value, `"`), true
 }
 
-func setAliases(pi *PkgInfo) {
+// setAliases sets the aliases from the Aliases map and the //mage:alias
+// directives on targets.
+func setAliases(pi *PkgInfo) error {
+	pi.Aliases = map[string]*Function{}
+	// aliases may only be declared once, ignoring case, since that's how
+	// they're matched.
+	declared := map[string]string{}
+	add := func(alias string, f *Function) error {
+		key := strings.ToLower(alias)
+		if prev, ok := declared[key]; ok {
+			if pi.Aliases[prev] == f {
+				return nil
+			}
+			return fmt.Errorf("alias %q is declared for both %s and %s", alias, pi.Aliases[prev].ID(), f.ID())
+		}
+		declared[key] = alias
+		pi.Aliases[alias] = f
+		return nil
+	}
+	for _, f := range pi.Funcs {
+		for _, alias := range f.Aliases {
+			if err := add(alias, f); err != nil {
+				return err
+			}
+		}
+	}
 	for _, v := range pi.DocPkg.Vars {
 		for x, name := range v.Names {
 			if name != "Aliases" {
@@ -544,7 +884,7 @@ func setAliases(pi *PkgInfo) {
 			spec, ok := v.Decl.Specs[x].(*ast.ValueSpec)
 			if !ok {
 				log.Println("warning: aliases declaration is not a value")
-				return
+				return nil
 			}
 			if len(spec.Values) != 1 {
 				log.Println("warning: aliases declaration has multiple values")
@@ -552,9 +892,8 @@ func setAliases(pi *PkgInfo) {
 			comp, ok := spec.Values[0].(*ast.CompositeLit)
 			if !ok {
 				log.Println("warning: aliases declaration is not a map")
-				return
+				return nil
 			}
-			pi.Aliases = map[string]*Function{}
 			for _, elem := range comp.Elts {
 				kv, ok := elem.(*ast.KeyValueExpr)
 				if !ok {
@@ -577,11 +916,45 @@ func setAliases(pi *PkgInfo) {
 					log.Printf("warning, alias malformed: %v", err)
 					continue
 				}
-				pi.Aliases[alias] = f
+				if err := add(alias, f); err != nil {
+					return err
+				}
 			}
-			return
+			return nil
+		}
+	}
+	return nil
+}
+
+// checkAliases returns an error if an alias has the same name as a target,
+// since only one of them could be run.
+func checkAliases(pi *PkgInfo) error {
+	targets := map[string][]*Function{}
+	for _, f := range pi.Funcs {
+		name := strings.ToLower(f.TargetName())
+		targets[name] = append(targets[name], f)
+	}
+	for _, imp := range pi.Imports {
+		for _, f := range imp.Info.Funcs {
+			name := strings.ToLower(f.TargetName())
+			targets[name] = append(targets[name], f)
+		}
+	}
+	var aliases []string
+	for alias := range pi.Aliases {
+		aliases = append(aliases, alias)
+	}
+	sort.Strings(aliases)
+	for _, alias := range aliases {
+		if funcs := targets[strings.ToLower(alias)]; len(funcs) > 0 {
+			var ids []string
+			for _, f := range funcs {
+				ids = append(ids, f.ID())
+			}
+			return fmt.Errorf("alias %q for %s duplicates existing target(s): %s", alias, pi.Aliases[alias].ID(), strings.Join(ids, ", "))
 		}
 	}
+	return nil
 }
 
 func getFunction(exp ast.Expr, pi *PkgInfo) (*Function, error) {
@@ -701,12 +1074,8 @@ func getPackage(path string, files []string, fset *token.FileSet) (*ast.Package,
 	}
 }
 
-func hasContextParam(ft *ast.FuncType) bool {
-	if ft.Params.NumFields() != 1 {
-		return false
-	}
-	ret := ft.Params.List[0]
-	sel, ok := ret.Type.(*ast.SelectorExpr)
+func isContextType(expr ast.Expr) bool {
+	sel, ok := expr.(*ast.SelectorExpr)
 	if !ok {
 		return false
 	}
@@ -737,34 +1106,28 @@ func hasErrorReturn(ft *ast.FuncType) bool {
 	return fmt.Sprint(ret.Type) == "error"
 }
 
-type functype int
-
-const (
-	invalidType functype = iota
-	voidType
-	errorType
-	contextVoidType
-	contextErrorType
-)
-
-func funcType(ft *ast.FuncType) functype {
-	if hasContextParam(ft) {
-		if hasVoidReturn(ft) {
-			return contextVoidType
-		}
-		if hasErrorReturn(ft) {
-			return contextErrorType
-		}
+// hasCleanupReturn reports whether the function returns (func(), error), a
+// cleanup func to run once all the targets have finished, and an error.
+func hasCleanupReturn(ft *ast.FuncType) bool {
+	res := ft.Results
+	if res.NumFields() != 2 {
+		return false
 	}
-	if ft.Params.NumFields() == 0 {
-		if hasVoidReturn(ft) {
-			return voidType
+	var types []ast.Expr
+	for _, r := range res.List {
+		n := len(r.Names)
+		if n == 0 {
+			n = 1
 		}
-		if hasErrorReturn(ft) {
-			return errorType
+		for i := 0; i < n; i++ {
+			types = append(types, r.Type)
 		}
 	}
-	return invalidType
+	cleanup, ok := types[0].(*ast.FuncType)
+	if !ok || cleanup.Params.NumFields() != 0 || cleanup.Results.NumFields() != 0 {
+		return false
+	}
+	return fmt.Sprint(types[1]) == "error"
 }
 
 func toOneLine(s string) string {
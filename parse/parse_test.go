@@ -3,6 +3,7 @@ package parse
 import (
 	"log"
 	"os"
+	"path/filepath"
 	"reflect"
 	"testing"
 
@@ -14,7 +15,7 @@ func init() {
 }
 
 func TestParse(t *testing.T) {
-	info, err := PrimaryPackage("go", "./testdata", []string{"func.go", "command.go", "alias.go", "repeating_synopsis.go", "subcommands.go"})
+	info, err := PrimaryPackage("go", "./testdata", []string{"func.go", "command.go", "alias.go", "repeating_synopsis.go", "subcommands.go", "args.go", "directives.go"})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -28,6 +29,7 @@ func TestParse(t *testing.T) {
 		},
 		{
 			Name: "ReturnsVoid",
+			Deps: []Dep{{Name: "f"}},
 		},
 		{
 			Name:      "TakesContextReturnsError",
@@ -55,6 +57,61 @@ func TestParse(t *testing.T) {
 			Receiver: "Build",
 			IsError:  false,
 		},
+		{
+			Name:      "TakesArgs",
+			IsError:   true,
+			IsContext: true,
+			Args: []Arg{
+				{Name: "name", Type: "string"},
+				{Name: "count", Type: "int"},
+				{Name: "dry-run", Type: "bool"},
+			},
+		},
+		{
+			Name:      "WithOptions",
+			ArgStruct: "Options",
+			Args: []Arg{
+				{Name: "dir-name", Type: "string", Field: "DirName", Doc: "Dir is the output directory."},
+				{Name: "force", Type: "bool", Field: "Force"},
+			},
+		},
+		{
+			Name:         "OldBuild",
+			Comment:      "Builds the old way.",
+			Synopsis:     "Builds the old way.",
+			IsDeprecated: true,
+			Deprecation:  "use build:all",
+		},
+		{
+			Name:     "InstallTools",
+			Comment:  "Installs the tools the other targets need.",
+			Synopsis: "Installs the tools the other targets need.",
+			IsHidden: true,
+		},
+		{
+			Name:     "Publish",
+			Comment:  "Publishes the release.",
+			Synopsis: "Publishes the release.",
+			Tags:     []string{"release", "slow"},
+		},
+		{
+			Name: "MoreTypes",
+			Args: []Arg{
+				{Name: "d", Type: "time.Duration"},
+				{Name: "f", Type: "float64"},
+				{Name: "list", Type: "[]string"},
+				{Name: "m", Type: "Mode", Enum: []string{"fast", "slow"}},
+			},
+		},
+		{
+			Name:      "Exec",
+			IsError:   true,
+			IsCleanup: true,
+			Args: []Arg{
+				{Name: "cmd", Type: "string"},
+				{Name: "args", Type: "...string"},
+			},
+		},
 	}
 
 	if info.DefaultFunc == nil {
@@ -87,10 +144,19 @@ func TestParse(t *testing.T) {
 		t.Fatalf("expected to only have two aliases, but have %#v", info.Aliases)
 	}
 
+	for _, fn := range info.Funcs {
+		if fn.Name == "UnsupportedArg" {
+			t.Fatal("expected UnsupportedArg to be skipped because float32 args are not supported")
+		}
+	}
+
 	for _, fn := range expected {
 		found := false
 		for _, infoFn := range info.Funcs {
-			if reflect.DeepEqual(fn, *infoFn) {
+			// positions are checked in TestFunctionPosition.
+			got := *infoFn
+			got.File, got.Line = "", 0
+			if reflect.DeepEqual(fn, got) {
 				found = true
 				break
 			} else {
@@ -103,8 +169,46 @@ func TestParse(t *testing.T) {
 	}
 }
 
+func TestFunctionPosition(t *testing.T) {
+	info, err := PrimaryPackage("go", "./testdata", []string{"func.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := filepath.Abs(filepath.Join("testdata", "func.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range info.Funcs {
+		if fn.Name != "ReturnsNilError" {
+			continue
+		}
+		if fn.File != file || fn.Line != 9 {
+			t.Errorf("expected ReturnsNilError at %s:9, but got %s:%d", file, fn.File, fn.Line)
+		}
+		return
+	}
+	t.Fatal("ReturnsNilError not found")
+}
+
+func TestMgFuncs(t *testing.T) {
+	info, err := PrimaryPackage("go", "./testdata", []string{"func.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.MgFuncs != nil {
+		t.Errorf("expected no mg funcs for magefiles that don't use mage's libraries, but got %v", info.MgFuncs)
+	}
+	info, err = PrimaryPackage("go", "./testdata", []string{"command.go"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.MgFuncs["Deps"] || !info.MgFuncs["CurrentLogger"] {
+		t.Errorf("expected the funcs of this repo's mg, but got %v", info.MgFuncs)
+	}
+}
+
 func TestGetImportSelf(t *testing.T) {
-	imp, err := getImport("go", "github.com/magefile/mage/parse/testdata/importself", "")
+	imp, err := getImport("go", "", "github.com/magefile/mage/parse/testdata/importself", "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,3 +216,19 @@ func TestGetImportSelf(t *testing.T) {
 		t.Fatalf("expected package importself, got %v", imp.Info.AstPkg.Name)
 	}
 }
+
+func TestFlagName(t *testing.T) {
+	tests := map[string]string{
+		"version":   "version",
+		"buildTags": "build-tags",
+		"DryRun":    "dry-run",
+		"BaseURL":   "base-url",
+		"URLPath":   "url-path",
+		"arm64Only": "arm64-only",
+	}
+	for in, expected := range tests {
+		if actual := flagName(in); actual != expected {
+			t.Errorf("flagName(%q): expected %q, got %q", in, expected, actual)
+		}
+	}
+}
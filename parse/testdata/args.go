@@ -0,0 +1,36 @@
+// +build mage
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// Options are the options for WithOptions.
+type Options struct {
+	// Dir is the output directory.
+	DirName string
+	Force   bool
+	private string
+}
+
+func TakesArgs(ctx context.Context, name string, count int, dryRun bool) error {
+	return nil
+}
+
+func WithOptions(opts Options) {}
+
+func UnsupportedArg(f float32) {}
+
+// Mode is how fast to go.
+type Mode string
+
+const (
+	Fast Mode = "fast"
+	Slow Mode = "slow"
+)
+
+func MoreTypes(d time.Duration, f float64, list []string, m Mode) {}
+
+func Exec(cmd string, args ...string) (func(), error) { return nil, nil }
@@ -0,0 +1,18 @@
+// +build mage
+
+package main
+
+// Builds the old way.
+//
+//mage:deprecated "use build:all"
+func OldBuild() {}
+
+// Installs the tools the other targets need.
+//
+//mage:hidden
+func InstallTools() {}
+
+// Publishes the release.
+//
+//mage:tags release, slow
+func Publish() {}
@@ -0,0 +1,144 @@
+// Package pool runs tasks in a target at the same time, but no more than a
+// limit at once, like uploading many artifacts or testing many modules.
+// Every task runs even if others fail, and the errors of the ones that failed
+// are returned together.
+package pool
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/osinfo"
+)
+
+// Task is a piece of work for Run.
+type Task struct {
+	// Name is what the task is called in logs and errors, e.g. the file it
+	// uploads.
+	Name string
+
+	// Run does the work.  It should stop when the context is cancelled.
+	Run func(ctx context.Context) error
+}
+
+// TaskError is the error from a task that failed.
+type TaskError struct {
+	Name string
+	Err  error
+}
+
+func (e *TaskError) Error() string {
+	return e.Name + ": " + e.Err.Error()
+}
+
+// ExitStatus returns the exit status of the task's error, so mage exits with
+// it.
+func (e *TaskError) ExitStatus() int {
+	return mg.ExitStatus(e.Err)
+}
+
+// Errors are the errors from the tasks that failed, in the order the tasks
+// were given.
+type Errors []*TaskError
+
+func (e Errors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// ExitStatus returns the exit status the tasks failed with, if they agree,
+// or else 1, as mg.Deps does.
+func (e Errors) ExitStatus() int {
+	code := 0
+	for _, err := range e {
+		switch c := err.ExitStatus(); {
+		case code == 0:
+			code = c
+		case c != code:
+			return 1
+		}
+	}
+	return code
+}
+
+// Run runs the tasks, at most n at once, or as many as there are CPUs if n
+// is 0, as osinfo.CPUs counts them.  If any fail, it returns their Errors.
+func Run(n int, tasks ...Task) error {
+	return RunContext(context.Background(), n, tasks...)
+}
+
+// RunContext is like Run, but the tasks are given the context.  Once it's
+// cancelled, the tasks that haven't started yet aren't, and fail with the
+// context's error.
+func RunContext(ctx context.Context, n int, tasks ...Task) error {
+	if n <= 0 {
+		n = osinfo.CPUs()
+	}
+	errs := make([]error, len(tasks))
+	sem := make(chan struct{}, n)
+	var wg sync.WaitGroup
+	// with mage -j, the commands the tasks run take the place of the target
+	// while it waits for them.
+	mg.Yield(func() {
+		for i, t := range tasks {
+			acquired := false
+			select {
+			case sem <- struct{}{}:
+				acquired = true
+			case <-ctx.Done():
+			}
+			if ctx.Err() != nil {
+				if acquired {
+					<-sem
+				}
+				errs[i] = ctx.Err()
+				continue
+			}
+			wg.Add(1)
+			go func(i int, t Task) {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+				errs[i] = run(ctx, t)
+			}(i, t)
+		}
+		wg.Wait()
+	})
+
+	var failed Errors
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, &TaskError{Name: tasks[i].Name, Err: err})
+		}
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// run runs the task, turning a panic into an error.
+func run(ctx context.Context, t Task) (err error) {
+	start := clock.Now()
+	log.Printf("%s: started", t.Name)
+	defer func() {
+		if v := recover(); v != nil {
+			err = fmt.Errorf("panic: %v", v)
+		}
+		if err != nil {
+			log.Printf("%s: failed after %v: %v", t.Name, clock.Since(start), err)
+		} else {
+			log.Printf("%s: done in %v", t.Name, clock.Since(start))
+		}
+	}()
+	return t.Run(ctx)
+}
@@ -0,0 +1,147 @@
+package pool
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/mg"
+)
+
+func TestRun(t *testing.T) {
+	var running, most int32
+	var mu sync.Mutex
+	var done []string
+	task := func(name string) Task {
+		return Task{Name: name, Run: func(context.Context) error {
+			n := atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			mu.Lock()
+			if n > most {
+				most = n
+			}
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+			mu.Lock()
+			done = append(done, name)
+			mu.Unlock()
+			return nil
+		}}
+	}
+	var tasks []Task
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		tasks = append(tasks, task(name))
+	}
+	if err := Run(2, tasks...); err != nil {
+		t.Fatal(err)
+	}
+	if len(done) != 6 {
+		t.Fatalf("expected all 6 tasks to run but %d did", len(done))
+	}
+	if most != 2 {
+		t.Fatalf("expected 2 tasks to run at once but %d did", most)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	var ran int32
+	tasks := []Task{
+		{Name: "upload a.zip", Run: func(context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			return mg.Fatal(3, "403 Forbidden")
+		}},
+		{Name: "upload b.zip", Run: func(context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			return nil
+		}},
+		{Name: "upload c.zip", Run: func(context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			panic("boom")
+		}},
+	}
+	err := Run(1, tasks...)
+	if ran != 3 {
+		t.Fatalf("expected every task to run but %d did", ran)
+	}
+	errs, ok := err.(Errors)
+	if !ok || len(errs) != 2 {
+		t.Fatalf("expected 2 errors but got %v", err)
+	}
+	expected := "upload a.zip: 403 Forbidden\nupload c.zip: panic: boom"
+	if err.Error() != expected {
+		t.Fatalf("expected %q but got %q", expected, err)
+	}
+	if mg.ExitStatus(err) != 1 || errs[0].ExitStatus() != 3 {
+		t.Fatalf("expected exit status 1 for different codes but got %d", mg.ExitStatus(err))
+	}
+	if mg.ExitStatus(errs[:1]) != 3 {
+		t.Fatalf("expected exit status 3 but got %d", mg.ExitStatus(errs[:1]))
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var ran int32
+	tasks := []Task{
+		{Name: "first", Run: func(ctx context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			cancel()
+			<-ctx.Done()
+			return ctx.Err()
+		}},
+		{Name: "second", Run: func(context.Context) error {
+			atomic.AddInt32(&ran, 1)
+			return nil
+		}},
+	}
+	err := RunContext(ctx, 1, tasks...)
+	if ran != 1 {
+		t.Fatalf("expected only the first task to run but %d did", ran)
+	}
+	if err == nil || err.Error() != "first: context canceled\nsecond: context canceled" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if errs := err.(Errors); errs[0].Err != context.Canceled || errs[1].Err != context.Canceled {
+		t.Fatalf("expected the context's error but got %v", errs)
+	}
+}
+
+// TestRunJobs checks that the jobs the tasks start take the place of the
+// dependency running them with mage -j, rather than waiting behind it.
+func TestRunJobs(t *testing.T) {
+	os.Setenv(mg.JobsEnv, "1")
+	defer os.Unsetenv(mg.JobsEnv)
+	var running, most int32
+	task := Task{Name: "task", Run: func(context.Context) error {
+		done := mg.StartJob()
+		defer done()
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		if n > atomic.LoadInt32(&most) {
+			atomic.StoreInt32(&most, n)
+		}
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	}}
+	finished := make(chan error)
+	go func() {
+		var err error
+		mg.Deps(func() { err = Run(2, task, task, task) })
+		finished <- err
+	}()
+	select {
+	case err := <-finished:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected the tasks to finish, but they deadlocked")
+	}
+	if most != 1 {
+		t.Fatalf("expected 1 task to run at once with -j 1, but %d did", most)
+	}
+}
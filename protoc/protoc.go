@@ -0,0 +1,270 @@
+// Package protoc generates code from protocol buffers with protoc and its
+// plugins, pinned to versions, so everyone generates the same code: protoc is
+// downloaded from its releases with the downloads package, and the plugins
+// are installed with the tools package.  Like the codegen package, which it
+// uses, it only generates code when the protos have changed, and checks in CI
+// that the generated code that's committed is up to date.
+package protoc
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/codegen"
+	"github.com/magefile/mage/downloads"
+	"github.com/magefile/mage/tools"
+)
+
+// DefaultVersion is the version of protoc that's downloaded if Options
+// doesn't set one.
+const DefaultVersion = "25.1"
+
+// The packages of the usual plugins for Go.
+const (
+	GoPackage     = "google.golang.org/protobuf/cmd/protoc-gen-go"
+	GoGRPCPackage = "google.golang.org/grpc/cmd/protoc-gen-go-grpc"
+)
+
+// Binary returns the protoc release download of the version, e.g. 25.1.
+func Binary(version string) downloads.Binary {
+	b := downloads.Binary{
+		Name:    "protoc",
+		Version: version,
+		URL:     "https://github.com/protocolbuffers/protobuf/releases/download/v{version}/protoc-{version}-{os}-{arch}.zip",
+		Path:    "bin/protoc",
+		OS:      map[string]string{"darwin": "osx"},
+		Arch:    map[string]string{"amd64": "x86_64", "arm64": "aarch_64", "386": "x86_32", "ppc64le": "ppcle_64", "s390x": "s390_64"},
+	}
+	if runtime.GOOS == "windows" {
+		b.URL = "https://github.com/protocolbuffers/protobuf/releases/download/v{version}/protoc-{version}-win64.zip"
+		b.Path = "bin/protoc.exe"
+	}
+	return b
+}
+
+// Plugin is a protoc plugin, which generates code for a language.
+type Plugin struct {
+	// Package is the go package the plugin is installed from, e.g.
+	// GoPackage.  The plugin's name is the name of its binary without
+	// protoc-gen-, e.g. go for protoc-gen-go, and it's run with --go_out.
+	Package string
+
+	// Version is the module version to install.  It defaults to the version
+	// of the package in the tools manifest.
+	Version string
+
+	// Binary is the plugin to run, instead of installing one.
+	Binary string
+
+	// Out is the directory the code is generated in.
+	Out string
+
+	// Opts are the plugin's options, e.g. paths=source_relative.
+	Opts []string
+}
+
+func (p Plugin) name() string {
+	base := strings.TrimSuffix(filepath.Base(p.Binary), ".exe")
+	if p.Binary == "" {
+		base = filepath.Base(p.Package)
+	}
+	return strings.TrimPrefix(base, "protoc-gen-")
+}
+
+// binary returns the path to the plugin, installing it if it's not already.
+func (p Plugin) binary() (string, error) {
+	if p.Binary != "" {
+		return p.Binary, nil
+	}
+	if p.Version != "" {
+		return tools.Ensure(p.Package, p.Version)
+	}
+	t, ok, err := tools.Lookup(p.Package)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return t.Ensure()
+	}
+	return "", fmt.Errorf("no version of %s to install, set one or add it to the tools manifest", p.Package)
+}
+
+// Options are the options for generating code.
+type Options struct {
+	// Version is the version of protoc to download.  It defaults to
+	// DefaultVersion.
+	Version string
+
+	// Protoc is the protoc to run, instead of downloading one.
+	Protoc string
+
+	// Protos are the files to generate code from, as globs like
+	// api/v1/*.proto.  Each must match something.
+	Protos []string
+
+	// Includes are the directories imports are found in.  They default to the
+	// working directory.  The well known types, like
+	// google/protobuf/timestamp.proto, are always included.
+	Includes []string
+
+	// Plugins are the plugins that generate the code.
+	Plugins []Plugin
+
+	// Outputs are the files and directories the code is generated in, which
+	// tell whether it's up to date, and are what Check checks.  They default
+	// to the plugins' Out directories, unless the protos are in one, e.g.
+	// with paths=source_relative, when the code is always generated and
+	// Check checks every file.
+	Outputs []string
+}
+
+// Generate generates the code, unless it's newer than the protos.
+//
+//	// Proto generates the API's code.
+//	func Proto() error {
+//		return protoc.Generate(protoc.Options{
+//			Protos: []string{"api/v1/*.proto"},
+//			Plugins: []protoc.Plugin{
+//				{Package: protoc.GoPackage, Version: "v1.31.0", Out: ".", Opts: []string{"paths=source_relative"}},
+//				{Package: protoc.GoGRPCPackage, Version: "v1.3.0", Out: ".", Opts: []string{"paths=source_relative"}},
+//			},
+//		})
+//	}
+func Generate(opts Options) error {
+	g, err := generator(opts)
+	if err != nil {
+		return err
+	}
+	return codegen.Generate(g)
+}
+
+// Check generates the code, and fails with the diff if that changed it, so the
+// generated code that's committed is out of date.
+func Check(opts Options) error {
+	g, err := generator(opts)
+	if err != nil {
+		return err
+	}
+	return codegen.Check(g)
+}
+
+// Run checks the generated code, like Check, in CI, i.e. when CI is true, and
+// otherwise generates it, like Generate.
+func Run(opts Options) error {
+	g, err := generator(opts)
+	if err != nil {
+		return err
+	}
+	return codegen.Run(g)
+}
+
+// generator returns the codegen generator that runs protoc, having installed
+// protoc and the plugins, and made the directories they write to.
+func generator(opts Options) (codegen.Generator, error) {
+	if len(opts.Protos) == 0 || len(opts.Plugins) == 0 {
+		return codegen.Generator{}, fmt.Errorf("protoc needs protos and plugins to generate code with")
+	}
+	bin := opts.Protoc
+	if bin == "" {
+		version := opts.Version
+		if version == "" {
+			version = DefaultVersion
+		}
+		var err error
+		if bin, err = Binary(version).Ensure(); err != nil {
+			return codegen.Generator{}, err
+		}
+	}
+	var files []string
+	for _, pattern := range opts.Protos {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return codegen.Generator{}, err
+		}
+		if len(matches) == 0 {
+			return codegen.Generator{}, fmt.Errorf("glob didn't match any files: %s", pattern)
+		}
+		files = append(files, matches...)
+	}
+	sort.Strings(files)
+
+	cmd := []string{bin}
+	for _, dir := range includes(opts.Includes, bin) {
+		cmd = append(cmd, "-I", dir)
+	}
+	outs := map[string]bool{}
+	// a directory that doesn't exist yet hasn't had code generated in it, but
+	// once it's made, it looks newer than the protos.
+	created := false
+	for _, p := range opts.Plugins {
+		path, err := p.binary()
+		if err != nil {
+			return codegen.Generator{}, err
+		}
+		out := p.Out
+		if out == "" {
+			out = "."
+		}
+		if _, err := os.Stat(out); os.IsNotExist(err) {
+			created = true
+		}
+		if err := os.MkdirAll(out, 0755); err != nil {
+			return codegen.Generator{}, err
+		}
+		outs[out] = true
+		name := p.name()
+		cmd = append(cmd, "--plugin=protoc-gen-"+name+"="+path, "--"+name+"_out="+out)
+		for _, opt := range p.Opts {
+			cmd = append(cmd, "--"+name+"_opt="+opt)
+		}
+	}
+	outputs := opts.Outputs
+	if len(outputs) == 0 {
+		for out := range outs {
+			if containsAny(out, files) {
+				outputs = nil
+				break
+			}
+			outputs = append(outputs, out)
+		}
+		sort.Strings(outputs)
+	}
+	if created {
+		outputs = nil
+	}
+	return codegen.Generator{
+		Name:    "protoc",
+		Command: append(cmd, files...),
+		Inputs:  opts.Protos,
+		Outputs: outputs,
+	}, nil
+}
+
+// containsAny reports whether any of the files are in the directory.
+func containsAny(dir string, files []string) bool {
+	for _, f := range files {
+		rel, err := filepath.Rel(dir, f)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// includes returns the include paths: the given ones, or the working
+// directory, and then the directory of the well known types that comes with
+// protoc, next to its bin directory, if it's there.
+func includes(dirs []string, protoc string) []string {
+	if len(dirs) == 0 {
+		dirs = []string{"."}
+	}
+	wkt := filepath.Join(filepath.Dir(filepath.Dir(protoc)), "include")
+	if fi, err := os.Stat(filepath.Join(wkt, "google", "protobuf")); err == nil && fi.IsDir() {
+		dirs = append(dirs[:len(dirs):len(dirs)], wkt)
+	}
+	return dirs
+}
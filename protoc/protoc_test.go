@@ -0,0 +1,173 @@
+package protoc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"protoc": fakeProtoc})
+	os.Exit(m.Run())
+}
+
+// fakeProtoc stands in for protoc, logging how it was run to the file log
+// next to it, and writing a file to each out directory.
+func fakeProtoc(args []string) int {
+	faketool.Append(filepath.Join(faketool.Dir(), "log"), strings.Join(args, " "))
+	for _, arg := range args {
+		if i := strings.Index(arg, "_out="); strings.HasPrefix(arg, "--") && i > 0 {
+			ioutil.WriteFile(filepath.Join(arg[strings.Index(arg, "=")+1:], "gen.txt"), nil, 0644)
+		}
+	}
+	return 0
+}
+
+func TestBinary(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("windows has its own download")
+	}
+	b := Binary("25.1")
+	tests := map[string]string{
+		"linux/amd64":  "https://github.com/protocolbuffers/protobuf/releases/download/v25.1/protoc-25.1-linux-x86_64.zip",
+		"darwin/arm64": "https://github.com/protocolbuffers/protobuf/releases/download/v25.1/protoc-25.1-osx-aarch_64.zip",
+	}
+	for platform, expected := range tests {
+		parts := strings.Split(platform, "/")
+		if got := b.URLFor(parts[0], parts[1]); got != expected {
+			t.Errorf("%s: expected %s, got %s", platform, expected, got)
+		}
+	}
+}
+
+// project makes a project with api/v1/api.proto, and a fake protoc, with the
+// well known types next to it, that logs how it was run and writes a file to
+// each out directory.  It changes to the project, and returns the path to
+// protoc, the log, and a func that puts things back.
+func project(t *testing.T) (protoc, logFile string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.MkdirAll(filepath.Join(dir, "protoc", "include", "google", "protobuf"), 0755)
+	os.MkdirAll(filepath.Join(dir, "api", "v1"), 0755)
+	ioutil.WriteFile(filepath.Join(dir, "api", "v1", "api.proto"), []byte(`syntax = "proto3";`), 0644)
+	bin := filepath.Join(dir, "protoc", "bin")
+	protoc = faketool.Install(t, bin, "protoc")
+	logFile = filepath.Join(bin, "log")
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	ci, hasCI := os.LookupEnv("CI")
+	os.Unsetenv("CI")
+	return protoc, logFile, func() {
+		if hasCI {
+			os.Setenv("CI", ci)
+		}
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func ran(t *testing.T, logFile string) string {
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	os.Remove(logFile)
+	return strings.TrimSpace(string(b))
+}
+
+func TestGenerate(t *testing.T) {
+	protoc, logFile, cleanup := project(t)
+	defer cleanup()
+	opts := Options{
+		Protoc:   protoc,
+		Protos:   []string{"api/*/*.proto"},
+		Includes: []string{"api"},
+		Plugins: []Plugin{
+			{Binary: "/bin/protoc-gen-go", Out: "gen", Opts: []string{"paths=source_relative"}},
+			{Binary: "/bin/protoc-gen-go-grpc", Out: "gen"},
+		},
+	}
+	if err := Generate(opts); err != nil {
+		t.Fatal(err)
+	}
+	wkt := filepath.Join(filepath.Dir(filepath.Dir(protoc)), "include")
+	expected := "-I api -I " + wkt +
+		" --plugin=protoc-gen-go=/bin/protoc-gen-go --go_out=gen --go_opt=paths=source_relative" +
+		" --plugin=protoc-gen-go-grpc=/bin/protoc-gen-go-grpc --go-grpc_out=gen " + filepath.Join("api", "v1", "api.proto")
+	if got := ran(t, logFile); got != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, got)
+	}
+	if err := Generate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got != "" {
+		t.Fatalf("expected protoc not to run when the code is up to date, got %q", got)
+	}
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(filepath.Join("api", "v1", "api.proto"), later, later)
+	if err := Generate(opts); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(t, logFile); got == "" {
+		t.Fatal("expected protoc to run when a proto changed")
+	}
+}
+
+func TestGenerateNextToProtos(t *testing.T) {
+	protoc, logFile, cleanup := project(t)
+	defer cleanup()
+	opts := Options{
+		Protoc:  protoc,
+		Protos:  []string{"api/v1/api.proto"},
+		Plugins: []Plugin{{Binary: "/bin/protoc-gen-go", Out: ".", Opts: []string{"paths=source_relative"}}},
+	}
+	g, err := generator(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(g.Outputs) != 0 {
+		t.Fatalf("expected no outputs when the code is generated next to the protos, got %v", g.Outputs)
+	}
+	for i := 0; i < 2; i++ {
+		if err := Generate(opts); err != nil {
+			t.Fatal(err)
+		}
+		if got := ran(t, logFile); !strings.HasPrefix(got, "-I . -I ") {
+			t.Fatalf("expected protoc to run every time, got %q", got)
+		}
+	}
+}
+
+func TestOptionsErrors(t *testing.T) {
+	protoc, _, cleanup := project(t)
+	defer cleanup()
+	tests := []struct {
+		opts Options
+		err  string
+	}{
+		{Options{Protoc: protoc}, "needs protos and plugins"},
+		{Options{Protoc: protoc, Protos: []string{"*.proto"}, Plugins: []Plugin{{Binary: "/bin/protoc-gen-go"}}}, "glob didn't match any files: *.proto"},
+		{Options{Protoc: protoc, Protos: []string{"api/v1/*.proto"}, Plugins: []Plugin{{Package: GoPackage}}}, "no version of " + GoPackage},
+	}
+	for _, tt := range tests {
+		if err := Generate(tt.opts); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("expected an error with %q, got %v", tt.err, err)
+		}
+	}
+	// a broken tools manifest is reported, rather than taken for a missing one.
+	if err := ioutil.WriteFile("tools.yaml", []byte("protoc-gen-go "+GoPackage+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Protoc: protoc, Protos: []string{"api/v1/*.proto"}, Plugins: []Plugin{{Package: GoPackage}}}
+	if err := Generate(opts); err == nil || !strings.Contains(err.Error(), "error reading tools.yaml") {
+		t.Errorf("expected an error reading the tools manifest, got %v", err)
+	}
+}
@@ -0,0 +1,166 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/gitx"
+)
+
+// ChangelogOptions are the options for Changelog.
+type ChangelogOptions struct {
+	// From is the ref the changes are since, usually the previous release's
+	// tag.  If it's empty, it's every change.
+	From string
+
+	// To is the ref the changes are up to.  It defaults to HEAD.
+	To string
+
+	// Version is the title of the section.  It defaults to To.
+	Version string
+
+	// Date is the date in the title of the section.  It defaults to today.
+	Date time.Time
+
+	// NoTitle leaves out the title, e.g. for the body of a GitHub release.
+	NoTitle bool
+
+	// Conventional groups the changes by their conventional commit type,
+	// e.g. feat and fix, with breaking changes first.
+	Conventional bool
+}
+
+// conventionalGroups are the titles of the groups of changes in a
+// conventional changelog, in order, by commit type.  Types that aren't
+// listed are Other Changes.
+var conventionalGroups = []struct {
+	typ, title string
+}{
+	{"!", "Breaking Changes"},
+	{"feat", "Features"},
+	{"fix", "Bug Fixes"},
+	{"perf", "Performance"},
+	{"docs", "Documentation"},
+	{"", "Other Changes"},
+}
+
+// conventionalRE matches the subject of a conventional commit, e.g.
+// "feat(parser)!: allow comments".
+var conventionalRE = regexp.MustCompile(`^(\w+)(?:\(([^)]*)\))?(!)?: (.+)$`)
+
+// Changelog returns a section for a changelog, in markdown, that lists the
+// commits between two refs:
+//
+//	## v1.3.0 (2024-05-01)
+//
+//	- Allow comments in config files (1a2b3c4)
+func Changelog(opts ChangelogOptions) (string, error) {
+	commits, err := gitx.Log(opts.From, opts.To)
+	if err != nil {
+		return "", err
+	}
+	var b strings.Builder
+	if !opts.NoTitle {
+		version := opts.Version
+		if version == "" {
+			version = opts.To
+		}
+		if version == "" {
+			version = "HEAD"
+		}
+		date := opts.Date
+		if date.IsZero() {
+			date = clock.Now()
+		}
+		fmt.Fprintf(&b, "## %s (%s)\n\n", version, date.Format("2006-01-02"))
+	}
+	if !opts.Conventional {
+		for _, c := range commits {
+			fmt.Fprintf(&b, "- %s (%s)\n", c.Subject, short(c.SHA))
+		}
+		return b.String(), nil
+	}
+
+	groups := map[string][]string{}
+	for _, c := range commits {
+		typ, entry := "", c.Subject
+		if m := conventionalRE.FindStringSubmatch(c.Subject); m != nil {
+			typ, entry = m[1], m[4]
+			if m[2] != "" {
+				entry = "**" + m[2] + ":** " + entry
+			}
+			if m[3] != "" || strings.Contains(c.Body, "BREAKING CHANGE") {
+				typ = "!"
+			}
+		}
+		if !isGroup(typ) {
+			typ = ""
+		}
+		groups[typ] = append(groups[typ], fmt.Sprintf("- %s (%s)\n", entry, short(c.SHA)))
+	}
+	first := true
+	for _, g := range conventionalGroups {
+		if len(groups[g.typ]) == 0 {
+			continue
+		}
+		if !first {
+			b.WriteString("\n")
+		}
+		first = false
+		fmt.Fprintf(&b, "### %s\n\n%s", g.title, strings.Join(groups[g.typ], ""))
+	}
+	return b.String(), nil
+}
+
+// InsertChangelog adds the section to the changelog file, e.g. CHANGELOG.md,
+// above the newest section, after the file's title and introduction.  The file
+// is created if it doesn't exist.
+func InsertChangelog(path, section string) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	content := string(b)
+	section = strings.TrimRight(section, "\n") + "\n"
+
+	// the newest section starts at the first level 2 heading.
+	i := strings.Index(content, "\n## ")
+	switch {
+	case strings.HasPrefix(content, "## "):
+		i = 0
+	case i >= 0:
+		i++
+	default:
+		i = len(content)
+		if content != "" {
+			content = strings.TrimRight(content, "\n") + "\n\n"
+			i = len(content)
+		}
+	}
+	if i < len(content) {
+		section += "\n"
+	}
+	return ioutil.WriteFile(path, []byte(content[:i]+section+content[i:]), 0644)
+}
+
+func isGroup(typ string) bool {
+	for _, g := range conventionalGroups {
+		if g.typ == typ {
+			return true
+		}
+	}
+	return false
+}
+
+// short abbreviates a commit SHA.
+func short(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
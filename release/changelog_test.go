@@ -0,0 +1,105 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestChangelog(t *testing.T) {
+	run, cleanup := repo(t)
+	defer cleanup()
+	run("tag", "v1.0.0")
+	for _, msg := range []string{
+		"feat(parser): allow comments",
+		"fix: handle empty files",
+		"Update the readme",
+		"docs: explain namespaces",
+		"feat!: drop go 1.11",
+		"refactor: tidy up\n\nBREAKING CHANGE: Foo is now Bar",
+		"chore: bump deps",
+	} {
+		run("commit", "-q", "--allow-empty", "-m", msg)
+	}
+	sha := func(rev string) string { return run("rev-parse", "--short=7", rev) }
+
+	log, err := Changelog(ChangelogOptions{From: "v1.0.0", Version: "v1.1.0", Date: time.Date(2024, 5, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "## v1.1.0 (2024-05-01)\n\n" +
+		"- chore: bump deps (" + sha("HEAD") + ")\n" +
+		"- refactor: tidy up (" + sha("HEAD~1") + ")\n" +
+		"- feat!: drop go 1.11 (" + sha("HEAD~2") + ")\n" +
+		"- docs: explain namespaces (" + sha("HEAD~3") + ")\n" +
+		"- Update the readme (" + sha("HEAD~4") + ")\n" +
+		"- fix: handle empty files (" + sha("HEAD~5") + ")\n" +
+		"- feat(parser): allow comments (" + sha("HEAD~6") + ")\n"
+	if log != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, log)
+	}
+
+	log, err = Changelog(ChangelogOptions{From: "v1.0.0", NoTitle: true, Conventional: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = "### Breaking Changes\n\n" +
+		"- tidy up (" + sha("HEAD~1") + ")\n" +
+		"- drop go 1.11 (" + sha("HEAD~2") + ")\n" +
+		"\n### Features\n\n" +
+		"- **parser:** allow comments (" + sha("HEAD~6") + ")\n" +
+		"\n### Bug Fixes\n\n" +
+		"- handle empty files (" + sha("HEAD~5") + ")\n" +
+		"\n### Documentation\n\n" +
+		"- explain namespaces (" + sha("HEAD~3") + ")\n" +
+		"\n### Other Changes\n\n" +
+		"- bump deps (" + sha("HEAD") + ")\n" +
+		"- Update the readme (" + sha("HEAD~4") + ")\n"
+	if log != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, log)
+	}
+
+	if _, err := Changelog(ChangelogOptions{From: "v9.9.9"}); err == nil {
+		t.Fatal("expected an error for a ref that doesn't exist")
+	}
+}
+
+func TestInsertChangelog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "CHANGELOG.md")
+	section := "## v1.1.0 (2024-05-01)\n\n- new\n"
+
+	tests := []struct {
+		desc, before, after string
+	}{
+		{"missing", "", section},
+		{"sections", "## v1.0.0\n\n- old\n", section + "\n## v1.0.0\n\n- old\n"},
+		{
+			"title",
+			"# Changelog\n\nAll notable changes.\n\n## v1.0.0\n\n- old\n",
+			"# Changelog\n\nAll notable changes.\n\n" + section + "\n## v1.0.0\n\n- old\n",
+		},
+		{"title only", "# Changelog\n", "# Changelog\n\n" + section},
+	}
+	for _, tt := range tests {
+		os.Remove(path)
+		if tt.before != "" {
+			if err := ioutil.WriteFile(path, []byte(tt.before), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := InsertChangelog(path, section); err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadFile(path)
+		if string(b) != tt.after {
+			t.Errorf("%s: expected:\n%s\ngot:\n%s", tt.desc, tt.after, b)
+		}
+	}
+}
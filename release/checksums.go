@@ -0,0 +1,71 @@
+// Package release has helpers for the steps of publishing a release that every
+// project's release target needs, like checksumming and signing the artifacts.
+package release
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ChecksumsFile is the name of the file Checksums writes.
+const ChecksumsFile = "checksums.txt"
+
+// Checksums writes the sha256 sums of the artifacts in dir to checksums.txt in
+// dir, and returns its path.  The file has a line for each artifact, sorted by
+// name, in the format sha256sum -c reads.  Directories, the checksums file and
+// signatures (.asc, .sig and .pem files) are left out.
+func Checksums(dir string) (string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", err
+	}
+	var names []string
+	for _, fi := range infos {
+		if !fi.Mode().IsRegular() || fi.Name() == ChecksumsFile || isSignature(fi.Name()) {
+			continue
+		}
+		names = append(names, fi.Name())
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	for _, name := range names {
+		sum, err := sha256File(filepath.Join(dir, name))
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "%x  %s\n", sum, name)
+	}
+	path := filepath.Join(dir, ChecksumsFile)
+	if err := ioutil.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func isSignature(name string) bool {
+	switch filepath.Ext(name) {
+	case ".asc", ".sig", ".pem":
+		return true
+	}
+	return false
+}
+
+func sha256File(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
@@ -0,0 +1,61 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func tempdir(t *testing.T, files map[string]string) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, s := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestChecksums(t *testing.T) {
+	dir, cleanup := tempdir(t, map[string]string{
+		"mytool_linux_amd64.tar.gz": "linux",
+		"mytool_darwin_arm64.zip":   "darwin",
+		"checksums.txt.asc":         "signature",
+		ChecksumsFile:               "old sums",
+	})
+	defer cleanup()
+	if err := os.Mkdir(filepath.Join(dir, "subdir"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	path, err := Checksums(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != filepath.Join(dir, ChecksumsFile) {
+		t.Fatalf("expected %s but got %s", filepath.Join(dir, ChecksumsFile), path)
+	}
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `
+26ce1a1580f693873b6268fef54c5f0d0607f2896cad02ce2894c0c899a11575  mytool_darwin_arm64.zip
+caf90169eefa5f807d577486b9f795ab86ae2983c5c20806cff959117e90af18  mytool_linux_amd64.tar.gz
+`[1:]
+	if string(b) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b)
+	}
+
+	// the checksums don't change when it's run again.
+	if _, err := Checksums(dir); err != nil {
+		t.Fatal(err)
+	}
+	again, _ := ioutil.ReadFile(path)
+	if string(again) != string(b) {
+		t.Fatalf("expected the same checksums but got:\n%s", again)
+	}
+}
@@ -0,0 +1,251 @@
+package release
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+)
+
+// GitHub is a GitHub repository to publish releases to, with the REST API.
+type GitHub struct {
+	// Repository is the owner and name of the repository, e.g.
+	// magefile/mage.  It defaults to $GITHUB_REPOSITORY, which GitHub
+	// Actions sets.
+	Repository string
+
+	// Token is the token to authenticate with.  It defaults to
+	// $GITHUB_TOKEN.
+	Token string
+
+	// APIURL is the URL of the API.  It defaults to $GITHUB_API_URL, or
+	// else https://api.github.com.
+	APIURL string
+
+	// Retries is how many times a request that fails because of the network
+	// or GitHub is retried.  It defaults to 3.
+	Retries int
+}
+
+// GitHubRelease is a release on GitHub.
+type GitHubRelease struct {
+	ID         int64  `json:"id"`
+	TagName    string `json:"tag_name"`
+	Name       string `json:"name"`
+	Body       string `json:"body"`
+	Draft      bool   `json:"draft"`
+	Prerelease bool   `json:"prerelease"`
+	HTMLURL    string `json:"html_url"`
+	UploadURL  string `json:"upload_url"`
+	Assets     []struct {
+		ID   int64  `json:"id"`
+		Name string `json:"name"`
+	} `json:"assets"`
+}
+
+// PublishOptions are the options for GitHub.Publish.
+type PublishOptions struct {
+	// Tag is the tag the release is for.
+	Tag string
+
+	// Name is the title of the release.  It defaults to the tag.
+	Name string
+
+	// Body is the description of the release, e.g. from Changelog.
+	Body string
+
+	// Draft and Prerelease mark the release as a draft or a prerelease.
+	Draft      bool
+	Prerelease bool
+}
+
+// retryDelay is how long to wait before the first retry, which doubles for
+// each one after.
+var retryDelay = time.Second
+
+// Publish creates the release for the tag, or updates it if there already is
+// one, and returns it.
+func (g GitHub) Publish(opts PublishOptions) (*GitHubRelease, error) {
+	if opts.Tag == "" {
+		return nil, fmt.Errorf("a GitHub release needs a tag")
+	}
+	name := opts.Name
+	if name == "" {
+		name = opts.Tag
+	}
+	body := map[string]interface{}{
+		"tag_name":   opts.Tag,
+		"name":       name,
+		"body":       opts.Body,
+		"draft":      opts.Draft,
+		"prerelease": opts.Prerelease,
+	}
+	repo, err := g.repo()
+	if err != nil {
+		return nil, err
+	}
+	rel := &GitHubRelease{}
+	status, err := g.do("GET", g.api()+"/repos/"+repo+"/releases/tags/"+url.PathEscape(opts.Tag), nil, "", rel)
+	switch {
+	case status == http.StatusNotFound:
+		rel = &GitHubRelease{}
+		_, err = g.do("POST", g.api()+"/repos/"+repo+"/releases", body, "", rel)
+	case err == nil:
+		_, err = g.do("PATCH", fmt.Sprintf("%s/repos/%s/releases/%d", g.api(), repo, rel.ID), body, "", rel)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to publish the GitHub release for %s: %v", opts.Tag, err)
+	}
+	return rel, nil
+}
+
+// Upload adds the files to the release as assets.  If the release already has
+// an asset with the same name, it's replaced if overwrite is true, and
+// otherwise it's an error.
+func (g GitHub) Upload(rel *GitHubRelease, overwrite bool, paths ...string) error {
+	repo, err := g.repo()
+	if err != nil {
+		return err
+	}
+	uploadURL := strings.SplitN(rel.UploadURL, "{", 2)[0]
+	for _, path := range paths {
+		name := filepath.Base(path)
+		for _, a := range rel.Assets {
+			if a.Name != name {
+				continue
+			}
+			if !overwrite {
+				return fmt.Errorf("the GitHub release for %s already has %s", rel.TagName, name)
+			}
+			if _, err := g.do("DELETE", fmt.Sprintf("%s/repos/%s/releases/assets/%d", g.api(), repo, a.ID), nil, "", nil); err != nil {
+				return fmt.Errorf("failed to replace %s: %v", name, err)
+			}
+		}
+		log.Printf("uploading %s to the GitHub release for %s", name, rel.TagName)
+		if _, err := g.do("POST", uploadURL+"?name="+url.QueryEscape(name), nil, path, nil); err != nil {
+			return fmt.Errorf("failed to upload %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// do sends the request, with the JSON body or the file, retrying it if it
+// fails because of the network or GitHub, and decodes the response into out.
+// It returns the status of the last response.
+func (g GitHub) do(method, u string, body interface{}, file string, out interface{}) (int, error) {
+	token := g.Token
+	if token == "" {
+		token = os.Getenv("GITHUB_TOKEN")
+	}
+	if token == "" {
+		return 0, fmt.Errorf("no GitHub token, set $GITHUB_TOKEN")
+	}
+	retries := g.Retries
+	if retries == 0 {
+		retries = 3
+	}
+	delay := retryDelay
+	for attempt := 0; ; attempt++ {
+		status, retry, err := g.send(method, u, token, body, file, out)
+		if err == nil || !retry || attempt == retries {
+			return status, err
+		}
+		log.Printf("%s %s failed, retrying in %v: %v", method, u, delay, err)
+		clock.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// send sends the request once, and reports whether it's worth retrying if it
+// failed.
+func (g GitHub) send(method, u, token string, body interface{}, file string, out interface{}) (status int, retry bool, err error) {
+	var r io.Reader
+	contentType := ""
+	var size int64
+	switch {
+	case file != "":
+		f, err := os.Open(file)
+		if err != nil {
+			return 0, false, err
+		}
+		defer f.Close()
+		fi, err := f.Stat()
+		if err != nil {
+			return 0, false, err
+		}
+		r, contentType, size = f, "application/octet-stream", fi.Size()
+	case body != nil:
+		b, err := json.Marshal(body)
+		if err != nil {
+			return 0, false, err
+		}
+		r, contentType, size = bytes.NewReader(b), "application/json", int64(len(b))
+	}
+	req, err := http.NewRequest(method, u, r)
+	if err != nil {
+		return 0, false, err
+	}
+	req.ContentLength = size
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, true, err
+	}
+	if resp.StatusCode >= 300 {
+		var msg struct {
+			Message string `json:"message"`
+		}
+		json.Unmarshal(b, &msg)
+		if msg.Message == "" {
+			msg.Message = strings.TrimSpace(string(b))
+		}
+		return resp.StatusCode, resp.StatusCode >= 500, fmt.Errorf("%s: %s", resp.Status, msg.Message)
+	}
+	if out != nil && len(b) > 0 {
+		if err := json.Unmarshal(b, out); err != nil {
+			return resp.StatusCode, false, err
+		}
+	}
+	return resp.StatusCode, false, nil
+}
+
+func (g GitHub) repo() (string, error) {
+	repo := g.Repository
+	if repo == "" {
+		repo = os.Getenv("GITHUB_REPOSITORY")
+	}
+	if strings.Count(repo, "/") != 1 {
+		return "", fmt.Errorf("invalid GitHub repository %q, expected owner/name", repo)
+	}
+	return repo, nil
+}
+
+func (g GitHub) api() string {
+	u := g.APIURL
+	if u == "" {
+		u = os.Getenv("GITHUB_API_URL")
+	}
+	if u == "" {
+		u = "https://api.github.com"
+	}
+	return strings.TrimSuffix(u, "/")
+}
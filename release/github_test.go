@@ -0,0 +1,197 @@
+package release
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeGitHub is enough of the GitHub releases API for the tests.
+type fakeGitHub struct {
+	mu       sync.Mutex
+	srv      *httptest.Server
+	releases map[string]*GitHubRelease
+	uploads  map[string]string
+	requests []string
+	failNext int // how many requests fail with a 502
+}
+
+func newFakeGitHub(t *testing.T) *fakeGitHub {
+	f := &fakeGitHub{releases: map[string]*GitHubRelease{}, uploads: map[string]string{}}
+	f.srv = httptest.NewServer(http.HandlerFunc(f.serve))
+	return f
+}
+
+func (f *fakeGitHub) serve(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.requests = append(f.requests, r.Method+" "+r.URL.RequestURI())
+	if r.Header.Get("Authorization") != "Bearer t0ken" {
+		http.Error(w, `{"message":"Bad credentials"}`, http.StatusUnauthorized)
+		return
+	}
+	if f.failNext > 0 {
+		f.failNext--
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return
+	}
+	byID := func(id string) *GitHubRelease {
+		for _, rel := range f.releases {
+			if fmt.Sprint(rel.ID) == id {
+				return rel
+			}
+		}
+		return nil
+	}
+	path := r.URL.Path
+	switch {
+	case r.Method == "GET" && strings.HasPrefix(path, "/repos/o/r/releases/tags/"):
+		rel, ok := f.releases[strings.TrimPrefix(path, "/repos/o/r/releases/tags/")]
+		if !ok {
+			http.Error(w, `{"message":"Not Found"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(rel)
+	case r.Method == "POST" && path == "/repos/o/r/releases", r.Method == "PATCH":
+		rel := &GitHubRelease{}
+		if r.Method == "PATCH" {
+			rel = byID(strings.TrimPrefix(path, "/repos/o/r/releases/"))
+		} else {
+			rel.ID = int64(len(f.releases) + 1)
+			rel.UploadURL = f.srv.URL + fmt.Sprintf("/uploads/%d/assets{?name,label}", rel.ID)
+		}
+		json.NewDecoder(r.Body).Decode(rel)
+		f.releases[rel.TagName] = rel
+		json.NewEncoder(w).Encode(rel)
+	case r.Method == "POST" && strings.HasPrefix(path, "/uploads/"):
+		id := strings.Split(path, "/")[2]
+		name := r.URL.Query().Get("name")
+		b, _ := ioutil.ReadAll(r.Body)
+		rel := byID(id)
+		rel.Assets = append(rel.Assets, struct {
+			ID   int64  `json:"id"`
+			Name string `json:"name"`
+		}{int64(100 + len(f.uploads)), name})
+		f.uploads[name] = string(b)
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "DELETE" && strings.HasPrefix(path, "/repos/o/r/releases/assets/"):
+		id := strings.TrimPrefix(path, "/repos/o/r/releases/assets/")
+		for _, rel := range f.releases {
+			for i, a := range rel.Assets {
+				if fmt.Sprint(a.ID) == id {
+					delete(f.uploads, a.Name)
+					rel.Assets = append(rel.Assets[:i], rel.Assets[i+1:]...)
+					break
+				}
+			}
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestGitHubPublish(t *testing.T) {
+	f := newFakeGitHub(t)
+	defer f.srv.Close()
+	old := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old }()
+	dir, cleanup := tempdir(t, map[string]string{"app.tar.gz": "v1 archive", ChecksumsFile: "sums"})
+	defer cleanup()
+
+	gh := GitHub{Repository: "o/r", Token: "t0ken", APIURL: f.srv.URL + "/"}
+	rel, err := gh.Publish(PublishOptions{Tag: "v1.0.0", Body: "first", Prerelease: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.ID != 1 || rel.Name != "v1.0.0" || rel.Body != "first" || !rel.Prerelease {
+		t.Fatalf("expected a new prerelease but got %+v", rel)
+	}
+
+	// the upload is retried when GitHub fails.
+	f.failNext = 1
+	if err := gh.Upload(rel, false, filepath.Join(dir, "app.tar.gz"), filepath.Join(dir, ChecksumsFile)); err != nil {
+		t.Fatal(err)
+	}
+	if f.uploads["app.tar.gz"] != "v1 archive" || f.uploads[ChecksumsFile] != "sums" {
+		t.Fatalf("expected both files to be uploaded but got %v", f.uploads)
+	}
+
+	// publishing again updates the release.
+	rel, err = gh.Publish(PublishOptions{Tag: "v1.0.0", Name: "One", Body: "updated"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rel.ID != 1 || rel.Name != "One" || rel.Body != "updated" || rel.Prerelease || len(rel.Assets) != 2 {
+		t.Fatalf("expected the release to be updated but got %+v", rel)
+	}
+
+	ioutil.WriteFile(filepath.Join(dir, "app.tar.gz"), []byte("v1 archive, rebuilt"), 0644)
+	err = gh.Upload(rel, false, filepath.Join(dir, "app.tar.gz"))
+	if err == nil || err.Error() != "the GitHub release for v1.0.0 already has app.tar.gz" {
+		t.Fatalf("expected an already has error but got %v", err)
+	}
+	if err := gh.Upload(rel, true, filepath.Join(dir, "app.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+	if f.uploads["app.tar.gz"] != "v1 archive, rebuilt" {
+		t.Fatalf("expected the asset to be replaced but got %q", f.uploads["app.tar.gz"])
+	}
+
+	expected := []string{
+		"GET /repos/o/r/releases/tags/v1.0.0",
+		"POST /repos/o/r/releases",
+		"POST /uploads/1/assets?name=app.tar.gz",
+		"POST /uploads/1/assets?name=app.tar.gz",
+		"POST /uploads/1/assets?name=checksums.txt",
+		"GET /repos/o/r/releases/tags/v1.0.0",
+		"PATCH /repos/o/r/releases/1",
+		"DELETE /repos/o/r/releases/assets/100",
+		"POST /uploads/1/assets?name=app.tar.gz",
+	}
+	if strings.Join(f.requests, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected requests:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(f.requests, "\n"))
+	}
+}
+
+func TestGitHubErrors(t *testing.T) {
+	f := newFakeGitHub(t)
+	defer f.srv.Close()
+	old := retryDelay
+	retryDelay = time.Millisecond
+	defer func() { retryDelay = old }()
+	defer func(v string, ok bool) {
+		if ok {
+			os.Setenv("GITHUB_REPOSITORY", v)
+		} else {
+			os.Unsetenv("GITHUB_REPOSITORY")
+		}
+	}(os.LookupEnv("GITHUB_REPOSITORY"))
+	os.Unsetenv("GITHUB_REPOSITORY")
+
+	tests := []struct {
+		gh       GitHub
+		fail     int
+		expected string
+	}{
+		{GitHub{Token: "t0ken", APIURL: f.srv.URL}, 0, `invalid GitHub repository "", expected owner/name`},
+		{GitHub{Repository: "o/r", Token: "wrong", APIURL: f.srv.URL}, 0, "failed to publish the GitHub release for v1: 401 Unauthorized: Bad credentials"},
+		{GitHub{Repository: "o/r", Token: "t0ken", APIURL: f.srv.URL, Retries: 1}, 2, "failed to publish the GitHub release for v1: 502 Bad Gateway: bad gateway"},
+	}
+	for _, tt := range tests {
+		f.failNext = tt.fail
+		_, err := tt.gh.Publish(PublishOptions{Tag: "v1"})
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("expected %q but got %v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package release
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// SignGPG makes an armored, detached gpg signature of the file, at the file's
+// path plus .asc, and returns its path.  The key is the user id of the key to
+// sign with, or empty for gpg's default key.  It fails before signing if gpg
+// doesn't have the secret key.
+func SignGPG(path, key string) (string, error) {
+	sig := path + ".asc"
+	if err := checkGPGKey(key); err != nil {
+		return "", fmt.Errorf("failed to sign %s with gpg: %v", path, err)
+	}
+	args := []string{"--batch", "--yes", "--armor", "--detach-sign", "--output", sig}
+	if key != "" {
+		args = append(args, "--local-user", key)
+	}
+	if err := sh.Run("gpg", append(args, path)...); err != nil {
+		return "", fmt.Errorf("failed to sign %s with gpg: %v", path, err)
+	}
+	return sig, nil
+}
+
+// VerifyGPG verifies the file's gpg signature, at the file's path plus .asc,
+// with the keys in gpg's keyring.
+func VerifyGPG(path string) error {
+	if err := sh.Run("gpg", "--batch", "--verify", path+".asc", path); err != nil {
+		return fmt.Errorf("failed to verify the gpg signature of %s: %v", path, err)
+	}
+	return nil
+}
+
+// SignCosign signs the file with cosign, writing the signature to the file's
+// path plus .sig, and returns its path.  The key is the path or KMS URI of the
+// key to sign with, or empty for keyless signing, which also writes the
+// certificate it's verified with to the file's path plus .pem.  It fails
+// before signing if the key file doesn't exist, or in CI, if there's no
+// COSIGN_PASSWORD for the key or OIDC token for keyless signing.
+func SignCosign(path, key string) (string, error) {
+	sig := path + ".sig"
+	if err := checkCosignKey(key); err != nil {
+		return "", fmt.Errorf("failed to sign %s with cosign: %v", path, err)
+	}
+	args := []string{"sign-blob", "--yes", "--output-signature", sig}
+	if key != "" {
+		args = append(args, "--key", key)
+	} else {
+		args = append(args, "--output-certificate", path+".pem")
+	}
+	if err := sh.Run("cosign", append(args, path)...); err != nil {
+		return "", fmt.Errorf("failed to sign %s with cosign: %v", path, err)
+	}
+	return sig, nil
+}
+
+// VerifyCosign verifies the file's cosign signature, at the file's path plus
+// .sig, with the public key, which is a path or KMS URI.
+func VerifyCosign(path, key string) error {
+	if err := sh.Run("cosign", "verify-blob", "--key", key, "--signature", path+".sig", path); err != nil {
+		return fmt.Errorf("failed to verify the cosign signature of %s: %v", path, err)
+	}
+	return nil
+}
+
+// VerifyCosignKeyless verifies the file's keyless cosign signature, at the
+// file's path plus .sig, with the certificate at its path plus .pem.  The
+// identity and issuer are who the certificate must have been issued to, and
+// by, e.g. for a GitHub Actions workflow,
+// https://github.com/example/app/.github/workflows/release.yml@refs/tags/v1.2.3
+// and https://token.actions.githubusercontent.com.
+func VerifyCosignKeyless(path, identity, issuer string) error {
+	err := sh.Run("cosign", "verify-blob", "--signature", path+".sig", "--certificate", path+".pem",
+		"--certificate-identity", identity, "--certificate-oidc-issuer", issuer, path)
+	if err != nil {
+		return fmt.Errorf("failed to verify the cosign signature of %s: %v", path, err)
+	}
+	return nil
+}
+
+// SignImage signs the container image with cosign, which pushes the signature
+// to the image's registry.  The ref should be by digest, e.g.
+// ghcr.io/example/app@sha256:..., so what's signed is what was built.  The
+// key is like SignCosign's.
+func SignImage(ref, key string) error {
+	if err := checkCosignKey(key); err != nil {
+		return fmt.Errorf("failed to sign %s with cosign: %v", ref, err)
+	}
+	args := []string{"sign", "--yes"}
+	if key != "" {
+		args = append(args, "--key", key)
+	}
+	if err := sh.Run("cosign", append(args, ref)...); err != nil {
+		return fmt.Errorf("failed to sign %s with cosign: %v", ref, err)
+	}
+	return nil
+}
+
+// VerifyImage verifies the container image's cosign signature with the
+// public key, which is a path or KMS URI.
+func VerifyImage(ref, key string) error {
+	if err := sh.Run("cosign", "verify", "--key", key, ref); err != nil {
+		return fmt.Errorf("failed to verify the cosign signature of %s: %v", ref, err)
+	}
+	return nil
+}
+
+// VerifyImageKeyless verifies the container image's keyless cosign
+// signature, which must have been issued to the identity by the issuer, like
+// VerifyCosignKeyless.
+func VerifyImageKeyless(ref, identity, issuer string) error {
+	err := sh.Run("cosign", "verify", "--certificate-identity", identity, "--certificate-oidc-issuer", issuer, ref)
+	if err != nil {
+		return fmt.Errorf("failed to verify the cosign signature of %s: %v", ref, err)
+	}
+	return nil
+}
+
+// SignAll signs each artifact in dir with sign, the way Checksums picks them,
+// so every artifact is signed the same way, and returns the signatures' paths:
+//
+//	sigs, err := release.SignAll("dist", func(path string) (string, error) {
+//		return release.SignCosign(path, "")
+//	})
+func SignAll(dir string, sign func(path string) (string, error)) ([]string, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range infos {
+		if fi.Mode().IsRegular() && !isSignature(fi.Name()) {
+			names = append(names, fi.Name())
+		}
+	}
+	sort.Strings(names)
+	var sigs []string
+	for _, name := range names {
+		sig, err := sign(filepath.Join(dir, name))
+		if err != nil {
+			return sigs, err
+		}
+		sigs = append(sigs, sig)
+	}
+	return sigs, nil
+}
+
+// checkGPGKey returns an error if gpg doesn't have the secret key, or any
+// secret key if it's empty.
+func checkGPGKey(key string) error {
+	args := []string{"--batch", "--list-secret-keys"}
+	if key != "" {
+		args = append(args, key)
+	}
+	out := &bytes.Buffer{}
+	ran, err := sh.Exec(nil, out, ioutil.Discard, "gpg", args...)
+	if !ran {
+		return err
+	}
+	if err != nil || strings.TrimSpace(out.String()) == "" {
+		if key == "" {
+			return fmt.Errorf("gpg has no secret key to sign with")
+		}
+		return fmt.Errorf("gpg has no secret key %q to sign with", key)
+	}
+	return nil
+}
+
+// checkCosignKey returns an error if signing with the key, or keyless if it's
+// empty, is bound to fail, rather than waiting for a password or a browser
+// that no one will see in CI.
+func checkCosignKey(key string) error {
+	ci := os.Getenv("CI") == "true"
+	switch {
+	case key == "":
+		if ci && os.Getenv("SIGSTORE_ID_TOKEN") == "" && os.Getenv("ACTIONS_ID_TOKEN_REQUEST_URL") == "" {
+			return fmt.Errorf("keyless signing in CI needs an OIDC token, from SIGSTORE_ID_TOKEN or, on GitHub Actions, the id-token: write permission")
+		}
+	case strings.Contains(key, "://"):
+		// a KMS key, which cosign checks itself.
+	default:
+		if _, err := os.Stat(key); err != nil {
+			return fmt.Errorf("can't use the key %s: %v", key, err)
+		}
+		if _, ok := os.LookupEnv("COSIGN_PASSWORD"); ci && !ok {
+			return fmt.Errorf("COSIGN_PASSWORD must be set to sign with %s in CI", key)
+		}
+	}
+	return nil
+}
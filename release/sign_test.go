@@ -0,0 +1,197 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"gpg": signer("gpg"), "cosign": signer("cosign")})
+	os.Exit(m.Run())
+}
+
+// signer returns a fake signing tool called name, which writes its args to
+// the file name.args next to it, and signed to the files it's told to write
+// the signature to, or exits with $FAKE_SIGN_EXIT if it's set.
+func signer(name string) faketool.Tool {
+	return func(args []string) int {
+		if code, err := strconv.Atoi(os.Getenv("FAKE_SIGN_EXIT")); err == nil {
+			return code
+		}
+		argsFile := filepath.Join(faketool.Dir(), name+".args")
+		ioutil.WriteFile(argsFile, []byte(strings.Join(args, " ")+"\n"), 0644)
+		for i := 0; i < len(args); i++ {
+			switch args[i] {
+			case "--output", "--output-signature":
+				if i+1 < len(args) {
+					ioutil.WriteFile(args[i+1], []byte("signed\n"), 0644)
+					i++
+				}
+			case "--list-secret-keys":
+				fmt.Println("sec")
+			}
+		}
+		return 0
+	}
+}
+
+// fakeCmd puts the fake signing tool with the name on the PATH, and returns
+// the file with its args, and a func that restores the PATH.
+func fakeCmd(t *testing.T, dir, name string) (string, func()) {
+	bin := filepath.Join(dir, "bin")
+	faketool.Install(t, bin, name)
+	old := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+old)
+	return filepath.Join(bin, name+".args"), func() { os.Setenv("PATH", old) }
+}
+
+func TestSign(t *testing.T) {
+	dir, cleanup := tempdir(t, map[string]string{ChecksumsFile: "sums"})
+	defer cleanup()
+	path := filepath.Join(dir, ChecksumsFile)
+	// keyless signing is refused in CI without a token.
+	if ci, ok := os.LookupEnv("CI"); ok {
+		os.Unsetenv("CI")
+		defer os.Setenv("CI", ci)
+	}
+
+	tests := []struct {
+		cmd      string
+		sign     func(path, key string) (string, error)
+		key      string
+		ext      string
+		expected string
+	}{
+		{"gpg", SignGPG, "", ".asc", "--batch --yes --armor --detach-sign --output {sig} {path}"},
+		{"gpg", SignGPG, "releases@example.com", ".asc", "--batch --yes --armor --detach-sign --output {sig} --local-user releases@example.com {path}"},
+		{"cosign", SignCosign, "", ".sig", "sign-blob --yes --output-signature {sig} --output-certificate {path}.pem {path}"},
+		{"cosign", SignCosign, "gcpkms://keys/release", ".sig", "sign-blob --yes --output-signature {sig} --key gcpkms://keys/release {path}"},
+	}
+	for _, tt := range tests {
+		argsFile, restore := fakeCmd(t, dir, tt.cmd)
+		sig, err := tt.sign(path, tt.key)
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sig != path+tt.ext {
+			t.Fatalf("expected %s but got %s", path+tt.ext, sig)
+		}
+		if b, _ := ioutil.ReadFile(sig); string(b) != "signed\n" {
+			t.Fatalf("expected %s to be written but got %q", sig, b)
+		}
+		b, _ := ioutil.ReadFile(argsFile)
+		expected := strings.NewReplacer("{sig}", sig, "{path}", path).Replace(tt.expected) + "\n"
+		if string(b) != expected {
+			t.Errorf("expected %s to be run with %q but got %q", tt.cmd, expected, b)
+		}
+	}
+}
+
+func TestSignError(t *testing.T) {
+	dir, cleanup := tempdir(t, nil)
+	defer cleanup()
+	old := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", old)
+	_, err := SignGPG("checksums.txt", "")
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to sign checksums.txt with gpg: ") {
+		t.Fatalf("expected a failed to sign error but got %v", err)
+	}
+}
+
+func TestVerifyAndImages(t *testing.T) {
+	dir, cleanup := tempdir(t, map[string]string{"cosign.key": "key"})
+	defer cleanup()
+	key := filepath.Join(dir, "cosign.key")
+	const ref = "ghcr.io/example/app@sha256:abc"
+	const id, issuer = "https://github.com/example/app/.github/workflows/release.yml@refs/tags/v1", "https://token.actions.githubusercontent.com"
+
+	tests := []struct {
+		cmd      string
+		run      func() error
+		expected string
+	}{
+		{"gpg", func() error { return VerifyGPG("app.tar.gz") }, "--batch --verify app.tar.gz.asc app.tar.gz"},
+		{"cosign", func() error { return VerifyCosign("app.tar.gz", "cosign.pub") }, "verify-blob --key cosign.pub --signature app.tar.gz.sig app.tar.gz"},
+		{"cosign", func() error { return VerifyCosignKeyless("app.tar.gz", id, issuer) }, "verify-blob --signature app.tar.gz.sig --certificate app.tar.gz.pem --certificate-identity " + id + " --certificate-oidc-issuer " + issuer + " app.tar.gz"},
+		{"cosign", func() error { return SignImage(ref, key) }, "sign --yes --key " + key + " " + ref},
+		{"cosign", func() error { return SignImage(ref, "") }, "sign --yes " + ref},
+		{"cosign", func() error { return VerifyImage(ref, "cosign.pub") }, "verify --key cosign.pub " + ref},
+		{"cosign", func() error { return VerifyImageKeyless(ref, id, issuer) }, "verify --certificate-identity " + id + " --certificate-oidc-issuer " + issuer + " " + ref},
+	}
+	for _, tt := range tests {
+		argsFile, restore := fakeCmd(t, dir, tt.cmd)
+		err := tt.run()
+		restore()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b, _ := ioutil.ReadFile(argsFile); string(b) != tt.expected+"\n" {
+			t.Errorf("expected %s to be run with %q but got %q", tt.cmd, tt.expected, b)
+		}
+	}
+}
+
+func TestSignAll(t *testing.T) {
+	dir, cleanup := tempdir(t, map[string]string{
+		"app_linux.tar.gz":  "linux",
+		"app_windows.zip":   "windows",
+		"checksums.txt":     "sums",
+		"checksums.txt.sig": "signature",
+	})
+	defer cleanup()
+	var signed []string
+	sigs, err := SignAll(dir, func(path string) (string, error) {
+		signed = append(signed, filepath.Base(path))
+		return path + ".sig", nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(signed, " ") != "app_linux.tar.gz app_windows.zip checksums.txt" || len(sigs) != 3 {
+		t.Fatalf("unexpected files signed %v", signed)
+	}
+}
+
+func TestSignMissingMaterial(t *testing.T) {
+	dir, cleanup := tempdir(t, map[string]string{"cosign.key": "key"})
+	defer cleanup()
+	faketool.Install(t, dir, "gpg")
+	os.Setenv("FAKE_SIGN_EXIT", "2")
+	defer os.Unsetenv("FAKE_SIGN_EXIT")
+	old := os.Getenv("PATH")
+	os.Setenv("PATH", dir)
+	defer os.Setenv("PATH", old)
+	for _, k := range []string{"CI", "COSIGN_PASSWORD", "SIGSTORE_ID_TOKEN", "ACTIONS_ID_TOKEN_REQUEST_URL"} {
+		if v, ok := os.LookupEnv(k); ok {
+			defer os.Setenv(k, v)
+			os.Unsetenv(k)
+		}
+	}
+	os.Setenv("CI", "true")
+	defer os.Unsetenv("CI")
+
+	tests := []struct {
+		run      func() (string, error)
+		expected string
+	}{
+		{func() (string, error) { return SignGPG("app.zip", "releases@example.com") }, `failed to sign app.zip with gpg: gpg has no secret key "releases@example.com" to sign with`},
+		{func() (string, error) { return SignCosign("app.zip", "missing.key") }, "failed to sign app.zip with cosign: can't use the key missing.key: "},
+		{func() (string, error) { return SignCosign("app.zip", filepath.Join(dir, "cosign.key")) }, "failed to sign app.zip with cosign: COSIGN_PASSWORD must be set"},
+		{func() (string, error) { return SignCosign("app.zip", "") }, "failed to sign app.zip with cosign: keyless signing in CI needs an OIDC token"},
+		{func() (string, error) { return "", SignImage("ghcr.io/example/app", "") }, "failed to sign ghcr.io/example/app with cosign: keyless signing in CI needs an OIDC token"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.run(); err == nil || !strings.HasPrefix(err.Error(), tt.expected) {
+			t.Errorf("expected %q but got %v", tt.expected, err)
+		}
+	}
+}
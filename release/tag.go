@@ -0,0 +1,109 @@
+package release
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/magefile/mage/gitx"
+)
+
+// TagOptions are the options for BumpTag.
+type TagOptions struct {
+	// Prerelease is the id of the prerelease for a Prerelease bump, e.g. rc.
+	Prerelease string
+
+	// Remote is the remote to push the tag to.  It defaults to origin.
+	Remote string
+
+	// NoPush keeps the tag from being pushed.
+	NoPush bool
+
+	// DryRun prints what would be done, without tagging or pushing.
+	DryRun bool
+
+	// Stdout is where the dry run is printed.  It defaults to os.Stdout.
+	Stdout io.Writer
+}
+
+// LatestVersion returns the highest semantic version the repository is tagged
+// with, and false if there isn't one.
+func LatestVersion() (Version, bool, error) {
+	tags, err := gitx.Tags()
+	if err != nil {
+		return Version{}, false, err
+	}
+	var latest Version
+	found := false
+	for _, tag := range tags {
+		v, err := ParseVersion(tag)
+		if err != nil {
+			continue
+		}
+		if !found || latest.Less(v) {
+			latest, found = v, true
+		}
+	}
+	return latest, found, nil
+}
+
+// BumpTag tags the commit that's checked out with the version after the latest
+// version tag, or after v0.0.0 if there isn't one, makes it an annotated tag,
+// pushes it, and returns the new version.
+//
+//	// Release tags a patch release.
+//	func (Release) Patch() error {
+//		_, err := release.BumpTag(release.Patch, release.TagOptions{})
+//		return err
+//	}
+func BumpTag(b Bump, opts TagOptions) (Version, error) {
+	latest, found, err := LatestVersion()
+	if err != nil {
+		return Version{}, err
+	}
+	if !found {
+		latest = Version{V: true}
+	}
+	next, err := latest.Next(b, opts.Prerelease)
+	if err != nil {
+		return Version{}, err
+	}
+	tag := next.String()
+	exists, err := gitx.TagExists(tag)
+	if err != nil {
+		return Version{}, err
+	}
+	if exists {
+		return Version{}, fmt.Errorf("tag %s already exists", tag)
+	}
+	if err := createTag(tag, opts); err != nil {
+		return Version{}, err
+	}
+	return next, nil
+}
+
+// createTag makes the annotated tag and pushes it, as the options say.
+func createTag(tag string, opts TagOptions) error {
+	remote := opts.Remote
+	if remote == "" {
+		remote = "origin"
+	}
+	if opts.DryRun {
+		stdout := opts.Stdout
+		if stdout == nil {
+			stdout = os.Stdout
+		}
+		fmt.Fprintf(stdout, "would tag %s\n", tag)
+		if !opts.NoPush {
+			fmt.Fprintf(stdout, "would push %s to %s\n", tag, remote)
+		}
+		return nil
+	}
+	if err := gitx.CreateTag(tag, tag); err != nil {
+		return err
+	}
+	if !opts.NoPush {
+		return gitx.PushTag(remote, tag)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package release
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// repo changes to a new git repository with a commit, and a bare repository as
+// its origin, and returns a func that runs git, and one that changes back and
+// removes them.
+func repo(t *testing.T) (func(args ...string) string, func()) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := func(args ...string) string {
+		args = append([]string{"-c", "user.name=mage", "-c", "user.email=mage@example.com", "-c", "commit.gpgsign=false", "-c", "tag.gpgsign=false"}, args...)
+		out, err := exec.Command("git", args...).CombinedOutput()
+		if err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	origin := filepath.Join(dir, "origin.git")
+	run("init", "-q", "--bare", origin)
+	work := filepath.Join(dir, "work")
+	run("init", "-q", work)
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	run("remote", "add", "origin", origin)
+	run("commit", "-q", "--allow-empty", "-m", "first")
+	return run, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBumpTag(t *testing.T) {
+	run, cleanup := repo(t)
+	defer cleanup()
+	// BumpTag runs git itself, so the identity for the annotated tags has
+	// to be in the repository's config.
+	run("config", "user.name", "mage")
+	run("config", "user.email", "mage@example.com")
+	run("config", "tag.gpgsign", "false")
+
+	if _, found, err := LatestVersion(); err != nil || found {
+		t.Fatalf("expected no version but got %v, %v", found, err)
+	}
+	v, err := BumpTag(Minor, TagOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "v0.1.0" {
+		t.Fatalf("expected v0.1.0 but got %s", v)
+	}
+	if out := run("ls-remote", "--tags", "origin"); !strings.Contains(out, "refs/tags/v0.1.0") {
+		t.Fatalf("expected v0.1.0 to be pushed but got %q", out)
+	}
+	if typ := run("cat-file", "-t", "v0.1.0"); typ != "tag" {
+		t.Fatalf("expected an annotated tag but got a %s", typ)
+	}
+
+	run("tag", "not-a-version")
+	run("tag", "v0.0.9")
+	run("commit", "-q", "--allow-empty", "-m", "second")
+	out := &bytes.Buffer{}
+	v, err = BumpTag(Prerelease, TagOptions{Prerelease: "rc", DryRun: true, Stdout: out, Remote: "upstream"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "v0.1.1-rc.1" {
+		t.Fatalf("expected v0.1.1-rc.1 but got %s", v)
+	}
+	expected := "would tag v0.1.1-rc.1\nwould push v0.1.1-rc.1 to upstream\n"
+	if out.String() != expected {
+		t.Fatalf("expected %q but got %q", expected, out)
+	}
+	if tags := run("tag", "--list", "v0.1.1*"); tags != "" {
+		t.Fatalf("expected the dry run not to tag but got %q", tags)
+	}
+
+	v, err = BumpTag(Patch, TagOptions{NoPush: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.String() != "v0.1.1" {
+		t.Fatalf("expected v0.1.1 but got %s", v)
+	}
+	if out := run("ls-remote", "--tags", "origin"); strings.Contains(out, "v0.1.1") {
+		t.Fatalf("expected v0.1.1 not to be pushed but got %q", out)
+	}
+	latest, found, err := LatestVersion()
+	if err != nil || !found || latest.String() != "v0.1.1" {
+		t.Fatalf("expected the latest version to be v0.1.1 but got %s, %v, %v", latest, found, err)
+	}
+
+	if _, err := BumpTag("huge", TagOptions{}); err == nil {
+		t.Fatal("expected an error for an unknown bump")
+	}
+}
@@ -0,0 +1,169 @@
+package release
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a semantic version, like v1.2.3 or 1.3.0-rc.1.
+type Version struct {
+	Major, Minor, Patch int
+
+	// Prerelease is the part after the -, e.g. rc.1.
+	Prerelease string
+
+	// Build is the build metadata, the part after the +.
+	Build string
+
+	// V is whether it's written with a leading v.
+	V bool
+}
+
+// ParseVersion parses a semantic version, with or without a leading v.
+func ParseVersion(s string) (Version, error) {
+	var v Version
+	rest := s
+	if strings.HasPrefix(rest, "v") {
+		v.V = true
+		rest = rest[1:]
+	}
+	if i := strings.Index(rest, "+"); i >= 0 {
+		v.Build = rest[i+1:]
+		rest = rest[:i]
+		if v.Build == "" {
+			return Version{}, fmt.Errorf("invalid semantic version %q", s)
+		}
+	}
+	if i := strings.Index(rest, "-"); i >= 0 {
+		v.Prerelease = rest[i+1:]
+		rest = rest[:i]
+		if v.Prerelease == "" {
+			return Version{}, fmt.Errorf("invalid semantic version %q", s)
+		}
+	}
+	parts := strings.Split(rest, ".")
+	if len(parts) != 3 {
+		return Version{}, fmt.Errorf("invalid semantic version %q", s)
+	}
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 || (len(p) > 1 && p[0] == '0') || strings.HasPrefix(p, "+") {
+			return Version{}, fmt.Errorf("invalid semantic version %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// String returns the version as it's written in a tag.
+func (v Version) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.V {
+		s = "v" + s
+	}
+	if v.Prerelease != "" {
+		s += "-" + v.Prerelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Less reports whether v comes before other, by semantic version precedence.
+func (v Version) Less(other Version) bool {
+	if v.Major != other.Major {
+		return v.Major < other.Major
+	}
+	if v.Minor != other.Minor {
+		return v.Minor < other.Minor
+	}
+	if v.Patch != other.Patch {
+		return v.Patch < other.Patch
+	}
+	return lessPrerelease(v.Prerelease, other.Prerelease)
+}
+
+// lessPrerelease compares prereleases, where no prerelease comes last, and
+// otherwise each dot separated identifier is compared, numbers numerically.
+func lessPrerelease(a, b string) bool {
+	if a == b || a == "" {
+		return false
+	}
+	if b == "" {
+		return true
+	}
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] == bs[i] {
+			continue
+		}
+		an, aerr := strconv.Atoi(as[i])
+		bn, berr := strconv.Atoi(bs[i])
+		switch {
+		case aerr == nil && berr == nil:
+			return an < bn
+		case aerr == nil:
+			return true
+		case berr == nil:
+			return false
+		default:
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// Bump is the part of a version to increment.
+type Bump string
+
+// The parts of a version that can be bumped.
+const (
+	Major      Bump = "major"
+	Minor      Bump = "minor"
+	Patch      Bump = "patch"
+	Prerelease Bump = "prerelease"
+)
+
+// Next returns the version that comes after v for the bump.  A prerelease of
+// the version being bumped to is released, so the patch bump of 1.2.4-rc.2 is
+// 1.2.4.  For a Prerelease bump, id names the prerelease, e.g. rc: the number
+// of a prerelease with that id is incremented, and otherwise the patch is
+// bumped and it's the first, so 1.2.3 is followed by 1.2.4-rc.1 and that by
+// 1.2.4-rc.2.  The build metadata is dropped.
+func (v Version) Next(b Bump, id string) (Version, error) {
+	pre := v.Prerelease
+	next := Version{Major: v.Major, Minor: v.Minor, Patch: v.Patch, V: v.V}
+	switch b {
+	case Major:
+		if pre == "" || v.Minor != 0 || v.Patch != 0 {
+			next.Major, next.Minor, next.Patch = v.Major+1, 0, 0
+		}
+	case Minor:
+		if pre == "" || v.Patch != 0 {
+			next.Minor, next.Patch = v.Minor+1, 0
+		}
+	case Patch:
+		if pre == "" {
+			next.Patch = v.Patch + 1
+		}
+	case Prerelease:
+		if id == "" {
+			return Version{}, fmt.Errorf("a prerelease bump needs an id, like rc")
+		}
+		if pre == "" {
+			next.Patch = v.Patch + 1
+		}
+		next.Prerelease = id + ".1"
+		if strings.HasPrefix(pre, id+".") {
+			if n, err := strconv.Atoi(pre[len(id)+1:]); err == nil {
+				next.Prerelease = id + "." + strconv.Itoa(n+1)
+			}
+		}
+	default:
+		return Version{}, fmt.Errorf("unknown version bump %q, expected major, minor, patch or prerelease", b)
+	}
+	return next, nil
+}
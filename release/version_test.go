@@ -0,0 +1,99 @@
+package release
+
+import (
+	"sort"
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	valid := map[string]Version{
+		"v1.2.3":             {Major: 1, Minor: 2, Patch: 3, V: true},
+		"0.10.0":             {Minor: 10},
+		"v2.0.0-rc.1":        {Major: 2, Prerelease: "rc.1", V: true},
+		"1.0.0-beta+exp.sha": {Major: 1, Prerelease: "beta", Build: "exp.sha"},
+		"1.0.0+20240101":     {Major: 1, Build: "20240101"},
+	}
+	for s, expected := range valid {
+		v, err := ParseVersion(s)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", s, err)
+			continue
+		}
+		if v != expected {
+			t.Errorf("%s: expected %#v but got %#v", s, expected, v)
+		}
+		if v.String() != s {
+			t.Errorf("%s: expected it to print the same but got %s", s, v)
+		}
+	}
+	for _, s := range []string{"", "v1", "1.2", "1.2.3.4", "v1.02.3", "1.2.x", "1.2.3-", "1.2.3+", "latest", "1.+2.3"} {
+		if _, err := ParseVersion(s); err == nil {
+			t.Errorf("%q: expected an error", s)
+		}
+	}
+}
+
+func TestVersionLess(t *testing.T) {
+	// in order, from the semver spec.
+	order := []string{
+		"1.0.0-alpha", "1.0.0-alpha.1", "1.0.0-alpha.beta", "1.0.0-beta",
+		"1.0.0-beta.2", "1.0.0-beta.11", "1.0.0-rc.1", "1.0.0", "1.0.1", "1.1.0", "2.0.0",
+	}
+	var versions []Version
+	for i := len(order) - 1; i >= 0; i-- {
+		v, err := ParseVersion(order[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		versions = append(versions, v)
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Less(versions[j]) })
+	for i, v := range versions {
+		if v.String() != order[i] {
+			t.Fatalf("expected %s at %d but got %s", order[i], i, v)
+		}
+	}
+}
+
+func TestVersionNext(t *testing.T) {
+	tests := []struct {
+		from     string
+		bump     Bump
+		id       string
+		expected string
+	}{
+		{"v1.2.3", Patch, "", "v1.2.4"},
+		{"v1.2.3", Minor, "", "v1.3.0"},
+		{"v1.2.3", Major, "", "v2.0.0"},
+		{"v1.2.3+build", Patch, "", "v1.2.4"},
+		{"v1.2.3", Prerelease, "rc", "v1.2.4-rc.1"},
+		{"v1.2.4-rc.1", Prerelease, "rc", "v1.2.4-rc.2"},
+		{"v1.2.4-beta.3", Prerelease, "rc", "v1.2.4-rc.1"},
+		{"v1.2.4-rc.2", Patch, "", "v1.2.4"},
+		{"v1.3.0-rc.1", Minor, "", "v1.3.0"},
+		{"v1.2.4-rc.1", Minor, "", "v1.3.0"},
+		{"v2.0.0-rc.1", Major, "", "v2.0.0"},
+		{"v2.1.0-rc.1", Major, "", "v3.0.0"},
+		{"0.0.0", Patch, "", "0.0.1"},
+	}
+	for _, tt := range tests {
+		v, err := ParseVersion(tt.from)
+		if err != nil {
+			t.Fatal(err)
+		}
+		next, err := v.Next(tt.bump, tt.id)
+		if err != nil {
+			t.Errorf("%s %s: unexpected error: %v", tt.from, tt.bump, err)
+			continue
+		}
+		if next.String() != tt.expected {
+			t.Errorf("%s %s: expected %s but got %s", tt.from, tt.bump, tt.expected, next)
+		}
+	}
+	if _, err := (Version{}).Next(Prerelease, ""); err == nil || err.Error() != "a prerelease bump needs an id, like rc" {
+		t.Errorf("expected an error for a prerelease without an id but got %v", err)
+	}
+	if _, err := (Version{}).Next("huge", ""); err == nil || err.Error() != `unknown version bump "huge", expected major, minor, patch or prerelease` {
+		t.Errorf("expected an unknown bump error but got %v", err)
+	}
+}
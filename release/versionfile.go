@@ -0,0 +1,152 @@
+package release
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/magefile/mage/gitx"
+)
+
+// VersionFile is where the project keeps its version, so there's one source
+// of truth for the version stamped into binaries, and the tags of images and
+// charts: a file with only the version in it, like VERSION, or a constant or
+// variable in a go file, like const Version = "v1.2.3".
+type VersionFile struct {
+	// Path is the path of the file.  It defaults to VERSION.
+	Path string
+
+	// Const is the name of the constant or variable in the go file that's set
+	// to the version, e.g. Version.  If it's empty, the whole file is the
+	// version.
+	Const string
+}
+
+func (f VersionFile) path() string {
+	if f.Path == "" {
+		return "VERSION"
+	}
+	return f.Path
+}
+
+// constRE matches the declaration of the constant or variable, with the
+// version in the second group.
+func (f VersionFile) constRE() *regexp.Regexp {
+	return regexp.MustCompile(`(?m)^(\s*(?:(?:const|var)\s+)?` + regexp.QuoteMeta(f.Const) + `(?:\s+string)?\s*=\s*)"([^"\n]*)"`)
+}
+
+// Read reads the version.
+//
+//	v, err := release.VersionFile{}.Read()
+//	if err != nil {
+//		return err
+//	}
+//	ldflags, err := gobuild.LDFlags{Version: v.String()}.Flags()
+func (f VersionFile) Read() (Version, error) {
+	b, err := ioutil.ReadFile(f.path())
+	if err != nil {
+		return Version{}, err
+	}
+	s := strings.TrimSpace(string(b))
+	if f.Const != "" {
+		m := f.constRE().FindStringSubmatch(string(b))
+		if m == nil {
+			return Version{}, fmt.Errorf("%s doesn't set %s to a string", f.path(), f.Const)
+		}
+		s = m[2]
+	}
+	v, err := ParseVersion(s)
+	if err != nil {
+		return Version{}, fmt.Errorf("%s: %v", f.path(), err)
+	}
+	return v, nil
+}
+
+// Write writes the version, leaving the rest of a go file as it is.
+func (f VersionFile) Write(v Version) error {
+	if f.Const == "" {
+		return ioutil.WriteFile(f.path(), []byte(v.String()+"\n"), 0644)
+	}
+	fi, err := os.Stat(f.path())
+	if err != nil {
+		return err
+	}
+	b, err := ioutil.ReadFile(f.path())
+	if err != nil {
+		return err
+	}
+	re := f.constRE()
+	if !re.Match(b) {
+		return fmt.Errorf("%s doesn't set %s to a string", f.path(), f.Const)
+	}
+	loc := re.FindSubmatchIndex(b)
+	out := append(append(append([]byte{}, b[:loc[4]]...), v.String()...), b[loc[5]:]...)
+	return ioutil.WriteFile(f.path(), out, fi.Mode())
+}
+
+// Bump writes the version after the one in the file, as Version.Next makes
+// it, and returns it.
+func (f VersionFile) Bump(b Bump, prerelease string) (Version, error) {
+	v, err := f.Read()
+	if err != nil {
+		return Version{}, err
+	}
+	next, err := v.Next(b, prerelease)
+	if err != nil {
+		return Version{}, err
+	}
+	return next, f.Write(next)
+}
+
+// Sync writes the latest version the repository is tagged with, if it's after
+// the version in the file, e.g. when the tags are made by a release process
+// that doesn't update the file, and returns the version in the file.
+func (f VersionFile) Sync() (Version, error) {
+	v, err := f.Read()
+	if err != nil {
+		return Version{}, err
+	}
+	latest, found, err := LatestVersion()
+	if err != nil || !found || !v.Less(latest) {
+		return v, err
+	}
+	return latest, f.Write(latest)
+}
+
+// Tag tags the commit that's checked out with the version in the file, as an
+// annotated tag, and pushes it, as the options say.  It's an error if the
+// tag exists.
+func (f VersionFile) Tag(opts TagOptions) (Version, error) {
+	v, err := f.Read()
+	if err != nil {
+		return Version{}, err
+	}
+	exists, err := gitx.TagExists(v.String())
+	if err != nil {
+		return Version{}, err
+	}
+	if exists {
+		return Version{}, fmt.Errorf("tag %s already exists, bump the version in %s", v, f.path())
+	}
+	return v, createTag(v.String(), opts)
+}
+
+// ImageTags returns the tags to push an image of the version as: the version
+// without a leading v, e.g. example.com/app:1.2.3, and, unless it's a
+// prerelease, 1.2, 1 and latest, so users can follow a major or minor
+// version.
+func (v Version) ImageTags(image string) []string {
+	exact := v
+	exact.V = false
+	// + isn't allowed in image tags.
+	tags := []string{image + ":" + strings.Replace(exact.String(), "+", "_", 1)}
+	if v.Prerelease != "" {
+		return tags
+	}
+	return append(tags,
+		fmt.Sprintf("%s:%d.%d", image, v.Major, v.Minor),
+		fmt.Sprintf("%s:%d", image, v.Major),
+		image+":latest")
+}
@@ -0,0 +1,108 @@
+package release
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const versionGo = `package version
+
+// Version is the version of the app.
+const Version = "v1.2.3"
+
+var Other = "v9.9.9"
+`
+
+func TestVersionFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	plain := VersionFile{Path: filepath.Join(dir, "VERSION")}
+	ioutil.WriteFile(plain.Path, []byte("1.2.3\n"), 0644)
+	goFile := VersionFile{Path: filepath.Join(dir, "version.go"), Const: "Version"}
+	ioutil.WriteFile(goFile.Path, []byte(versionGo), 0644)
+
+	for _, f := range []VersionFile{plain, goFile} {
+		v, err := f.Read()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Major != 1 || v.Minor != 2 || v.Patch != 3 {
+			t.Fatalf("%s: unexpected %v", f.Path, v)
+		}
+		next, err := f.Bump(Minor, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v, _ := f.Read(); v != next || !strings.HasSuffix(next.String(), "1.3.0") {
+			t.Fatalf("%s: expected %v after the bump, got %v", f.Path, next, v)
+		}
+	}
+	if b, _ := ioutil.ReadFile(plain.Path); string(b) != "1.3.0\n" {
+		t.Fatalf("unexpected %q", b)
+	}
+	if b, _ := ioutil.ReadFile(goFile.Path); string(b) != strings.Replace(versionGo, "v1.2.3", "v1.3.0", 1) {
+		t.Fatalf("expected only the version to change, got\n%s", b)
+	}
+
+	missing := VersionFile{Path: goFile.Path, Const: "Missing"}
+	if _, err := missing.Read(); err == nil || !strings.HasSuffix(err.Error(), "doesn't set Missing to a string") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := missing.Write(Version{Major: 1}); err == nil {
+		t.Fatal("expected an error writing a constant that isn't there")
+	}
+}
+
+func TestVersionFileTags(t *testing.T) {
+	run, cleanup := repo(t)
+	defer cleanup()
+	run("config", "user.name", "mage")
+	run("config", "user.email", "mage@example.com")
+	run("config", "tag.gpgsign", "false")
+	f := VersionFile{}
+	ioutil.WriteFile("VERSION", []byte("v1.0.0\n"), 0644)
+
+	if v, err := f.Sync(); err != nil || v.String() != "v1.0.0" {
+		t.Fatalf("expected the file's version without tags, got %v, %v", v, err)
+	}
+	if _, err := f.Tag(TagOptions{NoPush: true}); err != nil {
+		t.Fatal(err)
+	}
+	if out := run("tag", "--list"); out != "v1.0.0" {
+		t.Fatalf("unexpected tags %q", out)
+	}
+	if _, err := f.Tag(TagOptions{NoPush: true}); err == nil || !strings.HasPrefix(err.Error(), "tag v1.0.0 already exists") {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	run("tag", "v1.1.0")
+	if v, err := f.Sync(); err != nil || v.String() != "v1.1.0" {
+		t.Fatalf("expected the latest tag, got %v, %v", v, err)
+	}
+	if b, _ := ioutil.ReadFile("VERSION"); string(b) != "v1.1.0\n" {
+		t.Fatalf("unexpected %q", b)
+	}
+}
+
+func TestImageTags(t *testing.T) {
+	tests := map[string]string{
+		"v1.2.3":       "app:1.2.3 app:1.2 app:1 app:latest",
+		"2.0.0-rc.1":   "app:2.0.0-rc.1",
+		"1.0.0+build5": "app:1.0.0_build5 app:1.0 app:1 app:latest",
+	}
+	for s, expected := range tests {
+		v, err := ParseVersion(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := strings.Join(v.ImageTags("app"), " "); got != expected {
+			t.Errorf("%s: expected %s, got %s", s, expected, got)
+		}
+	}
+}
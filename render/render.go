@@ -0,0 +1,85 @@
+// Package render renders text/templates to files, e.g. config files,
+// Dockerfiles and manifests made from the version and other build metadata.
+// A file is only written if what's rendered is different from what's in it,
+// so its modtime says when it last changed, and the targets that depend on it
+// aren't rerun for nothing.
+package render
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"text/template"
+
+	"github.com/magefile/mage/fsutil"
+)
+
+// FS is a set of files templates can be read from, like an embed.FS.
+type FS interface {
+	ReadFile(name string) ([]byte, error)
+}
+
+// String renders the template text with the data, usually a map, to the file
+// dst, and reports whether the file changed.  It's an error for the
+// template to use a key that isn't in the map.
+func String(dst, text string, data interface{}) (changed bool, err error) {
+	return render(dst, dst, text, data, 0644)
+}
+
+// File renders the template in the file src with the data to the file dst,
+// with the same permissions, and reports whether dst changed.
+func File(dst, src string, data interface{}) (changed bool, err error) {
+	info, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	b, err := ioutil.ReadFile(src)
+	if err != nil {
+		return false, err
+	}
+	return render(dst, src, string(b), data, info.Mode().Perm())
+}
+
+// FromFS renders the template named name in fsys, e.g. an embed.FS, with the
+// data to the file dst, and reports whether dst changed.
+//
+//	//go:embed templates
+//	var templates embed.FS
+//
+//	func Config() error {
+//		_, err := render.FromFS("deploy/config.yaml", templates, "templates/config.yaml", map[string]interface{}{
+//			"Version": version,
+//		})
+//		return err
+//	}
+func FromFS(dst string, fsys FS, name string, data interface{}) (changed bool, err error) {
+	b, err := fsys.ReadFile(name)
+	if err != nil {
+		return false, err
+	}
+	return render(dst, name, string(b), data, 0644)
+}
+
+func render(dst, name, text string, data interface{}, perm os.FileMode) (bool, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return false, err
+	}
+	buf := &bytes.Buffer{}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return false, err
+	}
+	return write(dst, buf.Bytes(), perm)
+}
+
+// write writes b to the file dst, unless it's already what's in it, and
+// reports whether it did.
+func write(dst string, b []byte, perm os.FileMode) (bool, error) {
+	if old, err := ioutil.ReadFile(dst); err == nil && bytes.Equal(old, b) {
+		return false, nil
+	}
+	if err := fsutil.WriteFile(dst, b, perm); err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,113 @@
+package render
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func TestString(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	dst := filepath.Join(dir, "deploy", "config.yaml")
+	text := "version: {{.Version}}\nreplicas: {{.Replicas}}\n"
+
+	changed, err := String(dst, text, map[string]interface{}{"Version": "v1.2.3", "Replicas": 2})
+	if err != nil || !changed {
+		t.Fatalf("expected the file to be written but got %v, %v", changed, err)
+	}
+	b, _ := ioutil.ReadFile(dst)
+	if string(b) != "version: v1.2.3\nreplicas: 2\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	os.Chtimes(dst, old, old)
+	changed, err = String(dst, text, map[string]interface{}{"Version": "v1.2.3", "Replicas": 2})
+	if err != nil || changed {
+		t.Fatalf("expected the file not to change but got %v, %v", changed, err)
+	}
+	if info, _ := os.Stat(dst); !info.ModTime().Equal(old) {
+		t.Fatalf("expected the modtime to be kept but it's %v", info.ModTime())
+	}
+	changed, err = String(dst, text, map[string]interface{}{"Version": "v1.2.4", "Replicas": 2})
+	if err != nil || !changed {
+		t.Fatalf("expected the file to change but got %v, %v", changed, err)
+	}
+
+	_, err = String(dst, text, map[string]interface{}{"Version": "v1.2.5"})
+	if err == nil || !strings.Contains(err.Error(), `map has no entry for key "Replicas"`) {
+		t.Fatalf("expected an error for the missing key but got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(dst); !strings.HasPrefix(string(b), "version: v1.2.4\n") {
+		t.Fatalf("expected the file to be left alone after the error but got %q", b)
+	}
+	if files, _ := ioutil.ReadDir(filepath.Dir(dst)); len(files) != 1 {
+		t.Fatalf("expected no temporary files to be left but found %d files", len(files))
+	}
+}
+
+func TestFile(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	src := filepath.Join(dir, "entrypoint.sh.tmpl")
+	ioutil.WriteFile(src, []byte("#!/bin/sh\nexec app --version {{.Version}}\n"), 0755)
+	dst := filepath.Join(dir, "entrypoint.sh")
+
+	changed, err := File(dst, src, map[string]string{"Version": "v1"})
+	if err != nil || !changed {
+		t.Fatalf("expected the file to be written but got %v, %v", changed, err)
+	}
+	b, _ := ioutil.ReadFile(dst)
+	if string(b) != "#!/bin/sh\nexec app --version v1\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if info, _ := os.Stat(dst); runtime.GOOS != "windows" && info.Mode().Perm() != 0755 {
+		t.Fatalf("expected the template's permissions but got %v", info.Mode())
+	}
+
+	ioutil.WriteFile(src, []byte("{{.Version"), 0644)
+	if _, err := File(dst, src, nil); err == nil || !strings.Contains(err.Error(), "entrypoint.sh.tmpl") {
+		t.Fatalf("expected a parse error naming the template but got %v", err)
+	}
+}
+
+type mapFS map[string]string
+
+func (m mapFS) ReadFile(name string) ([]byte, error) {
+	s, ok := m[name]
+	if !ok {
+		return nil, os.ErrNotExist
+	}
+	return []byte(s), nil
+}
+
+func TestFromFS(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	fsys := mapFS{"templates/Dockerfile": "FROM golang:{{.Go}}\n"}
+	dst := filepath.Join(dir, "Dockerfile")
+
+	changed, err := FromFS(dst, fsys, "templates/Dockerfile", map[string]string{"Go": "1.22"})
+	if err != nil || !changed {
+		t.Fatalf("expected the file to be written but got %v, %v", changed, err)
+	}
+	if b, _ := ioutil.ReadFile(dst); string(b) != "FROM golang:1.22\n" {
+		t.Fatalf("unexpected contents %q", b)
+	}
+	if _, err := FromFS(dst, fsys, "templates/missing", nil); !os.IsNotExist(err) {
+		t.Fatalf("expected a not exist error but got %v", err)
+	}
+}
@@ -0,0 +1,164 @@
+package sbom
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+)
+
+// tool is how the SBOMs say what made them.
+const tool = "mage"
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name             string            `json:"name"`
+	SPDXID           string            `json:"SPDXID"`
+	VersionInfo      string            `json:"versionInfo,omitempty"`
+	DownloadLocation string            `json:"downloadLocation"`
+	FilesAnalyzed    bool              `json:"filesAnalyzed"`
+	LicenseConcluded string            `json:"licenseConcluded"`
+	LicenseDeclared  string            `json:"licenseDeclared"`
+	CopyrightText    string            `json:"copyrightText"`
+	ExternalRefs     []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+// writeSPDX writes the SPDX SBOM of the binary, where the binary's main
+// module is the package the document describes, which depends on the others.
+func writeSPDX(w io.Writer, bin *binary) error {
+	pkg := func(id string, m module) spdxPackage {
+		p := spdxPackage{
+			Name:             m.path,
+			SPDXID:           id,
+			VersionInfo:      m.version,
+			DownloadLocation: "NOASSERTION",
+			LicenseConcluded: "NOASSERTION",
+			LicenseDeclared:  "NOASSERTION",
+			CopyrightText:    "NOASSERTION",
+		}
+		p.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: m.purl()}}
+		return p
+	}
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              bin.name,
+		DocumentNamespace: fmt.Sprintf("https://spdx.org/spdxdocs/%s-%s", bin.name, bin.id),
+		CreationInfo: spdxCreationInfo{
+			Created:  bin.created.Format(time.RFC3339),
+			Creators: []string{"Tool: " + tool},
+		},
+		Packages: []spdxPackage{pkg("SPDXRef-Package-main", bin.main)},
+		Relationships: []spdxRelationship{
+			{SPDXElementID: "SPDXRef-DOCUMENT", RelationshipType: "DESCRIBES", RelatedSPDXElement: "SPDXRef-Package-main"},
+		},
+	}
+	if bin.goVersion != "" {
+		doc.Packages = append(doc.Packages, pkg("SPDXRef-Package-stdlib", module{path: "stdlib", version: "go" + bin.goVersion}))
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: "SPDXRef-Package-main", RelationshipType: "DEPENDS_ON", RelatedSPDXElement: "SPDXRef-Package-stdlib"})
+	}
+	for i, m := range bin.deps {
+		id := fmt.Sprintf("SPDXRef-Package-%d", i+1)
+		doc.Packages = append(doc.Packages, pkg(id, m))
+		doc.Relationships = append(doc.Relationships, spdxRelationship{SPDXElementID: "SPDXRef-Package-main", RelationshipType: "DEPENDS_ON", RelatedSPDXElement: id})
+	}
+	return writeJSON(w, doc)
+}
+
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	BOMRef  string `json:"bom-ref,omitempty"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	PURL    string `json:"purl,omitempty"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+// writeCycloneDX writes the CycloneDX SBOM of the binary, which is the
+// application the BOM's metadata describes, and its modules are the
+// components.
+func writeCycloneDX(w io.Writer, bin *binary) error {
+	main := cdxComponent{BOMRef: bin.main.purl(), Type: "application", Name: bin.main.path, Version: bin.main.version, PURL: bin.main.purl()}
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + bin.id,
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: bin.created.Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: "application", Name: tool}}},
+			Component: main,
+		},
+		Components: []cdxComponent{},
+	}
+	deps := cdxDependency{Ref: main.BOMRef}
+	mods := bin.deps
+	if bin.goVersion != "" {
+		mods = append([]module{{path: "stdlib", version: "go" + bin.goVersion}}, mods...)
+	}
+	for _, m := range mods {
+		c := cdxComponent{BOMRef: m.purl(), Type: "library", Name: m.path, Version: m.version, PURL: m.purl()}
+		bom.Components = append(bom.Components, c)
+		deps.DependsOn = append(deps.DependsOn, c.BOMRef)
+	}
+	bom.Dependencies = []cdxDependency{deps}
+	return writeJSON(w, bom)
+}
@@ -0,0 +1,233 @@
+// Package sbom writes software bills of materials, SBOMs, for release
+// artifacts, in the SPDX or CycloneDX JSON formats.  The SBOM of a Go binary
+// is made from the modules recorded in it, which go version -m reads, so it
+// needs no other tools, and the SBOM of a container image is made by syft.
+// Each is written next to the artifact, e.g. dist/app.spdx.json for
+// dist/app, to be published with it.
+package sbom
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/fsutil"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Format is an SBOM format.
+type Format string
+
+const (
+	// SPDX is SPDX 2.3 JSON.
+	SPDX Format = "spdx-json"
+	// CycloneDX is CycloneDX 1.5 JSON.
+	CycloneDX Format = "cyclonedx-json"
+)
+
+// ext returns the extension of SBOM files in the format.
+func (f Format) ext() string {
+	if f == CycloneDX {
+		return ".cdx.json"
+	}
+	return ".spdx.json"
+}
+
+// Options are the options for making an SBOM.
+type Options struct {
+	// Format is the format of the SBOM.  It defaults to SPDX.
+	Format Format
+
+	// Output is the file the SBOM is written to.  It defaults to the
+	// artifact's path, or for an image, its reference made into a file name
+	// in the working directory, with .spdx.json or .cdx.json added.
+	Output string
+
+	// Syft is the syft to run.  For images, it defaults to syft on the PATH.
+	// If it's set, it's used for binaries too, instead of go version -m.
+	Syft string
+}
+
+func (o Options) format() (Format, error) {
+	switch o.Format {
+	case "":
+		return SPDX, nil
+	case SPDX, CycloneDX:
+		return o.Format, nil
+	default:
+		return "", fmt.Errorf("unknown SBOM format %q, the formats are: %s, %s", o.Format, SPDX, CycloneDX)
+	}
+}
+
+// Binary writes the SBOM of the Go binary, and returns the path to it.
+func Binary(path string, opts Options) (string, error) {
+	format, err := opts.format()
+	if err != nil {
+		return "", err
+	}
+	out := opts.Output
+	if out == "" {
+		out = strings.TrimSuffix(path, ".exe") + format.ext()
+	}
+	if opts.Syft != "" {
+		return out, syft(opts.Syft, "file:"+path, format, out)
+	}
+	info, err := sh.Output(mg.GoCmd(), "version", "-m", path)
+	if err != nil {
+		return "", err
+	}
+	bin, err := parseBuildInfo(info)
+	if err != nil {
+		return "", fmt.Errorf("can't read the modules in %s: %v", path, err)
+	}
+	bin.name = filepath.Base(strings.TrimSuffix(path, ".exe"))
+	if bin.id, err = fileID(path); err != nil {
+		return "", err
+	}
+	bin.created, err = created()
+	if err != nil {
+		return "", err
+	}
+	var b bytes.Buffer
+	if format == CycloneDX {
+		err = writeCycloneDX(&b, bin)
+	} else {
+		err = writeSPDX(&b, bin)
+	}
+	if err != nil {
+		return "", err
+	}
+	return out, fsutil.WriteFile(out, b.Bytes(), 0644)
+}
+
+// Image writes the SBOM of the container image, e.g.
+// ghcr.io/example/app:v1.2.3, with syft, and returns the path to it.
+func Image(ref string, opts Options) (string, error) {
+	format, err := opts.format()
+	if err != nil {
+		return "", err
+	}
+	out := opts.Output
+	if out == "" {
+		out = strings.NewReplacer("/", "_", ":", "_", "@", "_").Replace(ref) + format.ext()
+	}
+	bin := opts.Syft
+	if bin == "" {
+		bin = "syft"
+	}
+	return out, syft(bin, ref, format, out)
+}
+
+// syft runs syft to write the SBOM of the source to out.
+func syft(bin, source string, format Format, out string) error {
+	if err := os.MkdirAll(filepath.Dir(out), 0755); err != nil {
+		return err
+	}
+	return sh.Run(bin, source, "-q", "-o", string(format)+"="+out)
+}
+
+// binary is what's recorded about how a Go binary was built.
+type binary struct {
+	name      string
+	id        string // a UUID from the binary's contents
+	created   time.Time
+	goVersion string
+	main      module
+	deps      []module
+}
+
+// module is a module that's part of a binary.
+type module struct {
+	path    string
+	version string
+}
+
+// purl returns the package URL of the module.
+func (m module) purl() string {
+	if m.version == "" || m.version == "(devel)" {
+		return "pkg:golang/" + m.path
+	}
+	return "pkg:golang/" + m.path + "@" + m.version
+}
+
+// parseBuildInfo parses the output of go version -m.
+func parseBuildInfo(s string) (*binary, error) {
+	bin := &binary{}
+	scanner := bufio.NewScanner(strings.NewReader(s))
+	for first := true; scanner.Scan(); first = false {
+		if first {
+			// the first line is "path: go1.22.1".
+			if i := strings.LastIndex(scanner.Text(), ": "); i >= 0 {
+				bin.goVersion = strings.TrimPrefix(scanner.Text()[i+2:], "go")
+			}
+			continue
+		}
+		fields := strings.Split(strings.TrimSpace(scanner.Text()), "\t")
+		switch {
+		case fields[0] == "mod" && len(fields) >= 3:
+			bin.main = module{path: fields[1], version: fields[2]}
+		case fields[0] == "dep" && len(fields) >= 3:
+			bin.deps = append(bin.deps, module{path: fields[1], version: fields[2]})
+		case fields[0] == "=>" && len(fields) >= 2 && len(bin.deps) > 0:
+			// the last dep is replaced with another module, or with a
+			// directory, which has no version.
+			last := &bin.deps[len(bin.deps)-1]
+			if strings.HasPrefix(fields[1], ".") || filepath.IsAbs(fields[1]) {
+				last.version = ""
+				continue
+			}
+			*last = module{path: fields[1]}
+			if len(fields) >= 3 {
+				last.version = fields[2]
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if bin.main.path == "" {
+		return nil, fmt.Errorf("it has no module information, it has to be built in module mode")
+	}
+	return bin, nil
+}
+
+// fileID returns a UUID made from the file's sha256, so the same binary
+// always gets the same SBOM.
+func fileID(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	b := h.Sum(nil)[:16]
+	b[6] = b[6]&0x0f | 0x50 // version 5, i.e. made from a hash
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
+
+// created returns when the SBOM was made, which is SOURCE_DATE_EPOCH, if
+// it's set, for reproducible builds.
+func created() (time.Time, error) {
+	s := os.Getenv("SOURCE_DATE_EPOCH")
+	if s == "" {
+		return clock.Now().UTC().Truncate(time.Second), nil
+	}
+	sec, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", s, err)
+	}
+	return time.Unix(sec, 0).UTC(), nil
+}
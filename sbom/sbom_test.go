@@ -0,0 +1,178 @@
+package sbom
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/mg"
+)
+
+const buildInfo = `dist/app: go1.22.1
+	path	example.com/app/cmd/app
+	mod	example.com/app	v1.2.3	h1:abc=
+	dep	github.com/pkg/errors	v0.9.1	h1:def=
+	dep	golang.org/x/sys	v0.1.0	h1:ghi=
+	=>	github.com/fork/sys	v0.1.1	h1:jkl=
+	dep	example.com/local	v0.0.0
+	=>	../local
+	build	-compiler=gc
+	build	GOOS=linux
+`
+
+func TestParseBuildInfo(t *testing.T) {
+	bin, err := parseBuildInfo(buildInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bin.goVersion != "1.22.1" || bin.main != (module{"example.com/app", "v1.2.3"}) {
+		t.Fatalf("unexpected %+v", bin)
+	}
+	expected := []module{{"github.com/pkg/errors", "v0.9.1"}, {"github.com/fork/sys", "v0.1.1"}, {"example.com/local", ""}}
+	if len(bin.deps) != 3 || bin.deps[0] != expected[0] || bin.deps[1] != expected[1] || bin.deps[2] != expected[2] {
+		t.Fatalf("expected %v, got %v", expected, bin.deps)
+	}
+	if p := bin.deps[2].purl(); p != "pkg:golang/example.com/local" {
+		t.Fatalf("unexpected purl %s", p)
+	}
+	if _, err := parseBuildInfo("app: go1.22.1\n"); err == nil {
+		t.Fatal("expected an error for a binary without modules")
+	}
+}
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{
+		// go version -m prints the file info next to it.
+		"go": func([]string) int {
+			faketool.Cat(filepath.Join(faketool.Dir(), "info"))
+			return 0
+		},
+		// syft writes its args to the file its fourth arg, like
+		// spdx-json=FILE, asks it to write.
+		"syft": func(args []string) int {
+			file := args[3][strings.Index(args[3], "=")+1:]
+			ioutil.WriteFile(file, []byte(strings.Join(args, " ")+"\n"), 0644)
+			return 0
+		},
+	})
+	os.Exit(m.Run())
+}
+
+// fakeTools makes MAGEFILE_GOCMD a fake go that prints buildInfo, and puts a
+// fake syft on the PATH.  It returns the directory they're in and a func that
+// puts things back.
+func fakeTools(t *testing.T) (string, func()) {
+	dir, cleanup := faketool.OnPath(t, "syft")
+	ioutil.WriteFile(filepath.Join(dir, "info"), []byte(buildInfo), 0644)
+	os.Setenv(mg.GoCmdEnv, faketool.Install(t, dir, "go"))
+	os.Setenv("SOURCE_DATE_EPOCH", "1700000000")
+	return dir, func() {
+		os.Unsetenv(mg.GoCmdEnv)
+		os.Unsetenv("SOURCE_DATE_EPOCH")
+		cleanup()
+	}
+}
+
+func TestBinarySPDX(t *testing.T) {
+	dir, cleanup := fakeTools(t)
+	defer cleanup()
+	bin := filepath.Join(dir, "dist", "app")
+	os.MkdirAll(filepath.Dir(bin), 0755)
+	ioutil.WriteFile(bin, []byte("binary"), 0755)
+
+	out, err := Binary(bin, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != bin+".spdx.json" {
+		t.Fatalf("unexpected output %s", out)
+	}
+	b, _ := ioutil.ReadFile(out)
+	var doc spdxDocument
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	if doc.SPDXVersion != "SPDX-2.3" || doc.Name != "app" || doc.CreationInfo.Created != "2023-11-14T22:13:20Z" {
+		t.Fatalf("unexpected document %+v", doc)
+	}
+	if len(doc.Packages) != 5 || doc.Packages[0].Name != "example.com/app" || doc.Packages[1].VersionInfo != "go1.22.1" {
+		t.Fatalf("unexpected packages %+v", doc.Packages)
+	}
+	if ref := doc.Packages[3].ExternalRefs[0].ReferenceLocator; ref != "pkg:golang/github.com/fork/sys@v0.1.1" {
+		t.Fatalf("unexpected purl %s", ref)
+	}
+	if len(doc.Relationships) != 5 || doc.Relationships[0].RelationshipType != "DESCRIBES" {
+		t.Fatalf("unexpected relationships %+v", doc.Relationships)
+	}
+
+	// the same binary gets the same SBOM.
+	again, err := Binary(bin, Options{Output: filepath.Join(dir, "again.json")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b2, _ := ioutil.ReadFile(again); string(b2) != string(b) {
+		t.Fatal("expected the same SBOM for the same binary")
+	}
+}
+
+func TestBinaryCycloneDX(t *testing.T) {
+	dir, cleanup := fakeTools(t)
+	defer cleanup()
+	bin := filepath.Join(dir, "app.exe")
+	ioutil.WriteFile(bin, []byte("binary"), 0755)
+
+	out, err := Binary(bin, Options{Format: CycloneDX})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != filepath.Join(dir, "app.cdx.json") {
+		t.Fatalf("unexpected output %s", out)
+	}
+	b, _ := ioutil.ReadFile(out)
+	var bom cdxBOM
+	if err := json.Unmarshal(b, &bom); err != nil {
+		t.Fatal(err)
+	}
+	if bom.BOMFormat != "CycloneDX" || !strings.HasPrefix(bom.SerialNumber, "urn:uuid:") || bom.Metadata.Component.PURL != "pkg:golang/example.com/app@v1.2.3" {
+		t.Fatalf("unexpected BOM %+v", bom)
+	}
+	if len(bom.Components) != 4 || bom.Components[0].Name != "stdlib" || len(bom.Dependencies[0].DependsOn) != 4 {
+		t.Fatalf("unexpected components %+v", bom.Components)
+	}
+
+	if _, err := Binary(bin, Options{Format: "yaml"}); err == nil || !strings.HasPrefix(err.Error(), `unknown SBOM format "yaml"`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestImage(t *testing.T) {
+	dir, cleanup := fakeTools(t)
+	defer cleanup()
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	out, err := Image("ghcr.io/example/app:v1.2.3", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "ghcr.io_example_app_v1.2.3.spdx.json" {
+		t.Fatalf("unexpected output %s", out)
+	}
+	b, _ := ioutil.ReadFile(out)
+	if string(b) != "ghcr.io/example/app:v1.2.3 -q -o spdx-json=ghcr.io_example_app_v1.2.3.spdx.json\n" {
+		t.Fatalf("unexpected syft args %q", b)
+	}
+
+	out, err = Binary("dist/app", Options{Format: CycloneDX, Syft: filepath.Join(dir, "syft")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := ioutil.ReadFile(out); string(b) != "file:dist/app -q -o cyclonedx-json=dist/app.cdx.json\n" {
+		t.Fatalf("unexpected syft args %q", b)
+	}
+}
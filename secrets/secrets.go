@@ -0,0 +1,154 @@
+// Package secrets gets secrets, like passwords and tokens, from the first of
+// a list of places that has them: environment variables, files, the OS
+// keyring, or a command like op read or vault kv get.  The secrets it gets
+// are masked by package sh, so they aren't shown in the commands it logs or
+// their output.
+package secrets
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// Source is a place a secret can be found.
+type Source interface {
+	// Lookup returns the secret, and whether it's there.  It returns an error
+	// if the source can't be checked.
+	Lookup() (value string, ok bool, err error)
+
+	// String describes the source in errors, e.g. env REGISTRY_TOKEN.
+	String() string
+}
+
+// Get returns the secret from the first of the sources that has it, and
+// masks it with sh.Mask.  The name is what the secret is called in errors.
+//
+//	token, err := secrets.Get("registry token",
+//		secrets.Env("REGISTRY_TOKEN"),
+//		secrets.Command("op", "read", "op://build/registry/token"),
+//	)
+func Get(name string, sources ...Source) (string, error) {
+	for _, src := range sources {
+		v, ok, err := src.Lookup()
+		if err != nil {
+			return "", fmt.Errorf("failed to get the %s from %s: %v", name, src, err)
+		}
+		if ok {
+			sh.Mask(v)
+			return v, nil
+		}
+	}
+	desc := make([]string, len(sources))
+	for i, src := range sources {
+		desc[i] = src.String()
+	}
+	return "", fmt.Errorf("the %s isn't set, it's looked for in: %s", name, strings.Join(desc, ", "))
+}
+
+type env string
+
+// Env is the environment variable, if it isn't empty.
+func Env(name string) Source {
+	return env(name)
+}
+
+func (e env) Lookup() (string, bool, error) {
+	v := os.Getenv(string(e))
+	return v, v != "", nil
+}
+
+func (e env) String() string {
+	return "env " + string(e)
+}
+
+type file string
+
+// File is the contents of the file, without the trailing newline, if it
+// exists, e.g. a secret mounted by the CI service.  The path may refer to
+// environment variables like $HOME.
+func File(path string) Source {
+	return file(path)
+}
+
+func (f file) Lookup() (string, bool, error) {
+	b, err := ioutil.ReadFile(os.ExpandEnv(string(f)))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	v := strings.TrimRight(string(b), "\r\n")
+	return v, v != "", nil
+}
+
+func (f file) String() string {
+	return "file " + string(f)
+}
+
+type command []string
+
+// Command is what the command prints, without the trailing newline, e.g.
+// op read op://build/registry/token or vault kv get -field=token
+// secret/registry.  It's an error if the command fails.
+func Command(cmd string, args ...string) Source {
+	return command(append([]string{cmd}, args...))
+}
+
+func (c command) Lookup() (string, bool, error) {
+	out := &bytes.Buffer{}
+	// Exec expands the args in place, so it gets a copy.
+	if _, err := sh.Exec(nil, out, os.Stderr, c[0], append([]string(nil), c[1:]...)...); err != nil {
+		return "", false, err
+	}
+	v := strings.TrimRight(out.String(), "\r\n")
+	return v, v != "", nil
+}
+
+func (c command) String() string {
+	return "command " + strings.Join(c, " ")
+}
+
+type keyring struct {
+	service string
+	account string
+}
+
+// Keyring is the password for the service and account in the OS keyring:
+// the login keychain on macOS, read with security, and the Secret Service,
+// e.g. GNOME Keyring, on Linux, read with secret-tool, where the service and
+// account are the service and account attributes of the secret.  It isn't
+// there on other OSes, or if the tool isn't installed, as on most CI
+// machines, so the next source is checked.
+func Keyring(service, account string) Source {
+	return keyring{service: service, account: account}
+}
+
+func (k keyring) Lookup() (string, bool, error) {
+	var cmd []string
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = []string{"security", "find-generic-password", "-s", k.service, "-a", k.account, "-w"}
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = []string{"secret-tool", "lookup", "service", k.service, "account", k.account}
+	default:
+		return "", false, nil
+	}
+	out := &bytes.Buffer{}
+	// both exit with an error when there's no such secret.
+	if _, err := sh.Exec(nil, out, ioutil.Discard, cmd[0], cmd[1:]...); err != nil {
+		return "", false, nil
+	}
+	v := strings.TrimRight(out.String(), "\r\n")
+	return v, v != "", nil
+}
+
+func (k keyring) String() string {
+	return fmt.Sprintf("keyring %s/%s", k.service, k.account)
+}
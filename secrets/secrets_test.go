@@ -0,0 +1,100 @@
+package secrets
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+	"github.com/magefile/mage/sh"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{
+		// print-secret prints its arg as the secret, or fails if it's fail.
+		"print-secret": func(args []string) int {
+			if args[0] == "fail" {
+				return 2
+			}
+			fmt.Println(args[0])
+			return 0
+		},
+		"security":    fakeKeyring("find-generic-password -s registry -a bot -w"),
+		"secret-tool": fakeKeyring("lookup service registry account bot"),
+	})
+	os.Exit(m.Run())
+}
+
+// fakeKeyring returns a fake keyring tool that prints the secret when it's run
+// with the args, and fails otherwise.
+func fakeKeyring(args string) faketool.Tool {
+	return func(a []string) int {
+		if strings.Join(a, " ") != args {
+			return 1
+		}
+		fmt.Println("from-keyring")
+		return 0
+	}
+}
+
+func TestGet(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	printSecret := faketool.Install(t, dir, "print-secret")
+	path := filepath.Join(dir, "token")
+	ioutil.WriteFile(path, []byte("from-file\n"), 0600)
+	os.Setenv("SECRETS_TEST_TOKEN", "from-env")
+	defer os.Unsetenv("SECRETS_TEST_TOKEN")
+
+	tests := []struct {
+		sources  []Source
+		expected string
+	}{
+		{[]Source{Env("SECRETS_TEST_TOKEN"), File(path)}, "from-env"},
+		{[]Source{Env("SECRETS_TEST_NOT_SET"), File(filepath.Join(dir, "missing")), File(path)}, "from-file"},
+		{[]Source{Keyring("mage-secrets-test", "nobody"), Command(printSecret, "from-command")}, "from-command"},
+	}
+	for _, tt := range tests {
+		v, err := Get("token", tt.sources...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != tt.expected {
+			t.Fatalf("expected %q but got %q", tt.expected, v)
+		}
+		if masked := sh.Masked("token=" + v); masked != "token=***" {
+			t.Fatalf("expected %s to be masked but got %q", v, masked)
+		}
+	}
+
+	_, err = Get("registry token", Env("SECRETS_TEST_NOT_SET"), File("$HOME/no-such-token"))
+	if err == nil || err.Error() != "the registry token isn't set, it's looked for in: env SECRETS_TEST_NOT_SET, file $HOME/no-such-token" {
+		t.Fatalf("unexpected error %v", err)
+	}
+	_, err = Get("token", Command(printSecret, "fail"))
+	if err == nil || err.Error() != `failed to get the token from command `+printSecret+` fail: running "`+printSecret+` fail" failed with exit code 2` {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func TestKeyring(t *testing.T) {
+	if runtime.GOOS != "darwin" && runtime.GOOS != "linux" {
+		t.Skip("the keyring isn't supported on " + runtime.GOOS)
+	}
+	_, cleanup := faketool.OnPath(t, "security", "secret-tool")
+	defer cleanup()
+
+	if v, ok, err := Keyring("registry", "bot").Lookup(); err != nil || !ok || v != "from-keyring" {
+		t.Fatalf("expected the secret from the keyring but got %q, %v, %v", v, ok, err)
+	}
+	if v, ok, err := Keyring("registry", "someone").Lookup(); err != nil || ok {
+		t.Fatalf("expected no secret but got %q, %v, %v", v, ok, err)
+	}
+}
@@ -7,7 +7,10 @@ import (
 	"log"
 	"os"
 	"os/exec"
+	"os/signal"
 	"strings"
+	"sync"
+	"syscall"
 
 	"github.com/magefile/mage/mg"
 )
@@ -118,9 +121,9 @@ func Exec(env map[string]string, stdout, stderr io.Writer, cmd string, args ...s
 		return true, nil
 	}
 	if ran {
-		return ran, mg.Fatalf(code, `running "%s %s" failed with exit code %d`, cmd, strings.Join(args, " "), code)
+		return ran, mg.Fatalf(code, `running "%s %s" failed with exit code %d`, Masked(cmd), Masked(strings.Join(args, " ")), code)
 	}
-	return ran, fmt.Errorf(`failed to run "%s %s: %v"`, cmd, strings.Join(args, " "), err)
+	return ran, fmt.Errorf(`failed to run "%s %s: %v"`, Masked(cmd), Masked(strings.Join(args, " ")), Masked(err.Error()))
 }
 
 func run(env map[string]string, stdout, stderr io.Writer, cmd string, args ...string) (ran bool, code int, err error) {
@@ -129,14 +132,79 @@ func run(env map[string]string, stdout, stderr io.Writer, cmd string, args ...st
 	for k, v := range env {
 		c.Env = append(c.Env, k+"="+v)
 	}
-	c.Stderr = stderr
-	c.Stdout = stdout
+	var flushOut, flushErr func()
+	c.Stdout, flushOut = maskOutput(stdout)
+	c.Stderr, flushErr = maskOutput(stderr)
+	defer flushOut()
+	defer flushErr()
 	c.Stdin = os.Stdin
-	log.Println("exec:", cmd, strings.Join(args, " "))
-	err = c.Run()
+	log.Println("exec:", Masked(cmd), Masked(strings.Join(args, " ")))
+	masked := make([]string, len(args))
+	for i, a := range args {
+		masked[i] = Masked(a)
+	}
+	mg.EmitEvent("exec", map[string]interface{}{"command": Masked(cmd), "args": masked})
+	// with -j, the command counts against the same limit as the targets.
+	done := mg.StartJob()
+	defer done()
+	if Fake != nil {
+		return fake(Cmd{Env: env, Stdout: c.Stdout, Stderr: c.Stderr, Name: cmd, Args: args})
+	}
+	err = c.Start()
+	if err == nil {
+		untrack := track(c)
+		err = c.Wait()
+		untrack()
+	}
 	return CmdRan(err), ExitStatus(err), err
 }
 
+var (
+	runningMu      sync.Mutex
+	runningCmds    = map[*exec.Cmd]bool{}
+	stopForwarding func()
+)
+
+// track passes SIGTERM on to c while it's running, so it can stop cleanly
+// when mage is stopped, rather than being left behind.  Ctrl+C from a terminal
+// already reaches it.  It returns the func to call once c is done.
+func track(c *exec.Cmd) (untrack func()) {
+	runningMu.Lock()
+	defer runningMu.Unlock()
+	if len(runningCmds) == 0 {
+		sigs := make(chan os.Signal, 1)
+		done := make(chan struct{})
+		signal.Notify(sigs, syscall.SIGTERM)
+		go func() {
+			for {
+				select {
+				case sig := <-sigs:
+					runningMu.Lock()
+					for c := range runningCmds {
+						c.Process.Signal(sig)
+					}
+					runningMu.Unlock()
+				case <-done:
+					return
+				}
+			}
+		}()
+		stopForwarding = func() {
+			signal.Stop(sigs)
+			close(done)
+		}
+	}
+	runningCmds[c] = true
+	return func() {
+		runningMu.Lock()
+		defer runningMu.Unlock()
+		delete(runningCmds, c)
+		if len(runningCmds) == 0 {
+			stopForwarding()
+		}
+	}
+}
+
 // CmdRan examines the error to determine if it was generated as a result of a
 // command running via os/exec.Command.  If the error is nil, or the command ran
 // (even if it exited with a non-zero exit code), CmdRan reports true.  If the
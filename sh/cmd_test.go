@@ -3,7 +3,11 @@ package sh
 import (
 	"bytes"
 	"os"
+	"sync"
 	"testing"
+	"time"
+
+	"github.com/magefile/mage/mg"
 )
 
 func TestOutCmd(t *testing.T) {
@@ -70,3 +74,60 @@ func TestAutoExpand(t *testing.T) {
 	}
 
 }
+
+// fakeWork is an Executor whose commands take a little while, and that counts
+// how many jobs run at once, along with the dependencies that call work.
+type fakeWork struct {
+	mu           sync.Mutex
+	running, max int
+}
+
+func (f *fakeWork) work() {
+	f.mu.Lock()
+	f.running++
+	if f.running > f.max {
+		f.max = f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	f.mu.Lock()
+	f.running--
+	f.mu.Unlock()
+}
+
+func (f *fakeWork) Execute(Cmd) (bool, int) {
+	f.work()
+	return true, 0
+}
+
+// TestJobs checks that the commands dependencies run, and the ones run from
+// other goroutines, count against the same limit as the dependencies.
+func TestJobs(t *testing.T) {
+	os.Setenv(mg.JobsEnv, "2")
+	defer os.Unsetenv(mg.JobsEnv)
+	f := &fakeWork{}
+	Fake = f
+	defer func() { Fake = nil }()
+	dep := func() error {
+		f.work()
+		f.work()
+		return Run("build")
+	}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5; i++ {
+			Run("lint")
+		}
+	}()
+	// each is a different dependency, so they all run.
+	a := func() error { return dep() }
+	b := func() error { return dep() }
+	c := func() error { return dep() }
+	d := func() error { return dep() }
+	mg.Deps(a, b, c, d)
+	<-done
+	if f.max != 2 {
+		t.Fatalf("expected at most 2 dependencies and commands to run at once, but %d did", f.max)
+	}
+}
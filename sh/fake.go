@@ -0,0 +1,54 @@
+package sh
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Cmd is a command this package runs, as an Executor is given it.
+type Cmd struct {
+	// Env are the environment variables added to the command's.
+	Env map[string]string
+
+	// Stdout and Stderr are where its output goes, which is masked, as
+	// with Mask.  They're nil if it's discarded.
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Name is the command, and Args its args, with environment variables
+	// expanded.
+	Name string
+	Args []string
+}
+
+// String returns the command and its args, separated by spaces.
+func (c Cmd) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Executor runs commands in place of the OS.
+type Executor interface {
+	// Execute runs the command, writing its output to the Cmd's Stdout
+	// and Stderr, if they're not nil, and reports whether it ran, rather
+	// than not being found, and its exit code.
+	Execute(c Cmd) (ran bool, code int)
+}
+
+// Fake, if it's set, runs the commands this package runs, after they're
+// logged, instead of the OS, e.g. to test a target without running docker.
+// magetest.CommandRecorder is one.
+var Fake Executor
+
+// fake runs the command with Fake, and returns an error like os/exec's if it
+// fails.
+func fake(c Cmd) (ran bool, code int, err error) {
+	ran, code = Fake.Execute(c)
+	switch {
+	case !ran:
+		return false, code, fmt.Errorf("exec: %q: executable file not found", c.Name)
+	case code != 0:
+		return true, code, fmt.Errorf("exit status %d", code)
+	}
+	return true, 0, nil
+}
@@ -4,12 +4,14 @@ import (
 	"fmt"
 	"io"
 	"os"
+
+	"github.com/magefile/mage/fsutil"
 )
 
 // Rm removes the given file or directory even if non-empty. It will not return
 // an error if the target doesn't exist, only if the target cannot be removed.
 func Rm(path string) error {
-	err := os.RemoveAll(path)
+	err := fsutil.Default.RemoveAll(path)
 	if err == nil || os.IsNotExist(err) {
 		return nil
 	}
@@ -18,7 +20,7 @@ func Rm(path string) error {
 
 // Copy robustly copies the source file to the destination, overwriting the destination if necessary.
 func Copy(dst string, src string) error {
-	from, err := os.Open(src)
+	from, err := fsutil.Default.Open(src)
 	if err != nil {
 		return fmt.Errorf(`can't copy %s: %v`, src, err)
 	}
@@ -27,7 +29,7 @@ func Copy(dst string, src string) error {
 	if err != nil {
 		return fmt.Errorf(`can't stat %s: %v`, src, err)
 	}
-	to, err := os.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, finfo.Mode())
+	to, err := fsutil.Default.OpenFile(dst, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, finfo.Mode())
 	if err != nil {
 		return fmt.Errorf(`can't copy to %s: %v`, dst, err)
 	}
@@ -8,6 +8,7 @@ import (
 	"path/filepath"
 	"testing"
 
+	"github.com/magefile/mage/fsutil"
 	"github.com/magefile/mage/sh"
 )
 
@@ -119,3 +120,33 @@ func TestHelpers(t *testing.T) {
 	})
 
 }
+
+func TestHelpersMemFS(t *testing.T) {
+	mem := fsutil.NewMemFS()
+	fsutil.Default = mem
+	defer func() { fsutil.Default = fsutil.OSFS{} }()
+
+	dir := filepath.FromSlash("/work")
+	mem.MkdirAll(dir, 0755)
+	src := filepath.Join(dir, "src.txt")
+	f, _ := mem.OpenFile(src, os.O_CREATE|os.O_WRONLY, 0600)
+	f.Write([]byte("hello"))
+	f.Close()
+
+	dst := filepath.Join(dir, "dst.txt")
+	if err := sh.Copy(dst, src); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := fsutil.ReadFile(mem, dst); err != nil || string(b) != "hello" {
+		t.Fatalf("unexpected contents %q, %v", b, err)
+	}
+	if info, _ := mem.Stat(dst); info.Mode() != 0600 {
+		t.Fatalf("expected the copy to keep the mode 0600 but got %v", info.Mode())
+	}
+	if err := sh.Rm(dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("expected %s to be removed but got %v", dst, err)
+	}
+}
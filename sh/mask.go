@@ -0,0 +1,82 @@
+package sh
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+var (
+	secretsMu sync.RWMutex
+	secrets   []string
+)
+
+// Mask registers secrets, like passwords and tokens, to hide from what's
+// logged about the commands run by this package, the errors when they fail,
+// and what they print to os.Stdout and os.Stderr.  Output that's captured,
+// e.g. by Output, isn't masked, since it's being read rather than shown.
+// Empty strings are ignored.  Once there are secrets to mask, the commands'
+// output goes through a pipe to be masked, so they don't see a terminal.
+func Mask(s ...string) {
+	secretsMu.Lock()
+	defer secretsMu.Unlock()
+	for _, secret := range s {
+		if secret != "" {
+			secrets = append(secrets, secret)
+		}
+	}
+}
+
+// Masked returns s with the secrets registered with Mask replaced by ***.
+func Masked(s string) string {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	for _, secret := range secrets {
+		s = strings.Replace(s, secret, "***", -1)
+	}
+	return s
+}
+
+func masking() bool {
+	secretsMu.RLock()
+	defer secretsMu.RUnlock()
+	return len(secrets) > 0
+}
+
+// maskWriter masks the secrets in what's written to w a line at a time, so a
+// secret split across writes is still masked.
+type maskWriter struct {
+	w   io.Writer
+	buf bytes.Buffer
+}
+
+// maskOutput returns a writer that masks what's written to w, if it's
+// os.Stdout or os.Stderr and there are secrets to mask, and a func to call
+// when the command is done to write the rest.
+func maskOutput(w io.Writer) (io.Writer, func()) {
+	if (w != os.Stdout && w != os.Stderr) || !masking() {
+		return w, func() {}
+	}
+	m := &maskWriter{w: w}
+	return m, m.flush
+}
+
+func (m *maskWriter) Write(p []byte) (int, error) {
+	m.buf.Write(p)
+	if i := bytes.LastIndexByte(m.buf.Bytes(), '\n'); i >= 0 {
+		lines := m.buf.Next(i + 1)
+		if _, err := io.WriteString(m.w, Masked(string(lines))); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (m *maskWriter) flush() {
+	if m.buf.Len() > 0 {
+		io.WriteString(m.w, Masked(m.buf.String()))
+		m.buf.Reset()
+	}
+}
@@ -0,0 +1,71 @@
+package sh
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMask(t *testing.T) {
+	Mask("hunter2", "")
+	defer func() {
+		secretsMu.Lock()
+		secrets = nil
+		secretsMu.Unlock()
+	}()
+
+	logs := &bytes.Buffer{}
+	log.SetOutput(logs)
+	defer log.SetOutput(os.Stderr)
+	f, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	stdout := os.Stdout
+	os.Stdout = f
+	_, err = Exec(nil, os.Stdout, nil, os.Args[0], "-helper", "-stdout", "the password is hunter2", "-stderr", "--password=hunter2", "-exit", "3")
+	os.Stdout = stdout
+	f.Close()
+
+	if err == nil || strings.Contains(err.Error(), "hunter2") || !strings.Contains(err.Error(), "--password=***") {
+		t.Fatalf("expected the error to be masked but got %v", err)
+	}
+	if strings.Contains(logs.String(), "hunter2") || !strings.Contains(logs.String(), "-stdout the password is *** -stderr --password=***") {
+		t.Fatalf("expected the log to be masked but got %q", logs)
+	}
+	b, _ := ioutil.ReadFile(f.Name())
+	if string(b) != "the password is ***\n" {
+		t.Fatalf("expected the output to be masked but got %q", b)
+	}
+
+	out, err := Output(os.Args[0], "-helper", "-stdout", "hunter2")
+	if err != nil || out != "hunter2" {
+		t.Fatalf("expected captured output not to be masked but got %q, %v", out, err)
+	}
+}
+
+func TestMaskWriter(t *testing.T) {
+	Mask("s3cret")
+	defer func() {
+		secretsMu.Lock()
+		secrets = nil
+		secretsMu.Unlock()
+	}()
+	buf := &bytes.Buffer{}
+	m := &maskWriter{w: buf}
+	// the secret is split across writes.
+	for _, s := range []string{"token s3", "cret\nand s3", "cret"} {
+		m.Write([]byte(s))
+	}
+	if buf.String() != "token ***\n" {
+		t.Fatalf("expected only whole lines to be written but got %q", buf)
+	}
+	m.flush()
+	if buf.String() != "token ***\nand ***" {
+		t.Fatalf("expected the rest to be written on flush but got %q", buf)
+	}
+}
@@ -0,0 +1,56 @@
+package sh
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// pathMu keeps targets running in parallel from losing each other's changes
+// to PATH.
+var pathMu sync.Mutex
+
+// PrependPath puts the directories at the front of PATH, in the order given,
+// for the rest of the mage run, so the commands run by this package, and
+// anything else that looks up commands on the PATH, find what's in them
+// first, e.g. tools installed in the project.  Relative directories are made
+// absolute, so changing directory doesn't lose them.  A directory that's
+// already on the PATH is moved to the front rather than added again, so it's
+// safe to call in every target.  The PATH of the shell mage was run from
+// isn't changed.
+func PrependPath(dirs ...string) error {
+	pathMu.Lock()
+	defer pathMu.Unlock()
+	var front []string
+	for _, dir := range dirs {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			return err
+		}
+		if !containsDir(front, abs) {
+			front = append(front, abs)
+		}
+	}
+	list := front
+	for _, dir := range filepath.SplitList(os.Getenv("PATH")) {
+		if dir != "" && !containsDir(list, dir) {
+			list = append(list, dir)
+		}
+	}
+	return os.Setenv("PATH", strings.Join(list, string(os.PathListSeparator)))
+}
+
+// containsDir reports whether the directory is in the list, ignoring case on
+// windows, where paths are case-insensitive.
+func containsDir(list []string, dir string) bool {
+	dir = filepath.Clean(dir)
+	for _, d := range list {
+		d = filepath.Clean(d)
+		if d == dir || (runtime.GOOS == "windows" && strings.EqualFold(d, dir)) {
+			return true
+		}
+	}
+	return false
+}
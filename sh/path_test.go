@@ -0,0 +1,45 @@
+package sh
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPrependPath(t *testing.T) {
+	path := os.Getenv("PATH")
+	defer os.Setenv("PATH", path)
+	dir, err := filepath.Abs("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sep := string(os.PathListSeparator)
+	os.Setenv("PATH", strings.Join([]string{"/usr/bin", dir, "", "/bin"}, sep))
+
+	if err := PrependPath("bin", "tools", "bin"); err != nil {
+		t.Fatal(err)
+	}
+	tools, _ := filepath.Abs("tools")
+	expected := strings.Join([]string{dir, tools, "/usr/bin", "/bin"}, sep)
+	if got := os.Getenv("PATH"); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+
+	// commands run afterwards get it too.
+	out, err := Output(os.Args[0], "-printVar", "PATH")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != expected {
+		t.Fatalf("expected the command to get %q, got %q", expected, out)
+	}
+
+	if err := PrependPath("tools"); err != nil {
+		t.Fatal(err)
+	}
+	expected = strings.Join([]string{tools, dir, "/usr/bin", "/bin"}, sep)
+	if got := os.Getenv("PATH"); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,288 @@
+package sh
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// TestScripts runs the scripts in testdata/script, which test the helpers in
+// this package the way magefiles use them.  Each is a txtar archive: a script,
+// followed by files, each after a "-- name --" line, which are written to a
+// new directory, $WORK, that the script runs in.  The script's lines are
+// commands and their args, which are split on spaces, unless they're in single
+// quotes, and have environment variables expanded.  Lines starting with # are
+// comments.  A command after a ! is expected to fail, or for stdout, stderr
+// and log, not to match.  $HELPER is the test binary, which with -helper
+// prints its -stdout and -stderr and exits with -exit, as in TestMain.  The
+// commands are:
+//
+//	exec cmd args...    runs the command with Exec
+//	env KEY=VALUE       sets an environment variable for the rest of the script
+//	copy dst src        calls Copy
+//	rm path             calls Rm
+//	mask secret         calls Mask
+//	mkdir path          creates the directory
+//	stdout regexp       checks what the last exec printed to stdout
+//	stderr regexp       checks what the last exec printed to stderr
+//	log regexp          checks what's been logged with the log package
+//	exists path         checks that the file exists
+//	cmp file1 file2     checks that the files are the same
+func TestScripts(t *testing.T) {
+	scripts, err := filepath.Glob(filepath.Join("testdata", "script", "*.txtar"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(scripts) == 0 {
+		t.Fatal("no scripts in testdata/script")
+	}
+	for _, script := range scripts {
+		name := strings.TrimSuffix(filepath.Base(script), ".txtar")
+		t.Run(name, func(t *testing.T) {
+			runScript(t, script)
+		})
+	}
+}
+
+// txtarFile is a file in a txtar archive.
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+// parseTxtar returns the comment at the start of a txtar archive, and its
+// files.
+func parseTxtar(data []byte) ([]byte, []txtarFile) {
+	var comment []byte
+	var files []txtarFile
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			line, data = data, nil
+		}
+		trimmed := bytes.TrimSpace(line)
+		if bytes.HasPrefix(trimmed, []byte("-- ")) && bytes.HasSuffix(trimmed, []byte(" --")) && len(trimmed) > 6 {
+			name := string(bytes.TrimSpace(trimmed[3 : len(trimmed)-3]))
+			files = append(files, txtarFile{name: name})
+			continue
+		}
+		if len(files) == 0 {
+			comment = append(comment, line...)
+		} else {
+			f := &files[len(files)-1]
+			f.data = append(f.data, line...)
+		}
+	}
+	return comment, files
+}
+
+// splitArgs splits the line on spaces, except in single quotes.
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg, quoted := false, false
+	for _, r := range line {
+		switch {
+		case r == '\'':
+			quoted = !quoted
+			inArg = true
+		case (r == ' ' || r == '\t') && !quoted:
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+	if quoted {
+		return nil, fmt.Errorf("unterminated quote")
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// scriptState is what a script's commands change.
+type scriptState struct {
+	stdout, stderr string
+	logs           *bytes.Buffer
+	vars           map[string]string
+
+	// env are the values of the environment variables the script set from
+	// before it did, or nil if they weren't set.
+	env map[string]*string
+}
+
+func (s *scriptState) getenv(k string) string {
+	if v, ok := s.vars[k]; ok {
+		return v
+	}
+	return os.Getenv(k)
+}
+
+func runScript(t *testing.T, path string) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, files := parseTxtar(data)
+
+	work, err := ioutil.TempDir("", "sh-script")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(work)
+	for _, f := range files {
+		name := filepath.Join(work, filepath.FromSlash(f.name))
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name, f.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(work); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	s := &scriptState{
+		logs: &bytes.Buffer{},
+		vars: map[string]string{"WORK": work, "HELPER": os.Args[0]},
+		env:  map[string]*string{},
+	}
+	log.SetOutput(s.logs)
+	defer log.SetOutput(os.Stderr)
+	defer func() {
+		secretsMu.Lock()
+		secrets = nil
+		secretsMu.Unlock()
+	}()
+	defer func() {
+		for k, v := range s.env {
+			if v == nil {
+				os.Unsetenv(k)
+			} else {
+				os.Setenv(k, *v)
+			}
+		}
+	}()
+
+	for i, line := range strings.Split(string(script), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		args, err := splitArgs(line)
+		if err != nil {
+			t.Fatalf("%s:%d: %v", path, i+1, err)
+		}
+		for j := range args {
+			args[j] = os.Expand(args[j], s.getenv)
+		}
+		neg := args[0] == "!"
+		if neg {
+			args = args[1:]
+		}
+		if len(args) == 0 {
+			t.Fatalf("%s:%d: no command after !", path, i+1)
+		}
+		if err := s.run(args[0], args[1:], neg); err != nil {
+			t.Fatalf("%s:%d: %s: %v", path, i+1, line, err)
+		}
+	}
+}
+
+// run runs the command, and returns an error if it didn't do what's
+// expected.
+func (s *scriptState) run(cmd string, args []string, neg bool) error {
+	nargs := map[string]int{
+		"env": 1, "copy": 2, "rm": 1, "mask": 1, "mkdir": 1,
+		"stdout": 1, "stderr": 1, "log": 1, "exists": 1, "cmp": 2,
+	}
+	if n, ok := nargs[cmd]; ok && len(args) != n {
+		return fmt.Errorf("expected %d args but got %d", n, len(args))
+	}
+	var err error
+	switch cmd {
+	case "exec":
+		if len(args) == 0 {
+			return fmt.Errorf("no command to exec")
+		}
+		var stdout, stderr bytes.Buffer
+		_, err = Exec(nil, &stdout, &stderr, args[0], args[1:]...)
+		s.stdout, s.stderr = stdout.String(), stderr.String()
+	case "env":
+		kv := strings.SplitN(args[0], "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("expected KEY=VALUE but got %q", args[0])
+		}
+		if _, ok := s.env[kv[0]]; !ok {
+			if old, ok := os.LookupEnv(kv[0]); ok {
+				s.env[kv[0]] = &old
+			} else {
+				s.env[kv[0]] = nil
+			}
+		}
+		err = os.Setenv(kv[0], kv[1])
+	case "copy":
+		err = Copy(args[0], args[1])
+	case "rm":
+		err = Rm(args[0])
+	case "mask":
+		Mask(args[0])
+	case "mkdir":
+		err = os.MkdirAll(args[0], 0755)
+	case "stdout", "stderr", "log":
+		out := map[string]string{"stdout": s.stdout, "stderr": s.stderr, "log": s.logs.String()}[cmd]
+		re, rerr := regexp.Compile(`(?m)` + args[0])
+		if rerr != nil {
+			return rerr
+		}
+		switch {
+		case neg && re.MatchString(out):
+			return fmt.Errorf("expected no match for %s in:\n%s", args[0], out)
+		case !neg && !re.MatchString(out):
+			return fmt.Errorf("no match for %s in:\n%s", args[0], out)
+		}
+		return nil
+	case "exists":
+		_, err = os.Stat(args[0])
+	case "cmp":
+		a, aerr := ioutil.ReadFile(args[0])
+		b, berr := ioutil.ReadFile(args[1])
+		switch {
+		case aerr != nil:
+			err = aerr
+		case berr != nil:
+			err = berr
+		case !bytes.Equal(a, b):
+			err = fmt.Errorf("%s and %s differ:\n%s\n%s", args[0], args[1], a, b)
+		}
+	default:
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+	switch {
+	case neg && err == nil:
+		return fmt.Errorf("expected it to fail")
+	case !neg && err != nil:
+		return err
+	}
+	return nil
+}
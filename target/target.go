@@ -3,8 +3,9 @@ package target
 import (
 	"errors"
 	"os"
-	"path/filepath"
 	"time"
+
+	"github.com/magefile/mage/fsutil"
 )
 
 // expand takes a collection of sources as strings, and for each one, it expands
@@ -24,7 +25,7 @@ func expand(sources []string) []string {
 // exist, it always returns true and nil. It's an error if any of the sources
 // don't exist.
 func Path(dst string, sources ...string) (bool, error) {
-	stat, err := os.Stat(os.ExpandEnv(dst))
+	stat, err := fsutil.Default.Stat(os.ExpandEnv(dst))
 	if os.IsNotExist(err) {
 		return true, nil
 	}
@@ -51,7 +52,7 @@ func Path(dst string, sources ...string) (bool, error) {
 // the call to Path. It is an error for any glob to return an empty result.
 func Glob(dst string, globs ...string) (bool, error) {
 	for _, g := range globs {
-		files, err := filepath.Glob(g)
+		files, err := fsutil.Glob(fsutil.Default, g)
 		if err != nil {
 			return false, err
 		}
@@ -77,7 +78,7 @@ func Glob(dst string, globs ...string) (bool, error) {
 // doesn't exist, it always returns true and nil.  It's an error if any of the
 // sources don't exist.
 func Dir(dst string, sources ...string) (bool, error) {
-	stat, err := os.Stat(os.ExpandEnv(dst))
+	stat, err := fsutil.Default.Stat(os.ExpandEnv(dst))
 	if os.IsNotExist(err) {
 		return true, nil
 	}
@@ -107,7 +108,7 @@ func Dir(dst string, sources ...string) (bool, error) {
 
 func calDirModTimeRecursive(name string, dir os.FileInfo) (time.Time, error) {
 	t := dir.ModTime()
-	ferr := filepath.Walk(name, func(path string, info os.FileInfo, err error) error {
+	ferr := fsutil.Walk(fsutil.Default, name, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -136,7 +137,7 @@ type depTargets struct {
 func loadTargets(targets []string) (*depTargets, error) {
 	d := &depTargets{}
 	for _, v := range targets {
-		stat, err := os.Stat(v)
+		stat, err := fsutil.Default.Stat(v)
 		if err != nil {
 			return nil, err
 		}
@@ -6,6 +6,8 @@ import (
 	"path/filepath"
 	"testing"
 	"time"
+
+	"github.com/magefile/mage/fsutil"
 )
 
 func TestPathMissingDest(t *testing.T) {
@@ -441,3 +443,40 @@ func TestDir(t *testing.T) {
 		})
 	}
 }
+
+func TestMemFS(t *testing.T) {
+	mem := fsutil.NewMemFS()
+	fsutil.Default = mem
+	defer func() { fsutil.Default = fsutil.OSFS{} }()
+
+	base := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	mem.MkdirAll(filepath.FromSlash("/proj/src/pkg"), 0755)
+	mem.MkdirAll(filepath.FromSlash("/proj/bin"), 0755)
+	for i, name := range []string{"/proj/bin/app", "/proj/src/main.go", "/proj/src/pkg/lib.go"} {
+		name = filepath.FromSlash(name)
+		f, _ := mem.OpenFile(name, os.O_CREATE|os.O_WRONLY, 0644)
+		f.Close()
+		mtime := base.Add(time.Duration(i) * time.Hour)
+		mem.Chtimes(name, mtime, mtime)
+	}
+	for _, dir := range []string{"/proj/src", "/proj/src/pkg", "/proj/bin"} {
+		mem.Chtimes(filepath.FromSlash(dir), base, base)
+	}
+	bin := filepath.FromSlash("/proj/bin/app")
+
+	if rebuild, err := Path(bin, filepath.FromSlash("/proj/src")); err != nil || rebuild {
+		t.Fatalf("expected the directory itself to be older but got %v, %v", rebuild, err)
+	}
+	if rebuild, err := Dir(bin, filepath.FromSlash("/proj/src")); err != nil || !rebuild {
+		t.Fatalf("expected a newer file in the directory to rebuild but got %v, %v", rebuild, err)
+	}
+	if rebuild, err := Glob(bin, filepath.FromSlash("/proj/src/*.go")); err != nil || !rebuild {
+		t.Fatalf("expected the newer glob match to rebuild but got %v, %v", rebuild, err)
+	}
+	if _, err := Glob(bin, filepath.FromSlash("/proj/src/*.c")); err == nil {
+		t.Fatal("expected a glob that matches nothing to fail")
+	}
+	if rebuild, err := Path(filepath.FromSlash("/proj/bin/missing"), filepath.FromSlash("/proj/src/main.go")); err != nil || !rebuild {
+		t.Fatalf("expected a missing target to rebuild but got %v, %v", rebuild, err)
+	}
+}
@@ -0,0 +1,244 @@
+package testx
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/gitx"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+	"github.com/magefile/mage/tools"
+)
+
+// BenchstatPackage is the package benchstat is installed from, when it's in
+// the tools manifest.
+const BenchstatPackage = "golang.org/x/perf/cmd/benchstat"
+
+// BenchOptions are the options for CompareBench.
+type BenchOptions struct {
+	// Base is the ref to compare with, e.g. origin/main.
+	Base string
+
+	// Packages are the packages to benchmark.  They default to ./...
+	Packages []string
+
+	// Bench is the regexp of the benchmarks to run.  It defaults to all of
+	// them.
+	Bench string
+
+	// Count is how many times each benchmark is run, for benchstat to tell
+	// a change from noise.  It defaults to 6.
+	Count int
+
+	// Flags are other flags for go test, e.g. -benchtime=100ms.
+	Flags []string
+
+	// Threshold is how much worse, in percent, a benchmark can get before
+	// it's a regression.  It defaults to 10.
+	Threshold float64
+
+	// Warn prints the regressions, rather than failing.
+	Warn bool
+
+	// Benchstat is the benchstat to run.  It defaults to the one in the
+	// tools manifest, if it's there, or else benchstat on the PATH.
+	Benchstat string
+
+	// Stdout is where benchstat's comparison is printed.  It defaults to
+	// os.Stdout.
+	Stdout io.Writer
+}
+
+// Regression is a benchmark that got worse.
+type Regression struct {
+	// Benchmark is the benchmark's name, e.g. Parse-8.
+	Benchmark string
+
+	// Unit is what got worse, e.g. sec/op or allocs/op.
+	Unit string
+
+	// Change is how much it changed, in percent.
+	Change float64
+}
+
+func (r Regression) String() string {
+	return fmt.Sprintf("%s %s %+.2f%%", r.Benchmark, r.Unit, r.Change)
+}
+
+// CompareBench runs the benchmarks in the working tree, and in a worktree of
+// the base ref, compares them with benchstat, and prints the comparison.  It
+// returns the benchmarks that got worse than the threshold, and an error if
+// there are any, unless it's only to warn about them.
+//
+//	// BenchCheck fails if a benchmark got more than 10% slower than on main.
+//	func BenchCheck() error {
+//		_, err := testx.CompareBench(testx.BenchOptions{Base: "origin/main", Flags: []string{"-benchtime=100ms"}})
+//		return err
+//	}
+func CompareBench(opts BenchOptions) ([]Regression, error) {
+	if opts.Base == "" {
+		return nil, fmt.Errorf("there's no base ref to compare the benchmarks with")
+	}
+	benchstat, err := benchstatPath(opts.Benchstat)
+	if err != nil {
+		return nil, err
+	}
+	tmp, err := ioutil.TempDir("", "mage-bench-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	base, head := filepath.Join(tmp, "base.txt"), filepath.Join(tmp, "head.txt")
+	if err := runBench(opts, "", head); err != nil {
+		return nil, err
+	}
+	// the benchmarks run in the same directory of the worktree as the
+	// working directory is of the repository.
+	prefix, err := sh.Output("git", "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	worktree := filepath.Join(tmp, "base")
+	if err := gitx.AddWorktree(worktree, opts.Base); err != nil {
+		return nil, err
+	}
+	err = runBench(opts, filepath.Join(worktree, filepath.FromSlash(prefix)), base)
+	if rmErr := gitx.RemoveWorktree(worktree); err == nil {
+		err = rmErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to run the benchmarks of %s: %v", opts.Base, err)
+	}
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	if _, err := sh.Exec(nil, stdout, os.Stderr, benchstat, base, head); err != nil {
+		return nil, err
+	}
+	out := &bytes.Buffer{}
+	if _, err := sh.Exec(nil, out, os.Stderr, benchstat, "-format", "csv", base, head); err != nil {
+		return nil, err
+	}
+	threshold := opts.Threshold
+	if threshold == 0 {
+		threshold = 10
+	}
+	regressions, err := parseBenchstat(out.String(), threshold)
+	if err != nil || len(regressions) == 0 {
+		return regressions, err
+	}
+	lines := make([]string, len(regressions))
+	for i, r := range regressions {
+		lines[i] = "  " + r.String()
+	}
+	msg := fmt.Sprintf("%d benchmarks got more than %g%% worse than %s:\n%s", len(regressions), threshold, opts.Base, strings.Join(lines, "\n"))
+	if opts.Warn {
+		fmt.Fprintf(stdout, "warning: %s\n", msg)
+		return regressions, nil
+	}
+	return regressions, fmt.Errorf("%s", msg)
+}
+
+// benchstatPath returns the benchstat to run.
+func benchstatPath(bin string) (string, error) {
+	if bin != "" {
+		return bin, nil
+	}
+	t, ok, err := tools.Lookup(BenchstatPackage)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return t.Ensure()
+	}
+	if _, err := exec.LookPath("benchstat"); err != nil {
+		return "", fmt.Errorf("benchstat isn't installed, add %s to the tools manifest", BenchstatPackage)
+	}
+	return "benchstat", nil
+}
+
+// runBench runs the benchmarks in dir, or the working directory, and writes
+// their results to out.
+func runBench(opts BenchOptions, dir, out string) error {
+	bench := opts.Bench
+	if bench == "" {
+		bench = "."
+	}
+	count := opts.Count
+	if count == 0 {
+		count = 6
+	}
+	args := append([]string{"test", "-run", "^$", "-bench", bench, "-count", strconv.Itoa(count)}, opts.Flags...)
+	pkgs := opts.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	f, err := os.Create(out)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// sh can't run a command in another directory.
+	cmd := exec.Command(mg.GoCmd(), append(args, pkgs...)...)
+	cmd.Dir = dir
+	cmd.Stdout = f
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("go test -bench failed: %v", err)
+	}
+	return f.Close()
+}
+
+// parseBenchstat returns the benchmarks in benchstat's csv output that got
+// worse by more than the threshold, in percent.  Units per op, like sec/op,
+// are worse when they go up, and units per second, like B/s, are worse when
+// they go down.  Changes benchstat doesn't think are significant are ~.
+func parseBenchstat(s string, threshold float64) ([]Regression, error) {
+	r := csv.NewReader(strings.NewReader(s))
+	r.FieldsPerRecord = -1
+	var regressions []Regression
+	unit, delta := "", -1
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return regressions, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read benchstat's output: %v", err)
+		}
+		if len(rec) < 2 {
+			// the goos: and pkg: lines.
+			continue
+		}
+		for i, cell := range rec {
+			if cell == "vs base" {
+				unit, delta = rec[1], i
+			}
+		}
+		if delta < 0 || len(rec) <= delta || rec[0] == "" || rec[0] == "geomean" || !strings.HasSuffix(rec[delta], "%") {
+			continue
+		}
+		change, err := strconv.ParseFloat(strings.TrimSuffix(rec[delta], "%"), 64)
+		if err != nil {
+			continue
+		}
+		worse := change
+		if strings.HasSuffix(unit, "/s") {
+			worse = -change
+		}
+		if worse > threshold {
+			regressions = append(regressions, Regression{Benchmark: rec[0], Unit: unit, Change: change})
+		}
+	}
+}
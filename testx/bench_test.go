@@ -0,0 +1,132 @@
+package testx
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+const benchstatCSV = `goos: linux
+goarch: amd64
+pkg: example.com/app
+,base.txt,,head.txt,,,
+,sec/op,CI,sec/op,CI,vs base,P
+Parse-8,1.000e-06,2%,1.200e-06,1%,+20.00%,p=0.002 n=6
+Format-8,2.000e-06,2%,2.010e-06,1%,~,p=0.400 n=6
+Print-8,2.000e-06,2%,1.000e-06,1%,-50.00%,p=0.002 n=6
+geomean,1.4e-06,,1.55e-06,,+10.54%,
+
+,base.txt,,head.txt,,,
+,B/s,CI,B/s,CI,vs base,P
+Copy-8,100.0Mi,1%,80.0Mi,1%,-20.00%,p=0.002 n=6
+`
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"benchstat": benchstat})
+	os.Exit(m.Run())
+}
+
+// benchstat stands in for benchstat.  With -format, it prints the file
+// benchstat.csv next to it, and otherwise how many results for BenchmarkSum
+// there are in the files it's given.
+func benchstat(args []string) int {
+	if len(args) > 0 && args[0] == "-format" {
+		faketool.Cat(filepath.Join(faketool.Dir(), "benchstat.csv"))
+		return 0
+	}
+	n := 0
+	for _, file := range args {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return 1
+		}
+		for _, line := range strings.Split(string(b), "\n") {
+			if strings.HasPrefix(line, "BenchmarkSum") {
+				n++
+			}
+		}
+	}
+	fmt.Println(n)
+	return 0
+}
+
+func TestParseBenchstat(t *testing.T) {
+	regressions, err := parseBenchstat(benchstatCSV, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, r := range regressions {
+		got = append(got, r.String())
+	}
+	expected := "Parse-8 sec/op +20.00%, Copy-8 B/s -20.00%"
+	if strings.Join(got, ", ") != expected {
+		t.Fatalf("expected %s, got %s", expected, strings.Join(got, ", "))
+	}
+	if regressions, _ := parseBenchstat(benchstatCSV, 25); len(regressions) != 0 {
+		t.Fatalf("expected no regressions over 25%%, got %v", regressions)
+	}
+}
+
+func TestBenchstatPathManifestError(t *testing.T) {
+	defer project(t, map[string]string{"tools.yaml": "benchstat " + BenchstatPackage + "\n"})()
+	if _, err := benchstatPath(""); err == nil || !strings.HasPrefix(err.Error(), "error reading tools.yaml") {
+		t.Fatalf("expected an error reading the tools manifest, got %v", err)
+	}
+}
+
+func TestCompareBench(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	defer project(t, map[string]string{
+		"app/app.go":      "package app\n\nfunc Sum(n int) (s int) {\n\tfor i := 0; i < n; i++ {\n\t\ts += i\n\t}\n\treturn s\n}\n",
+		"app/app_test.go": "package app\n\nimport \"testing\"\n\nfunc BenchmarkSum(b *testing.B) {\n\tfor i := 0; i < b.N; i++ {\n\t\tSum(100)\n\t}\n}\n",
+	})()
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=mage", "-c", "user.email=mage@example.com", "-c", "commit.gpgsign=false", "commit", "-q", "-m", "first"},
+	} {
+		if out, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, out)
+		}
+	}
+	dir, _ := os.Getwd()
+	ioutil.WriteFile("benchstat.csv", []byte(benchstatCSV), 0644)
+	benchstat := faketool.Install(t, dir, "benchstat")
+
+	out := &bytes.Buffer{}
+	opts := BenchOptions{Base: "HEAD", Count: 2, Flags: []string{"-benchtime=1x"}, Benchstat: benchstat, Stdout: out}
+	regressions, err := CompareBench(opts)
+	if err == nil || !strings.HasPrefix(err.Error(), "2 benchmarks got more than 10% worse than HEAD:\n  Parse-8 sec/op +20.00%") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if len(regressions) != 2 {
+		t.Fatalf("unexpected regressions %v", regressions)
+	}
+	// benchstat was given both runs of the benchmark, in the base and the
+	// working tree.
+	if strings.TrimSpace(out.String()) != "4" {
+		t.Fatalf("expected 4 benchmark results, got %q", out)
+	}
+	if b, _ := exec.Command("git", "worktree", "list").Output(); strings.Count(string(b), "\n") != 1 {
+		t.Fatalf("expected the worktree to be removed, got\n%s", b)
+	}
+
+	out.Reset()
+	opts.Warn = true
+	if _, err := CompareBench(opts); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "warning: 2 benchmarks got more than 10% worse") {
+		t.Fatalf("expected a warning, got %q", out)
+	}
+}
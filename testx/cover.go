@@ -0,0 +1,233 @@
+package testx
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// profile is a coverage profile.
+type profile struct {
+	mode string
+	// blocks are the blocks of code, by file:start,end, in the order
+	// they're first seen.
+	blocks map[string]*block
+	order  []string
+}
+
+// block is a block of code in a coverage profile.
+type block struct {
+	file               string
+	startLine, endLine int
+	stmts, count       int
+}
+
+// MergeProfiles merges coverage profiles, e.g. from the unit and integration
+// tests, into one, and writes it to out.  The profiles must have the same
+// mode.  A block is covered if it's covered in any of them, and its counts
+// are added up for the count and atomic modes.
+func MergeProfiles(out string, profiles ...string) error {
+	merged := &profile{blocks: map[string]*block{}}
+	for _, path := range profiles {
+		p, err := readProfile(path)
+		if err != nil {
+			return err
+		}
+		if merged.mode == "" {
+			merged.mode = p.mode
+		} else if p.mode != merged.mode {
+			return fmt.Errorf("can't merge %s, its mode is %s, not %s", path, p.mode, merged.mode)
+		}
+		for _, key := range p.order {
+			b := p.blocks[key]
+			m, ok := merged.blocks[key]
+			switch {
+			case !ok:
+				c := *b
+				merged.blocks[key] = &c
+				merged.order = append(merged.order, key)
+			case merged.mode == "set":
+				if b.count > 0 {
+					m.count = 1
+				}
+			default:
+				m.count += b.count
+			}
+		}
+	}
+	return merged.write(out)
+}
+
+// readProfile reads a coverage profile.
+func readProfile(path string) (*profile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	p := &profile{blocks: map[string]*block{}}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" {
+			continue
+		}
+		if strings.HasPrefix(s, "mode: ") {
+			if p.mode == "" {
+				p.mode = strings.TrimPrefix(s, "mode: ")
+			}
+			continue
+		}
+		// file.go:start.col,end.col stmts count
+		fields := strings.Fields(s)
+		colon := strings.LastIndex(s, ":")
+		if len(fields) != 3 || colon < 0 || p.mode == "" {
+			return nil, fmt.Errorf("%s:%d: invalid coverage profile line %q", path, line, s)
+		}
+		key := fields[0]
+		rng := strings.Split(key[strings.LastIndex(key, ":")+1:], ",")
+		b := &block{file: key[:strings.LastIndex(key, ":")]}
+		var errs [4]error
+		if len(rng) == 2 {
+			b.startLine, errs[0] = strconv.Atoi(strings.SplitN(rng[0], ".", 2)[0])
+			b.endLine, errs[1] = strconv.Atoi(strings.SplitN(rng[1], ".", 2)[0])
+		} else {
+			errs[0] = fmt.Errorf("bad range")
+		}
+		b.stmts, errs[2] = strconv.Atoi(fields[1])
+		b.count, errs[3] = strconv.Atoi(fields[2])
+		for _, err := range errs {
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid coverage profile line %q", path, line, s)
+			}
+		}
+		// a profile from several packages can list a block more than once.
+		if m, ok := p.blocks[key]; ok {
+			if p.mode == "set" {
+				if b.count > 0 {
+					m.count = 1
+				}
+			} else {
+				m.count += b.count
+			}
+			continue
+		}
+		p.blocks[key] = b
+		p.order = append(p.order, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if p.mode == "" {
+		return nil, fmt.Errorf("%s is not a coverage profile", path)
+	}
+	return p, nil
+}
+
+// write writes the profile to the file.
+func (p *profile) write(path string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "mode: %s\n", p.mode)
+	for _, key := range p.order {
+		blk := p.blocks[key]
+		fmt.Fprintf(&b, "%s %d %d\n", key, blk.stmts, blk.count)
+	}
+	return ioutil.WriteFile(path, []byte(b.String()), 0644)
+}
+
+// percent returns the percentage of the statements that are covered.
+func (p *profile) percent() float64 {
+	total, covered := 0, 0
+	for _, b := range p.blocks {
+		total += b.stmts
+		if b.count > 0 {
+			covered += b.stmts
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return float64(covered) / float64(total) * 100
+}
+
+// writeLCOV writes the profile in lcov format, with the files' paths relative
+// to the working directory.
+func (p *profile) writeLCOV(out string) error {
+	// the profile has the files' import paths, so their directories are
+	// looked up with go list.
+	files := map[string][]*block{}
+	pkgs := map[string]bool{}
+	for _, key := range p.order {
+		b := p.blocks[key]
+		files[b.file] = append(files[b.file], b)
+		pkgs[path.Dir(b.file)] = true
+	}
+	args := []string{"list", "-e", "-f", "{{.ImportPath}}\t{{.Dir}}"}
+	for pkg := range pkgs {
+		args = append(args, pkg)
+	}
+	sort.Strings(args[4:])
+	listing, err := sh.Output(mg.GoCmd(), args...)
+	if err != nil {
+		return err
+	}
+	dirs := map[string]string{}
+	for _, line := range strings.Split(listing, "\n") {
+		if parts := strings.SplitN(line, "\t", 2); len(parts) == 2 {
+			dirs[parts[0]] = parts[1]
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		src := name
+		if dir, ok := dirs[path.Dir(name)]; ok && dir != "" {
+			src = filepath.Join(dir, path.Base(name))
+			if rel, err := filepath.Rel(wd, src); err == nil && !strings.HasPrefix(rel, "..") {
+				src = rel
+			}
+		}
+		// a line's count is the highest count of the blocks it's in.
+		lines := map[int]int{}
+		for _, blk := range files[name] {
+			for l := blk.startLine; l <= blk.endLine; l++ {
+				if c, ok := lines[l]; !ok || blk.count > c {
+					lines[l] = blk.count
+				}
+			}
+		}
+		nums := make([]int, 0, len(lines))
+		for l := range lines {
+			nums = append(nums, l)
+		}
+		sort.Ints(nums)
+		hit := 0
+		fmt.Fprintf(&b, "TN:\nSF:%s\n", filepath.ToSlash(src))
+		for _, l := range nums {
+			fmt.Fprintf(&b, "DA:%d,%d\n", l, lines[l])
+			if lines[l] > 0 {
+				hit++
+			}
+		}
+		fmt.Fprintf(&b, "LF:%d\nLH:%d\nend_of_record\n", len(nums), hit)
+	}
+	return ioutil.WriteFile(out, []byte(b.String()), 0644)
+}
@@ -0,0 +1,213 @@
+package testx
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// slowest is how many of the slowest tests Report lists.
+const slowest = 10
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	TestCases []junitCase `xml:"testcase"`
+	SystemOut string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	ClassName string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func seconds(d time.Duration) string {
+	return fmt.Sprintf("%.3f", d.Seconds())
+}
+
+// junit returns the results as JUnit XML's test suites, with a suite for each
+// package, and a test case for each test.  A package that failed outside of
+// its tests, e.g. because it didn't build, has an error instead.
+func (s Summary) junit() junitSuites {
+	byPackage := map[string][]TestResult{}
+	for _, t := range s.Tests {
+		byPackage[t.Package] = append(byPackage[t.Package], t)
+	}
+	suites := junitSuites{Tests: len(s.Tests), Failures: s.Failed, Skipped: s.Skipped}
+	var total time.Duration
+	for _, p := range s.Packages {
+		suite := junitSuite{Name: p.Package, Time: seconds(p.Elapsed)}
+		total += p.Elapsed
+		for _, t := range byPackage[p.Package] {
+			c := junitCase{ClassName: p.Package, Name: t.Name, Time: seconds(t.Elapsed)}
+			switch t.Status {
+			case "fail":
+				c.Failure = &junitMessage{Message: "Failed", Body: t.Output}
+				suite.Failures++
+			case "skip":
+				c.Skipped = &junitMessage{Message: "Skipped", Body: t.Output}
+				suite.Skipped++
+			}
+			suite.Tests++
+			suite.TestCases = append(suite.TestCases, c)
+		}
+		if p.Status == "FAIL" && suite.Failures == 0 {
+			suite.TestCases = append(suite.TestCases, junitCase{
+				ClassName: p.Package,
+				Name:      "[package failed]",
+				Time:      seconds(p.Elapsed),
+				Error:     &junitMessage{Message: "the package failed outside of its tests", Body: p.Output},
+			})
+			suite.Tests++
+			suite.Errors++
+			suite.SystemOut = p.Output
+			suites.Tests++
+			suites.Errors++
+		}
+		suites.Suites = append(suites.Suites, suite)
+	}
+	suites.Time = seconds(total)
+	return suites
+}
+
+// ParseJSON reads the output of go test -json, e.g. saved by another run, and
+// returns the summary of its results, which can then be written as JUnit XML
+// or a report.
+func ParseJSON(r io.Reader) (Summary, error) {
+	events := newEventWriter(ioutil.Discard)
+	if _, err := io.Copy(events, r); err != nil {
+		return Summary{}, err
+	}
+	events.flush()
+	return events.summary(), nil
+}
+
+// WriteJUnit writes the results as JUnit XML.
+func (s Summary) WriteJUnit(w io.Writer) error {
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(s.junit()); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
+
+// writeJUnit writes the results as JUnit XML to the file.
+func (s Summary) writeJUnit(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := s.WriteJUnit(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Report writes a summary of the results for people, as markdown: the counts,
+// the output of the tests that failed, and the slowest tests.
+func (s Summary) Report(w io.Writer) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Test results\n\n%s\n", s)
+	var failed []TestResult
+	for _, t := range s.Tests {
+		if t.Status == "fail" {
+			failed = append(failed, t)
+		}
+	}
+	failedIn := map[string]bool{}
+	for _, t := range failed {
+		failedIn[t.Package] = true
+	}
+	var broken []PackageResult
+	for _, p := range s.Packages {
+		if p.Status == "FAIL" && !failedIn[p.Package] {
+			broken = append(broken, p)
+		}
+	}
+	if len(failed) > 0 || len(broken) > 0 {
+		b.WriteString("\n### Failures\n")
+		for _, p := range broken {
+			fmt.Fprintf(&b, "\n#### %s\n\n```\n%s```\n", p.Package, withNewline(p.Output))
+		}
+		for _, t := range failed {
+			fmt.Fprintf(&b, "\n#### %s.%s (%ss)\n\n```\n%s```\n", t.Package, t.Name, seconds(t.Elapsed), withNewline(t.Output))
+		}
+	}
+	tests := make([]TestResult, 0, len(s.Tests))
+	for _, t := range s.Tests {
+		// subtests' time is part of their parent's.
+		if t.Status != "skip" && !strings.Contains(t.Name, "/") {
+			tests = append(tests, t)
+		}
+	}
+	sort.SliceStable(tests, func(i, j int) bool { return tests[i].Elapsed > tests[j].Elapsed })
+	if len(tests) > slowest {
+		tests = tests[:slowest]
+	}
+	if len(tests) > 0 {
+		b.WriteString("\n### Slowest tests\n\n| Test | Time |\n| --- | --- |\n")
+		for _, t := range tests {
+			fmt.Fprintf(&b, "| %s.%s | %ss |\n", t.Package, t.Name, seconds(t.Elapsed))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// appendReport appends the Report to the file.
+func (s Summary) appendReport(path string) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if err := s.Report(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func withNewline(s string) string {
+	if s == "" || strings.HasSuffix(s, "\n") {
+		return s
+	}
+	return s + "\n"
+}
@@ -0,0 +1,102 @@
+package testx
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRunJUnit(t *testing.T) {
+	defer project(t, files)()
+	ioutil.WriteFile("summary.md", []byte("# CI\n"), 0644)
+	summary, err := Run(Options{JUnit: "reports/junit.xml", JSON: "reports/test.json", Report: "summary.md", Stdout: ioutil.Discard})
+	if err == nil {
+		t.Fatal("expected TestOne to fail")
+	}
+	if len(summary.Tests) != 4 {
+		t.Fatalf("expected 4 tests but got %+v", summary.Tests)
+	}
+
+	b, err := ioutil.ReadFile("reports/junit.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var suites junitSuites
+	if err := xml.Unmarshal(b, &suites); err != nil {
+		t.Fatal(err)
+	}
+	if suites.Tests != 4 || suites.Failures != 1 || suites.Skipped != 1 || len(suites.Suites) != 3 {
+		t.Fatalf("unexpected suites:\n%s", b)
+	}
+	bad := suites.Suites[0]
+	if bad.Name != "example.com/app/bad" || bad.Failures != 1 || bad.TestCases[0].Failure == nil || !strings.Contains(bad.TestCases[0].Failure.Body, "One isn't 1") {
+		t.Fatalf("unexpected suite %+v", bad)
+	}
+
+	// the JSON is what go test wrote, which reads back the same.
+	f, err := os.Open("reports/test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	again, err := ParseJSON(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.String() != summary.String() || len(again.Tests) != 4 {
+		t.Fatalf("expected %s but got %s", summary, again)
+	}
+
+	report, _ := ioutil.ReadFile("summary.md")
+	for _, s := range []string{"# CI\n## Test results\n\n2 passed, 1 failed, 1 skipped\n", "#### example.com/app/bad.TestOne (", "One isn't 1", "### Slowest tests", "| example.com/app/good.TestHalf |"} {
+		if !strings.Contains(string(report), s) {
+			t.Fatalf("expected %q in the report:\n%s", s, report)
+		}
+	}
+	if strings.Contains(string(report), "TestHalf/even") {
+		t.Fatalf("expected subtests to be left out of the slowest tests:\n%s", report)
+	}
+}
+
+const failures = `{"Action":"start","Package":"example.com/app/broken"}
+{"ImportPath":"example.com/app/broken","Action":"build-output","Output":"broken/broken.go:3:1: syntax error\n"}
+{"ImportPath":"example.com/app/broken","Action":"build-fail"}
+{"Action":"output","Package":"example.com/app/broken","Output":"FAIL\texample.com/app/broken [build failed]\n"}
+{"Action":"fail","Package":"example.com/app/broken","Elapsed":0,"FailedBuild":"example.com/app/broken"}
+{"Action":"run","Package":"example.com/app/slow","Test":"TestForever"}
+{"Action":"output","Package":"example.com/app/slow","Test":"TestForever","Output":"=== RUN   TestForever\n"}
+{"Action":"output","Package":"example.com/app/slow","Output":"panic: test timed out after 1s\n"}
+{"Action":"fail","Package":"example.com/app/slow","Elapsed":1.01}
+`
+
+func TestParseJSONFailures(t *testing.T) {
+	summary, err := ParseJSON(strings.NewReader(failures))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Failed != 1 || len(summary.FailedTests) != 1 || summary.FailedTests[0] != "example.com/app/slow.TestForever" {
+		t.Fatalf("expected the test that didn't finish to fail, got %+v", summary)
+	}
+	if p := summary.Packages[0]; p.Status != "FAIL" || !strings.HasPrefix(p.Output, "broken/broken.go:3:1: syntax error\n") {
+		t.Fatalf("expected the build error, got %+v", p)
+	}
+
+	var b bytes.Buffer
+	if err := summary.WriteJUnit(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{`<testsuites tests="2" failures="1" errors="1"`, `<testcase classname="example.com/app/broken" name="[package failed]" time="0.000">`, "syntax error", `<testcase classname="example.com/app/slow" name="TestForever" time="0.000">`} {
+		if !strings.Contains(b.String(), s) {
+			t.Fatalf("expected %q in:\n%s", s, &b)
+		}
+	}
+
+	b.Reset()
+	summary.Report(&b)
+	if !strings.Contains(b.String(), "#### example.com/app/broken\n\n```\nbroken/broken.go:3:1: syntax error\n") {
+		t.Fatalf("unexpected report:\n%s", &b)
+	}
+}
@@ -0,0 +1,370 @@
+// Package testx runs go test and reports on the results: how many tests passed
+// and failed in which packages, and how much of the code they cover, merged
+// into one coverage profile that can be turned into HTML or lcov.  The results
+// can be written as JUnit XML and a markdown report for CI.
+package testx
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Options are the options for Run.
+type Options struct {
+	// Packages are the packages to test.  They default to ./...
+	Packages []string
+
+	// Flags are other flags for go test, e.g. -tags integration.
+	Flags []string
+
+	// Race turns on the race detector.
+	Race bool
+
+	// Short tells long-running tests to shorten their run time.
+	Short bool
+
+	// Timeout is how long the tests of a package can run before they're
+	// stopped.  It defaults to go test's default of 10 minutes.
+	Timeout time.Duration
+
+	// Coverage is the path to write the coverage profile to.  Coverage
+	// isn't measured if it's empty.
+	Coverage string
+
+	// CoverMode is set, count or atomic.  It defaults to atomic with the
+	// race detector, and set without it.
+	CoverMode string
+
+	// HTML and LCOV are paths to write the coverage as HTML, and in lcov
+	// format, for tools like Codecov and Coveralls.
+	HTML string
+	LCOV string
+
+	// JUnit is the path to write the results to as JUnit XML, which most CI
+	// systems show as test reports.
+	JUnit string
+
+	// JSON is the path to write the output of go test -json to, as it is.
+	JSON string
+
+	// Report is the path to write the summary of the results to, as
+	// markdown, as Summary.Report writes it, e.g. $GITHUB_STEP_SUMMARY.  It's
+	// appended to if the file exists, so more than one run can report to it.
+	Report string
+
+	// Stdout is where the output of the tests is written.  It defaults to
+	// os.Stdout.
+	Stdout io.Writer
+}
+
+// Summary is the results of a run.
+type Summary struct {
+	// Passed, Failed and Skipped are the number of tests, and subtests,
+	// that passed, failed and were skipped.
+	Passed, Failed, Skipped int
+
+	// FailedTests are the names of the tests that failed, as
+	// package.TestName.
+	FailedTests []string
+
+	// Tests are the results of each test and subtest, in the order they
+	// finished.
+	Tests []TestResult
+
+	// Packages are the results of each package.
+	Packages []PackageResult
+
+	// Coverage is the percentage of statements the tests covered, if
+	// coverage was measured.
+	Coverage float64
+}
+
+// PackageResult is the result of testing a package.
+type PackageResult struct {
+	Package string
+
+	// Status is ok, FAIL, or "no test files".
+	Status string
+
+	Elapsed time.Duration
+
+	// Output is what the package printed outside of its tests, if it
+	// failed, e.g. a build error or a panic in TestMain.
+	Output string
+}
+
+// TestResult is the result of a test.
+type TestResult struct {
+	Package string
+
+	// Name is the test's name, e.g. TestHalf or TestHalf/even.
+	Name string
+
+	// Status is pass, fail or skip.
+	Status string
+
+	Elapsed time.Duration
+
+	// Output is what the test printed, if it failed or was skipped.
+	Output string
+}
+
+// Run runs go test, printing the tests' output as it goes, and returns a
+// summary of the results.  It returns an error if any of the tests failed,
+// along with the summary.
+//
+//	func Test() error {
+//		summary, err := testx.Run(testx.Options{Race: true, Coverage: "coverage.out", HTML: "coverage.html"})
+//		fmt.Printf("%d passed, %d failed, %.1f%% coverage\n", summary.Passed, summary.Failed, summary.Coverage)
+//		return err
+//	}
+func Run(opts Options) (Summary, error) {
+	args := []string{"test", "-json"}
+	if opts.Race {
+		args = append(args, "-race")
+	}
+	if opts.Short {
+		args = append(args, "-short")
+	}
+	if opts.Timeout > 0 {
+		args = append(args, "-timeout", opts.Timeout.String())
+	}
+	if opts.Coverage != "" {
+		mode := opts.CoverMode
+		if mode == "" {
+			mode = "set"
+			if opts.Race {
+				mode = "atomic"
+			}
+		}
+		args = append(args, "-covermode", mode, "-coverprofile", opts.Coverage)
+	}
+	args = append(args, opts.Flags...)
+	pkgs := opts.Packages
+	if len(pkgs) == 0 {
+		pkgs = []string{"./..."}
+	}
+	args = append(args, pkgs...)
+
+	stdout := opts.Stdout
+	if stdout == nil {
+		stdout = os.Stdout
+	}
+	events := newEventWriter(stdout)
+	var w io.Writer = events
+	if opts.JSON != "" {
+		if err := os.MkdirAll(filepath.Dir(opts.JSON), 0755); err != nil {
+			return Summary{}, err
+		}
+		f, err := os.Create(opts.JSON)
+		if err != nil {
+			return Summary{}, err
+		}
+		defer f.Close()
+		w = io.MultiWriter(events, f)
+	}
+	_, testErr := sh.Exec(nil, w, os.Stderr, mg.GoCmd(), args...)
+	events.flush()
+	summary := events.summary()
+	if opts.JUnit != "" {
+		if err := summary.writeJUnit(opts.JUnit); err != nil {
+			return summary, err
+		}
+	}
+
+	if opts.Coverage != "" && fileExists(opts.Coverage) {
+		p, err := readProfile(opts.Coverage)
+		if err != nil {
+			return summary, err
+		}
+		summary.Coverage = p.percent()
+		if opts.HTML != "" {
+			if err := sh.Run(mg.GoCmd(), "tool", "cover", "-html", opts.Coverage, "-o", opts.HTML); err != nil {
+				return summary, err
+			}
+		}
+		if opts.LCOV != "" {
+			if err := p.writeLCOV(opts.LCOV); err != nil {
+				return summary, err
+			}
+		}
+	}
+	if opts.Report != "" {
+		if err := summary.appendReport(opts.Report); err != nil {
+			return summary, err
+		}
+	}
+	if testErr != nil {
+		if summary.Failed > 0 {
+			return summary, mg.Fatalf(mg.ExitStatus(testErr), "%d of %d tests failed", summary.Failed, summary.Passed+summary.Failed)
+		}
+		return summary, testErr
+	}
+	return summary, nil
+}
+
+// event is an event in the output of go test -json.
+type event struct {
+	Action      string
+	Package     string
+	Test        string
+	Elapsed     float64
+	Output      string
+	ImportPath  string // of build-output events
+	FailedBuild string
+}
+
+// eventWriter reads the events go test -json writes, writing their output to
+// out, and counting the results.
+type eventWriter struct {
+	out      io.Writer
+	buf      []byte
+	packages map[string]*PackageResult
+	summ     Summary
+
+	// output is what each running test, and each package outside of its
+	// tests, has printed so far, by package and test name.
+	output map[[2]string]*bytes.Buffer
+}
+
+func newEventWriter(out io.Writer) *eventWriter {
+	return &eventWriter{out: out, packages: map[string]*PackageResult{}, output: map[[2]string]*bytes.Buffer{}}
+}
+
+func (w *eventWriter) Write(b []byte) (int, error) {
+	w.buf = append(w.buf, b...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			return len(b), nil
+		}
+		w.handle(w.buf[:i])
+		w.buf = w.buf[i+1:]
+	}
+}
+
+// flush handles the last line, if it didn't end with a newline.
+func (w *eventWriter) flush() {
+	if len(w.buf) > 0 {
+		w.handle(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *eventWriter) handle(line []byte) {
+	var e event
+	if err := json.Unmarshal(line, &e); err != nil || e.Action == "" {
+		// go test prints some things, like build errors, as plain text.
+		fmt.Fprintf(w.out, "%s\n", line)
+		return
+	}
+	if e.Output != "" {
+		io.WriteString(w.out, e.Output)
+		pkg := e.Package
+		if e.Action == "build-output" {
+			pkg = e.ImportPath
+		}
+		key := [2]string{pkg, e.Test}
+		if w.output[key] == nil {
+			w.output[key] = &bytes.Buffer{}
+		}
+		w.output[key].WriteString(e.Output)
+	}
+	switch e.Action {
+	case "pass", "fail", "skip":
+	default:
+		return
+	}
+	elapsed := time.Duration(e.Elapsed * float64(time.Second))
+	key := [2]string{e.Package, e.Test}
+	output := w.output[key]
+	delete(w.output, key)
+	if e.Test != "" {
+		result := TestResult{Package: e.Package, Name: e.Test, Status: e.Action, Elapsed: elapsed}
+		switch e.Action {
+		case "pass":
+			w.summ.Passed++
+		case "fail":
+			w.summ.Failed++
+			w.summ.FailedTests = append(w.summ.FailedTests, e.Package+"."+e.Test)
+		case "skip":
+			w.summ.Skipped++
+		}
+		if e.Action != "pass" && output != nil {
+			result.Output = output.String()
+		}
+		w.summ.Tests = append(w.summ.Tests, result)
+		return
+	}
+	status := map[string]string{"pass": "ok", "fail": "FAIL", "skip": "no test files"}[e.Action]
+	p := &PackageResult{
+		Package: e.Package,
+		Status:  status,
+		Elapsed: elapsed,
+	}
+	if e.Action == "fail" {
+		// tests that didn't finish, e.g. because the package timed out,
+		// failed too.
+		var unfinished []string
+		for k := range w.output {
+			if k[0] == e.Package && k[1] != "" {
+				unfinished = append(unfinished, k[1])
+			}
+		}
+		sort.Strings(unfinished)
+		for _, name := range unfinished {
+			k := [2]string{e.Package, name}
+			w.summ.Failed++
+			w.summ.FailedTests = append(w.summ.FailedTests, e.Package+"."+name)
+			w.summ.Tests = append(w.summ.Tests, TestResult{Package: e.Package, Name: name, Status: "fail", Output: w.output[k].String()})
+			delete(w.output, k)
+		}
+		var out bytes.Buffer
+		if e.FailedBuild != "" {
+			if b := w.output[[2]string{e.FailedBuild, ""}]; b != nil {
+				out.Write(b.Bytes())
+			}
+		}
+		if output != nil {
+			out.Write(output.Bytes())
+		}
+		p.Output = out.String()
+	}
+	w.packages[e.Package] = p
+}
+
+// summary returns the summary of the results, with the packages sorted.
+func (w *eventWriter) summary() Summary {
+	s := w.summ
+	for _, p := range w.packages {
+		s.Packages = append(s.Packages, *p)
+	}
+	sort.Slice(s.Packages, func(i, j int) bool { return s.Packages[i].Package < s.Packages[j].Package })
+	sort.Strings(s.FailedTests)
+	return s
+}
+
+// String summarizes the results on one line, e.g. "12 passed, 1 failed,
+// 2 skipped, 81.5% coverage".
+func (s Summary) String() string {
+	parts := []string{fmt.Sprintf("%d passed", s.Passed), fmt.Sprintf("%d failed", s.Failed), fmt.Sprintf("%d skipped", s.Skipped)}
+	if s.Coverage > 0 {
+		parts = append(parts, fmt.Sprintf("%.1f%% coverage", s.Coverage))
+	}
+	return strings.Join(parts, ", ")
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
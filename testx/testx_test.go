@@ -0,0 +1,166 @@
+package testx
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// project changes to a new module with the files, and returns a func that
+// changes back and removes it.
+func project(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, _ := os.Getwd()
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	files["go.mod"] = "module example.com/app\n\ngo 1.16\n"
+	for name, s := range files {
+		os.MkdirAll(filepath.Dir(name), 0755)
+		if err := ioutil.WriteFile(name, []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	oldFlags, oldWork := os.Getenv("GOFLAGS"), os.Getenv("GOWORK")
+	os.Setenv("GOFLAGS", "")
+	os.Setenv("GOWORK", "off")
+	return func() {
+		os.Setenv("GOFLAGS", oldFlags)
+		os.Setenv("GOWORK", oldWork)
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+var files = map[string]string{
+	"good/good.go": `package good
+
+func Half(n int) int {
+	if n < 0 {
+		return -Half(-n)
+	}
+	return n / 2
+}
+`,
+	"good/good_test.go": `package good
+
+import "testing"
+
+func TestHalf(t *testing.T) {
+	t.Run("even", func(t *testing.T) {
+		if Half(4) != 2 {
+			t.Fatal("wrong")
+		}
+	})
+}
+
+func TestSlow(t *testing.T) {
+	t.Skip("too slow")
+}
+`,
+	"bad/bad.go": "package bad\n\nfunc One() int { return 2 }\n",
+	"bad/bad_test.go": `package bad
+
+import "testing"
+
+func TestOne(t *testing.T) {
+	if One() != 1 {
+		t.Fatal("One isn't 1")
+	}
+}
+`,
+	"none/none.go": "package none\n",
+}
+
+func TestRun(t *testing.T) {
+	defer project(t, files)()
+	out := &bytes.Buffer{}
+	summary, err := Run(Options{Coverage: "cover.out", LCOV: "lcov.info", HTML: "cover.html", Stdout: out})
+	if err == nil || err.Error() != "1 of 3 tests failed" {
+		t.Fatalf("expected 1 of 3 tests to fail but got %v", err)
+	}
+	if summary.Passed != 2 || summary.Failed != 1 || summary.Skipped != 1 {
+		t.Fatalf("expected 2 passed, 1 failed and 1 skipped but got %+v", summary)
+	}
+	if !reflect.DeepEqual(summary.FailedTests, []string{"example.com/app/bad.TestOne"}) {
+		t.Fatalf("expected TestOne to fail but got %v", summary.FailedTests)
+	}
+	var statuses []string
+	for _, p := range summary.Packages {
+		statuses = append(statuses, p.Package+" "+p.Status)
+	}
+	expected := []string{"example.com/app/bad FAIL", "example.com/app/good ok", "example.com/app/none no test files"}
+	if !reflect.DeepEqual(statuses, expected) {
+		t.Fatalf("expected %v but got %v", expected, statuses)
+	}
+	// good has 3 statements, 2 covered, and bad has 1, covered.
+	if summary.Coverage != 75 {
+		t.Fatalf("expected 75%% coverage but got %v", summary.Coverage)
+	}
+	if s := summary.String(); s != "2 passed, 1 failed, 1 skipped, 75.0% coverage" {
+		t.Fatalf("unexpected summary %q", s)
+	}
+	if !strings.Contains(out.String(), "One isn't 1") {
+		t.Fatalf("expected the tests' output but got:\n%s", out)
+	}
+
+	lcov, err := ioutil.ReadFile("lcov.info")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(lcov), "TN:\nSF:good/good.go\nDA:4,1\nDA:5,0\nDA:6,0\nDA:7,1\nLF:4\nLH:2\nend_of_record\n") {
+		t.Fatalf("unexpected lcov:\n%s", lcov)
+	}
+	if html, err := ioutil.ReadFile("cover.html"); err != nil || !strings.Contains(string(html), "good.go") {
+		t.Fatalf("expected the HTML coverage report: %v", err)
+	}
+}
+
+func TestRunPass(t *testing.T) {
+	defer project(t, files)()
+	summary, err := Run(Options{Packages: []string{"./good"}, Short: true, Stdout: ioutil.Discard})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if summary.Passed != 2 || summary.Coverage != 0 {
+		t.Fatalf("expected 2 passed and no coverage but got %+v", summary)
+	}
+}
+
+func TestMergeProfiles(t *testing.T) {
+	defer project(t, map[string]string{
+		"unit.out":        "mode: count\nexample.com/app/a.go:3.1,5.2 2 1\nexample.com/app/a.go:6.1,7.2 1 0\n",
+		"integration.out": "mode: count\nexample.com/app/a.go:3.1,5.2 2 3\nexample.com/app/a.go:6.1,7.2 1 2\nexample.com/app/b.go:1.1,2.2 1 0\n",
+		"set.out":         "mode: set\nexample.com/app/a.go:3.1,5.2 2 1\n",
+		"bad.out":         "nonsense\n",
+	})()
+	if err := MergeProfiles("merged.out", "unit.out", "integration.out"); err != nil {
+		t.Fatal(err)
+	}
+	b, _ := ioutil.ReadFile("merged.out")
+	expected := "mode: count\nexample.com/app/a.go:3.1,5.2 2 4\nexample.com/app/a.go:6.1,7.2 1 2\nexample.com/app/b.go:1.1,2.2 1 0\n"
+	if string(b) != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, b)
+	}
+	p, err := readProfile("merged.out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.percent() != 75 {
+		t.Fatalf("expected 75%% coverage but got %v", p.percent())
+	}
+
+	if err := MergeProfiles("merged.out", "unit.out", "set.out"); err == nil || err.Error() != "can't merge set.out, its mode is set, not count" {
+		t.Fatalf("expected a mode error but got %v", err)
+	}
+	if err := MergeProfiles("merged.out", "bad.out"); err == nil || err.Error() != `bad.out:1: invalid coverage profile line "nonsense"` {
+		t.Fatalf("expected an invalid profile error but got %v", err)
+	}
+}
@@ -0,0 +1,229 @@
+package tools
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/magefile/mage/sh"
+)
+
+// ManifestFiles are the files, in the working directory, that EnsureAll and
+// Path read the project's tools from, in the order they're looked for.
+//
+// tools.yaml maps a name to a package and version, one per line:
+//
+//	gotestsum: gotest.tools/gotestsum@v1.11.0
+//	golangci-lint: github.com/golangci/golangci-lint/cmd/golangci-lint@v1.55.2
+//
+// tools.go is the common go file that imports each tool with a blank import,
+// so it's tracked in go.mod, which the versions are read from.
+var ManifestFiles = []string{"tools.yaml", "tools.go"}
+
+// Manifest returns the tools listed in the project's manifest, sorted by name.
+func Manifest() ([]Tool, error) {
+	tools, found, err := manifest()
+	if err == nil && !found {
+		err = fmt.Errorf("no tools manifest found, looked for %s", strings.Join(ManifestFiles, ", "))
+	}
+	return tools, err
+}
+
+// manifest returns the tools listed in the project's manifest, sorted by name,
+// and whether there is one.
+func manifest() (tools []Tool, found bool, err error) {
+	for _, name := range ManifestFiles {
+		b, err := ioutil.ReadFile(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, true, err
+		}
+		if filepath.Ext(name) == ".go" {
+			tools, err = parseToolsGo(name, b)
+		} else {
+			tools, err = parseToolsYAML(b)
+		}
+		if err != nil {
+			return nil, true, fmt.Errorf("error reading %s: %v", name, err)
+		}
+		sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+		return tools, true, nil
+	}
+	return nil, false, nil
+}
+
+// EnsureAll installs every tool in the project's manifest that isn't already
+// installed, and puts them first on the PATH for the rest of the mage run, as
+// sh.PrependPath does, so they can be run by name.  Tools that are installed
+// are only checked for, so it's cheap to use as a dependency of every target
+// that runs a tool:
+//
+//	func Lint() error {
+//		mg.Deps(tools.EnsureAll)
+//		return sh.RunV("golangci-lint", "run")
+//	}
+func EnsureAll() error {
+	tools, err := Manifest()
+	if err != nil {
+		return err
+	}
+	var dirs []string
+	for _, t := range tools {
+		path, err := t.Ensure()
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, filepath.Dir(path))
+	}
+	return sh.PrependPath(dirs...)
+}
+
+// Path installs the named tool from the project's manifest, unless it's already
+// installed, and returns the path to the binary.
+func Path(name string) (string, error) {
+	tools, err := Manifest()
+	if err != nil {
+		return "", err
+	}
+	for _, t := range tools {
+		if t.Name == name {
+			return t.Ensure()
+		}
+	}
+	return "", fmt.Errorf("tool %q is not in the tools manifest", name)
+}
+
+// Lookup returns the tool in the project's manifest that installs pkg, and
+// whether there is one.  A project without a manifest just doesn't list pkg,
+// so packages that pin a default version of their tool can let the manifest
+// override it, but a manifest that can't be read is an error.
+func Lookup(pkg string) (Tool, bool, error) {
+	tools, _, err := manifest()
+	if err != nil {
+		return Tool{}, false, err
+	}
+	for _, t := range tools {
+		if t.Package == pkg {
+			return t, true, nil
+		}
+	}
+	return Tool{}, false, nil
+}
+
+// parseToolsYAML reads the name: package@version lines of a tools.yaml.
+func parseToolsYAML(b []byte) ([]Tool, error) {
+	var tools []Tool
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for line := 1; scanner.Scan(); line++ {
+		s := scanner.Text()
+		if i := strings.Index(s, "#"); i >= 0 {
+			s = s[:i]
+		}
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		parts := strings.SplitN(s, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("line %d: expected name: package@version", line)
+		}
+		name := strings.TrimSpace(parts[0])
+		spec := strings.Trim(strings.TrimSpace(parts[1]), `"'`)
+		at := strings.LastIndex(spec, "@")
+		if name == "" || at <= 0 || at == len(spec)-1 {
+			return nil, fmt.Errorf("line %d: expected name: package@version", line)
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("line %d: tool %q is listed more than once", line, name)
+		}
+		seen[name] = true
+		tools = append(tools, Tool{Name: name, Package: spec[:at], Version: spec[at+1:]})
+	}
+	return tools, scanner.Err()
+}
+
+// parseToolsGo reads the blank imports of a tools.go, and looks up the version
+// of each in the go.mod next to it.
+func parseToolsGo(filename string, b []byte) ([]Tool, error) {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, b, parser.ImportsOnly)
+	if err != nil {
+		return nil, err
+	}
+	modfile := filepath.Join(filepath.Dir(filename), "go.mod")
+	mod, err := ioutil.ReadFile(modfile)
+	if err != nil {
+		return nil, err
+	}
+	reqs := parseRequires(mod)
+	var tools []Tool
+	for _, imp := range f.Imports {
+		if imp.Name == nil || imp.Name.Name != "_" {
+			continue
+		}
+		pkg, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			return nil, err
+		}
+		version := moduleVersion(reqs, pkg)
+		if version == "" {
+			return nil, fmt.Errorf("%s is not required in %s", pkg, modfile)
+		}
+		tools = append(tools, Tool{Name: binName(pkg), Package: pkg, Version: version})
+	}
+	return tools, nil
+}
+
+// parseRequires returns the module versions required by a go.mod file.
+func parseRequires(mod []byte) map[string]string {
+	reqs := map[string]string{}
+	inBlock := false
+	scanner := bufio.NewScanner(bytes.NewReader(mod))
+	for scanner.Scan() {
+		s := scanner.Text()
+		if i := strings.Index(s, "//"); i >= 0 {
+			s = s[:i]
+		}
+		fields := strings.Fields(s)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case fields[0] == "require" && len(fields) == 2 && fields[1] == "(":
+			inBlock = true
+			continue
+		case fields[0] == "require":
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		if len(fields) >= 2 {
+			reqs[fields[0]] = fields[1]
+		}
+	}
+	return reqs
+}
+
+// moduleVersion returns the version of the required module that provides pkg,
+// the one with the longest matching path.
+func moduleVersion(reqs map[string]string, pkg string) string {
+	best := ""
+	for mod := range reqs {
+		if (pkg == mod || strings.HasPrefix(pkg, mod+"/")) && len(mod) > len(best) {
+			best = mod
+		}
+	}
+	return reqs[best]
+}
@@ -0,0 +1,197 @@
+package tools
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"reflect"
+	"testing"
+
+	"github.com/magefile/mage/mg"
+)
+
+func TestManifestYAML(t *testing.T) {
+	_, undo := chdir(t)
+	defer undo()
+	yaml := `
+# pinned build tools
+lint: "github.com/golangci/golangci-lint/cmd/golangci-lint@v1.55.2"
+gotestsum: gotest.tools/gotestsum@v1.11.0 # tests
+`
+	if err := ioutil.WriteFile("tools.yaml", []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tools, err := Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Tool{
+		{Name: "gotestsum", Package: "gotest.tools/gotestsum", Version: "v1.11.0"},
+		{Name: "lint", Package: "github.com/golangci/golangci-lint/cmd/golangci-lint", Version: "v1.55.2"},
+	}
+	if !reflect.DeepEqual(tools, expected) {
+		t.Fatalf("expected %v but got %v", expected, tools)
+	}
+}
+
+func TestManifestYAMLErrors(t *testing.T) {
+	tests := map[string]string{
+		"gotestsum gotest.tools/gotestsum@v1.11.0":                                  "line 1: expected name: package@version",
+		"gotestsum: gotest.tools/gotestsum":                                         "line 1: expected name: package@version",
+		"a: example.com/a@v1\na: example.com/b@v1":                                  `line 2: tool "a" is listed more than once`,
+		"\n: gotest.tools/gotestsum@v1.11.0":                                        "line 2: expected name: package@version",
+		"gotestsum: gotest.tools/gotestsum@":                                        "line 1: expected name: package@version",
+		"ok: example.com/ok@v1.0.0\n\n# comment\nbad: example.com/bad # no version": "line 4: expected name: package@version",
+	}
+	for yaml, expected := range tests {
+		_, err := parseToolsYAML([]byte(yaml))
+		if err == nil || err.Error() != expected {
+			t.Errorf("%q: expected error %q but got %v", yaml, expected, err)
+		}
+	}
+}
+
+func TestManifestToolsGo(t *testing.T) {
+	_, undo := chdir(t)
+	defer undo()
+	gomod := `module example.com/project
+
+go 1.16
+
+require github.com/golangci/golangci-lint v1.55.2
+
+require (
+	gotest.tools v2.2.0+incompatible // indirect
+	gotest.tools/gotestsum v1.11.0
+	github.com/goreleaser/goreleaser/v2 v2.3.1
+)
+`
+	toolsgo := `//go:build tools
+
+package tools
+
+import (
+	_ "github.com/golangci/golangci-lint/cmd/golangci-lint"
+	_ "github.com/goreleaser/goreleaser/v2"
+	_ "gotest.tools/gotestsum"
+	"fmt"
+)
+`
+	if err := ioutil.WriteFile("go.mod", []byte(gomod), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("tools.go", []byte(toolsgo), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tools, err := Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []Tool{
+		{Name: "golangci-lint", Package: "github.com/golangci/golangci-lint/cmd/golangci-lint", Version: "v1.55.2"},
+		{Name: "goreleaser", Package: "github.com/goreleaser/goreleaser/v2", Version: "v2.3.1"},
+		{Name: "gotestsum", Package: "gotest.tools/gotestsum", Version: "v1.11.0"},
+	}
+	if !reflect.DeepEqual(tools, expected) {
+		t.Fatalf("expected %v but got %v", expected, tools)
+	}
+
+	// tools.yaml is used if both are there.
+	if err := ioutil.WriteFile("tools.yaml", []byte("x: example.com/x@v1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tools, err = Manifest()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tools) != 1 || tools[0].Name != "x" {
+		t.Fatalf("expected the tools from tools.yaml but got %v", tools)
+	}
+}
+
+func TestManifestToolsGoMissingVersion(t *testing.T) {
+	_, undo := chdir(t)
+	defer undo()
+	if err := ioutil.WriteFile("go.mod", []byte("module example.com/project\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("tools.go", []byte("package tools\n\nimport _ \"example.com/tool\"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := Manifest()
+	expected := "error reading tools.go: example.com/tool is not required in go.mod"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q but got %v", expected, err)
+	}
+}
+
+func TestManifestMissing(t *testing.T) {
+	_, undo := chdir(t)
+	defer undo()
+	_, err := Manifest()
+	expected := "no tools manifest found, looked for tools.yaml, tools.go"
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q but got %v", expected, err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	_, undo := chdir(t)
+	defer undo()
+	// without a manifest, nothing is listed.
+	if _, ok, err := Lookup("gotest.tools/gotestsum"); ok || err != nil {
+		t.Fatalf("expected no tool and no error without a manifest, but got %v, %v", ok, err)
+	}
+	if err := ioutil.WriteFile("tools.yaml", []byte("gotestsum: gotest.tools/gotestsum@v1.11.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	tool, ok, err := Lookup("gotest.tools/gotestsum")
+	expected := Tool{Name: "gotestsum", Package: "gotest.tools/gotestsum", Version: "v1.11.0"}
+	if err != nil || !ok || tool != expected {
+		t.Fatalf("expected %v but got %v, %v, %v", expected, tool, ok, err)
+	}
+	if _, ok, err := Lookup("example.com/other"); ok || err != nil {
+		t.Fatalf("expected a package that isn't listed not to be found, but got %v, %v", ok, err)
+	}
+	// a manifest that's broken is an error, not a missing tool.
+	if err := ioutil.WriteFile("tools.yaml", []byte("gotestsum gotest.tools/gotestsum\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = Lookup("gotest.tools/gotestsum")
+	if e := "error reading tools.yaml: line 1: expected name: package@version"; err == nil || err.Error() != e {
+		t.Fatalf("expected error %q but got %v", e, err)
+	}
+}
+
+func TestEnsureAll(t *testing.T) {
+	defer fakeProxy(t)()
+	defer setenv("PATH", os.Getenv("PATH"))()
+	if err := ioutil.WriteFile("tools.yaml", []byte("hi: example.com/hello@v1.0.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := EnsureAll(); err != nil {
+		t.Fatal(err)
+	}
+	// it's found on the PATH.
+	if _, err := exec.LookPath("hello"); err != nil {
+		t.Fatal(err)
+	}
+
+	// installed tools are only checked for, so go isn't needed anymore.
+	defer setenv(mg.GoCmdEnv, "no-such-go")()
+	if err := EnsureAll(); err != nil {
+		t.Fatal(err)
+	}
+	path, err := Path("hi")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fileExists(path) {
+		t.Fatalf("%s was not installed", path)
+	}
+	_, err = Path("nope")
+	expected := `tool "nope" is not in the tools manifest`
+	if err == nil || err.Error() != expected {
+		t.Fatalf("expected error %q but got %v", expected, err)
+	}
+}
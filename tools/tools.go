@@ -0,0 +1,101 @@
+// Package tools installs the go tools a build needs, pinned to a version, into
+// a directory in the project, so every machine runs the same version without
+// installing it globally.  Tools are installed with go install the first time
+// they're needed, and after that, checking them only looks for the binary, so
+// it's fast enough to do in every target that uses them.
+package tools
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// Tool is a go tool pinned to a version.
+type Tool struct {
+	Name    string // the name it's looked up by with Path
+	Package string // the package to install, e.g. gotest.tools/gotestsum
+	Version string // the module version to install, e.g. v1.11.0
+}
+
+// installMu keeps tools from being installed twice at once by targets running
+// in parallel.
+var installMu sync.Mutex
+
+// Ensure installs the package at the given version, unless it's already
+// installed, and returns the path to the binary.
+func Ensure(pkg, version string) (string, error) {
+	return Tool{Name: binName(pkg), Package: pkg, Version: version}.Ensure()
+}
+
+// Ensure installs the tool, unless it's already installed, and returns the
+// path to the binary.
+func (t Tool) Ensure() (string, error) {
+	if t.Package == "" || t.Version == "" {
+		return "", fmt.Errorf("tool %q needs a package and a version", t.Name)
+	}
+	dir, err := filepath.Abs(filepath.Join(mg.ToolsDir(), binName(t.Package), t.Version))
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(dir, exeName(binName(t.Package)))
+	if fileExists(path) {
+		return path, nil
+	}
+
+	installMu.Lock()
+	defer installMu.Unlock()
+	if fileExists(path) {
+		return path, nil
+	}
+	log.Printf("installing %s@%s", t.Package, t.Version)
+	if err := os.MkdirAll(filepath.Dir(dir), 0755); err != nil {
+		return "", err
+	}
+	// the binary is installed to a temporary directory and moved into place,
+	// so an install that fails part way isn't mistaken for a good one.
+	tmp, err := ioutil.TempDir(filepath.Dir(dir), ".install-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	if err := sh.RunWith(map[string]string{"GOBIN": tmp}, mg.GoCmd(), "install", t.Package+"@"+t.Version); err != nil {
+		return "", fmt.Errorf("failed to install %s@%s: %v", t.Package, t.Version, err)
+	}
+	if err := os.Rename(tmp, dir); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// binName returns the name go install gives the binary for pkg: the last
+// element of its path, or the one before it for a major version suffix.
+func binName(pkg string) string {
+	parts := strings.Split(pkg, "/")
+	name := parts[len(parts)-1]
+	if len(parts) > 1 && len(name) > 1 && name[0] == 'v' && strings.Trim(name[1:], "0123456789") == "" {
+		name = parts[len(parts)-2]
+	}
+	return name
+}
+
+// exeName returns the file name of the named binary on this OS.
+func exeName(name string) string {
+	if runtime.GOOS == "windows" {
+		return name + ".exe"
+	}
+	return name
+}
+
+func fileExists(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && !fi.IsDir()
+}
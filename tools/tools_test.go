@@ -0,0 +1,167 @@
+package tools
+
+import (
+	"archive/zip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/magefile/mage/mg"
+)
+
+// setenv sets the environment variable, and returns a func that restores it.
+func setenv(key, value string) func() {
+	old, ok := os.LookupEnv(key)
+	os.Setenv(key, value)
+	return func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	}
+}
+
+// chdir changes to a new temporary directory, and returns it and a func that
+// changes back and removes it.
+func chdir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(wd)
+		os.RemoveAll(dir)
+	}
+}
+
+// fakeProxy serves example.com/hello v1.0.0 from a module proxy in a directory,
+// so tools install without the network.  It returns a func that undoes it.
+func fakeProxy(t *testing.T) func() {
+	wd, undo := chdir(t)
+	dir := filepath.Join(wd, "proxy")
+	vdir := filepath.Join(dir, "example.com", "hello", "@v")
+	if err := os.MkdirAll(vdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mod := "module example.com/hello\n\ngo 1.16\n"
+	files := map[string]string{
+		"list":        "v1.0.0\n",
+		"v1.0.0.info": `{"Version":"v1.0.0","Time":"2020-01-01T00:00:00Z"}`,
+		"v1.0.0.mod":  mod,
+	}
+	for name, s := range files {
+		if err := ioutil.WriteFile(filepath.Join(vdir, name), []byte(s), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f, err := os.Create(filepath.Join(vdir, "v1.0.0.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	z := zip.NewWriter(f)
+	for name, s := range map[string]string{
+		"go.mod":  mod,
+		"main.go": "package main\n\nfunc main() { println(\"hello\") }\n",
+	} {
+		w, err := z.Create("example.com/hello@v1.0.0/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.Close(); err != nil {
+		t.Fatal(err)
+	}
+	restores := []func(){
+		setenv("GOPROXY", "file://"+filepath.ToSlash(dir)),
+		setenv("GOSUMDB", "off"),
+		setenv("GOFLAGS", ""),
+		setenv("GOWORK", "off"),
+		undo,
+	}
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+func TestEnsure(t *testing.T) {
+	defer fakeProxy(t)()
+	path, err := Ensure("example.com/hello", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected, _ := filepath.Abs(filepath.Join(".tools", "hello", "v1.0.0", exeName("hello")))
+	if path != expected {
+		t.Fatalf("expected %q but got %q", expected, path)
+	}
+	if !fileExists(path) {
+		t.Fatalf("%s was not installed", path)
+	}
+
+	// once it's installed, go isn't run again.
+	defer setenv(mg.GoCmdEnv, "no-such-go")()
+	again, err := Ensure("example.com/hello", "v1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != path {
+		t.Fatalf("expected %q but got %q", path, again)
+	}
+	if _, err := Ensure("example.com/hello", "v1.0.1"); err == nil {
+		t.Fatal("expected an error installing a new version without go")
+	}
+}
+
+func TestEnsureToolsDir(t *testing.T) {
+	dir, undo := chdir(t)
+	defer undo()
+	defer setenv(mg.ToolsDirEnv, filepath.Join(dir, "bin"))()
+	defer setenv(mg.GoCmdEnv, "no-such-go")()
+	expected := filepath.Join(dir, "bin", "tool", "v2.0.0", exeName("tool"))
+	if err := os.MkdirAll(filepath.Dir(expected), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(expected, nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	path, err := Ensure("example.com/tool/v2", "v2.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if path != expected {
+		t.Fatalf("expected %q but got %q", expected, path)
+	}
+}
+
+func TestBinName(t *testing.T) {
+	tests := map[string]string{
+		"gotest.tools/gotestsum":                              "gotestsum",
+		"github.com/golangci/golangci-lint/cmd/golangci-lint": "golangci-lint",
+		"github.com/goreleaser/goreleaser/v2":                 "goreleaser",
+		"example.com/v":                                       "v",
+		"vet":                                                 "vet",
+	}
+	for pkg, expected := range tests {
+		if name := binName(pkg); name != expected {
+			t.Errorf("%s: expected %q but got %q", pkg, expected, name)
+		}
+	}
+	if runtime.GOOS != "windows" && exeName("x") != "x" {
+		t.Errorf("expected no extension on %s", runtime.GOOS)
+	}
+}
@@ -0,0 +1,53 @@
+package upload
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// types are the content types of the files builds usually make, which
+// mime.TypeByExtension only knows if the system's mime.types does.
+var types = map[string]string{
+	".asc":    "text/plain; charset=utf-8",
+	".gz":     "application/gzip",
+	".json":   "application/json",
+	".md":     "text/markdown; charset=utf-8",
+	".pem":    "application/x-pem-file",
+	".sha256": "text/plain; charset=utf-8",
+	".sig":    "text/plain; charset=utf-8",
+	".tar":    "application/x-tar",
+	".tgz":    "application/gzip",
+	".txt":    "text/plain; charset=utf-8",
+	".xz":     "application/x-xz",
+	".yaml":   "application/yaml",
+	".yml":    "application/yaml",
+	".zip":    "application/zip",
+	".zst":    "application/zstd",
+}
+
+// detect returns the content type of the file, from its extension if it's
+// known, or else from what its contents look like.
+func detect(path string) (string, error) {
+	ext := strings.ToLower(filepath.Ext(path))
+	if t, ok := types[ext]; ok {
+		return t, nil
+	}
+	if t := mime.TypeByExtension(ext); ext != "" && t != "" {
+		return t, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	b := make([]byte, 512)
+	n, err := io.ReadFull(f, b)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return "", err
+	}
+	return http.DetectContentType(b[:n]), nil
+}
@@ -0,0 +1,41 @@
+package upload
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDetect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"app.TGZ":       "application/gzip",
+		"checksums.txt": "text/plain; charset=utf-8",
+		"index.html":    "text/html; charset=utf-8",
+		"app":           "application/octet-stream",
+		"SHA256SUMS":    "text/plain; charset=utf-8",
+	}
+	contents := map[string]string{
+		"app":        "\x7fELF\x02\x01\x01\x00\x00",
+		"SHA256SUMS": "abc123  app\n",
+	}
+	for name, expected := range files {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(contents[name]), 0644)
+		got, err := detect(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != expected {
+			t.Errorf("%s: expected %q, got %q", name, expected, got)
+		}
+	}
+	if _, err := detect(filepath.Join(dir, "missing")); err == nil {
+		t.Fatal("expected an error for a missing file")
+	}
+}
@@ -0,0 +1,219 @@
+// Package upload publishes build outputs, like release archives and docs, to
+// S3, Google Cloud Storage, Azure Blob Storage, or any server that takes an
+// HTTP PUT.  Where a file goes is a URL, which says how it's uploaded:
+//
+//	s3://bucket/path             with the aws CLI
+//	gs://bucket/path             with the gcloud CLI
+//	az://account/container/path  with the az CLI
+//	https://host/path            with a PUT, by httpx
+//
+// The CLIs are already installed and logged in on most CI runners, and they
+// find credentials the way they always do, so magefiles don't need the
+// clouds' SDKs or to handle credentials themselves.
+package upload
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/magefile/mage/httpx"
+	"github.com/magefile/mage/pool"
+	"github.com/magefile/mage/sh"
+)
+
+// Options are the options for uploading.
+type Options struct {
+	// ContentType is the content type of every file.  It defaults to the
+	// type of each file's extension, or else what its contents look like.
+	ContentType string
+
+	// CacheControl is the Cache-Control the files are served with, e.g.
+	// "public, max-age=300", if it's set.
+	CacheControl string
+
+	// Parallel is how many files are uploaded at once.  It defaults to the
+	// number of CPUs.
+	Parallel int
+
+	// DryRun prints what would be uploaded, without uploading it.
+	DryRun bool
+
+	// Stdout is where the dry run is printed.  It defaults to os.Stdout.
+	Stdout io.Writer
+
+	// Client makes the PUTs to http and https URLs.  It defaults to
+	// httpx.Default.
+	Client *httpx.Client
+}
+
+// File uploads the file to the URL.
+func File(src, dst string, opts Options) error {
+	return uploadAll([]file{{src, dst}}, opts)
+}
+
+// Files uploads the files into the directory at the URL, each with its base
+// name, e.g. dist/app.tar.gz to s3://bucket/v1.2.3/app.tar.gz for
+// s3://bucket/v1.2.3.
+//
+//	// Publish uploads the release's artifacts.
+//	func Publish() error {
+//		files, err := filepath.Glob("dist/*")
+//		if err != nil {
+//			return err
+//		}
+//		return upload.Files("s3://releases/app/"+version, files, upload.Options{})
+//	}
+func Files(dst string, files []string, opts Options) error {
+	var fs []file
+	for _, f := range files {
+		fs = append(fs, file{f, join(dst, filepath.Base(f))})
+	}
+	return uploadAll(fs, opts)
+}
+
+// Dir uploads the files in the directory, and in its subdirectories, into
+// the directory at the URL, where they have the same paths relative to it,
+// e.g. site/css/main.css to gs://bucket/css/main.css for gs://bucket.
+func Dir(dir, dst string, opts Options) error {
+	var fs []file
+	err := filepath.Walk(dir, func(p string, fi os.FileInfo, err error) error {
+		if err != nil || !fi.Mode().IsRegular() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		fs = append(fs, file{p, join(dst, filepath.ToSlash(rel))})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return uploadAll(fs, opts)
+}
+
+// file is a file to upload, and where to.
+type file struct {
+	src, dst string
+}
+
+// join adds the name to the path of the URL, before its query, if it has one,
+// so presigned prefixes work too.
+func join(dst, name string) string {
+	query := ""
+	if i := strings.Index(dst, "?"); i >= 0 {
+		dst, query = dst[:i], dst[i:]
+	}
+	return strings.TrimSuffix(dst, "/") + "/" + name + query
+}
+
+// uploadAll uploads the files, opts.Parallel at once, or prints them for a
+// dry run.
+func uploadAll(files []file, opts Options) error {
+	sort.Slice(files, func(i, j int) bool { return files[i].dst < files[j].dst })
+	tasks := make([]pool.Task, len(files))
+	for i, f := range files {
+		f := f
+		contentType := opts.ContentType
+		if contentType == "" {
+			var err error
+			if contentType, err = detect(f.src); err != nil {
+				return fmt.Errorf("can't upload %s: %v", f.src, err)
+			}
+		}
+		up, err := uploader(f.src, f.dst, contentType, opts)
+		if err != nil {
+			return err
+		}
+		tasks[i] = pool.Task{
+			Name: "upload " + redact(f.dst),
+			Run:  func(context.Context) error { return up() },
+		}
+		if opts.DryRun {
+			stdout := opts.Stdout
+			if stdout == nil {
+				stdout = os.Stdout
+			}
+			desc := contentType
+			if opts.CacheControl != "" {
+				desc += ", Cache-Control: " + opts.CacheControl
+			}
+			fmt.Fprintf(stdout, "would upload %s to %s (%s)\n", f.src, redact(f.dst), desc)
+		}
+	}
+	if opts.DryRun {
+		return nil
+	}
+	return pool.Run(opts.Parallel, tasks...)
+}
+
+// uploader returns a func that uploads the file to dst, or an error if dst
+// isn't a URL it can upload to.
+func uploader(src, dst, contentType string, opts Options) (func() error, error) {
+	u, err := url.Parse(dst)
+	if err != nil {
+		return nil, fmt.Errorf("can't upload to %s: %v", redact(dst), err)
+	}
+	switch u.Scheme {
+	case "s3":
+		args := []string{"s3", "cp", "--only-show-errors", "--content-type", contentType}
+		if opts.CacheControl != "" {
+			args = append(args, "--cache-control", opts.CacheControl)
+		}
+		args = append(args, src, dst)
+		return func() error { return sh.Run("aws", args...) }, nil
+	case "gs":
+		args := []string{"storage", "cp", "--content-type=" + contentType}
+		if opts.CacheControl != "" {
+			args = append(args, "--cache-control="+opts.CacheControl)
+		}
+		args = append(args, src, dst)
+		return func() error { return sh.Run("gcloud", args...) }, nil
+	case "az":
+		parts := strings.SplitN(strings.TrimPrefix(u.Path, "/"), "/", 2)
+		if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("can't upload to %s, Azure Blob Storage URLs are az://account/container/path", dst)
+		}
+		args := []string{"storage", "blob", "upload", "--only-show-errors", "--overwrite",
+			"--account-name", u.Host, "--container-name", parts[0], "--name", parts[1],
+			"--file", src, "--content-type", contentType}
+		if opts.CacheControl != "" {
+			args = append(args, "--content-cache-control", opts.CacheControl)
+		}
+		return func() error { return sh.Run("az", args...) }, nil
+	case "http", "https":
+		c := httpx.Default
+		if opts.Client != nil {
+			c = opts.Client
+		}
+		client := *c
+		client.Header = http.Header{}
+		for k, v := range c.Header {
+			client.Header[k] = v
+		}
+		client.Header.Set("Content-Type", contentType)
+		if opts.CacheControl != "" {
+			client.Header.Set("Cache-Control", opts.CacheControl)
+		}
+		return func() error { return client.Upload(src, dst) }, nil
+	default:
+		return nil, fmt.Errorf("can't upload to %s, the URL must start with s3://, gs://, az://, http:// or https://", redact(dst))
+	}
+}
+
+// redact returns the URL without its query, which, for a presigned URL, is a
+// secret.
+func redact(dst string) string {
+	if i := strings.Index(dst, "?"); i >= 0 {
+		return dst[:i] + "?..."
+	}
+	return dst
+}
@@ -0,0 +1,149 @@
+package upload
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/magefile/mage/internal/faketool"
+)
+
+func TestMain(m *testing.M) {
+	faketool.Main(map[string]faketool.Tool{"aws": cli("aws"), "gcloud": cli("gcloud"), "az": cli("az")})
+	os.Exit(m.Run())
+}
+
+// cli returns a fake CLI called name, that writes how it was run to the file
+// log next to it.
+func cli(name string) faketool.Tool {
+	return func(args []string) int {
+		faketool.Append(filepath.Join(faketool.Dir(), "log"), name+" "+strings.Join(args, " "))
+		return 0
+	}
+}
+
+// fakeCLIs puts a fake aws, gcloud and az on the PATH, and returns a dir with
+// files to upload, the log of how the CLIs were run, and a func that puts
+// things back.
+func fakeCLIs(t *testing.T) (dir, logFile string, cleanup func()) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bin := filepath.Join(dir, "bin")
+	for _, name := range []string{"aws", "gcloud", "az"} {
+		faketool.Install(t, bin, name)
+	}
+	logFile = filepath.Join(bin, "log")
+	src := filepath.Join(dir, "dist")
+	os.MkdirAll(filepath.Join(src, "docs"), 0755)
+	ioutil.WriteFile(filepath.Join(src, "app.tar.gz"), []byte("archive"), 0644)
+	ioutil.WriteFile(filepath.Join(src, "docs", "index.html"), []byte("<html></html>"), 0644)
+	path := os.Getenv("PATH")
+	os.Setenv("PATH", bin+string(os.PathListSeparator)+path)
+	return src, logFile, func() {
+		os.Setenv("PATH", path)
+		os.RemoveAll(dir)
+	}
+}
+
+// lines returns the sorted lines of the log, since uploads run in parallel.
+func lines(t *testing.T, logFile string) []string {
+	b, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := strings.Split(strings.TrimSpace(string(b)), "\n")
+	sort.Strings(l)
+	return l
+}
+
+func TestClouds(t *testing.T) {
+	dir, logFile, cleanup := fakeCLIs(t)
+	defer cleanup()
+	archive := filepath.Join(dir, "app.tar.gz")
+
+	if err := Files("s3://bucket/v1/", []string{archive}, Options{CacheControl: "max-age=60"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := Dir(dir, "gs://bucket/site", Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := File(archive, "az://acct/releases/v1/app.tar.gz", Options{ContentType: "application/x-gtar"}); err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{
+		"aws s3 cp --only-show-errors --content-type application/gzip --cache-control max-age=60 " + archive + " s3://bucket/v1/app.tar.gz",
+		"az storage blob upload --only-show-errors --overwrite --account-name acct --container-name releases --name v1/app.tar.gz --file " + archive + " --content-type application/x-gtar",
+		"gcloud storage cp --content-type=application/gzip " + archive + " gs://bucket/site/app.tar.gz",
+		"gcloud storage cp --content-type=text/html; charset=utf-8 " + filepath.Join(dir, "docs", "index.html") + " gs://bucket/site/docs/index.html",
+	}
+	got := lines(t, logFile)
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("expected\n%s\ngot\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+
+	if err := File(archive, "az://acct/releases", Options{}); err == nil || !strings.Contains(err.Error(), "az://account/container/path") {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if err := File(archive, "ftp://host/app.tar.gz", Options{}); err == nil {
+		t.Fatal("expected an error for an ftp URL")
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var mu sync.Mutex
+	got := map[string]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		got[r.URL.Path+"?"+r.URL.RawQuery] = r.Header.Get("Content-Type") + " " + r.Header.Get("Cache-Control") + " " + string(b)
+	}))
+	defer srv.Close()
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	a, b := filepath.Join(dir, "a.json"), filepath.Join(dir, "b")
+	ioutil.WriteFile(a, []byte("{}"), 0644)
+	ioutil.WriteFile(b, []byte("plain text"), 0644)
+
+	if err := Files(srv.URL+"/v1?sig=secret", []string{a, b}, Options{CacheControl: "no-cache", Parallel: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 ||
+		got["/v1/a.json?sig=secret"] != "application/json no-cache {}" ||
+		got["/v1/b?sig=secret"] != "text/plain; charset=utf-8 no-cache plain text" {
+		t.Fatalf("unexpected uploads %q", got)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	dir, logFile, cleanup := fakeCLIs(t)
+	defer cleanup()
+	var out bytes.Buffer
+	err := Dir(dir, "https://example.com/upload?token=secret", Options{DryRun: true, Stdout: &out, CacheControl: "max-age=60"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "would upload " + filepath.Join(dir, "app.tar.gz") + " to https://example.com/upload/app.tar.gz?... (application/gzip, Cache-Control: max-age=60)\n" +
+		"would upload " + filepath.Join(dir, "docs", "index.html") + " to https://example.com/upload/docs/index.html?... (text/html; charset=utf-8, Cache-Control: max-age=60)\n"
+	if out.String() != expected {
+		t.Fatalf("expected\n%s\ngot\n%s", expected, out.String())
+	}
+	if err := Dir(dir, "s3://bucket", Options{DryRun: true, Stdout: &out}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Fatal("expected a dry run not to run the CLIs")
+	}
+}
@@ -0,0 +1,141 @@
+// Package waitfor waits for services to be ready, e.g. for a database that an
+// integration test target started to accept connections.  Each function
+// checks again and again, backing off between attempts, until the service is
+// ready or the timeout passes, when it returns a *TimeoutError with why the
+// last attempt failed.
+package waitfor
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/magefile/mage/clock"
+	"github.com/magefile/mage/mg"
+	"github.com/magefile/mage/sh"
+)
+
+// firstDelay is how long to wait after the first attempt, which doubles after
+// each one up to maxDelay.
+var (
+	firstDelay = 100 * time.Millisecond
+	maxDelay   = 5 * time.Second
+)
+
+// TimeoutError is the error when what was waited for isn't ready in time.
+type TimeoutError struct {
+	// What is what was waited for, e.g. tcp localhost:5432.
+	What string
+	// Timeout is how long it was waited for.
+	Timeout time.Duration
+	// Attempts is how many times it was checked.
+	Attempts int
+	// Err is why the last attempt failed.
+	Err error
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %v waiting for %s (%d attempts): %v", e.Timeout, e.What, e.Attempts, e.Err)
+}
+
+// permanent is an error that waiting longer won't fix, like a command that
+// isn't installed.
+type permanent struct{ error }
+
+// poll calls probe until it succeeds, returns a permanent error, or the
+// timeout passes.  The probe is given how long is left.
+func poll(what string, timeout time.Duration, probe func(left time.Duration) error) error {
+	deadline := clock.Now().Add(timeout)
+	delay := firstDelay
+	for attempt := 1; ; attempt++ {
+		left := clock.Until(deadline)
+		err := probe(left)
+		if err == nil {
+			if mg.Verbose() {
+				log.Printf("%s is ready after %d attempts", what, attempt)
+			}
+			return nil
+		}
+		if p, ok := err.(permanent); ok {
+			return p.error
+		}
+		left = clock.Until(deadline)
+		if left <= 0 {
+			return &TimeoutError{What: what, Timeout: timeout, Attempts: attempt, Err: err}
+		}
+		if mg.Verbose() {
+			log.Printf("waiting for %s: %v", what, err)
+		}
+		if delay > left {
+			delay = left
+		}
+		clock.Sleep(delay)
+		if delay *= 2; delay > maxDelay {
+			delay = maxDelay
+		}
+	}
+}
+
+// TCP waits up to the timeout for something to accept connections at the
+// address, e.g. localhost:5432.
+func TCP(addr string, timeout time.Duration) error {
+	return poll("tcp "+addr, timeout, func(left time.Duration) error {
+		conn, err := net.DialTimeout("tcp", addr, left)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	})
+}
+
+// HTTP waits up to the timeout for a GET of the url to respond with the
+// status, or any 2xx status if it's 0.  Redirects aren't followed, so they
+// can be waited for too.
+func HTTP(url string, status int, timeout time.Duration) error {
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	return poll(url, timeout, func(left time.Duration) error {
+		client.Timeout = left
+		resp, err := client.Get(url)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if status == 0 && resp.StatusCode/100 == 2 || resp.StatusCode == status {
+			return nil
+		}
+		want := "2xx"
+		if status != 0 {
+			want = fmt.Sprint(status)
+		}
+		return fmt.Errorf("got status %s, want %s", resp.Status, want)
+	})
+}
+
+// Cmd waits up to the timeout for the command to succeed, e.g. pg_isready.
+// If the command can't be run at all, it returns that error right away.
+func Cmd(timeout time.Duration, cmd string, args ...string) error {
+	what := strings.Join(append([]string{cmd}, args...), " ")
+	return poll(what, timeout, func(time.Duration) error {
+		stderr := &bytes.Buffer{}
+		// Exec expands the args in place, so each attempt gets a copy.
+		ran, err := sh.Exec(nil, nil, stderr, cmd, append([]string(nil), args...)...)
+		if !ran {
+			return permanent{err}
+		}
+		if err != nil {
+			if msg := strings.TrimSpace(stderr.String()); msg != "" {
+				return fmt.Errorf("exit code %d: %s", sh.ExitStatus(err), msg)
+			}
+			return fmt.Errorf("exit code %d", sh.ExitStatus(err))
+		}
+		return nil
+	})
+}
@@ -0,0 +1,135 @@
+package waitfor
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/magefile/mage/clock"
+)
+
+func init() {
+	firstDelay = time.Millisecond
+	maxDelay = 10 * time.Millisecond
+}
+
+func TestTCP(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	if err := TCP(addr, time.Second); err != nil {
+		t.Fatalf("expected the listener to be ready but got %v", err)
+	}
+	l.Close()
+
+	err = TCP(addr, 50*time.Millisecond)
+	e, ok := err.(*TimeoutError)
+	if !ok {
+		t.Fatalf("expected a timeout but got %v", err)
+	}
+	if e.What != "tcp "+addr || e.Timeout != 50*time.Millisecond || e.Attempts < 2 || e.Err == nil {
+		t.Fatalf("unexpected timeout %+v", e)
+	}
+	if !strings.HasPrefix(e.Error(), "timed out after 50ms waiting for tcp "+addr+" (") {
+		t.Fatalf("unexpected message %q", e.Error())
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/moved":
+			http.Redirect(w, r, "/healthz", http.StatusFound)
+		case atomic.AddInt32(&requests, 1) < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	if err := HTTP(srv.URL+"/healthz", 0, time.Second); err != nil {
+		t.Fatalf("expected the server to be ready but got %v", err)
+	}
+	if n := atomic.LoadInt32(&requests); n != 3 {
+		t.Fatalf("expected 3 requests but got %d", n)
+	}
+	if err := HTTP(srv.URL+"/moved", http.StatusFound, time.Second); err != nil {
+		t.Fatalf("expected the redirect not to be followed but got %v", err)
+	}
+	err := HTTP(srv.URL+"/healthz", http.StatusOK, 30*time.Millisecond)
+	e, ok := err.(*TimeoutError)
+	if !ok || e.Err.Error() != "got status 204 No Content, want 200" {
+		t.Fatalf("expected a timeout for the status but got %v", err)
+	}
+}
+
+func TestCmd(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sh as the probe")
+	}
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	count := filepath.Join(dir, "count")
+
+	// fails twice, then succeeds.
+	script := `echo >> "$COUNT"; [ $(wc -l < "$COUNT") -ge 3 ] || { echo not ready >&2; exit 2; }`
+	os.Setenv("COUNT", count)
+	defer os.Unsetenv("COUNT")
+	if err := Cmd(time.Second, "sh", "-c", script); err != nil {
+		t.Fatalf("expected the command to succeed but got %v", err)
+	}
+	if b, _ := ioutil.ReadFile(count); len(b) != 3 {
+		t.Fatalf("expected 3 attempts but got %d", len(b))
+	}
+
+	err = Cmd(30*time.Millisecond, "sh", "-c", "echo not ready >&2; exit 2")
+	e, ok := err.(*TimeoutError)
+	if !ok || e.What != "sh -c echo not ready >&2; exit 2" || e.Err.Error() != "exit code 2: not ready" {
+		t.Fatalf("expected a timeout with the command's error but got %v", err)
+	}
+
+	start := time.Now()
+	err = Cmd(time.Minute, "no-such-probe")
+	if _, ok := err.(*TimeoutError); err == nil || ok || time.Since(start) > 10*time.Second {
+		t.Fatalf("expected a missing command to fail right away but got %v", err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+	fake := clock.NewFake(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	clock.Default = fake
+	defer func() { clock.Default = clock.Real{} }()
+
+	// the delays double up to maxDelay, and the last is cut short by the
+	// timeout.
+	err := HTTP(srv.URL, 0, 50*time.Millisecond)
+	e, ok := err.(*TimeoutError)
+	if !ok || e.Attempts != 9 {
+		t.Fatalf("expected a timeout after 9 attempts but got %v", err)
+	}
+	ms := time.Millisecond
+	expected := []time.Duration{ms, 2 * ms, 4 * ms, 8 * ms, 10 * ms, 10 * ms, 10 * ms, 5 * ms}
+	if s := fake.Sleeps(); !reflect.DeepEqual(s, expected) {
+		t.Fatalf("expected to wait %v but waited %v", expected, s)
+	}
+}